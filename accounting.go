@@ -0,0 +1,116 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	runtimemetrics "runtime/metrics"
+	"sync/atomic"
+)
+
+// Stages of hashing a file, as reported to MemoryMetrics.
+const (
+	StageOpen   = "open"   // Opening the file, and reading it in full with BatchOptions.Readers.
+	StageDecode = "decode" // Decoding the image, and reading the file without Readers.
+	StageHash   = "hash"   // Running the HashFunc, filters included.
+)
+
+// MemoryMetrics is implemented by Metrics which also take the memory
+// accounting of batches run with BatchOptions.Accounting. Pass one to
+// SetMetrics; Metrics without these methods only get the events of
+// Metrics.
+//
+// Allocations are counted for the process as a whole, as the Go runtime
+// counts them. With more than one worker, the figures of a stage include
+// whatever the other workers allocated while it ran. Run a sample of the
+// files with a single worker for figures per file, and the full batch
+// with all workers for its peak.
+type MemoryMetrics interface {
+	Metrics
+
+	// StageAllocated is called after every stage of hashing a file,
+	// with the number of bytes and of objects allocated while it ran.
+	StageAllocated(stage string, bytes, objects uint64)
+
+	// MemoryPeaked is called whenever the memory the Go runtime holds
+	// exceeds the largest amount seen by the batch before. It is
+	// sampled as each stage ends, and estimates the peak resident size
+	// of the process, less what is mapped by other means, like cgo.
+	MemoryPeaked(bytes uint64)
+}
+
+// accountingKey is the context key of the accountant of a batch.
+type accountingKey struct{}
+
+// An accountant measures the memory used by the stages of a batch.
+type accountant struct {
+	peak uint64
+}
+
+// A memSample holds the allocation counters at the start of a stage.
+type memSample struct {
+	bytes, objects uint64
+}
+
+// withAccountant returns ctx, carrying a new accountant.
+func withAccountant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, accountingKey{}, &accountant{})
+}
+
+// accountantOf returns the accountant in ctx, or nil if there is none.
+func accountantOf(ctx context.Context) *accountant {
+	a, _ := ctx.Value(accountingKey{}).(*accountant)
+	return a
+}
+
+// readMemory reads the allocation counters, and the memory held.
+func readMemory() (bytes, objects, total uint64) {
+	s := []runtimemetrics.Sample{
+		{Name: "/gc/heap/allocs:bytes"},
+		{Name: "/gc/heap/allocs:objects"},
+		{Name: "/memory/classes/total:bytes"},
+	}
+
+	runtimemetrics.Read(s)
+	return s[0].Value.Uint64(), s[1].Value.Uint64(), s[2].Value.Uint64()
+}
+
+// begin samples the counters at the start of a stage. A nil accountant
+// measures nothing.
+func (a *accountant) begin() memSample {
+	if a == nil {
+		return memSample{}
+	}
+
+	bytes, objects, _ := readMemory()
+	return memSample{bytes, objects}
+}
+
+// end reports the memory used by the stage begun with s.
+func (a *accountant) end(stage string, s memSample) {
+	if a == nil {
+		return
+	}
+
+	bytes, objects, total := readMemory()
+
+	m, ok := currentMetrics().(MemoryMetrics)
+	if !ok {
+		return
+	}
+
+	m.StageAllocated(stage, bytes-s.bytes, objects-s.objects)
+
+	for {
+		peak := atomic.LoadUint64(&a.peak)
+		if total <= peak {
+			break
+		}
+
+		if atomic.CompareAndSwapUint64(&a.peak, peak, total) {
+			m.MemoryPeaked(total)
+			break
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+)
+
+// Composite returns a Filter which draws the image over an opaque
+// background of the given colour. Any alpha in bg itself is ignored.
+//
+// This is the recommended way of hashing transparent images. Fully
+// transparent pixels all end up with the background colour, regardless
+// of what their colour channels held in the source file. Without it,
+// transparent PNG logos hash differently depending on the encoder that
+// produced them.
+func Composite(bg color.Color) Filter {
+	c := color.NRGBA64Model.Convert(bg).(color.NRGBA64)
+	cr, cg, cb := uint32(c.R), uint32(c.G), uint32(c.B)
+
+	return func(img image.Image) image.Image {
+		img = copyable(img)
+		rect := img.Bounds()
+		out := image.NewRGBA64(rect)
+
+		var x, y int
+		var r, g, b, a, ia uint32
+
+		for y = rect.Min.Y; y < rect.Max.Y; y++ {
+			for x = rect.Min.X; x < rect.Max.X; x++ {
+				// RGBA() is alpha-premultiplied, so we only need
+				// to add the visible part of the background.
+				r, g, b, a = img.At(x, y).RGBA()
+				ia = 0xffff - a
+
+				out.SetRGBA64(x, y, color.RGBA64{
+					R: uint16(r + cr*ia/0xffff),
+					G: uint16(g + cg*ia/0xffff),
+					B: uint16(b + cb*ia/0xffff),
+					A: 0xffff,
+				})
+			}
+		}
+
+		return out
+	}
+}
+
+// IgnoreAlpha is a Filter which drops the alpha channel altogether.
+// Each pixel keeps its un-premultiplied colour and becomes fully opaque.
+//
+// Use this when the colour channels of transparent pixels are known
+// to carry meaningful data. In all other cases, Composite is the
+// safer choice.
+func IgnoreAlpha(img image.Image) image.Image {
+	img = copyable(img)
+	rect := img.Bounds()
+	out := image.NewNRGBA64(rect)
+
+	var x, y int
+	var c color.NRGBA64
+
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			c = unpremultiplied(img.At(x, y))
+			c.A = 0xffff
+			out.SetNRGBA64(x, y, c)
+		}
+	}
+
+	return out
+}
+
+// unpremultiplied returns the non-alpha-premultiplied version of c.
+// Colours which are stored that way to begin with are returned as-is,
+// because the conversion through c.RGBA() would lose the colour of
+// fully transparent pixels.
+func unpremultiplied(c color.Color) color.NRGBA64 {
+	switch c := c.(type) {
+	case color.NRGBA:
+		return color.NRGBA64{
+			R: uint16(c.R) * 0x101,
+			G: uint16(c.G) * 0x101,
+			B: uint16(c.B) * 0x101,
+			A: uint16(c.A) * 0x101,
+		}
+	case color.NRGBA64:
+		return c
+	}
+
+	return color.NRGBA64Model.Convert(c).(color.NRGBA64)
+}
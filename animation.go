@@ -0,0 +1,275 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultFrameDelay is used for frames without a delay. Browsers
+// display those at roughly this rate as well.
+const defaultFrameDelay = 100 * time.Millisecond
+
+// temporalSamples is the number of points in time at which two
+// animations are compared by AnimationDistance.
+const temporalSamples = 32
+
+// A Frame is a single frame of an animation or video.
+type Frame struct {
+	Image    image.Image   // Fully composited frame.
+	Time     time.Duration // Offset of the frame from the start.
+	Duration time.Duration // Time for which the frame is displayed.
+}
+
+// FrameHash holds the hash of a single frame.
+type FrameHash struct {
+	Hash     uint64        // Perceptual Image hash.
+	Time     time.Duration // Offset of the frame from the start.
+	Duration time.Duration // Time for which the frame is displayed.
+}
+
+// AnimationHash describes an animation by the hashes of its frames.
+type AnimationHash struct {
+	Frames []FrameHash // Per-frame hashes, in display order.
+
+	// Temporal is a single hash for the whole animation. Each bit
+	// holds the value which is set for the majority of the running
+	// time. It is comparable with Distance, like any other hash, and
+	// suits quick lookups in an Index.
+	Temporal uint64
+}
+
+// Duration returns the total running time of the animation.
+func (a *AnimationHash) Duration() time.Duration {
+	if len(a.Frames) == 0 {
+		return 0
+	}
+
+	last := a.Frames[len(a.Frames)-1]
+	return last.Time + last.Duration
+}
+
+// at returns the hash of the frame displayed at the given position,
+// expressed as a fraction of the running time.
+func (a *AnimationHash) at(pos float64) uint64 {
+	total := a.Duration()
+	if total <= 0 {
+		return a.Frames[int(pos*float64(len(a.Frames)))].Hash
+	}
+
+	t := time.Duration(pos * float64(total))
+	i := sort.Search(len(a.Frames), func(i int) bool {
+		return a.Frames[i].Time > t
+	})
+
+	if i > 0 {
+		i--
+	}
+
+	return a.Frames[i].Hash
+}
+
+// DecodeAnimation decodes all frames of an animated GIF or PNG. Each
+// frame is composited onto the previous ones, the way a browser would
+// display it. Still images yield a single frame.
+//
+// Like Decode, embedded ICC profiles are applied to every frame. The
+// frames are limited to MaxPixels pixels in total.
+func DecodeAnimation(r io.Reader) ([]*Frame, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []*Frame
+
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		frames, err = decodeGIF(data)
+	default:
+		frames, err = decodeAPNG(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if frames == nil {
+		img, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Frame{{Image: img, Duration: defaultFrameDelay}}, nil
+	}
+
+	if p := profileConverter(data); p != nil {
+		for _, f := range frames {
+			f.Image = p.convert(f.Image)
+		}
+	}
+
+	return frames, nil
+}
+
+// DecodeAnimationFile decodes all frames in the given file.
+// Refer to DecodeAnimation for details.
+func DecodeAnimationFile(file string) ([]*Frame, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return DecodeAnimation(fd)
+}
+
+// decodeGIF decodes and composites all frames of a GIF.
+func decodeGIF(data []byte) ([]*Frame, error) {
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPixels(cfg.Width, cfg.Height, 1); err != nil {
+		return nil, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+
+	if err := checkPixels(bounds.Dx(), bounds.Dy(), len(g.Image)); err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]*Frame, 0, len(g.Image))
+
+	var elapsed time.Duration
+
+	for i, img := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		delay := defaultFrameDelay
+		if i < len(g.Delay) && g.Delay[i] > 0 {
+			delay = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+
+		frames = append(frames, &Frame{Image: cloneRGBA(canvas), Time: elapsed, Duration: delay})
+		elapsed += delay
+
+		switch disposal {
+		case gif.DisposalPrevious:
+			canvas = previous
+		case gif.DisposalBackground:
+			draw.Draw(canvas, img.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	return frames, nil
+}
+
+// HashAnimation computes the hashes of the given frames, using the
+// given HashFunc.
+func HashAnimation(frames []*Frame, hf HashFunc) *AnimationHash {
+	a := &AnimationHash{Frames: make([]FrameHash, len(frames))}
+
+	var weights [64]time.Duration
+	var total time.Duration
+	var i, bit int
+	var hash uint64
+
+	for i = range frames {
+		hash = hf(frames[i].Image)
+
+		a.Frames[i] = FrameHash{
+			Hash:     hash,
+			Time:     frames[i].Time,
+			Duration: frames[i].Duration,
+		}
+
+		// Frames without a duration still count.
+		d := frames[i].Duration
+		if d <= 0 {
+			d = 1
+		}
+
+		total += d
+		for bit = 0; bit < 64; bit++ {
+			if hash&(1<<uint(bit)) != 0 {
+				weights[bit] += d
+			}
+		}
+	}
+
+	for bit = 0; bit < 64; bit++ {
+		if weights[bit]*2 > total {
+			a.Temporal |= 1 << uint(bit)
+		}
+	}
+
+	return a
+}
+
+// AnimationDistance returns the mean Hamming Distance between two
+// animations. They are compared at fixed points relative to their
+// running time, rather than frame by frame. This makes the distance
+// insensitive to changes in frame rate and playback speed: the same
+// clip re-encoded at half the frame rate still has a distance of 0.
+func AnimationDistance(a, b *AnimationHash) float64 {
+	if len(a.Frames) == 0 || len(b.Frames) == 0 {
+		return 64
+	}
+
+	var sum uint64
+	var pos float64
+
+	for i := 0; i < temporalSamples; i++ {
+		pos = (float64(i) + 0.5) / temporalSamples
+		sum += Distance(a.at(pos), b.at(pos))
+	}
+
+	return float64(sum) / temporalSamples
+}
+
+// ComputeAnimation decodes the animation in r and computes its hashes
+// using the given HashFunc.
+func ComputeAnimation(r io.Reader, hf HashFunc) (*AnimationHash, error) {
+	start := time.Now()
+	m := currentMetrics()
+
+	frames, err := DecodeAnimation(r)
+	if err != nil {
+		m.DecodeFailed(err)
+		return nil, err
+	}
+
+	a := HashAnimation(frames, hf)
+	m.ImageHashed(time.Since(start))
+	return a, nil
+}
@@ -0,0 +1,178 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestAnimation(t *testing.T) {
+	gopher, err := loadImg("testdata/gopher_large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	card := image.NewUniform(color.White)
+	checker := checkerboard(gopher.Bounds(), gopher.Bounds().Dx()/4)
+	clip := []image.Image{card, gopher, checker}
+
+	hf := Preprocess(Average, Composite(color.White))
+	a := decodeTestAnimation(t, makeGIF(t, clip, gopher.Bounds(), 1, 50))
+
+	if len(a.Frames) != 3 || a.Frames[2].Time != time.Second || a.Duration() != 1500*time.Millisecond {
+		t.Fatalf("unexpected frame timing: %+v", a.Frames)
+	}
+
+	// The same clip at twice the frame rate.
+	b := decodeTestAnimation(t, makeGIF(t, clip, gopher.Bounds(), 2, 25))
+	if d := AnimationDistance(a, b); d != 0 {
+		t.Fatalf("frame rate change: distance %.2f", d)
+	}
+
+	if a.Temporal != b.Temporal {
+		t.Fatalf("temporal hash mismatch: %016x %016x", a.Temporal, b.Temporal)
+	}
+
+	// The same frames in a different order.
+	reversed := []image.Image{checker, gopher, card}
+	c := decodeTestAnimation(t, makeGIF(t, reversed, gopher.Bounds(), 1, 50))
+	if d := AnimationDistance(a, c); d <= MaxDistance {
+		t.Fatalf("reordered clip: distance %.2f", d)
+	}
+
+	// The same clip as an APNG.
+	frames, err := DecodeAnimation(bytes.NewReader(makeAPNG(t, clip, gopher.Bounds(), 50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := HashAnimation(frames, hf)
+	if len(d.Frames) != 3 || d.Duration() != a.Duration() {
+		t.Fatalf("unexpected APNG frames: %+v", d.Frames)
+	}
+
+	if dist := AnimationDistance(a, d); dist > MaxDistance {
+		t.Fatalf("APNG mismatch: distance %.2f", dist)
+	}
+}
+
+// decodeTestAnimation decodes and hashes the given animation.
+func decodeTestAnimation(t *testing.T, data []byte) *AnimationHash {
+	a, err := ComputeAnimation(bytes.NewReader(data), Preprocess(Average, Composite(color.White)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+// checkerboard returns a black and white checkerboard.
+func checkerboard(rect image.Rectangle, size int) image.Image {
+	img := image.NewGray(rect)
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if (x/size+y/size)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 0xff})
+			}
+		}
+	}
+
+	return img
+}
+
+// makeGIF encodes the given frames as a GIF. Each frame is repeated
+// the given number of times, with the given delay in 100ths of a second.
+func makeGIF(t testing.TB, frames []image.Image, rect image.Rectangle, repeat, delay int) []byte {
+	g := new(gif.GIF)
+
+	for _, f := range frames {
+		img := image.NewRGBA(rect)
+		draw.Draw(img, rect, image.White, image.Point{}, draw.Src)
+		draw.Draw(img, rect, f, rect.Min, draw.Over)
+
+		p := image.NewPaletted(rect, palette.Plan9)
+		draw.FloydSteinberg.Draw(p, rect, img, rect.Min)
+
+		for i := 0; i < repeat; i++ {
+			g.Image = append(g.Image, p)
+			g.Delay = append(g.Delay, delay)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// makeAPNG encodes the given frames as an animated PNG,
+// with the given delay in 100ths of a second.
+func makeAPNG(t testing.TB, frames []image.Image, rect image.Rectangle, delay int) []byte {
+	var out bytes.Buffer
+	var seq uint32
+
+	u32 := func(v ...uint32) []byte {
+		b := make([]byte, 4*len(v))
+		for i := range v {
+			binary.BigEndian.PutUint32(b[i*4:], v[i])
+		}
+		return b
+	}
+
+	out.WriteString("\x89PNG\r\n\x1a\n")
+
+	for i, f := range frames {
+		img := image.NewRGBA(rect)
+		draw.Draw(img, rect, image.White, image.Point{}, draw.Src)
+		draw.Draw(img, rect, f, rect.Min, draw.Over)
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()[8:]
+		for len(data) >= 12 {
+			size := int(binary.BigEndian.Uint32(data))
+			kind := string(data[4:8])
+			chunk := data[8 : 8+size]
+			data = data[size+12:]
+
+			switch {
+			case kind == "IHDR" && i == 0:
+				writeChunk(&out, kind, chunk)
+				writeChunk(&out, "acTL", u32(uint32(len(frames)), 0))
+
+				fallthrough
+			case kind == "IHDR":
+				fc := u32(seq, uint32(rect.Dx()), uint32(rect.Dy()), 0, 0)
+				fc = append(fc, 0, byte(delay), 0, 100, apngDisposeNone, apngBlendSource)
+				writeChunk(&out, "fcTL", fc)
+				seq++
+
+			case kind == "IDAT" && i == 0:
+				writeChunk(&out, kind, chunk)
+
+			case kind == "IDAT":
+				writeChunk(&out, "fdAT", append(u32(seq), chunk...))
+				seq++
+			}
+		}
+	}
+
+	writeChunk(&out, "IEND", nil)
+	return out.Bytes()
+}
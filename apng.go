@@ -0,0 +1,238 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"time"
+)
+
+// errInvalidAPNG is returned for APNG files with malformed
+// animation chunks.
+var errInvalidAPNG = errors.New("imghash: invalid APNG animation")
+
+// APNG frame disposal and blend operations.
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+)
+
+// apngFrame holds the control data and encoded image data of a
+// single APNG frame.
+type apngFrame struct {
+	rect     image.Rectangle
+	delay    time.Duration
+	dispose  byte
+	blend    byte
+	segments [][]byte
+}
+
+// decodeAPNG decodes all frames of an animated PNG. The standard
+// library only decodes the default image, so every frame is turned into
+// a standalone PNG stream and decoded separately.
+//
+// It returns nil if the data is not an animated PNG.
+func decodeAPNG(data []byte) ([]*Frame, error) {
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		return nil, nil
+	}
+
+	var ihdr []byte
+	var header [][]byte
+	var frames []*apngFrame
+	var animated, seenIDAT bool
+
+	data = data[8:]
+	for len(data) >= 12 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 0 || size+12 > len(data) {
+			return nil, errInvalidAPNG
+		}
+
+		kind := string(data[4:8])
+		chunk := data[8 : 8+size]
+		raw := data[:size+12]
+		data = data[size+12:]
+
+		switch kind {
+		case "IHDR":
+			if size != 13 {
+				return nil, errInvalidAPNG
+			}
+			ihdr = chunk
+
+		case "acTL":
+			animated = true
+
+		case "fcTL":
+			if size != 26 {
+				return nil, errInvalidAPNG
+			}
+
+			x := int(binary.BigEndian.Uint32(chunk[12:]))
+			y := int(binary.BigEndian.Uint32(chunk[16:]))
+			w := int(binary.BigEndian.Uint32(chunk[4:]))
+			h := int(binary.BigEndian.Uint32(chunk[8:]))
+			num := binary.BigEndian.Uint16(chunk[20:])
+			den := binary.BigEndian.Uint16(chunk[22:])
+
+			if den == 0 {
+				den = 100
+			}
+
+			frames = append(frames, &apngFrame{
+				rect:    image.Rect(x, y, x+w, y+h),
+				delay:   time.Duration(num) * time.Second / time.Duration(den),
+				dispose: chunk[24],
+				blend:   chunk[25],
+			})
+
+		case "IDAT":
+			// The default image is only part of the animation
+			// if a frame control chunk precedes it.
+			seenIDAT = true
+			if len(frames) > 0 {
+				f := frames[len(frames)-1]
+				f.segments = append(f.segments, chunk)
+			}
+
+		case "fdAT":
+			if size < 4 || len(frames) == 0 {
+				return nil, errInvalidAPNG
+			}
+
+			f := frames[len(frames)-1]
+			f.segments = append(f.segments, chunk[4:])
+
+		case "IEND":
+			data = nil
+
+		default:
+			// Keep chunks like PLTE and tRNS, which every
+			// frame needs to decode.
+			if !seenIDAT {
+				header = append(header, raw)
+			}
+		}
+	}
+
+	if !animated || len(frames) == 0 {
+		return nil, nil
+	}
+
+	if ihdr == nil {
+		return nil, errInvalidAPNG
+	}
+
+	width := int(binary.BigEndian.Uint32(ihdr))
+	height := int(binary.BigEndian.Uint32(ihdr[4:]))
+	bounds := image.Rect(0, 0, width, height)
+
+	if err := checkPixels(width, height, len(frames)); err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewRGBA(bounds)
+	out := make([]*Frame, 0, len(frames))
+
+	var elapsed time.Duration
+
+	for i, f := range frames {
+		if !f.rect.In(bounds) || f.rect.Empty() {
+			return nil, errInvalidAPNG
+		}
+
+		img, err := png.Decode(bytes.NewReader(apngStream(ihdr, header, f)))
+		if err != nil {
+			return nil, err
+		}
+
+		var previous *image.RGBA
+		if f.dispose == apngDisposePrevious && i > 0 {
+			previous = cloneRGBA(canvas)
+		}
+
+		op := draw.Over
+		if f.blend == apngBlendSource {
+			op = draw.Src
+		}
+
+		draw.Draw(canvas, f.rect, img, img.Bounds().Min, op)
+
+		delay := f.delay
+		if delay <= 0 {
+			delay = defaultFrameDelay
+		}
+
+		out = append(out, &Frame{Image: cloneRGBA(canvas), Time: elapsed, Duration: delay})
+		elapsed += delay
+
+		switch {
+		case previous != nil:
+			canvas = previous
+		case f.dispose != apngDisposeNone:
+			// Disposing the first frame to the previous
+			// state is the same as clearing it.
+			draw.Draw(canvas, f.rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	return out, nil
+}
+
+// apngStream builds a standalone PNG stream for the given frame.
+func apngStream(ihdr []byte, header [][]byte, f *apngFrame) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+
+	hdr := make([]byte, len(ihdr))
+	copy(hdr, ihdr)
+	binary.BigEndian.PutUint32(hdr, uint32(f.rect.Dx()))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(f.rect.Dy()))
+	writeChunk(&buf, "IHDR", hdr)
+
+	for _, raw := range header {
+		buf.Write(raw)
+	}
+
+	for _, seg := range f.segments {
+		writeChunk(&buf, "IDAT", seg)
+	}
+
+	writeChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+// writeChunk writes a single PNG chunk, including its checksum.
+func writeChunk(buf *bytes.Buffer, kind string, data []byte) {
+	var tmp [4]byte
+
+	binary.BigEndian.PutUint32(tmp[:], uint32(len(data)))
+	buf.Write(tmp[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+
+	buf.WriteString(kind)
+	buf.Write(data)
+
+	binary.BigEndian.PutUint32(tmp[:], crc.Sum32())
+	buf.Write(tmp[:])
+}
+
+// cloneRGBA returns a copy of img.
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	out := image.NewRGBA(img.Rect)
+	copy(out.Pix, img.Pix)
+	return out
+}
@@ -0,0 +1,261 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Kinds of archive HashFS can walk.
+const (
+	archiveNone = iota
+	archiveZip
+	archiveTar
+	archiveTarGz
+)
+
+// archiveKind returns the kind of archive the named file is,
+// judged by its extension.
+func archiveKind(file string) int {
+	name := strings.ToLower(file)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	}
+	return archiveNone
+}
+
+// An archive tracks the entries of an archive which are being hashed.
+type archive struct {
+	slots chan struct{} // One for each entry in flight.
+	wg    sync.WaitGroup
+}
+
+// newArchive returns an archive which allows as many entries in
+// flight as the ArchiveWorkers option.
+func (b *batch) newArchive() *archive {
+	n := b.opts.ArchiveWorkers
+	if n < 1 {
+		n = 4
+	}
+
+	return &archive{slots: make(chan struct{}, n)}
+}
+
+// acquire blocks until another entry may be read. It returns false
+// if the batch is done first.
+func (a *archive) acquire(b *batch) bool {
+	select {
+	case a.slots <- struct{}{}:
+		a.wg.Add(1)
+		return true
+	case <-b.ctx.Done():
+		return false
+	}
+}
+
+// release ends an entry started with acquire.
+func (a *archive) release() {
+	<-a.slots
+	a.wg.Done()
+}
+
+// send sends j, which holds a slot, on jobs. The slot is released
+// once j is hashed or dropped. It returns false if the batch is done.
+func (a *archive) send(b *batch, jobs chan<- job, j job) bool {
+	j.done = a.release
+
+	select {
+	case jobs <- j:
+		return true
+	case <-b.ctx.Done():
+		j.finish()
+		return false
+	}
+}
+
+// walkArchive sends a job for every image in the archive at file in
+// fsys. A damaged archive yields a job for the archive itself, which
+// fails with the error. It returns false if the batch is done.
+func (b *batch) walkArchive(fsys fs.FS, file string, kind int, jobs chan<- job) bool {
+	fd, err := fsys.Open(file)
+	if err == nil && kind == archiveZip {
+		return b.walkZip(fd, file, jobs)
+	}
+
+	if err == nil {
+		err = b.walkTar(fd, file, kind, jobs)
+		fd.Close()
+	}
+
+	if err != nil && b.ctx.Err() == nil {
+		select {
+		case jobs <- job{path: file, err: err}:
+		case <-b.ctx.Done():
+		}
+	}
+
+	return b.ctx.Err() == nil
+}
+
+// walkZip sends a job for every image in the zip archive fd. The
+// entries are read in place if fd can be read at random, as files of
+// os.DirFS can; otherwise the archive is read into memory first. Fd is
+// closed once all entries are hashed.
+func (b *batch) walkZip(fd fs.File, file string, jobs chan<- job) bool {
+	zr, err := openZip(fd)
+	if err != nil {
+		fd.Close()
+
+		select {
+		case jobs <- job{path: file, err: err}:
+			return true
+		case <-b.ctx.Done():
+			return false
+		}
+	}
+
+	a := b.newArchive()
+	open := zr.Open
+	if b.limit != nil {
+		open = b.limit.open(open)
+	}
+
+	fs.WalkDir(zr, ".", func(name string, d fs.DirEntry, err error) error {
+		if b.ctx.Err() != nil {
+			return fs.SkipAll
+		}
+
+		if err == nil && (d.IsDir() || !b.accept(zr, name, d)) {
+			return nil
+		}
+
+		if !a.acquire(b) {
+			return fs.SkipAll
+		}
+
+		j := job{path: path.Join(file, name), err: err}
+		j.open = func(string) (fs.File, error) { return open(name) }
+
+		if !a.send(b, jobs, j) {
+			return fs.SkipAll
+		}
+
+		return nil
+	})
+
+	go func() {
+		a.wg.Wait()
+		fd.Close()
+	}()
+
+	return b.ctx.Err() == nil
+}
+
+// openZip returns a reader for the zip archive fd.
+func openZip(fd fs.File) (*zip.Reader, error) {
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	ra, ok := fd.(io.ReaderAt)
+	size := stat.Size()
+
+	if !ok {
+		data, err := io.ReadAll(fd)
+		if err != nil {
+			return nil, err
+		}
+
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	return zip.NewReader(ra, size)
+}
+
+// walkTar sends a job for every image in the tar archive fd, which is
+// read from start to end, through gzip if kind is archiveTarGz. Each
+// entry sent is read into memory, which the limit of entries in flight
+// bounds.
+func (b *batch) walkTar(fd fs.File, file string, kind int, jobs chan<- job) error {
+	var r io.Reader = fd
+	if b.limit != nil {
+		r = b.limit.reader(r)
+	}
+
+	if kind == archiveTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+		r = gz
+	}
+
+	a := b.newArchive()
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Names may hold ".." or start at "/"; keep them within the archive.
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if hdr.Typeflag != tar.TypeReg || len(name) == 0 {
+			continue
+		}
+
+		if !a.acquire(b) {
+			return nil
+		}
+
+		var data []byte
+		var rerr error
+		read := func() bool {
+			if data == nil && rerr == nil {
+				data, rerr = io.ReadAll(tr)
+			}
+			return rerr == nil
+		}
+
+		stat := hdr.FileInfo()
+		if !b.match(name, fs.FileInfoToDirEntry(stat), func() bool { return read() && sniffReader(bytes.NewReader(data)) }) {
+			a.release()
+			continue
+		}
+
+		read()
+		j := job{path: path.Join(file, name), err: rerr}
+		j.open = func(string) (fs.File, error) { return &memFile{bytes.NewReader(data), stat, nil}, nil }
+
+		if !a.send(b, jobs, j) {
+			return nil
+		}
+
+		if rerr != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// The hashers in this package squash every image into a square grid.
+// By default, the aspect ratio is simply ignored: the image is stretched
+// to fit. This makes the hash indifferent to images being resized
+// without preserving their aspect ratio, but crops change it a great deal.
+//
+// The filters below provide two alternative ways of getting from a
+// rectangle to a square:
+//
+//   - CenterCrop cuts the largest possible centred square from the
+//     image. The edges of the image do not affect the hash at all, so
+//     added borders or crops along the long side are tolerated. Content
+//     outside of the square is lost, and stretched copies no longer match.
+//   - PadSquare centres the image on a square background. The whole
+//     image, with its aspect ratio intact, ends up in the hash. This is
+//     what some other libraries do, so it helps when comparing hashes
+//     with theirs. It is the least tolerant of crops.
+
+// CenterCrop is a Filter which crops the image to the largest
+// square which fits in its centre.
+func CenterCrop(img image.Image) image.Image {
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+
+	switch {
+	case w > h:
+		rect.Min.X += (w - h) / 2
+		rect.Max.X = rect.Min.X + h
+	case h > w:
+		rect.Min.Y += (h - w) / 2
+		rect.Max.Y = rect.Min.Y + w
+	default:
+		return img
+	}
+
+	return Region(img, rect)
+}
+
+// PadSquare returns a Filter which centres the image on a square
+// background of the given colour, large enough to hold the image.
+func PadSquare(bg color.Color) Filter {
+	return func(img image.Image) image.Image {
+		rect := img.Bounds()
+		w, h := rect.Dx(), rect.Dy()
+
+		if w == h {
+			return img
+		}
+
+		size := w
+		if h > size {
+			size = h
+		}
+
+		out := image.NewRGBA64(image.Rect(0, 0, size, size))
+		draw.Draw(out, out.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+		at := image.Pt((size-w)/2, (size-h)/2)
+		draw.Draw(out, image.Rectangle{at, at.Add(rect.Size())}, img, rect.Min, draw.Over)
+		return out
+	}
+}
@@ -0,0 +1,237 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math"
+	"sort"
+)
+
+// A Pairing pairs an item of one set of hashes with one of another.
+type Pairing struct {
+	A, B     int    // Positions of the items in their sets.
+	Distance uint64 // Hamming Distance between their hashes.
+}
+
+// An Assignment pairs the items of two sets of hashes one to one.
+type Assignment struct {
+	Pairs      []Pairing // Sorted by A.
+	UnmatchedA []int     // Items of the first set without a pair, in order.
+	UnmatchedB []int     // Items of the second set without a pair, in order.
+	Total      uint64    // Sum of the distances of the pairs.
+}
+
+// Assign pairs the hashes of a with those of b, one to one, as is needed
+// to check a set of images against the one expected, such as the frames
+// of a render. Pairing each with its nearest neighbour does not do: two
+// frames may have the same nearest image, and leave over an image which
+// only pairs with one of them.
+//
+// Only items within maxDistance of each other are paired. Of all the
+// ways to pair them, Assign finds one with the most pairs, and of those,
+// the one with the least total distance. Items of either set which are
+// not paired are listed as unmatched.
+//
+// The assignment is optimal, as found by the Hungarian algorithm. That
+// takes cubic time in the size of a group of items within reach of each
+// other, but sets of distinct images fall apart into many small groups,
+// which are solved one by one.
+func Assign(a, b []uint64, maxDistance uint64) *Assignment {
+	// Find the items of b within reach of each of a, and join them into
+	// groups which can be solved apart.
+	x := NewKeyedIndex[int]()
+	for j, h := range b {
+		x.Add(j, h)
+	}
+
+	parent := make([]int, len(a)+len(b))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	edges := make([][]KeyedHit[int], len(a))
+	for i, h := range a {
+		edges[i] = x.Search(h, maxDistance)
+		for _, hit := range edges[i] {
+			parent[find(i)] = find(len(a) + hit.Key)
+		}
+	}
+
+	groups := make(map[int]*assignGroup)
+	var order []*assignGroup
+
+	group := func(i int) *assignGroup {
+		root := find(i)
+		g, ok := groups[root]
+		if !ok {
+			g = &assignGroup{}
+			groups[root] = g
+			order = append(order, g)
+		}
+		return g
+	}
+
+	for i := range a {
+		g := group(i)
+		g.a = append(g.a, i)
+	}
+	for j := range b {
+		g := group(len(a) + j)
+		g.b = append(g.b, j)
+	}
+
+	out := &Assignment{}
+	for _, g := range order {
+		g.solve(a, b, edges, maxDistance, out)
+	}
+
+	sort.Slice(out.Pairs, func(i, j int) bool { return out.Pairs[i].A < out.Pairs[j].A })
+	sort.Ints(out.UnmatchedA)
+	sort.Ints(out.UnmatchedB)
+	return out
+}
+
+// An assignGroup is a set of items of a and b which are within reach
+// of each other, but of no item of another group.
+type assignGroup struct {
+	a, b []int
+}
+
+// solve adds the optimal assignment of the group to out.
+func (g *assignGroup) solve(a, b []uint64, edges [][]KeyedHit[int], maxDistance uint64, out *Assignment) {
+	na, nb := len(g.a), len(g.b)
+	if na == 0 || nb == 0 {
+		out.UnmatchedA = append(out.UnmatchedA, g.a...)
+		out.UnmatchedB = append(out.UnmatchedB, g.b...)
+		return
+	}
+
+	if na == 1 && nb == 1 {
+		out.Pairs = append(out.Pairs, Pairing{g.a[0], g.b[0], Distance(a[g.a[0]], b[g.b[0]])})
+		out.Total += out.Pairs[len(out.Pairs)-1].Distance
+		return
+	}
+
+	// Square costs, with a column per item of a to leave it unmatched,
+	// and a row per item of b. An unmatched item costs more than all
+	// pairs of the group together, so a pair more always costs less.
+	n := na + nb
+	const never = math.MaxInt64 / 4
+	unmatched := int64(min(na, nb))*int64(min(maxDistance, 64)) + 1
+
+	cost := make([][]int64, n)
+	for i := range cost {
+		cost[i] = make([]int64, n)
+		for j := range cost[i] {
+			cost[i][j] = never
+		}
+	}
+
+	col := make(map[int]int, nb)
+	for j, bj := range g.b {
+		col[bj] = j
+	}
+
+	for i, ai := range g.a {
+		for _, hit := range edges[ai] {
+			cost[i][col[hit.Key]] = int64(hit.Distance)
+		}
+		cost[i][nb+i] = unmatched
+	}
+
+	for j := range g.b {
+		cost[na+j][j] = unmatched
+		for i := range g.a {
+			cost[na+j][nb+i] = 0
+		}
+	}
+
+	for i, j := range hungarian(cost) {
+		switch {
+		case i < na && j < nb:
+			d := Distance(a[g.a[i]], b[g.b[j]])
+			out.Pairs = append(out.Pairs, Pairing{g.a[i], g.b[j], d})
+			out.Total += d
+		case i < na:
+			out.UnmatchedA = append(out.UnmatchedA, g.a[i])
+		case j < nb:
+			out.UnmatchedB = append(out.UnmatchedB, g.b[j])
+		}
+	}
+}
+
+// hungarian returns the column assigned to each row of the square cost
+// matrix, such that the sum of their costs is least. It keeps potentials
+// for rows and columns, and extends the assignment a row at a time along
+// a shortest augmenting path, in O(n³).
+func hungarian(cost [][]int64) []int {
+	n := len(cost)
+	const inf = math.MaxInt64
+
+	// Rows and columns count from 1; column 0 is where paths start.
+	u := make([]int64, n+1)
+	v := make([]int64, n+1)
+	row := make([]int, n+1) // Row assigned to each column, or 0.
+	way := make([]int, n+1)
+
+	for i := 1; i <= n; i++ {
+		row[0] = i
+		j0 := 0
+		minv := make([]int64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = inf
+		}
+
+		for row[j0] != 0 {
+			used[j0] = true
+			i0, delta, j1 := row[j0], int64(inf), 0
+
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+
+				if c := cost[i0-1][j-1] - u[i0] - v[j]; c < minv[j] {
+					minv[j], way[j] = c, j0
+				}
+
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[row[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+
+			j0 = j1
+		}
+
+		for j0 != 0 {
+			j1 := way[j0]
+			row[j0] = row[j1]
+			j0 = j1
+		}
+	}
+
+	assigned := make([]int, n)
+	for j := 1; j <= n; j++ {
+		assigned[row[j]-1] = j - 1
+	}
+	return assigned
+}
@@ -0,0 +1,50 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssign(t *testing.T) {
+	// The nearest image to 0x00 is 0x01, the only one within reach of
+	// 0x0d. Pairing 0x00 with its nearest neighbour leaves 0x0d unpaired.
+	a := []uint64{0x00, 0x0d, 0xff00, 0xf0f0f0}
+	b := []uint64{0x01, 0x03, 0xff01, 0x0f0f0f0f00000000}
+
+	got := Assign(a, b, 2)
+	want := &Assignment{
+		Pairs:      []Pairing{{0, 1, 2}, {1, 0, 2}, {2, 2, 1}},
+		UnmatchedA: []int{3},
+		UnmatchedB: []int{3},
+		Total:      5,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("assignment %+v, want %+v", got, want)
+	}
+
+	// Pairing two items is preferred over the least distance.
+	got = Assign([]uint64{0x00, 0x03}, []uint64{0x03, 0x0f}, 2)
+	if len(got.Pairs) != 2 || got.Total != 4 {
+		t.Fatalf("assignment %+v", got)
+	}
+
+	if got = Assign(nil, b, 2); len(got.Pairs) != 0 || len(got.UnmatchedB) != len(b) {
+		t.Fatalf("assignment of nothing %+v", got)
+	}
+}
+
+func TestHungarian(t *testing.T) {
+	cost := [][]int64{
+		{4, 1, 3},
+		{2, 0, 5},
+		{3, 2, 2},
+	}
+
+	if got := hungarian(cost); !reflect.DeepEqual(got, []int{1, 0, 2}) {
+		t.Fatalf("assigned %v", got)
+	}
+}
@@ -0,0 +1,414 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package attack produces perturbed variants of an image, for measuring
+how robust a perceptual hash is against them.
+
+Each Transform models an edit a copy of an image commonly goes through:
+recompression, scaling, cropping, rotation, noise, brightness and
+contrast changes, and watermarks. Computing the hash of an image and
+each of its variants, and looking at the Hamming Distances, shows which
+algorithm and threshold suit a given collection:
+
+	for _, t := range attack.Standard() {
+		d := imghash.Distance(imghash.Average(img), imghash.Average(t.Apply(img)))
+		fmt.Printf("%s: %d\n", t.Name, d)
+	}
+
+All transforms are deterministic. The input image is never modified.
+*/
+package attack
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// A Transform produces a perturbed variant of an image.
+type Transform struct {
+	Name  string                        // Short description, like "jpeg q75".
+	Apply func(image.Image) image.Image // Returns the variant.
+}
+
+// Standard returns a set of transforms ranging from mild to severe,
+// covering all kinds the package provides.
+func Standard() []Transform {
+	return []Transform{
+		JPEG(90),
+		JPEG(75),
+		JPEG(50),
+		Resize(2),
+		Resize(0.5),
+		Resize(0.25),
+		Crop(0.05),
+		Crop(0.1),
+		Crop(0.2),
+		Rotate(1),
+		Rotate(5),
+		Noise(4, 1),
+		Noise(16, 1),
+		Brightness(0.1),
+		Brightness(-0.1),
+		Contrast(1.2),
+		Contrast(0.8),
+		Watermark(0.3, 0.5),
+	}
+}
+
+// Chain applies all given transforms in order.
+func Chain(ts ...Transform) Transform {
+	names := make([]string, len(ts))
+	for i, t := range ts {
+		names[i] = t.Name
+	}
+
+	return Transform{
+		Name: strings.Join(names, ", "),
+		Apply: func(img image.Image) image.Image {
+			for _, t := range ts {
+				img = t.Apply(img)
+			}
+			return img
+		},
+	}
+}
+
+// JPEG recompresses the image as a JPEG with the given quality,
+// in the range 1-100.
+func JPEG(quality int) Transform {
+	return Transform{
+		Name: fmt.Sprintf("jpeg q%d", quality),
+		Apply: func(img image.Image) image.Image {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return img
+			}
+
+			out, err := jpeg.Decode(&buf)
+			if err != nil {
+				return img
+			}
+
+			return out
+		},
+	}
+}
+
+// Resize scales the image by the given factor. Each output pixel is
+// the area weighted average of the source pixels it covers.
+func Resize(scale float64) Transform {
+	return Transform{
+		Name: fmt.Sprintf("resize %g%%", 100*scale),
+		Apply: func(img image.Image) image.Image {
+			r := img.Bounds()
+			w := int(math.Round(float64(r.Dx()) * scale))
+			h := int(math.Round(float64(r.Dy()) * scale))
+
+			if w < 1 {
+				w = 1
+			}
+
+			if h < 1 {
+				h = 1
+			}
+
+			return resample(toRGBA(img), w, h)
+		},
+	}
+}
+
+// Crop removes the given fraction of the width and height, split evenly
+// between opposite edges. Crop(0.1) keeps the centre 90% of both.
+func Crop(fraction float64) Transform {
+	return Transform{
+		Name: fmt.Sprintf("crop %g%%", 100*fraction),
+		Apply: func(img image.Image) image.Image {
+			src := toRGBA(img)
+			r := src.Rect
+			dx := int(float64(r.Dx()) * fraction / 2)
+			dy := int(float64(r.Dy()) * fraction / 2)
+
+			crop := image.Rect(r.Min.X+dx, r.Min.Y+dy, r.Max.X-dx, r.Max.Y-dy)
+			if crop.Empty() {
+				return src
+			}
+
+			out := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+			draw.Draw(out, out.Rect, src, crop.Min, draw.Src)
+			return out
+		},
+	}
+}
+
+// Rotate rotates the image clockwise around its centre, by the given
+// number of degrees. The output has the same size as the input. Corners
+// which are rotated out of view are lost, and uncovered areas are black.
+func Rotate(degrees float64) Transform {
+	return Transform{
+		Name: fmt.Sprintf("rotate %gdeg", degrees),
+		Apply: func(img image.Image) image.Image {
+			src := toRGBA(img)
+			w, h := src.Rect.Dx(), src.Rect.Dy()
+			out := image.NewRGBA(image.Rect(0, 0, w, h))
+
+			sin, cos := math.Sincos(degrees * math.Pi / 180)
+			cx, cy := float64(w)/2, float64(h)/2
+
+			var x, y int
+			for y = 0; y < h; y++ {
+				for x = 0; x < w; x++ {
+					// Map the output pixel centre back into the source.
+					fx, fy := float64(x)+0.5-cx, float64(y)+0.5-cy
+					sx := cos*fx + sin*fy + cx - 0.5
+					sy := -sin*fx + cos*fy + cy - 0.5
+
+					c := bilinear(src, sx, sy)
+					copy(out.Pix[out.PixOffset(x, y):], c[:])
+				}
+			}
+
+			return out
+		},
+	}
+}
+
+// Noise adds Gaussian noise with the given standard deviation, in 8-bit
+// levels, to each colour channel. The seed makes the noise repeatable.
+func Noise(sigma float64, seed int64) Transform {
+	return Transform{
+		Name: fmt.Sprintf("noise sigma %g", sigma),
+		Apply: func(img image.Image) image.Image {
+			out := toRGBA(img)
+			rng := rand.New(rand.NewSource(seed))
+
+			return mapPixels(out, func(c []uint8) {
+				for i := 0; i < 3; i++ {
+					c[i] = clamp(float64(c[i]) + rng.NormFloat64()*sigma)
+				}
+			})
+		},
+	}
+}
+
+// Brightness shifts each colour channel by the given fraction of the
+// full range. Brightness(0.1) makes the image 10% lighter.
+func Brightness(delta float64) Transform {
+	return Transform{
+		Name: fmt.Sprintf("brightness %+g%%", 100*delta),
+		Apply: func(img image.Image) image.Image {
+			return mapPixels(toRGBA(img), func(c []uint8) {
+				for i := 0; i < 3; i++ {
+					c[i] = clamp(float64(c[i]) + 255*delta)
+				}
+			})
+		},
+	}
+}
+
+// Contrast scales the distance of each colour channel to the middle
+// of the range by the given factor.
+func Contrast(factor float64) Transform {
+	return Transform{
+		Name: fmt.Sprintf("contrast x%g", factor),
+		Apply: func(img image.Image) image.Image {
+			return mapPixels(toRGBA(img), func(c []uint8) {
+				for i := 0; i < 3; i++ {
+					c[i] = clamp((float64(c[i])-127.5)*factor + 127.5)
+				}
+			})
+		},
+	}
+}
+
+// Watermark blends a striped white banner into the bottom right corner of
+// the image. It spans the given fraction of the width, and a third of that
+// of the height. Opacity is in the range 0-1.
+func Watermark(size, opacity float64) Transform {
+	return Transform{
+		Name: fmt.Sprintf("watermark %g%%", 100*size),
+		Apply: func(img image.Image) image.Image {
+			out := toRGBA(img)
+			r := out.Rect
+			w := int(float64(r.Dx()) * size)
+			h := int(float64(r.Dy()) * size / 3)
+			margin := r.Dx() / 50
+
+			banner := image.Rect(r.Max.X-margin-w, r.Max.Y-margin-h, r.Max.X-margin, r.Max.Y-margin)
+			banner = banner.Intersect(r)
+
+			// Stripes stand in for lettering.
+			stripe := h / 4
+			if stripe < 2 {
+				stripe = 2
+			}
+
+			var x, y, i int
+			for y = banner.Min.Y; y < banner.Max.Y; y++ {
+				for x = banner.Min.X; x < banner.Max.X; x++ {
+					a := opacity
+					if ((x+y)/stripe)%2 == 0 {
+						a /= 2
+					}
+
+					c := out.Pix[out.PixOffset(x, y):]
+					for i = 0; i < 3; i++ {
+						c[i] = clamp(float64(c[i])*(1-a) + float64(c[3])*a)
+					}
+				}
+			}
+
+			return out
+		},
+	}
+}
+
+// toRGBA returns a copy of the image as an *image.RGBA, with its
+// bounds starting at the origin.
+func toRGBA(img image.Image) *image.RGBA {
+	r := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Rect, img, r.Min, draw.Src)
+	return out
+}
+
+// mapPixels calls fn for the premultiplied RGBA values of each pixel.
+// Colour values are then limited to alpha, to keep them valid.
+func mapPixels(img *image.RGBA, fn func([]uint8)) *image.RGBA {
+	for i := 0; i+4 <= len(img.Pix); i += 4 {
+		c := img.Pix[i : i+4]
+		fn(c)
+
+		for j := 0; j < 3; j++ {
+			if c[j] > c[3] {
+				c[j] = c[3]
+			}
+		}
+	}
+
+	return img
+}
+
+// clamp rounds v to the nearest 8-bit level.
+func clamp(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+
+	return uint8(v + 0.5)
+}
+
+// bilinear samples the image at the given position. Samples
+// outside the image are transparent black.
+func bilinear(img *image.RGBA, x, y float64) [4]uint8 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+
+	var sum [4]float64
+
+	at := func(px, py int, weight float64) {
+		if !image.Pt(px, py).In(img.Rect) {
+			return
+		}
+
+		c := img.Pix[img.PixOffset(px, py):]
+		for i := range sum {
+			sum[i] += float64(c[i]) * weight
+		}
+	}
+
+	at(ix, iy, (1-fx)*(1-fy))
+	at(ix+1, iy, fx*(1-fy))
+	at(ix, iy+1, (1-fx)*fy)
+	at(ix+1, iy+1, fx*fy)
+
+	var out [4]uint8
+	for i := range sum {
+		out[i] = clamp(sum[i])
+	}
+
+	return out
+}
+
+// resample scales the image to the given size, averaging the source
+// pixels each output pixel covers, weighted by their coverage.
+func resample(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	xs, ys := coverage(sw, w), coverage(sh, h)
+
+	// Scale horizontally into tmp, then vertically into out.
+	tmp := make([]float64, 4*w*sh)
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	var x, y, i int
+	for y = 0; y < sh; y++ {
+		for x = 0; x < w; x++ {
+			t := tmp[4*(y*w+x):]
+
+			for _, c := range xs[x] {
+				p := src.Pix[src.PixOffset(c.index, y):]
+				for i = 0; i < 4; i++ {
+					t[i] += float64(p[i]) * c.weight
+				}
+			}
+		}
+	}
+
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			var sum [4]float64
+
+			for _, c := range ys[y] {
+				t := tmp[4*(c.index*w+x):]
+				for i = 0; i < 4; i++ {
+					sum[i] += t[i] * c.weight
+				}
+			}
+
+			p := out.Pix[out.PixOffset(x, y):]
+			for i = 0; i < 4; i++ {
+				p[i] = clamp(sum[i])
+			}
+		}
+	}
+
+	return out
+}
+
+// A contribution is the weight of a single source pixel
+// in an output pixel.
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// coverage returns, for each of the n output pixels, the source pixels
+// out of size which it covers, and by how much. The weights of each
+// output pixel sum up to one.
+func coverage(size, n int) [][]contribution {
+	out := make([][]contribution, n)
+	scale := float64(size) / float64(n)
+
+	for i := range out {
+		start, end := float64(i)*scale, float64(i+1)*scale
+
+		for j := int(start); j < size && float64(j) < end; j++ {
+			overlap := math.Min(end, float64(j+1)) - math.Max(start, float64(j))
+			if overlap > 0 {
+				out[i] = append(out[i], contribution{j, overlap / scale})
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,70 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package attack
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	src := image.NewRGBA(image.Rect(10, 20, 110, 70))
+
+	var x, y int
+	for y = src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		for x = src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			src.Set(x, y, color.RGBA{uint8(2 * x), uint8(3 * y), 128, 255})
+		}
+	}
+
+	orig := append([]uint8(nil), src.Pix...)
+	size := func(w, h int) image.Rectangle { return image.Rect(0, 0, w, h) }
+
+	for _, tc := range []struct {
+		t    Transform
+		want image.Rectangle
+	}{
+		{JPEG(75), size(100, 50)},
+		{Resize(0.5), size(50, 25)},
+		{Resize(2), size(200, 100)},
+		{Resize(0.001), size(1, 1)},
+		{Crop(0.1), size(90, 46)},
+		{Rotate(5), size(100, 50)},
+		{Noise(8, 1), size(100, 50)},
+		{Brightness(0.1), size(100, 50)},
+		{Contrast(1.2), size(100, 50)},
+		{Watermark(0.3, 0.5), size(100, 50)},
+		{Chain(Crop(0.1), Resize(0.5)), size(45, 23)},
+	} {
+		out := tc.t.Apply(src)
+		if b := out.Bounds(); b.Sub(b.Min) != tc.want {
+			t.Fatalf("%s: bounds %v, want %v", tc.t.Name, b, tc.want)
+		}
+
+		for i := range orig {
+			if src.Pix[i] != orig[i] {
+				t.Fatalf("%s: input modified", tc.t.Name)
+			}
+		}
+
+		// Transforms must be repeatable.
+		again := toRGBA(tc.t.Apply(src))
+		for i, v := range toRGBA(out).Pix {
+			if again.Pix[i] != v {
+				t.Fatalf("%s: output differs between runs", tc.t.Name)
+			}
+		}
+	}
+
+	// Halving a uniform image leaves it unchanged.
+	c := color.RGBA{10, 20, 30, 255}
+	flat := image.NewRGBA(size(4, 4))
+	draw.Draw(flat, flat.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+
+	if got := Resize(0.5).Apply(flat).At(1, 1); got != c {
+		t.Fatalf("resized colour %v, want %v", got, c)
+	}
+}
@@ -3,7 +3,11 @@
 
 package imghash
 
-import "image"
+import (
+	"image"
+	"math"
+	"sort"
+)
 
 // Average computes a Perceptual Hash using a naive, but very fast method.
 // It holds up to minor colour changes, changing brightness and contrast and
@@ -23,53 +27,339 @@ import "image"
 func Average(img image.Image) uint64 {
 	img = resize(img, 8, 8)
 	img = grayscale(img)
-	mean := avgMean(img)
-	return avgHash(img, mean)
+	cells := gridValues(img)
+	mean := avgMean(cells, nil)
+	return avgHash(cells, mean, nil)
 }
 
-// avgMean computes the mean of all pixels.
-func avgMean(img image.Image) uint32 {
-	var x, y int
-	var r, m uint32
+// AverageOptions configure AverageWith. The zero value yields the
+// same hashes as Average.
+type AverageOptions struct {
+	// If set, parts of the image which are transparent in the mask
+	// are ignored, as with AverageMask.
+	Mask image.Image
 
-	rect := img.Bounds()
-	w := rect.Max.X - rect.Min.X
-	h := rect.Max.Y - rect.Min.Y
-	c := uint32(w * h)
+	// Weight of the central cells when computing the mean, relative to
+	// the corners of the image. Weights fall off linearly with distance
+	// from the centre. Borders and vignetting then sway the mean less,
+	// while every cell still yields a bit. Values <= 1 weight all cells
+	// equally. A value of 4 is a good start.
+	CenterWeight float64
 
-	if c == 0 {
-		return 0
+	// Fraction of the darkest and of the brightest cells to leave out
+	// of the mean, between 0 and 0.5. A few blown-out highlights then
+	// do not shift the mean, which flips many bits between exposures
+	// of the same scene. A value of 0.1 drops about 6 cells at either end.
+	// The cells themselves still yield bits.
+	Trim float64
+
+	// If set, cells are compared against their (weighted) median,
+	// rather than their mean. This sets about half the bits for any
+	// image, which makes the hash less sensitive to a few very bright
+	// or dark cells. It is the limit of Trim; Trim is ignored if set.
+	Median bool
+
+	// If greater than 0, each cell is compared against the mean of the
+	// cells within this many cells of it, rather than against a mean
+	// over the image. Uneven lighting, like vignetting or a gradient
+	// across a scanned page, then shifts the threshold along with the
+	// cells, and flips fewer bits. This takes a second pass over the
+	// cells. A value of 2 compares each cell with a 5x5 window, clipped
+	// at the edges; values of 7 or more are the same as the global mean.
+	// Trim and Median are ignored if set; the weights still apply.
+	Neighborhood int
+
+	// Order in which the bits of the cells are packed into the hash.
+	// The zero value is RowMajor, as for Average.
+	Layout BitLayout
+}
+
+// AverageWith returns a HashFunc which computes an Average hash with the
+// given options. Opts may be nil, to use the defaults.
+func AverageWith(opts *AverageOptions) HashFunc {
+	var o AverageOptions
+	if opts != nil {
+		o = *opts
 	}
 
-	for y = rect.Min.Y; y < rect.Max.Y; y++ {
-		for x = rect.Min.X; x < rect.Max.X; x++ {
-			r, _, _, _ = img.At(x, y).RGBA()
-			m += r
+	var center []uint32
+	if o.CenterWeight > 1 {
+		center = centerWeights(8, 8, o.CenterWeight)
+	}
+
+	hf := func(img image.Image) uint64 {
+		var cells, weights []uint32
+
+		if o.Mask != nil {
+			lum, cov := applyMask(img, o.Mask)
+			cells, weights = maskedCells(resize(lum, 8, 8), resize(cov, 8, 8))
+		} else {
+			cells = gridValues(grayscale(resize(img, 8, 8)))
 		}
+
+		weights = combineWeights(weights, center)
+
+		if o.Neighborhood > 0 {
+			return avgLocalHash(cells, weights, 8, o.Neighborhood)
+		}
+
+		if o.Median {
+			return avgHash(cells, avgMedian(cells, weights), weights)
+		}
+
+		if o.Trim > 0 {
+			return avgHash(cells, avgTrimmedMean(cells, weights, o.Trim), weights)
+		}
+
+		return avgHash(cells, avgMean(cells, weights), weights)
+	}
+
+	if o.Layout == RowMajor {
+		return hf
 	}
 
-	return m / c
+	return func(img image.Image) uint64 {
+		return Relayout(hf(img), RowMajor, o.Layout)
+	}
 }
 
-// avgHash computes the hash bits for the given image and mean.
-// It sets individual bits in a 64-bit integer. A bit is set if the
-// pixel value is larger than the mean.
-func avgHash(img image.Image, mean uint32) uint64 {
+// AverageCells returns the grayscale values of the 8x8 grid from which
+// Average derives its bits, in bit order, along with the mean they are
+// compared against. Bit i is set when cells[i] > mean.
+//
+// This is meant for debugging and visualising hashes.
+func AverageCells(img image.Image) (cells []uint32, mean uint32) {
+	img = resize(img, 8, 8)
+	img = grayscale(img)
+	cells = gridValues(img)
+	mean = avgMean(cells, nil)
+	return
+}
+
+// gridValues returns the pixel values of a grayscale image,
+// in row-major order.
+func gridValues(img image.Image) []uint32 {
 	var x, y int
-	var value, bit uint64
 	var r uint32
 
 	rect := img.Bounds()
+	cells := make([]uint32, 0, rect.Dx()*rect.Dy())
 
 	for y = rect.Min.Y; y < rect.Max.Y; y++ {
 		for x = rect.Min.X; x < rect.Max.X; x++ {
 			r, _, _, _ = img.At(x, y).RGBA()
+			cells = append(cells, r)
+		}
+	}
+
+	return cells
+}
+
+// avgMean computes the mean of all cells. If weights is not nil,
+// it holds the relative weight of each cell.
+func avgMean(cells, weights []uint32) uint32 {
+	var m, c uint64
+
+	for i, v := range cells {
+		if weights == nil {
+			m += uint64(v)
+			c++
+		} else {
+			m += uint64(v) * uint64(weights[i])
+			c += uint64(weights[i])
+		}
+	}
+
+	if c == 0 {
+		return 0
+	}
+
+	return uint32(m / c)
+}
+
+// avgMedian computes the median of all cells. If weights is not nil,
+// it holds the relative weight of each cell. Without weights, this is
+// the upper median, as used by Average1024.
+func avgMedian(cells, weights []uint32) uint32 {
+	order := sortedCells(cells)
+
+	weight := func(i int) uint64 {
+		if weights == nil {
+			return 1
+		}
+		return uint64(weights[i])
+	}
+
+	var total, sum uint64
+	for i := range cells {
+		total += weight(i)
+	}
+
+	for _, i := range order {
+		if sum += weight(i); 2*sum > total {
+			return cells[i]
+		}
+	}
+
+	return 0
+}
 
-			if r > mean {
-				value |= 1 << bit
+// avgTrimmedMean computes the mean of all cells, with the given fraction
+// of the total weight left out at either end of the range of values. A
+// cell on the edge of a cut counts for the part of its weight within it.
+// If weights is not nil, it holds the relative weight of each cell.
+func avgTrimmedMean(cells, weights []uint32, trim float64) uint32 {
+	if trim >= 0.5 {
+		return avgMedian(cells, weights)
+	}
+
+	order := sortedCells(cells)
+
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return float64(weights[i])
+	}
+
+	var total float64
+	for i := range cells {
+		total += weight(i)
+	}
+
+	low, high := trim*total, (1-trim)*total
+
+	var pos, sum, kept float64
+	for _, i := range order {
+		w := weight(i)
+		from, to := math.Max(pos, low), math.Min(pos+w, high)
+		pos += w
+
+		if to > from {
+			sum += float64(cells[i]) * (to - from)
+			kept += to - from
+		}
+	}
+
+	if kept == 0 {
+		return 0
+	}
+
+	return uint32(sum / kept)
+}
+
+// sortedCells returns the indices of the cells, by ascending value.
+func sortedCells(cells []uint32) []int {
+	order := make([]int, len(cells))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool { return cells[order[i]] < cells[order[j]] })
+	return order
+}
+
+// centerWeights returns the weights of a grid of cells, with the given
+// weight at the centre, falling off linearly to 1 at the corners. The
+// weights are fixed point numbers, with 0x1000 for 1.
+func centerWeights(cols, rows int, weight float64) []uint32 {
+	weights := make([]uint32, 0, cols*rows)
+
+	var x, y int
+	for y = 0; y < rows; y++ {
+		for x = 0; x < cols; x++ {
+			dx := (float64(x)+0.5)/float64(cols) - 0.5
+			dy := (float64(y)+0.5)/float64(rows) - 0.5
+			r := math.Sqrt((dx*dx + dy*dy) * 2)
+
+			w := 1 + (weight-1)*(1-r)
+			weights = append(weights, uint32(w*0x1000+0.5))
+		}
+	}
+
+	return weights
+}
+
+// combineWeights multiplies the weights of a mask coverage grid with
+// those of another grid. Either may be nil. Cells which are masked
+// stay at zero, and cells which are not stay above it.
+func combineWeights(mask, other []uint32) []uint32 {
+	if mask == nil {
+		return other
+	}
+
+	if other == nil {
+		return mask
+	}
+
+	out := make([]uint32, len(mask))
+	for i, m := range mask {
+		if m > 0 {
+			out[i] = uint32(uint64(m) * uint64(other[i]) / 0xffff)
+			if out[i] == 0 {
+				out[i] = 1
+			}
+		}
+	}
+
+	return out
+}
+
+// avgHash computes the hash bits for the given cells and mean.
+// It sets individual bits in a 64-bit integer. A bit is set if the
+// cell value is larger than the mean. If weights is not nil, bits
+// for cells with a zero weight are never set.
+func avgHash(cells []uint32, mean uint32, weights []uint32) uint64 {
+	var value uint64
+
+	for bit, v := range cells {
+		if weights != nil && weights[bit] == 0 {
+			continue
+		}
+
+		if v > mean {
+			value |= 1 << uint(bit)
+		}
+	}
+
+	return value
+}
+
+// avgLocalHash is like avgHash, for a grid of cells the given number of
+// cells wide, with each cell compared against the mean of a window of
+// cells within radius of it. If weights is not nil, it holds the
+// relative weight of each cell.
+func avgLocalHash(cells, weights []uint32, width, radius int) uint64 {
+	height := len(cells) / width
+	window := make([]uint32, 0, len(cells))
+	var wwindow []uint32
+	if weights != nil {
+		wwindow = make([]uint32, 0, len(cells))
+	}
+
+	var value uint64
+	var x, y, wx, wy int
+
+	for y = 0; y < height; y++ {
+		for x = 0; x < width; x++ {
+			bit := y*width + x
+			if weights != nil && weights[bit] == 0 {
+				continue
 			}
 
-			bit++
+			window, wwindow = window[:0], wwindow[:0]
+			for wy = max(y-radius, 0); wy <= min(y+radius, height-1); wy++ {
+				for wx = max(x-radius, 0); wx <= min(x+radius, width-1); wx++ {
+					window = append(window, cells[wy*width+wx])
+					if weights != nil {
+						wwindow = append(wwindow, weights[wy*width+wx])
+					}
+				}
+			}
+
+			if cells[bit] > avgMean(window, wwindow) {
+				value |= 1 << uint(bit)
+			}
 		}
 	}
 
@@ -0,0 +1,93 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+// A DistanceBackend compares a query against many hashes at once. It is
+// what Database.Find uses to search its entries. The default compares
+// them in Go; the opencl subpackage provides one which runs on a GPU,
+// for collections of hundreds of millions of hashes.
+type DistanceBackend interface {
+	// Load replaces the hashes to search. The backend may keep the
+	// slice until the next call to Load, so it must not be modified
+	// until then.
+	Load(hashes []uint64) error
+
+	// Within returns the indices of the loaded hashes which are within
+	// the given distance of query, in increasing order.
+	Within(query, distance uint64) ([]int, error)
+}
+
+// NewGoBackend returns a DistanceBackend which compares hashes in Go.
+// Large collections are split over all CPUs.
+func NewGoBackend() DistanceBackend {
+	return &goBackend{}
+}
+
+// minParallel is the least number of hashes goBackend
+// splits over several goroutines.
+const minParallel = 1 << 16
+
+type goBackend struct {
+	hashes []uint64
+}
+
+func (b *goBackend) Load(hashes []uint64) error {
+	b.hashes = hashes
+	return nil
+}
+
+func (b *goBackend) Within(query, distance uint64) ([]int, error) {
+	n := len(b.hashes)
+	parts := runtime.GOMAXPROCS(0)
+	if n < minParallel || parts < 2 || generic.Load() {
+		return within(nil, b.hashes, 0, query, distance), nil
+	}
+
+	size := (n + parts - 1) / parts
+	results := make([][]int, parts)
+
+	var wg sync.WaitGroup
+	for p := range results {
+		start, end := p*size, (p+1)*size
+		if end > n {
+			end = n
+		}
+
+		if start >= end {
+			break
+		}
+
+		wg.Add(1)
+		go func(p, start, end int) {
+			defer wg.Done()
+			results[p] = within(nil, b.hashes[start:end], start, query, distance)
+		}(p, start, end)
+	}
+
+	wg.Wait()
+
+	var out []int
+	for _, r := range results {
+		out = append(out, r...)
+	}
+
+	return out, nil
+}
+
+// within appends to dst the indices of the hashes within distance
+// of query, offset by base.
+func within(dst []int, hashes []uint64, base int, query, distance uint64) []int {
+	for i, h := range hashes {
+		if uint64(bits.OnesCount64(h^query)) <= distance {
+			dst = append(dst, base+i)
+		}
+	}
+	return dst
+}
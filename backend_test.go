@@ -0,0 +1,178 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestGoBackend(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hashes := make([]uint64, 3*minParallel+5)
+	for i := range hashes {
+		hashes[i] = rng.Uint64()
+	}
+
+	query := hashes[len(hashes)-1]
+	want := within(nil, hashes, 0, query, 20)
+
+	b := NewGoBackend()
+	b.Load(hashes)
+
+	got, err := b.Within(query, 20)
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, %v, want %v", got, err, want)
+	}
+}
+
+// countingBackend counts the loads of its backend, and fails
+// all queries if fail is set.
+type countingBackend struct {
+	DistanceBackend
+	loads int
+	fail  bool
+}
+
+func (b *countingBackend) Load(hashes []uint64) error {
+	b.loads++
+	return b.DistanceBackend.Load(hashes)
+}
+
+func (b *countingBackend) Within(query, distance uint64) ([]int, error) {
+	if b.fail {
+		return nil, errors.New("device lost")
+	}
+	return b.DistanceBackend.Within(query, distance)
+}
+
+func TestDatabaseBackend(t *testing.T) {
+	b := &countingBackend{DistanceBackend: NewGoBackend()}
+	d := NewDatabase()
+	d.Backend = b
+
+	d.Set("a", 1, 0x00ff)
+	d.Set("b", 1, 0x01ff)
+	d.Set("c", 1, 0xff00)
+
+	paths := func(rs ResultSet) string {
+		var s string
+		for _, r := range rs {
+			s += fmt.Sprintf("%s:%d ", r.Path, r.Distance)
+		}
+		return s
+	}
+
+	if got := paths(d.Find(0x00ff, 2)); got != "a:0 b:1 " {
+		t.Fatalf("found %s", got)
+	}
+
+	d.Find(0xff00, 2)
+	if b.loads != 1 {
+		t.Fatalf("%d loads for unchanged database", b.loads)
+	}
+
+	// Changed and deleted entries are found under their new hashes only.
+	d.Set("c", 2, 0x00fe)
+	d.DeleteEntry(d.IndexFile("b"))
+
+	if got := paths(d.Find(0x00ff, 2)); got != "a:0 c:1 " || b.loads != 2 {
+		t.Fatalf("found %s after %d loads", got, b.loads)
+	}
+
+	if got := paths(d.Find(0x00fe, 0)); got != "c:0 " {
+		t.Fatalf("exact match: %s", got)
+	}
+
+	b.fail = true
+	if got := paths(d.Find(0x00ff, 2)); got != "a:0 c:1 " {
+		t.Fatalf("found %s with a failing backend", got)
+	}
+}
+
+func TestDatabaseFindExact(t *testing.T) {
+	d := NewDatabase()
+	d.Set("a", 1, 0x00ff)
+	d.Set("b", 1, 0x00ff)
+	d.Set("c", 1, 0x01ff)
+
+	// A distance of 0 looks the hash up, and finds exact copies only.
+	rs := d.Find(0x00ff, 0)
+	if len(rs) != 2 || rs[0].Distance != 0 || rs[1].Distance != 0 {
+		t.Fatalf("exact match: %d results", len(rs))
+	}
+
+	if rs := d.Find(0x03ff, 0); len(rs) != 0 {
+		t.Fatalf("exact match of unknown hash: %d results", len(rs))
+	}
+
+	// Any other distance compares the hashes.
+	if rs := d.Find(0x03ff, 1); len(rs) != 1 || rs[0].Path != "c" {
+		t.Fatalf("distance 1: %d results", len(rs))
+	}
+}
+
+// holdingBackend keeps a copy of the hashes it was loaded with, to
+// check its backend was not handed hashes which then changed.
+type holdingBackend struct {
+	DistanceBackend
+	held, loaded []uint64
+}
+
+func (b *holdingBackend) Load(hashes []uint64) error {
+	b.held = hashes
+	b.loaded = append([]uint64(nil), hashes...)
+	return b.DistanceBackend.Load(hashes)
+}
+
+func TestDatabaseSetLoaded(t *testing.T) {
+	b := &holdingBackend{DistanceBackend: NewGoBackend()}
+	d := NewDatabase()
+	d.Backend = b
+
+	d.Set("a", 1, 0x00ff)
+	d.Set("b", 1, 0xff00)
+	d.Find(0x00ff, 2)
+
+	// Changing a hash leaves the loaded ones as they were, until the
+	// next query loads the new ones.
+	d.Set("b", 2, 0x00fe)
+	if fmt.Sprint(b.held) != fmt.Sprint(b.loaded) {
+		t.Fatalf("loaded hashes changed from %x to %x", b.loaded, b.held)
+	}
+
+	if rs := d.Find(0x00ff, 2); len(rs) != 2 || rs[1].Path != "b" || fmt.Sprintf("%x", b.held) != "[ff fe]" {
+		t.Fatalf("%d results, with %x loaded", len(rs), b.held)
+	}
+}
+
+func TestDatabaseFindNear(t *testing.T) {
+	d := NewDatabase()
+	d.Set("a", 1, 0x00ff)
+	d.Set("b", 1, 0x01ff)
+	d.Set("c", 1, 0x0fff)
+
+	// Find took the exact-match lookup for any distance once, and so
+	// never found near matches.
+	for _, tt := range []struct {
+		distance uint64
+		want     string
+	}{
+		{0, "[a]"},
+		{1, "[a b]"},
+		{3, "[a b]"},
+		{4, "[a b c]"},
+	} {
+		var got []string
+		for _, r := range d.Find(0x00ff, tt.distance) {
+			got = append(got, r.Path)
+		}
+
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("distance %d: found %v, want %s", tt.distance, got, tt.want)
+		}
+	}
+}
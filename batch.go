@@ -0,0 +1,692 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTimeout is the error of a file which took longer to hash than
+// BatchOptions.Timeout.
+var ErrTimeout = errors.New("imghash: timed out")
+
+// A BatchResult holds the outcome of hashing a single file.
+type BatchResult struct {
+	Path    string // File path.
+	Hash    uint64 // Perceptual Image hash.
+	Err     error  // Error which kept the file from being hashed, as a *HashError.
+	Cached  bool   // Whether the hash came from the cache or checkpoint.
+	Partial bool   // Whether the file was damaged, and salvaged with BatchOptions.Salvage.
+	Losses  Loss   // Lossy conversions the image took, with BatchOptions.Strictness at Audit.
+
+	seq int // Position of the file in the input.
+}
+
+// Progress describes how far along a batch is.
+type Progress struct {
+	Started  int                 // Number of files picked up by a worker.
+	Done     int                 // Number of files finished, including failures.
+	Failed   int                 // Number of files which failed to hash.
+	Failures map[FailureKind]int // Number of failed files by kind of failure.
+	Cached   int                 // Number of hashes from the cache or checkpoint.
+	Elapsed  time.Duration       // Time since the batch started.
+}
+
+// BatchOptions configure a batch. The zero value is a valid
+// configuration. All callbacks are optional; they are called from
+// the worker goroutines, so they must be safe for concurrent use.
+type BatchOptions struct {
+	// Number of concurrent workers. A value < 1 uses one worker per CPU.
+	Workers int
+
+	// Number of files read at the same time. A value < 1 lets each
+	// worker read its own file. When set, files are read into memory
+	// in full before they are decoded. On network file systems, a few
+	// readers feeding many workers tends to be fastest.
+	Readers int
+
+	// If > 0, files are read at no more than this many bytes per
+	// second, across all readers.
+	ReadRate int64
+
+	// If set, receives a debug record for every file hashed, and
+	// a warning for every file which failed.
+	Logger *slog.Logger
+
+	// Called when a worker starts on a file.
+	OnStart func(path string)
+
+	// Called when a file has been hashed successfully.
+	OnFinish func(r *BatchResult)
+
+	// Called when a file failed to hash. The batch continues,
+	// unless FailFast is set.
+	OnError func(path string, err error)
+
+	// If set, the first file which fails to hash stops the batch.
+	// No more files are started; those already being hashed are
+	// finished, and their results delivered. By default, failures
+	// are reported and the batch goes on.
+	FailFast bool
+
+	// If > 0, files which take longer than this to hash fail with
+	// ErrTimeout. Decoders can not be interrupted, so the file is
+	// still decoded in the background, but the worker moves on.
+	Timeout time.Duration
+
+	// Called periodically with the batch progress, and once more
+	// when the batch is done. ProgressInterval defaults to a second.
+	OnProgress       func(Progress)
+	ProgressInterval time.Duration
+
+	// If set, hashes are looked up in, and stored to this cache.
+	// Files found in it are not decoded.
+	Cache Cache
+
+	// Name of the hash function. It is part of every cache key, so
+	// hashes from different algorithms can share a cache. Batches
+	// passed no hash function default to the name and version of
+	// DefaultHasher.
+	Algorithm string
+
+	// By default, cache entries are keyed by file path, size and
+	// modification time. If ContentKeys is set, they are keyed by the
+	// SHA-256 of the file contents instead. This survives renames and
+	// touched files, at the cost of reading every file in full.
+	//
+	// HashFiles keys entries on absolute paths. HashFS keys them on paths
+	// within the file system; use content keys, or a cache per file
+	// system, to avoid collisions.
+	ContentKeys bool
+
+	// If set, every file hashed is recorded in this checkpoint, and
+	// files recorded by an earlier, interrupted batch are not decoded
+	// again. Entries are keyed like cache entries without ContentKeys.
+	Checkpoint *Checkpoint
+
+	// If set, results are delivered in the order of the input, instead
+	// of the order in which they complete. Workers run at most a few
+	// files ahead of the oldest file still being hashed, so a single
+	// slow file holds up the batch for a while, but memory stays bounded.
+	// For HashFS, the input order is that of fs.WalkDir.
+	Ordered bool
+
+	// If set, the memory allocated by each stage of hashing a file is
+	// counted, along with the peak memory in use, and reported to the
+	// Metrics set with SetMetrics, if they implement MemoryMetrics. This
+	// costs a few microseconds per stage.
+	Accounting bool
+
+	// If set, files which are truncated or corrupt are salvaged with
+	// ComputeSalvage, instead of failing. Their results have Partial
+	// set. Salvaged hashes are neither cached nor checkpointed.
+	Salvage bool
+
+	// Whether images which take a lossy conversion before they are
+	// hashed, like CMYK converted to RGB without a profile, are hashed
+	// silently, reported in BatchResult.Losses and to the Logger, or
+	// fail with ErrLossy. Hashes from the cache or checkpoint were not
+	// decoded, so they are not audited. Defaults to Lenient.
+	Strictness Strictness
+
+	// The remaining options only apply to HashFS.
+
+	// Files smaller than this many bytes are skipped.
+	MinSize int64
+
+	// File extensions to hash, including the dot. They are matched
+	// without regard to case. Defaults to those of all registered
+	// decoders.
+	Extensions []string
+
+	// If set, files with other extensions are hashed as well, if their
+	// contents are recognised as an image format known to the image
+	// package. This costs a read of every file's header.
+	Sniff bool
+
+	// If set, zip and tar archives (.zip, .tar, .tar.gz and .tgz) are
+	// walked as directories, and the images within them are hashed
+	// without extracting the archives. Their result paths are those of
+	// the archives, followed by the paths within them. Archives within
+	// archives are not opened.
+	Archives bool
+
+	// Number of entries of a single archive which may be hashed at the
+	// same time. The walk waits for one to finish before it reads on,
+	// so an archive holds at most this many entries in memory. Defaults
+	// to 4.
+	ArchiveWorkers int
+}
+
+// orderWindow is the number of files per worker which may be in flight
+// in an ordered batch.
+const orderWindow = 4
+
+// batch tracks the state of a running batch.
+type batch struct {
+	// Counters, updated atomically. They come first, so they are
+	// 64-bit aligned on 32-bit platforms too.
+	started  int64
+	done     int64
+	failed   int64
+	cached   int64
+	failures [failureKinds]int64
+
+	opts     BatchOptions
+	parent   context.Context // Context of the caller.
+	ctx      context.Context // Done when the parent is, or on FailFast.
+	cancel   context.CancelFunc
+	stopped  error // First failure, with FailFast.
+	stopOnce sync.Once
+	open     func(string) (fs.File, error)
+	limit    *throttle // Nil without read limits.
+	abs      bool      // Whether to key cache entries on absolute paths.
+	start    time.Time
+}
+
+// A job is a single file to hash. If err is set, the file could not
+// be listed and is reported as failed right away.
+type job struct {
+	path string
+	err  error
+	seq  int
+	open func(string) (fs.File, error) // Opens the file instead of b.open, if set.
+	done func()                        // Called once the job is hashed or dropped.
+}
+
+// finish calls the done function of the job, if it has one.
+func (j *job) finish() {
+	if j.done != nil {
+		j.done()
+	}
+}
+
+// newBatch creates a batch for the given options, which may be nil.
+func newBatch(ctx context.Context, opts *BatchOptions, open func(string) (fs.File, error)) *batch {
+	b := &batch{parent: ctx, open: open, start: time.Now()}
+	if opts != nil {
+		b.opts = *opts
+	}
+
+	if b.opts.Accounting {
+		b.parent = withAccountant(ctx)
+	}
+
+	b.ctx, b.cancel = context.WithCancel(b.parent)
+
+	if b.limit = newThrottle(&b.opts); b.limit != nil {
+		b.open = b.limit.open(open)
+	}
+
+	return b
+}
+
+// progress returns the current progress.
+func (b *batch) progress() Progress {
+	p := Progress{
+		Started: int(atomic.LoadInt64(&b.started)),
+		Done:    int(atomic.LoadInt64(&b.done)),
+		Failed:  int(atomic.LoadInt64(&b.failed)),
+		Cached:  int(atomic.LoadInt64(&b.cached)),
+		Elapsed: time.Since(b.start),
+	}
+
+	for i := range b.failures {
+		if n := atomic.LoadInt64(&b.failures[i]); n > 0 {
+			if p.Failures == nil {
+				p.Failures = make(map[FailureKind]int)
+			}
+			p.Failures[FailureKind(i)] = int(n)
+		}
+	}
+
+	return p
+}
+
+// hash hashes a single file and fires all relevant callbacks.
+func (b *batch) hash(j job, hf HashFunc) *BatchResult {
+	defer j.finish()
+
+	file := j.path
+	open := b.open
+	if j.open != nil {
+		open = j.open
+	}
+
+	atomic.AddInt64(&b.started, 1)
+
+	if b.opts.OnStart != nil {
+		b.opts.OnStart(file)
+	}
+
+	start := time.Now()
+	r := &BatchResult{Path: file, Err: j.err, seq: j.seq}
+
+	ctx := b.parent
+	var audit *auditor
+	if b.opts.Strictness != Lenient {
+		audit = &auditor{strictness: b.opts.Strictness}
+		ctx = withAuditor(ctx, audit)
+	}
+
+	if r.Err == nil {
+		r.Hash, r.Cached, r.Err = b.timedCompute(ctx, file, open, hf)
+	}
+
+	if r.Err != nil && b.opts.Salvage && j.err == nil {
+		if kind := Classify(r.Err); kind == FailureTruncated || kind == FailureDecode {
+			if s, err := b.salvage(file, open, hf); err == nil {
+				r.Hash, r.Partial, r.Err = s.Hash, s.Partial, nil
+			}
+		}
+	}
+
+	if r.Err = classify(r.Err); r.Err != nil {
+		atomic.AddInt64(&b.failed, 1)
+		atomic.AddInt64(&b.failures[Classify(r.Err)], 1)
+
+		if b.opts.Logger != nil {
+			b.opts.Logger.Warn("hash failed", "path", file, "error", r.Err)
+		}
+
+		if b.opts.OnError != nil {
+			b.opts.OnError(file, r.Err)
+		}
+
+		if b.opts.FailFast {
+			b.stop(r.Err)
+		}
+	} else {
+		if b.opts.Logger != nil {
+			b.opts.Logger.Debug("hashed", "path", file, "hash", fmt.Sprintf("%016x", r.Hash),
+				"cached", r.Cached, "elapsed", time.Since(start))
+		}
+
+		if r.Cached {
+			atomic.AddInt64(&b.cached, 1)
+		}
+
+		if audit != nil && audit.loss != 0 {
+			r.Losses = audit.loss
+
+			if b.opts.Logger != nil {
+				b.opts.Logger.Warn("lossy conversion", "path", file, "losses", r.Losses)
+			}
+		}
+
+		if b.opts.OnFinish != nil {
+			b.opts.OnFinish(r)
+		}
+	}
+
+	atomic.AddInt64(&b.done, 1)
+	return r
+}
+
+// stop ends a FailFast batch, with the given error.
+func (b *batch) stop(err error) {
+	b.stopOnce.Do(func() {
+		b.stopped = err
+		b.cancel()
+	})
+}
+
+// timedCompute calls safeCompute, within the time allowed by
+// the Timeout option.
+func (b *batch) timedCompute(ctx context.Context, file string, open func(string) (fs.File, error), hf HashFunc) (uint64, bool, error) {
+	if b.opts.Timeout <= 0 {
+		return b.safeCompute(ctx, file, open, hf)
+	}
+
+	type result struct {
+		hash   uint64
+		cached bool
+		err    error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.hash, r.cached, r.err = b.safeCompute(ctx, file, open, hf)
+		done <- r
+	}()
+
+	timer := time.NewTimer(b.opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.hash, r.cached, r.err
+	case <-timer.C:
+		return 0, false, &HashError{FailureTimeout, fmt.Errorf("%w after %v", ErrTimeout, b.opts.Timeout)}
+	}
+}
+
+// safeCompute calls compute, and turns a panic in a decoder into an
+// error, so a single malicious or corrupt file can not end the batch.
+func (b *batch) safeCompute(ctx context.Context, file string, open func(string) (fs.File, error), hf HashFunc) (hash uint64, cached bool, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &HashError{FailureDecode, fmt.Errorf("imghash: decoder panic: %v", p)}
+		}
+	}()
+
+	return b.compute(ctx, file, open, hf)
+}
+
+// salvage hashes what can be recovered of a damaged file, turning a
+// panic into an error as safeCompute does.
+func (b *batch) salvage(file string, open func(string) (fs.File, error), hf HashFunc) (s *Salvage, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("imghash: decoder panic: %v", p)
+		}
+	}()
+
+	fd, err := open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return ComputeSalvage(fd, hf)
+}
+
+// compute opens and hashes a single file with open, in ctx. It returns
+// true if the hash came from the cache or checkpoint.
+func (b *batch) compute(ctx context.Context, file string, open func(string) (fs.File, error), hf HashFunc) (uint64, bool, error) {
+	acct := accountantOf(ctx)
+	mem := acct.begin()
+	fd, err := open(file)
+	acct.end(StageOpen, mem)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer fd.Close()
+
+	cp := b.opts.Checkpoint
+	if cp == nil {
+		return b.computeFile(ctx, file, fd, hf)
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+
+	key := checkpointKey(b.opts.Algorithm, b.keyPath(file), stat)
+	if hash, ok := cp.hash(key); ok {
+		return hash, true, nil
+	}
+
+	hash, cached, err := b.computeFile(ctx, file, fd, hf)
+	if err == nil {
+		cp.putHash(key, hash)
+	}
+
+	return hash, cached, err
+}
+
+// computeFile hashes the open file fd, through the cache if there is one.
+func (b *batch) computeFile(ctx context.Context, file string, fd fs.File, hf HashFunc) (uint64, bool, error) {
+	if b.opts.Cache != nil {
+		return b.computeCached(ctx, file, fd, hf)
+	}
+
+	hash, err := ComputeContext(ctx, fd, hf)
+	return hash, false, err
+}
+
+// keyPath returns the path by which file is keyed in caches
+// and checkpoints.
+func (b *batch) keyPath(file string) string {
+	if b.abs {
+		if abs, err := filepath.Abs(file); err == nil {
+			return abs
+		}
+	}
+
+	return file
+}
+
+// reportProgress calls OnProgress periodically, until done is closed.
+func (b *batch) reportProgress(done <-chan struct{}) {
+	interval := b.opts.ProgressInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			b.opts.OnProgress(b.progress())
+		case <-done:
+			return
+		}
+	}
+}
+
+// HashFiles hashes all files received on the given channel concurrently.
+// Hf may be nil, to use DefaultHasher, and opts, to use the defaults.
+//
+// Results are sent on the returned channel in the order in which they
+// complete, or in the order of files if opts.Ordered is set. It is
+// closed once files is closed and all files have been hashed. Files
+// which fail to decode yield a result with Err set; they do not stop the
+// batch, unless opts.FailFast is set. Err tells what kind of failure it
+// was.
+func HashFiles(files <-chan string, hf HashFunc, opts *BatchOptions) <-chan *BatchResult {
+	_, results := hashFiles(context.Background(), files, hf, opts)
+	return results
+}
+
+// HashAll hashes all files received on the given channel, like
+// HashFiles, and waits for the batch to finish. It returns the results
+// of all files, failed ones included, and an error in the manner of
+// errgroup.Group.Wait:
+//
+// With opts.FailFast set, the error is that of the first file which
+// failed, and the batch stops there. Otherwise, every file is hashed,
+// and the error joins those of all failures, or is nil if there were
+// none. Either way, the error of ctx is returned if it is cancelled
+// before the batch is done.
+func HashAll(ctx context.Context, files <-chan string, hf HashFunc, opts *BatchOptions) ([]*BatchResult, error) {
+	b, results := hashFiles(ctx, files, hf, opts)
+
+	var out []*BatchResult
+	var errs []error
+	for r := range results {
+		out = append(out, r)
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
+	}
+
+	switch {
+	case b.stopped != nil:
+		return out, b.stopped
+	case ctx.Err() != nil:
+		return out, ctx.Err()
+	}
+
+	return out, errors.Join(errs...)
+}
+
+// hashFiles starts a batch over the given files. Refer to HashFiles.
+func hashFiles(ctx context.Context, files <-chan string, hf HashFunc, opts *BatchOptions) (*batch, <-chan *BatchResult) {
+	jobs := make(chan job)
+
+	go func() {
+		for file := range files {
+			jobs <- job{path: file}
+		}
+
+		close(jobs)
+	}()
+
+	open := func(file string) (fs.File, error) { return os.Open(file) }
+	b := newBatch(ctx, opts, open)
+	b.abs = true
+	return b, b.run(jobs, hf)
+}
+
+// run hashes all jobs concurrently. Refer to HashFiles for details.
+func (b *batch) run(jobs <-chan job, hf HashFunc) <-chan *BatchResult {
+	if hf == nil {
+		h := DefaultHasher()
+		hf = h.Hash
+		if b.opts.Algorithm == "" {
+			b.opts.Algorithm = h.String()
+		}
+	}
+
+	workers := b.opts.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	if !b.opts.Ordered {
+		return b.runWorkers(jobs, hf, workers)
+	}
+
+	// Each job holds a slot from the moment it is handed to a worker,
+	// until its result has been delivered.
+	slots := make(chan struct{}, orderWindow*workers)
+	numbered := make(chan job)
+
+	go func() {
+		defer close(numbered)
+
+		var seq int
+		for j := range jobs {
+			select {
+			case slots <- struct{}{}:
+			case <-b.ctx.Done():
+				// Drain the jobs, as the workers would.
+				j.finish()
+				continue
+			}
+
+			j.seq = seq
+			seq++
+			numbered <- j
+		}
+	}()
+
+	return b.order(b.runWorkers(numbered, hf, workers), slots, workers)
+}
+
+// order sends the results on in the order of their sequence numbers,
+// and frees the slot of each one it sends.
+func (b *batch) order(results <-chan *BatchResult, slots chan struct{}, size int) <-chan *BatchResult {
+	ordered := make(chan *BatchResult, size)
+
+	send := func(r *BatchResult) {
+		select {
+		case ordered <- r:
+		case <-b.parent.Done():
+		}
+
+		<-slots
+	}
+
+	go func() {
+		defer close(ordered)
+
+		pending := make(map[int]*BatchResult)
+		var next int
+
+		for r := range results {
+			pending[r.seq] = r
+
+			for r, ok := pending[next]; ok; r, ok = pending[next] {
+				delete(pending, next)
+				next++
+				send(r)
+			}
+		}
+
+		// Workers drop the jobs they receive once a FailFast batch
+		// has stopped, which leaves gaps in the sequence. Files past
+		// a gap were hashed all the same, so deliver them in order.
+		seqs := make([]int, 0, len(pending))
+		for seq := range pending {
+			seqs = append(seqs, seq)
+		}
+
+		sort.Ints(seqs)
+		for _, seq := range seqs {
+			send(pending[seq])
+		}
+	}()
+
+	return ordered
+}
+
+// runWorkers hashes all jobs on a pool of workers, and sends the
+// results in the order in which they complete.
+func (b *batch) runWorkers(jobs <-chan job, hf HashFunc, workers int) <-chan *BatchResult {
+	results := make(chan *BatchResult, workers)
+	done := make(chan struct{})
+
+	if b.opts.OnProgress != nil {
+		go b.reportProgress(done)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				if b.ctx.Err() != nil {
+					j.finish()
+					continue
+				}
+
+				select {
+				case results <- b.hash(j, hf):
+				case <-b.parent.Done():
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+
+		if b.opts.OnProgress != nil {
+			b.opts.OnProgress(b.progress())
+		}
+
+		if b.opts.Logger != nil {
+			p := b.progress()
+			b.opts.Logger.Info("batch done", "files", p.Done, "failed", p.Failed, "elapsed", p.Elapsed)
+		}
+
+		b.cancel()
+		close(results)
+	}()
+
+	return results
+}
@@ -0,0 +1,679 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestHashFilesCallbacks(t *testing.T) {
+	files := make(chan string, 3)
+	files <- "testdata/gopher_small.png"
+	files <- "testdata/gopher_large.png"
+	files <- "testdata/missing.png"
+	close(files)
+
+	var mu sync.Mutex
+	var started, finished, failed int
+	var last Progress
+	var logs bytes.Buffer
+
+	opts := &BatchOptions{
+		Workers: 2,
+		Logger:  slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		OnStart: func(string) {
+			mu.Lock()
+			started++
+			mu.Unlock()
+		},
+		OnFinish: func(*BatchResult) {
+			mu.Lock()
+			finished++
+			mu.Unlock()
+		},
+		OnError: func(string, error) {
+			mu.Lock()
+			failed++
+			mu.Unlock()
+		},
+		OnProgress: func(p Progress) {
+			mu.Lock()
+			last = p
+			mu.Unlock()
+		},
+	}
+
+	var results int
+	for range HashFiles(files, Average, opts) {
+		results++
+	}
+
+	if results != 3 || started != 3 || finished != 2 || failed != 1 {
+		t.Fatalf("results=%d started=%d finished=%d failed=%d",
+			results, started, finished, failed)
+	}
+
+	if last.Done != 3 || last.Failed != 1 {
+		t.Fatalf("final progress: %+v", last)
+	}
+
+	if !strings.Contains(logs.String(), "hash failed") {
+		t.Fatalf("missing failure in log:\n%s", logs.String())
+	}
+}
+
+func TestHashFS(t *testing.T) {
+	gopher, err := os.ReadFile("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		"a/gopher.png":   {Data: gopher},
+		"a/b/GOPHER.PNG": {Data: gopher},
+		"a/b/broken.jpg": {Data: []byte("not a jpeg")},
+		"a/noext":        {Data: gopher},
+		"notes.txt":      {Data: []byte("hello")},
+		"c/empty/.keep":  {},
+		"c/small.gif":    {Data: []byte("GIF8")},
+	}
+
+	hashFS := func(opts *BatchOptions) (files []string, failed []string) {
+		for r := range HashFS(context.Background(), fsys, Average, opts) {
+			if r.Err != nil {
+				failed = append(failed, r.Path)
+				continue
+			}
+
+			if r.Hash != Average(mustDecode(t, gopher)) {
+				t.Fatalf("%s: unexpected hash %016x", r.Path, r.Hash)
+			}
+
+			files = append(files, r.Path)
+		}
+
+		sort.Strings(files)
+		sort.Strings(failed)
+		return
+	}
+
+	files, failed := hashFS(nil)
+	if strings.Join(files, " ") != "a/b/GOPHER.PNG a/gopher.png" ||
+		strings.Join(failed, " ") != "a/b/broken.jpg c/small.gif" {
+		t.Fatalf("by extension: hashed %v, failed %v", files, failed)
+	}
+
+	files, failed = hashFS(&BatchOptions{Sniff: true, MinSize: 16})
+	if strings.Join(files, " ") != "a/b/GOPHER.PNG a/gopher.png a/noext" || len(failed) != 0 {
+		t.Fatalf("sniffing: hashed %v, failed %v", files, failed)
+	}
+
+	// A cancelled context stops the walk.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for r := range HashFS(ctx, fsys, Average, nil) {
+		t.Fatalf("cancelled walk yielded %s", r.Path)
+	}
+}
+
+func TestHashFSArchives(t *testing.T) {
+	gopher, err := os.ReadFile("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, name := range []string{"img/a.png", "img/b.png", "img/c.png", "notes.txt"} {
+		w, _ := zw.Create(name)
+		if name == "notes.txt" {
+			w.Write([]byte("hello"))
+		} else {
+			w.Write(gopher)
+		}
+	}
+	zw.Close()
+
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"../up.png", "/abs.png", "noext", "broken.jpg"} {
+		data := gopher
+		if name == "broken.jpg" {
+			data = []byte("not a jpeg")
+		}
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+	tw.Close()
+	gz.Close()
+
+	fsys := fstest.MapFS{
+		"gopher.png":      {Data: gopher},
+		"x/assets.zip":    {Data: zbuf.Bytes()},
+		"x/more.tgz":      {Data: tbuf.Bytes()},
+		"x/damaged.zip":   {Data: []byte("PK not really")},
+		"x/damaged.tar":   {Data: []byte("not a tar")},
+		"x/sub/notes.txt": {Data: []byte("hello")},
+	}
+
+	var mu sync.Mutex
+	var running, most int
+	hf := func(img image.Image) uint64 {
+		mu.Lock()
+		if running++; running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return Average(img)
+	}
+
+	var files, failed []string
+	opts := &BatchOptions{Archives: true, ArchiveWorkers: 1, Workers: 4, Sniff: true, Extensions: []string{".png", ".jpg"}}
+	for r := range HashFS(context.Background(), fsys, hf, opts) {
+		if r.Err != nil {
+			failed = append(failed, r.Path)
+			continue
+		}
+
+		files = append(files, r.Path)
+	}
+
+	sort.Strings(files)
+	sort.Strings(failed)
+
+	if strings.Join(files, " ") != "gopher.png x/assets.zip/img/a.png x/assets.zip/img/b.png x/assets.zip/img/c.png "+
+		"x/more.tgz/abs.png x/more.tgz/noext x/more.tgz/up.png" {
+		t.Fatalf("hashed %v", files)
+	}
+
+	if strings.Join(failed, " ") != "x/damaged.tar x/damaged.zip x/more.tgz/broken.jpg" {
+		t.Fatalf("failed %v", failed)
+	}
+
+	// The entries of an archive are hashed one at a time; at most the
+	// loose file runs next to one of them.
+	if most > 2 {
+		t.Fatalf("%d files hashed at once", most)
+	}
+
+	// Without the option, archives are not images.
+	for r := range HashFS(context.Background(), fsys, Average, nil) {
+		if r.Path != "gopher.png" {
+			t.Fatalf("walk without archives yielded %s", r.Path)
+		}
+	}
+}
+
+// countingFS tracks the largest number of files open at once.
+type countingFS struct {
+	fs.FS
+	mu         sync.Mutex
+	open, most int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	fd, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.open++; c.open > c.most {
+		c.most = c.open
+	}
+	c.mu.Unlock()
+
+	return &countingFile{fd, c}, nil
+}
+
+func (c *countingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(c.FS, name)
+}
+
+type countingFile struct {
+	fs.File
+	fs *countingFS
+}
+
+func (f *countingFile) Close() error {
+	f.fs.mu.Lock()
+	f.fs.open--
+	f.fs.mu.Unlock()
+	return f.File.Close()
+}
+
+func TestReadLimits(t *testing.T) {
+	gopher, err := os.ReadFile("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapFS := fstest.MapFS{}
+	for i := 0; i < 8; i++ {
+		mapFS[fmt.Sprintf("%d.png", i)] = &fstest.MapFile{Data: gopher}
+	}
+
+	fsys := &countingFS{FS: mapFS}
+	hashed := 0
+
+	for r := range HashFS(context.Background(), fsys, Average, &BatchOptions{Workers: 4, Readers: 1}) {
+		if r.Err != nil || r.Hash != Average(mustDecode(t, gopher)) {
+			t.Fatalf("%s: %016x, %v", r.Path, r.Hash, r.Err)
+		}
+		hashed++
+	}
+
+	if hashed != 8 || fsys.most != 1 {
+		t.Fatalf("hashed %d files, with up to %d open at once", hashed, fsys.most)
+	}
+
+	// Reading all files takes about a second at this rate.
+	rate := int64(8 * len(gopher))
+	start := time.Now()
+
+	for range HashFS(context.Background(), mapFS, Average, &BatchOptions{Workers: 4, ReadRate: rate}) {
+	}
+
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Fatalf("read %d bytes in %v, at a rate of %d bytes per second", rate, elapsed, rate)
+	}
+}
+
+func TestOrdered(t *testing.T) {
+	gopher, err := os.ReadFile("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{}
+	var want []string
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("%02d.png", i)
+		fsys[name] = &fstest.MapFile{Data: gopher}
+		want = append(want, name)
+	}
+
+	// Random delays make files complete out of order.
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(1))
+
+	slow := func(img image.Image) uint64 {
+		mu.Lock()
+		d := time.Duration(rng.Intn(5)) * time.Millisecond
+		mu.Unlock()
+
+		time.Sleep(d)
+		return Average(img)
+	}
+
+	var got []string
+	for r := range HashFS(context.Background(), fsys, slow, &BatchOptions{Workers: 4, Ordered: true}) {
+		got = append(got, r.Path)
+	}
+
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("results out of order: %v", got)
+	}
+
+	// Cancelling an ordered batch does not deadlock.
+	ctx, cancel := context.WithCancel(context.Background())
+	for range HashFS(ctx, fsys, slow, &BatchOptions{Workers: 2, Ordered: true}) {
+		cancel()
+	}
+	cancel()
+
+	// A failure stops a FailFast batch, and the jobs workers drop then
+	// leave gaps in the order. The files hashed past them are still
+	// delivered, in order.
+	fsys["17.png"] = &fstest.MapFile{Data: []byte("not an image")}
+
+	var finished []string
+	opts := &BatchOptions{
+		Workers:  4,
+		Ordered:  true,
+		FailFast: true,
+		OnFinish: func(r *BatchResult) {
+			mu.Lock()
+			finished = append(finished, r.Path)
+			mu.Unlock()
+		},
+	}
+
+	got = got[:0]
+	for r := range HashFS(context.Background(), fsys, slow, opts) {
+		if len(got) > 0 && r.Path <= got[len(got)-1] {
+			t.Fatalf("%s delivered after %s", r.Path, got[len(got)-1])
+		}
+		got = append(got, r.Path)
+	}
+
+	delivered := strings.Join(got, " ")
+	for _, name := range finished {
+		if !strings.Contains(delivered, name) {
+			t.Errorf("%s hashed, but not delivered: %v", name, got)
+		}
+	}
+}
+
+func TestOrderGaps(t *testing.T) {
+	b := newBatch(context.Background(), nil, nil)
+	slots := make(chan struct{}, 4)
+	results := make(chan *BatchResult, 4)
+
+	for _, seq := range []int{3, 0, 4} {
+		slots <- struct{}{}
+		results <- &BatchResult{seq: seq}
+	}
+	close(results)
+
+	var got []int
+	for r := range b.order(results, slots, 1) {
+		got = append(got, r.seq)
+	}
+
+	if fmt.Sprint(got) != "[0 3 4]" {
+		t.Fatalf("delivered %v", got)
+	}
+
+	if len(slots) != 0 {
+		t.Fatalf("%d slots held", len(slots))
+	}
+}
+
+// mustDecode decodes the given image data.
+func mustDecode(t *testing.T, data []byte) image.Image {
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func TestCache(t *testing.T) {
+	file := t.TempDir() + "/cache"
+
+	cache, err := NewFileCache(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashAll := func(opts *BatchOptions) (cached int) {
+		files := make(chan string, 2)
+		files <- "testdata/gopher_small.png"
+		files <- "testdata/gopher_large.png"
+		close(files)
+
+		for r := range HashFiles(files, Average, opts) {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+
+			if r.Cached {
+				cached++
+			}
+		}
+
+		return
+	}
+
+	opts := &BatchOptions{Cache: cache, Algorithm: "average"}
+	if n := hashAll(opts); n != 0 {
+		t.Fatalf("empty cache yielded %d hits", n)
+	}
+
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload the cache from disk.
+	cache, err = NewFileCache(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Cache = cache
+	if n := hashAll(opts); n != 2 || cache.Len() != 2 {
+		t.Fatalf("expected 2 hits out of 2 entries, got %d out of %d", n, cache.Len())
+	}
+
+	// Other algorithms do not share entries.
+	opts.Algorithm = "other"
+	if n := hashAll(opts); n != 0 {
+		t.Fatalf("other algorithm yielded %d hits", n)
+	}
+
+	// Content keys are independent of the path.
+	opts.ContentKeys = true
+	if n := hashAll(opts); n != 0 || hashAll(opts) != 2 {
+		t.Fatalf("content keys not cached")
+	}
+}
+
+func TestDedupeFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	small, err := os.ReadFile("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large, err := os.ReadFile("testdata/gopher_large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checker bytes.Buffer
+	if err := png.Encode(&checker, checkerboard(image.Rect(0, 0, 64, 64), 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string][]byte{
+		"a.png":      small,
+		"b.png":      small,
+		"c.png":      small,
+		"large.png":  large,
+		"other.png":  checker.Bytes(),
+		"broken.png": []byte("not a png"),
+	}
+
+	files := make(chan string, len(contents)+1)
+	for name, data := range contents {
+		if err := os.WriteFile(dir+"/"+name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		files <- dir + "/" + name
+	}
+
+	// Listed twice.
+	files <- dir + "/a.png"
+	close(files)
+
+	var mu sync.Mutex
+	var decoded int
+	var failed []string
+
+	groups := DedupeFiles(files, Average, MaxDistance, &BatchOptions{
+		OnStart: func(string) {
+			mu.Lock()
+			decoded++
+			mu.Unlock()
+		},
+		OnError: func(path string, err error) {
+			mu.Lock()
+			failed = append(failed, path)
+			mu.Unlock()
+		},
+	})
+
+	// Byte-identical copies are only decoded once.
+	if decoded != 4 {
+		t.Fatalf("expected 4 files to be decoded, got %d", decoded)
+	}
+
+	if len(failed) != 1 || failed[0] != dir+"/broken.png" {
+		t.Fatalf("unexpected failures: %v", failed)
+	}
+
+	if len(groups) != 1 {
+		t.Fatalf("expected a single group, got %d", len(groups))
+	}
+
+	var names []string
+	for _, e := range groups[0] {
+		names = append(names, e.Path[len(dir)+1:])
+	}
+
+	if got := strings.Join(names, " "); got != "a.png b.png c.png large.png" {
+		t.Fatalf("unexpected group: %s", got)
+	}
+}
+
+func TestHashAll(t *testing.T) {
+	list := func(names ...string) <-chan string {
+		files := make(chan string, len(names))
+		for _, name := range names {
+			files <- name
+		}
+		close(files)
+		return files
+	}
+
+	names := []string{"testdata/missing.png", "testdata/gopher_small.png", "testdata/gone.png", "testdata/gopher_large.png"}
+
+	// Collect all errors.
+	results, err := HashAll(context.Background(), list(names...), Average, &BatchOptions{Workers: 2})
+	if len(results) != 4 || err == nil || Classify(err) != FailureRead {
+		t.Fatalf("%d results, %v", len(results), err)
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "missing.png") || !strings.Contains(msg, "gone.png") {
+		t.Fatalf("joined error %q", msg)
+	}
+
+	results, err = HashAll(context.Background(), list(names[1]), Average, nil)
+	if len(results) != 1 || err != nil {
+		t.Fatalf("%d results, %v", len(results), err)
+	}
+
+	// Stop at the first error.
+	results, err = HashAll(context.Background(), list(names...), Average, &BatchOptions{Workers: 1, FailFast: true})
+	if len(results) != 1 || err == nil || !strings.Contains(err.Error(), "missing.png") {
+		t.Fatalf("fail fast: %d results, %v", len(results), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := HashAll(ctx, list(names...), Average, nil); err != context.Canceled {
+		t.Fatalf("cancelled: %v", err)
+	}
+}
+
+func TestBatchTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	fsys := fstest.MapFS{"slow.png": {Data: buf.Bytes()}}
+	slow := func(img image.Image) uint64 {
+		time.Sleep(200 * time.Millisecond)
+		return Average(img)
+	}
+
+	for r := range HashFS(context.Background(), fsys, slow, &BatchOptions{Timeout: 10 * time.Millisecond}) {
+		if Classify(r.Err) != FailureTimeout || !errors.Is(r.Err, ErrTimeout) {
+			t.Fatalf("%s: %v", r.Path, r.Err)
+		}
+	}
+}
+
+// memoryMetrics records the memory accounting of a batch.
+type memoryMetrics struct {
+	nopMetrics
+
+	mu     sync.Mutex
+	stages map[string][]uint64 // Bytes allocated by each stage.
+	peak   uint64
+}
+
+func (m *memoryMetrics) StageAllocated(stage string, bytes, objects uint64) {
+	m.mu.Lock()
+	m.stages[stage] = append(m.stages[stage], bytes)
+	m.mu.Unlock()
+}
+
+func (m *memoryMetrics) MemoryPeaked(bytes uint64) {
+	m.mu.Lock()
+	m.peak = bytes
+	m.mu.Unlock()
+}
+
+func TestAccounting(t *testing.T) {
+	m := &memoryMetrics{stages: make(map[string][]uint64)}
+	SetMetrics(m)
+	defer SetMetrics(nil)
+
+	hashAll := func(opts *BatchOptions) {
+		files := make(chan string, 2)
+		files <- "testdata/gopher_small.png"
+		files <- "testdata/gopher_large.png"
+		close(files)
+
+		if _, err := HashAll(context.Background(), files, Average, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hashAll(&BatchOptions{Workers: 1})
+	if len(m.stages) != 0 || m.peak != 0 {
+		t.Fatalf("accounted without accounting: %v", m.stages)
+	}
+
+	hashAll(&BatchOptions{Workers: 1, Accounting: true})
+
+	for _, stage := range []string{StageOpen, StageDecode, StageHash} {
+		if len(m.stages[stage]) != 2 {
+			t.Fatalf("%s: %v", stage, m.stages[stage])
+		}
+	}
+
+	// Decoding allocates at least the pixels of the image.
+	img, _ := DecodeFile("testdata/gopher_large.png")
+	pixels := uint64(img.Bounds().Dx() * img.Bounds().Dy())
+
+	if d := m.stages[StageDecode]; d[0]+d[1] < pixels {
+		t.Fatalf("decoding allocated %v bytes, for %d pixels", d, pixels)
+	}
+
+	if m.peak == 0 {
+		t.Fatal("no peak reported")
+	}
+}
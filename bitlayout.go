@@ -0,0 +1,83 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "fmt"
+
+// A BitLayout is the order in which the bits of the cells of an 8x8 grid
+// are packed into a 64 bit hash. Bit 0 is the least significant bit, and
+// that of the top left cell in every layout. Consumers which number bits from
+// the most significant one, as string representations tend to, see
+// them in the order of bits.Reverse64.
+type BitLayout int
+
+// Known bit layouts.
+const (
+	// RowMajor puts the cell at column x and row y in bit 8*y+x: the
+	// rows, top to bottom, each left to right. All hashes of this
+	// package use it by default, and Orient expects it.
+	RowMajor BitLayout = iota
+
+	// ColumnMajor puts the cell at column x and row y in bit 8*x+y: the
+	// columns, left to right, each top to bottom.
+	ColumnMajor
+
+	// Interleaved puts the cell at column x and row y in the bit whose
+	// index interleaves the bits of x and y, those of x first: Morton,
+	// or Z-order. Each group of 4 bits is then a 2x2 block of cells, and
+	// each group of 16 a 4x4 block, so quadrants of the image are whole
+	// words of 16 bits.
+	Interleaved
+
+	bitLayouts
+)
+
+var bitLayoutNames = [...]string{"row-major", "column-major", "interleaved"}
+
+func (l BitLayout) String() string {
+	if l < 0 || l >= bitLayouts {
+		return fmt.Sprintf("BitLayout(%d)", int(l))
+	}
+	return bitLayoutNames[l]
+}
+
+// bit returns the index of the bit of the cell at column x and row y.
+func (l BitLayout) bit(x, y int) uint {
+	switch l {
+	case ColumnMajor:
+		return uint(8*x + y)
+
+	case Interleaved:
+		var i uint
+		for k := uint(0); k < 3; k++ {
+			i |= uint(x>>k&1)<<(2*k) | uint(y>>k&1)<<(2*k+1)
+		}
+		return i
+	}
+
+	return uint(8*y + x)
+}
+
+// Relayout returns the hash with its bits moved from one layout to
+// another. Convert hashes from other programs to RowMajor before using
+// Orient on them, or hashes of this package to the layout of another.
+// AverageOptions.Layout computes Average hashes in any layout directly.
+func Relayout(hash uint64, from, to BitLayout) uint64 {
+	if from == to {
+		return hash
+	}
+
+	var out uint64
+	var x, y int
+
+	for y = 0; y < 8; y++ {
+		for x = 0; x < 8; x++ {
+			if hash&(1<<from.bit(x, y)) != 0 {
+				out |= 1 << to.bit(x, y)
+			}
+		}
+	}
+
+	return out
+}
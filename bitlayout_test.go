@@ -0,0 +1,50 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"testing"
+)
+
+func TestRelayout(t *testing.T) {
+	// The cell at column 3, row 1 in each layout.
+	bits := map[BitLayout]uint{RowMajor: 11, ColumnMajor: 25, Interleaved: 7}
+	for l, bit := range bits {
+		if got := Relayout(1<<11, RowMajor, l); got != 1<<bit {
+			t.Fatalf("%v: %#x, want bit %d", l, got, bit)
+		}
+	}
+
+	// The top left quadrant is the low word of an interleaved hash.
+	var quadrant uint64
+	for y := 0; y < 4; y++ {
+		quadrant |= 0xf << uint(8*y)
+	}
+
+	if got := Relayout(quadrant, RowMajor, Interleaved); got != 0xffff {
+		t.Fatalf("quadrant %#x", got)
+	}
+
+	for _, hash := range []uint64{0, ^uint64(0), 0x0123456789abcdef, 1 << 63} {
+		for from := BitLayout(0); from < bitLayouts; from++ {
+			for to := BitLayout(0); to < bitLayouts; to++ {
+				if back := Relayout(Relayout(hash, from, to), to, from); back != hash {
+					t.Fatalf("%#x from %v to %v and back: %#x", hash, from, to, back)
+				}
+			}
+		}
+	}
+
+	img := synth.Shapes(96, 64, 2)
+	for l := BitLayout(0); l < bitLayouts; l++ {
+		if got, want := AverageWith(&AverageOptions{Layout: l})(img), Relayout(Average(img), RowMajor, l); got != want {
+			t.Fatalf("%v: hash %016x, want %016x", l, got, want)
+		}
+	}
+
+	if s := BitLayout(5).String(); s != "BitLayout(5)" {
+		t.Fatalf("unknown layout %q", s)
+	}
+}
@@ -0,0 +1,135 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math"
+	"sort"
+)
+
+// BitStats describes how the bits of a set of hashes are distributed.
+//
+// In a good hash, every bit is set for half of all images, and bits are
+// independent of each other. Bits which are nearly always set or unset,
+// or which always flip together, carry little information. They make
+// unrelated images look more alike than they are, which leads to false
+// positives. Preprocessing, masks and the choice of algorithm all affect
+// this, so it is worth checking on a representative collection.
+type BitStats struct {
+	Count int // Number of hashes analysed.
+
+	// Frequency holds the fraction of hashes with each bit set.
+	Frequency [64]float64
+
+	// Correlation holds the Pearson correlation between each pair of
+	// bits, in the range -1 to 1. It is 0 for bits which never change.
+	Correlation [64][64]float64
+}
+
+// A BitPair identifies two correlated bits.
+type BitPair struct {
+	A, B        int
+	Correlation float64
+}
+
+// AnalyzeBits computes bit statistics over the given hashes.
+func AnalyzeBits(hashes []uint64) *BitStats {
+	s := &BitStats{Count: len(hashes)}
+	if len(hashes) == 0 {
+		return s
+	}
+
+	// Count how often each bit, and each pair of bits, is set.
+	var ones [64]int
+	var both [64][64]int
+	var i, j int
+
+	for _, h := range hashes {
+		for i = 0; i < 64; i++ {
+			if h&(1<<uint(i)) == 0 {
+				continue
+			}
+
+			ones[i]++
+			for j = i; j < 64; j++ {
+				if h&(1<<uint(j)) != 0 {
+					both[i][j]++
+				}
+			}
+		}
+	}
+
+	n := float64(len(hashes))
+	for i = 0; i < 64; i++ {
+		s.Frequency[i] = float64(ones[i]) / n
+	}
+
+	// For binary variables, the Pearson correlation is the phi coefficient.
+	for i = 0; i < 64; i++ {
+		for j = i; j < 64; j++ {
+			pi, pj := s.Frequency[i], s.Frequency[j]
+			dev := math.Sqrt(pi * (1 - pi) * pj * (1 - pj))
+			if dev == 0 {
+				continue
+			}
+
+			c := (float64(both[i][j])/n - pi*pj) / dev
+			s.Correlation[i][j] = c
+			s.Correlation[j][i] = c
+		}
+	}
+
+	return s
+}
+
+// Entropy returns the sum of the entropies of the individual bits. A
+// hash with unbiased bits scores 64. Since correlations are not taken
+// into account, this is an upper bound on the information in the hashes.
+func (s *BitStats) Entropy() float64 {
+	var sum float64
+
+	for _, p := range s.Frequency {
+		if p > 0 && p < 1 {
+			sum -= p*math.Log2(p) + (1-p)*math.Log2(1-p)
+		}
+	}
+
+	return sum
+}
+
+// Biased returns the bits whose frequency differs from 0.5 by more
+// than the given tolerance. Biased(0.4) yields the bits which are set
+// for less than 10%, or more than 90% of all hashes.
+func (s *BitStats) Biased(tolerance float64) []int {
+	var bits []int
+
+	for i, p := range s.Frequency {
+		if math.Abs(p-0.5) > tolerance {
+			bits = append(bits, i)
+		}
+	}
+
+	return bits
+}
+
+// Correlated returns the pairs of distinct bits whose correlation is at
+// least the given threshold in magnitude, strongest first.
+func (s *BitStats) Correlated(threshold float64) []BitPair {
+	var pairs []BitPair
+	var i, j int
+
+	for i = 0; i < 64; i++ {
+		for j = i + 1; j < 64; j++ {
+			if c := s.Correlation[i][j]; math.Abs(c) >= threshold {
+				pairs = append(pairs, BitPair{i, j, c})
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return math.Abs(pairs[i].Correlation) > math.Abs(pairs[j].Correlation)
+	})
+
+	return pairs
+}
@@ -0,0 +1,60 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestAnalyzeBits(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hashes := make([]uint64, 5000)
+
+	// Bit 0 is always set, bit 2 copies bit 1,
+	// and bit 4 is the inverse of bit 3.
+	for i := range hashes {
+		h := rng.Uint64() | 1
+		h &^= 0x14
+		h |= (h & 2) << 1
+		if h&8 == 0 {
+			h |= 16
+		}
+		hashes[i] = h
+	}
+
+	s := AnalyzeBits(hashes)
+
+	if s.Count != len(hashes) || s.Frequency[0] != 1 {
+		t.Fatalf("count %d, frequency %f", s.Count, s.Frequency[0])
+	}
+
+	if b := s.Biased(0.4); len(b) != 1 || b[0] != 0 {
+		t.Fatalf("biased bits %v", b)
+	}
+
+	pairs := s.Correlated(0.5)
+	if len(pairs) != 2 {
+		t.Fatalf("correlated pairs %v", pairs)
+	}
+
+	for _, p := range pairs {
+		switch {
+		case p.A == 1 && p.B == 2 && math.Abs(p.Correlation-1) < 1e-9:
+		case p.A == 3 && p.B == 4 && math.Abs(p.Correlation+1) < 1e-9:
+		default:
+			t.Fatalf("unexpected pair %+v", p)
+		}
+	}
+
+	// Bit 0 carries nothing, the other 63 bits nearly one bit each.
+	if e := s.Entropy(); e < 62.9 || e > 63 {
+		t.Fatalf("entropy %f", e)
+	}
+
+	if AnalyzeBits(nil).Entropy() != 0 {
+		t.Fatal("entropy of no hashes")
+	}
+}
@@ -0,0 +1,152 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"crypto/subtle"
+	"errors"
+	"math/bits"
+	"time"
+)
+
+// ErrNoVerifier is returned by NewBlocklist for options without Verify.
+var ErrNoVerifier = errors.New("imghash: blocklist needs a verifier")
+
+// BlocklistOptions configures a Blocklist. Unlike elsewhere in this
+// package, nothing defaults to a heuristic: every decision is one of
+// the fields below.
+type BlocklistOptions struct {
+	// Distance is the largest Hamming Distance, in bits, at which a hash
+	// is a candidate match of an entry. Zero only matches identical
+	// hashes. No Thresholds or Verdicts apply.
+	Distance uint64
+
+	// Verify confirms or rejects each candidate match, by means other
+	// than the hash: a second algorithm, a cryptographic hash, or a
+	// queue for human review. It is required. A match is only reported
+	// once Verify confirmed it; an error rejects it.
+	Verify func(BlocklistMatch) (bool, error)
+
+	// Audit is called for every check, match or not, before Check
+	// returns. It may be nil.
+	Audit func(AuditRecord)
+}
+
+// A BlocklistMatch is a candidate match of a checked hash against an
+// entry of a Blocklist.
+type BlocklistMatch struct {
+	Subject   string // What was checked, as passed to Check.
+	Hash      uint64 // Hash of the subject.
+	Entry     string // ID of the entry matched.
+	EntryHash uint64 // Hash of the entry.
+	Distance  uint64 // Hamming Distance between both hashes.
+}
+
+// An AuditRecord describes a single check against a Blocklist.
+type AuditRecord struct {
+	Time      time.Time
+	Subject   string
+	Hash      uint64
+	Candidate bool           // A list entry was within the distance.
+	Match     BlocklistMatch // The candidate, if there was one.
+	Verified  bool           // Verify confirmed the candidate.
+	Err       error          // Error returned by Verify, if any.
+}
+
+// A Blocklist matches hashes against a list of known hashes, such as the
+// lists of abuse material shared between content-safety teams. It makes
+// the guarantees such lists call for explicit:
+//
+//   - A hash matches at the configured distance in bits, and no other.
+//   - Every candidate match goes through the Verify hook before it is
+//     reported.
+//   - Every check is reported to the Audit hook, if set.
+//   - Each check compares the hash with every entry, without branches on
+//     their distances, so its duration says nothing about whether, or
+//     where in the list, the hash matched. Verification and auditing
+//     happen after the scan, and are not constant-time.
+//
+// A Blocklist is safe for concurrent use, provided its hooks are.
+type Blocklist struct {
+	ids    []string
+	hashes []uint64
+	opts   BlocklistOptions
+}
+
+// NewBlocklist creates a Blocklist of the given entries, whose paths
+// serve as their IDs. It returns ErrNoVerifier if opts has no Verify
+// hook.
+func NewBlocklist(entries []*Entry, opts *BlocklistOptions) (*Blocklist, error) {
+	if opts == nil || opts.Verify == nil {
+		return nil, ErrNoVerifier
+	}
+
+	b := &Blocklist{
+		ids:    make([]string, len(entries)),
+		hashes: make([]uint64, len(entries)),
+		opts:   *opts,
+	}
+
+	for i, e := range entries {
+		b.ids[i], b.hashes[i] = e.Path, e.Hash
+	}
+
+	return b, nil
+}
+
+// Len returns the number of entries in the list.
+func (b *Blocklist) Len() int {
+	return len(b.hashes)
+}
+
+// Check checks the hash of the subject against the list. It returns the
+// verified match, and true, if there is one. Of several candidates, the
+// nearest is verified, and the first of those in the list if several are
+// equally near. An error from Verify is returned along with false.
+func (b *Blocklist) Check(subject string, hash uint64) (BlocklistMatch, bool, error) {
+	i, dist, candidate := b.scan(hash)
+
+	rec := AuditRecord{Time: time.Now(), Subject: subject, Hash: hash, Candidate: candidate}
+
+	if candidate {
+		rec.Match = BlocklistMatch{
+			Subject:   subject,
+			Hash:      hash,
+			Entry:     b.ids[i],
+			EntryHash: b.hashes[i],
+			Distance:  dist,
+		}
+
+		rec.Verified, rec.Err = b.opts.Verify(rec.Match)
+		rec.Verified = rec.Verified && rec.Err == nil
+	}
+
+	if b.opts.Audit != nil {
+		b.opts.Audit(rec)
+	}
+
+	return rec.Match, rec.Verified, rec.Err
+}
+
+// scan finds the nearest entry to the hash, and whether it is within the
+// distance, in constant time for a list of a given length.
+func (b *Blocklist) scan(hash uint64) (int, uint64, bool) {
+	best, bestDist := 0, 65
+
+	for i, h := range b.hashes {
+		d := bits.OnesCount64(h ^ hash)
+
+		// Take entry i if it is nearer than the best so far.
+		nearer := 1 - subtle.ConstantTimeLessOrEq(bestDist, d)
+		best = subtle.ConstantTimeSelect(nearer, i, best)
+		bestDist = subtle.ConstantTimeSelect(nearer, d, bestDist)
+	}
+
+	limit := 64
+	if b.opts.Distance < 64 {
+		limit = int(b.opts.Distance)
+	}
+
+	return best, uint64(bestDist), subtle.ConstantTimeLessOrEq(bestDist, limit) == 1
+}
@@ -0,0 +1,81 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	entries := []*Entry{
+		{Path: "a", Hash: 0xff00},
+		{Path: "b", Hash: 0x00ff},
+		{Path: "c", Hash: 0x00fe},
+	}
+
+	if _, err := NewBlocklist(entries, &BlocklistOptions{Distance: 3}); err != ErrNoVerifier {
+		t.Fatalf("list without verifier: %v", err)
+	}
+
+	var audit []AuditRecord
+	var verified []string
+	errReview := errors.New("review failed")
+
+	b, err := NewBlocklist(entries, &BlocklistOptions{
+		Distance: 2,
+		Verify: func(m BlocklistMatch) (bool, error) {
+			verified = append(verified, m.Subject)
+			switch m.Subject {
+			case "rejected":
+				return false, nil
+			case "failed":
+				return true, errReview
+			}
+			return true, nil
+		},
+		Audit: func(r AuditRecord) { audit = append(audit, r) },
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subject string
+		hash    uint64
+		entry   string
+		ok      bool
+		err     error
+	}{
+		{"exact", 0xff00, "a", true, nil},
+		{"nearest", 0x00fc, "c", true, nil}, // One bit from c, two from b.
+		{"edge", 0xff03, "a", true, nil},
+		{"beyond", 0xff07, "", false, nil},
+		{"rejected", 0xff00, "a", false, nil},
+		{"failed", 0xff00, "a", false, errReview},
+	}
+
+	for _, tt := range tests {
+		m, ok, err := b.Check(tt.subject, tt.hash)
+		if ok != tt.ok || err != tt.err || m.Entry != tt.entry {
+			t.Fatalf("%s: matched %q, %v, %v", tt.subject, m.Entry, ok, err)
+		}
+	}
+
+	if len(audit) != len(tests) {
+		t.Fatalf("%d checks audited", len(audit))
+	}
+
+	for i, r := range audit {
+		if r.Subject != tests[i].subject || r.Verified != tests[i].ok || r.Candidate != (tests[i].entry != "") {
+			t.Fatalf("audit record %+v", r)
+		}
+	}
+
+	// Hashes beyond the distance never reach the verifier.
+	if len(verified) != 5 {
+		t.Fatalf("verified %v", verified)
+	}
+}
@@ -0,0 +1,200 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// bloomMagic identifies the persistent Bloom filter format.
+const bloomMagic = "IMGHBLM1"
+
+// bloomStripes is the number of locks which serialize BloomFilter.Add.
+const bloomStripes = 64
+
+// ErrInvalidBloomFilter is returned when reading a malformed Bloom filter.
+var ErrInvalidBloomFilter = errors.New("imghash: invalid Bloom filter")
+
+// A BloomFilter remembers which hashes it has seen, in a fixed amount
+// of memory. It never misses a hash which was added, but reports a small
+// fraction of other hashes as seen too.
+//
+// This is meant as a prefilter for ingest pipelines: most images a crawler
+// encounters have an exact copy which was hashed before. Checking the filter
+// first skips the index query for those. A false positive makes a new hash
+// look like a repeat, so the rate should be chosen with the cost of missing
+// an image in mind.
+//
+// A BloomFilter is safe for concurrent use.
+type BloomFilter struct {
+	words []uint64
+	bits  uint64 // Number of bits in words.
+	k     uint64 // Number of bits set per hash.
+
+	locks [bloomStripes]sync.Mutex // Serialize Adds of the same hash.
+}
+
+// NewBloomFilter creates a filter sized for n hashes, with the given
+// false positive rate -- for example 0.01 for 1%. Adding more than n
+// hashes raises the rate. The filter takes about 1.2 bytes per hash
+// at 1%, and 1.8 bytes at 0.1%.
+func NewBloomFilter(n int, rate float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+
+	if rate <= 0 || rate >= 1 {
+		rate = 0.01
+	}
+
+	// The optimal number of bits, and of bits per hash.
+	m := math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+
+	words := (uint64(m) + 63) / 64
+	return &BloomFilter{words: make([]uint64, words), bits: 64 * words, k: uint64(k)}
+}
+
+// Add adds the hash to the filter. It returns true if the hash was
+// possibly added before, and false if it certainly was not. Of
+// concurrent Adds of a new hash, exactly one returns false.
+func (f *BloomFilter) Add(hash uint64) bool {
+	// The bits are set one word at a time. Without the lock, two Adds
+	// of the same hash could each set some of them, and both find the
+	// others set.
+	mu := &f.locks[mix64(hash)%bloomStripes]
+	mu.Lock()
+	defer mu.Unlock()
+
+	seen := true
+
+	f.each(hash, func(word *uint64, bit uint64) bool {
+		for {
+			old := atomic.LoadUint64(word)
+			if old&bit != 0 {
+				return true
+			}
+
+			if atomic.CompareAndSwapUint64(word, old, old|bit) {
+				seen = false
+				return true
+			}
+		}
+	})
+
+	return seen
+}
+
+// Contains returns true if the hash was possibly added to the
+// filter, and false if it certainly was not.
+func (f *BloomFilter) Contains(hash uint64) bool {
+	seen := true
+
+	f.each(hash, func(word *uint64, bit uint64) bool {
+		seen = atomic.LoadUint64(word)&bit != 0
+		return seen
+	})
+
+	return seen
+}
+
+// each calls fn for every bit belonging to the hash, until it
+// returns false. Bit positions are derived by double hashing.
+func (f *BloomFilter) each(hash uint64, fn func(word *uint64, bit uint64) bool) {
+	h1 := mix64(hash)
+	h2 := mix64(hash^0x9e3779b97f4a7c15) | 1
+
+	var i uint64
+	for i = 0; i < f.k; i++ {
+		pos := (h1 + i*h2) % f.bits
+		if !fn(&f.words[pos/64], 1<<(pos%64)) {
+			return
+		}
+	}
+}
+
+// mix64 scrambles the bits of v, as in SplitMix64. Perceptual hashes
+// are far from uniformly distributed, so they can not be used as bit
+// positions directly.
+func mix64(v uint64) uint64 {
+	v = (v ^ v>>30) * 0xbf58476d1ce4e5b9
+	v = (v ^ v>>27) * 0x94d049bb133111eb
+	return v ^ v>>31
+}
+
+// WriteTo writes the filter to w. The format starts with the magic string
+// "IMGHBLM1", followed by the number of bits per hash and the number of
+// 64 bit words, as unsigned varints, and the words, as 8 byte big endian
+// values.
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	var buf [binary.MaxVarintLen64]byte
+	io.WriteString(cw, bloomMagic)
+	cw.Write(buf[:binary.PutUvarint(buf[:], f.k)])
+	cw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(f.words)))])
+
+	for i := range f.words {
+		binary.BigEndian.PutUint64(buf[:], atomic.LoadUint64(&f.words[i]))
+		cw.Write(buf[:8])
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// ReadBloomFilter reads a filter written by WriteTo.
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	cr := &countReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(bloomMagic))
+	if _, err := io.ReadFull(cr, magic); err != nil {
+		return nil, err
+	}
+
+	if string(magic) != bloomMagic {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	k, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Guard against absurd sizes in corrupt files.
+	if k == 0 || k > 64 || n == 0 || n > 1<<32 {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	f := &BloomFilter{bits: 64 * n, k: k}
+
+	var buf [8]byte
+	for ; n > 0; n-- {
+		if _, err = io.ReadFull(cr, buf[:]); err != nil {
+			return nil, err
+		}
+
+		f.words = append(f.words, binary.BigEndian.Uint64(buf[:]))
+	}
+
+	return f, nil
+}
@@ -0,0 +1,83 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	f := NewBloomFilter(n, 0.01)
+
+	// Sequential values, as a stand-in for hashes which are far
+	// from uniformly distributed.
+	for i := uint64(0); i < n; i++ {
+		f.Add(i << 8)
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if !f.Contains(i<<8) || !f.Add(i<<8) {
+			t.Fatalf("missing hash %d", i)
+		}
+	}
+
+	var fp int
+	for i := uint64(n); i < 11*n; i++ {
+		if f.Contains(i << 8) {
+			fp++
+		}
+	}
+
+	if rate := float64(fp) / (10 * n); rate > 0.015 {
+		t.Fatalf("false positive rate %f", rate)
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ReadBloomFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.k != f.k || g.bits != f.bits || !g.Contains(42<<8) {
+		t.Fatalf("unexpected copy: k=%d bits=%d", g.k, g.bits)
+	}
+
+	if _, err := ReadBloomFilter(bytes.NewReader([]byte("IMGHIDX1"))); err != ErrInvalidBloomFilter {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestBloomFilterConcurrentAdd(t *testing.T) {
+	f := NewBloomFilter(1000, 0.01)
+
+	for hash := uint64(0); hash < 200; hash++ {
+		var added int32
+		var wg sync.WaitGroup
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !f.Add(hash) {
+					atomic.AddInt32(&added, 1)
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		// A false positive leaves none finding it new.
+		if added > 1 {
+			t.Fatalf("hash %d: %d Adds found it new", hash, added)
+		}
+	}
+}
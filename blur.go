@@ -0,0 +1,91 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+)
+
+// Blur returns a Filter which blurs the image with a box of the given
+// radius, in pixels. A radius < 1 leaves the image as is.
+//
+// This undoes dithering. GIF stills and icons with few colours render
+// flat areas and gradients as patterns of alternating palette entries,
+// and re-encoding an image shuffles those patterns around. The patterns
+// mostly average out on the 8x8 grid of Average, but not on the finer
+// grids of Screenshot or the 1024 bit hashes. A radius of 1 or 2 is
+// usually enough:
+//
+//	hf := Preprocess(Screenshot(nil), Blur(1))
+func Blur(radius int) Filter {
+	return func(img image.Image) image.Image {
+		if radius < 1 {
+			return img
+		}
+
+		img = bounded(img)
+		rect := img.Bounds()
+		w, h := rect.Dx(), rect.Dy()
+
+		// Premultiplied channels, in row-major order.
+		pix := make([]uint32, 4*w*h)
+
+		var x, y, i int
+		for y = 0; y < h; y++ {
+			for x = 0; x < w; x++ {
+				r, g, b, a := img.At(rect.Min.X+x, rect.Min.Y+y).RGBA()
+				pix[i], pix[i+1], pix[i+2], pix[i+3] = r, g, b, a
+				i += 4
+			}
+		}
+
+		tmp := make([]uint32, len(pix))
+		boxBlur(tmp, pix, w, h, 4, 4*w, radius)
+		boxBlur(pix, tmp, h, w, 4*w, 4, radius)
+
+		out := image.NewRGBA64(rect)
+		for i = range pix {
+			out.Pix[2*i] = uint8(pix[i] >> 8)
+			out.Pix[2*i+1] = uint8(pix[i])
+		}
+
+		return out
+	}
+}
+
+// boxBlur blurs the lines of src into dst, along one axis. There are
+// n lines of length pixels each; step is the distance between pixels
+// in a line, and stride the distance between lines. Pixels past the
+// ends of a line repeat the pixel at the end.
+func boxBlur(dst, src []uint32, length, n, step, stride, radius int) {
+	size := uint32(2*radius + 1)
+
+	var line, x, c, j int
+	for line = 0; line < n; line++ {
+		base := line * stride
+
+		at := func(x int) int {
+			if x < 0 {
+				x = 0
+			} else if x >= length {
+				x = length - 1
+			}
+			return base + x*step
+		}
+
+		for c = 0; c < 4; c++ {
+			// Running sum over the window around x.
+			var sum uint32
+			for j = -radius; j <= radius; j++ {
+				sum += src[at(j)+c]
+			}
+
+			for x = 0; x < length; x++ {
+				dst[base+x*step+c] = sum / size
+				sum += src[at(x+radius+1)+c]
+				sum -= src[at(x-radius)+c]
+			}
+		}
+	}
+}
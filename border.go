@@ -0,0 +1,111 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"image"
+	"sort"
+)
+
+// borderInsets are the fractions BorderCrops cuts off each side of an
+// image, for borders which Border does not find.
+var borderInsets = []struct {
+	name     string
+	fraction float64
+}{
+	{"5%", 0.05},
+	{"10%", 0.10},
+}
+
+// Border finds a border around img: a frame of a single colour, like the
+// white ones photo apps add, along all sides or along two opposite ones.
+// It returns the bounds of the photo inside, in the coordinates of img,
+// or false if there is no border.
+//
+// Borders must differ from the edges of the photo along most of their
+// length. Plain backgrounds which run into the edges of the image, as
+// in product photos, are not taken for borders.
+func Border(img image.Image) (image.Rectangle, bool) {
+	c := newCollage(img)
+	if c == nil {
+		return img.Bounds(), false
+	}
+
+	r, colour, ok := c.frame(image.Rect(0, 0, c.w, c.h))
+	if !ok || colour == nil {
+		return img.Bounds(), false
+	}
+
+	return c.unscale(r), true
+}
+
+// A Crop is a part of an image which may be an original photo, before
+// a border was added around it.
+type Crop struct {
+	Name string // "border" for the one Border finds, or the fraction cut off.
+	Rect image.Rectangle
+}
+
+// BorderCrops returns the parts of img to search for, besides the whole
+// image, to find the originals of photos with a border added: the photo
+// inside the border Border finds, if any, followed by img with 5% and
+// 10% cut off each side. The fixed crops catch borders which are not of
+// a single colour, like blurred copies of the photo, or which Border
+// misses for blending into the photo.
+func BorderCrops(img image.Image) []Crop {
+	var crops []Crop
+	if r, ok := Border(img); ok {
+		crops = append(crops, Crop{"border", r})
+	}
+
+	rect := img.Bounds()
+	for _, in := range borderInsets {
+		dx := int(float64(rect.Dx()) * in.fraction)
+		dy := int(float64(rect.Dy()) * in.fraction)
+
+		crops = append(crops, Crop{in.name, image.Rect(
+			rect.Min.X+dx, rect.Min.Y+dy, rect.Max.X-dx, rect.Max.Y-dy)})
+	}
+
+	return crops
+}
+
+// QueryCrops searches the store for img, and for each of its BorderCrops,
+// all hashed with hf, and merges the results: every ID is listed once,
+// with the smallest distance of any of the searches. This finds the
+// originals of photos which were shared with a border around them. The
+// other way around, copies with borders in the store are only found by
+// a search for the original if their border is narrow.
+//
+// Every crop is another chance for an unrelated image to match, so keep
+// the distance at the near-duplicate threshold or below.
+func QueryCrops(ctx context.Context, s Store, img image.Image, hf HashFunc, distance uint64) (ResultSet, error) {
+	hashes := []uint64{hf(img)}
+	for _, c := range BorderCrops(img) {
+		hashes = append(hashes, hf(Region(img, c.Rect)))
+	}
+
+	var results ResultSet
+	best := make(map[string]int)
+
+	for _, hash := range hashes {
+		rs, err := s.Query(ctx, hash, distance)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range rs {
+			if i, ok := best[r.Path]; !ok {
+				best[r.Path] = len(results)
+				results = append(results, r)
+			} else if r.Distance < results[i].Distance {
+				results[i] = r
+			}
+		}
+	}
+
+	sort.Stable(results)
+	return results, nil
+}
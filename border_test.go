@@ -0,0 +1,95 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"fmt"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// addBorder places photo on a background of the given colour, with the
+// given margins to the left, top, right and bottom.
+func addBorder(photo image.Image, l, t, r, b int, c color.Color) *image.RGBA {
+	rect := photo.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, rect.Dx()+l+r, rect.Dy()+t+b))
+	draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+	draw.Draw(img, rect.Add(image.Pt(l, t)), photo, rect.Min, draw.Src)
+	return img
+}
+
+func TestBorder(t *testing.T) {
+	photo := synth.Shapes(200, 150, 1)
+
+	for _, m := range [][4]int{
+		{12, 12, 12, 12}, // Even.
+		{10, 10, 10, 40}, // Polaroid.
+		{0, 25, 0, 25},   // Padded to a square.
+	} {
+		img := addBorder(photo, m[0], m[1], m[2], m[3], color.White)
+		want := image.Rect(m[0], m[1], m[0]+200, m[1]+150)
+
+		r, ok := Border(img)
+		if !ok {
+			t.Fatalf("%v: no border found", m)
+		}
+
+		d, e := r.Min.Sub(want.Min), r.Max.Sub(want.Max)
+		if d.X*d.X+d.Y*d.Y > 2 || e.X*e.X+e.Y*e.Y > 2 {
+			t.Fatalf("%v: border at %v, want %v", m, r, want)
+		}
+	}
+
+	for name, gen := range synth.Generators {
+		for seed := int64(0); seed < 20; seed++ {
+			if r, ok := Border(gen(200, 150, seed)); ok {
+				t.Fatalf("%s %d: border at %v", name, seed, r)
+			}
+		}
+	}
+}
+
+func TestQueryCrops(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex()
+
+	var photos []image.Image
+	for seed := int64(0); seed < 20; seed++ {
+		photo := synth.Shapes(200, 150, seed)
+		index.Add(fmt.Sprint(seed), Average(photo))
+		photos = append(photos, photo)
+	}
+
+	store := IndexStore(index)
+
+	for i, photo := range photos {
+		// A white border, and one of another photo, which only
+		// the fixed crops cut off.
+		bg := addBorder(synth.Gradient(180, 130, int64(i)), 20, 20, 20, 20, color.White)
+		draw.Draw(bg, photo.Bounds().Add(image.Pt(10, 10)), photo, image.Point{}, draw.Src)
+
+		for _, img := range []image.Image{addBorder(photo, 16, 16, 16, 40, color.White), bg} {
+			rs, err := QueryCrops(ctx, store, img, Average, AverageThresholds.NearDuplicate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rs) == 0 || rs[0].Path != fmt.Sprint(i) {
+				t.Fatalf("photo %d: %v", i, rs)
+			}
+
+			seen := make(map[string]bool)
+			for _, r := range rs {
+				if seen[r.Path] {
+					t.Fatalf("photo %d: %s listed twice", i, r.Path)
+				}
+				seen[r.Path] = true
+			}
+		}
+	}
+}
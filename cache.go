@@ -0,0 +1,207 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ErrInvalidCache is returned when loading a malformed cache file.
+var ErrInvalidCache = errors.New("imghash: invalid cache file")
+
+// cacheHeader is the first line of a cache file.
+const cacheHeader = "# imghash cache v1"
+
+// A Cache stores file hashes between runs, so files which have not
+// changed need not be decoded again. Keys are opaque strings built by
+// the batch functions; they identify both the file and the algorithm.
+//
+// Implementations must be safe for concurrent use. This makes it easy
+// to plug in a key/value store like BoltDB or Redis. FileCache is the
+// default implementation.
+type Cache interface {
+	Get(key string) (hash uint64, ok bool)
+	Put(key string, hash uint64)
+}
+
+// FileCache is a Cache kept in memory, and stored in a flat file.
+type FileCache struct {
+	file    string
+	mu      sync.Mutex
+	entries map[string]uint64
+	dirty   bool
+}
+
+// NewFileCache creates a cache, backed by the given file. Existing
+// entries are loaded from it. A missing file yields an empty cache.
+func NewFileCache(file string) (*FileCache, error) {
+	c := &FileCache{file: file, entries: make(map[string]uint64)}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	r := bufio.NewReader(fd)
+	line, err := r.ReadString('\n')
+	if err != nil || line[:len(line)-1] != cacheHeader {
+		return nil, ErrInvalidCache
+	}
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) < 18 || line[16] != ' ' {
+			return nil, ErrInvalidCache
+		}
+
+		hash, err := strconv.ParseUint(string(line[:16]), 16, 64)
+		if err != nil {
+			return nil, ErrInvalidCache
+		}
+
+		key, err := strconv.Unquote(string(line[17:]))
+		if err != nil {
+			return nil, ErrInvalidCache
+		}
+
+		c.entries[key] = hash
+	}
+
+	return c, nil
+}
+
+// Get returns the hash stored for key.
+func (c *FileCache) Get(key string) (uint64, bool) {
+	c.mu.Lock()
+	hash, ok := c.entries[key]
+	c.mu.Unlock()
+	return hash, ok
+}
+
+// Put stores the hash for key.
+func (c *FileCache) Put(key string, hash uint64) {
+	c.mu.Lock()
+	c.entries[key] = hash
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// Len returns the number of entries in the cache.
+func (c *FileCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Save writes the cache to its file, if it has changed. The file is
+// replaced atomically, so an interrupted run does not corrupt it.
+func (c *FileCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), ".imghash-cache-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintln(w, cacheHeader)
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%016x %s\n", c.entries[key], strconv.Quote(key))
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), c.file); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// computeCached hashes the open file fd through the cache.
+// It returns true if the hash came from the cache.
+func (b *batch) computeCached(ctx context.Context, file string, fd fs.File, hf HashFunc) (uint64, bool, error) {
+	cache := b.opts.Cache
+	var r io.Reader = fd
+	var key string
+
+	if b.opts.ContentKeys {
+		data, err := io.ReadAll(fd)
+		if err != nil {
+			return 0, false, err
+		}
+
+		key = fmt.Sprintf("%s:sha256:%x", b.opts.Algorithm, sha256.Sum256(data))
+		r = bytes.NewReader(data)
+	} else {
+		stat, err := fd.Stat()
+		if err != nil {
+			return 0, false, err
+		}
+
+		key = fmt.Sprintf("%s:file:%d:%d:%s", b.opts.Algorithm,
+			stat.Size(), stat.ModTime().UnixNano(), b.keyPath(file))
+	}
+
+	if hash, ok := cache.Get(key); ok {
+		return hash, true, nil
+	}
+
+	hash, err := ComputeContext(ctx, r, hf)
+	if err != nil {
+		return 0, false, err
+	}
+
+	cache.Put(key, hash)
+	return hash, false, nil
+}
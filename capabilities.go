@@ -0,0 +1,173 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Robustness tells how well hashes hold up to one kind of change.
+type Robustness int
+
+// Known levels of robustness, from least to most.
+const (
+	RobustNone    Robustness = iota // Hashes drift apart with any change.
+	RobustPartial                   // Hashes hold up to small changes.
+	RobustFull                      // Hashes hold up to any change of the kind.
+	robustnesses
+)
+
+var robustnessNames = [...]string{"none", "partial", "full"}
+
+func (r Robustness) String() string {
+	if r < 0 || r >= robustnesses {
+		return fmt.Sprintf("Robustness(%d)", int(r))
+	}
+	return robustnessNames[r]
+}
+
+// Capabilities describe how well the hashes of an algorithm hold up
+// to the changes copies of an image typically go through. Used as a
+// requirement, each field is the least robustness an algorithm must
+// have; the zero value requires nothing.
+type Capabilities struct {
+	Scale    Robustness // Resizing, including changes of aspect ratio.
+	Rotation Robustness // Rotation by any angle; see Orient for multiples of 90 degrees.
+	Crop     Robustness // Cropping, and borders added around the image.
+	Color    Robustness // Changes in brightness, contrast, gamma and colour.
+}
+
+// Capabilities of the built-in algorithms.
+var (
+	AverageCapabilities    = Capabilities{Scale: RobustFull, Color: RobustPartial}
+	DocumentCapabilities   = Capabilities{Scale: RobustFull, Rotation: RobustPartial, Color: RobustFull}
+	ScreenshotCapabilities = Capabilities{Scale: RobustFull, Color: RobustFull}
+)
+
+// capabilityFields lists the fields of Capabilities by name, in the
+// order of String.
+var capabilityFields = []struct {
+	name  string
+	field func(*Capabilities) *Robustness
+}{
+	{"scale", func(c *Capabilities) *Robustness { return &c.Scale }},
+	{"rotation", func(c *Capabilities) *Robustness { return &c.Rotation }},
+	{"crop", func(c *Capabilities) *Robustness { return &c.Crop }},
+	{"color", func(c *Capabilities) *Robustness { return &c.Color }},
+}
+
+// ParseCapabilities parses requirements, as a comma separated list of
+// the changes an algorithm must hold up to: scale, rotation, crop and
+// color. A change is followed by =partial or =full for that level of
+// robustness; partial is the default.
+func ParseCapabilities(s string) (Capabilities, error) {
+	var c Capabilities
+
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); len(f) == 0 {
+			continue
+		}
+
+		kv := strings.SplitN(f, "=", 2)
+		r := RobustPartial
+
+		if len(kv) == 2 {
+			r = parseRobustness(kv[1])
+			if r < 0 {
+				return c, fmt.Errorf("imghash: unknown robustness %q", kv[1])
+			}
+		}
+
+		field := c.field(kv[0])
+		if field == nil {
+			return c, fmt.Errorf("imghash: unknown capability %q", kv[0])
+		}
+
+		*field = r
+	}
+
+	return c, nil
+}
+
+// parseRobustness returns the level with the given name, or -1.
+func parseRobustness(name string) Robustness {
+	for r, n := range robustnessNames {
+		if n == name {
+			return Robustness(r)
+		}
+	}
+	return -1
+}
+
+// field returns the field of c with the given name, or nil.
+func (c *Capabilities) field(name string) *Robustness {
+	for _, f := range capabilityFields {
+		if f.name == name {
+			return f.field(c)
+		}
+	}
+	return nil
+}
+
+// String returns the capabilities in the form ParseCapabilities reads,
+// with the level of every field.
+func (c Capabilities) String() string {
+	fields := make([]string, len(capabilityFields))
+	for i, f := range capabilityFields {
+		fields[i] = f.name + "=" + f.field(&c).String()
+	}
+	return strings.Join(fields, ",")
+}
+
+// Satisfies returns true if c is at least as robust as req, in every
+// field.
+func (c Capabilities) Satisfies(req Capabilities) bool {
+	for _, f := range capabilityFields {
+		if *f.field(&c) < *f.field(&req) {
+			return false
+		}
+	}
+	return true
+}
+
+// excess returns by how many levels c exceeds req, over all fields.
+func (c Capabilities) excess(req Capabilities) int {
+	var n int
+	for _, f := range capabilityFields {
+		n += int(*f.field(&c) - *f.field(&req))
+	}
+	return n
+}
+
+// MatchAlgorithms returns the names of the registered algorithms whose
+// capabilities satisfy the requirements, best match first. That is the
+// one exceeding them the least: every invariance costs some ability to
+// tell distinct images apart. Ties are sorted by name.
+func MatchAlgorithms(req Capabilities) []string {
+	type match struct {
+		name   string
+		excess int
+	}
+
+	var matches []match
+	for _, name := range Algorithms() {
+		a, err := LookupAlgorithm(name)
+		if err != nil || !a.Capabilities.Satisfies(req) {
+			continue
+		}
+
+		matches = append(matches, match{name, a.Capabilities.excess(req)})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].excess < matches[j].excess })
+
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.name
+	}
+
+	return names
+}
@@ -0,0 +1,226 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ErrInvalidCheckpoint is returned when opening a malformed checkpoint file.
+var ErrInvalidCheckpoint = errors.New("imghash: invalid checkpoint file")
+
+// checkpointHeader is the first line of a checkpoint file.
+const checkpointHeader = "# imghash checkpoint v1"
+
+// A Checkpoint records the progress of a batch in a file, so a batch which
+// is interrupted -- by a crash, a reboot or a lost mount -- can resume where
+// it left off. Every hashed file is appended to the file as soon as it is
+// done. Running the same batch again with the same checkpoint skips all
+// files recorded in it, as long as their size and modification time have
+// not changed. DedupeFiles records the SHA-256 of every file as well.
+//
+// Set BatchOptions.Checkpoint to use one. Once the batch has finished,
+// call Remove, so the next batch starts from scratch. Unlike a Cache,
+// a checkpoint is only meant to last until its batch is complete.
+//
+// Records are written to the operating system right away, so they survive
+// the process being killed. A record cut short by a power failure is
+// dropped when the checkpoint is opened again.
+//
+// A Checkpoint is safe for concurrent use.
+type Checkpoint struct {
+	mu      sync.Mutex
+	fd      *os.File
+	w       *bufio.Writer
+	err     error
+	hashes  map[string]uint64
+	digests map[string][sha256.Size]byte
+}
+
+// OpenCheckpoint opens the given checkpoint file, and loads the records
+// in it. A missing file is created.
+func OpenCheckpoint(file string) (*Checkpoint, error) {
+	fd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoint{
+		fd:      fd,
+		hashes:  make(map[string]uint64),
+		digests: make(map[string][sha256.Size]byte),
+	}
+
+	if err = c.load(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	c.w = bufio.NewWriter(fd)
+	return c, nil
+}
+
+// load reads all records, and positions the file after the last
+// complete one. A new file gets a header.
+func (c *Checkpoint) load() error {
+	r := bufio.NewReader(c.fd)
+
+	header, err := r.ReadString('\n')
+	if err == io.EOF && len(header) == 0 {
+		_, err = fmt.Fprintln(c.fd, checkpointHeader)
+		return err
+	}
+
+	if err != nil || header[:len(header)-1] != checkpointHeader {
+		return ErrInvalidCheckpoint
+	}
+
+	offset := int64(len(header))
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line was cut short; drop it.
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !c.parse(bytes.TrimSuffix(line, []byte("\n"))) {
+			return ErrInvalidCheckpoint
+		}
+
+		offset += int64(len(line))
+	}
+
+	if err := c.fd.Truncate(offset); err != nil {
+		return err
+	}
+
+	_, err = c.fd.Seek(offset, io.SeekStart)
+	return err
+}
+
+// parse parses a single record. Hashes are written as "h", followed by
+// the hash and the key. Digests are written as "d", followed by the
+// digest and the key.
+func (c *Checkpoint) parse(line []byte) bool {
+	fields := bytes.SplitN(line, []byte(" "), 3)
+	if len(fields) != 3 {
+		return false
+	}
+
+	key, err := strconv.Unquote(string(fields[2]))
+	if err != nil {
+		return false
+	}
+
+	switch string(fields[0]) {
+	case "h":
+		hash, err := strconv.ParseUint(string(fields[1]), 16, 64)
+		if err != nil {
+			return false
+		}
+		c.hashes[key] = hash
+
+	case "d":
+		var sum [sha256.Size]byte
+		if n, err := hex.Decode(sum[:], fields[1]); err != nil || n != len(sum) {
+			return false
+		}
+		c.digests[key] = sum
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Len returns the number of files recorded.
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.hashes) + len(c.digests)
+}
+
+// hash returns the hash recorded for key.
+func (c *Checkpoint) hash(key string) (uint64, bool) {
+	c.mu.Lock()
+	hash, ok := c.hashes[key]
+	c.mu.Unlock()
+	return hash, ok
+}
+
+// putHash records the hash for key.
+func (c *Checkpoint) putHash(key string, hash uint64) {
+	c.mu.Lock()
+	c.hashes[key] = hash
+	c.write("h %016x %s\n", hash, strconv.Quote(key))
+	c.mu.Unlock()
+}
+
+// digest returns the digest recorded for key.
+func (c *Checkpoint) digest(key string) ([sha256.Size]byte, bool) {
+	c.mu.Lock()
+	sum, ok := c.digests[key]
+	c.mu.Unlock()
+	return sum, ok
+}
+
+// putDigest records the digest for key.
+func (c *Checkpoint) putDigest(key string, sum [sha256.Size]byte) {
+	c.mu.Lock()
+	c.digests[key] = sum
+	c.write("d %x %s\n", sum, strconv.Quote(key))
+	c.mu.Unlock()
+}
+
+// write appends a record, with the lock held. The first error is kept,
+// and returned by Close; later records are only kept in memory.
+func (c *Checkpoint) write(format string, args ...interface{}) {
+	if c.err != nil {
+		return
+	}
+
+	fmt.Fprintf(c.w, format, args...)
+	c.err = c.w.Flush()
+}
+
+// Close closes the checkpoint file. It returns the first error which
+// occurred while writing to it.
+func (c *Checkpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.fd.Close(); c.err == nil {
+		c.err = err
+	}
+
+	return c.err
+}
+
+// Remove closes and deletes the checkpoint file.
+func (c *Checkpoint) Remove() error {
+	c.Close()
+	return os.Remove(c.fd.Name())
+}
+
+// checkpointKey returns the key for a file, from its size and
+// modification time, and an optional prefix.
+func checkpointKey(prefix, file string, stat fs.FileInfo) string {
+	return fmt.Sprintf("%s:%d:%d:%s", prefix, stat.Size(), stat.ModTime().UnixNano(), file)
+}
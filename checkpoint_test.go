@@ -0,0 +1,90 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	file := t.TempDir() + "/checkpoint"
+
+	hashAll := func(cp *Checkpoint) (cached int) {
+		files := make(chan string, 2)
+		files <- "testdata/gopher_small.png"
+		files <- "testdata/gopher_large.png"
+		close(files)
+
+		for r := range HashFiles(files, Average, &BatchOptions{Checkpoint: cp, Algorithm: "average"}) {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+
+			if r.Cached {
+				cached++
+			}
+		}
+
+		return
+	}
+
+	cp, err := OpenCheckpoint(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := hashAll(cp); n != 0 || cp.Len() != 2 {
+		t.Fatalf("new checkpoint yielded %d hits, %d entries", n, cp.Len())
+	}
+
+	// Simulate a crash halfway through writing a record. Records
+	// are on disk without closing the checkpoint.
+	fd, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.WriteString("h 00ff")
+	fd.Close()
+
+	if cp, err = OpenCheckpoint(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := hashAll(cp); n != 2 || cp.Len() != 2 {
+		t.Fatalf("resumed checkpoint yielded %d hits, %d entries", n, cp.Len())
+	}
+
+	// Deduplication records digests too.
+	files := make(chan string, 1)
+	files <- "testdata/gopher_small.png"
+	close(files)
+
+	DedupeFiles(files, Average, 0, &BatchOptions{Checkpoint: cp, Algorithm: "average"})
+	if cp.Len() != 3 {
+		t.Fatalf("expected a digest to be recorded, got %d entries", cp.Len())
+	}
+
+	if err := cp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cp, err = OpenCheckpoint(file); err != nil || cp.Len() != 3 {
+		t.Fatalf("reopened checkpoint: %v", err)
+	}
+
+	if err := cp.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint not removed: %v", err)
+	}
+
+	// Other files are not mistaken for a checkpoint.
+	os.WriteFile(file, []byte("# imghash cache v1\n"), 0644)
+	if _, err := OpenCheckpoint(file); err != ErrInvalidCheckpoint {
+		t.Fatalf("cache file accepted as checkpoint: %v", err)
+	}
+}
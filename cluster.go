@@ -0,0 +1,425 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// clustersMagic identifies the persistent format of Clusters.
+const clustersMagic = "IMGHCLS1"
+
+// ErrInvalidClusters is returned when loading a malformed clusters file.
+var ErrInvalidClusters = errors.New("imghash: invalid clusters file")
+
+// Cluster groups the given entries into clusters of near-duplicates.
+// Two entries end up in the same cluster if their hashes are within the
+// given Hamming Distance of each other, or if they are linked through a
+// chain of such entries.
+//
+// Entry paths are expected to be unique.
+//
+// Entries without any near-duplicates are returned as clusters of one.
+// Clusters are sorted by the path of their first entry. The entries in
+// each cluster are sorted by path.
+func Cluster(entries []*Entry, distance uint64) [][]*Entry {
+	index := NewIndex()
+	byPath := make(map[string]int, len(entries))
+
+	for i, e := range entries {
+		index.Add(e.Path, e.Hash)
+		byPath[e.Path] = i
+	}
+
+	// Union-find over entry indices.
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i, e := range entries {
+		for _, r := range index.Query(e.Hash, distance) {
+			a, b := find(i), find(byPath[r.Path])
+			if a != b {
+				parent[b] = a
+			}
+		}
+	}
+
+	groups := make(map[int][]*Entry)
+	for i, e := range entries {
+		root := find(i)
+		groups[root] = append(groups[root], e)
+	}
+
+	clusters := make([][]*Entry, 0, len(groups))
+	for _, g := range groups {
+		sort.Slice(g, func(i, j int) bool { return g[i].Path < g[j].Path })
+		clusters = append(clusters, g)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0].Path < clusters[j][0].Path
+	})
+
+	return clusters
+}
+
+// Clusters keeps the clusters of near-duplicates among the entries of an
+// Index, as Cluster groups them, up to date as entries are added. A new
+// entry joins the cluster of the entries within the distance of it, or
+// starts a cluster of its own; if it links several clusters, they are
+// merged. The result is the same as clustering all entries again.
+//
+// Each cluster is named by a label: the ID of its oldest member. Saved
+// alongside the index, the clusters need only be updated with the
+// entries added since, rather than made again from scratch.
+//
+// Removing an entry never splits its cluster, even if it was the only
+// link between its other members. Clusters which must stay exact across
+// removals need to be made again from time to time.
+//
+// Clusters is not safe for concurrent use.
+type Clusters struct {
+	index    *Index
+	distance uint64
+
+	label   map[string]string   // Label of the cluster of each ID.
+	members map[string][]string // Members of each cluster, oldest first.
+}
+
+// NewClusters creates an empty set of clusters of the entries of the
+// index, at the given Hamming Distance. Call Update to cluster the
+// entries already in the index, or Load those saved before.
+func NewClusters(x *Index, distance uint64) *Clusters {
+	return &Clusters{
+		index:    x,
+		distance: distance,
+		label:    make(map[string]string),
+		members:  make(map[string][]string),
+	}
+}
+
+// Len returns the number of clusters.
+func (c *Clusters) Len() int {
+	return len(c.members)
+}
+
+// Labels returns the labels of all clusters, sorted.
+func (c *Clusters) Labels() []string {
+	labels := make([]string, 0, len(c.members))
+	for l := range c.members {
+		labels = append(labels, l)
+	}
+
+	sort.Strings(labels)
+	return labels
+}
+
+// Cluster returns the label of the cluster of the given ID.
+func (c *Clusters) Cluster(id string) (string, bool) {
+	l, ok := c.label[id]
+	return l, ok
+}
+
+// Members returns the IDs in the cluster with the given label, sorted.
+func (c *Clusters) Members(label string) []string {
+	ids := append([]string(nil), c.members[label]...)
+	sort.Strings(ids)
+	return ids
+}
+
+// Add adds the ID and hash to the index, and to its cluster. It returns
+// the label of that cluster, and those of the clusters which were merged
+// into it, if any. The members of those now carry the returned label.
+func (c *Clusters) Add(id string, hash uint64) (label string, merged []string) {
+	if old, ok := c.index.Hash(id); ok {
+		if l, ok := c.label[id]; ok && old == hash {
+			return l, nil
+		}
+		c.Remove(id)
+	}
+
+	c.index.Add(id, hash)
+	return c.assign(id, hash)
+}
+
+// Remove removes the ID from the index and from its cluster. A cluster
+// whose label is removed is named after its oldest remaining member.
+func (c *Clusters) Remove(id string) {
+	c.index.Remove(id)
+	c.unassign(id)
+}
+
+// Update brings the clusters in line with the index: it clusters the
+// entries which are in no cluster yet, in order of their IDs, and drops
+// from the clusters those which are no longer in the index. It returns
+// the number of entries clustered.
+func (c *Clusters) Update() int {
+	for id := range c.label {
+		if _, ok := c.index.Hash(id); !ok {
+			c.unassign(id)
+		}
+	}
+
+	var n int
+	for _, id := range c.index.IDs() {
+		if _, ok := c.label[id]; !ok {
+			hash, _ := c.index.Hash(id)
+			c.assign(id, hash)
+			n++
+		}
+	}
+
+	return n
+}
+
+// assign adds an ID which is in the index to its cluster.
+func (c *Clusters) assign(id string, hash uint64) (string, []string) {
+	// Entries which are in no cluster yet link to this one when they
+	// are assigned themselves.
+	var labels []string
+	seen := make(map[string]bool)
+
+	for _, h := range c.index.Search(hash, c.distance) {
+		if l, ok := c.label[h.ID]; ok && h.ID != id && !seen[l] {
+			seen[l] = true
+			labels = append(labels, l)
+		}
+	}
+
+	if len(labels) == 0 {
+		c.label[id] = id
+		c.members[id] = []string{id}
+		return id, nil
+	}
+
+	// The largest cluster absorbs the others, so the fewest members
+	// change labels.
+	sort.Strings(labels)
+	target := labels[0]
+	for _, l := range labels[1:] {
+		if len(c.members[l]) > len(c.members[target]) {
+			target = l
+		}
+	}
+
+	var merged []string
+	for _, l := range labels {
+		if l == target {
+			continue
+		}
+
+		for _, m := range c.members[l] {
+			c.label[m] = target
+		}
+
+		c.members[target] = append(c.members[target], c.members[l]...)
+		delete(c.members, l)
+		merged = append(merged, l)
+	}
+
+	c.label[id] = target
+	c.members[target] = append(c.members[target], id)
+	return target, merged
+}
+
+// unassign removes the ID from its cluster, if it is in one.
+func (c *Clusters) unassign(id string) {
+	l, ok := c.label[id]
+	if !ok {
+		return
+	}
+
+	delete(c.label, id)
+
+	members := c.members[l]
+	for i, m := range members {
+		if m == id {
+			members = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+
+	delete(c.members, l)
+	if len(members) == 0 {
+		return
+	}
+
+	if l == id {
+		l = members[0]
+		for _, m := range members {
+			c.label[m] = l
+		}
+	}
+
+	c.members[l] = members
+}
+
+// WriteTo writes the clusters to w, in a compact binary format.
+//
+// The format starts with the magic string "IMGHCLS1", followed by the
+// distance and the number of clusters. Each cluster holds its label, the
+// number of its members and their IDs, oldest first. Strings are prefixed
+// by their length and all lengths and counts are stored as unsigned
+// varints. Clusters are written in label order.
+func (c *Clusters) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		cw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		io.WriteString(cw, s)
+	}
+
+	io.WriteString(cw, clustersMagic)
+	putUvarint(c.distance)
+	putUvarint(uint64(len(c.members)))
+
+	for _, l := range c.Labels() {
+		putString(l)
+		putUvarint(uint64(len(c.members[l])))
+
+		for _, m := range c.members[l] {
+			putString(m)
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// ReadFrom reads clusters written by WriteTo from r, in place of the
+// ones held so far. They must have been made at the same distance. IDs
+// which are no longer in the index are left out; call Update to cluster
+// the entries added to it since.
+func (c *Clusters) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(clustersMagic))
+	if _, err := io.ReadFull(cr, magic); err != nil {
+		return cr.n, err
+	}
+
+	if string(magic) != clustersMagic {
+		return cr.n, ErrInvalidClusters
+	}
+
+	distance, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return cr.n, err
+	}
+
+	if distance != c.distance {
+		return cr.n, fmt.Errorf("imghash: clusters were made at distance %d, not %d", distance, c.distance)
+	}
+
+	count, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return cr.n, err
+	}
+
+	c.label = make(map[string]string)
+	c.members = make(map[string][]string)
+
+	for ; count > 0; count-- {
+		l, err := readString(cr)
+		if err != nil {
+			return cr.n, err
+		}
+
+		n, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return cr.n, err
+		}
+
+		var members []string
+		for ; n > 0; n-- {
+			m, err := readString(cr)
+			if err != nil {
+				return cr.n, err
+			}
+
+			if _, ok := c.label[m]; ok {
+				return cr.n, ErrInvalidClusters
+			}
+
+			if _, ok := c.index.Hash(m); ok {
+				c.label[m] = l
+				members = append(members, m)
+			}
+		}
+
+		if len(members) == 0 {
+			continue
+		}
+
+		if _, ok := c.members[l]; ok {
+			return cr.n, ErrInvalidClusters
+		}
+
+		// Name clusters whose label was removed after their oldest
+		// remaining member, as Remove does.
+		if c.label[l] != l {
+			for _, m := range members {
+				c.label[m] = members[0]
+			}
+			l = members[0]
+		}
+
+		c.members[l] = members
+	}
+
+	return cr.n, nil
+}
+
+// Save saves the clusters to the given file.
+func (c *Clusters) Save(file string) (err error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return
+	}
+
+	if _, err = c.WriteTo(fd); err != nil {
+		fd.Close()
+		return
+	}
+
+	return fd.Close()
+}
+
+// Load loads clusters from the given file, as ReadFrom does.
+func (c *Clusters) Load(file string) (err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+
+	_, err = c.ReadFrom(fd)
+	return
+}
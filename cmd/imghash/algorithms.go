@@ -0,0 +1,90 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"os"
+)
+
+func init() {
+	register(&command{
+		Name:  "algorithms",
+		Args:  "",
+		Short: "List the hashing algorithms, and the changes their hashes hold up to.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf(" -require: Only list algorithms which hold up to the given changes,\n" +
+				"           best match first. A comma separated list of scale,\n" +
+				"           rotation, crop and color, each optionally followed by\n" +
+				"           =partial or =full. Partial is the default.\n")
+			formatHelp(9)
+			fmt.Printf("\nThe best match is the algorithm which does the least beyond the\n" +
+				"requirements, since every invariance makes distinct images more alike.\n" +
+				"Its name can be passed to the -a option of the other commands.\n")
+		},
+		Run: runAlgorithms,
+	})
+}
+
+func runAlgorithms(args []string) int {
+	fs := newFlags(commands["algorithms"])
+	require := fs.String("require", "", "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(args) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	req, err := imghash.ParseCapabilities(*require)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	names := imghash.Algorithms()
+	if len(*require) > 0 {
+		names = imghash.MatchAlgorithms(req)
+	}
+
+	if len(names) == 0 {
+		fmt.Fprintf(os.Stderr, "No algorithm holds up to %s.\n", *require)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%-12s scale=%s rotation=%s crop=%s color=%s\n", r.Get("algorithm"),
+			r.Get("scale"), r.Get("rotation"), r.Get("crop"), r.Get("color"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	for _, name := range names {
+		a, err := imghash.LookupAlgorithm(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 1
+		}
+
+		c := a.Capabilities
+		out.Write(record{
+			{"algorithm", name},
+			{"scale", c.Scale.String()},
+			{"rotation", c.Rotation.String()},
+			{"crop", c.Crop.String()},
+			{"color", c.Color.String()},
+		})
+	}
+
+	return 0
+}
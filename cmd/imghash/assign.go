@@ -0,0 +1,186 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/hashlist"
+	"io"
+	"os"
+	"sort"
+)
+
+func init() {
+	register(&command{
+		Name:  "assign",
+		Args:  "<expected> <actual>",
+		Short: "Pair the images of two sets one to one, and list those without a pair.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("         -a: Hashing algorithm to use. Defaults to average.\n"+
+				"             Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("         -t: Hamming Distance within which images are paired.\n" +
+				"             Defaults to the near-duplicate threshold of the algorithm.\n")
+			fmt.Printf("         -q: Skip hashes of lists below this quality. Defaults to 0.\n")
+			batchHelp(11)
+			formatHelp(11)
+			fmt.Printf("\nEach set is a directory of images, or a list of hashes as index load\n" +
+				"reads them. Images are paired such that the most of them have a pair,\n" +
+				"and of those pairings, the one with the least total distance is used.\n" +
+				"Images of the expected set without a pair are missing, those of the\n" +
+				"actual set are extra. The exit status is 2 if there are any.\n")
+		},
+		Run: runAssign,
+	})
+}
+
+func runAssign(args []string) int {
+	fs := newFlags(commands["assign"])
+	algo := fs.String("a", defaultAlgorithm(), "")
+	dist := fs.Int("t", -1, "")
+	quality := fs.Int("q", 0, "")
+	batch := newBatchFlags(fs)
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		switch r.Get("status") {
+		case "match":
+			fmt.Fprintf(w, "%s %s %d\n", r.Get("expected"), r.Get("actual"), r.Get("distance"))
+		case "missing":
+			fmt.Fprintf(w, "- %s\n", r.Get("expected"))
+		default:
+			fmt.Fprintf(w, "+ %s\n", r.Get("actual"))
+		}
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	threshold := a.Thresholds.NearDuplicate
+	if *dist >= 0 {
+		threshold = uint64(*dist)
+	}
+
+	status := 0
+
+	var sets [2][]*imghash.Entry
+	for i, arg := range fs.Args() {
+		var failed bool
+		if sets[i], failed, err = loadSet(arg, a, *algo, *quality, batch); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			return 1
+		}
+
+		if failed {
+			status = 1
+		}
+	}
+
+	expected, actual := sets[0], sets[1]
+	as := imghash.Assign(entryHashes(expected), entryHashes(actual), threshold)
+
+	for _, p := range as.Pairs {
+		out.Write(record{
+			{"expected", expected[p.A].Path},
+			{"actual", actual[p.B].Path},
+			{"distance", p.Distance},
+			{"status", "match"},
+		})
+	}
+
+	for _, i := range as.UnmatchedA {
+		out.Write(record{{"expected", expected[i].Path}, {"status", "missing"}})
+	}
+
+	for _, j := range as.UnmatchedB {
+		out.Write(record{{"actual", actual[j].Path}, {"status", "extra"}})
+	}
+
+	if status == 0 && len(as.UnmatchedA)+len(as.UnmatchedB) > 0 {
+		status = 2
+	}
+
+	return status
+}
+
+// loadSet returns the hashes of the images in the given directory, or
+// those of the given list of hashes, sorted by path. Files of the
+// directory which can not be hashed are reported, and make failed true.
+func loadSet(file string, a *imghash.Algorithm, algo string, quality int, batch *batchFlags) (entries []*imghash.Entry, failed bool, err error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !stat.IsDir() {
+		list, err := hashlist.Load(file)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if entries, err = hashlist.Hashes(list, algo, quality); err != nil {
+			return nil, false, err
+		}
+
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+		return entries, false, nil
+	}
+
+	log, err := batch.logger()
+	if err != nil {
+		return nil, false, err
+	}
+
+	cache, err := batch.openCache()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Both sets are hashed in one run, so they can not resume from a
+	// checkpoint of their own.
+	for r := range imghash.HashFiles(walkImages([]string{file}, 0, log), a.Hash, batch.options(algo, log, cache, nil)) {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.Path, r.Err)
+			failed = true
+			continue
+		}
+
+		entries = append(entries, &imghash.Entry{Path: r.Path, Hash: r.Hash})
+	}
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, failed, nil
+}
+
+// entryHashes returns the hashes of the given entries.
+func entryHashes(entries []*imghash.Entry) []uint64 {
+	hashes := make([]uint64, len(entries))
+	for i, e := range entries {
+		hashes[i] = e.Hash
+	}
+	return hashes
+}
@@ -0,0 +1,179 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// batchFlags holds the flags shared by commands which hash
+// entire directory trees.
+type batchFlags struct {
+	workers    *int
+	readers    *int
+	rate       *float64
+	progress   *bool
+	log        *string
+	cache      *string
+	checkpoint *string
+	strictness imghash.Strictness
+}
+
+// newBatchFlags defines the batch flags on the given set.
+func newBatchFlags(fs *flag.FlagSet) *batchFlags {
+	b := &batchFlags{
+		workers:    fs.Int("w", policy.Workers, ""),
+		readers:    fs.Int("readers", policy.Readers, ""),
+		rate:       fs.Float64("rate", 0, ""),
+		progress:   fs.Bool("progress", false, ""),
+		log:        fs.String("log", "", ""),
+		cache:      fs.String("cache", policy.Cache, ""),
+		checkpoint: fs.String("checkpoint", "", ""),
+	}
+
+	fs.TextVar(&b.strictness, "strictness", imghash.Lenient, "")
+	return b
+}
+
+// batchHelp prints the help text for the batch flags.
+// Indent is the width of the flag name column.
+func batchHelp(indent int) {
+	fmt.Printf("%*s: Number of concurrent workers. Defaults to one per CPU.\n", indent, "-w")
+	fmt.Printf("%*s: Number of files read at the same time. Defaults to one per\n"+
+		"%*s  worker. Network shares are often faster with only a few.\n", indent, "-readers", indent, "")
+	fmt.Printf("%*s: Read no more than this many megabytes per second.\n", indent, "-rate")
+	fmt.Printf("%*s: Periodically print progress to stderr.\n", indent, "-progress")
+	fmt.Printf("%*s: Log batch activity to stderr at the given level.\n"+
+		"%*s  One of: debug, info, warn. Disabled by default.\n", indent, "-log", indent, "")
+	fmt.Printf("%*s: Cache file for hashes. Unchanged files are not decoded again.\n", indent, "-cache")
+	fmt.Printf("%*s: Record progress in this file. An interrupted run started\n"+
+		"%*s  again with the same file resumes where it left off. The\n"+
+		"%*s  file is removed once the run completes.\n", indent, "-checkpoint", indent, "", indent, "")
+	fmt.Printf("%*s: How to treat images which take a lossy conversion, like CMYK\n"+
+		"%*s  to RGB: lenient, audit (report them) or strict (fail them).\n"+
+		"%*s  Defaults to lenient.\n", indent, "-strictness", indent, "", indent, "")
+}
+
+// logger returns the logger selected with -log, or nil if there is none.
+func (b *batchFlags) logger() (*slog.Logger, error) {
+	if len(*b.log) == 0 {
+		return nil, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*b.log)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", *b.log)
+	}
+
+	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	return slog.New(h), nil
+}
+
+// openCache loads the cache selected with -cache, or returns nil
+// if there is none.
+func (b *batchFlags) openCache() (*imghash.FileCache, error) {
+	if len(*b.cache) == 0 {
+		return nil, nil
+	}
+
+	return imghash.NewFileCache(*b.cache)
+}
+
+// openCheckpoint opens the checkpoint selected with -checkpoint, or
+// returns nil if there is none.
+func (b *batchFlags) openCheckpoint() (*imghash.Checkpoint, error) {
+	if len(*b.checkpoint) == 0 {
+		return nil, nil
+	}
+
+	return imghash.OpenCheckpoint(*b.checkpoint)
+}
+
+// removeCheckpoint removes the checkpoint once a run has completed.
+// It returns the exit status. Cp may be nil.
+func (b *batchFlags) removeCheckpoint(cp *imghash.Checkpoint) int {
+	if cp == nil {
+		return 0
+	}
+
+	if err := cp.Remove(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *b.checkpoint, err)
+		return 1
+	}
+
+	return 0
+}
+
+// options returns the batch options selected by the flags.
+// Cache and cp may be nil.
+func (b *batchFlags) options(algo string, log *slog.Logger, cache *imghash.FileCache, cp *imghash.Checkpoint) *imghash.BatchOptions {
+	// Filters change the hashes, so cached hashes and checkpoints
+	// must not be shared with runs without them.
+	if len(policy.Preprocess) > 0 {
+		algo += "+" + strings.Join(policy.Preprocess, "+")
+	}
+
+	opts := &imghash.BatchOptions{
+		Workers:    *b.workers,
+		Readers:    *b.readers,
+		ReadRate:   int64(*b.rate * 1e6),
+		Logger:     log,
+		Algorithm:  algo,
+		Checkpoint: cp,
+		Strictness: b.strictness,
+	}
+
+	if b.strictness == imghash.Audit && log == nil {
+		opts.OnFinish = printLosses
+	}
+
+	if cache != nil {
+		opts.Cache = cache
+	}
+
+	if *b.progress {
+		opts.ProgressInterval = 2 * time.Second
+		opts.OnProgress = printProgress
+	}
+
+	return opts
+}
+
+// printLosses writes the lossy conversions of a file to stderr, if
+// it took any.
+func printLosses(r *imghash.BatchResult) {
+	if r.Losses != 0 {
+		fmt.Fprintf(os.Stderr, "%s: lossy conversion: %v\n", r.Path, r.Losses)
+	}
+}
+
+// printProgress writes a progress line to stderr.
+func printProgress(p imghash.Progress) {
+	rate := float64(p.Done) / p.Elapsed.Seconds()
+	fmt.Fprintf(os.Stderr, "* %d file(s) hashed, %d cached, %d failed%s (%.1f/sec)\n",
+		p.Done, p.Cached, p.Failed, failureCauses(p), rate)
+}
+
+// failureCauses lists the number of failures of each kind, like
+// ": 1 unsupported, 2 truncated". It is empty if there are none.
+func failureCauses(p imghash.Progress) string {
+	var causes []string
+	for k := imghash.FailureRead; k <= imghash.FailureLossy; k++ {
+		if n := p.Failures[k]; n > 0 {
+			causes = append(causes, fmt.Sprintf("%d %s", n, k))
+		}
+	}
+
+	if len(causes) == 0 {
+		return ""
+	}
+
+	return ": " + strings.Join(causes, ", ")
+}
@@ -0,0 +1,228 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"time"
+)
+
+func init() {
+	register(&command{
+		Name:  "bench",
+		Args:  "[directory...]",
+		Short: "Measure hashing speed and stability over a set of images.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("      -a: Algorithm to benchmark. Defaults to all of them.\n"+
+				"          Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("      -n: Maximum number of images to use. Defaults to all.\n")
+			fmt.Printf("      -synthetic: Number of synthetic images to generate, instead of\n" +
+				"          reading images from directories.\n")
+			formatHelp(8)
+			fmt.Printf("\nSpeed is measured for decoding and hashing together, and for\n" +
+				"hashing alone. Files are read into memory first, so disk speed\n" +
+				"does not affect the results. Synthetic images are encoded as PNG.\n\n" +
+				"Stability is measured by recompressing each image as a JPEG with\n" +
+				"quality 75, and by scaling it down to half its size. For both, the\n" +
+				"mean and maximum Hamming Distance to the original hash are reported.\n\n" +
+				"The distribution of hash bits is summarised by their entropy, out of\n" +
+				"64; the number of bits set for under 10%% or over 90%% of all images;\n" +
+				"and the number of pairs of bits with a correlation of 0.5 or more.\n")
+		},
+		Run: runBench,
+	})
+}
+
+// benchImage is a single image in the benchmark corpus.
+type benchImage struct {
+	Data     []byte      // Encoded file contents.
+	Img      image.Image // Decoded original.
+	Variants []image.Image
+}
+
+func runBench(args []string) int {
+	fs := newFlags(commands["bench"])
+	algo := fs.String("a", "", "")
+	limit := fs.Int("n", 0, "")
+	synthetic := fs.Int("synthetic", 0, "")
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 && *synthetic <= 0 {
+		fs.Usage()
+		return 1
+	}
+
+	names := imghash.Algorithms()
+	if len(*algo) > 0 {
+		names = []string{*algo}
+	}
+
+	hashes := make(map[string]imghash.HashFunc)
+	for _, name := range names {
+		a, err := findAlgorithm(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		hashes[name] = a.Hash
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%s:\n", r.Get("algorithm"))
+		fmt.Fprintf(w, "  decode+hash: %.1f images/sec, %.2f MB/sec\n",
+			r.Get("images_per_sec"), r.Get("mb_per_sec"))
+		fmt.Fprintf(w, "  hash only:   %.1f images/sec\n", r.Get("hash_images_per_sec"))
+		fmt.Fprintf(w, "  jpeg q75:    %.2f mean, %d max bit flips\n",
+			r.Get("jpeg_mean_distance"), r.Get("jpeg_max_distance"))
+		fmt.Fprintf(w, "  half size:   %.2f mean, %d max bit flips\n",
+			r.Get("scale_mean_distance"), r.Get("scale_max_distance"))
+		fmt.Fprintf(w, "  bits:        %.1f entropy, %d biased, %d correlated\n",
+			r.Get("bit_entropy"), r.Get("biased_bits"), r.Get("correlated_bits"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	var corpus []*benchImage
+	var size int64
+
+	if *synthetic > 0 {
+		corpus, size = syntheticCorpus(*synthetic)
+	} else {
+		corpus, size = loadCorpus(fs.Args(), *limit)
+	}
+
+	if len(corpus) == 0 {
+		fmt.Fprintf(os.Stderr, "No images found.\n")
+		return 1
+	}
+
+	for _, name := range names {
+		hf := hashes[name]
+
+		// Decoding and hashing.
+		start := time.Now()
+		for _, bi := range corpus {
+			imghash.ComputeBytes(bi.Data, hf)
+		}
+		full := time.Since(start).Seconds()
+
+		// Hashing only.
+		hashes := make([]uint64, len(corpus))
+		start = time.Now()
+		for i, bi := range corpus {
+			hashes[i] = hf(bi.Img)
+		}
+		hashOnly := time.Since(start).Seconds()
+
+		// Stability under each variant.
+		var sum [2]uint64
+		var max [2]uint64
+
+		for i, bi := range corpus {
+			for v, img := range bi.Variants {
+				d := imghash.Distance(hashes[i], hf(img))
+				sum[v] += d
+				if d > max[v] {
+					max[v] = d
+				}
+			}
+		}
+
+		bits := imghash.AnalyzeBits(hashes)
+
+		n := float64(len(corpus))
+		out.Write(record{
+			{"algorithm", name},
+			{"images", len(corpus)},
+			{"bytes", size},
+			{"images_per_sec", n / full},
+			{"mb_per_sec", float64(size) / (1 << 20) / full},
+			{"hash_images_per_sec", n / hashOnly},
+			{"jpeg_mean_distance", float64(sum[0]) / n},
+			{"jpeg_max_distance", max[0]},
+			{"scale_mean_distance", float64(sum[1]) / n},
+			{"scale_max_distance", max[1]},
+			{"bit_entropy", bits.Entropy()},
+			{"biased_bits", len(bits.Biased(0.4))},
+			{"correlated_bits", len(bits.Correlated(0.5))},
+		})
+	}
+
+	return 0
+}
+
+// loadCorpus reads and decodes up to limit images from the given
+// directories, and creates their variants. It returns the images and
+// their total size in bytes.
+func loadCorpus(dirs []string, limit int) ([]*benchImage, int64) {
+	var corpus []*benchImage
+	var size int64
+
+	for file := range walkImages(dirs, 0, nil) {
+		if limit > 0 && len(corpus) >= limit {
+			continue // Drain the walker.
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			continue
+		}
+
+		img, err := imghash.Decode(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			continue
+		}
+
+		corpus = append(corpus, newBenchImage(data, img))
+		size += int64(len(data))
+	}
+
+	return corpus, size
+}
+
+// syntheticCorpus generates n images, and encodes them as PNG.
+// It returns the images and their total size in bytes.
+func syntheticCorpus(n int) ([]*benchImage, int64) {
+	var corpus []*benchImage
+	var size int64
+
+	for _, img := range synth.Corpus(n, nil, 1) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			continue
+		}
+
+		corpus = append(corpus, newBenchImage(buf.Bytes(), img))
+		size += int64(buf.Len())
+	}
+
+	return corpus, size
+}
+
+// newBenchImage creates the variants of a decoded image.
+func newBenchImage(data []byte, img image.Image) *benchImage {
+	return &benchImage{
+		Data:     data,
+		Img:      img,
+		Variants: []image.Image{attack.JPEG(75).Apply(img), attack.Resize(0.5).Apply(img)},
+	}
+}
@@ -0,0 +1,225 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	register(&command{
+		Name:  "compare",
+		Args:  "<file> <file>",
+		Short: "Compare two images and tell if they are duplicates.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("        -a: Hashing algorithm to use. Defaults to average.\n"+
+				"            Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("  -heatmap: Write a PNG of the first image to this file, with the\n" +
+				"            tiles which differ from the second image tinted red.\n")
+			fmt.Printf("    -tiles: Number of heatmap tiles along each side. Defaults to 8.\n")
+			fmt.Printf("-thumbnail: The first image is a thumbnail of the second. The\n" +
+				"            second is scaled down to its size the ways thumbnailers\n" +
+				"            do, and compared with the closest of those.\n")
+			fmt.Printf(" -decision: Write the decision to this file as JSON, for keeping a\n" +
+				"            record of it: the hashes, algorithm, filters, thresholds,\n" +
+				"            verdict and the cells whose bits differ.\n")
+			formatHelp(10)
+			fmt.Printf("\nThe verdict is one of: duplicate, near-duplicate or distinct.\n" +
+				"It is based on the recommended thresholds for the selected algorithm.\n" +
+				"The heatmap compares the hashes of each tile, so it shows where\n" +
+				"the images differ. With -thumbnail and average, the thresholds are\n" +
+				"looser, as thumbnails drift further from their originals.\n" +
+				"If the configuration file has a [policy] table, the verdict of its\n" +
+				"rules is reported as policy.\n")
+		},
+		Run: runCompare,
+	})
+}
+
+func runCompare(args []string) int {
+	fs := newFlags(commands["compare"])
+	algo := fs.String("a", defaultAlgorithm(), "")
+	heatmap := fs.String("heatmap", "", "")
+	tiles := fs.Int("tiles", 8, "")
+	thumbnail := fs.Bool("thumbnail", false, "")
+	decision := fs.String("decision", "", "")
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "distance:   %d\n", r.Get("distance"))
+		fmt.Fprintf(w, "similarity: %.2f\n", r.Get("similarity"))
+		fmt.Fprintf(w, "verdict:    %s\n", r.Get("verdict"))
+		if k := r.Get("kernel"); k != nil {
+			fmt.Fprintf(w, "kernel:     %s\n", k)
+		}
+		if p := r.Get("policy"); p != nil {
+			fmt.Fprintf(w, "policy:     %s\n", p)
+		}
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	var images [2]image.Image
+	var hashes [2]uint64
+	for i, file := range fs.Args() {
+		images[i], err = imghash.DecodeFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			return 1
+		}
+
+		hashes[i] = a.Hash(images[i])
+	}
+
+	if len(*heatmap) > 0 {
+		h := imghash.CompareTiles(images[0], images[1], *tiles, *tiles, a.Hash)
+		if err := writePNG(*heatmap, h.Overlay(images[0])); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *heatmap, err)
+			return 1
+		}
+	}
+
+	thresholds := a.Thresholds
+	dist := imghash.Distance(hashes[0], hashes[1])
+
+	// Keep the hash of the second image as scaled by the
+	// kernel closest to the thumbnail.
+	var kernel imghash.Kernel
+	if *thumbnail {
+		for k, hash := range imghash.ThumbnailHashes(images[1], images[0].Bounds().Size(), a.Hash) {
+			if d := imghash.Distance(hashes[0], hash); k == 0 || d < dist {
+				dist, kernel, hashes[1] = d, imghash.Kernel(k), hash
+			}
+		}
+
+		if strings.ToLower(*algo) == "average" {
+			thresholds = imghash.ThumbnailThresholds
+		}
+	}
+
+	r := record{
+		{"file_a", fs.Arg(0)},
+		{"file_b", fs.Arg(1)},
+		{"hash_a", hexHash(hashes[0])},
+		{"hash_b", hexHash(hashes[1])},
+		{"algorithm", *algo},
+		{"distance", dist},
+		{"similarity", imghash.Similarity(hashes[0], hashes[1])},
+		{"verdict", thresholds.Classify(dist).String()},
+	}
+
+	if *thumbnail {
+		r = append(r, field{"kernel", kernel.String()})
+	}
+
+	if p := policy.MatchPolicy(); p != nil {
+		v, err := policyVerdict(p, images)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		r = append(r, field{"policy", v.String()})
+	}
+
+	out.Write(r)
+
+	if len(*decision) > 0 {
+		d, err := imghash.Decide(hashes[0], hashes[1], &imghash.DecisionOptions{
+			Algorithm:  *algo,
+			Profile:    strings.Join(policy.Preprocess, "+"),
+			Thresholds: thresholds,
+			Diff:       true,
+		})
+
+		if err == nil {
+			err = writeJSONFile(*decision, d)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *decision, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// policyVerdict returns the verdict of the policy of the configuration
+// file for the two images, hashed with the algorithm of each rule. Files
+// carry no metadata, so its constraints are left out.
+func policyVerdict(p *imghash.Policy, images [2]image.Image) (imghash.Verdict, error) {
+	var subjects [2]imghash.Subject
+	for i := range subjects {
+		subjects[i].Hashes = make(map[string]uint64)
+	}
+
+	for _, rule := range p.Rules {
+		a, err := findAlgorithm(rule.Algorithm)
+		if err != nil {
+			return imghash.Distinct, err
+		}
+
+		for i, img := range images {
+			subjects[i].Hashes[rule.Algorithm] = a.Hash(img)
+		}
+	}
+
+	d, err := (&imghash.Policy{Rules: p.Rules, Agree: p.Agree}).Evaluate(&subjects[0], &subjects[1])
+	if err != nil {
+		return imghash.Distinct, err
+	}
+
+	return d.Verdict, nil
+}
+
+// writeJSONFile writes v to the given file, as indented JSON.
+func writeJSONFile(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// writePNG writes the image to the given file, as PNG.
+func writePNG(file string, img image.Image) error {
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(fd, img); err != nil {
+		fd.Close()
+		return err
+	}
+
+	return fd.Close()
+}
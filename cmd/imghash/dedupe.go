@@ -0,0 +1,412 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/report"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+func init() {
+	register(&command{
+		Name:  "dedupe",
+		Args:  "<directory...>",
+		Short: "Find groups of duplicate images in directory trees.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("         -a: Hashing algorithm to use. Defaults to average.\n"+
+				"             Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("         -t: Hamming Distance at which images are considered duplicates.\n" +
+				"             Defaults to the near-duplicate threshold of the algorithm.\n")
+			fmt.Printf("       -min: Skip files smaller than this many bytes.\n")
+			fmt.Printf("      -ssim: Verify groups by comparing the images themselves, and\n" +
+				"             split off members with a lower SSIM than this, from 0\n" +
+				"             to 1. 0.8 removes most false matches. Disabled by default.\n")
+			fmt.Printf("      -keep: Comma separated policies which pick the file to keep:\n" +
+				"             resolution, size, oldest or dir=PATH. Defaults to\n" +
+				"             resolution,oldest.\n")
+			fmt.Printf("    -action: Plan to delete or hardlink the other files.\n")
+			fmt.Printf("     -apply: Carry out the planned actions. Without it, they are\n" +
+				"             only reported.\n")
+			fmt.Printf("    -report: Write a report of all groups to this file, as HTML\n" +
+				"             or JSON, depending on its extension.\n")
+			fmt.Printf("     -spill: Cluster the hashes on disk, in this directory, for\n" +
+				"             collections too large to hold in memory. This skips\n" +
+				"             the grouping of identical files before hashing.\n")
+			batchHelp(11)
+			formatHelp(11)
+			fmt.Printf("\nIn the text format, groups are separated by an empty line.\n" +
+				"The other formats hold one record per file, with a group number.\n" +
+				"With -keep, -action or -report, each file is marked with its\n" +
+				"action: keep, delete, hardlink, or duplicate if there is no plan.\n")
+		},
+		Run: runDedupe,
+	})
+}
+
+func runDedupe(args []string) int {
+	fs := newFlags(commands["dedupe"])
+	algo := fs.String("a", defaultAlgorithm(), "")
+	dist := fs.Int("t", -1, "")
+	minSize := fs.Int64("min", 0, "")
+	minSSIM := fs.Float64("ssim", 0, "")
+	keep := fs.String("keep", "", "")
+	action := fs.String("action", "", "")
+	apply := fs.Bool("apply", false, "")
+	reportFile := fs.String("report", "", "")
+	spill := fs.String("spill", "", "")
+	batch := newBatchFlags(fs)
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	policy, err := parsePolicy(*keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	op, err := parseOp(*action)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if *apply && op < 0 {
+		fmt.Fprintf(os.Stderr, "-apply requires -action\n")
+		return 1
+	}
+
+	planned := len(*keep) > 0 || op >= 0 || len(*reportFile) > 0
+	if len(*reportFile) > 0 && reportWriter(*reportFile) == nil {
+		fmt.Fprintf(os.Stderr, "%s: report must end in .html or .json\n", *reportFile)
+		return 1
+	}
+
+	log, err := batch.logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cache, err := batch.openCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+		return 1
+	}
+
+	cp, err := batch.openCheckpoint()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.checkpoint, err)
+		return 1
+	}
+
+	last := -1
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		if group := r.Get("group").(int); group != last {
+			if last != -1 {
+				fmt.Fprintln(w)
+			}
+			last = group
+		}
+
+		if planned {
+			fmt.Fprintf(w, "%s %-9s %s\n", r.Get("hash"), r.Get("action"), r.Get("path"))
+		} else {
+			fmt.Fprintf(w, "%s %s\n", r.Get("hash"), r.Get("path"))
+		}
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	threshold := a.Thresholds.NearDuplicate
+	if *dist >= 0 {
+		threshold = uint64(*dist)
+	}
+
+	var failed int32
+
+	opts := batch.options(*algo, log, cache, cp)
+	opts.OnError = func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		atomic.StoreInt32(&failed, 1)
+	}
+
+	files := walkImages(fs.Args(), *minSize, log)
+
+	var groups [][]*imghash.Entry
+	if len(*spill) > 0 {
+		groups, err = dedupeExternal(files, a.Hash, threshold, opts, *spill)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *spill, err)
+			return 1
+		}
+	} else {
+		groups = imghash.DedupeFiles(files, a.Hash, threshold, opts)
+	}
+
+	if *minSSIM > 0 {
+		groups = imghash.VerifyGroups(groups, *minSSIM)
+	}
+
+	status := int(atomic.LoadInt32(&failed))
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+			status = 1
+		}
+	}
+
+	if batch.removeCheckpoint(cp) != 0 {
+		status = 1
+	}
+
+	if !planned {
+		for i, g := range groups {
+			for _, e := range g {
+				out.Write(record{
+					{"group", i},
+					{"path", e.Path},
+					{"hash", hexHash(e.Hash)},
+				})
+			}
+		}
+
+		return status
+	}
+
+	stats, err := report.Stat(groups)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	rep := report.New(stats, policy)
+	if op >= 0 {
+		rep.Plan(op)
+	}
+
+	actions := make(map[string]string)
+	for _, a := range rep.Actions {
+		actions[a.Path] = a.Op.String()
+	}
+
+	for i, g := range rep.Groups {
+		out.Write(record{
+			{"group", i},
+			{"path", g.Keep.Path},
+			{"hash", hexHash(g.Keep.Hash)},
+			{"action", "keep"},
+		})
+
+		for _, f := range g.Duplicates {
+			a, ok := actions[f.Path]
+			if !ok {
+				a = "duplicate"
+			}
+
+			out.Write(record{
+				{"group", i},
+				{"path", f.Path},
+				{"hash", hexHash(f.Hash)},
+				{"action", a},
+			})
+		}
+	}
+
+	if len(*reportFile) > 0 {
+		if err := writeReport(*reportFile, rep); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *reportFile, err)
+			status = 1
+		}
+	}
+
+	if *apply {
+		for _, a := range rep.Actions {
+			if err := a.Apply(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", a.Path, err)
+				status = 1
+			}
+		}
+	}
+
+	return status
+}
+
+// dedupeExternal finds groups of duplicates like DedupeFiles does, but
+// clusters the hashes with an ExternalCluster in the given directory.
+// Only the groups are held in memory.
+func dedupeExternal(files <-chan string, hf imghash.HashFunc, distance uint64, opts *imghash.BatchOptions, dir string) ([][]*imghash.Entry, error) {
+	c, err := imghash.NewExternalCluster(distance, &imghash.ExternalOptions{Dir: dir})
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.Close()
+
+	// Keep receiving results after a failure, so the batch can finish.
+	for r := range imghash.HashFiles(files, hf, opts) {
+		if r.Err == nil && err == nil {
+			err = c.Add(r.Path, r.Hash)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var groups [][]*imghash.Entry
+	err = c.Clusters(func(g []*imghash.Entry) error {
+		groups = append(groups, g)
+		return nil
+	})
+
+	return groups, err
+}
+
+// parsePolicy parses the value of the -keep option.
+func parsePolicy(value string) (report.Policy, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	var policies []report.Policy
+	for _, name := range strings.Split(value, ",") {
+		switch {
+		case name == "resolution":
+			policies = append(policies, report.LargestResolution)
+		case name == "size":
+			policies = append(policies, report.LargestFile)
+		case name == "oldest":
+			policies = append(policies, report.OldestModTime)
+		case strings.HasPrefix(name, "dir="):
+			policies = append(policies, report.PreferDirectory(name[4:]))
+		default:
+			return nil, fmt.Errorf("unknown keep policy %q", name)
+		}
+	}
+
+	return report.Chain(policies...), nil
+}
+
+// parseOp parses the value of the -action option.
+// It returns -1 if there is none.
+func parseOp(value string) (report.Op, error) {
+	switch value {
+	case "":
+		return -1, nil
+	case "delete":
+		return report.Delete, nil
+	case "hardlink":
+		return report.Hardlink, nil
+	}
+
+	return -1, fmt.Errorf("unknown action %q", value)
+}
+
+// reportWriter returns the method which writes a report in the format
+// matching the file's extension, or nil if there is none.
+func reportWriter(file string) func(*report.Report, io.Writer) error {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".html", ".htm":
+		return (*report.Report).WriteHTML
+	case ".json":
+		return (*report.Report).WriteJSON
+	}
+
+	return nil
+}
+
+// writeReport writes the report to the given file.
+func writeReport(file string, rep *report.Report) error {
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err := reportWriter(file)(rep, fd); err != nil {
+		fd.Close()
+		return err
+	}
+
+	return fd.Close()
+}
+
+// walkImages walks the given directories concurrently and sends
+// the paths of all image files it finds on the returned channel.
+// Skipped files are reported to log, if it is not nil.
+func walkImages(dirs []string, minSize int64, log *slog.Logger) <-chan string {
+	files := make(chan string)
+
+	var wg sync.WaitGroup
+	wg.Add(len(dirs))
+
+	for _, dir := range dirs {
+		go func(dir string) {
+			defer wg.Done()
+
+			filepath.Walk(dir, func(file string, stat os.FileInfo, err error) error {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+					return nil
+				}
+
+				if stat.IsDir() || !isImage(file) {
+					return nil
+				}
+
+				if stat.Size() < minSize {
+					if log != nil {
+						log.Debug("skipped", "path", file, "size", stat.Size())
+					}
+					return nil
+				}
+
+				files <- file
+				return nil
+			})
+
+			if log != nil {
+				log.Debug("walked", "dir", dir)
+			}
+		}(dir)
+	}
+
+	go func() {
+		wg.Wait()
+		close(files)
+	}()
+
+	return files
+}
+
+// isImage returns true if the file name has the extension
+// of a format with a registered decoder.
+func isImage(file string) bool {
+	return imghash.HasExtension(file)
+}
@@ -0,0 +1,16 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+imghash is a commandline frontend for the imghash package.
+It bundles a number of subcommands. Run `imghash help` for a listing.
+
+The hash subcommand computes Perceptual hashes for the given image files,
+or for an image read from stdin. The hashes are printed as hexadecimal
+numbers, one per line, followed by the file name:
+
+	$ imghash hash *.jpg
+	c3c3e7ff7e3c1800 a.jpg
+	c3c3e7ff7e3c1c00 b.jpg
+*/
+package main
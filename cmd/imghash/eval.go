@@ -0,0 +1,136 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/eval"
+	"io"
+	"os"
+)
+
+func init() {
+	register(&command{
+		Name:  "eval",
+		Args:  "<pairs.csv>",
+		Short: "Find the best threshold for each algorithm on labeled pairs.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("      -a: Algorithm to evaluate. Defaults to all of them.\n"+
+				"          Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("  -curve: List the ROC curve, rather than a summary.\n")
+			formatHelp(8)
+			fmt.Printf("\nThe pairs file holds one pair of images per line, followed\n" +
+				"by 1 if they are duplicates, or 0 if they are not:\n\n" +
+				"    a.jpg,a_small.jpg,1\n" +
+				"    a.jpg,b.jpg,0\n\n" +
+				"For each algorithm, the area under the ROC curve is reported, along\n" +
+				"with the threshold which best separates duplicates from the rest.\n")
+		},
+		Run: runEval,
+	})
+}
+
+func runEval(args []string) int {
+	fs := newFlags(commands["eval"])
+	algo := fs.String("a", "", "")
+	curve := fs.Bool("curve", false, "")
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	names := imghash.Algorithms()
+	if len(*algo) > 0 {
+		names = []string{*algo}
+	}
+
+	hashes := make(map[string]imghash.HashFunc)
+	for _, name := range names {
+		a, err := findAlgorithm(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		hashes[name] = a.Hash
+	}
+
+	fd, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	pairs, err := eval.ReadPairs(fd)
+	fd.Close()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	results, err := eval.EvaluateFiles(pairs, hashes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	text := func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%s:\n", r.Get("algorithm"))
+		fmt.Fprintf(w, "  auc:       %.3f\n", r.Get("auc"))
+		fmt.Fprintf(w, "  threshold: %d\n", r.Get("threshold"))
+		fmt.Fprintf(w, "  recall:    %.1f%%\n", 100*r.Get("tpr").(float64))
+		fmt.Fprintf(w, "  false pos: %.1f%%\n", 100*r.Get("fpr").(float64))
+		fmt.Fprintf(w, "  precision: %.1f%%\n", 100*r.Get("precision").(float64))
+	}
+
+	if *curve {
+		text = func(w io.Writer, r record) {
+			fmt.Fprintf(w, "%s %2d: tpr %.3f, fpr %.3f, precision %.3f\n", r.Get("algorithm"),
+				r.Get("threshold"), r.Get("tpr"), r.Get("fpr"), r.Get("precision"))
+		}
+	}
+
+	out, err := newOutput(*format, text)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	for _, name := range names {
+		r := results[name]
+
+		if *curve {
+			for _, p := range r.Points {
+				out.Write(record{
+					{"algorithm", name},
+					{"threshold", p.Threshold},
+					{"tpr", p.TPR()},
+					{"fpr", p.FPR()},
+					{"precision", p.Precision()},
+				})
+			}
+			continue
+		}
+
+		out.Write(record{
+			{"algorithm", name},
+			{"pairs", len(pairs)},
+			{"auc", r.AUC},
+			{"threshold", r.Best.Threshold},
+			{"tpr", r.Best.TPR()},
+			{"fpr", r.Best.FPR()},
+			{"precision", r.Best.Precision()},
+			{"f1", r.Best.F1()},
+		})
+	}
+
+	return 0
+}
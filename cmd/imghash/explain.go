@@ -0,0 +1,132 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+)
+
+// Size of a single grid cell in the explain output, in pixels.
+const cellSize = 32
+
+// Cell border colours used by explain.
+var (
+	colorAbove = color.RGBA{0x00, 0xc0, 0x00, 0xff} // Bit is set.
+	colorBelow = color.RGBA{0x00, 0x40, 0xff, 0xff} // Bit is not set.
+	colorDiff  = color.RGBA{0xff, 0x00, 0x00, 0xff} // Bit differs.
+)
+
+func init() {
+	register(&command{
+		Name:  "explain",
+		Args:  "<file> | -diff <file> <file>",
+		Short: "Draw the grid a hash is derived from, to see how it came about.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("    -o: File to write the PNG image to. Defaults to explain.png.\n")
+			fmt.Printf(" -diff: Compare two images and highlight the differing bits.\n")
+			fmt.Printf("\nThe image shows the downscaled grayscale grid used by the average\n" +
+				"hash. Cells above the mean -- set bits -- have a green border. Cells\n" +
+				"below it have a blue one. With -diff, the grids of both images are\n" +
+				"drawn side by side, and only cells whose bits differ get a border,\n" +
+				"in red.\n")
+		},
+		Run: runExplain,
+	})
+}
+
+func runExplain(args []string) int {
+	fs := newFlags(commands["explain"])
+	file := fs.String("o", "explain.png", "")
+	diff := fs.Bool("diff", false, "")
+	args = parseInterleaved(fs, args)
+
+	if (!*diff && len(args) != 1) || (*diff && len(args) != 2) {
+		fs.Usage()
+		return 1
+	}
+
+	grids := make([][]uint32, len(args))
+	means := make([]uint32, len(args))
+	hashes := make([]uint64, len(args))
+
+	for i, name := range args {
+		img, err := imghash.DecodeFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 1
+		}
+
+		grids[i], means[i] = imghash.AverageCells(img)
+		hashes[i] = imghash.Average(img)
+		fmt.Printf("%016x %s\n", hashes[i], name)
+	}
+
+	var out *image.RGBA
+
+	if *diff {
+		mask := hashes[0] ^ hashes[1]
+		fmt.Printf("distance: %d\n", imghash.Distance(hashes[0], hashes[1]))
+
+		// Leave a one cell gap between the two grids.
+		out = image.NewRGBA(image.Rect(0, 0, 17*cellSize, 8*cellSize))
+		draw.Draw(out, out.Rect, image.White, image.Point{}, draw.Src)
+
+		for i := range grids {
+			drawGrid(out, image.Pt(i*9*cellSize, 0), grids[i], func(bit int) color.Color {
+				if mask&(1<<uint(bit)) != 0 {
+					return colorDiff
+				}
+				return nil
+			})
+		}
+	} else {
+		out = image.NewRGBA(image.Rect(0, 0, 8*cellSize, 8*cellSize))
+		drawGrid(out, image.Point{}, grids[0], func(bit int) color.Color {
+			if grids[0][bit] > means[0] {
+				return colorAbove
+			}
+			return colorBelow
+		})
+	}
+
+	fd, err := os.Create(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer fd.Close()
+
+	if err = png.Encode(fd, out); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	return 0
+}
+
+// drawGrid draws the 8x8 grid of cell values at the given position.
+// The border function returns the border colour for each bit,
+// or nil if the cell should have no border.
+func drawGrid(dst draw.Image, at image.Point, cells []uint32, border func(int) color.Color) {
+	for bit, v := range cells {
+		min := at.Add(image.Pt((bit%8)*cellSize, (bit/8)*cellSize))
+		cell := image.Rectangle{min, min.Add(image.Pt(cellSize, cellSize))}
+
+		if c := border(bit); c != nil {
+			draw.Draw(dst, cell, image.NewUniform(c), image.Point{}, draw.Src)
+			cell = cell.Inset(cellSize / 8)
+		}
+
+		gray := color.Gray16{uint16(v)}
+		draw.Draw(dst, cell, image.NewUniform(gray), image.Point{}, draw.Src)
+	}
+}
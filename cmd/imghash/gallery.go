@@ -0,0 +1,164 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/report"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+func init() {
+	register(&command{
+		Name:  "gallery",
+		Args:  "-o <file> <directory...>",
+		Short: "Write an HTML gallery of the images the matcher takes as copies, to check it by eye.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("         -o: HTML file to write the gallery to.\n")
+			fmt.Printf("         -a: Hashing algorithm to use. Defaults to average.\n"+
+				"             Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("         -t: Hamming Distance at which images are matched.\n" +
+				"             Defaults to the near-duplicate threshold of the algorithm.\n")
+			fmt.Printf("       -max: Maximum number of pairs to show. Defaults to 500.\n" +
+				"             0 shows all of them.\n")
+			fmt.Printf("      -size: Longest side of the thumbnails. Defaults to 192.\n")
+			fmt.Printf("     -title: Title of the page.\n")
+			batchHelp(11)
+			formatHelp(11)
+			fmt.Printf("\nImages are grouped as dedupe groups them. Each member of a group\n" +
+				"is shown next to the first, with the distance between them, the\n" +
+				"verdict and its threshold, the cells of the hashes which differ,\n" +
+				"and a heatmap of the regions which differ. Groups with the largest\n" +
+				"distances come first. The algorithm, filters and thresholds of\n" +
+				"-config apply, so a configuration can be looked over before it\n" +
+				"goes to production. Thumbnails are embedded in the page.\n")
+		},
+		Run: runGallery,
+	})
+}
+
+func runGallery(args []string) int {
+	fs := newFlags(commands["gallery"])
+	file := fs.String("o", "", "")
+	algo := fs.String("a", defaultAlgorithm(), "")
+	dist := fs.Int("t", -1, "")
+	maxPairs := fs.Int("max", 500, "")
+	size := fs.Int("size", 192, "")
+	title := fs.String("title", "", "")
+	batch := newBatchFlags(fs)
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if len(*file) == 0 || fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	log, err := batch.logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cache, err := batch.openCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+		return 1
+	}
+
+	cp, err := batch.openCheckpoint()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.checkpoint, err)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d pair(s) in %d group(s) written to %s.\n", r.Get("pairs"), r.Get("groups"), r.Get("gallery"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	threshold := a.Thresholds.NearDuplicate
+	if *dist >= 0 {
+		threshold = uint64(*dist)
+	}
+
+	var failed int32
+
+	opts := batch.options(*algo, log, cache, cp)
+	opts.OnError = func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		atomic.StoreInt32(&failed, 1)
+	}
+
+	groups := imghash.DedupeFiles(walkImages(fs.Args(), 0, log), a.Hash, threshold, opts)
+	status := int(atomic.LoadInt32(&failed))
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+			status = 1
+		}
+	}
+
+	if batch.removeCheckpoint(cp) != 0 {
+		status = 1
+	}
+
+	fd, err := os.Create(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	err = report.WriteGallery(fd, groups, &report.GalleryOptions{
+		Title: *title,
+		Decision: imghash.DecisionOptions{
+			Algorithm:  *algo,
+			Profile:    strings.Join(policy.Preprocess, "+"),
+			Thresholds: a.Thresholds,
+		},
+		HashFunc: a.Hash,
+		Size:     *size,
+		MaxPairs: *maxPairs,
+	})
+
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	var pairs int
+	for _, g := range groups {
+		pairs += len(g) - 1
+	}
+
+	out.Write(record{
+		{"gallery", *file},
+		{"groups", len(groups)},
+		{"pairs", pairs},
+	})
+
+	return status
+}
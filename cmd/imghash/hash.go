@@ -0,0 +1,161 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	register(&command{
+		Name:  "hash",
+		Args:  "[file...]",
+		Short: "Compute Perceptual hashes for image files, or stdin.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("      -a: Hashing algorithm to use. Defaults to average.\n"+
+				"          Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("      -q: Also report the quality of each image, from 0 to 100.\n")
+			fmt.Printf("  -pages: Pages of multi-page files to hash: all, or a comma\n" +
+				"          separated list of indices, from 0. Defaults to the first.\n")
+			formatHelp(8)
+			fmt.Printf("\nImages with a quality under 50 hold little detail. Their hashes\n" +
+				"are unreliable, and tend to match unrelated images.\n")
+			fmt.Printf("\nWithout file arguments, or with a file named '-', the image\n" +
+				"is read from stdin.\n")
+		},
+		Run: runHash,
+	})
+}
+
+func runHash(args []string) int {
+	fs := newFlags(commands["hash"])
+	algo := fs.String("a", defaultAlgorithm(), "")
+	quality := fs.Bool("q", false, "")
+	pageList := fs.String("pages", "", "")
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	pages, err := parsePages(*pageList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		path := r.Get("path")
+		if page := r.Get("page"); page != nil {
+			path = fmt.Sprintf("%s[%d]", path, page)
+		}
+
+		if *quality {
+			fmt.Fprintf(w, "%s %3d %s\n", r.Get("hash"), r.Get("quality"), path)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", r.Get("hash"), path)
+		}
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	status := 0
+
+	for _, file := range files {
+		decoded, err := decodePages(file, pages)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			status = 1
+			continue
+		}
+
+		for _, p := range decoded {
+			r := record{
+				{"path", file},
+				{"hash", hexHash(a.Hash(p.Image))},
+				{"algorithm", *algo},
+			}
+
+			if pages != nil {
+				r = append(r, field{"page", p.Index})
+			}
+
+			if *quality {
+				r = append(r, field{"quality", imghash.Quality(p.Image)})
+			}
+
+			out.Write(r)
+		}
+	}
+
+	return status
+}
+
+// parsePages parses the -pages option. It returns nil for the first
+// page only, and an empty list for all pages.
+func parsePages(list string) ([]int, error) {
+	switch list {
+	case "":
+		return nil, nil
+	case "all":
+		return []int{}, nil
+	}
+
+	var pages []int
+	for _, item := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid page %q", item)
+		}
+
+		pages = append(pages, n)
+	}
+
+	return pages, nil
+}
+
+// decodePages decodes the given pages of the file, or stdin for "-".
+// Without a list of pages, it decodes the first.
+func decodePages(file string, pages []int) ([]*imghash.Page, error) {
+	r := io.Reader(os.Stdin)
+	if file != "-" {
+		fd, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+
+		defer fd.Close()
+		r = fd
+	}
+
+	if pages == nil {
+		img, err := imghash.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*imghash.Page{{Index: 0, Image: img}}, nil
+	}
+
+	return imghash.DecodePages(r, pages...)
+}
@@ -0,0 +1,318 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	register(&command{
+		Name:  "import",
+		Args:  "<source...> <library>",
+		Short: "Copy or move images into a library, skipping those it already holds.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("            -index: Index of the library. Defaults to .imghash.idx in it.\n" +
+				"                    A new index is built from the images already there.\n")
+			fmt.Printf("                -a: Hashing algorithm for new indexes. Defaults to average.\n"+
+				"                    Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("                -d: Hamming Distance at which images are considered\n" +
+				"                    duplicates. Defaults to the near-duplicate threshold\n" +
+				"                    of the algorithm.\n")
+			fmt.Printf("  -skip-duplicates: Leave images with a near-duplicate in the library\n" +
+				"                    where they are. Without it, they are imported as well,\n" +
+				"                    and reported as duplicates.\n")
+			fmt.Printf("             -move: Move the images, rather than copy them.\n")
+			fmt.Printf("                -n: Only report what would be done.\n")
+			batchHelp(18)
+			formatHelp(18)
+			fmt.Printf("\nImages keep their path relative to the source directory. Names\n" +
+				"taken in the library get a number appended. Each image is added to\n" +
+				"the index as it is imported, so duplicates among the sources are\n" +
+				"caught as well. The library may not lie within a source directory.\n")
+		},
+		Run: runImport,
+	})
+}
+
+func runImport(args []string) int {
+	fs := newFlags(commands["import"])
+	file := fs.String("index", policy.Index.Path, "")
+	algo := fs.String("a", defaultAlgorithm(), "")
+	dist := fs.Int("d", -1, "")
+	skip := fs.Bool("skip-duplicates", false, "")
+	move := fs.Bool("move", false, "")
+	dryRun := fs.Bool("n", false, "")
+	batch := newBatchFlags(fs)
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(args) < 2 {
+		fs.Usage()
+		return 1
+	}
+
+	sources, library := args[:len(args)-1], args[len(args)-1]
+
+	// The sources are walked while files are imported, so a library
+	// inside one would be imported into itself.
+	for _, src := range sources {
+		if within(library, src) {
+			fmt.Fprintf(os.Stderr, "%s: library %s lies within it\n", src, library)
+			return 1
+		}
+	}
+
+	if len(*file) == 0 {
+		*file = filepath.Join(library, ".imghash.idx")
+	}
+
+	log, err := batch.logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cache, err := batch.openCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+		return 1
+	}
+
+	cp, err := batch.openCheckpoint()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.checkpoint, err)
+		return 1
+	}
+
+	index, err := loadIndex(*file)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	fresh := err != nil
+	if len(index.Algorithm) == 0 {
+		index.Algorithm = *algo
+	}
+
+	a, err := findAlgorithm(index.Algorithm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	distance := a.Thresholds.NearDuplicate
+	if *dist >= 0 {
+		distance = uint64(*dist)
+	}
+
+	op := "copy"
+	if *move {
+		op = "move"
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		switch r.Get("action") {
+		case "skip":
+			fmt.Fprintf(w, "skip %s ~ %s (distance %d)\n", r.Get("path"), r.Get("match"), r.Get("distance"))
+		default:
+			fmt.Fprintf(w, "%s %s -> %s", r.Get("action"), r.Get("path"), r.Get("dest"))
+			if m := r.Get("match"); m != nil {
+				fmt.Fprintf(w, " (duplicate of %s)", m)
+			}
+			fmt.Fprintln(w)
+		}
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	opts := batch.options(index.Algorithm, log, cache, cp)
+	status := 0
+
+	// Index what the library holds already.
+	if fresh {
+		for r := range imghash.HashFiles(walkImages([]string{library}, 0, log), a.Hash, opts) {
+			if r.Err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", r.Path, r.Err)
+				status = 1
+				continue
+			}
+
+			index.Add(absPath(r.Path), r.Hash)
+		}
+	}
+
+	// Names in the library, including those taken during this run.
+	taken := make(map[string]bool)
+
+	for _, src := range sources {
+		for r := range imghash.HashFiles(walkImages([]string{src}, 0, log), a.Hash, opts) {
+			if r.Err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", r.Path, r.Err)
+				status = 1
+				continue
+			}
+
+			rec := record{{"path", r.Path}, {"hash", hexHash(r.Hash)}}
+			if rs := index.Query(r.Hash, distance); len(rs) > 0 {
+				rec = append(rec, field{"match", rs[0].Path}, field{"distance", rs[0].Distance})
+
+				if *skip {
+					out.Write(append(record{{"action", "skip"}}, rec...))
+					continue
+				}
+			}
+
+			dest := importPath(library, src, r.Path, taken)
+			if !*dryRun {
+				if err := importFile(r.Path, dest, *move); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", r.Path, err)
+					status = 1
+					continue
+				}
+			}
+
+			index.Add(absPath(dest), r.Hash)
+			out.Write(append(record{{"action", op}, {"dest", dest}}, rec...))
+		}
+	}
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+			status = 1
+		}
+	}
+
+	if *dryRun {
+		return status
+	}
+
+	if err := index.Save(*file); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	if batch.removeCheckpoint(cp) != 0 {
+		status = 1
+	}
+
+	return status
+}
+
+// importPath returns the path in the library for the file found under
+// src. Names which exist, or were handed out before, get a number.
+func importPath(library, src, file string, taken map[string]bool) string {
+	rel, err := filepath.Rel(src, file)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(file)
+	}
+
+	dest := filepath.Join(library, rel)
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(dest); os.IsNotExist(err) && !taken[dest] {
+			break
+		}
+
+		dest = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+
+	taken[dest] = true
+	return dest
+}
+
+// rename renames files. Tests replace it to fail, as it does across
+// file systems.
+var rename = os.Rename
+
+// importFile copies or moves the file to dest, creating its directory.
+// The copy keeps the modification time of the original.
+func importFile(file, dest string, move bool) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	// Renames fail across file systems; copy and remove instead.
+	if move && rename(file, dest) == nil {
+		return nil
+	}
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	if err := copyFile(file, dest, stat); err != nil {
+		return err
+	}
+
+	if move {
+		return os.Remove(file)
+	}
+
+	return nil
+}
+
+// copyFile copies the contents, mode and modification time of file to
+// dest, which must not exist. A partial copy is removed.
+func copyFile(file, dest string, stat os.FileInfo) (err error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dest, stat.ModTime(), stat.ModTime())
+}
+
+// absPath returns the absolute form of the path, or the path itself
+// if it has none. Indexes store absolute paths.
+func absPath(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+// within returns true if path is dir, or lies below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(absPath(dir), absPath(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,215 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/fixtures"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeImages writes the named fixtures to dir, by the path they
+// should have there.
+func writeImages(t *testing.T, dir string, images map[string]string) {
+	for file, name := range images {
+		file = filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(file, fixtures.Bytes(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listImages returns the paths of the images in dir, relative to it.
+func listImages(t *testing.T, dir string) string {
+	var files []string
+	err := filepath.Walk(dir, func(file string, stat os.FileInfo, err error) error {
+		if err == nil && !stat.IsDir() && isImage(file) {
+			rel, _ := filepath.Rel(dir, file)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(files)
+	return strings.Join(files, " ")
+}
+
+// sameFile returns true if file holds the named fixture.
+func sameFile(t *testing.T, file, name string) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.Equal(data, fixtures.Bytes(name))
+}
+
+func TestImport(t *testing.T) {
+	src, lib := t.TempDir(), t.TempDir()
+	writeImages(t, src, map[string]string{
+		"trip/gopher.jpg": "gopher.jpg",
+		"blocks.gif":      "blocks.gif",
+		"gradient.png":    "checker_alpha.png",
+	})
+	writeImages(t, lib, map[string]string{"gradient.png": "gradient16.png"})
+
+	// A dry run does nothing.
+	if status := runImport([]string{"-n", src, lib}); status != 0 {
+		t.Fatalf("dry run: status %d", status)
+	}
+
+	if got := listImages(t, lib); got != "gradient.png" {
+		t.Fatalf("dry run: library holds %s", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(lib, ".imghash.idx")); !os.IsNotExist(err) {
+		t.Fatalf("dry run: index %v", err)
+	}
+
+	// Copies keep their path, and their modification time. Names
+	// taken in the library get a number.
+	mtime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "blocks.gif"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := runImport([]string{src, lib}); status != 0 {
+		t.Fatalf("copy: status %d", status)
+	}
+
+	if got := listImages(t, lib); got != "blocks.gif gradient-1.png gradient.png trip/gopher.jpg" {
+		t.Fatalf("copy: library holds %s", got)
+	}
+
+	if !sameFile(t, filepath.Join(lib, "gradient.png"), "gradient16.png") ||
+		!sameFile(t, filepath.Join(lib, "gradient-1.png"), "checker_alpha.png") ||
+		!sameFile(t, filepath.Join(lib, "trip/gopher.jpg"), "gopher.jpg") {
+		t.Fatal("copy: wrong contents")
+	}
+
+	if stat, err := os.Stat(filepath.Join(lib, "blocks.gif")); err != nil {
+		t.Fatal(err)
+	} else if !stat.ModTime().Equal(mtime) {
+		t.Fatalf("copy: modification time %v", stat.ModTime())
+	}
+
+	if got := listImages(t, src); got != "blocks.gif gradient.png trip/gopher.jpg" {
+		t.Fatalf("copy: source holds %s", got)
+	}
+
+	index := imghash.NewIndex()
+	if err := index.Load(filepath.Join(lib, ".imghash.idx")); err != nil {
+		t.Fatal(err)
+	}
+
+	if index.Len() != 4 {
+		t.Fatalf("copy: %d indexed images", index.Len())
+	}
+
+	if _, ok := index.Hash(absPath(filepath.Join(lib, "gradient-1.png"))); !ok {
+		t.Fatalf("copy: gradient-1.png not indexed: %v", index.IDs())
+	}
+
+	// Near-duplicates of images in the library are skipped with
+	// -skip-duplicates, and imported otherwise.
+	more := t.TempDir()
+	writeImages(t, more, map[string]string{"large.png": "gopher_large.png"})
+
+	if status := runImport([]string{"-skip-duplicates", more, lib}); status != 0 {
+		t.Fatalf("skip: status %d", status)
+	}
+
+	if got := listImages(t, lib); strings.Contains(got, "large.png") {
+		t.Fatalf("skip: library holds %s", got)
+	}
+
+	if status := runImport([]string{more, lib}); status != 0 {
+		t.Fatalf("duplicate: status %d", status)
+	}
+
+	if !sameFile(t, filepath.Join(lib, "large.png"), "gopher_large.png") {
+		t.Fatal("duplicate: not imported")
+	}
+}
+
+func TestImportMove(t *testing.T) {
+	defer func() { rename = os.Rename }()
+
+	for _, tt := range []struct {
+		name   string
+		rename func(string, string) error
+	}{
+		{"rename", os.Rename},
+		{"cross-device", func(file, dest string) error {
+			return &os.LinkError{Op: "rename", Old: file, New: dest, Err: syscall.EXDEV}
+		}},
+	} {
+		rename = tt.rename
+
+		src, lib := t.TempDir(), t.TempDir()
+		writeImages(t, src, map[string]string{"a/blocks.gif": "blocks.gif", "gopher.jpg": "gopher.jpg"})
+
+		mtime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(src, "gopher.jpg"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		if status := runImport([]string{"-move", src, lib}); status != 0 {
+			t.Fatalf("%s: status %d", tt.name, status)
+		}
+
+		if got := listImages(t, src); got != "" {
+			t.Fatalf("%s: source holds %s", tt.name, got)
+		}
+
+		if got := listImages(t, lib); got != "a/blocks.gif gopher.jpg" {
+			t.Fatalf("%s: library holds %s", tt.name, got)
+		}
+
+		if !sameFile(t, filepath.Join(lib, "a/blocks.gif"), "blocks.gif") {
+			t.Fatalf("%s: wrong contents", tt.name)
+		}
+
+		if stat, err := os.Stat(filepath.Join(lib, "gopher.jpg")); err != nil {
+			t.Fatal(err)
+		} else if !stat.ModTime().Equal(mtime) {
+			t.Fatalf("%s: modification time %v", tt.name, stat.ModTime())
+		}
+	}
+}
+
+func TestImportWithin(t *testing.T) {
+	src := t.TempDir()
+	writeImages(t, src, map[string]string{"gopher.jpg": "gopher.jpg"})
+
+	for _, lib := range []string{src, filepath.Join(src, "library"), filepath.Join(src, "a", "..", "b")} {
+		if status := runImport([]string{src, lib}); status != 1 {
+			t.Fatalf("%s: status %d", lib, status)
+		}
+
+		if got := listImages(t, src); got != "gopher.jpg" {
+			t.Fatalf("%s: source holds %s", lib, got)
+		}
+	}
+
+	// Siblings whose names start alike are fine.
+	if within(src+"-library", src) || within(filepath.Dir(src), src) || !within(src, src) {
+		t.Fatal("within")
+	}
+}
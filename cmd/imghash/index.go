@@ -0,0 +1,799 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/hashlist"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	register(&command{
+		Name:  "index",
+		Args:  "build -o <index> <directory...> | query <index> <file> | export -o <blocks> <index> | resolve <index> <results> | load -o <index> <list...> | dump -o <list> <index> | map -o <file> <index> | matrix -o <file> <index>",
+		Short: "Build an image index, search one for similar images, or export one for hardware matchers or other tools.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("build:\n")
+			fmt.Printf("         -o: File to write the index to. Existing entries are kept.\n")
+			fmt.Printf("         -a: Hashing algorithm to use. Defaults to average.\n"+
+				"             Supported algorithms: %s.\n", algorithmNames())
+			batchHelp(11)
+			formatHelp(11)
+			fmt.Printf("\nquery:\n")
+			fmt.Printf("         -d: Hamming Distance to use when matching hashes.\n" +
+				"             Defaults to the near-duplicate threshold of the algorithm.\n")
+			fmt.Printf("    -panels: If the file is a collage of several photos, search for\n" +
+				"             each of them as well.\n")
+			fmt.Printf("     -crops: Search for the file with any border around it cut off,\n" +
+				"             and with 5%% and 10%% cut off each side, as well.\n" +
+				"             With -panels or -crops, matches list the search which\n" +
+				"             found them.\n")
+			fmt.Printf("    -orient: Search for the file mirrored and rotated by multiples of\n" +
+				"             90 degrees, as well. Matches list the orientation they\n" +
+				"             were found in. This works for the average algorithm.\n")
+			formatHelp(11)
+			fmt.Printf("\nexport:\n")
+			fmt.Printf("         -o: File to write the packed hashes to.\n")
+			fmt.Printf("      -word: Bytes per word: 1, 2, 4 or 8. Defaults to 8.\n")
+			fmt.Printf("    -stride: Bytes per hash, padded with zeros. Defaults to 8.\n")
+			fmt.Printf("     -align: Pad the file to a multiple of this many bytes.\n")
+			fmt.Printf("        -be: Store words big endian, rather than little endian.\n")
+			formatHelp(11)
+			fmt.Printf("\nresolve:\n")
+			fmt.Printf("     -index: Bytes per row index in the results: 4 or 8. Defaults to 4.\n")
+			fmt.Printf("        -be: Read row indices big endian, rather than little endian.\n")
+			formatHelp(11)
+			fmt.Printf("\nload:\n")
+			fmt.Printf("         -o: File to write the index to. Existing entries are kept.\n")
+			fmt.Printf("         -a: Algorithm of the hashes. Defaults to that of the index, or\n" +
+				"             average. Hashes tagged with another one are skipped.\n")
+			fmt.Printf("   -quality: Skip hashes whose list gives a lower quality, from 0 to 100.\n")
+			fmt.Printf("      -type: Format of the lists: csv, json or hex. Defaults to json for\n" +
+				"             .json and .jsonl files, csv for .csv files, and hex otherwise.\n")
+			formatHelp(11)
+			fmt.Printf("\ndump:\n")
+			fmt.Printf("         -o: File to write the list to.\n")
+			fmt.Printf("      -type: Format of the list, as for load.\n")
+			formatHelp(11)
+			fmt.Printf("\nmap:\n")
+			fmt.Printf("         -o: File to write the mapped index to.\n")
+			formatHelp(11)
+			fmt.Printf("\nmatrix:\n")
+			fmt.Printf("         -o: File to write the matrix to: .npy or .csv.\n")
+			fmt.Printf("  -encoding: Values of the bits: bits (0 and 1), or signed (-1 and 1)\n" +
+				"             for the cosine metric. Defaults to bits.\n")
+			fmt.Printf("       -ids: File to write the IDs of the rows of a .npy matrix to,\n" +
+				"             one per line. Defaults to the matrix file, with .ids\n" +
+				"             in place of .npy.\n")
+			formatHelp(11)
+			fmt.Printf("\nExport writes the hashes of an index as rows of words, one\n" +
+				"hash per row, in the order of their paths. GPU and FPGA matchers\n" +
+				"take these as they are. Resolve reads the row indices such a\n" +
+				"matcher reports, and lists the images they refer to.\n")
+			fmt.Printf("\nLoad adds hash lists shared by others to an index, and dump writes\n" +
+				"one out as a list. CSV lists hold the hash, quality and file name\n" +
+				"per line, as the PDQ hasher writes them. Hex lists hold a hash per\n" +
+				"line, optionally tagged as in average:ff00ff00ff00ff00, and followed\n" +
+				"by an ID. Only 64 bit hashes fit into an index.\n")
+			fmt.Printf("\nMap writes an index in a format imghashd -mmap maps into memory\n" +
+				"read-only, without loading it. Servers on one host mapping the\n" +
+				"same file share a single copy of it. Metadata is left out.\n")
+			fmt.Printf("\nMatrix writes the hashes of an index as a matrix with a row per\n" +
+				"hash and a column per bit, in the order of their paths, for t-SNE,\n" +
+				"UMAP and other tools which map a collection out in a plane.\n")
+		},
+		Run: runIndex,
+	})
+}
+
+func runIndex(args []string) int {
+	fs := newFlags(commands["index"])
+
+	if len(args) == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	switch args[0] {
+	case "build":
+		return runIndexBuild(fs, args[1:])
+	case "query":
+		return runIndexQuery(fs, args[1:])
+	case "export":
+		return runIndexExport(fs, args[1:])
+	case "resolve":
+		return runIndexResolve(fs, args[1:])
+	case "load":
+		return runIndexLoad(fs, args[1:])
+	case "dump":
+		return runIndexDump(fs, args[1:])
+	case "map":
+		return runIndexMap(fs, args[1:])
+	case "matrix":
+		return runIndexMatrix(fs, args[1:])
+	}
+
+	fs.Usage()
+	return 1
+}
+
+func runIndexBuild(fs *flag.FlagSet, args []string) int {
+	file := fs.String("o", policy.Index.Path, "")
+	algo := fs.String("a", defaultAlgorithm(), "")
+	batch := newBatchFlags(fs)
+	format := formatFlag(fs)
+	dirs := parseInterleaved(fs, args)
+
+	if len(*file) == 0 || len(dirs) == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	log, err := batch.logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cache, err := batch.openCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+		return 1
+	}
+
+	cp, err := batch.openCheckpoint()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.checkpoint, err)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d image(s) indexed.\n", r.Get("entries"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(*file)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	if len(index.Algorithm) > 0 && index.Algorithm != *algo {
+		fmt.Fprintf(os.Stderr, "%s: index uses algorithm %q\n", *file, index.Algorithm)
+		return 1
+	}
+
+	index.Algorithm = *algo
+	status := 0
+
+	for r := range imghash.HashFiles(walkImages(dirs, 0, log), a.Hash, batch.options(*algo, log, cache, cp)) {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.Path, r.Err)
+			status = 1
+			continue
+		}
+
+		// Store absolute paths, so queries can be run from anywhere.
+		path, err := filepath.Abs(r.Path)
+		if err != nil {
+			path = r.Path
+		}
+
+		index.Add(path, r.Hash)
+	}
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+			status = 1
+		}
+	}
+
+	if err := index.Save(*file); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	// The hashes are safe in the index now.
+	if batch.removeCheckpoint(cp) != 0 {
+		status = 1
+	}
+
+	out.Write(record{
+		{"index", *file},
+		{"algorithm", index.Algorithm},
+		{"entries", index.Len()},
+	})
+
+	return status
+}
+
+func runIndexQuery(fs *flag.FlagSet, args []string) int {
+	dist := fs.Int("d", -1, "")
+	panels := fs.Bool("panels", false, "")
+	crops := fs.Bool("crops", false, "")
+	orient := fs.Bool("orient", false, "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	// The index may come from the configuration file.
+	if len(args) == 1 && len(policy.Index.Path) > 0 {
+		args = []string{policy.Index.Path, args[0]}
+	}
+
+	if len(args) != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		if q := r.Get("query"); q != nil {
+			fmt.Fprintf(w, "%-8s ", q)
+		}
+		fmt.Fprintf(w, "%d %s %s", r.Get("distance"), r.Get("hash"), r.Get("path"))
+		if o := r.Get("orientation"); o != nil && o != imghash.Identity.String() {
+			fmt.Fprintf(w, " (%s)", o)
+		}
+		fmt.Fprintln(w)
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	a, err := findAlgorithm(index.Algorithm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	img, err := imghash.DecodeFile(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[1], err)
+		return 1
+	}
+
+	// Searches to run, by name.
+	queries := []string{"image"}
+	hashes := []uint64{a.Hash(img)}
+
+	if *panels {
+		for i, hash := range imghash.HashPanels(img, a.Hash) {
+			queries = append(queries, fmt.Sprintf("panel-%d", i+1))
+			hashes = append(hashes, hash)
+		}
+	}
+
+	if *crops {
+		for _, c := range imghash.BorderCrops(img) {
+			name := "border"
+			if c.Name != "border" {
+				name = "crop-" + c.Name
+			}
+
+			queries = append(queries, name)
+			hashes = append(hashes, a.Hash(imghash.Region(img, c.Rect)))
+		}
+	}
+
+	distance := a.Thresholds.NearDuplicate
+	if *dist >= 0 {
+		distance = uint64(*dist)
+	}
+
+	// Keep the closest match for each entry, over all searches.
+	var results imghash.ResultSet
+	best := make(map[string]int)                        // Index of the match for each entry.
+	query := make(map[string]int)                       // Search which found it.
+	orientation := make(map[string]imghash.Orientation) // Orientation it was found in.
+
+	for i, hash := range hashes {
+		for _, h := range search(index, hash, distance, *orient) {
+			r := &imghash.SearchResult{Path: h.ID, Hash: h.Hash, Distance: h.Distance}
+
+			j, ok := best[r.Path]
+			if !ok {
+				best[r.Path], query[r.Path] = len(results), i
+				results = append(results, r)
+			} else if r.Distance < results[j].Distance {
+				results[j], query[r.Path] = r, i
+			} else {
+				continue
+			}
+
+			orientation[r.Path] = h.Orientation
+		}
+	}
+
+	if len(results) == 0 && *format == "text" {
+		fmt.Printf("No matches were found.\n")
+		return 0
+	}
+
+	sort.Stable(results)
+
+	for _, r := range results {
+		rec := record{
+			{"distance", r.Distance},
+			{"hash", hexHash(r.Hash)},
+			{"path", r.Path},
+		}
+
+		if len(queries) > 1 {
+			rec = append(record{{"query", queries[query[r.Path]]}}, rec...)
+		}
+
+		if *orient {
+			rec = append(rec, field{"orientation", orientation[r.Path].String()})
+		}
+
+		out.Write(rec)
+	}
+
+	return 0
+}
+
+// search finds the entries of the index within distance of hash, in
+// any orientation if oriented is set, and otherwise as it is.
+func search(index *imghash.Index, hash, distance uint64, oriented bool) []imghash.OrientedHit {
+	if oriented {
+		return index.SearchOriented(hash, distance)
+	}
+
+	hits := index.Search(hash, distance)
+	out := make([]imghash.OrientedHit, len(hits))
+	for i, h := range hits {
+		out[i].Hit = h
+	}
+
+	return out
+}
+
+func runIndexExport(fs *flag.FlagSet, args []string) int {
+	file := fs.String("o", "", "")
+	word := fs.Int("word", 8, "")
+	stride := fs.Int("stride", 0, "")
+	align := fs.Int("align", 0, "")
+	be := fs.Bool("be", false, "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(*file) == 0 || len(args) != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d hash(es) exported, %d bytes.\n", r.Get("entries"), r.Get("bytes"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	ids := index.IDs()
+	hashes := make([]uint64, len(ids))
+	for i, id := range ids {
+		hashes[i], _ = index.Hash(id)
+	}
+
+	fd, err := os.Create(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	layout := &imghash.PackLayout{WordSize: *word, Stride: *stride, Align: *align, BigEndian: *be}
+	n, err := imghash.PackHashes(fd, hashes, layout)
+	if err == nil {
+		err = fd.Close()
+	} else {
+		fd.Close()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	out.Write(record{
+		{"blocks", *file},
+		{"entries", len(hashes)},
+		{"bytes", n},
+	})
+
+	return 0
+}
+
+func runIndexResolve(fs *flag.FlagSet, args []string) int {
+	size := fs.Int("index", 4, "")
+	be := fs.Bool("be", false, "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(args) != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%s %s\n", r.Get("hash"), r.Get("path"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	fd, err := os.Open(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer fd.Close()
+
+	// Rows are numbered in the order export wrote them.
+	ids := index.IDs()
+	rows, err := imghash.ReadIndices(fd, len(ids), &imghash.PackLayout{IndexSize: *size, BigEndian: *be})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[1], err)
+		return 1
+	}
+
+	for _, row := range rows {
+		hash, _ := index.Hash(ids[row])
+		out.Write(record{
+			{"row", row},
+			{"hash", hexHash(hash)},
+			{"path", ids[row]},
+		})
+	}
+
+	return 0
+}
+
+func runIndexLoad(fs *flag.FlagSet, args []string) int {
+	file := fs.String("o", policy.Index.Path, "")
+	algo := fs.String("a", "", "")
+	quality := fs.Int("quality", 0, "")
+	typ := fs.String("type", "", "")
+	format := formatFlag(fs)
+	lists := parseInterleaved(fs, args)
+
+	if len(*file) == 0 || len(lists) == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d hash(es) loaded from %s.\n", r.Get("entries"), r.Get("list"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(*file)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	if len(*algo) == 0 && len(index.Algorithm) == 0 {
+		*algo = defaultAlgorithm()
+	}
+
+	for _, list := range lists {
+		entries, err := readList(list, *typ)
+		if err == nil {
+			var n int
+			if n, err = hashlist.AddToIndex(index, entries, *algo, *quality); err == nil {
+				out.Write(record{
+					{"list", list},
+					{"index", *file},
+					{"algorithm", index.Algorithm},
+					{"entries", n},
+				})
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", list, err)
+			return 1
+		}
+	}
+
+	if err := index.Save(*file); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	return 0
+}
+
+func runIndexDump(fs *flag.FlagSet, args []string) int {
+	file := fs.String("o", "", "")
+	typ := fs.String("type", "", "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(*file) == 0 || len(args) != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	f := hashlist.FormatOf(*file)
+	if len(*typ) > 0 {
+		var err error
+		if f, err = hashlist.ParseFormat(*typ); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d hash(es) written to %s.\n", r.Get("entries"), r.Get("list"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	entries := hashlist.FromIndex(index)
+
+	fd, err := os.Create(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if err = hashlist.Write(fd, f, entries); err == nil {
+		err = fd.Close()
+	} else {
+		fd.Close()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	out.Write(record{
+		{"list", *file},
+		{"algorithm", index.Algorithm},
+		{"entries", len(entries)},
+	})
+
+	return 0
+}
+
+func runIndexMap(fs *flag.FlagSet, args []string) int {
+	file := fs.String("o", "", "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(*file) == 0 || len(args) != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d entries mapped to %s.\n", r.Get("entries"), r.Get("mapped"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	if err := index.SaveMapped(*file); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	out.Write(record{
+		{"mapped", *file},
+		{"algorithm", index.Algorithm},
+		{"entries", index.Len()},
+	})
+
+	return 0
+}
+
+func runIndexMatrix(fs *flag.FlagSet, args []string) int {
+	file := fs.String("o", "", "")
+	idFile := fs.String("ids", "", "")
+	var enc imghash.MatrixEncoding
+	fs.TextVar(&enc, "encoding", imghash.MatrixBits, "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(*file) == 0 || len(args) != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	npy := strings.EqualFold(filepath.Ext(*file), ".npy")
+	if !npy && !strings.EqualFold(filepath.Ext(*file), ".csv") {
+		fmt.Fprintf(os.Stderr, "%s: matrix must be a .npy or .csv file\n", *file)
+		return 1
+	}
+
+	if npy && len(*idFile) == 0 {
+		*idFile = strings.TrimSuffix(*file, filepath.Ext(*file)) + ".ids"
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d hash(es) written to %s.\n", r.Get("entries"), r.Get("matrix"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	index, err := loadIndex(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	ids := index.IDs()
+	hashes := make([]uint64, len(ids))
+	for i, id := range ids {
+		hashes[i], _ = index.Hash(id)
+	}
+
+	err = writeFile(*file, func(w io.Writer) error {
+		if npy {
+			return imghash.WriteNPY(w, hashes, enc)
+		}
+		return imghash.WriteMatrixCSV(w, ids, hashes, enc)
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	if npy {
+		err = writeFile(*idFile, func(w io.Writer) error {
+			for _, id := range ids {
+				if _, err := fmt.Fprintln(w, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *idFile, err)
+			return 1
+		}
+	}
+
+	r := record{
+		{"matrix", *file},
+		{"encoding", enc.String()},
+		{"entries", len(hashes)},
+	}
+
+	if npy {
+		r = append(r, field{"ids", *idFile})
+	}
+
+	out.Write(r)
+	return 0
+}
+
+// writeFile creates the given file, and writes it with write.
+func writeFile(file string, write func(io.Writer) error) error {
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err := write(fd); err != nil {
+		fd.Close()
+		return err
+	}
+
+	return fd.Close()
+}
+
+// readList reads the hash list in the given file, in the format named
+// by typ, or the one its extension tells.
+func readList(file, typ string) ([]hashlist.Entry, error) {
+	if len(typ) == 0 {
+		return hashlist.Load(file)
+	}
+
+	f, err := hashlist.ParseFormat(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return hashlist.Read(fd, f)
+}
+
+// loadIndex loads the given index file. It returns an empty index
+// along with the error if the file does not exist.
+func loadIndex(file string) (*imghash.Index, error) {
+	index := imghash.NewIndex()
+	return index, index.Load(file)
+}
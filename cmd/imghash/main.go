@@ -0,0 +1,184 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/config"
+	_ "github.com/jteeuwen/imghash/ximage"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A command is a single imghash subcommand.
+type command struct {
+	Name  string              // Name by which the command is invoked.
+	Args  string              // Summary of the command's arguments.
+	Short string              // One line description.
+	Flags func(*flag.FlagSet) // Prints the command's options, if any.
+	Run   func(args []string) int
+}
+
+// commands lists all subcommands by name.
+var commands = make(map[string]*command)
+
+// register makes a subcommand available.
+func register(cmd *command) {
+	commands[cmd.Name] = cmd
+}
+
+// policy holds the settings of the configuration file given with
+// -config or IMGHASH_CONFIG. Those left out are zero.
+var policy = new(config.Config)
+
+func main() {
+	args := os.Args[1:]
+	file := os.Getenv("IMGHASH_CONFIG")
+
+	if len(args) > 1 && args[0] == "-config" {
+		file, args = args[1], args[2:]
+	}
+
+	if len(args) == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	if len(file) > 0 {
+		if err := loadPolicy(file); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	name := args[0]
+
+	switch name {
+	case "-v", "version":
+		fmt.Printf("%s\n", Version())
+		return
+	case "-h", "help":
+		usage()
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", name)
+		usage()
+		os.Exit(1)
+	}
+
+	os.Exit(cmd.Run(args[1:]))
+}
+
+// loadPolicy loads the configuration file.
+func loadPolicy(file string) error {
+	c, err := config.Load(file)
+	if err != nil {
+		return err
+	}
+
+	h, err := c.Hasher()
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	if err := imghash.SetDefaultHasher(h); err != nil {
+		return err
+	}
+
+	policy = c
+	return nil
+}
+
+// defaultAlgorithm returns the algorithm of the default hasher: that of
+// the configuration file, or average if there is none.
+func defaultAlgorithm() string {
+	return imghash.DefaultHasher().Algorithm
+}
+
+// usage prints a listing of all subcommands.
+func usage() {
+	fmt.Printf("Usage: %s [-config <file>] <command> [arguments]\n\n", os.Args[0])
+	fmt.Printf("Commands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("  %-10s %s\n", name, commands[name].Short)
+	}
+
+	fmt.Printf("  %-10s %s\n", "version", "Display version information.")
+	fmt.Printf("\nWith -config, or a file named by IMGHASH_CONFIG, the algorithm,\n" +
+		"filters, thresholds and other defaults are read from a configuration\n" +
+		"file. Options given to a command override it.\n")
+	fmt.Printf("\nRun '%s <command> -h' for help on a specific command.\n", os.Args[0])
+}
+
+// newFlags creates the flag set for the given command.
+// Its usage function prints the command's help text.
+func newFlags(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s %s [options] %s\n\n", os.Args[0], cmd.Name, cmd.Args)
+		fmt.Printf("%s\n\n", cmd.Short)
+
+		if cmd.Flags != nil {
+			cmd.Flags(fs)
+		}
+	}
+
+	return fs
+}
+
+// parseInterleaved parses args with the given flag set, allowing
+// flags and positional arguments to be mixed. It returns the
+// positional arguments.
+func parseInterleaved(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+
+	for {
+		fs.Parse(args)
+
+		if fs.NArg() == 0 {
+			return rest
+		}
+
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// findAlgorithm returns the algorithm with the given name. The filters
+// of the configuration file run before it, and the thresholds of the
+// file replace its own if it is the configured algorithm.
+func findAlgorithm(name string) (*imghash.Algorithm, error) {
+	a, err := imghash.LookupAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Hash = policy.HashFunc(a.Hash)
+	if strings.EqualFold(name, policy.Algorithm) {
+		a.Thresholds = policy.ApplyThresholds(a.Thresholds)
+	}
+
+	return a, nil
+}
+
+// algorithmNames returns a comma-separated list of the supported
+// algorithm names, for use in help texts.
+func algorithmNames() string {
+	return strings.Join(imghash.Algorithms(), ", ")
+}
@@ -0,0 +1,209 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/migrate"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+func init() {
+	register(&command{
+		Name:  "migrate",
+		Args:  "-o <mapping> [-index <index> | -manifest <file> | <directory...>]",
+		Short: "Re-hash a collection with another algorithm, keeping its IDs.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("         -o: Mapping file to write. An existing file is resumed.\n")
+			fmt.Printf("         -a: Hashing algorithm to migrate to. Defaults to average.\n"+
+				"             Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("     -index: Migrate the entries of this index.\n")
+			fmt.Printf("  -manifest: Migrate the images listed in this CSV file, with\n" +
+				"             an ID, a path and optionally the old hash per row.\n")
+			fmt.Printf(" -index-out: Also write the new hashes to this index.\n")
+			fmt.Printf("         -w: Number of concurrent workers. Defaults to one per CPU.\n")
+			fmt.Printf("  -progress: Periodically print progress to stderr.\n")
+			formatHelp(11)
+			fmt.Printf("\nWithout -index or -manifest, all images in the given directories\n" +
+				"are migrated, with their paths as IDs. The mapping file lists the\n" +
+				"ID, path, old hash, new hash and error of every image, in CSV.\n" +
+				"If a run is interrupted, running it again with the same mapping\n" +
+				"file skips the images which were done already.\n")
+		},
+		Run: runMigrate,
+	})
+}
+
+func runMigrate(args []string) int {
+	fs := newFlags(commands["migrate"])
+	file := fs.String("o", "", "")
+	algo := fs.String("a", defaultAlgorithm(), "")
+	indexFile := fs.String("index", "", "")
+	manifest := fs.String("manifest", "", "")
+	indexOut := fs.String("index-out", "", "")
+	workers := fs.Int("w", policy.Workers, "")
+	progress := fs.Bool("progress", false, "")
+	format := formatFlag(fs)
+	dirs := parseInterleaved(fs, args)
+
+	// Exactly one source of images must be given.
+	var sources int
+	for _, ok := range []bool{len(*indexFile) > 0, len(*manifest) > 0, len(dirs) > 0} {
+		if ok {
+			sources++
+		}
+	}
+
+	if len(*file) == 0 || sources != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "* %d image(s) migrated, %d skipped, %d failed.\n",
+			r.Get("migrated"), r.Get("skipped"), r.Get("failed"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	items, err := migrateItems(*indexFile, *manifest, dirs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	opts := &migrate.Options{Workers: *workers}
+	if *progress {
+		start := time.Now()
+		last := start
+
+		opts.OnProgress = func(p migrate.Progress) {
+			if now := time.Now(); now.Sub(last) >= 2*time.Second || p.Skipped+p.Done == p.Total {
+				last = now
+				fmt.Fprintf(os.Stderr, "* %d of %d image(s) migrated, %d failed (%.1f/sec)\n",
+					p.Skipped+p.Done, p.Total, p.Failed, float64(p.Done)/now.Sub(start).Seconds())
+			}
+		}
+	}
+
+	p, err := migrate.Run(items, migrate.Hash64(a.Hash), *file, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	status := 0
+	if p.Failed > 0 {
+		status = 1
+	}
+
+	if len(*indexOut) > 0 {
+		if err := writeMigratedIndex(*file, *indexOut, *algo); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *indexOut, err)
+			return 1
+		}
+	}
+
+	out.Write(record{
+		{"mapping", *file},
+		{"algorithm", *algo},
+		{"total", p.Total},
+		{"skipped", p.Skipped},
+		{"migrated", p.Done - p.Failed},
+		{"failed", p.Failed},
+	})
+
+	return status
+}
+
+// migrateItems lists the images to migrate, from an index,
+// a manifest, or the given directories.
+func migrateItems(indexFile, manifest string, dirs []string) ([]*migrate.Item, error) {
+	switch {
+	case len(indexFile) > 0:
+		index, err := loadIndex(indexFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", indexFile, err)
+		}
+		return migrate.FromIndex(index), nil
+
+	case len(manifest) > 0:
+		fd, err := os.Open(manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		defer fd.Close()
+
+		items, err := migrate.ReadManifest(fd)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", manifest, err)
+		}
+		return items, nil
+	}
+
+	var items []*migrate.Item
+	for file := range walkImages(dirs, 0, nil) {
+		// Use absolute paths, as index build does.
+		path, err := filepath.Abs(file)
+		if err != nil {
+			path = file
+		}
+
+		items = append(items, &migrate.Item{ID: path, Path: path})
+	}
+
+	return items, nil
+}
+
+// writeMigratedIndex writes the new hashes in the mapping
+// file to an index. Images which failed are left out.
+func writeMigratedIndex(mapping, file, algo string) error {
+	fd, err := os.Open(mapping)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	records, err := migrate.ReadMapping(fd)
+	if err != nil {
+		return err
+	}
+
+	index := imghash.NewIndex()
+	index.Algorithm = algo
+
+	for _, r := range records {
+		if len(r.Err) > 0 {
+			continue
+		}
+
+		hash, err := strconv.ParseUint(r.New, 16, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid hash %q", r.ID, r.New)
+		}
+
+		index.Add(r.ID, hash)
+	}
+
+	return index.Save(file)
+}
@@ -0,0 +1,83 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build fsnotify
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// notifications is true if this build has file system notifications.
+const notifications = true
+
+// notify returns a channel which receives when files under root change,
+// until ctx is done. Directories created later are watched as well.
+func notify(ctx context.Context, root string) (<-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watchTree(w, root); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	c := make(chan struct{}, 1)
+
+	go func() {
+		defer w.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+
+				if e.Has(fsnotify.Create) {
+					if stat, err := os.Lstat(e.Name); err == nil && stat.IsDir() {
+						watchTree(w, e.Name)
+					}
+				}
+
+				// Scans cover all changes since the last one.
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+
+				// Overflows lose events; the periodic scans catch up.
+				fmt.Fprintf(os.Stderr, "%s: %v\n", root, err)
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// watchTree adds dir and all directories below it to the watcher.
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return w.Add(file)
+	})
+}
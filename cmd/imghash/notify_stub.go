@@ -0,0 +1,18 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build !fsnotify
+
+package main
+
+import "context"
+
+// notifications is true if this build has file system notifications.
+// They come from github.com/fsnotify/fsnotify, with the fsnotify tag;
+// the default build has no external dependencies, and polls.
+const notifications = false
+
+// notify returns nil: the watch command only polls.
+func notify(ctx context.Context, root string) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,50 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build fsnotify
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := notify(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification for %s", what)
+		}
+	}
+
+	// New directories are watched too.
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wait("new directory")
+
+	// Let the watcher add it before writing to it.
+	time.Sleep(100 * time.Millisecond)
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	writeImages(t, sub, map[string]string{"gopher.jpg": "gopher.jpg"})
+	wait("file in new directory")
+}
@@ -0,0 +1,160 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A field is a single named value in a record.
+type field struct {
+	Name  string
+	Value interface{}
+}
+
+// A record is a single row of output. Each subcommand emits records
+// with a fixed set of fields, in a fixed order. This forms the schema
+// for the machine-readable formats, so fields must not be removed or
+// reordered. New fields go at the end.
+type record []field
+
+// Get returns the value of the named field.
+func (r record) Get(name string) interface{} {
+	for _, f := range r {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+
+	return nil
+}
+
+// output writes records to stdout in the selected format.
+//
+// Supported formats are:
+//
+//	text:  Human-readable; its layout is up to each subcommand.
+//	json:  A single JSON array holding one object per record.
+//	jsonl: One JSON object per line.
+//	csv:   A header row with the field names, followed by one row per record.
+type output struct {
+	format string
+	text   func(io.Writer, record)
+	w      *bufio.Writer
+	csv    *csv.Writer
+	count  int
+}
+
+// formatFlag adds the -format option to the given flag set.
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", "text", "")
+}
+
+// formatHelp prints the help text for the -format option.
+func formatHelp(indent int) {
+	fmt.Printf("%*s: Output format: text, json, jsonl or csv. Defaults to text.\n", indent, "-format")
+}
+
+// newOutput creates an output for the given format. The text function
+// writes a single record in the human-readable format.
+func newOutput(format string, text func(io.Writer, record)) (*output, error) {
+	switch format {
+	case "text", "json", "jsonl", "csv":
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+
+	o := &output{format: format, text: text, w: bufio.NewWriter(os.Stdout)}
+	if format == "csv" {
+		o.csv = csv.NewWriter(o.w)
+	}
+
+	return o, nil
+}
+
+// Write writes a single record.
+func (o *output) Write(r record) {
+	switch o.format {
+	case "text":
+		o.text(o.w, r)
+
+	case "json":
+		if o.count == 0 {
+			o.w.WriteString("[\n  ")
+		} else {
+			o.w.WriteString(",\n  ")
+		}
+		o.writeJSON(r)
+
+	case "jsonl":
+		o.writeJSON(r)
+		o.w.WriteByte('\n')
+
+	case "csv":
+		row := make([]string, len(r))
+
+		if o.count == 0 {
+			for i, f := range r {
+				row[i] = f.Name
+			}
+			o.csv.Write(row)
+		}
+
+		for i, f := range r {
+			row[i] = fmt.Sprint(f.Value)
+		}
+		o.csv.Write(row)
+	}
+
+	o.count++
+}
+
+// Close finishes the output and flushes it to stdout.
+func (o *output) Close() error {
+	switch o.format {
+	case "json":
+		if o.count == 0 {
+			o.w.WriteString("[]\n")
+		} else {
+			o.w.WriteString("\n]\n")
+		}
+
+	case "csv":
+		o.csv.Flush()
+	}
+
+	return o.w.Flush()
+}
+
+// writeJSON writes the record as a JSON object, with its
+// fields in record order.
+func (o *output) writeJSON(r record) {
+	o.w.WriteByte('{')
+
+	for i, f := range r {
+		if i > 0 {
+			o.w.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(f.Name)
+		value, _ := json.Marshal(f.Value)
+
+		o.w.Write(key)
+		o.w.WriteByte(':')
+		o.w.Write(value)
+	}
+
+	o.w.WriteByte('}')
+}
+
+// hexHash formats a hash the same way in all output formats.
+func hexHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
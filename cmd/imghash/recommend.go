@@ -0,0 +1,91 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash/eval"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	register(&command{
+		Name:  "recommend",
+		Args:  "<directory>",
+		Short: "Rank the algorithms on a sample of a collection, with thresholds.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("     -a: Comma separated list of algorithms to compare. Defaults\n"+
+				"         to all of them. Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("     -n: Number of images to sample. Defaults to 50.\n")
+			fmt.Printf("  -seed: Seed for sampling images. Defaults to 1.\n")
+			formatHelp(7)
+			fmt.Printf("\nEvery sampled image is edited in the ways of the attack package:\n" +
+				"recompressed, scaled, cropped, rotated and so on. Each algorithm is\n" +
+				"scored on how well it tells these duplicates from the other images,\n" +
+				"and the algorithms are listed best first, with the thresholds which\n" +
+				"separate the two best. No labels are needed.\n")
+		},
+		Run: runRecommend,
+	})
+}
+
+func runRecommend(args []string) int {
+	fs := newFlags(commands["recommend"])
+	algo := fs.String("a", "", "")
+	n := fs.Int("n", 50, "")
+	seed := fs.Int64("seed", 1, "")
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	opts := &eval.RecommendOptions{Images: *n, Seed: *seed}
+	if len(*algo) > 0 {
+		opts.Algorithms = strings.Split(*algo, ",")
+	}
+
+	recs, err := eval.RecommendDir(fs.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%d. %s:\n", r.Get("rank"), r.Get("algorithm"))
+		fmt.Fprintf(w, "  auc:            %.3f\n", r.Get("auc"))
+		fmt.Fprintf(w, "  near-duplicate: %d\n", r.Get("near_duplicate"))
+		fmt.Fprintf(w, "  duplicate:      %d\n", r.Get("duplicate"))
+		fmt.Fprintf(w, "  recall:         %.1f%%\n", 100*r.Get("tpr").(float64))
+		fmt.Fprintf(w, "  false pos:      %.1f%%\n", 100*r.Get("fpr").(float64))
+		fmt.Fprintf(w, "  time:           %v\n", r.Get("time"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	for i, rec := range recs {
+		out.Write(record{
+			{"rank", i + 1},
+			{"algorithm", rec.Algorithm},
+			{"auc", rec.Result.AUC},
+			{"near_duplicate", rec.Thresholds.NearDuplicate},
+			{"duplicate", rec.Thresholds.Duplicate},
+			{"tpr", rec.Result.Best.TPR()},
+			{"fpr", rec.Result.Best.FPR()},
+			{"time", rec.Time},
+		})
+	}
+
+	return 0
+}
@@ -0,0 +1,143 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"os"
+	"time"
+)
+
+func init() {
+	register(&command{
+		Name:  "series",
+		Args:  "<directory...>",
+		Short: "Group photo bursts into series of similar shots.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("         -a: Hashing algorithm to use. Defaults to average.\n"+
+				"             Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("         -t: Hamming Distance between successive shots of a series.\n" +
+				"             Defaults to the near-duplicate threshold of the algorithm.\n")
+			fmt.Printf("    -window: Largest time between successive shots. Defaults to 2s.\n")
+			fmt.Printf("       -min: Skip files smaller than this many bytes.\n")
+			batchHelp(11)
+			formatHelp(11)
+			fmt.Printf("\nShots are ordered by their modification time. In the text format,\n" +
+				"series are separated by an empty line. The other formats hold one\n" +
+				"record per file, with a series number.\n")
+		},
+		Run: runSeries,
+	})
+}
+
+func runSeries(args []string) int {
+	fs := newFlags(commands["series"])
+	algo := fs.String("a", defaultAlgorithm(), "")
+	dist := fs.Int("t", -1, "")
+	window := fs.Duration("window", 2*time.Second, "")
+	minSize := fs.Int64("min", 0, "")
+	batch := newBatchFlags(fs)
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	log, err := batch.logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cache, err := batch.openCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+		return 1
+	}
+
+	cp, err := batch.openCheckpoint()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.checkpoint, err)
+		return 1
+	}
+
+	last := -1
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		if series := r.Get("series").(int); series != last {
+			if last != -1 {
+				fmt.Fprintln(w)
+			}
+			last = series
+		}
+
+		fmt.Fprintf(w, "%s %s %s\n", r.Get("hash"), r.Get("time"), r.Get("path"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	threshold := a.Thresholds.NearDuplicate
+	if *dist >= 0 {
+		threshold = uint64(*dist)
+	}
+
+	status := 0
+
+	var entries []*imghash.Entry
+	for r := range imghash.HashFiles(walkImages(fs.Args(), *minSize, log), a.Hash, batch.options(*algo, log, cache, cp)) {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.Path, r.Err)
+			status = 1
+			continue
+		}
+
+		stat, err := os.Stat(r.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			status = 1
+			continue
+		}
+
+		entries = append(entries, &imghash.Entry{Path: r.Path, Hash: r.Hash, ModTime: stat.ModTime().Unix()})
+	}
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+			status = 1
+		}
+	}
+
+	if batch.removeCheckpoint(cp) != 0 {
+		status = 1
+	}
+
+	for i, s := range imghash.Series(entries, threshold, *window) {
+		for _, e := range s {
+			out.Write(record{
+				{"series", i},
+				{"path", e.Path},
+				{"hash", hexHash(e.Hash)},
+				{"time", time.Unix(e.ModTime, 0).Format(time.RFC3339)},
+			})
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,87 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash/sqlfunc"
+	"io"
+	"os"
+)
+
+func init() {
+	register(&command{
+		Name:  "sql",
+		Args:  "",
+		Short: "Print SQL which computes the distance between hashes in the database.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("   -dialect: Dialect of SQL: postgres or sqlite. Defaults to postgres.\n")
+			fmt.Printf("  -encoding: How hashes are stored: integer, as 64 bit integers, or\n" +
+				"             hex, as 16 hex digits. Defaults to integer.\n")
+			fmt.Printf("      -name: Name of the function. Defaults to imghash_distance.\n")
+			fmt.Printf("     -table: Table queried. Defaults to imghash.\n")
+			fmt.Printf("        -id: Column of the IDs. Defaults to id.\n")
+			fmt.Printf("      -hash: Column of the hashes. Defaults to hash.\n")
+			fmt.Printf("-registered: For SQLite, call the function by name, rather than\n" +
+				"             inlining the expression. The driver must register it.\n")
+			formatHelp(11)
+			fmt.Printf("\nPrints the statements creating the function, if the dialect can\n" +
+				"define one, followed by a radius query which takes the hash and the\n" +
+				"distance as parameters. Every query compares against all rows; use\n" +
+				"it for small collections only.\n")
+		},
+		Run: runSQL,
+	})
+}
+
+func runSQL(args []string) int {
+	fs := newFlags(commands["sql"])
+	dialect := fs.String("dialect", "postgres", "")
+	encoding := fs.String("encoding", "integer", "")
+	name := fs.String("name", sqlfunc.DefaultName, "")
+	table := fs.String("table", "imghash", "")
+	id := fs.String("id", "id", "")
+	hash := fs.String("hash", "hash", "")
+	registered := fs.Bool("registered", false, "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(args) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	d, err := sqlfunc.ParseDialect(*dialect)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	e, err := sqlfunc.ParseEncoding(*encoding)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	g := sqlfunc.New(&sqlfunc.Options{Dialect: d, Encoding: e, Name: *name, Registered: *registered})
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%s;\n", r.Get("sql"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	for _, stmt := range g.Functions() {
+		out.Write(record{{"kind", "function"}, {"sql", stmt}})
+	}
+
+	out.Write(record{{"kind", "query"}, {"sql", g.QuerySQL(*table, *id, *hash)}})
+	return 0
+}
@@ -0,0 +1,179 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/eval"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	register(&command{
+		Name:  "threshold",
+		Args:  "<directory...>",
+		Short: "Suggest a threshold from the distances within a collection.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf("         -a: Hashing algorithm to use. Defaults to average.\n"+
+				"             Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("     -pairs: Number of pairs to sample. Defaults to 1000000.\n" +
+				"             Smaller collections use all of their pairs.\n")
+			fmt.Printf("      -seed: Seed for sampling pairs. Defaults to 1.\n")
+			fmt.Printf(" -histogram: List the number of pairs at each distance, rather\n" +
+				"             than a summary.\n")
+			fmt.Printf("       -min: Skip files smaller than this many bytes.\n")
+			batchHelp(11)
+			formatHelp(11)
+			fmt.Printf("\nNo labels are needed: the distances between images are modeled\n" +
+				"as a mix of duplicates and distinct images, and the threshold which\n" +
+				"best separates the two is reported, with its estimated error rates.\n" +
+				"For exact figures, label some pairs and use eval.\n")
+		},
+		Run: runThreshold,
+	})
+}
+
+func runThreshold(args []string) int {
+	fs := newFlags(commands["threshold"])
+	algo := fs.String("a", defaultAlgorithm(), "")
+	pairs := fs.Int("pairs", 1000000, "")
+	seed := fs.Int64("seed", 1, "")
+	histogram := fs.Bool("histogram", false, "")
+	minSize := fs.Int64("min", 0, "")
+	batch := newBatchFlags(fs)
+	format := formatFlag(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	a, err := findAlgorithm(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	log, err := batch.logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cache, err := batch.openCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+		return 1
+	}
+
+	cp, err := batch.openCheckpoint()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.checkpoint, err)
+		return 1
+	}
+
+	text := func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%s:\n", r.Get("algorithm"))
+		fmt.Fprintf(w, "  images:     %d\n", r.Get("images"))
+		fmt.Fprintf(w, "  pairs:      %d\n", r.Get("pairs"))
+		fmt.Fprintf(w, "  threshold:  %d\n", r.Get("threshold"))
+		fmt.Fprintf(w, "  false pos:  %.3f%%\n", 100*r.Get("fpr").(float64))
+		fmt.Fprintf(w, "  false neg:  %.3f%%\n", 100*r.Get("fnr").(float64))
+		fmt.Fprintf(w, "  duplicates: %.3f%% of pairs\n", 100*r.Get("duplicates").(float64))
+	}
+
+	// Largest count in the histogram, to scale the bars by.
+	var most int
+
+	if *histogram {
+		text = func(w io.Writer, r record) {
+			count := r.Get("count").(int)
+			fmt.Fprintf(w, "%2d %9d %s\n", r.Get("distance"), count, strings.Repeat("#", bar(count, most, 50)))
+		}
+	}
+
+	out, err := newOutput(*format, text)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	status := 0
+
+	var hashes []uint64
+	for r := range imghash.HashFiles(walkImages(fs.Args(), *minSize, log), a.Hash, batch.options(*algo, log, cache, cp)) {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.Path, r.Err)
+			status = 1
+			continue
+		}
+
+		hashes = append(hashes, r.Hash)
+	}
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *batch.cache, err)
+			status = 1
+		}
+	}
+
+	if batch.removeCheckpoint(cp) != 0 {
+		status = 1
+	}
+
+	h := eval.SampleDistances(hashes, *pairs, *seed)
+
+	if *histogram {
+		for _, n := range h {
+			if n > most {
+				most = n
+			}
+		}
+
+		for d, n := range h {
+			out.Write(record{
+				{"distance", d},
+				{"count", n},
+			})
+		}
+
+		return status
+	}
+
+	s, err := eval.Suggest(h)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	out.Write(record{
+		{"algorithm", *algo},
+		{"images", len(hashes)},
+		{"pairs", h.Total()},
+		{"threshold", s.Threshold},
+		{"fpr", s.FPR},
+		{"fnr", s.FNR},
+		{"duplicates", s.Duplicates},
+	})
+
+	return status
+}
+
+// bar returns the length of a bar for n, out of a longest bar of
+// width for most.
+func bar(n, most, width int) int {
+	if most == 0 {
+		return 0
+	}
+
+	return (n*width + most - 1) / most
+}
@@ -0,0 +1,37 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"runtime"
+	"strings"
+)
+
+const (
+	AppName         = "imghash"
+	AppVersionMajor = 0
+	AppVersionMinor = 1
+)
+
+// revision part of the program version.
+// This will be set automatically at build time like so:
+//
+//	go build -ldflags "-X main.AppVersionRev `date -u +%s`"
+var AppVersionRev string
+
+func Version() string {
+	if len(AppVersionRev) == 0 {
+		AppVersionRev = "0"
+	}
+
+	var paths strings.Builder
+	for _, p := range imghash.CodePaths() {
+		fmt.Fprintf(&paths, "\n  %s", p)
+	}
+
+	return fmt.Sprintf("%s %d.%d.%s (Go runtime %s).\nCopyright (c) 2010-2012, Jim Teeuwen.\n\nCode paths on %s/%s:%s",
+		AppName, AppVersionMajor, AppVersionMinor, AppVersionRev, runtime.Version(), runtime.GOOS, runtime.GOARCH, paths.String())
+}
@@ -0,0 +1,145 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	register(&command{
+		Name:  "watch",
+		Args:  "-index <index> <directory>",
+		Short: "Watch a directory and report duplicates as images arrive.",
+		Flags: func(fs *flag.FlagSet) {
+			fmt.Printf(" -index: Index to check against and add new images to.\n" +
+				"         It is created if it does not exist.\n")
+			fmt.Printf("     -a: Hashing algorithm for new indexes. Defaults to average.\n"+
+				"         Supported algorithms: %s.\n", algorithmNames())
+			fmt.Printf("     -d: Hamming Distance to use when matching hashes.\n" +
+				"         Defaults to the near-duplicate threshold of the algorithm.\n")
+			fmt.Printf("     -i: Interval at which the directory is scanned. Defaults to 2s.\n")
+			fmt.Printf("     -s: How long a file must go unchanged before it is hashed.\n" +
+				"         Defaults to the interval.\n")
+			formatHelp(7)
+			fmt.Printf("\nFiles are hashed once they have not changed for a while (-s),\n" +
+				"so files which are still being written are not picked up early.\n" +
+				"Files which fail to hash are tried again, up to 3 times.\n")
+			if notifications {
+				fmt.Printf("File system notifications start a scan as soon as files change;\n" +
+					"the interval scans catch what they miss.\n")
+			}
+		},
+		Run: runWatch,
+	})
+}
+
+func runWatch(args []string) int {
+	fs := newFlags(commands["watch"])
+	file := fs.String("index", policy.Index.Path, "")
+	algo := fs.String("a", defaultAlgorithm(), "")
+	dist := fs.Int("d", -1, "")
+	interval := fs.Duration("i", 2*time.Second, "")
+	settle := fs.Duration("s", 0, "")
+	format := formatFlag(fs)
+	args = parseInterleaved(fs, args)
+
+	if len(*file) == 0 || len(args) != 1 || *interval <= 0 {
+		fs.Usage()
+		return 1
+	}
+
+	index, err := loadIndex(*file)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	if len(index.Algorithm) == 0 {
+		index.Algorithm = *algo
+	}
+
+	a, err := findAlgorithm(index.Algorithm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+
+	distance := a.Thresholds.NearDuplicate
+	if *dist >= 0 {
+		distance = uint64(*dist)
+	}
+
+	out, err := newOutput(*format, func(w io.Writer, r record) {
+		fmt.Fprintf(w, "%s ~ %s (distance %d)\n", r.Get("path"), r.Get("match"), r.Get("distance"))
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	defer out.Close()
+
+	root, err := filepath.Abs(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	changes, err := notify(ctx, root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", root, err)
+		return 1
+	}
+
+	err = imghash.Watch(ctx, root, index, a.Hash, &imghash.WatchOptions{
+		Interval: *interval,
+		Settle:   *settle,
+		Distance: distance,
+		Accept:   isImage,
+		Notify:   changes,
+
+		OnEvent: func(e imghash.WatchEvent) {
+			if e.Op == imghash.WatchError {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", e.Path, e.Err)
+			}
+
+			for _, h := range e.Matches {
+				out.Write(record{
+					{"path", e.Path},
+					{"hash", hexHash(e.Hash)},
+					{"match", h.ID},
+					{"distance", h.Distance},
+				})
+			}
+		},
+
+		AfterScan: func() {
+			out.w.Flush()
+
+			if err := index.Save(*file); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+			}
+		},
+	})
+
+	if err != nil && err != context.Canceled {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	return 0
+}
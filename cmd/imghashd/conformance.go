@@ -0,0 +1,208 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/fixtures"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// conformanceSeed seeds the hashes of the generated cases, so every
+// server serves the same suite.
+const conformanceSeed = 1
+
+// conformanceCase is a single check of the conformance suite. Inputs
+// and expected outputs are all strings, so clients compare them as is.
+type conformanceCase struct {
+	ID     string            `json:"id"`
+	Kind   string            `json:"kind"`
+	Input  map[string]string `json:"input"`
+	Expect map[string]string `json:"expect"`
+}
+
+// conformanceSuite is returned by /conformance.
+type conformanceSuite struct {
+	Version string            `json:"version"`
+	Cases   []conformanceCase `json:"cases"`
+}
+
+// conformanceResult is the output of a client for a single case.
+type conformanceResult struct {
+	ID     string            `json:"id"`
+	Output map[string]string `json:"output"`
+}
+
+// conformanceFailure is a mismatched output field.
+type conformanceFailure struct {
+	ID    string `json:"id"`
+	Field string `json:"field"`
+	Got   string `json:"got"`
+	Want  string `json:"want"`
+}
+
+// conformanceReport is returned by /conformance/verify.
+type conformanceReport struct {
+	Passed   int                  `json:"passed"`
+	Failed   int                  `json:"failed"`
+	Missing  []string             `json:"missing"`
+	Failures []conformanceFailure `json:"failures"`
+}
+
+// conformanceCases returns the suite: the fixture vectors, distances
+// between hashes, and the text and binary encodings of hashes, multi-
+// hashes and corpora.
+func conformanceCases() []conformanceCase {
+	var cases []conformanceCase
+
+	for _, v := range fixtures.Vectors() {
+		cases = append(cases, conformanceCase{
+			ID:     "hash/" + v.Algorithm + "/" + v.Image,
+			Kind:   "hash",
+			Input:  map[string]string{"algorithm": v.Algorithm, "image": "/conformance/images/" + v.Image},
+			Expect: map[string]string{"hash": imghash.FormatHash(v.Hash)},
+		})
+	}
+
+	rng := rand.New(rand.NewSource(conformanceSeed))
+	pairs := [][2]uint64{{0, 0}, {0, 1<<64 - 1}, {0x0838787c7c3e3c18, 0x0838787c7c3e3c19}}
+	for i := 0; i < 8; i++ {
+		a := rng.Uint64()
+		pairs = append(pairs, [2]uint64{a, a ^ rng.Uint64()&rng.Uint64()&rng.Uint64()})
+	}
+
+	for i, p := range pairs {
+		d := imghash.Distance(p[0], p[1])
+		cases = append(cases, conformanceCase{
+			ID:    "distance/" + strconv.Itoa(i),
+			Kind:  "distance",
+			Input: map[string]string{"a": imghash.FormatHash(p[0]), "b": imghash.FormatHash(p[1])},
+			Expect: map[string]string{
+				"distance": strconv.FormatUint(d, 10),
+				"verdict":  imghash.AverageThresholds.Classify(d).String(),
+			},
+		})
+	}
+
+	for i, n := range []int{1, 3} {
+		m := make(imghash.MultiHash, n)
+		for j := range m {
+			m[j] = rng.Uint64()
+		}
+
+		bin, _ := m.MarshalBinary()
+		cases = append(cases, conformanceCase{
+			ID:     "multihash/" + strconv.Itoa(i),
+			Kind:   "multihash",
+			Input:  map[string]string{"components": joinHashes(m)},
+			Expect: map[string]string{"text": m.String(), "binary": hex.EncodeToString(bin)},
+		})
+	}
+
+	corpus := []uint64{0, 0, 1<<64 - 1}
+	for i := 0; i < 16; i++ {
+		corpus = append(corpus, rng.Uint64())
+	}
+	sort.Slice(corpus, func(i, j int) bool { return corpus[i] < corpus[j] })
+
+	var buf bytes.Buffer
+	imghash.WriteCorpus(&buf, corpus)
+	cases = append(cases, conformanceCase{
+		ID:     "corpus/0",
+		Kind:   "corpus",
+		Input:  map[string]string{"hashes": joinHashes(corpus)},
+		Expect: map[string]string{"binary": hex.EncodeToString(buf.Bytes())},
+	})
+
+	return cases
+}
+
+// joinHashes returns the hashes in hexadecimal, separated by commas.
+func joinHashes(hashes []uint64) string {
+	s := make([]string, len(hashes))
+	for i, h := range hashes {
+		s[i] = imghash.FormatHash(h)
+	}
+	return strings.Join(s, ",")
+}
+
+// conformanceHandler serves the conformance suite, the fixture images
+// it refers to, and the verification of a client's outputs.
+func conformanceHandler() http.Handler {
+	suite := &conformanceSuite{Version: Version(), Cases: conformanceCases()}
+
+	mux := http.NewServeMux()
+	mux.Handle("/conformance/images/", http.StripPrefix("/conformance/images/", http.FileServer(http.FS(fixtures.Images()))))
+
+	mux.HandleFunc("/conformance", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, suite)
+	})
+
+	mux.HandleFunc("/conformance/verify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("expected a POST of results"))
+			return
+		}
+
+		var results []conformanceResult
+		if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, verifyConformance(suite.Cases, results))
+	})
+
+	return mux
+}
+
+// verifyConformance compares the outputs of a client with the expected
+// ones. Cases the client left out are listed as missing; fields it
+// leaves out of an output count as mismatched.
+func verifyConformance(cases []conformanceCase, results []conformanceResult) *conformanceReport {
+	outputs := make(map[string]map[string]string, len(results))
+	for _, r := range results {
+		outputs[r.ID] = r.Output
+	}
+
+	rep := &conformanceReport{Missing: []string{}, Failures: []conformanceFailure{}}
+
+	for _, c := range cases {
+		out, ok := outputs[c.ID]
+		if !ok {
+			rep.Missing = append(rep.Missing, c.ID)
+			continue
+		}
+
+		fields := make([]string, 0, len(c.Expect))
+		for field := range c.Expect {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		passed := true
+		for _, field := range fields {
+			if got, want := out[field], c.Expect[field]; got != want {
+				rep.Failures = append(rep.Failures, conformanceFailure{c.ID, field, got, want})
+				passed = false
+			}
+		}
+
+		if passed {
+			rep.Passed++
+		} else {
+			rep.Failed++
+		}
+	}
+
+	return rep
+}
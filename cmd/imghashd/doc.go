@@ -0,0 +1,14 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+imghashd is a small HTTP server which exposes the imghash package to
+programs not written in Go. It computes hashes for uploaded images or
+remote URLs, compares hashes and searches an image index built with
+`imghash index build`.
+
+All responses are JSON. Hashes are passed around as 16 digit
+hexadecimal strings. Refer to the README for a description of the
+endpoints.
+*/
+package main
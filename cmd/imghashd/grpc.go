@@ -0,0 +1,196 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build grpc
+
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative proto/imghash.proto
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/jteeuwen/imghash"
+	pb "github.com/jteeuwen/imghash/cmd/imghashd/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var grpcAddr = flag.String("grpc", ":8081", "")
+
+func init() {
+	startGRPC = func(s *server) error {
+		l, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+
+		gs := grpc.NewServer()
+		pb.RegisterImghashServer(gs, &grpcServer{s: s})
+		return gs.Serve(l)
+	}
+}
+
+// grpcServer implements the Imghash gRPC service on top of server.
+type grpcServer struct {
+	pb.UnimplementedImghashServer
+	s *server
+}
+
+func (g *grpcServer) Hash(ctx context.Context, req *pb.HashRequest) (*pb.HashResponse, error) {
+	spec, err := g.negotiate(req.GetAlgorithm(), req.GetProfile(), req.GetBits())
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := g.hashImage(ctx, req.GetImage(), spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.HashResponse{Hash: hash, Algorithm: spec.algorithm, Profile: spec.profile, Bits: uint32(spec.bits)}, nil
+}
+
+func (g *grpcServer) Compare(ctx context.Context, req *pb.CompareRequest) (*pb.CompareResponse, error) {
+	spec, err := g.negotiate(req.GetAlgorithm(), "", req.GetBits())
+	if err != nil {
+		return nil, err
+	}
+
+	dist := imghash.Distance(req.A, req.B)
+
+	return &pb.CompareResponse{
+		Distance:   uint32(dist),
+		Similarity: 1 - float64(dist)/float64(spec.bits),
+		Verdict:    spec.thresholds.Classify(dist).String(),
+	}, nil
+}
+
+func (g *grpcServer) Search(ctx context.Context, req *pb.SearchRequest) (*pb.SearchResponse, error) {
+	if g.s.store == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no index loaded")
+	}
+
+	spec, err := g.negotiate(req.GetAlgorithm(), req.GetProfile(), 0)
+	if err == nil {
+		err = g.s.searchable(spec)
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	hash := req.GetHash()
+	if img := req.GetImage(); img != nil {
+		if hash, err = g.hashImage(ctx, img, spec); err != nil {
+			return nil, err
+		}
+	}
+
+	distance := spec.thresholds.NearDuplicate
+	if req.Distance != nil {
+		distance = uint64(*req.Distance)
+	}
+
+	rs, err := g.s.store.Query(ctx, hash, distance)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	resp := &pb.SearchResponse{Hash: hash}
+	for _, r := range rs {
+		resp.Matches = append(resp.Matches, &pb.Match{
+			Id:       r.Path,
+			Hash:     r.Hash,
+			Distance: uint32(r.Distance),
+		})
+	}
+
+	return resp, nil
+}
+
+func (g *grpcServer) BulkInsert(stream pb.Imghash_BulkInsertServer) error {
+	if g.s.store == nil {
+		return status.Error(codes.FailedPrecondition, "no index loaded")
+	}
+
+	// Entries go into the index, so they are hashed as its hashes are.
+	spec, _ := g.negotiate("", "", 0)
+	var inserted uint64
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.BulkInsertResponse{Inserted: inserted})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		hash := req.GetHash()
+		if img := req.GetImage(); img != nil {
+			if hash, err = g.hashImage(stream.Context(), img, spec); err != nil {
+				return err
+			}
+		}
+
+		if err = g.s.store.Add(stream.Context(), req.Id, hash); err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+
+		inserted++
+	}
+}
+
+// negotiate returns what a request asks to be hashed with, as
+// server.negotiate does. A length of 0 means the default.
+func (g *grpcServer) negotiate(algorithm, profile string, bits uint32) (*hashSpec, error) {
+	var b string
+	if bits > 0 {
+		b = strconv.FormatUint(uint64(bits), 10)
+	}
+
+	spec, err := g.s.negotiate(algorithm, profile, b)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return spec, nil
+}
+
+// hashImage hashes the given image, as the spec asks, fetching it first
+// if needed.
+func (g *grpcServer) hashImage(ctx context.Context, img *pb.Image, spec *hashSpec) (uint64, error) {
+	data := img.GetData()
+
+	if url := img.GetUrl(); len(url) > 0 {
+		var err error
+		data, err = imghash.FetchURL(ctx, g.s.client, url, &imghash.FetchOptions{MaxSize: g.s.maxSize})
+		switch {
+		case err == imghash.ErrTooLarge:
+			return 0, status.Error(codes.ResourceExhausted, err.Error())
+		case err != nil:
+			return 0, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+
+	if int64(len(data)) > g.s.maxSize {
+		return 0, status.Error(codes.ResourceExhausted, errTooLarge.Error())
+	}
+
+	hash, err := g.s.hashData(ctx, data, spec)
+	if err != nil {
+		if err == ctx.Err() {
+			return 0, status.FromContextError(err).Err()
+		}
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return hash, nil
+}
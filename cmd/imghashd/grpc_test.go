@@ -0,0 +1,127 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/jteeuwen/imghash"
+	pb "github.com/jteeuwen/imghash/cmd/imghashd/proto"
+	"github.com/jteeuwen/imghash/fixtures"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// newTestClient serves s over gRPC on a local port, and returns a client
+// connected to it.
+func newTestClient(t *testing.T, s *server) pb.ImghashClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gs := grpc.NewServer()
+	pb.RegisterImghashServer(gs, &grpcServer{s: s})
+	go gs.Serve(l)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewImghashClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	s := newTestServer(t, map[string]uint64{"gopher": gopherHash})
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	gopher := &pb.Image{Source: &pb.Image_Data{Data: fixtures.Bytes("gopher.jpg")}}
+
+	h, err := c.Hash(ctx, &pb.HashRequest{Image: gopher})
+	if err != nil || h.Hash != gopherHash || h.Algorithm != "average" || h.Bits != 64 {
+		t.Fatalf("hash: %v, %v", h, err)
+	}
+
+	h, err = c.Hash(ctx, &pb.HashRequest{Image: gopher, Bits: 16})
+	if err != nil || h.Hash != uint64(imghash.Fold16(gopherHash)) || h.Bits != 16 {
+		t.Fatalf("hash 16 bits: %v, %v", h, err)
+	}
+
+	cmp, err := c.Compare(ctx, &pb.CompareRequest{A: gopherHash, B: gopherHash ^ 1})
+	if err != nil || cmp.Distance != 1 || cmp.Verdict != imghash.AverageThresholds.Classify(1).String() {
+		t.Fatalf("compare: %v, %v", cmp, err)
+	}
+
+	// Entries are inserted as hashes, or as images hashed like the index.
+	stream, err := c.BulkInsert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream.Send(&pb.BulkInsertRequest{Id: "near", Entry: &pb.BulkInsertRequest_Hash{Hash: gopherHash ^ 3}})
+	stream.Send(&pb.BulkInsertRequest{Id: "copy", Entry: &pb.BulkInsertRequest_Image{Image: gopher}})
+
+	ins, err := stream.CloseAndRecv()
+	if err != nil || ins.Inserted != 2 {
+		t.Fatalf("bulk insert: %v, %v", ins, err)
+	}
+
+	res, err := c.Search(ctx, &pb.SearchRequest{Query: &pb.SearchRequest_Image{Image: gopher}})
+	if err != nil || res.Hash != gopherHash || len(res.Matches) != 3 {
+		t.Fatalf("search: %v, %v", res, err)
+	}
+
+	zero := uint32(0)
+	res, err = c.Search(ctx, &pb.SearchRequest{Query: &pb.SearchRequest_Hash{Hash: gopherHash}, Distance: &zero})
+	if err != nil || len(res.Matches) != 2 {
+		t.Fatalf("search at distance 0: %v, %v", res, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"bad bits", func() error {
+			_, err := c.Hash(ctx, &pb.HashRequest{Image: gopher, Bits: 8})
+			return err
+		}, codes.InvalidArgument},
+		{"unknown algorithm", func() error {
+			_, err := c.Hash(ctx, &pb.HashRequest{Image: gopher, Algorithm: "robust"})
+			return err
+		}, codes.InvalidArgument},
+		{"not an image", func() error {
+			_, err := c.Hash(ctx, &pb.HashRequest{Image: &pb.Image{Source: &pb.Image_Data{Data: []byte("not an image")}}})
+			return err
+		}, codes.InvalidArgument},
+		{"search other algorithm", func() error {
+			_, err := c.Search(ctx, &pb.SearchRequest{Query: &pb.SearchRequest_Hash{Hash: 0}, Algorithm: "document"})
+			return err
+		}, codes.InvalidArgument},
+	} {
+		if err := tt.call(); status.Code(err) != tt.code {
+			t.Errorf("%s: %v; want %s", tt.name, err, tt.code)
+		}
+	}
+
+	s.maxSize = 16
+	if _, err := c.Hash(ctx, &pb.HashRequest{Image: gopher}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("too large: %v", err)
+	}
+
+	s.store = nil
+	if _, err := c.Search(ctx, &pb.SearchRequest{Query: &pb.SearchRequest_Hash{Hash: 0}}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("no index: %v", err)
+	}
+}
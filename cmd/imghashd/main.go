@@ -0,0 +1,470 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/config"
+	"github.com/jteeuwen/imghash/redis"
+	"github.com/jteeuwen/imghash/selftest"
+	_ "github.com/jteeuwen/imghash/ximage"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	addr        = flag.String("addr", ":8080", "")
+	indexFile   = flag.String("index", "", "")
+	snapFile    = flag.String("snapshot", "", "")
+	snapEvery   = flag.Duration("snapshot-interval", 10*time.Minute, "")
+	updateLog   = flag.String("log", "", "")
+	follow      = flag.String("follow", "", "")
+	redisAddr   = flag.String("redis", "", "")
+	redisPrefix = flag.String("redis-prefix", redis.DefaultPrefix, "")
+	algo        = flag.String("a", "average", "")
+	algorithms  = flag.String("algorithms", "", "")
+	concurrency = flag.Int("c", runtime.NumCPU(), "")
+	maxSize     = flag.Int64("max", 32<<20, "")
+	timeout     = flag.Duration("timeout", 30*time.Second, "")
+	noMetrics   = flag.Bool("nometrics", false, "")
+	noSelfTest  = flag.Bool("noselftest", false, "")
+	configFile  = flag.String("config", "", "")
+	conformance = flag.Bool("conformance", false, "")
+	mapped      = flag.Bool("mmap", false, "")
+	cacheSize   = flag.Int("cache", 0, "")
+	cacheAge    = flag.Duration("cache-age", time.Minute, "")
+	compactEach = flag.Duration("compact-interval", 10*time.Minute, "")
+)
+
+// policy holds the settings of the configuration file given with
+// -config. Those left out are zero.
+var policy = new(config.Config)
+
+// startGRPC serves the gRPC API. It is only set when imghashd is
+// built with gRPC support; refer to grpc.go.
+var startGRPC func(*server) error
+
+func main() {
+	parseArgs()
+
+	srv := &server{
+		algorithm: *algo,
+		maxSize:   *maxSize,
+		slots:     make(chan struct{}, *concurrency),
+		client:    &http.Client{Timeout: *timeout},
+
+		conformance: *conformance,
+	}
+
+	if !*noMetrics {
+		srv.metrics = newPromMetrics()
+		imghash.SetMetrics(srv.metrics)
+	}
+
+	var followSeq uint64
+
+	switch {
+	case len(*follow) > 0:
+		index, seq, err := loadSnapshot(*follow, srv.client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *follow, err)
+			os.Exit(1)
+		}
+
+		if len(index.Algorithm) > 0 {
+			srv.algorithm = index.Algorithm
+		}
+
+		warmIndex(index)
+		srv.store = imghash.IndexStore(index)
+		followSeq = seq
+
+	case len(*redisAddr) > 0:
+		store, err := redis.New(*redisAddr, &redis.Options{
+			Password: os.Getenv("REDIS_PASSWORD"),
+			Prefix:   *redisPrefix,
+			MaxConns: *concurrency,
+		})
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		srv.store = store
+
+	case len(*indexFile) > 0 && *mapped:
+		m, err := imghash.OpenMapped(*indexFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *indexFile, err)
+			os.Exit(1)
+		}
+
+		if len(m.Algorithm()) > 0 {
+			srv.algorithm = m.Algorithm()
+		}
+
+		fmt.Printf("* Mapped %d entries.\n", m.Len())
+		srv.store = imghash.MappedStore(m)
+
+	case len(*indexFile) > 0:
+		index, err := loadIndex(*indexFile, srv.client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *indexFile, err)
+			os.Exit(1)
+		}
+
+		if len(index.Algorithm) > 0 {
+			srv.algorithm = index.Algorithm
+		}
+
+		warmIndex(index)
+		srv.store = imghash.IndexStore(index)
+	}
+
+	algos, err := loadAlgorithms(srv.algorithm, *algorithms)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	// The filters of the configuration file run before the algorithm,
+	// and its thresholds replace those of the configured algorithm.
+	a := *algos[strings.ToLower(srv.algorithm)]
+	a.Hash = policy.HashFunc(a.Hash)
+
+	srv.algo = &a
+	srv.algos = algos
+
+	if *cacheSize > 0 && srv.store != nil {
+		srv.store = imghash.NewCachedStore(srv.store, &imghash.CacheOptions{
+			Size:   *cacheSize,
+			MaxAge: *cacheAge,
+		})
+	}
+
+	if len(*follow) > 0 {
+		go followUpdates(srv.store, *follow, followSeq, srv.client)
+	}
+
+	if len(*updateLog) > 0 && srv.store != nil {
+		log, err := imghash.OpenUpdateLog(*updateLog)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *updateLog, err)
+			os.Exit(1)
+		}
+
+		srv.log = log
+		srv.store = imghash.NewLoggedStore(srv.store, log)
+	}
+
+	if len(*snapFile) > 0 {
+		// Wrapped stores are Snapshotters, but return no snapshot of a
+		// store which is not.
+		snap, ok := srv.store.(imghash.Snapshotter)
+		if !ok || snap.Snapshot() == nil {
+			fmt.Fprintf(os.Stderr, "-snapshot needs an index.\n")
+			os.Exit(1)
+		}
+
+		go saveSnapshots(snap, *snapFile, *snapEvery)
+	}
+
+	if *compactEach > 0 && srv.store != nil {
+		go compact(srv.store, *compactEach)
+	}
+
+	if !*noSelfTest {
+		r := selftest.Run(context.Background(), &selftest.Options{Store: srv.store})
+		if !r.OK() {
+			fmt.Fprintf(os.Stderr, "%v\n", r.Err())
+			os.Exit(1)
+		}
+
+		fmt.Printf("* Self-test passed %d checks in %v.\n", r.Passed, r.Elapsed)
+	}
+
+	if startGRPC != nil {
+		go func() {
+			if err := startGRPC(srv); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	fmt.Printf("* Listening on %s...\n", *addr)
+
+	if err := http.ListenAndServe(*addr, srv.handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// warmIndex prepares a loaded index for queries, so the first ones
+// after a restart are not slower than the rest.
+func warmIndex(index *imghash.Index) {
+	s := index.Warm()
+	fmt.Printf("* Loaded %d entries, in a tree of %d nodes, %d deep.\n", s.Entries, s.Nodes, s.Depth)
+}
+
+// loadIndex loads the index in the given file. It may also be the URL
+// of the /snapshot endpoint of another imghashd, to start a replica
+// with the index of a running server.
+func loadIndex(file string, client *http.Client) (*imghash.Index, error) {
+	index := imghash.NewIndex()
+	if !strings.HasPrefix(file, "http://") && !strings.HasPrefix(file, "https://") {
+		return index, index.Load(file)
+	}
+
+	// The client timeout is meant for images, not whole indexes.
+	resp, err := (&http.Client{Transport: client.Transport}).Get(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	_, err = index.ReadFrom(resp.Body)
+	return index, err
+}
+
+// loadSnapshot loads the index of the primary server at the given URL,
+// and returns the sequence number of the last update it includes.
+func loadSnapshot(primary string, client *http.Client) (*imghash.Index, uint64, error) {
+	resp, err := (&http.Client{Transport: client.Transport}).Get(strings.TrimSuffix(primary, "/") + "/snapshot")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("snapshot: %s", resp.Status)
+	}
+
+	seq, err := strconv.ParseUint(resp.Header.Get("Imghash-Seq"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("snapshot: the primary does not record updates")
+	}
+
+	index := imghash.NewIndex()
+	_, err = index.ReadFrom(resp.Body)
+	return index, seq, err
+}
+
+// followUpdates applies the updates of the primary server at the given
+// URL to the store, from after the given sequence number, for as long
+// as the process runs. Lost connections are retried.
+func followUpdates(store imghash.Store, primary string, seq uint64, client *http.Client) {
+	ctx := context.Background()
+	hc := &http.Client{Transport: client.Transport}
+
+	for {
+		err := func() error {
+			resp, err := hc.Get(fmt.Sprintf("%s/updates?from=%d", strings.TrimSuffix(primary, "/"), seq))
+			if err != nil {
+				return err
+			}
+
+			defer resp.Body.Close()
+
+			if resp.StatusCode == http.StatusConflict {
+				fmt.Fprintf(os.Stderr, "%s: the primary has a different update log; restart to copy its index again.\n", primary)
+				os.Exit(1)
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("updates: %s", resp.Status)
+			}
+
+			return imghash.ReadUpdates(resp.Body, func(u *imghash.Update) error {
+				if err := u.Apply(ctx, store); err != nil {
+					return err
+				}
+
+				seq = u.Seq
+				return nil
+			})
+		}()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", primary, err)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// compact compacts the index in the background, whenever removals have
+// left enough of its tree empty.
+func compact(store imghash.Store, interval time.Duration) {
+	err := imghash.ScheduleCompaction(context.Background(), store, &imghash.CompactOptions{
+		Interval: interval,
+		OnCompact: func(before, after imghash.IndexStats) {
+			fmt.Printf("* Compacted the index from %d nodes to %d, %d deep.\n", before.Nodes, after.Nodes, after.Depth)
+		},
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compact: %v\n", err)
+	}
+}
+
+// saveSnapshots writes a snapshot of the index to the given file at
+// every interval, and once more when the process is told to stop.
+// The file is replaced atomically, so it always holds a complete index.
+func saveSnapshots(s imghash.Snapshotter, file string, interval time.Duration) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-stop:
+			if err := saveSnapshot(s.Snapshot(), file); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+
+		if err := saveSnapshot(s.Snapshot(), file); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		}
+	}
+}
+
+// saveSnapshot writes the index to a temporary file, and moves it over
+// the given one.
+func saveSnapshot(index *imghash.Index, file string) error {
+	tmp := file + ".tmp"
+	if err := index.Save(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// parseArgs processes and validates commandline arguments.
+func parseArgs() {
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [options]\n\n", os.Args[0])
+		fmt.Printf("    -addr: Address to listen on. Defaults to :8080.\n")
+		fmt.Printf("   -index: Index file to serve search queries from. The index\n" +
+			"           determines the algorithm, if it records one. This may\n" +
+			"           also be the URL of the /snapshot endpoint of a running\n" +
+			"           server, to copy its index.\n")
+		fmt.Printf("    -mmap: The index file is in the format of imghash index map. It\n" +
+			"           is mapped into memory read-only, and shared with other\n" +
+			"           processes on the host which map it.\n")
+		fmt.Printf("-snapshot: File to save snapshots of the index to, including\n" +
+			"           entries added since it was loaded. Snapshots are taken\n" +
+			"           while queries go on, and on interrupt or SIGTERM.\n")
+		fmt.Printf("-snapshot-interval: Time between snapshots. Defaults to 10m.\n" +
+			"           Zero only saves on interrupt or SIGTERM.\n")
+		fmt.Printf("   -redis: Address of a Redis server to store and search hashes\n" +
+			"           in, instead of an index file. Replicas sharing a server\n" +
+			"           share their hashes. The password, if any, is read from\n" +
+			"           the REDIS_PASSWORD environment variable.\n")
+		fmt.Printf("-redis-prefix: Prefix for all Redis keys. Defaults to imghash.\n")
+		fmt.Printf("     -log: Record all changes to the index in this update log, and\n" +
+			"           stream them to followers on /updates.\n")
+		fmt.Printf("  -follow: URL of a primary server started with -log. Its index\n" +
+			"           is copied, and its changes applied as they are made.\n")
+		fmt.Printf("       -a: Hashing algorithm to use. Defaults to average.\n")
+		fmt.Printf("-algorithms: Other algorithms clients may ask for per request,\n" +
+			"           separated by commas.\n")
+		fmt.Printf("   -cache: Number of queries to cache the results of. Crawlers\n" +
+			"           which query the same images again are answered without\n" +
+			"           searching the index. Defaults to 0, for none.\n")
+		fmt.Printf("-cache-age: How long cached results are kept. Defaults to 1m.\n" +
+			"           Zero keeps them until changes to the index drop them.\n")
+		fmt.Printf("-compact-interval: Time between checks of the index for nodes\n" +
+			"           left empty by removals. Once a quarter of them are, the\n" +
+			"           index is rebuilt in the background. Defaults to 10m.\n" +
+			"           Zero never compacts.\n")
+		fmt.Printf("       -c: Maximum number of images hashed concurrently.\n" +
+			"           Defaults to the number of CPUs.\n")
+		fmt.Printf("     -max: Maximum size of an image, in bytes. Defaults to 32MB.\n")
+		fmt.Printf(" -timeout: Timeout for fetching remote images. Defaults to 30s.\n")
+		fmt.Printf("-nometrics: Do not serve Prometheus metrics on /metrics.\n")
+		fmt.Printf("-noselftest: Do not check the hashes of the reference images, and\n" +
+			"           the index, before serving. /selftest runs the same\n" +
+			"           checks on demand either way.\n")
+		fmt.Printf("  -config: Read the algorithm, filters, thresholds, concurrency,\n" +
+			"           index and snapshot files, and the algorithms and profiles\n" +
+			"           clients may ask for, from this configuration file.\n" +
+			"           Options given on the command line override it.\n")
+		fmt.Printf("-conformance: Serve the conformance suite on /conformance, for\n" +
+			"           checking clients in other languages against the hashes\n" +
+			"           and formats of this package.\n")
+		fmt.Printf("       -v: Display version information.\n")
+	}
+
+	version := flag.Bool("v", false, "Display version information.")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", Version())
+		os.Exit(0)
+	}
+
+	if len(*configFile) > 0 {
+		c, err := config.Load(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		applyConfig(c)
+	}
+
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+}
+
+// applyConfig takes the settings of c for the options not given on the
+// command line, and makes c the policy.
+func applyConfig(c *config.Config) {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	set := func(name, value string) {
+		if !given[name] && len(value) > 0 && value != "0" {
+			flag.Set(name, value)
+		}
+	}
+
+	set("a", c.Algorithm)
+	set("algorithms", strings.Join(c.Service.Algorithms, ","))
+	set("c", strconv.Itoa(c.Workers))
+	set("index", c.Index.Path)
+	set("snapshot", c.Index.Snapshot)
+
+	policy = c
+}
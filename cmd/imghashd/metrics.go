@@ -0,0 +1,172 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// histogram is a Prometheus-style cumulative histogram.
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64 // Upper bounds, in increasing order.
+	counts  []uint64  // Observations per bucket; not cumulative.
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets ...float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// observe records a single value. The caller must hold the lock.
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// write writes the histogram in the Prometheus text format.
+func (h *histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	var n uint64
+	for i, le := range h.buckets {
+		n += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, le, n)
+	}
+
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", h.name, h.sum, h.name, h.count)
+}
+
+// promMetrics collects instrumentation events from the imghash
+// package and the HTTP handlers, and exports them in the Prometheus
+// text format.
+type promMetrics struct {
+	mu           sync.Mutex
+	decodeErrors uint64
+	requests     map[string]uint64 // Keyed by path and status code.
+	hashLatency  *histogram
+	queryVisited *histogram
+	queryResults *histogram
+}
+
+func newPromMetrics() *promMetrics {
+	return &promMetrics{
+		requests: make(map[string]uint64),
+		hashLatency: newHistogram("imghash_hash_duration_seconds",
+			"Time taken to decode and hash an image.",
+			0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5),
+		queryVisited: newHistogram("imghash_index_query_nodes_visited",
+			"Number of index nodes visited per query.",
+			1, 10, 100, 1000, 10000, 100000, 1e6),
+		queryResults: newHistogram("imghash_index_query_results",
+			"Number of results per index query.",
+			0, 1, 5, 10, 50, 100, 1000),
+	}
+}
+
+func (m *promMetrics) ImageHashed(elapsed time.Duration) {
+	m.mu.Lock()
+	m.hashLatency.observe(elapsed.Seconds())
+	m.mu.Unlock()
+}
+
+func (m *promMetrics) DecodeFailed(err error) {
+	m.mu.Lock()
+	m.decodeErrors++
+	m.mu.Unlock()
+}
+
+func (m *promMetrics) IndexQueried(visited, results int) {
+	m.mu.Lock()
+	m.queryVisited.observe(float64(visited))
+	m.queryResults.observe(float64(results))
+	m.mu.Unlock()
+}
+
+// instrument wraps the handler, counting requests by path and status.
+func (m *promMetrics) instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		// Unknown paths are lumped together, so clients
+		// can not blow up the number of series.
+		path := r.URL.Path
+		switch path {
+		case "/hash", "/compare", "/search", "/metrics":
+		default:
+			path = "other"
+		}
+
+		key := fmt.Sprintf("path=%q,code=\"%d\"", path, sw.status)
+
+		m.mu.Lock()
+		m.requests[key]++
+		m.mu.Unlock()
+	})
+}
+
+// ServeHTTP serves all metrics in the Prometheus text format.
+func (m *promMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hashLatency.write(w)
+	m.queryVisited.write(w)
+	m.queryResults.write(w)
+
+	fmt.Fprintf(w, "# HELP imghash_images_hashed_total Number of images hashed.\n")
+	fmt.Fprintf(w, "# TYPE imghash_images_hashed_total counter\n")
+	fmt.Fprintf(w, "imghash_images_hashed_total %d\n", m.hashLatency.count)
+
+	fmt.Fprintf(w, "# HELP imghash_decode_errors_total Number of images which failed to decode.\n")
+	fmt.Fprintf(w, "# TYPE imghash_decode_errors_total counter\n")
+	fmt.Fprintf(w, "imghash_decode_errors_total %d\n", m.decodeErrors)
+
+	keys := make([]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP imghashd_http_requests_total Number of HTTP requests served.\n")
+	fmt.Fprintf(w, "# TYPE imghashd_http_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "imghashd_http_requests_total{%s} %d\n", k, m.requests[k])
+	}
+}
+
+// statusWriter records the status code written to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes the underlying ResponseWriter, for streamed responses.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,141 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"strconv"
+	"strings"
+)
+
+// hashSpec is the algorithm, preprocessing profile and hash length a
+// request asked for.
+type hashSpec struct {
+	algorithm  string             // Name of the algorithm.
+	profile    string             // Name of the profile; empty for the configured filters.
+	bits       int                // Length of the hashes: 64, or folded to 32 or 16.
+	hash       imghash.HashFunc   // Hashes images, filters included.
+	thresholds imghash.Thresholds // Thresholds of the algorithm.
+}
+
+// negotiate returns what a request asks to be hashed with. Empty values
+// mean the defaults: the algorithm and filters imghashd was started
+// with, and 64 bit hashes. Algorithms must be listed with -algorithms,
+// and profiles in the profiles table of -config.
+func (s *server) negotiate(algorithm, profile, bits string) (*hashSpec, error) {
+	spec := &hashSpec{
+		algorithm:  s.algorithm,
+		profile:    profile,
+		bits:       64,
+		hash:       s.algo.Hash,
+		thresholds: s.algo.Thresholds,
+	}
+
+	switch bits {
+	case "", "64":
+	case "32", "16":
+		spec.bits, _ = strconv.Atoi(bits)
+	default:
+		return nil, fmt.Errorf("invalid hash length %q; expected 64, 32 or 16", bits)
+	}
+
+	if len(algorithm) == 0 && len(profile) == 0 {
+		return spec, nil
+	}
+
+	a := s.algos[strings.ToLower(s.algorithm)]
+	if len(algorithm) > 0 {
+		var ok bool
+		if a, ok = s.algos[strings.ToLower(algorithm)]; !ok {
+			return nil, fmt.Errorf("algorithm %q is not served", algorithm)
+		}
+
+		spec.algorithm = strings.ToLower(algorithm)
+		spec.thresholds = a.Thresholds
+	}
+
+	if len(profile) == 0 {
+		spec.hash = policy.HashFunc(a.Hash)
+		return spec, nil
+	}
+
+	hf, ok := policy.ProfileHashFunc(profile, a.Hash)
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", profile)
+	}
+
+	spec.hash = hf
+	return spec, nil
+}
+
+// fold returns the hash, folded to the length of the spec.
+func (spec *hashSpec) fold(hash uint64) uint64 {
+	switch spec.bits {
+	case 32:
+		return uint64(imghash.Fold32(hash))
+	case 16:
+		return uint64(imghash.Fold16(hash))
+	}
+
+	return hash
+}
+
+// format returns the hash in hexadecimal, with a digit for every 4 bits.
+func (spec *hashSpec) format(hash uint64) string {
+	return fmt.Sprintf("%0*x", spec.bits/4, hash)
+}
+
+// parse parses a hexadecimal hash of the length of the spec.
+func (spec *hashSpec) parse(v string) (uint64, error) {
+	hash, err := parseHash(v)
+	if err == nil && spec.bits < 64 && hash>>uint(spec.bits) != 0 {
+		err = fmt.Errorf("hash %q is longer than %d bits", v, spec.bits)
+	}
+
+	return hash, err
+}
+
+// searchable returns an error if the index can not be searched for the
+// hashes of the spec: those of another algorithm, or folded ones.
+func (s *server) searchable(spec *hashSpec) error {
+	if spec.bits != 64 || !strings.EqualFold(spec.algorithm, s.algorithm) {
+		return fmt.Errorf("the index holds 64 bit %s hashes", s.algorithm)
+	}
+
+	return nil
+}
+
+// loadAlgorithms looks up the algorithm imghashd was started with, and
+// the others clients may ask for, in a comma separated list. They are
+// returned by lower case name, without the filters of the policy, but
+// with its thresholds for the algorithm it names.
+func loadAlgorithms(main, others string) (map[string]*imghash.Algorithm, error) {
+	algos := make(map[string]*imghash.Algorithm)
+
+	names := []string{main}
+	if len(others) > 0 {
+		names = append(names, strings.Split(others, ",")...)
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+
+		a, err := imghash.LookupAlgorithm(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown algorithm %q.", name)
+		}
+
+		if strings.EqualFold(name, policy.Algorithm) {
+			a.Thresholds = policy.ApplyThresholds(a.Thresholds)
+		}
+
+		algos[name] = a
+	}
+
+	return algos, nil
+}
@@ -0,0 +1,872 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build grpc
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: proto/imghash.proto
+
+package imghashpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Image holds either encoded image data, or the URL of a remote image.
+type Image struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Source:
+	//
+	//	*Image_Data
+	//	*Image_Url
+	Source        isImage_Source `protobuf_oneof:"source"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Image) Reset() {
+	*x = Image{}
+	mi := &file_proto_imghash_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Image) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Image) ProtoMessage() {}
+
+func (x *Image) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Image.ProtoReflect.Descriptor instead.
+func (*Image) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Image) GetSource() isImage_Source {
+	if x != nil {
+		return x.Source
+	}
+	return nil
+}
+
+func (x *Image) GetData() []byte {
+	if x != nil {
+		if x, ok := x.Source.(*Image_Data); ok {
+			return x.Data
+		}
+	}
+	return nil
+}
+
+func (x *Image) GetUrl() string {
+	if x != nil {
+		if x, ok := x.Source.(*Image_Url); ok {
+			return x.Url
+		}
+	}
+	return ""
+}
+
+type isImage_Source interface {
+	isImage_Source()
+}
+
+type Image_Data struct {
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3,oneof"`
+}
+
+type Image_Url struct {
+	Url string `protobuf:"bytes,2,opt,name=url,proto3,oneof"`
+}
+
+func (*Image_Data) isImage_Source() {}
+
+func (*Image_Url) isImage_Source() {}
+
+type HashRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Image         *Image                 `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	Algorithm     string                 `protobuf:"bytes,2,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	Profile       string                 `protobuf:"bytes,3,opt,name=profile,proto3" json:"profile,omitempty"`
+	Bits          uint32                 `protobuf:"varint,4,opt,name=bits,proto3" json:"bits,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HashRequest) Reset() {
+	*x = HashRequest{}
+	mi := &file_proto_imghash_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HashRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashRequest) ProtoMessage() {}
+
+func (x *HashRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashRequest.ProtoReflect.Descriptor instead.
+func (*HashRequest) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *HashRequest) GetImage() *Image {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *HashRequest) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *HashRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *HashRequest) GetBits() uint32 {
+	if x != nil {
+		return x.Bits
+	}
+	return 0
+}
+
+type HashResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          uint64                 `protobuf:"fixed64,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Algorithm     string                 `protobuf:"bytes,2,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	Profile       string                 `protobuf:"bytes,3,opt,name=profile,proto3" json:"profile,omitempty"`
+	Bits          uint32                 `protobuf:"varint,4,opt,name=bits,proto3" json:"bits,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HashResponse) Reset() {
+	*x = HashResponse{}
+	mi := &file_proto_imghash_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HashResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashResponse) ProtoMessage() {}
+
+func (x *HashResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashResponse.ProtoReflect.Descriptor instead.
+func (*HashResponse) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *HashResponse) GetHash() uint64 {
+	if x != nil {
+		return x.Hash
+	}
+	return 0
+}
+
+func (x *HashResponse) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *HashResponse) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *HashResponse) GetBits() uint32 {
+	if x != nil {
+		return x.Bits
+	}
+	return 0
+}
+
+type CompareRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	A     uint64                 `protobuf:"fixed64,1,opt,name=a,proto3" json:"a,omitempty"`
+	B     uint64                 `protobuf:"fixed64,2,opt,name=b,proto3" json:"b,omitempty"`
+	// Algorithm whose thresholds give the verdict, and length of the
+	// hashes.
+	Algorithm     string `protobuf:"bytes,3,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	Bits          uint32 `protobuf:"varint,4,opt,name=bits,proto3" json:"bits,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompareRequest) Reset() {
+	*x = CompareRequest{}
+	mi := &file_proto_imghash_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompareRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareRequest) ProtoMessage() {}
+
+func (x *CompareRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareRequest.ProtoReflect.Descriptor instead.
+func (*CompareRequest) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CompareRequest) GetA() uint64 {
+	if x != nil {
+		return x.A
+	}
+	return 0
+}
+
+func (x *CompareRequest) GetB() uint64 {
+	if x != nil {
+		return x.B
+	}
+	return 0
+}
+
+func (x *CompareRequest) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *CompareRequest) GetBits() uint32 {
+	if x != nil {
+		return x.Bits
+	}
+	return 0
+}
+
+type CompareResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Distance      uint32                 `protobuf:"varint,1,opt,name=distance,proto3" json:"distance,omitempty"`
+	Similarity    float64                `protobuf:"fixed64,2,opt,name=similarity,proto3" json:"similarity,omitempty"`
+	Verdict       string                 `protobuf:"bytes,3,opt,name=verdict,proto3" json:"verdict,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompareResponse) Reset() {
+	*x = CompareResponse{}
+	mi := &file_proto_imghash_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompareResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareResponse) ProtoMessage() {}
+
+func (x *CompareResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareResponse.ProtoReflect.Descriptor instead.
+func (*CompareResponse) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CompareResponse) GetDistance() uint32 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *CompareResponse) GetSimilarity() float64 {
+	if x != nil {
+		return x.Similarity
+	}
+	return 0
+}
+
+func (x *CompareResponse) GetVerdict() string {
+	if x != nil {
+		return x.Verdict
+	}
+	return ""
+}
+
+type SearchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Query:
+	//
+	//	*SearchRequest_Hash
+	//	*SearchRequest_Image
+	Query isSearchRequest_Query `protobuf_oneof:"query"`
+	// Maximum Hamming Distance. Defaults to the near-duplicate
+	// threshold of the algorithm when not set.
+	Distance *uint32 `protobuf:"varint,3,opt,name=distance,proto3,oneof" json:"distance,omitempty"`
+	// Images are hashed with the algorithm of the index, which is the
+	// only one accepted here, and with the given profile.
+	Algorithm     string `protobuf:"bytes,4,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	Profile       string `protobuf:"bytes,5,opt,name=profile,proto3" json:"profile,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_proto_imghash_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SearchRequest) GetQuery() isSearchRequest_Query {
+	if x != nil {
+		return x.Query
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetHash() uint64 {
+	if x != nil {
+		if x, ok := x.Query.(*SearchRequest_Hash); ok {
+			return x.Hash
+		}
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetImage() *Image {
+	if x != nil {
+		if x, ok := x.Query.(*SearchRequest_Image); ok {
+			return x.Image
+		}
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetDistance() uint32 {
+	if x != nil && x.Distance != nil {
+		return *x.Distance
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+type isSearchRequest_Query interface {
+	isSearchRequest_Query()
+}
+
+type SearchRequest_Hash struct {
+	Hash uint64 `protobuf:"fixed64,1,opt,name=hash,proto3,oneof"`
+}
+
+type SearchRequest_Image struct {
+	Image *Image `protobuf:"bytes,2,opt,name=image,proto3,oneof"`
+}
+
+func (*SearchRequest_Hash) isSearchRequest_Query() {}
+
+func (*SearchRequest_Image) isSearchRequest_Query() {}
+
+type Match struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Hash          uint64                 `protobuf:"fixed64,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	Distance      uint32                 `protobuf:"varint,3,opt,name=distance,proto3" json:"distance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Match) Reset() {
+	*x = Match{}
+	mi := &file_proto_imghash_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Match) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Match) ProtoMessage() {}
+
+func (x *Match) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Match.ProtoReflect.Descriptor instead.
+func (*Match) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Match) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Match) GetHash() uint64 {
+	if x != nil {
+		return x.Hash
+	}
+	return 0
+}
+
+func (x *Match) GetDistance() uint32 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+type SearchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          uint64                 `protobuf:"fixed64,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Matches       []*Match               `protobuf:"bytes,2,rep,name=matches,proto3" json:"matches,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchResponse) Reset() {
+	*x = SearchResponse{}
+	mi := &file_proto_imghash_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResponse) ProtoMessage() {}
+
+func (x *SearchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResponse.ProtoReflect.Descriptor instead.
+func (*SearchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SearchResponse) GetHash() uint64 {
+	if x != nil {
+		return x.Hash
+	}
+	return 0
+}
+
+func (x *SearchResponse) GetMatches() []*Match {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+type BulkInsertRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Types that are valid to be assigned to Entry:
+	//
+	//	*BulkInsertRequest_Hash
+	//	*BulkInsertRequest_Image
+	Entry         isBulkInsertRequest_Entry `protobuf_oneof:"entry"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BulkInsertRequest) Reset() {
+	*x = BulkInsertRequest{}
+	mi := &file_proto_imghash_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BulkInsertRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BulkInsertRequest) ProtoMessage() {}
+
+func (x *BulkInsertRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BulkInsertRequest.ProtoReflect.Descriptor instead.
+func (*BulkInsertRequest) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BulkInsertRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *BulkInsertRequest) GetEntry() isBulkInsertRequest_Entry {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+func (x *BulkInsertRequest) GetHash() uint64 {
+	if x != nil {
+		if x, ok := x.Entry.(*BulkInsertRequest_Hash); ok {
+			return x.Hash
+		}
+	}
+	return 0
+}
+
+func (x *BulkInsertRequest) GetImage() *Image {
+	if x != nil {
+		if x, ok := x.Entry.(*BulkInsertRequest_Image); ok {
+			return x.Image
+		}
+	}
+	return nil
+}
+
+type isBulkInsertRequest_Entry interface {
+	isBulkInsertRequest_Entry()
+}
+
+type BulkInsertRequest_Hash struct {
+	Hash uint64 `protobuf:"fixed64,2,opt,name=hash,proto3,oneof"`
+}
+
+type BulkInsertRequest_Image struct {
+	Image *Image `protobuf:"bytes,3,opt,name=image,proto3,oneof"`
+}
+
+func (*BulkInsertRequest_Hash) isBulkInsertRequest_Entry() {}
+
+func (*BulkInsertRequest_Image) isBulkInsertRequest_Entry() {}
+
+type BulkInsertResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Inserted      uint64                 `protobuf:"varint,1,opt,name=inserted,proto3" json:"inserted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BulkInsertResponse) Reset() {
+	*x = BulkInsertResponse{}
+	mi := &file_proto_imghash_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BulkInsertResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BulkInsertResponse) ProtoMessage() {}
+
+func (x *BulkInsertResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_imghash_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BulkInsertResponse.ProtoReflect.Descriptor instead.
+func (*BulkInsertResponse) Descriptor() ([]byte, []int) {
+	return file_proto_imghash_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *BulkInsertResponse) GetInserted() uint64 {
+	if x != nil {
+		return x.Inserted
+	}
+	return 0
+}
+
+var File_proto_imghash_proto protoreflect.FileDescriptor
+
+const file_proto_imghash_proto_rawDesc = "" +
+	"\n" +
+	"\x13proto/imghash.proto\x12\aimghash\";\n" +
+	"\x05Image\x12\x14\n" +
+	"\x04data\x18\x01 \x01(\fH\x00R\x04data\x12\x12\n" +
+	"\x03url\x18\x02 \x01(\tH\x00R\x03urlB\b\n" +
+	"\x06source\"\x7f\n" +
+	"\vHashRequest\x12$\n" +
+	"\x05image\x18\x01 \x01(\v2\x0e.imghash.ImageR\x05image\x12\x1c\n" +
+	"\talgorithm\x18\x02 \x01(\tR\talgorithm\x12\x18\n" +
+	"\aprofile\x18\x03 \x01(\tR\aprofile\x12\x12\n" +
+	"\x04bits\x18\x04 \x01(\rR\x04bits\"n\n" +
+	"\fHashResponse\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\x06R\x04hash\x12\x1c\n" +
+	"\talgorithm\x18\x02 \x01(\tR\talgorithm\x12\x18\n" +
+	"\aprofile\x18\x03 \x01(\tR\aprofile\x12\x12\n" +
+	"\x04bits\x18\x04 \x01(\rR\x04bits\"^\n" +
+	"\x0eCompareRequest\x12\f\n" +
+	"\x01a\x18\x01 \x01(\x06R\x01a\x12\f\n" +
+	"\x01b\x18\x02 \x01(\x06R\x01b\x12\x1c\n" +
+	"\talgorithm\x18\x03 \x01(\tR\talgorithm\x12\x12\n" +
+	"\x04bits\x18\x04 \x01(\rR\x04bits\"g\n" +
+	"\x0fCompareResponse\x12\x1a\n" +
+	"\bdistance\x18\x01 \x01(\rR\bdistance\x12\x1e\n" +
+	"\n" +
+	"similarity\x18\x02 \x01(\x01R\n" +
+	"similarity\x12\x18\n" +
+	"\averdict\x18\x03 \x01(\tR\averdict\"\xbc\x01\n" +
+	"\rSearchRequest\x12\x14\n" +
+	"\x04hash\x18\x01 \x01(\x06H\x00R\x04hash\x12&\n" +
+	"\x05image\x18\x02 \x01(\v2\x0e.imghash.ImageH\x00R\x05image\x12\x1f\n" +
+	"\bdistance\x18\x03 \x01(\rH\x01R\bdistance\x88\x01\x01\x12\x1c\n" +
+	"\talgorithm\x18\x04 \x01(\tR\talgorithm\x12\x18\n" +
+	"\aprofile\x18\x05 \x01(\tR\aprofileB\a\n" +
+	"\x05queryB\v\n" +
+	"\t_distance\"G\n" +
+	"\x05Match\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04hash\x18\x02 \x01(\x06R\x04hash\x12\x1a\n" +
+	"\bdistance\x18\x03 \x01(\rR\bdistance\"N\n" +
+	"\x0eSearchResponse\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\x06R\x04hash\x12(\n" +
+	"\amatches\x18\x02 \x03(\v2\x0e.imghash.MatchR\amatches\"j\n" +
+	"\x11BulkInsertRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x04hash\x18\x02 \x01(\x06H\x00R\x04hash\x12&\n" +
+	"\x05image\x18\x03 \x01(\v2\x0e.imghash.ImageH\x00R\x05imageB\a\n" +
+	"\x05entry\"0\n" +
+	"\x12BulkInsertResponse\x12\x1a\n" +
+	"\binserted\x18\x01 \x01(\x04R\binserted2\x80\x02\n" +
+	"\aImghash\x123\n" +
+	"\x04Hash\x12\x14.imghash.HashRequest\x1a\x15.imghash.HashResponse\x12<\n" +
+	"\aCompare\x12\x17.imghash.CompareRequest\x1a\x18.imghash.CompareResponse\x129\n" +
+	"\x06Search\x12\x16.imghash.SearchRequest\x1a\x17.imghash.SearchResponse\x12G\n" +
+	"\n" +
+	"BulkInsert\x12\x1a.imghash.BulkInsertRequest\x1a\x1b.imghash.BulkInsertResponse(\x01B:Z8github.com/jteeuwen/imghash/cmd/imghashd/proto;imghashpbb\x06proto3"
+
+var (
+	file_proto_imghash_proto_rawDescOnce sync.Once
+	file_proto_imghash_proto_rawDescData []byte
+)
+
+func file_proto_imghash_proto_rawDescGZIP() []byte {
+	file_proto_imghash_proto_rawDescOnce.Do(func() {
+		file_proto_imghash_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_imghash_proto_rawDesc), len(file_proto_imghash_proto_rawDesc)))
+	})
+	return file_proto_imghash_proto_rawDescData
+}
+
+var file_proto_imghash_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_proto_imghash_proto_goTypes = []any{
+	(*Image)(nil),              // 0: imghash.Image
+	(*HashRequest)(nil),        // 1: imghash.HashRequest
+	(*HashResponse)(nil),       // 2: imghash.HashResponse
+	(*CompareRequest)(nil),     // 3: imghash.CompareRequest
+	(*CompareResponse)(nil),    // 4: imghash.CompareResponse
+	(*SearchRequest)(nil),      // 5: imghash.SearchRequest
+	(*Match)(nil),              // 6: imghash.Match
+	(*SearchResponse)(nil),     // 7: imghash.SearchResponse
+	(*BulkInsertRequest)(nil),  // 8: imghash.BulkInsertRequest
+	(*BulkInsertResponse)(nil), // 9: imghash.BulkInsertResponse
+}
+var file_proto_imghash_proto_depIdxs = []int32{
+	0, // 0: imghash.HashRequest.image:type_name -> imghash.Image
+	0, // 1: imghash.SearchRequest.image:type_name -> imghash.Image
+	6, // 2: imghash.SearchResponse.matches:type_name -> imghash.Match
+	0, // 3: imghash.BulkInsertRequest.image:type_name -> imghash.Image
+	1, // 4: imghash.Imghash.Hash:input_type -> imghash.HashRequest
+	3, // 5: imghash.Imghash.Compare:input_type -> imghash.CompareRequest
+	5, // 6: imghash.Imghash.Search:input_type -> imghash.SearchRequest
+	8, // 7: imghash.Imghash.BulkInsert:input_type -> imghash.BulkInsertRequest
+	2, // 8: imghash.Imghash.Hash:output_type -> imghash.HashResponse
+	4, // 9: imghash.Imghash.Compare:output_type -> imghash.CompareResponse
+	7, // 10: imghash.Imghash.Search:output_type -> imghash.SearchResponse
+	9, // 11: imghash.Imghash.BulkInsert:output_type -> imghash.BulkInsertResponse
+	8, // [8:12] is the sub-list for method output_type
+	4, // [4:8] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_proto_imghash_proto_init() }
+func file_proto_imghash_proto_init() {
+	if File_proto_imghash_proto != nil {
+		return
+	}
+	file_proto_imghash_proto_msgTypes[0].OneofWrappers = []any{
+		(*Image_Data)(nil),
+		(*Image_Url)(nil),
+	}
+	file_proto_imghash_proto_msgTypes[5].OneofWrappers = []any{
+		(*SearchRequest_Hash)(nil),
+		(*SearchRequest_Image)(nil),
+	}
+	file_proto_imghash_proto_msgTypes[8].OneofWrappers = []any{
+		(*BulkInsertRequest_Hash)(nil),
+		(*BulkInsertRequest_Image)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_imghash_proto_rawDesc), len(file_proto_imghash_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_imghash_proto_goTypes,
+		DependencyIndexes: file_proto_imghash_proto_depIdxs,
+		MessageInfos:      file_proto_imghash_proto_msgTypes,
+	}.Build()
+	File_proto_imghash_proto = out.File
+	file_proto_imghash_proto_goTypes = nil
+	file_proto_imghash_proto_depIdxs = nil
+}
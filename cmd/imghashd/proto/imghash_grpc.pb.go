@@ -0,0 +1,250 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build grpc
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: proto/imghash.proto
+
+package imghashpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Imghash_Hash_FullMethodName       = "/imghash.Imghash/Hash"
+	Imghash_Compare_FullMethodName    = "/imghash.Imghash/Compare"
+	Imghash_Search_FullMethodName     = "/imghash.Imghash/Search"
+	Imghash_BulkInsert_FullMethodName = "/imghash.Imghash/BulkInsert"
+)
+
+// ImghashClient is the client API for Imghash service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Imghash exposes Perceptual hashing and index search.
+// It mirrors the HTTP API of imghashd.
+type ImghashClient interface {
+	// Hash computes the hash of an image.
+	Hash(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error)
+	// Compare compares two hashes.
+	Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error)
+	// Search finds all entries in the index within the given
+	// Hamming Distance of a hash or image.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// BulkInsert adds a stream of entries to the index.
+	BulkInsert(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[BulkInsertRequest, BulkInsertResponse], error)
+}
+
+type imghashClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewImghashClient(cc grpc.ClientConnInterface) ImghashClient {
+	return &imghashClient{cc}
+}
+
+func (c *imghashClient) Hash(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HashResponse)
+	err := c.cc.Invoke(ctx, Imghash_Hash_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imghashClient) Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompareResponse)
+	err := c.cc.Invoke(ctx, Imghash_Compare_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imghashClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, Imghash_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imghashClient) BulkInsert(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[BulkInsertRequest, BulkInsertResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Imghash_ServiceDesc.Streams[0], Imghash_BulkInsert_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BulkInsertRequest, BulkInsertResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Imghash_BulkInsertClient = grpc.ClientStreamingClient[BulkInsertRequest, BulkInsertResponse]
+
+// ImghashServer is the server API for Imghash service.
+// All implementations must embed UnimplementedImghashServer
+// for forward compatibility.
+//
+// Imghash exposes Perceptual hashing and index search.
+// It mirrors the HTTP API of imghashd.
+type ImghashServer interface {
+	// Hash computes the hash of an image.
+	Hash(context.Context, *HashRequest) (*HashResponse, error)
+	// Compare compares two hashes.
+	Compare(context.Context, *CompareRequest) (*CompareResponse, error)
+	// Search finds all entries in the index within the given
+	// Hamming Distance of a hash or image.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// BulkInsert adds a stream of entries to the index.
+	BulkInsert(grpc.ClientStreamingServer[BulkInsertRequest, BulkInsertResponse]) error
+	mustEmbedUnimplementedImghashServer()
+}
+
+// UnimplementedImghashServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedImghashServer struct{}
+
+func (UnimplementedImghashServer) Hash(context.Context, *HashRequest) (*HashResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Hash not implemented")
+}
+func (UnimplementedImghashServer) Compare(context.Context, *CompareRequest) (*CompareResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Compare not implemented")
+}
+func (UnimplementedImghashServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedImghashServer) BulkInsert(grpc.ClientStreamingServer[BulkInsertRequest, BulkInsertResponse]) error {
+	return status.Error(codes.Unimplemented, "method BulkInsert not implemented")
+}
+func (UnimplementedImghashServer) mustEmbedUnimplementedImghashServer() {}
+func (UnimplementedImghashServer) testEmbeddedByValue()                 {}
+
+// UnsafeImghashServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ImghashServer will
+// result in compilation errors.
+type UnsafeImghashServer interface {
+	mustEmbedUnimplementedImghashServer()
+}
+
+func RegisterImghashServer(s grpc.ServiceRegistrar, srv ImghashServer) {
+	// If the following call panics, it indicates UnimplementedImghashServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Imghash_ServiceDesc, srv)
+}
+
+func _Imghash_Hash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImghashServer).Hash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Imghash_Hash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImghashServer).Hash(ctx, req.(*HashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Imghash_Compare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImghashServer).Compare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Imghash_Compare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImghashServer).Compare(ctx, req.(*CompareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Imghash_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImghashServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Imghash_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImghashServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Imghash_BulkInsert_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ImghashServer).BulkInsert(&grpc.GenericServerStream[BulkInsertRequest, BulkInsertResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Imghash_BulkInsertServer = grpc.ClientStreamingServer[BulkInsertRequest, BulkInsertResponse]
+
+// Imghash_ServiceDesc is the grpc.ServiceDesc for Imghash service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Imghash_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "imghash.Imghash",
+	HandlerType: (*ImghashServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Hash",
+			Handler:    _Imghash_Hash_Handler,
+		},
+		{
+			MethodName: "Compare",
+			Handler:    _Imghash_Compare_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _Imghash_Search_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BulkInsert",
+			Handler:       _Imghash_BulkInsert_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/imghash.proto",
+}
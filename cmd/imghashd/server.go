@@ -0,0 +1,428 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/selftest"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errTooLarge is returned for images exceeding the size limit.
+var errTooLarge = errors.New("image too large")
+
+// server implements the HTTP API.
+type server struct {
+	algorithm string             // Name of the hashing algorithm.
+	algo      *imghash.Algorithm // The hashing algorithm.
+	store     imghash.Store      // Hashes to search; nil if none were loaded.
+	log       *imghash.UpdateLog // Changes to the store; nil if not recorded.
+	maxSize   int64              // Maximum image size, in bytes.
+	slots     chan struct{}      // Limits the number of concurrent hashes.
+	client    *http.Client       // Client for fetching remote images.
+	metrics   *promMetrics       // Exported metrics; nil if disabled.
+
+	conformance bool // Whether to serve the conformance suite.
+
+	// Algorithms clients may ask for, by lower case name, without the
+	// filters of the policy.
+	algos map[string]*imghash.Algorithm
+}
+
+// hashResponse is returned by /hash.
+type hashResponse struct {
+	Hash      string `json:"hash"`
+	Algorithm string `json:"algorithm"`
+	Profile   string `json:"profile,omitempty"`
+	Bits      int    `json:"bits"`
+}
+
+// compareResponse is returned by /compare.
+type compareResponse struct {
+	Distance   uint64  `json:"distance"`
+	Similarity float64 `json:"similarity"`
+	Verdict    string  `json:"verdict"`
+}
+
+// searchResult is a single match returned by /search.
+type searchResult struct {
+	ID       string `json:"id"`
+	Hash     string `json:"hash"`
+	Distance uint64 `json:"distance"`
+}
+
+// searchResponse is returned by /search.
+type searchResponse struct {
+	Hash    string         `json:"hash"`
+	Results []searchResult `json:"results"`
+}
+
+// countResponse is returned by /search, when asked for a count.
+type countResponse struct {
+	Hash  string `json:"hash"`
+	Count int    `json:"count"`
+}
+
+// errorResponse is returned for failed requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// handler returns the HTTP handler for all endpoints.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hash", s.handleHash)
+	mux.HandleFunc("/compare", s.handleCompare)
+	mux.HandleFunc("/search", s.handleSearch)
+	mux.HandleFunc("/snapshot", s.handleSnapshot)
+	mux.HandleFunc("/updates", s.handleUpdates)
+	mux.HandleFunc("/selftest", s.handleSelfTest)
+
+	if s.conformance {
+		c := conformanceHandler()
+		mux.Handle("/conformance", c)
+		mux.Handle("/conformance/", c)
+	}
+
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Leave some room for multipart overhead.
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxSize+1<<20)
+		mux.ServeHTTP(w, r)
+	})
+
+	if s.metrics != nil {
+		mux.Handle("/metrics", s.metrics)
+		h = s.metrics.instrument(h)
+	}
+
+	return h
+}
+
+// handleHash computes the hash of an uploaded image, or of the image
+// at the URL given in the url parameter.
+func (s *server) handleHash(w http.ResponseWriter, r *http.Request) {
+	spec, err := s.requestSpec(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	hash, status, err := s.hashRequest(r, spec)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &hashResponse{spec.format(hash), spec.algorithm, spec.profile, spec.bits})
+}
+
+// handleCompare compares the hashes given in the a and b parameters.
+func (s *server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	spec, err := s.requestSpec(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a, err := spec.parse(r.FormValue("a"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	b, err := spec.parse(r.FormValue("b"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	dist := imghash.Distance(a, b)
+	writeJSON(w, http.StatusOK, &compareResponse{
+		Distance:   dist,
+		Similarity: 1 - float64(dist)/float64(spec.bits),
+		Verdict:    spec.thresholds.Classify(dist).String(),
+	})
+}
+
+// handleSearch searches the index for the hash given in the hash
+// parameter, or for an uploaded image. The optional distance parameter
+// defaults to the near-duplicate threshold of the algorithm. With the
+// count parameter set to true, only the number of results is sent.
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusNotFound, errors.New("no index loaded"))
+		return
+	}
+
+	spec, err := s.requestSpec(r)
+	if err == nil {
+		err = s.searchable(spec)
+	}
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	distance := spec.thresholds.NearDuplicate
+	if v := r.URL.Query().Get("distance"); len(v) > 0 {
+		d, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid distance %q", v))
+			return
+		}
+		distance = d
+	}
+
+	var hash uint64
+
+	if v := r.URL.Query().Get("hash"); len(v) > 0 {
+		hash, err = parseHash(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	} else {
+		var status int
+		if hash, status, err = s.hashRequest(r, spec); err != nil {
+			writeError(w, status, err)
+			return
+		}
+	}
+
+	if r.URL.Query().Get("count") == "true" {
+		n, err := imghash.CountStore(r.Context(), s.store, hash, distance)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &countResponse{Hash: fmt.Sprintf("%016x", hash), Count: n})
+		return
+	}
+
+	if r.URL.Query().Get("stream") == "true" {
+		s.streamSearch(w, r, hash, distance)
+		return
+	}
+
+	rs, err := s.store.Query(r.Context(), hash, distance)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	resp := &searchResponse{Hash: fmt.Sprintf("%016x", hash), Results: []searchResult{}}
+	for _, res := range rs {
+		resp.Results = append(resp.Results, searchResult{
+			ID:       res.Path,
+			Hash:     fmt.Sprintf("%016x", res.Hash),
+			Distance: res.Distance,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// streamSearch sends the results of a search as they are found, one
+// JSON object per line, so searches with a lot of results need not
+// collect them first. The search stops when the client goes away.
+func (s *server) streamSearch(w http.ResponseWriter, r *http.Request, hash, distance uint64) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	err := imghash.StreamStore(r.Context(), s.store, hash, distance, func(res *imghash.SearchResult) bool {
+		return enc.Encode(&searchResult{
+			ID:       res.Path,
+			Hash:     fmt.Sprintf("%016x", res.Hash),
+			Distance: res.Distance,
+		}) == nil
+	})
+
+	// Errors after the first result can only end the stream.
+	if err != nil && r.Context().Err() == nil {
+		enc.Encode(&errorResponse{err.Error()})
+	}
+}
+
+// handleSelfTest runs the checks of the selftest package, and reports
+// them with status 200 if all passed, and 500 otherwise. The store is
+// checked as well, unless the store parameter is false.
+func (s *server) handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	var opts selftest.Options
+	if store, _ := strconv.ParseBool(r.FormValue("store")); store || len(r.FormValue("store")) == 0 {
+		opts.Store = s.store
+	}
+
+	report := selftest.Run(r.Context(), &opts)
+
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, report)
+}
+
+// handleSnapshot sends a snapshot of the index, in the format of
+// imghash.Index.WriteTo. Queries and inserts go on while it is sent.
+func (s *server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	var index *imghash.Index
+
+	switch st := s.store.(type) {
+	case *imghash.LoggedStore:
+		var seq uint64
+		if index, seq = st.SnapshotAt(); index != nil {
+			w.Header().Set("Imghash-Seq", strconv.FormatUint(seq, 10))
+		}
+	case imghash.Snapshotter:
+		index = st.Snapshot()
+	}
+
+	if index == nil {
+		writeError(w, http.StatusNotFound, errors.New("no index loaded"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	index.WriteTo(w)
+}
+
+// handleUpdates streams the update log, from after the sequence number
+// in the from parameter. Unless the follow parameter is "false", the
+// response goes on with new updates as they are made.
+func (s *server) handleUpdates(w http.ResponseWriter, r *http.Request) {
+	if s.log == nil {
+		writeError(w, http.StatusNotFound, errors.New("updates are not recorded"))
+		return
+	}
+
+	var from uint64
+	if v := r.URL.Query().Get("from"); len(v) > 0 {
+		var err error
+		if from, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid sequence number %q", v))
+			return
+		}
+	}
+
+	if from > s.log.Seq() {
+		writeError(w, http.StatusConflict, imghash.ErrUpdateSeq)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	s.log.Stream(r.Context(), w, from, r.URL.Query().Get("follow") != "false")
+}
+
+// requestSpec returns what the algorithm, profile and bits parameters of
+// the request ask to be hashed with.
+func (s *server) requestSpec(r *http.Request) (*hashSpec, error) {
+	q := r.URL.Query()
+	return s.negotiate(q.Get("algorithm"), q.Get("profile"), q.Get("bits"))
+}
+
+// hashRequest hashes the image in the request, as the spec asks. This is
+// either the image at the URL in the url parameter, the "image" field of
+// a multipart form, or the request body itself. It returns the hash, or
+// an HTTP status code and error.
+func (s *server) hashRequest(r *http.Request, spec *hashSpec) (uint64, int, error) {
+	var body io.Reader
+
+	if u := r.URL.Query().Get("url"); len(u) > 0 {
+		data, err := imghash.FetchURL(r.Context(), s.client, u, &imghash.FetchOptions{MaxSize: s.maxSize})
+		switch {
+		case err == imghash.ErrTooLarge:
+			return 0, http.StatusRequestEntityTooLarge, err
+		case err != nil:
+			return 0, http.StatusBadGateway, err
+		}
+
+		body = bytes.NewReader(data)
+	} else {
+		if r.Method != "POST" && r.Method != "PUT" {
+			return 0, http.StatusMethodNotAllowed, errors.New("expected an image upload or url parameter")
+		}
+
+		body = r.Body
+
+		// Only look for a form field in multipart requests. Parsing
+		// any other form type would consume a raw image body.
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			file, _, err := r.FormFile("image")
+			if err != nil {
+				return 0, http.StatusBadRequest, err
+			}
+
+			defer file.Close()
+			body = file
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, s.maxSize+1))
+	if err != nil {
+		return 0, http.StatusBadRequest, err
+	}
+
+	if int64(len(data)) > s.maxSize {
+		return 0, http.StatusRequestEntityTooLarge, errTooLarge
+	}
+
+	hash, err := s.hashData(r.Context(), data, spec)
+	switch {
+	case err == r.Context().Err() && err != nil:
+		return 0, http.StatusServiceUnavailable, err
+	case err == imghash.ErrTooLarge:
+		return 0, http.StatusRequestEntityTooLarge, err
+	case err != nil:
+		return 0, http.StatusUnprocessableEntity, err
+	}
+
+	return hash, http.StatusOK, nil
+}
+
+// hashData decodes and hashes the given image, as the spec asks. It waits
+// for a free slot first, so only a limited number of images are decoded
+// at once.
+func (s *server) hashData(ctx context.Context, data []byte, spec *hashSpec) (uint64, error) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	hash, err := imghash.ComputeContext(ctx, bytes.NewReader(data), spec.hash)
+	return spec.fold(hash), err
+}
+
+// parseHash parses a hexadecimal hash.
+func parseHash(v string) (uint64, error) {
+	hash, err := strconv.ParseUint(v, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash %q", v)
+	}
+
+	return hash, nil
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{err.Error()})
+}
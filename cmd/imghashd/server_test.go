@@ -0,0 +1,509 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/config"
+	"github.com/jteeuwen/imghash/fixtures"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// gopherHash is the Average hash of the gopher fixtures.
+const gopherHash = 0x0838787c7c3e3c18
+
+// newTestServer returns a server as main sets it up: hashing with
+// Average, serving Document on request, with metrics and the
+// conformance suite. The index holds the given hashes.
+func newTestServer(t *testing.T, hashes map[string]uint64) *server {
+	algos, err := loadAlgorithms("average", "document")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := *algos["average"]
+	a.Hash = policy.HashFunc(a.Hash)
+
+	store := imghash.IndexStore(imghash.NewIndex())
+	for id, hash := range hashes {
+		store.Add(context.Background(), id, hash)
+	}
+
+	return &server{
+		algorithm:   "average",
+		algo:        &a,
+		algos:       algos,
+		store:       store,
+		maxSize:     1 << 20,
+		slots:       make(chan struct{}, 2),
+		client:      http.DefaultClient,
+		metrics:     newPromMetrics(),
+		conformance: true,
+	}
+}
+
+// serve runs the request against the handler of s, and decodes the JSON
+// response into v, unless v is nil.
+func serve(t *testing.T, s *server, r *http.Request, v interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, r)
+
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v: %q", r.Method, r.URL, err, w.Body)
+		}
+	}
+
+	return w
+}
+
+// upload returns a POST of the image to target, as a multipart form if
+// form is set, and as the raw body otherwise.
+func upload(t *testing.T, target string, data []byte, form bool) *http.Request {
+	if !form {
+		return httptest.NewRequest("POST", target, bytes.NewReader(data))
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	fw, err := mw.CreateFormFile("image", "image.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Write(data)
+	mw.Close()
+
+	r := httptest.NewRequest("POST", target, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestHash(t *testing.T) {
+	s := newTestServer(t, nil)
+	gopher := fixtures.Bytes("gopher.jpg")
+
+	for _, form := range []bool{false, true} {
+		var resp hashResponse
+		w := serve(t, s, upload(t, "/hash", gopher, form), &resp)
+
+		if w.Code != http.StatusOK || resp.Hash != "0838787c7c3e3c18" || resp.Algorithm != "average" || resp.Bits != 64 {
+			t.Fatalf("form %v: status %d, %+v", form, w.Code, resp)
+		}
+	}
+
+	// Images are fetched from the url parameter.
+	images := httptest.NewServer(http.FileServer(http.FS(fixtures.Images())))
+	defer images.Close()
+
+	var resp hashResponse
+	r := httptest.NewRequest("GET", "/hash?url="+url.QueryEscape(images.URL+"/gopher.jpg"), nil)
+	if w := serve(t, s, r, &resp); w.Code != http.StatusOK || resp.Hash != "0838787c7c3e3c18" {
+		t.Fatalf("url: status %d, %+v", w.Code, resp)
+	}
+
+	r = httptest.NewRequest("GET", "/hash?url="+url.QueryEscape(images.URL+"/missing.jpg"), nil)
+	if w := serve(t, s, r, nil); w.Code != http.StatusBadGateway {
+		t.Fatalf("missing url: status %d", w.Code)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		r      *http.Request
+		status int
+	}{
+		{"no upload", httptest.NewRequest("GET", "/hash", nil), http.StatusMethodNotAllowed},
+		{"not an image", upload(t, "/hash", []byte("not an image"), false), http.StatusUnprocessableEntity},
+		{"empty body", upload(t, "/hash", nil, false), http.StatusUnprocessableEntity},
+		{"bad bits", upload(t, "/hash?bits=8", gopher, false), http.StatusBadRequest},
+		{"unknown algorithm", upload(t, "/hash?algorithm=robust", gopher, false), http.StatusBadRequest},
+		{"unknown profile", upload(t, "/hash?profile=scans", gopher, false), http.StatusBadRequest},
+	} {
+		var resp errorResponse
+		w := serve(t, s, tt.r, &resp)
+
+		if w.Code != tt.status || len(resp.Error) == 0 {
+			t.Errorf("%s: status %d, %+v; want %d", tt.name, w.Code, resp, tt.status)
+		}
+
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: content type %q", tt.name, ct)
+		}
+	}
+
+	// A multipart request without an image field.
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "gopher")
+	mw.Close()
+
+	r = httptest.NewRequest("POST", "/hash", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if w := serve(t, s, r, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("no image field: status %d", w.Code)
+	}
+}
+
+func TestUploadLimit(t *testing.T) {
+	s := newTestServer(t, nil)
+	gopher := fixtures.Bytes("gopher.jpg")
+	s.maxSize = int64(len(gopher))
+
+	// Images of exactly the limit are fine.
+	if w := serve(t, s, upload(t, "/hash", gopher, false), nil); w.Code != http.StatusOK {
+		t.Fatalf("at the limit: status %d", w.Code)
+	}
+
+	big := append(append([]byte(nil), gopher...), 0)
+	for _, form := range []bool{false, true} {
+		var resp errorResponse
+		if w := serve(t, s, upload(t, "/hash", big, form), &resp); w.Code != http.StatusRequestEntityTooLarge || resp.Error != errTooLarge.Error() {
+			t.Fatalf("form %v: status %d, %+v", form, w.Code, resp)
+		}
+	}
+
+	// So are downloads.
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(big)
+	}))
+	defer images.Close()
+
+	r := httptest.NewRequest("GET", "/hash?url="+url.QueryEscape(images.URL), nil)
+	if w := serve(t, s, r, nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("url: status %d", w.Code)
+	}
+
+	// Searches take uploads as well, with the same limit.
+	if w := serve(t, s, upload(t, "/search", big, false), nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("search: status %d", w.Code)
+	}
+
+	// Bodies well over the limit are cut off while they are read.
+	huge := bytes.Repeat([]byte{0}, int(s.maxSize)+2<<20)
+	if w := serve(t, s, upload(t, "/hash", huge, true), nil); w.Code != http.StatusBadRequest && w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("huge: status %d", w.Code)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	old := policy
+	defer func() { policy = old }()
+
+	var err error
+	policy, err = config.Read(strings.NewReader("[profiles]\nscans = [\"luma\"]\nnone = []\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, nil)
+	gopher := fixtures.Bytes("gopher.jpg")
+	document := imghash.Document(fixtures.Image("gopher.jpg"))
+
+	for _, tt := range []struct {
+		query string
+		want  hashResponse
+	}{
+		{"", hashResponse{"0838787c7c3e3c18", "average", "", 64}},
+		{"bits=64", hashResponse{"0838787c7c3e3c18", "average", "", 64}},
+		{"bits=32", hashResponse{fmt.Sprintf("%08x", imghash.Fold32(gopherHash)), "average", "", 32}},
+		{"bits=16", hashResponse{fmt.Sprintf("%04x", imghash.Fold16(gopherHash)), "average", "", 16}},
+		{"algorithm=Document", hashResponse{fmt.Sprintf("%016x", document), "document", "", 64}},
+		{"algorithm=document&bits=16", hashResponse{fmt.Sprintf("%04x", imghash.Fold16(document)), "document", "", 16}},
+		{"profile=none", hashResponse{"0838787c7c3e3c18", "average", "none", 64}},
+	} {
+		var resp hashResponse
+		if w := serve(t, s, upload(t, "/hash?"+tt.query, gopher, false), &resp); w.Code != http.StatusOK || resp != tt.want {
+			t.Errorf("%q: status %d, %+v; want %+v", tt.query, w.Code, resp, tt.want)
+		}
+	}
+
+	var resp hashResponse
+	if w := serve(t, s, upload(t, "/hash?profile=scans", gopher, false), &resp); w.Code != http.StatusOK || resp.Profile != "scans" || len(resp.Hash) != 16 {
+		t.Fatalf("profile: status %d, %+v", w.Code, resp)
+	}
+
+	// Compare parses hashes of the requested length.
+	var cmp compareResponse
+	r := httptest.NewRequest("GET", "/compare?bits=16&a=ffff&b=fff0", nil)
+	if w := serve(t, s, r, &cmp); w.Code != http.StatusOK || cmp.Distance != 4 || cmp.Similarity != 0.75 {
+		t.Fatalf("compare 16 bits: status %d, %+v", w.Code, cmp)
+	}
+
+	r = httptest.NewRequest("GET", "/compare?bits=16&a=10000&b=0", nil)
+	if w := serve(t, s, r, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("compare too long: status %d", w.Code)
+	}
+
+	// The index only holds 64 bit hashes of its own algorithm.
+	for _, query := range []string{"bits=32", "algorithm=document"} {
+		r := httptest.NewRequest("GET", "/search?hash=0&"+query, nil)
+		if w := serve(t, s, r, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("search %s: status %d", query, w.Code)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	var resp compareResponse
+	r := httptest.NewRequest("GET", "/compare?a=0838787c7c3e3c18&b=0838787c7c3e3c19", nil)
+	if w := serve(t, s, r, &resp); w.Code != http.StatusOK || resp.Distance != 1 || resp.Verdict != imghash.AverageThresholds.Classify(1).String() {
+		t.Fatalf("status %d, %+v", w.Code, resp)
+	}
+
+	// Parameters may be sent as a form, too.
+	r = httptest.NewRequest("POST", "/compare", strings.NewReader("a=0&b=ffffffffffffffff"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(t, s, r, &resp); w.Code != http.StatusOK || resp.Distance != 64 || resp.Similarity != 0 {
+		t.Fatalf("form: status %d, %+v", w.Code, resp)
+	}
+
+	for _, query := range []string{"a=0", "b=0", "a=xyz&b=0", "a=0&b=10000000000000000"} {
+		var resp errorResponse
+		r := httptest.NewRequest("GET", "/compare?"+query, nil)
+		if w := serve(t, s, r, &resp); w.Code != http.StatusBadRequest || len(resp.Error) == 0 {
+			t.Errorf("%s: status %d, %+v", query, w.Code, resp)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(t, map[string]uint64{
+		"gopher": gopherHash,
+		"near":   gopherHash ^ 0x3,
+		"far":    ^uint64(gopherHash),
+	})
+
+	var resp searchResponse
+	r := httptest.NewRequest("GET", "/search?hash=0838787c7c3e3c18", nil)
+	if w := serve(t, s, r, &resp); w.Code != http.StatusOK || resp.Hash != "0838787c7c3e3c18" || len(resp.Results) != 2 {
+		t.Fatalf("hash: status %d, %+v", w.Code, resp)
+	}
+
+	found := make(map[string]uint64)
+	for _, res := range resp.Results {
+		found[res.ID] = res.Distance
+	}
+
+	if d, ok := found["near"]; !ok || d != 2 {
+		t.Fatalf("results %+v", resp.Results)
+	}
+
+	// Uploads are hashed first.
+	if w := serve(t, s, upload(t, "/search?distance=0", fixtures.Bytes("gopher_small.png"), true), &resp); w.Code != http.StatusOK || len(resp.Results) != 1 || resp.Results[0].ID != "gopher" {
+		t.Fatalf("upload: status %d, %+v", w.Code, resp)
+	}
+
+	// Without results, the list is empty rather than null.
+	r = httptest.NewRequest("GET", "/search?hash=5555555555555555&distance=0", nil)
+	if w := serve(t, s, r, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"results":[]`) {
+		t.Fatalf("no results: status %d, %s", w.Code, w.Body)
+	}
+
+	var count countResponse
+	r = httptest.NewRequest("GET", "/search?hash=0838787c7c3e3c18&distance=64&count=true", nil)
+	if w := serve(t, s, r, &count); w.Code != http.StatusOK || count.Count != 3 {
+		t.Fatalf("count: status %d, %+v", w.Code, count)
+	}
+
+	// Streamed results come one per line.
+	r = httptest.NewRequest("GET", "/search?hash=0838787c7c3e3c18&distance=64&stream=true", nil)
+	w := serve(t, s, r, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("stream: status %d, %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var lines int
+	for sc := bufio.NewScanner(w.Body); sc.Scan(); lines++ {
+		var res searchResult
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil || len(res.ID) == 0 {
+			t.Fatalf("stream line %q: %v", sc.Text(), err)
+		}
+	}
+
+	if lines != 3 {
+		t.Fatalf("stream: %d lines", lines)
+	}
+
+	for _, query := range []string{"hash=xyz", "hash=0&distance=-1", "hash=0&distance=near", "hash=0&bits=7"} {
+		r := httptest.NewRequest("GET", "/search?"+query, nil)
+		if w := serve(t, s, r, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", query, w.Code)
+		}
+	}
+
+	// Neither a hash nor an upload.
+	if w := serve(t, s, httptest.NewRequest("GET", "/search", nil), nil); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("no hash: status %d", w.Code)
+	}
+
+	s.store = nil
+	r = httptest.NewRequest("GET", "/search?hash=0", nil)
+	if w := serve(t, s, r, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("no index: status %d", w.Code)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := newTestServer(t, map[string]uint64{"gopher": gopherHash})
+
+	w := serve(t, s, httptest.NewRequest("GET", "/snapshot", nil), nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("status %d, %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	index := imghash.NewIndex()
+	if _, err := index.ReadFrom(w.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	if rs := index.Query(gopherHash, 0); len(rs) != 1 || rs[0].Path != "gopher" {
+		t.Fatalf("snapshot results %+v", rs)
+	}
+
+	// Updates are only served when they are recorded.
+	if w := serve(t, s, httptest.NewRequest("GET", "/updates", nil), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("updates: status %d", w.Code)
+	}
+
+	s.store = nil
+	if w := serve(t, s, httptest.NewRequest("GET", "/snapshot", nil), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("no index: status %d", w.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t, map[string]uint64{"gopher": gopherHash})
+
+	imghash.SetMetrics(s.metrics)
+	defer imghash.SetMetrics(nil)
+
+	serve(t, s, upload(t, "/hash", fixtures.Bytes("gopher.jpg"), false), nil)
+	serve(t, s, upload(t, "/hash", []byte("not an image"), false), nil)
+	serve(t, s, httptest.NewRequest("GET", "/search?hash=0838787c7c3e3c18", nil), nil)
+	serve(t, s, httptest.NewRequest("GET", "/search?hash=0838787c7c3e3c18", nil), nil)
+	serve(t, s, httptest.NewRequest("GET", "/nowhere", nil), nil)
+
+	w := serve(t, s, httptest.NewRequest("GET", "/metrics", nil), nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("status %d, %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"imghash_images_hashed_total 1",
+		"imghash_decode_errors_total 1",
+		"imghash_index_query_results_count 2",
+		`imghashd_http_requests_total{path="/hash",code="200"} 1`,
+		`imghashd_http_requests_total{path="/hash",code="422"} 1`,
+		`imghashd_http_requests_total{path="/search",code="200"} 2`,
+		`imghashd_http_requests_total{path="other",code="404"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+
+	// Without metrics, there is no endpoint.
+	s.metrics = nil
+	if w := serve(t, s, httptest.NewRequest("GET", "/metrics", nil), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("disabled: status %d", w.Code)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	var suite conformanceSuite
+	if w := serve(t, s, httptest.NewRequest("GET", "/conformance", nil), &suite); w.Code != http.StatusOK || suite.Version != Version() || len(suite.Cases) == 0 {
+		t.Fatalf("suite: status %d, %d cases", w.Code, len(suite.Cases))
+	}
+
+	// The images the cases refer to are served, and hash as expected.
+	// Cases of algorithms the server was not started with are left out,
+	// as a client would leave out those it does not implement.
+	var results []conformanceResult
+	var skipped int
+	for _, c := range suite.Cases {
+		out := make(map[string]string)
+		for k, v := range c.Expect {
+			out[k] = v
+		}
+
+		if c.Kind == "hash" {
+			if s.algos[c.Input["algorithm"]] == nil {
+				skipped++
+				continue
+			}
+
+			w := serve(t, s, httptest.NewRequest("GET", c.Input["image"], nil), nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: image status %d", c.ID, w.Code)
+			}
+
+			var resp hashResponse
+			serve(t, s, upload(t, "/hash?algorithm="+c.Input["algorithm"], w.Body.Bytes(), false), &resp)
+			out["hash"] = resp.Hash
+		}
+
+		results = append(results, conformanceResult{c.ID, out})
+	}
+
+	verify := func(results []conformanceResult) (*httptest.ResponseRecorder, *conformanceReport) {
+		data, _ := json.Marshal(results)
+
+		var rep conformanceReport
+		w := serve(t, s, httptest.NewRequest("POST", "/conformance/verify", bytes.NewReader(data)), &rep)
+		return w, &rep
+	}
+
+	if w, rep := verify(results); w.Code != http.StatusOK || rep.Passed != len(results) || rep.Failed != 0 || len(rep.Missing) != skipped {
+		t.Fatalf("verify: status %d, %+v", w.Code, rep)
+	}
+
+	// Wrong outputs fail, and left out cases are missing.
+	results[0].Output["hash"] = "0000000000000000"
+	if w, rep := verify(results[:2]); w.Code != http.StatusOK || rep.Passed != 1 || rep.Failed != 1 || len(rep.Missing) != len(suite.Cases)-2 || rep.Failures[0].ID != results[0].ID {
+		t.Fatalf("failures: status %d, %+v", w.Code, rep)
+	}
+
+	if w := serve(t, s, httptest.NewRequest("GET", "/conformance/verify", nil), nil); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("verify GET: status %d", w.Code)
+	}
+
+	if w := serve(t, s, httptest.NewRequest("POST", "/conformance/verify", strings.NewReader("{")), nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("verify bad JSON: status %d", w.Code)
+	}
+
+	s.conformance = false
+	if w := serve(t, s, httptest.NewRequest("GET", "/conformance", nil), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("disabled: status %d", w.Code)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	s := newTestServer(t, map[string]uint64{"gopher": gopherHash})
+
+	for _, target := range []string{"/selftest", "/selftest?store=false"} {
+		w := serve(t, s, httptest.NewRequest("GET", target, nil), nil)
+		if w.Code != http.StatusOK {
+			body, _ := io.ReadAll(w.Body)
+			t.Fatalf("%s: status %d, %s", target, w.Code, body)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build js && wasm
+
+// imghashwasm exposes the imghash hashers to JavaScript, so
+// browsers can hash images before uploading them, and query imghashd
+// with the hashes alone. Refer to the README for how to build and load it.
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"strconv"
+	"syscall/js"
+)
+
+// defaultAlgorithm is the algorithm used when a call names none, as
+// imghashd does.
+const defaultAlgorithm = "average"
+
+func main() {
+	js.Global().Set("imghash", js.ValueOf(map[string]interface{}{
+		"hash":       js.FuncOf(hash),
+		"compare":    js.FuncOf(compare),
+		"algorithms": js.FuncOf(algorithms),
+	}))
+
+	// The functions are called from JS for as long as the page lives.
+	select {}
+}
+
+// hash computes the hash of the image in a Uint8Array.
+//
+//	imghash.hash(bytes[, algorithm]) -> {hash, algorithm, bits}
+func hash(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return failure(fmt.Errorf("expected a Uint8Array"))
+	}
+
+	name := algorithmArg(args, 1)
+	a, err := imghash.LookupAlgorithm(name)
+	if err != nil {
+		return failure(err)
+	}
+
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+
+	h, err := imghash.ComputeBytes(data, a.Hash)
+	if err != nil {
+		return failure(err)
+	}
+
+	return map[string]interface{}{
+		"hash":      imghash.FormatHash(h),
+		"algorithm": name,
+		"bits":      64,
+	}
+}
+
+// compare compares two hashes, as returned by hash.
+//
+//	imghash.compare(a, b[, algorithm]) -> {distance, similarity, verdict}
+func compare(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return failure(fmt.Errorf("expected two hashes"))
+	}
+
+	var hashes [2]uint64
+	for i := range hashes {
+		h, err := strconv.ParseUint(args[i].String(), 16, 64)
+		if err != nil {
+			return failure(fmt.Errorf("invalid hash %q", args[i].String()))
+		}
+
+		hashes[i] = h
+	}
+
+	a, err := imghash.LookupAlgorithm(algorithmArg(args, 2))
+	if err != nil {
+		return failure(err)
+	}
+
+	dist := imghash.Distance(hashes[0], hashes[1])
+	return map[string]interface{}{
+		"distance":   int(dist),
+		"similarity": imghash.Similarity(hashes[0], hashes[1]),
+		"verdict":    a.Thresholds.Classify(dist).String(),
+	}
+}
+
+// algorithms lists the names of the known algorithms.
+//
+//	imghash.algorithms() -> [name, ...]
+func algorithms(this js.Value, args []js.Value) interface{} {
+	names := imghash.Algorithms()
+	list := make([]interface{}, len(names))
+	for i, name := range names {
+		list[i] = name
+	}
+	return list
+}
+
+// algorithmArg returns the algorithm named by argument i, or the
+// default if there is none.
+func algorithmArg(args []js.Value, i int) string {
+	if i < len(args) && args[i].Type() == js.TypeString {
+		return args[i].String()
+	}
+
+	return defaultAlgorithm
+}
+
+// failure returns the error to JS, in the form imghashd uses.
+func failure(err error) interface{} {
+	return map[string]interface{}{"error": err.Error()}
+}
@@ -0,0 +1,131 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+)
+
+// adobeSegment is an APP14 segment marking a JPEG as Adobe, with its
+// colour transform unknown: CMYK for 4-component images.
+var adobeSegment = []byte{
+	0xff, 0xee, 0x00, 0x0e,
+	'A', 'd', 'o', 'b', 'e',
+	0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// decodeJPEG decodes a JPEG as jpeg.Decode does, as well as CMYK JPEGs
+// without an Adobe APP14 segment, which it rejects. CMYK and YCCK JPEGs
+// from print workflows come out as *image.CMYK either way.
+//
+// Adobe applications store CMYK inverted, 255 meaning no ink, and the
+// decoder undoes this. Other encoders, and libjpeg when told to leave
+// out the segment, store CMYK as it is. Those are decoded with a
+// segment added, and inverted back.
+func decodeJPEG(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err == nil || !missingAdobe(err) || len(data) < 2 {
+		return img, err
+	}
+
+	patched := make([]byte, 0, len(data)+len(adobeSegment))
+	patched = append(patched, data[:2]...)
+	patched = append(patched, adobeSegment...)
+	patched = append(patched, data[2:]...)
+
+	if img, err = jpeg.Decode(bytes.NewReader(patched)); err != nil {
+		return nil, err
+	}
+
+	if m, ok := img.(*image.CMYK); ok {
+		for i := range m.Pix {
+			m.Pix[i] = 0xff - m.Pix[i]
+		}
+	}
+
+	return img, nil
+}
+
+// missingAdobe returns true if err is the error of jpeg.Decode for
+// 4-component JPEGs without an Adobe APP14 segment.
+func missingAdobe(err error) bool {
+	_, ok := err.(jpeg.UnsupportedError)
+	return ok && strings.Contains(err.Error(), "Adobe APP14")
+}
+
+// cmykRGB converts a CMYK pixel to 16-bit RGB, as color.CMYK does.
+func cmykRGB(c, m, y, k uint8) (r, g, b uint32) {
+	w := 0xffff - uint32(k)*0x101
+	r = (0xffff - uint32(c)*0x101) * w / 0xffff
+	g = (0xffff - uint32(m)*0x101) * w / 0xffff
+	b = (0xffff - uint32(y)*0x101) * w / 0xffff
+	return
+}
+
+// resizeCMYK is resizeSums for the CMYK image slice r of m.
+func resizeCMYK(m *image.CMYK, r image.Rectangle, w, h int) ([]uint64, uint64) {
+	ww, hh := uint64(w), uint64(h)
+	dx, dy := uint64(r.Dx()), uint64(r.Dy())
+	n, sum := dx*dy, make([]uint64, 4*w*h)
+
+	var x, y int
+	var p []uint8
+	var pixOffset int
+	var r32, g32, b32 uint32
+
+	minx, miny := r.Min.X, r.Min.Y
+	maxx, maxy := r.Max.X, r.Max.Y
+
+	for y = miny; y < maxy; y++ {
+		pixOffset = m.PixOffset(minx, y)
+
+		for x = minx; x < maxx; x++ {
+			p = m.Pix[pixOffset : pixOffset+4]
+			r32, g32, b32 = cmykRGB(p[0], p[1], p[2], p[3])
+			pixOffset += 4
+
+			spread(sum, x-minx, y-miny, ww, hh, dx, dy, uint64(r32), uint64(g32), uint64(b32), 0xffff)
+		}
+	}
+
+	return sum, n
+}
+
+// luminanceCMYK is luminance for CMYK images. Pixels are read directly,
+// rather than through At, which allocates for each.
+func luminanceCMYK(m *image.CMYK) *image.Gray16 {
+	rect := m.Bounds()
+	gray := image.NewGray16(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+
+	var x, y, i, o int
+	var p []uint8
+
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		i = m.PixOffset(rect.Min.X, y)
+		o = gray.PixOffset(0, y-rect.Min.Y)
+
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			p = m.Pix[i : i+4]
+			r, g, b := cmykRGB(p[0], p[1], p[2], p[3])
+
+			// As color.Gray16Model converts.
+			v := (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+			gray.Pix[o] = uint8(v >> 8)
+			gray.Pix[o+1] = uint8(v)
+			i += 4
+			o += 2
+		}
+	}
+
+	return gray
+}
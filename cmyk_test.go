@@ -0,0 +1,124 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// encodeCMYKJPEG returns a baseline 4-component JPEG of an 8x8 image of
+// a single colour, with or without an Adobe APP14 segment. The standard
+// library can not write these; only the DC coefficients need coding.
+func encodeCMYKJPEG(c color.CMYK, adobe bool) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xd8})
+
+	if adobe {
+		buf.Write(adobeSegment)
+	}
+
+	// Quantization table 0, all ones.
+	buf.Write([]byte{0xff, 0xdb, 0x00, 0x43, 0x00})
+	buf.Write(bytes.Repeat([]byte{1}, 64))
+
+	// Frame header: 8 bits, 8x8, four components without subsampling.
+	buf.Write([]byte{0xff, 0xc0, 0x00, 0x14, 0x08, 0x00, 0x08, 0x00, 0x08, 0x04})
+	for i := byte(1); i <= 4; i++ {
+		buf.Write([]byte{i, 0x11, 0x00})
+	}
+
+	// DC categories 0 to 11 as 4-bit codes, and end of block as the
+	// only AC code, 1 bit long.
+	buf.Write([]byte{0xff, 0xc4, 0x00, 0x31, 0x00, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	buf.Write([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11})
+	buf.Write([]byte{0x10, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x00})
+
+	buf.Write([]byte{0xff, 0xda, 0x00, 0x0e, 0x04})
+	for i := byte(1); i <= 4; i++ {
+		buf.Write([]byte{i, 0x00})
+	}
+	buf.Write([]byte{0x00, 0x3f, 0x00})
+
+	var bits uint64
+	var n uint
+	put := func(v uint64, size uint) {
+		bits, n = bits<<size|v&(1<<size-1), n+size
+	}
+
+	for _, v := range []uint8{c.C, c.M, c.Y, c.K} {
+		dc := 8 * (int(v) - 128)
+
+		var cat uint
+		for a := dc; a != 0; a /= 2 {
+			cat++
+		}
+
+		if dc < 0 {
+			dc += 1<<cat - 1
+		}
+
+		put(uint64(cat), 4)
+		put(uint64(dc), cat)
+		put(0, 1)
+	}
+
+	for ; n%8 != 0; n++ {
+		bits = bits<<1 | 1
+	}
+
+	for n > 0 {
+		n -= 8
+		b := byte(bits >> n)
+		buf.WriteByte(b)
+		if b == 0xff {
+			buf.WriteByte(0)
+		}
+	}
+
+	buf.Write([]byte{0xff, 0xd9})
+	return buf.Bytes()
+}
+
+func TestDecodeCMYK(t *testing.T) {
+	want := color.CMYK{C: 10, M: 200, Y: 90, K: 30}
+	inverted := color.CMYK{C: 0xff - want.C, M: 0xff - want.M, Y: 0xff - want.Y, K: 0xff - want.K}
+
+	// Adobe applications store CMYK inverted; others store it as it is,
+	// without the segment.
+	for name, data := range map[string][]byte{
+		"adobe":    encodeCMYKJPEG(inverted, true),
+		"unmarked": encodeCMYKJPEG(want, false),
+	} {
+		img, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		m, ok := img.(*image.CMYK)
+		if !ok {
+			t.Fatalf("%s: decoded as %T", name, img)
+		}
+
+		if got := m.CMYKAt(3, 3); got != want {
+			t.Fatalf("%s: colour %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLuminanceCMYK(t *testing.T) {
+	m := image.NewCMYK(image.Rect(0, 0, 2, 2))
+	m.Pix = []uint8{0, 0, 0, 0, 255, 0, 0, 0, 10, 200, 90, 30, 0, 0, 0, 255}
+
+	got := luminanceCMYK(m)
+	for i := range got.Pix {
+		x, y := i/2%2, i/4
+		want := color.Gray16Model.Convert(m.At(x, y)).(color.Gray16)
+		if g := got.Gray16At(x, y); g != want {
+			t.Fatalf("(%d, %d): luminance %v, want %v", x, y, g, want)
+		}
+	}
+}
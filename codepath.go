@@ -0,0 +1,78 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+)
+
+// GenericEnv is the environment variable which, set to any value but
+// "0", forces the generic code paths from the start, as SetGeneric does.
+const GenericEnv = "IMGHASH_GENERIC"
+
+// generic is set to take the generic code paths everywhere.
+var generic atomic.Bool
+
+func init() {
+	if v := os.Getenv(GenericEnv); len(v) > 0 && v != "0" {
+		generic.Store(true)
+	}
+}
+
+// A CodePath is an optimised implementation of some part of hashing,
+// which is taken in place of the generic one where it applies. Both
+// yield the same results; only their speed differs.
+type CodePath struct {
+	Name   string `json:"name"`   // Name of the path, such as "resize-typed".
+	Active bool   `json:"active"` // Whether it is taken in this process.
+	Reason string `json:"reason"` // What it does, and why it is not taken, if it is not.
+}
+
+func (p CodePath) String() string {
+	state := "off"
+	if p.Active {
+		state = "on"
+	}
+	return fmt.Sprintf("%s: %s (%s)", p.Name, state, p.Reason)
+}
+
+// CodePaths reports which optimised code paths are taken on this
+// machine, in this process, so a report of a slow or differing hash can
+// state exactly which code ran. Paths which need particular CPU features
+// are listed with them.
+func CodePaths() []CodePath {
+	forced := generic.Load()
+	path := func(name, reason string, active bool) CodePath {
+		if forced {
+			return CodePath{name, false, reason + "; forced off by SetGeneric or " + GenericEnv}
+		}
+		return CodePath{name, active, reason}
+	}
+
+	procs := runtime.GOMAXPROCS(0)
+	parallel := fmt.Sprintf("large searches of the Go backend split over %d CPUs", procs)
+	if procs < 2 {
+		parallel = "large searches of the Go backend split over all CPUs; needs GOMAXPROCS of 2 or more"
+	}
+
+	return []CodePath{
+		path("resize-typed", "pixels of the image types of the standard decoders read directly, rather than through At", true),
+		path("pyramid", "grids of the hashes of a MultiHasher derived from one shared pyramid of the image", true),
+		path("luminance-paletted", "palette entries of paletted images converted once, rather than per pixel", true),
+		path("luminance-cmyk", "pixels of CMYK images converted to luminance directly, rather than through At", true),
+		path("tile-typed", "rows of tiled images read directly, rather than through At", true),
+		path("distance-parallel", parallel, procs > 1),
+	}
+}
+
+// SetGeneric forces the generic code paths everywhere if on is true, and
+// allows the optimised ones again if it is false. It returns the previous
+// setting. Hashes do not change either way; use it to check as much, or
+// to rule out an optimised path when chasing a bug.
+func SetGeneric(on bool) bool {
+	return generic.Swap(on)
+}
@@ -0,0 +1,55 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"testing"
+)
+
+func TestGenericPaths(t *testing.T) {
+	src := synth.Shapes(70, 50, 1)
+	multi := MultiHasher(Average, Screenshot(nil), Document)
+
+	hashes := func() map[string][]uint64 {
+		out := make(map[string][]uint64)
+		for _, kind := range boundsTypes {
+			img := convertTo(kind, src, src.Rect)
+			th := NewTiledHasher(70, 50, Average, nil)
+			for _, r := range []image.Rectangle{image.Rect(0, 0, 40, 50), image.Rect(40, 0, 70, 50)} {
+				th.Add(r.Min, convertTo(kind, src.SubImage(r), image.Rectangle{Max: r.Size()}))
+			}
+
+			out[kind] = append([]uint64{Average(img), Preprocess(Average, Equalize)(img), th.Hash()}, multi(img)...)
+		}
+		return out
+	}
+
+	fast := hashes()
+	if prev := SetGeneric(true); prev {
+		t.Fatal("generic paths forced from the start")
+	}
+
+	for _, p := range CodePaths() {
+		if p.Active {
+			t.Errorf("%s still active", p)
+		}
+	}
+
+	slow := hashes()
+	SetGeneric(false)
+
+	for kind, want := range fast {
+		for i, h := range slow[kind] {
+			if h != want[i] {
+				t.Errorf("%s: hash %d is 0x%x on the generic path, 0x%x on the fast one", kind, i, h, want[i])
+			}
+		}
+	}
+
+	if p := CodePaths(); len(p) == 0 || !p[0].Active {
+		t.Fatalf("paths after reset %v", p)
+	}
+}
@@ -0,0 +1,543 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"sort"
+)
+
+// Parameters of Panels. Levels are 8-bit.
+const (
+	collageSize   = 384 // Longest side of the copy searched for separators.
+	collageFlat   = 8   // Largest standard deviation of a single colour.
+	collageStep   = 24  // Smallest step in any channel across a seam.
+	collageSeam   = 0.7 // Smallest fraction of a seam showing a step.
+	collageGutter = 0.6 // Smallest fraction of a gutter's edge showing one.
+	collageDepth  = 2   // Number of times panels are split in turn.
+	collageBlur   = 2   // Lines next to a gutter which compression may blur.
+
+	// Largest number of panels along either axis. Other images
+	// with more straight lines than this, like checkerboards and
+	// tables, are not taken for collages.
+	collagePanels = 4
+)
+
+// Panels finds the photos in a collage: an image made by placing
+// several photos next to each other, typically in a grid of 2x2 or 3x3.
+// It returns the bounds of each photo, in the coordinates of img and in
+// reading order, or nil if img does not look like a collage.
+//
+// The photos are told apart by the lines between them: gutters, which
+// are strips of a single colour, or seams, where two photos simply meet
+// and the colours change along a straight line. Any frame around the
+// collage, and the gutters, are left out of the photos. Lines
+// must run across the whole image, or across a photo found earlier,
+// so grids and layouts like one large photo next to a column of small
+// ones are found; free-form arrangements are not.
+//
+// A photo with its own straight lines, like the horizon over the sea
+// or the edges of a building, may be split up where there is no
+// collage. Search with the whole image as well as with the panels.
+func Panels(img image.Image) []image.Rectangle {
+	c := newCollage(img)
+	if c == nil {
+		return nil
+	}
+
+	// Panels of a single colour are not photos. Dropping them
+	// also keeps checkerboards from passing for collages.
+	var cells []image.Rectangle
+	for _, r := range c.split(image.Rect(0, 0, c.w, c.h), 0) {
+		if !c.flat(r) {
+			cells = append(cells, r)
+		}
+	}
+
+	if len(cells) < 2 || c.pattern {
+		return nil
+	}
+
+	// Splits along either axis come first, wherever there are more.
+	// Sort the panels into reading order.
+	sort.Slice(cells, func(a, b int) bool {
+		if cells[a].Min.Y != cells[b].Min.Y {
+			return cells[a].Min.Y < cells[b].Min.Y
+		}
+		return cells[a].Min.X < cells[b].Min.X
+	})
+
+	for i := range cells {
+		cells[i] = c.unscale(cells[i])
+	}
+
+	return cells
+}
+
+// HashPanels hashes each of the photos Panels finds in a collage, in the
+// same order. It returns nil if img does not look like a collage.
+//
+// Photos which were shared on their own, and later in a collage, match
+// their hashes in the collage, so an index of the originals can be
+// searched with each of them.
+func HashPanels(img image.Image, hf HashFunc) []uint64 {
+	panels := Panels(img)
+	if panels == nil {
+		return nil
+	}
+
+	hashes := make([]uint64, len(panels))
+	for i, p := range panels {
+		hashes[i] = hf(Region(img, p))
+	}
+
+	return hashes
+}
+
+// collage holds the pixels of a smaller copy of an image searched by
+// Panels, as 8-bit RGB.
+type collage struct {
+	rect    image.Rectangle // Bounds of the image.
+	w, h    int             // Size of the copy.
+	pix     []uint8
+	gutter  *[3]int // Colour of the gutters and the frame, once found.
+	pattern bool    // Panels can be split further than collageDepth.
+}
+
+// newCollage returns a copy of img to search for the lines between
+// panels in, or nil if img is empty.
+func newCollage(img image.Image) *collage {
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+
+	if w > collageSize || h > collageSize {
+		if w >= h {
+			w, h = collageSize, h*collageSize/w
+		} else {
+			w, h = w*collageSize/h, collageSize
+		}
+
+		if w < 1 {
+			w = 1
+		}
+
+		if h < 1 {
+			h = 1
+		}
+	}
+
+	small := resize(bounded(img), w, h)
+	c := &collage{rect: rect, w: w, h: h, pix: make([]uint8, 0, 3*w*h)}
+
+	var x, y int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			r, g, b, _ := small.At(x, y).RGBA()
+			c.pix = append(c.pix, uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		}
+	}
+
+	return c
+}
+
+// unscale maps r in the copy onto the image.
+func (c *collage) unscale(r image.Rectangle) image.Rectangle {
+	// Pixels along the edges of r in a smaller copy blend
+	// in the gutters around it. Leave those out.
+	if c.w < c.rect.Dx() {
+		r = r.Inset(1)
+	}
+
+	w, h := c.rect.Dx(), c.rect.Dy()
+	return image.Rect(
+		c.rect.Min.X+r.Min.X*w/c.w, c.rect.Min.Y+r.Min.Y*h/c.h,
+		c.rect.Min.X+r.Max.X*w/c.w, c.rect.Min.Y+r.Max.Y*h/c.h,
+	)
+}
+
+// frame returns the part of r inside the frame around it, and the frame's
+// colour, or nil if there is no frame. It returns false if there seems
+// to be more frame than photo, as for images of mostly a single colour.
+func (c *collage) frame(r image.Rectangle) (image.Rectangle, *[3]int, bool) {
+	cols, rows := c.profile(r, false), c.profile(r, true)
+	x0, x1 := cols.trim()
+	y0, y1 := rows.trim()
+
+	// Frames run along opposite sides in pairs, in one colour. A flat
+	// strip along a single side is more likely part of the photo.
+	if (x0 > 0) != (x1 < r.Dx()) || x0 > 0 && !sameColour(cols.mean[0], cols.mean[r.Dx()-1]) {
+		x0, x1 = 0, r.Dx()
+	}
+
+	if (y0 > 0) != (y1 < r.Dy()) || y0 > 0 && !sameColour(rows.mean[0], rows.mean[r.Dy()-1]) {
+		y0, y1 = 0, r.Dy()
+	}
+
+	if 2*(x1-x0) < r.Dx() || 2*(y1-y0) < r.Dy() {
+		return r, nil, false
+	}
+
+	var colour *[3]int
+	switch {
+	case x0 > 0:
+		colour = &cols.mean[0]
+	case y0 > 0:
+		colour = &rows.mean[0]
+	default:
+		return r, nil, true
+	}
+
+	return image.Rect(r.Min.X+x0, r.Min.Y+y0, r.Min.X+x1, r.Min.Y+y1), colour, true
+}
+
+// flat returns true if r is of a single colour.
+func (c *collage) flat(r image.Rectangle) bool {
+	var s stats
+	var x, y int
+	for y = r.Min.Y; y < r.Max.Y; y++ {
+		for x = r.Min.X; x < r.Max.X; x++ {
+			s.add(c.pix[3*(y*c.w+x):])
+		}
+	}
+
+	return s.flat()
+}
+
+// stats sums the channels of a number of pixels, and their squares.
+// The sums are 64-bit, as the squares overflow 32-bit ints.
+type stats struct {
+	n       int64
+	sum, sq [3]int64
+}
+
+func (s *stats) add(p []uint8) {
+	s.n++
+	for i, v := range p[:3] {
+		s.sum[i] += int64(v)
+		s.sq[i] += int64(v) * int64(v)
+	}
+}
+
+// flat returns true if the pixels are of a single colour. Variances are
+// compared, rather than standard deviations, to keep to integers.
+func (s *stats) flat() bool {
+	var v int64
+	for i := range s.sum {
+		v += s.sq[i]*s.n - s.sum[i]*s.sum[i]
+	}
+
+	return v <= collageFlat*collageFlat*s.n*s.n
+}
+
+// mean returns the mean colour of the pixels.
+func (s *stats) mean() [3]int {
+	var m [3]int
+	if s.n > 0 {
+		for i := range m {
+			m[i] = int(s.sum[i] / s.n)
+		}
+	}
+	return m
+}
+
+// A profile describes the lines across a rectangle, along one axis.
+type profile struct {
+	flat  []bool    // Line i is of a single colour,
+	mean  [][3]int  // which is this one, on average.
+	off   []float64 // Fraction of line i off its mean colour.
+	edges []float64 // Fraction of line i with a step from line i-1.
+}
+
+// same returns true if line i is flat, and line j is of the same
+// colour. Line j may be off in a few more places, as compression blurs
+// the lines next to photos more than those further out.
+func (p *profile) same(i, j int) bool {
+	return p.flat[i] && 4*p.off[j] <= 1 && sameColour(p.mean[i], p.mean[j])
+}
+
+// sameColour returns true if a and b are the same colour, give or
+// take the noise allowed in flat lines.
+func sameColour(a, b [3]int) bool {
+	for i := range a {
+		if d := a[i] - b[i]; d > 2*collageFlat || d < -2*collageFlat {
+			return false
+		}
+	}
+
+	return true
+}
+
+// profile returns the profile of the columns of r, or of its rows
+// if rows is set.
+func (c *collage) profile(r image.Rectangle, rows bool) *profile {
+	n, m := r.Dx(), r.Dy()
+	if rows {
+		n, m = m, n
+	}
+
+	at := func(i, j int) []uint8 {
+		if rows {
+			return c.pix[3*((r.Min.Y+i)*c.w+r.Min.X+j):]
+		}
+		return c.pix[3*((r.Min.Y+j)*c.w+r.Min.X+i):]
+	}
+
+	p := &profile{
+		flat:  make([]bool, n),
+		mean:  make([][3]int, n),
+		off:   make([]float64, n),
+		edges: make([]float64, n),
+	}
+
+	var i, j, k int
+	for i = 0; i < n; i++ {
+		var s stats
+		var steps int
+
+		for j = 0; j < m; j++ {
+			v := at(i, j)
+			s.add(v)
+
+			if i == 0 {
+				continue
+			}
+
+			u := at(i-1, j)
+			for k = 0; k < 3; k++ {
+				if d := int(v[k]) - int(u[k]); d >= collageStep || d <= -collageStep {
+					steps++
+					break
+				}
+			}
+		}
+
+		p.mean[i] = s.mean()
+		p.edges[i] = float64(steps) / float64(m)
+
+		// Compression smears the photos into the lines along them, in
+		// places. Allow for a few pixels off the colour of the line.
+		var off int
+		for j = 0; j < m; j++ {
+			v := at(i, j)
+			if !sameColour([3]int{int(v[0]), int(v[1]), int(v[2])}, p.mean[i]) {
+				off++
+			}
+		}
+
+		p.off[i] = float64(off) / float64(m)
+		p.flat[i] = 20*off <= m
+	}
+
+	return p
+}
+
+// trim returns the range of lines in the profile which are not part
+// of a frame: flat lines of the same colour along either end, with a
+// step to the photos inside. Without the step, the lines are more
+// likely a plain background in a photo.
+func (p *profile) trim() (lo, hi int) {
+	n := len(p.flat)
+	for lo < n && p.same(0, lo) {
+		lo++
+	}
+
+	if lo > 0 {
+		if lo = p.step(lo, 1); lo < 0 {
+			lo = 0
+		}
+	}
+
+	for hi = n; hi > lo && p.same(n-1, hi-1); hi-- {
+	}
+
+	if hi < n {
+		if hi = p.step(hi, -1); hi <= lo {
+			hi = n
+		}
+	}
+
+	return lo, hi
+}
+
+// step returns the first line from i onwards, in the given direction,
+// which has a step from the line before it. It looks collageBlur lines
+// past i at most, and returns -1 if there is no step.
+func (p *profile) step(i, dir int) int {
+	var k int
+	for k = 0; k <= collageBlur; k++ {
+		if j := i + k*dir; j >= 0 && j < len(p.edges) && p.edges[j] >= collageGutter {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// A cut separates two panels: lines lo up to hi are left out of both.
+// Seams leave out no lines; gutters do, and have a colour.
+type cut struct {
+	lo, hi   int
+	strength float64
+	colour   [3]int
+}
+
+// cuts returns the lines which split the range lo to hi of the profile
+// into panels, in order. If gutter is not nil, only gutters of that
+// colour are taken.
+func (p *profile) cuts(lo, hi int, gutter *[3]int) []cut {
+	var found []cut
+	var seams int
+
+	// Gutters are runs of flat lines of the same colour, with a step
+	// on either side. They are preferred over seams.
+	var i, j int
+	for i = lo + 1; i < hi; i++ {
+		if !p.flat[i] {
+			continue
+		}
+
+		for j = i; j < hi && p.same(i, j); j++ {
+		}
+
+		if gutter != nil && !sameColour(p.mean[i], *gutter) {
+			i = j - 1
+			continue
+		}
+
+		if a, b := p.step(i, -1), p.step(j, 1); j < hi && j-i <= (hi-lo)/8 && a > lo && b > 0 && b < hi {
+			found = append(found, cut{a, b, 2, p.mean[i]})
+		}
+
+		i = j - 1
+	}
+
+	// Seams are lines with a step along most of their length, and few
+	// on the lines next to them. Edges within photos, like those of
+	// text, come with more.
+	for i = lo + 1; i < hi-1; i++ {
+		e := p.edges[i]
+		if e < collageSeam || 2*p.edges[i-1] > e || 2*p.edges[i+1] > e {
+			continue
+		}
+
+		seams++
+		found = append(found, cut{i, i, e, [3]int{}})
+	}
+
+	// Lots of straight lines make for a pattern, rather than panels.
+	if seams > 2*collagePanels {
+		n := 0
+		for _, f := range found {
+			if f.lo < f.hi {
+				found[n] = f
+				n++
+			}
+		}
+		found = found[:n]
+	}
+
+	sort.SliceStable(found, func(a, b int) bool {
+		return found[a].strength > found[b].strength
+	})
+
+	// Take the strongest cuts which leave panels of a reasonable size.
+	min := (hi - lo) / 6
+	if min < 8 {
+		min = 8
+	}
+
+	var cuts []cut
+	for _, f := range found {
+		if len(cuts) == collagePanels-1 {
+			break
+		}
+
+		ok := f.lo-lo >= min && hi-f.hi >= min
+		for _, c := range cuts {
+			if ok && f.lo < c.hi+min && c.lo < f.hi+min {
+				ok = false
+			}
+		}
+
+		if ok {
+			cuts = append(cuts, f)
+		}
+	}
+
+	sort.Slice(cuts, func(a, b int) bool { return cuts[a].lo < cuts[b].lo })
+	return cuts
+}
+
+// split returns the panels in r, splitting those it finds up to
+// collageDepth times.
+func (c *collage) split(r image.Rectangle, depth int) []image.Rectangle {
+	if depth == collageDepth {
+		// Photos split this often, which still hold lines across them,
+		// are more likely parts of a regular pattern.
+		if len(c.profile(r, false).cuts(0, r.Dx(), c.gutter)) > 0 || len(c.profile(r, true).cuts(0, r.Dy(), c.gutter)) > 0 {
+			c.pattern = true
+		}
+
+		return []image.Rectangle{r}
+	}
+
+	// Leave out the frame around the whole collage. Panels have
+	// their gutters cut off already, and flat lines along their
+	// edges are part of the photos.
+	if depth == 0 {
+		var ok bool
+		if r, c.gutter, ok = c.frame(r); !ok {
+			return []image.Rectangle{r}
+		}
+	}
+
+	cols, rows := c.profile(r, false), c.profile(r, true)
+
+	// Split along whichever axis has more cuts; the other is
+	// split in turn within each panel.
+	cuts, vertical := cols.cuts(0, r.Dx(), c.gutter), true
+	if hc := rows.cuts(0, r.Dy(), c.gutter); len(hc) > len(cuts) {
+		cuts, vertical = hc, false
+	}
+
+	if len(cuts) == 0 {
+		return []image.Rectangle{r}
+	}
+
+	// Collages have gutters of a single colour, if any.
+	for _, cut := range cuts {
+		if c.gutter == nil && cut.lo < cut.hi {
+			c.gutter = &cut.colour
+		}
+	}
+
+	var panels []image.Rectangle
+	lo := 0
+	for i := 0; i <= len(cuts); i++ {
+		hi := r.Dx()
+		if !vertical {
+			hi = r.Dy()
+		}
+
+		if i < len(cuts) {
+			hi = cuts[i].lo
+		}
+
+		p := image.Rect(r.Min.X+lo, r.Min.Y, r.Min.X+hi, r.Max.Y)
+		if !vertical {
+			p = image.Rect(r.Min.X, r.Min.Y+lo, r.Max.X, r.Min.Y+hi)
+		}
+
+		panels = append(panels, c.split(p, depth+1)...)
+
+		if i < len(cuts) {
+			lo = cuts[i].hi
+		}
+	}
+
+	return panels
+}
@@ -0,0 +1,105 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// makeCollage places cols by rows photos of 160x120 pixels from gen in a
+// grid, with the given gutter between them and frame around them. It
+// returns the collage and the bounds of the photos.
+func makeCollage(cols, rows, gutter, frame int, seed int64, gen synth.Generator) (*image.RGBA, []image.Rectangle) {
+	const w, h = 160, 120
+
+	img := image.NewRGBA(image.Rect(0, 0, 2*frame+cols*w+(cols-1)*gutter, 2*frame+rows*h+(rows-1)*gutter))
+	draw.Draw(img, img.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	var rects []image.Rectangle
+
+	var row, col int
+	for row = 0; row < rows; row++ {
+		for col = 0; col < cols; col++ {
+			photo := gen(w, h, seed+int64(len(rects)))
+			at := image.Pt(frame+col*(w+gutter), frame+row*(h+gutter))
+			rect := image.Rectangle{at, at.Add(image.Pt(w, h))}
+			draw.Draw(img, rect, photo, image.Point{}, draw.Src)
+
+			rects = append(rects, rect)
+		}
+	}
+
+	return img, rects
+}
+
+func TestPanels(t *testing.T) {
+	tests := []struct {
+		name                      string
+		cols, rows, gutter, frame int
+		gen                       synth.Generator
+	}{
+		{"2x2 gutters", 2, 2, 6, 6, synth.Gradient},
+		{"3x3 gutters", 3, 3, 4, 4, synth.Shapes},
+		{"3x2 no frame", 3, 2, 8, 0, synth.Text},
+		{"2x2 seams", 2, 2, 0, 0, synth.Gradient},
+		{"2x1 seam", 2, 1, 0, 0, synth.Text},
+	}
+
+	for _, tt := range tests {
+		img, rects := makeCollage(tt.cols, tt.rows, tt.gutter, tt.frame, 100, tt.gen)
+
+		// Collages are mostly shared as JPEGs.
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75})
+		decoded, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		panels := Panels(decoded)
+		if len(panels) != len(rects) {
+			t.Fatalf("%s: %d panels, want %d: %v", tt.name, len(panels), len(rects), panels)
+		}
+
+		for i, p := range panels {
+			// The panels' edges are close to the photos'.
+			d, e := p.Min.Sub(rects[i].Min), p.Max.Sub(rects[i].Max)
+			if d.X*d.X+d.Y*d.Y > 18 || e.X*e.X+e.Y*e.Y > 18 {
+				t.Fatalf("%s: panel %d is %v, want %v", tt.name, i, p, rects[i])
+			}
+		}
+
+		// Compression alone moves the hashes of some photos, so
+		// compare them with the photos in the collage.
+		for i, hash := range HashPanels(decoded, Average) {
+			if d := Distance(hash, Average(Region(decoded, rects[i]))); d > AverageThresholds.Duplicate {
+				t.Fatalf("%s: panel %d is at distance %d of its photo", tt.name, i, d)
+			}
+		}
+
+		// Panels keep the coordinates of the image.
+		moved := image.NewRGBA(img.Rect.Add(image.Pt(-50, 30)))
+		draw.Draw(moved, moved.Rect, img, image.Point{}, draw.Src)
+
+		if p := Panels(moved); len(p) != len(rects) || p[0] != Panels(img)[0].Add(image.Pt(-50, 30)) {
+			t.Fatalf("%s: moved image: %v", tt.name, p)
+		}
+	}
+}
+
+func TestPanelsNoCollage(t *testing.T) {
+	for name, gen := range synth.Generators {
+		for seed := int64(0); seed < 20; seed++ {
+			if p := Panels(gen(300, 200, seed)); p != nil {
+				t.Fatalf("%s %d: %v", name, seed, p)
+			}
+		}
+	}
+}
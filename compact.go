@@ -0,0 +1,257 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMinEmpty is the default CompactOptions.MinEmpty.
+const defaultMinEmpty = 0.25
+
+// CompactProgress describes how far along a compaction is.
+type CompactProgress struct {
+	Nodes   int           // Number of nodes holding IDs, to go in the new tree.
+	Done    int           // Number of those inserted so far.
+	Elapsed time.Duration // Time since the compaction started.
+}
+
+// CompactOptions configure the compaction of a store. The zero value is
+// a valid configuration. Callbacks are called from the goroutine doing
+// the compaction.
+type CompactOptions struct {
+	// Share of the nodes of the tree which must be empty for it to be
+	// compacted. Defaults to 0.25. A negative value compacts always.
+	MinEmpty float64
+
+	// If > 0, nodes are inserted into the new tree at no more than this
+	// many per second, so a compaction does not take a CPU from queries.
+	Rate int
+
+	// How often ScheduleCompaction checks the tree. Defaults to a minute.
+	Interval time.Duration
+
+	// Called periodically while the new tree is built, and once more when
+	// it is done. ProgressInterval defaults to a second.
+	OnProgress       func(CompactProgress)
+	ProgressInterval time.Duration
+
+	// Called with the shape of the tree before and after every
+	// compaction. The shape after is that of the tree as it was built,
+	// before the changes made meanwhile were replayed onto it.
+	OnCompact func(before, after IndexStats)
+}
+
+// A Compacter is a Store which can rebuild its index while it goes on
+// serving queries and changes. Stores returned by IndexStore
+// implement it, and CachedStore and LoggedStore pass it on.
+type Compacter interface {
+	// Compact rebuilds the index, if enough of its tree is empty. It
+	// returns the error of ctx if ctx is done before it finishes, and
+	// leaves the index as it was.
+	Compact(ctx context.Context, opts *CompactOptions) error
+}
+
+// CompactStore compacts s, if it is a Compacter. Other stores need no
+// compaction, and yield nil.
+func CompactStore(ctx context.Context, s Store, opts *CompactOptions) error {
+	if c, ok := s.(Compacter); ok {
+		return c.Compact(ctx, opts)
+	}
+	return nil
+}
+
+// ScheduleCompaction compacts s as needed, checking it every
+// opts.Interval, until ctx is done. It returns the error of ctx. Stores
+// which are not Compacters need no compaction, and yield nil at once.
+//
+//	go imghash.ScheduleCompaction(ctx, store, &imghash.CompactOptions{
+//		Rate: 50000,
+//	})
+func ScheduleCompaction(ctx context.Context, s Store, opts *CompactOptions) error {
+	c, ok := s.(Compacter)
+	if !ok {
+		return nil
+	}
+
+	if opts == nil {
+		opts = &CompactOptions{}
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := c.Compact(ctx, opts); err != nil && ctx.Err() == nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Compact rebuilds the tree of the index, and returns its new shape.
+//
+// Remove leaves the node of a hash in the tree when its last ID goes,
+// as other nodes hang from it. An index with a lot of churn fills up
+// with these empty nodes, which queries go through all the same, and
+// its tree grows lopsided, as hashes added later go deeper. Compact
+// drops the empty nodes, and inserts the others in an order which
+// spreads them evenly over the tree. The sorted children Warm sets up
+// are set up for the new tree as well.
+//
+// Snapshots taken before keep the old tree. Like Add, it must not be
+// called concurrently with other calls on the index; stores returned by
+// IndexStore compact theirs without blocking queries for long.
+func (x *Index) Compact() IndexStats {
+	x.load()
+
+	t, _ := x.rebuild(context.Background(), &CompactOptions{})
+	x.bkTree = t
+
+	s := IndexStats{Entries: len(x.ids)}
+	x.warm(&s)
+	return s
+}
+
+// rebuild returns a new tree holding the IDs of t, without its empty
+// nodes. The nodes are inserted in an order given by their scrambled
+// hashes, so the new tree does not depend on the order in which the
+// hashes were added. The tree is only read, so it may be shared with
+// an index which goes on changing.
+func (t *bkTree[K]) rebuild(ctx context.Context, o *CompactOptions) (bkTree[K], error) {
+	var nodes []*bkNode[K]
+
+	var walk func(*bkNode[K])
+	walk = func(n *bkNode[K]) {
+		if len(n.ids) > 0 {
+			nodes = append(nodes, n)
+		}
+
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+
+	if t.root != nil {
+		walk(t.root)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return mix64(nodes[i].hash) < mix64(nodes[j].hash) })
+
+	interval := o.ProgressInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	start := time.Now()
+	next := start
+	report := start.Add(interval)
+
+	p := CompactProgress{Nodes: len(nodes)}
+	nt := bkTree[K]{gen: atomic.AddUint64(&indexGen, 1)}
+
+	for _, n := range nodes {
+		if p.Done%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return bkTree[K]{}, err
+			}
+		}
+
+		if o.Rate > 0 {
+			next = next.Add(time.Second / time.Duration(o.Rate))
+			if d := time.Until(next); d > 0 {
+				time.Sleep(d)
+			}
+		}
+
+		for _, id := range n.ids {
+			nt.insert(id, n.hash)
+		}
+
+		p.Done++
+
+		if o.OnProgress != nil && time.Now().After(report) {
+			p.Elapsed = time.Since(start)
+			o.OnProgress(p)
+			report = report.Add(interval)
+		}
+	}
+
+	if o.OnProgress != nil {
+		p.Elapsed = time.Since(start)
+		o.OnProgress(p)
+	}
+
+	return nt, nil
+}
+
+// Compact rebuilds the index from a snapshot, without holding the lock,
+// and replays the changes made in the meantime onto the new tree before
+// it takes the place of the old one. Queries are only held up for the
+// replay.
+func (s *indexStore) Compact(ctx context.Context, opts *CompactOptions) error {
+	if opts == nil {
+		opts = &CompactOptions{}
+	}
+
+	min := opts.MinEmpty
+	if min == 0 {
+		min = defaultMinEmpty
+	}
+
+	var before IndexStats
+	s.mu.RLock()
+	s.x.survey(&before, false)
+	s.mu.RUnlock()
+
+	if before.Nodes == 0 || float64(before.Empty) < min*float64(before.Nodes) {
+		return nil
+	}
+
+	snap := s.Snapshot()
+	before.Entries = snap.Len()
+
+	t, err := snap.rebuild(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	after := IndexStats{Entries: snap.Len()}
+	t.warm(&after)
+
+	s.mu.Lock()
+	for id, hash := range snap.ids {
+		if h, ok := s.x.ids[id]; !ok || h != hash {
+			t.delete(id, hash)
+		}
+	}
+
+	for id, hash := range s.x.ids {
+		if h, ok := snap.ids[id]; !ok || h != hash {
+			t.insert(id, hash)
+		}
+	}
+
+	s.x.bkTree = t
+	after.Entries = len(s.x.ids)
+	s.mu.Unlock()
+
+	if opts.OnCompact != nil {
+		opts.OnCompact(before, after)
+	}
+
+	return nil
+}
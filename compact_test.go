@@ -0,0 +1,140 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// churn returns an index which had most of its entries removed, and a
+// fresh index holding the same entries.
+func churn(rng *rand.Rand) (x, fresh *Index) {
+	x, fresh = NewIndex(), NewIndex()
+	base := rng.Uint64()
+
+	for i := 0; i < 2000; i++ {
+		hash := base ^ rng.Uint64()&rng.Uint64()&rng.Uint64()
+		x.Add(fmt.Sprint(i), hash)
+
+		if i%4 == 0 {
+			fresh.Add(fmt.Sprint(i), hash)
+		} else {
+			x.Remove(fmt.Sprint(i))
+		}
+	}
+
+	return x, fresh
+}
+
+func TestIndexCompact(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x, fresh := churn(rng)
+	x.SetMeta("0", map[string]string{"a": "b"})
+	fresh.SetMeta("0", map[string]string{"a": "b"})
+
+	snap := x.Snapshot()
+
+	if s := x.Warm(); s.Empty < s.Nodes/2 {
+		t.Fatalf("before: stats %+v", s)
+	}
+
+	s := x.Compact()
+	if s.Empty != 0 || s.Entries != 500 || s.Nodes != fresh.Warm().Nodes {
+		t.Fatalf("after: stats %+v", s)
+	}
+
+	if err := x.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		q := rng.Uint64()
+		if a, b := x.Search(q, 20), fresh.Search(q, 20); !reflect.DeepEqual(a, b) {
+			t.Fatalf("%d hits, want %d", len(a), len(b))
+		}
+	}
+
+	// The snapshot keeps the old tree.
+	if s := snap.Warm(); s.Empty == 0 || s.Entries != 500 {
+		t.Fatalf("snapshot: stats %+v", s)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	x, fresh := churn(rng)
+	store := IndexStore(x).(*indexStore)
+	ctx := context.Background()
+
+	// Not enough of the tree is empty.
+	var compacted bool
+	opts := &CompactOptions{
+		MinEmpty:  0.99,
+		OnCompact: func(before, after IndexStats) { compacted = true },
+	}
+
+	if err := store.Compact(ctx, opts); err != nil || compacted {
+		t.Fatalf("compacted at %v: %v", opts.MinEmpty, err)
+	}
+
+	// A cancelled compaction leaves the index as it was.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	opts.MinEmpty = 0
+	if err := store.Compact(cancelled, opts); err != context.Canceled || compacted {
+		t.Fatalf("cancelled: %v", err)
+	}
+
+	// Changes made during a compaction are kept.
+	var progress []CompactProgress
+	opts.OnProgress = func(p CompactProgress) {
+		if len(progress) == 0 {
+			store.Add(ctx, "new", 1)
+			store.Add(ctx, "4", 2)
+			store.Remove(ctx, "8")
+		}
+		progress = append(progress, p)
+	}
+	opts.ProgressInterval = -1
+
+	fresh.Add("new", 1)
+	fresh.Add("4", 2)
+	fresh.Remove("8")
+
+	var before, after IndexStats
+	opts.OnCompact = func(b, a IndexStats) { before, after = b, a }
+
+	if err := store.Compact(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	last := progress[len(progress)-1]
+	if last.Done != last.Nodes || last.Nodes == 0 {
+		t.Fatalf("progress %+v", last)
+	}
+
+	if before.Empty == 0 || after.Empty != 0 || after.Entries != 500 {
+		t.Fatalf("stats %+v, then %+v", before, after)
+	}
+
+	if err := x.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		q := rng.Uint64()
+		if a, b := x.Search(q, 20), fresh.Search(q, 20); !reflect.DeepEqual(a, b) {
+			t.Fatalf("%d hits, want %d", len(a), len(b))
+		}
+	}
+
+	if err := ScheduleCompaction(cancelled, store, nil); err != context.Canceled {
+		t.Fatalf("schedule: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package compat
+
+import (
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// BlockhashThresholds holds the recommended thresholds for Blockhash
+// hashes.
+var BlockhashThresholds = imghash.Thresholds{Duplicate: 3, NearDuplicate: 9}
+
+func init() {
+	imghash.Register("blockhash", func() *imghash.Algorithm {
+		return &imghash.Algorithm{Hash: Blockhash, Thresholds: BlockhashThresholds}
+	})
+}
+
+// Size of the grid of blocks, for hashes of 64 bits.
+const blockhashSize = 8
+
+// Blockhash computes the hash of blockhash.io with 8 bits per side, for
+// hashes of 64 bits: bmvbhash, or blockhash in its Python version. The
+// image is divided into 8x8 blocks, which sum the red, green and blue
+// values of their pixels. Fully transparent pixels count as white, in
+// images with an alpha channel. Where block boundaries fall within a
+// pixel, it is divided over the blocks it covers.
+//
+// Bits are set for blocks brighter than the median of their band of two
+// rows. The top left block is the most significant bit, as in the
+// hexadecimal form of blockhash.
+func Blockhash(img image.Image) uint64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+
+	// The reference loads images as RGB, or as RGBA if they have an
+	// alpha channel.
+	alpha := true
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model, color.YCbCrModel, color.CMYKModel:
+		alpha = false
+	default:
+		if _, ok := img.(*image.Paletted); ok {
+			alpha = false
+		}
+	}
+
+	value := func(x, y int) int {
+		c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+		if alpha && c.A == 0 {
+			return 765
+		}
+		return int(c.R) + int(c.G) + int(c.B)
+	}
+
+	var blocks [blockhashSize * blockhashSize]float64
+
+	if w%blockhashSize == 0 && h%blockhashSize == 0 {
+		bw, bh := w/blockhashSize, h/blockhashSize
+		for i := range blocks {
+			var sum int
+			for y := 0; y < bh; y++ {
+				for x := 0; x < bw; x++ {
+					sum += value(i%blockhashSize*bw+x, i/blockhashSize*bh+y)
+				}
+			}
+			blocks[i] = float64(sum)
+		}
+
+		return blockhashBits(&blocks, float64(bw*bh))
+	}
+
+	bw := float64(w) / blockhashSize
+	bh := float64(h) / blockhashSize
+
+	// The pixels at x and y go to blocks lo and hi, with weights wlo
+	// and 1-wlo, as the reference computes them.
+	split := func(v, n int, size float64) (lo, hi int, wlo, whi float64) {
+		if n%blockhashSize == 0 {
+			lo, _ = pyDivmod(float64(v), size)
+			return lo, lo, 1, 0
+		}
+
+		_, m := pyDivmod(float64(v+1), size)
+		whole, frac := math.Modf(m)
+		lo, _ = pyDivmod(float64(v), size)
+		hi = lo
+
+		if whole <= 0 && v+1 != n {
+			// Math.ceil, as -(-v // size).
+			hi, _ = pyDivmod(float64(-v), size)
+			hi = -hi
+		}
+
+		return lo, hi, 1 - frac, frac
+	}
+
+	for y := 0; y < h; y++ {
+		top, bottom, wtop, wbottom := split(y, h, bh)
+
+		for x := 0; x < w; x++ {
+			left, right, wleft, wright := split(x, w, bw)
+			v := float64(value(x, y))
+
+			blocks[top*blockhashSize+left] += v * wtop * wleft
+			blocks[top*blockhashSize+right] += v * wtop * wright
+			blocks[bottom*blockhashSize+left] += v * wbottom * wleft
+			blocks[bottom*blockhashSize+right] += v * wbottom * wright
+		}
+	}
+
+	return blockhashBits(&blocks, bw*bh)
+}
+
+// blockhashBits returns the bits for the block values, as the
+// reference's translate_blocks_to_bits sets them.
+func blockhashBits(blocks *[blockhashSize * blockhashSize]float64, pixels float64) uint64 {
+	const bands = 4
+	const band = len(blocks) / bands
+
+	half := pixels * 256 * 3 / 2
+
+	var hash uint64
+	for i := 0; i < bands; i++ {
+		var sorted [band]float64
+		copy(sorted[:], blocks[i*band:])
+		sort.Float64s(sorted[:])
+		median := (sorted[band/2-1] + sorted[band/2]) / 2
+
+		// Images dominated by black or white have many blocks equal
+		// to the median. These are set if it is in the upper half of
+		// the values, so such images do not hash to all zeroes.
+		for _, v := range blocks[i*band : (i+1)*band] {
+			hash <<= 1
+			if v > median || math.Abs(v-median) < 1 && median > half {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// pyDivmod returns Python's floor division and modulo of two floats,
+// rounding as Python does.
+func pyDivmod(x, y float64) (int, float64) {
+	mod := math.Mod(x, y)
+	div := (x - mod) / y
+
+	if mod != 0 && (y < 0) != (mod < 0) {
+		mod += y
+		div--
+	}
+
+	floor := math.Floor(div)
+	if div-floor > 0.5 {
+		floor++
+	}
+
+	return int(floor), mod
+}
@@ -0,0 +1,68 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package compat
+
+import (
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// halves paints the left half of the image black, and the right half
+// white.
+func halves(m draw.Image) image.Image {
+	b := m.Bounds()
+	draw.Draw(m, b, image.Black, image.Point{}, draw.Src)
+	draw.Draw(m, image.Rect(b.Min.X+b.Dx()/2, b.Min.Y, b.Max.X, b.Max.Y), image.White, image.Point{}, draw.Src)
+	return m
+}
+
+func TestBlockhash(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		img  image.Image
+		want uint64
+	}{
+		// Sizes which divide into blocks evenly, and those which do not.
+		{"16x16", halves(image.NewGray(image.Rect(0, 0, 16, 16))), 0x0f0f0f0f0f0f0f0f},
+		{"12x12", halves(image.NewGray(image.Rect(0, 0, 12, 12))), 0x0f0f0f0f0f0f0f0f},
+		{"12x16", halves(image.NewRGBA(image.Rect(0, 0, 12, 16))), 0x0f0f0f0f0f0f0f0f},
+		{"16x13", halves(image.NewRGBA(image.Rect(0, 0, 16, 13))), 0x0f0f0f0f0f0f0f0f},
+
+		// Blocks equal to the median are set if it is bright, and
+		// transparent pixels are white.
+		{"black", image.NewGray(image.Rect(0, 0, 16, 16)), 0},
+		{"white", fill(image.NewGray(image.Rect(0, 0, 16, 16)), color.White), ^uint64(0)},
+		{"transparent", image.NewNRGBA(image.Rect(0, 0, 16, 16)), ^uint64(0)},
+		{"empty", image.NewGray(image.Rect(0, 0, 0, 0)), 0},
+	} {
+		if h := Blockhash(tt.img); h != tt.want {
+			t.Errorf("%s: %016x, want %016x", tt.name, h, tt.want)
+		}
+	}
+
+	a, err := imghash.LookupAlgorithm("blockhash")
+	if err != nil || a.Thresholds != BlockhashThresholds {
+		t.Fatalf("registered algorithm %v, %v", a, err)
+	}
+}
+
+func TestPyDivmod(t *testing.T) {
+	for _, tt := range []struct {
+		x, y float64
+		div  int
+		mod  float64
+	}{
+		{7, 2.5, 2, 2},
+		{-7, 2.5, -3, 0.5},
+		{6, 1.5, 4, 0},
+		{1, 0.1, 9, 0.09999999999999995}, // Not 10, as 1/0.1 rounds to.
+	} {
+		if div, mod := pyDivmod(tt.x, tt.y); div != tt.div || mod != tt.mod {
+			t.Errorf("divmod(%v, %v) = %d, %v; want %d, %v", tt.x, tt.y, div, mod, tt.div, tt.mod)
+		}
+	}
+}
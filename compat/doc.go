@@ -0,0 +1,44 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package compat computes the hashes of other perceptual hashing libraries,
+bit for bit, so hashes stored by programs using them can be searched and
+compared with this package:
+
+	hash, err := imghash.ComputeFile("photo.png", compat.PHash)
+
+PHash is the DCT hash of libpHash, ph_dct_imagehash. ImageHashAverage,
+ImageHashPHash and ImageHashDHash are average_hash, phash and dhash of
+the ImageHash Python library, which scale images with Pillow. Blockhash
+is the 64-bit hash of blockhash.io.
+
+Importing the package registers the hashers with imghash, under the
+names of the libraries, so programs which look algorithms up by name,
+like configuration files and indexes do, can use them as well:
+
+	a, err := imghash.LookupAlgorithm("libphash")
+
+The others are registered as imagehash-average, imagehash-phash,
+imagehash-dhash and blockhash.
+
+Compatibility holds for identical pixels. The libraries decode images
+with libpng and libjpeg, where this package uses the decoders of the Go
+standard library. These agree on PNG and GIF, but JPEG decoders round
+their colour conversions differently, which can flip the odd bit. Images
+are used as 8-bit channels, as the libraries load them.
+
+The transparent colour of GIF images is black here, since the Go
+decoder keeps no colour for it, where the libraries use the colour in
+the palette. Pillow loads 16-bit grayscale images as integers, which it
+clips to 255 when it converts them to 8 bits; the ImageHash functions
+do the same. blockhash.io does not load 16-bit grayscale images at all,
+so Blockhash takes their upper 8 bits.
+
+The compatibility promise for libpHash is held up by a parity suite
+which links libpHash itself. See the libphash package. The other modes
+follow the sources of ImageHash 4, Pillow 10 and blockhash-python, but
+have not been checked against them. The fixtures package holds vectors
+for all modes, so their output does not change unnoticed.
+*/
+package compat
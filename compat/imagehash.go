@@ -0,0 +1,318 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package compat
+
+import (
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// ImageHashThresholds holds the recommended thresholds for the hashes of
+// the ImageHash functions.
+var ImageHashThresholds = imghash.Thresholds{Duplicate: 3, NearDuplicate: 9}
+
+func init() {
+	for name, hf := range map[string]imghash.HashFunc{
+		"imagehash-average": ImageHashAverage,
+		"imagehash-phash":   ImageHashPHash,
+		"imagehash-dhash":   ImageHashDHash,
+	} {
+		hf := hf
+		imghash.Register(name, func() *imghash.Algorithm {
+			return &imghash.Algorithm{Hash: hf, Thresholds: ImageHashThresholds}
+		})
+	}
+}
+
+// ImageHashAverage computes the hash of average_hash in the ImageHash
+// Python library, with its default size of 8. The image is converted to
+// grayscale and scaled to 8x8 as Pillow does, and bits are set for the
+// pixels above their mean. The top left pixel is the most significant
+// bit, as in the hexadecimal form of ImageHash.
+func ImageHashAverage(img image.Image) uint64 {
+	pix := pillowGray(img, 8, 8)
+	if pix == nil {
+		return 0
+	}
+
+	var sum float64
+	for _, p := range pix {
+		sum += float64(p)
+	}
+
+	mean := sum / float64(len(pix))
+
+	var hash uint64
+	for _, p := range pix {
+		hash <<= 1
+		if float64(p) > mean {
+			hash |= 1
+		}
+	}
+
+	return hash
+}
+
+// ImageHashDHash computes the hash of dhash in the ImageHash Python
+// library, with its default size of 8. The image is converted to
+// grayscale and scaled to 9x8 as Pillow does, and bits are set for the
+// pixels brighter than the one to their left, row by row, the first
+// being the most significant bit.
+func ImageHashDHash(img image.Image) uint64 {
+	pix := pillowGray(img, 9, 8)
+	if pix == nil {
+		return 0
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if pix[y*9+x+1] > pix[y*9+x] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// ImageHashPHash computes the hash of phash in the ImageHash Python
+// library, with its default size of 8. The image is converted to
+// grayscale and scaled to 32x32 as Pillow does. Bits are set for the
+// 8x8 lowest frequencies of its DCT, constant ones included, which
+// exceed their median, row by row, the first being the most significant
+// bit.
+//
+// This is not the hash of libpHash, which PHash computes.
+func ImageHashPHash(img image.Image) uint64 {
+	const size = 32
+
+	pix := pillowGray(img, size, size)
+	if pix == nil {
+		return 0
+	}
+
+	// The unnormalized DCT-II of scipy.fftpack, along the columns
+	// and then along the rows, of the lowest frequencies only.
+	var basis [8][size]float64
+	for k := range basis {
+		for n := range basis[k] {
+			basis[k][n] = 2 * math.Cos(math.Pi*float64(k)*float64(2*n+1)/(2*size))
+		}
+	}
+
+	var cols [8][size]float64
+	for k := range cols {
+		for x := 0; x < size; x++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += basis[k][y] * float64(pix[y*size+x])
+			}
+			cols[k][x] = sum
+		}
+	}
+
+	var coeffs [64]float64
+	for k := 0; k < 8; k++ {
+		for l := 0; l < 8; l++ {
+			var sum float64
+			for x := 0; x < size; x++ {
+				sum += basis[l][x] * cols[k][x]
+			}
+			coeffs[k*8+l] = sum
+		}
+	}
+
+	sorted := coeffs
+	sort.Float64s(sorted[:])
+	median := (sorted[31] + sorted[32]) / 2
+
+	var hash uint64
+	for _, c := range coeffs {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+
+	return hash
+}
+
+// pillowGray returns the pixels of the image, converted to grayscale
+// and scaled to w by h, as Pillow's convert("L") and resize with the
+// Lanczos filter do. It returns nil if the image is empty.
+//
+// Colours are reduced to luma with Pillow's weights of 0.299, 0.587 and
+// 0.114, ignoring transparency. Pillow loads 16-bit grayscale images
+// as 32-bit integers, which convert("L") clips to 255, rather than
+// scaling them, so this does too.
+func pillowGray(img image.Image, w, h int) []uint8 {
+	b := img.Bounds()
+	iw, ih := b.Dx(), b.Dy()
+	if iw <= 0 || ih <= 0 {
+		return nil
+	}
+
+	gray := make([]uint8, iw*ih)
+	model := img.ColorModel()
+
+	for y := 0; y < ih; y++ {
+		for x := 0; x < iw; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+
+			var v uint8
+			switch model {
+			case color.GrayModel:
+				v = color.GrayModel.Convert(c).(color.Gray).Y
+			case color.Gray16Model:
+				v = 255
+				if g := color.Gray16Model.Convert(c).(color.Gray16).Y; g < 255 {
+					v = uint8(g)
+				}
+			default:
+				p := color.NRGBAModel.Convert(c).(color.NRGBA)
+				v = uint8((19595*uint32(p.R) + 38470*uint32(p.G) + 7471*uint32(p.B) + 0x8000) >> 16)
+			}
+
+			gray[y*iw+x] = v
+		}
+	}
+
+	return pillowResize(gray, iw, ih, w, h)
+}
+
+// Pillow resamples 8-bit images with coefficients in fixed point, with
+// this many fractional bits.
+const pillowPrecision = 32 - 8 - 2
+
+// pillowResize scales the iw by ih gray pixels to w by h, as Pillow's
+// resize with the Lanczos filter does: the rows first, keeping only the
+// ones the columns need, then the columns, with coefficients and sums
+// in fixed point, rounded to 8 bits after each pass.
+func pillowResize(pix []uint8, iw, ih, w, h int) []uint8 {
+	boundsX, kx := pillowCoeffs(iw, w)
+	boundsY, ky := pillowCoeffs(ih, h)
+
+	if w != iw {
+		first := boundsY[0]
+		last := boundsY[2*h-2] + boundsY[2*h-1]
+		for i := 0; i < h; i++ {
+			boundsY[2*i] -= first
+		}
+
+		tmp := make([]uint8, w*(last-first))
+		for y := 0; y < last-first; y++ {
+			row := pix[(y+first)*iw:]
+			for x := 0; x < w; x++ {
+				tmp[y*w+x] = pillowSample(row, 1, boundsX[2*x], boundsX[2*x+1], kx[x])
+			}
+		}
+
+		pix, iw = tmp, w
+	}
+
+	if h == ih {
+		return pix
+	}
+
+	out := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out[y*w+x] = pillowSample(pix[x:], iw, boundsY[2*y], boundsY[2*y+1], ky[y])
+		}
+	}
+
+	return out
+}
+
+// pillowSample returns the n pixels from min on, stride apart, weighted
+// by k, rounded and clipped to 8 bits.
+func pillowSample(pix []uint8, stride, min, n int, k []int32) uint8 {
+	sum := int32(1 << (pillowPrecision - 1))
+	for i := 0; i < n; i++ {
+		sum += int32(pix[(min+i)*stride]) * k[i]
+	}
+
+	switch {
+	case sum >= 1<<pillowPrecision<<8:
+		return 255
+	case sum <= 0:
+		return 0
+	}
+
+	return uint8(sum >> pillowPrecision)
+}
+
+// pillowCoeffs returns the first pixel and number of pixels for each of
+// the out pixels scaled from in, as pairs, and their weights in fixed
+// point, as Pillow's precompute_coeffs and normalize_coeffs_8bpc compute
+// them for the Lanczos filter.
+func pillowCoeffs(in, out int) ([]int, [][]int32) {
+	const support = 3 // Of the Lanczos filter.
+
+	scale := float64(in) / float64(out)
+	filterScale := math.Max(scale, 1)
+	window := support * filterScale
+
+	bounds := make([]int, 2*out)
+	k := make([][]int32, out)
+
+	for i := 0; i < out; i++ {
+		center := (float64(i) + 0.5) * scale
+
+		min := int(center - window + 0.5)
+		if min < 0 {
+			min = 0
+		}
+
+		max := int(center + window + 0.5)
+		if max > in {
+			max = in
+		}
+
+		w := make([]float64, max-min)
+		var sum float64
+		for x := range w {
+			w[x] = lanczos((float64(x+min) - center + 0.5) * (1 / filterScale))
+			sum += w[x]
+		}
+
+		k[i] = make([]int32, len(w))
+		for x := range w {
+			if sum != 0 {
+				w[x] /= sum
+			}
+
+			if w[x] < 0 {
+				k[i][x] = int32(-0.5 + w[x]*(1<<pillowPrecision))
+			} else {
+				k[i][x] = int32(0.5 + w[x]*(1<<pillowPrecision))
+			}
+		}
+
+		bounds[2*i], bounds[2*i+1] = min, len(w)
+	}
+
+	return bounds, k
+}
+
+// lanczos is Pillow's Lanczos filter, with a support of 3.
+func lanczos(x float64) float64 {
+	if x < -3 || x >= 3 {
+		return 0
+	}
+	return sinc(x) * sinc(x/3)
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
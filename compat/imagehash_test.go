@@ -0,0 +1,133 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package compat
+
+import (
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/fixtures"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPillowResize(t *testing.T) {
+	// Halving two pixels takes their mean, rounding halves up.
+	for _, tt := range []struct {
+		in   []uint8
+		want uint8
+	}{
+		{[]uint8{10, 21}, 16},
+		{[]uint8{10, 20}, 15},
+	} {
+		if out := pillowResize(tt.in, 2, 1, 1, 1); out[0] != tt.want {
+			t.Errorf("%v: %d, want %d", tt.in, out[0], tt.want)
+		}
+	}
+
+	// Flat images stay flat, scaled up or down.
+	for _, size := range []image.Point{{37, 23}, {5, 3}, {8, 8}, {1, 1}} {
+		in := make([]uint8, size.X*size.Y)
+		for i := range in {
+			in[i] = 200
+		}
+
+		for _, out := range [][2]int{{8, 8}, {9, 8}, {32, 32}} {
+			for i, v := range pillowResize(in, size.X, size.Y, out[0], out[1]) {
+				if v != 200 {
+					t.Fatalf("%v to %v: pixel %d is %d", size, out, i, v)
+				}
+			}
+		}
+	}
+
+	// The weights of each pixel add up to one, in fixed point.
+	for _, tt := range [][2]int{{100, 8}, {33, 32}, {7, 9}} {
+		bounds, k := pillowCoeffs(tt[0], tt[1])
+		for i := range k {
+			var sum int32
+			for _, w := range k[i] {
+				sum += w
+			}
+
+			if sum < 1<<pillowPrecision-4 || sum > 1<<pillowPrecision+4 {
+				t.Errorf("%d to %d: weights of %d add up to %d", tt[0], tt[1], i, sum)
+			}
+
+			if bounds[2*i] < 0 || bounds[2*i]+bounds[2*i+1] > tt[0] {
+				t.Errorf("%d to %d: pixel %d reads %v", tt[0], tt[1], i, bounds[2*i:2*i+2])
+			}
+		}
+	}
+}
+
+func TestPillowGray(t *testing.T) {
+	rect := image.Rect(0, 0, 8, 8)
+	for _, tt := range []struct {
+		img  image.Image
+		want uint8
+	}{
+		{fill(image.NewGray(rect), color.Gray{200}), 200},
+		{fill(image.NewGray16(rect), color.Gray16{0x00c8}), 200},
+		{fill(image.NewGray16(rect), color.Gray16{0x80ff}), 255},
+		{fill(image.NewRGBA(rect), color.RGBA{255, 0, 0, 255}), 76},
+		{fill(image.NewRGBA(rect), color.White), 255},
+	} {
+		if pix := pillowGray(tt.img, 8, 8); pix[0] != tt.want || pix[63] != tt.want {
+			t.Errorf("%v: %d, %d; want %d", tt.img.At(0, 0), pix[0], pix[63], tt.want)
+		}
+	}
+
+	if pillowGray(image.NewGray(image.Rect(0, 0, 0, 10)), 8, 8) != nil {
+		t.Fatal("empty image")
+	}
+}
+
+func TestImageHash(t *testing.T) {
+	// Images of the size hashed are used as they are.
+	ramp := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range ramp.Pix {
+		ramp.Pix[i] = uint8(i)
+	}
+
+	if h := ImageHashAverage(ramp); h != 0x00000000ffffffff {
+		t.Fatalf("average %016x", h)
+	}
+
+	rows := image.NewGray(image.Rect(0, 0, 9, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			// Rising in the top half, falling in the bottom.
+			v := x
+			if y >= 4 {
+				v = 9 - x
+			}
+			rows.SetGray(x, y, color.Gray{uint8(10 * v)})
+		}
+	}
+
+	if h := ImageHashDHash(rows); h != 0xffffffff00000000 {
+		t.Fatalf("dhash %016x", h)
+	}
+
+	// Scaled copies hash the same.
+	gopher := fixtures.Image("gopher.jpg")
+	for _, hf := range []imghash.HashFunc{ImageHashAverage, ImageHashPHash, ImageHashDHash} {
+		if d := imghash.Distance(hf(gopher), hf(fixtures.Image("gopher_large.png"))); d > 0 {
+			t.Errorf("gopher_large.png: distance %d", d)
+		}
+	}
+
+	for _, name := range []string{"imagehash-average", "imagehash-phash", "imagehash-dhash"} {
+		a, err := imghash.LookupAlgorithm(name)
+		if err != nil || a.Thresholds != ImageHashThresholds {
+			t.Fatalf("%s: %v, %v", name, a, err)
+		}
+	}
+
+	for _, hf := range []imghash.HashFunc{ImageHashAverage, ImageHashPHash, ImageHashDHash} {
+		if h := hf(image.NewGray(image.Rect(0, 0, 0, 0))); h != 0 {
+			t.Fatalf("empty image %016x", h)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package libphash binds ph_dct_imagehash of libpHash, to test compat.PHash
+against. Its tests hash a corpus of images with both, and fail on any
+difference, so changes to either the preprocessing or the transform of
+compat.PHash which break compatibility are caught:
+
+	go test -tags phash ./compat/libphash
+
+The binding is only included when building with the phash tag, as it
+needs cgo, a C++ compiler and libpHash itself. Without the tag, Hash
+fails with ErrUnavailable, and there are no tests.
+*/
+package libphash
+
+import "errors"
+
+// ErrUnavailable is returned by Hash if the package was built without
+// the phash tag.
+var ErrUnavailable = errors.New("libphash: built without the phash tag")
@@ -0,0 +1,19 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build phash
+
+// pHash.h pulls in all of CImg, which is only needed to build libpHash
+// itself. The one function called is declared here instead, with the
+// C++ linkage and types it has in the library.
+
+typedef unsigned long long ulong64;
+
+int ph_dct_imagehash(const char *file, ulong64 &hash);
+
+extern "C" int imghash_dct_imagehash(const char *file, unsigned long long *hash) {
+	ulong64 h = 0;
+	int err = ph_dct_imagehash(file, h);
+	*hash = h;
+	return err;
+}
@@ -0,0 +1,33 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build phash
+
+package libphash
+
+/*
+#cgo LDFLAGS: -lpHash -lpthread
+
+#include <stdlib.h>
+
+int imghash_dct_imagehash(const char *file, unsigned long long *hash);
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Hash returns the hash ph_dct_imagehash computes for the given file.
+func Hash(file string) (uint64, error) {
+	cs := C.CString(file)
+	defer C.free(unsafe.Pointer(cs))
+
+	var hash C.ulonglong
+	if C.imghash_dct_imagehash(cs, &hash) != 0 {
+		return 0, fmt.Errorf("libphash: can not hash %s", file)
+	}
+
+	return uint64(hash), nil
+}
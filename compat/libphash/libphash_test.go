@@ -0,0 +1,114 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build phash
+
+package libphash
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imghash/compat"
+	"github.com/jteeuwen/imghash/fixtures"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// corpus returns the images to compare the hashes of, by name: the
+// fixtures, and generated images of sizes which divide 32 unevenly, as
+// well as evenly, and of sizes below 32.
+func corpus() map[string]image.Image {
+	images := make(map[string]image.Image)
+	for _, name := range fixtures.Names() {
+		images[name] = fixtures.Image(name)
+	}
+
+	sizes := []image.Point{{64, 64}, {333, 250}, {1024, 768}, {17, 45}, {7, 7}}
+	for i, img := range synth.Corpus(30, sizes, 1) {
+		images[fmt.Sprintf("synth%02d", i)] = img
+	}
+
+	return images
+}
+
+// eightBit returns the image with 8-bit channels, and without
+// transparency, as a gray or RGB image. libpHash loads images with
+// more bits, and with an alpha channel, differently from compat.PHash,
+// which ignores them both.
+func eightBit(img image.Image) image.Image {
+	b := img.Bounds()
+
+	if m := img.ColorModel(); m == color.GrayModel || m == color.Gray16Model {
+		gray := image.NewGray(b)
+		draw.Draw(gray, b, img, b.Min, draw.Src)
+		return gray
+	}
+
+	rgb := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			c.A = 255
+			rgb.SetNRGBA(x, y, c)
+		}
+	}
+
+	return rgb
+}
+
+func TestParity(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, img := range corpus() {
+		// Flat images leave nothing but rounding errors in the
+		// coefficients, which the median then splits at random.
+		if isFlat(img) {
+			continue
+		}
+
+		img = eightBit(img)
+
+		// PNG is lossless, so both see the same pixels.
+		file := filepath.Join(dir, name+".png")
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = png.Encode(f, img)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := Hash(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hash := compat.PHash(img); hash != want {
+			t.Errorf("%s: hash %016x, libpHash %016x", name, hash, want)
+		}
+	}
+}
+
+// isFlat returns true if all pixels of the image are the same.
+func isFlat(img image.Image) bool {
+	b := img.Bounds()
+	r0, g0, b0, a0 := img.At(b.Min.X, b.Min.Y).RGBA()
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, g, b, a := img.At(x, y).RGBA(); r != r0 || g != g0 || b != b0 || a != a0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
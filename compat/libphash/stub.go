@@ -0,0 +1,9 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build !phash
+
+package libphash
+
+// Hash fails with ErrUnavailable.
+func Hash(file string) (uint64, error) { return 0, ErrUnavailable }
@@ -0,0 +1,148 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package compat
+
+import (
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// PHashThresholds holds the recommended thresholds for PHash hashes.
+var PHashThresholds = imghash.Thresholds{Duplicate: 4, NearDuplicate: 12}
+
+func init() {
+	imghash.Register("libphash", func() *imghash.Algorithm {
+		return &imghash.Algorithm{Hash: PHash, Thresholds: PHashThresholds}
+	})
+}
+
+// Size of the image libpHash takes the DCT of.
+const phashSize = 32
+
+// phashDCT is the DCT matrix of ph_dct_matrix, in single precision.
+// Rows are frequencies; the first row is never used, so it is left out.
+var phashDCT = func() (m [phashSize][phashSize]float32) {
+	c1 := float64(float32(math.Sqrt(2.0 / phashSize)))
+	for y := 1; y < phashSize; y++ {
+		for x := 0; x < phashSize; x++ {
+			m[y][x] = float32(c1 * math.Cos(math.Pi/2/phashSize*float64(y)*float64(2*x+1)))
+		}
+	}
+	return
+}()
+
+// PHash computes the hash of libpHash's ph_dct_imagehash. The image is
+// reduced to its luma, as CImg's RGBtoYCbCr computes it, or taken as is
+// if it is grayscale. It is blurred with a 7x7 box filter, and scaled
+// to 32x32 by taking the nearest pixels. Bits are set for the 8x8 lowest
+// frequencies of its DCT, without the constant ones, which exceed their
+// median. The first frequency is the least significant bit.
+//
+// Like libpHash, it ignores transparency, and works in single precision.
+func PHash(img image.Image) uint64 {
+	var in [phashSize][phashSize]float32
+	if !phashInput(img, &in) {
+		return 0
+	}
+
+	// The DCT is C * in * C', computed as CImg multiplies matrices:
+	// products in single precision, summed in double, and the
+	// result rounded to single precision.
+	var tmp, dct [phashSize][phashSize]float32
+	for y := 1; y <= 8; y++ {
+		for x := 0; x < phashSize; x++ {
+			var sum float64
+			for k := 0; k < phashSize; k++ {
+				sum += float64(phashDCT[y][k] * in[k][x])
+			}
+			tmp[y][x] = float32(sum)
+		}
+	}
+
+	for y := 1; y <= 8; y++ {
+		for x := 1; x <= 8; x++ {
+			var sum float64
+			for k := 0; k < phashSize; k++ {
+				sum += float64(tmp[y][k] * phashDCT[x][k])
+			}
+			dct[y][x] = float32(sum)
+		}
+	}
+
+	var coeffs [64]float32
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			coeffs[y*8+x] = dct[y+1][x+1]
+		}
+	}
+
+	sorted := coeffs
+	sort.Slice(sorted[:], func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := (sorted[31] + sorted[32]) / 2
+
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return hash
+}
+
+// phashInput fills in with the blurred and scaled luma of img. It
+// returns false if the image is empty.
+//
+// The blur sums the 7x7 pixels around each one, repeating the pixels
+// at the edges; scaling to 32x32 then takes the sums of the pixels at
+// x*w/32, y*h/32. Only those are computed.
+func phashInput(img image.Image, in *[phashSize][phashSize]float32) bool {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return false
+	}
+
+	gray := img.ColorModel() == color.GrayModel || img.ColorModel() == color.Gray16Model
+	luma := func(x, y int) int {
+		c := img.At(b.Min.X+x, b.Min.Y+y)
+		if gray {
+			return int(color.GrayModel.Convert(c).(color.Gray).Y)
+		}
+
+		p := color.NRGBAModel.Convert(c).(color.NRGBA)
+		return (66*int(p.R)+129*int(p.G)+25*int(p.B)+128)>>8 + 16
+	}
+
+	clamp := func(v, n int) int {
+		switch {
+		case v < 0:
+			return 0
+		case v >= n:
+			return n - 1
+		}
+		return v
+	}
+
+	for y := 0; y < phashSize; y++ {
+		sy := y * h / phashSize
+		for x := 0; x < phashSize; x++ {
+			sx := x * w / phashSize
+
+			var sum int
+			for dy := -3; dy <= 3; dy++ {
+				for dx := -3; dx <= 3; dx++ {
+					sum += luma(clamp(sx+dx, w), clamp(sy+dy, h))
+				}
+			}
+
+			in[y][x] = float32(sum)
+		}
+	}
+
+	return true
+}
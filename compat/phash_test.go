@@ -0,0 +1,113 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package compat
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/fixtures"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestPHashInput(t *testing.T) {
+	var in [phashSize][phashSize]float32
+
+	// Gray images are used as is, and colour ones by their luma.
+	// Transparency is ignored.
+	transparent := &image.NRGBA{
+		Pix:    bytes.Repeat([]byte{255, 255, 255, 0}, 40*40),
+		Stride: 40 * 4,
+		Rect:   image.Rect(0, 0, 40, 40),
+	}
+
+	for _, tt := range []struct {
+		img  image.Image
+		want float32
+	}{
+		{fill(image.NewGray(transparent.Rect), color.Gray{200}), 49 * 200},
+		{fill(image.NewGray16(transparent.Rect), color.Gray16{0x80ff}), 49 * 0x80},
+		{fill(image.NewRGBA(transparent.Rect), color.RGBA{255, 0, 0, 255}), 49 * 82},
+		{fill(image.NewRGBA(transparent.Rect), color.White), 49 * 235},
+		{transparent, 49 * 235},
+	} {
+		if !phashInput(tt.img, &in) || in[0][0] != tt.want || in[31][31] != tt.want {
+			t.Errorf("%v: %v, %v; want %v", tt.img.At(0, 0), in[0][0], in[31][31], tt.want)
+		}
+	}
+
+	// The blur repeats the edges, and scaling takes every other
+	// pixel of an image twice the size.
+	ramp := image.NewGray(image.Rect(10, 20, 74, 84))
+	for y := ramp.Rect.Min.Y; y < ramp.Rect.Max.Y; y++ {
+		for x := ramp.Rect.Min.X; x < ramp.Rect.Max.X; x++ {
+			ramp.SetGray(x, y, color.Gray{uint8(x - 10)})
+		}
+	}
+
+	phashInput(ramp, &in)
+	for _, tt := range []struct {
+		x    int
+		want float32
+	}{
+		{0, 7 * (0 + 0 + 0 + 0 + 1 + 2 + 3)},
+		{1, 7 * (0 + 0 + 1 + 2 + 3 + 4 + 5)},
+		{10, 7 * 7 * 20},
+		{31, 7 * (59 + 60 + 61 + 62 + 63 + 63 + 63)},
+	} {
+		if in[5][tt.x] != tt.want {
+			t.Errorf("ramp at %d: %v, want %v", tt.x, in[5][tt.x], tt.want)
+		}
+	}
+
+	if phashInput(image.NewGray(image.Rect(0, 0, 0, 10)), &in) {
+		t.Fatal("empty image")
+	}
+}
+
+// fill fills the image with the given colour.
+func fill(m draw.Image, c color.Color) image.Image {
+	draw.Draw(m, m.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return m
+}
+
+func TestPHash(t *testing.T) {
+	gopher := PHash(fixtures.Image("gopher.jpg"))
+	if gopher != 0xe3278796da1c0be1 {
+		t.Fatalf("gopher %016x", gopher)
+	}
+
+	// Scaled copies stay close, other images do not.
+	for _, tt := range []struct {
+		name string
+		max  uint64
+		min  uint64
+	}{
+		{"gopher_large.png", 0, 0},
+		{"gopher_small.png", PHashThresholds.NearDuplicate, 0},
+		{"blocks.gif", 64, PHashThresholds.NearDuplicate + 1},
+	} {
+		d := imghash.Distance(gopher, PHash(fixtures.Image(tt.name)))
+		if d > tt.max || d < tt.min {
+			t.Errorf("%s: distance %d", tt.name, d)
+		}
+	}
+
+	// Images which only change along one axis have no frequencies
+	// in the other, which the hash leaves out.
+	if h := PHash(fixtures.Image("gradient")); h != 0 {
+		t.Fatalf("gradient %016x", h)
+	}
+
+	if h := PHash(image.NewGray(image.Rect(0, 0, 0, 0))); h != 0 {
+		t.Fatalf("empty image %016x", h)
+	}
+
+	a, err := imghash.LookupAlgorithm("libphash")
+	if err != nil || a.Hash(fixtures.Image("gopher.jpg")) != gopher || a.Thresholds != PHashThresholds {
+		t.Fatalf("registered algorithm %v, %v", a, err)
+	}
+}
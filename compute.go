@@ -0,0 +1,80 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// ComputeReader decodes the image in r and computes its hash
+// using the given HashFunc, or that of DefaultHasher if hf is nil.
+func ComputeReader(r io.Reader, hf HashFunc) (uint64, error) {
+	return ComputeContext(context.Background(), r, hf)
+}
+
+// ComputeContext is like ComputeReader, but traces the decoding and
+// hashing with the Tracer set with SetTracer, in spans under the one
+// in ctx. The image is decoded even if ctx is done.
+func ComputeContext(ctx context.Context, r io.Reader, hf HashFunc) (hash uint64, err error) {
+	start := time.Now()
+	m := currentMetrics()
+
+	ctx, end := StartSpan(ctx, SpanCompute)
+	defer func() { end(err) }()
+
+	acct := accountantOf(ctx)
+	audit := auditorOf(ctx)
+
+	_, endDecode := StartSpan(ctx, SpanDecode)
+	mem := acct.begin()
+	img, loss, err := decode(r, audit != nil)
+	acct.end(StageDecode, mem)
+	endDecode(err)
+
+	if err != nil {
+		m.DecodeFailed(err)
+		return 0, err
+	}
+
+	if audit != nil {
+		if err = audit.record(loss); err != nil {
+			return 0, err
+		}
+	}
+
+	if hf == nil {
+		hf = DefaultHasher().Hash
+	}
+
+	_, endHash := StartSpan(ctx, SpanHash)
+	mem = acct.begin()
+	hash = hf(img)
+	acct.end(StageHash, mem)
+	endHash(nil)
+
+	m.ImageHashed(time.Since(start))
+	return hash, nil
+}
+
+// ComputeFile decodes the image in the given file and computes its
+// hash using the given HashFunc, or that of DefaultHasher if hf is nil.
+func ComputeFile(file string, hf HashFunc) (uint64, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+
+	defer fd.Close()
+	return ComputeReader(fd, hf)
+}
+
+// ComputeBytes decodes the image in data and computes its hash
+// using the given HashFunc, or that of DefaultHasher if hf is nil.
+func ComputeBytes(data []byte, hf HashFunc) (uint64, error) {
+	return ComputeReader(bytes.NewReader(data), hf)
+}
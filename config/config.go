@@ -0,0 +1,680 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package config loads a hashing policy from a file: the algorithm, the
+filters run before hashing, the thresholds, and the settings of batch
+runs and indexes. The CLI and imghashd both read it, so the policy can
+be kept under version control, rather than in shell scripts:
+
+	# Hashing policy for the photo archive.
+	algorithm  = "average"
+	preprocess = ["composite white", "blur 1"]
+	version    = 2
+	workers    = 8
+
+	[thresholds]
+	duplicate      = 3
+	near_duplicate = 9
+
+	[index]
+	path     = "/srv/imghash/photos.idx"
+	snapshot = "/srv/imghash/photos.snap"
+
+The format is a subset of TOML: tables of keys, whose values are strings,
+integers or arrays of strings. Settings left out keep their defaults,
+and flags given on the command line override the file.
+
+The [policy] table declares the rules which decide whether two images
+are copies, as an imghash.Policy: the algorithms to compare them by,
+how many of them must agree, and metadata the images must share, or
+must not.
+
+	c, err := config.Load("policy.toml")
+	...
+	hf := c.HashFunc(imghash.Average)
+
+Programs which take all their hashes from the policy make it the
+default hasher when they start, which the imghash command does:
+
+	h, err := c.Hasher()
+	...
+	err = imghash.SetDefaultHasher(h)
+*/
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Config is a hashing policy. Zero values mean a setting is left to
+// its default.
+type Config struct {
+	// Name of the hashing algorithm: average, document, screenshot,
+	// a preset like balanced, or any other registered with
+	// imghash.Register.
+	Algorithm string
+
+	// Filters to run over images before hashing them, in order. Each
+	// is a name, optionally followed by an argument:
+	//
+	//	blur <radius>         Blur, by a radius in pixels.
+	//	stretch <clip>        Stretch, clipping a fraction of pixels.
+	//	deskew <degrees>      Deskew, by at most this many degrees.
+	//	composite <colour>    Composite, on white, black or #rrggbb.
+	//	padsquare <colour>    PadSquare, with white, black or #rrggbb.
+	//	channels <weights>    Channels, as red, green, blue or r,g,b.
+	//	equalize, binarize, centercrop, ignorealpha, upright, luma
+	Preprocess []string
+
+	// Version of the algorithm and filters, as recorded in the Hasher.
+	// Bump it whenever they change, so hashes from before are not
+	// compared with those from after. Defaults to 1.
+	Version int
+
+	// Thresholds to classify distances by, in place of those of the
+	// algorithm. Fields left at 0 keep the algorithm's.
+	Thresholds imghash.Thresholds
+
+	Workers int    // Number of concurrent workers, or requests for imghashd.
+	Readers int    // Number of files read at the same time.
+	Cache   string // Cache file for hashes.
+
+	Index struct {
+		Path     string // File holding the index.
+		Snapshot string // Snapshot file imghashd saves the index to.
+	}
+
+	Service struct {
+		// Algorithms clients of imghashd may ask for per request,
+		// besides Algorithm.
+		Algorithms []string
+	}
+
+	// Named filter chains clients of imghashd may ask for per request,
+	// in place of Preprocess. Each is a key of the profiles table:
+	//
+	//	[profiles]
+	//	scans = ["deskew 5", "binarize"]
+	Profiles map[string][]string
+
+	// Rules which decide whether two images are copies, for
+	// imghash.Policy:
+	//
+	//	[policy]
+	//	rules  = ["average", "document 2 6"]
+	//	agree  = 2
+	//	differ = ["uploader"]
+	Policy struct {
+		Rules  []string // Algorithm, optionally followed by the duplicate and near-duplicate thresholds.
+		Agree  int      // Number of rules which must agree.
+		Same   []string // Metadata keys the images must have the same value for.
+		Differ []string // Metadata keys the images must have different values for.
+	}
+
+	filters  []imghash.Filter
+	profiles map[string][]imghash.Filter
+	policy   *imghash.Policy
+}
+
+// Load reads a Config from the given file.
+func Load(file string) (*Config, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	c, err := Read(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return c, nil
+}
+
+// Read reads a Config from r. Unknown keys and invalid filters are
+// errors, so typing mistakes do not go unnoticed.
+func Read(r io.Reader) (*Config, error) {
+	c := new(Config)
+
+	// Destination of each key, by table.
+	keys := map[string]interface{}{
+		"algorithm":                 &c.Algorithm,
+		"preprocess":                &c.Preprocess,
+		"version":                   &c.Version,
+		"workers":                   &c.Workers,
+		"readers":                   &c.Readers,
+		"cache":                     &c.Cache,
+		"thresholds.duplicate":      &c.Thresholds.Duplicate,
+		"thresholds.near_duplicate": &c.Thresholds.NearDuplicate,
+		"index.path":                &c.Index.Path,
+		"index.snapshot":            &c.Index.Snapshot,
+		"service.algorithms":        &c.Service.Algorithms,
+		"policy.rules":              &c.Policy.Rules,
+		"policy.agree":              &c.Policy.Agree,
+		"policy.same":               &c.Policy.Same,
+		"policy.differ":             &c.Policy.Differ,
+	}
+
+	tables := map[string]bool{"": true, "thresholds": true, "index": true, "service": true, "profiles": true, "policy": true}
+
+	var table, pending string
+	var line, start int
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		text := stripComment(scanner.Text())
+
+		// Arrays may span several lines.
+		if len(pending) > 0 {
+			pending += " " + text
+			if !closed(pending) {
+				continue
+			}
+
+			text, pending = pending, ""
+		}
+
+		if len(text) == 0 {
+			continue
+		}
+
+		if text[0] == '[' {
+			if len(text) < 3 || text[len(text)-1] != ']' || !tables[strings.TrimSpace(text[1:len(text)-1])] {
+				return nil, fmt.Errorf("config: line %d: unknown table %s", line, text)
+			}
+
+			table = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("config: line %d: expected key = value", line)
+		}
+
+		key, value := strings.TrimSpace(text[:eq]), strings.TrimSpace(text[eq+1:])
+		if strings.HasPrefix(value, "[") && !closed(value) {
+			pending, start = text, line
+			continue
+		}
+
+		if start == 0 {
+			start = line
+		}
+
+		if len(table) > 0 {
+			key = table + "." + key
+		}
+
+		dst, ok := keys[key]
+		if table == "profiles" {
+			name := strings.TrimPrefix(key, "profiles.")
+			if c.Profiles == nil {
+				c.Profiles = make(map[string][]string)
+			}
+
+			var chain []string
+			if err := decode(&chain, value); err != nil {
+				return nil, fmt.Errorf("config: line %d: %s: %v", start, key, err)
+			}
+
+			c.Profiles[name] = chain
+			start = 0
+			continue
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("config: line %d: unknown key %q", start, key)
+		}
+
+		if err := decode(dst, value); err != nil {
+			return nil, fmt.Errorf("config: line %d: %s: %v", start, key, err)
+		}
+
+		start = 0
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("config: line %d: unterminated array", start)
+	}
+
+	for _, spec := range c.Preprocess {
+		f, err := ParseFilter(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		c.filters = append(c.filters, f)
+	}
+
+	for name, chain := range c.Profiles {
+		filters := []imghash.Filter{}
+		for _, spec := range chain {
+			f, err := ParseFilter(spec)
+			if err != nil {
+				return nil, err
+			}
+
+			filters = append(filters, f)
+		}
+
+		if c.profiles == nil {
+			c.profiles = make(map[string][]imghash.Filter)
+		}
+		c.profiles[name] = filters
+	}
+
+	if len(c.Policy.Rules) > 0 {
+		p := &imghash.Policy{Agree: c.Policy.Agree}
+		for _, spec := range c.Policy.Rules {
+			r, err := ParseRule(spec)
+			if err != nil {
+				return nil, err
+			}
+
+			p.Rules = append(p.Rules, r)
+		}
+
+		for _, key := range c.Policy.Same {
+			p.Constraints = append(p.Constraints, imghash.Constraint{Key: key, Same: true})
+		}
+
+		for _, key := range c.Policy.Differ {
+			p.Constraints = append(p.Constraints, imghash.Constraint{Key: key})
+		}
+
+		c.policy = p
+	}
+
+	return c, nil
+}
+
+// MatchPolicy returns the policy of the [policy] table, or nil if it
+// has no rules.
+func (c *Config) MatchPolicy() *imghash.Policy {
+	return c.policy
+}
+
+// ParseRule returns the rule for an entry of the rules of a policy: the
+// name of a registered algorithm, optionally followed by the duplicate
+// and near-duplicate thresholds to use for it.
+func ParseRule(spec string) (imghash.PolicyRule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 1 && len(fields) != 3 {
+		return imghash.PolicyRule{}, fmt.Errorf("config: invalid rule %q", spec)
+	}
+
+	if _, err := imghash.LookupAlgorithm(fields[0]); err != nil {
+		return imghash.PolicyRule{}, fmt.Errorf("config: rule %q: %v", spec, err)
+	}
+
+	r := imghash.PolicyRule{Algorithm: strings.ToLower(fields[0])}
+	if len(fields) == 1 {
+		return r, nil
+	}
+
+	for i, dst := range []*uint64{&r.Thresholds.Duplicate, &r.Thresholds.NearDuplicate} {
+		n, err := strconv.ParseUint(fields[1+i], 10, 64)
+		if err != nil || n > 64 {
+			return imghash.PolicyRule{}, fmt.Errorf("config: invalid threshold %q in rule %q", fields[1+i], spec)
+		}
+		*dst = n
+	}
+
+	return r, nil
+}
+
+// Hasher returns the hasher of the policy: its algorithm, or average if
+// it has none, run after the filters of the Preprocess chain, with its
+// thresholds applied. It returns an error wrapping
+// imghash.ErrUnknownAlgorithm if the algorithm is not registered.
+func (c *Config) Hasher() (*imghash.Hasher, error) {
+	name := c.Algorithm
+	if len(name) == 0 {
+		name = imghash.StandardHasher().Algorithm
+	}
+
+	a, err := imghash.LookupAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+
+	version := c.Version
+	if version == 0 {
+		version = 1
+	}
+
+	return &imghash.Hasher{
+		Algorithm:  strings.ToLower(name),
+		Profile:    strings.Join(c.Preprocess, ", "),
+		Version:    version,
+		Hash:       c.HashFunc(a.Hash),
+		Thresholds: c.ApplyThresholds(a.Thresholds),
+	}, nil
+}
+
+// HashFunc returns hf, run after the filters of the Preprocess chain.
+func (c *Config) HashFunc(hf imghash.HashFunc) imghash.HashFunc {
+	if len(c.filters) == 0 {
+		return hf
+	}
+
+	return imghash.Preprocess(hf, c.filters...)
+}
+
+// ProfileHashFunc returns hf, run after the filters of the named profile,
+// and false if there is no such profile.
+func (c *Config) ProfileHashFunc(name string, hf imghash.HashFunc) (imghash.HashFunc, bool) {
+	filters, ok := c.profiles[name]
+	if !ok {
+		return nil, false
+	}
+
+	if len(filters) == 0 {
+		return hf, true
+	}
+
+	return imghash.Preprocess(hf, filters...), true
+}
+
+// ApplyThresholds returns t, with the fields set in c.Thresholds in
+// place of its own.
+func (c *Config) ApplyThresholds(t imghash.Thresholds) imghash.Thresholds {
+	if c.Thresholds.Duplicate > 0 {
+		t.Duplicate = c.Thresholds.Duplicate
+	}
+
+	if c.Thresholds.NearDuplicate > 0 {
+		t.NearDuplicate = c.Thresholds.NearDuplicate
+	}
+
+	return t
+}
+
+// ParseFilter returns the filter for an entry of a Preprocess chain.
+func ParseFilter(spec string) (imghash.Filter, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("config: invalid filter %q", spec)
+	}
+
+	name, arg := strings.ToLower(fields[0]), ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+
+	switch name {
+	case "equalize", "binarize", "centercrop", "ignorealpha", "upright", "luma":
+		if len(arg) > 0 {
+			return nil, fmt.Errorf("config: filter %s takes no argument", name)
+		}
+
+	case "blur", "stretch", "deskew", "composite", "padsquare", "channels":
+		if len(arg) == 0 {
+			return nil, fmt.Errorf("config: filter %s needs an argument", name)
+		}
+
+	default:
+		return nil, fmt.Errorf("config: unknown filter %q", name)
+	}
+
+	switch name {
+	case "equalize":
+		return imghash.Equalize, nil
+	case "binarize":
+		return imghash.Binarize, nil
+	case "centercrop":
+		return imghash.CenterCrop, nil
+	case "ignorealpha":
+		return imghash.IgnoreAlpha, nil
+	case "upright":
+		return imghash.Upright, nil
+	case "luma":
+		return imghash.Luma, nil
+
+	case "blur":
+		radius, err := strconv.Atoi(arg)
+		if err != nil || radius < 1 {
+			return nil, fmt.Errorf("config: invalid blur radius %q", arg)
+		}
+		return imghash.Blur(radius), nil
+
+	case "stretch", "deskew":
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("config: invalid %s argument %q", name, arg)
+		}
+
+		if name == "stretch" {
+			return imghash.Stretch(v), nil
+		}
+		return imghash.Deskew(v), nil
+
+	case "channels":
+		return parseChannels(arg)
+	}
+
+	c, err := parseColor(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "composite" {
+		return imghash.Composite(c), nil
+	}
+	return imghash.PadSquare(c), nil
+}
+
+// parseChannels parses red, green, blue, or the weights of all three as
+// r,g,b.
+func parseChannels(s string) (imghash.Filter, error) {
+	switch strings.ToLower(s) {
+	case "red":
+		return imghash.Channels(1, 0, 0), nil
+	case "green":
+		return imghash.Channels(0, 1, 0), nil
+	case "blue":
+		return imghash.Channels(0, 0, 1), nil
+	}
+
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("config: invalid channel weights %q", s)
+	}
+
+	var w [3]float64
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("config: invalid channel weights %q", s)
+		}
+		w[i] = v
+	}
+
+	return imghash.Channels(w[0], w[1], w[2]), nil
+}
+
+// parseColor parses white, black or a colour as #rrggbb.
+func parseColor(s string) (color.Color, error) {
+	switch strings.ToLower(s) {
+	case "white":
+		return color.White, nil
+	case "black":
+		return color.Black, nil
+	}
+
+	if len(s) == 7 && s[0] == '#' {
+		if v, err := strconv.ParseUint(s[1:], 16, 32); err == nil {
+			return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("config: invalid colour %q", s)
+}
+
+// stripComment removes a comment from the line, and the space around
+// what remains. A # inside a string does not start a comment.
+func stripComment(line string) string {
+	var quote byte
+	var i int
+
+	for i = 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return strings.TrimSpace(line[:i])
+		}
+	}
+
+	return strings.TrimSpace(line)
+}
+
+// closed returns whether all brackets in the text are closed. Brackets
+// inside strings do not count.
+func closed(text string) bool {
+	var quote byte
+	var depth, i int
+
+	for i = 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+
+	return depth <= 0
+}
+
+// decode parses a value into dst, which points to a field of a Config.
+func decode(dst interface{}, value string) error {
+	switch dst := dst.(type) {
+	case *string:
+		s, err := parseString(value)
+		if err != nil {
+			return err
+		}
+		*dst = s
+
+	case *int:
+		n, err := strconv.ParseInt(strings.Replace(value, "_", "", -1), 10, 0)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %s", value)
+		}
+		*dst = int(n)
+
+	case *uint64:
+		n, err := strconv.ParseUint(strings.Replace(value, "_", "", -1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %s", value)
+		}
+		*dst = n
+
+	case *[]string:
+		items, err := splitArray(value)
+		if err != nil {
+			return err
+		}
+
+		out := make([]string, len(items))
+		for i, item := range items {
+			if out[i], err = parseString(item); err != nil {
+				return err
+			}
+		}
+		*dst = out
+	}
+
+	return nil
+}
+
+// parseString parses a string, in double or single quotes.
+func parseString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+
+	if len(value) >= 2 && value[0] == '"' {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid string %s", value)
+}
+
+// splitArray splits an array into the text of its items. A trailing
+// comma is allowed.
+func splitArray(value string) ([]string, error) {
+	if len(value) < 2 || value[0] != '[' || value[len(value)-1] != ']' {
+		return nil, fmt.Errorf("invalid array %s", value)
+	}
+
+	var items []string
+	var quote byte
+	var i, from int
+
+	body := value[1 : len(value)-1]
+	for i = 0; i <= len(body); i++ {
+		if i < len(body) {
+			switch c := body[i]; {
+			case quote == '"' && c == '\\':
+				i++
+				continue
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c == '[' || c == ']':
+				return nil, fmt.Errorf("nested arrays are not supported")
+			case c != ',':
+				continue
+			}
+		}
+
+		if item := strings.TrimSpace(body[from:i]); len(item) > 0 {
+			items = append(items, item)
+		} else if i < len(body) {
+			return nil, fmt.Errorf("invalid array %s", value)
+		}
+
+		from = i + 1
+	}
+
+	return items, nil
+}
@@ -0,0 +1,170 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/synth"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	c, err := Read(strings.NewReader(`
+# Hashing policy for the photo archive.
+algorithm  = "screenshot"
+preprocess = [
+	"composite #ffffff",  # Transparent icons.
+	'blur 1',
+]
+workers = 8
+cache   = "/var/cache/imghash # hashes"
+
+[thresholds]
+near_duplicate = 12
+
+[index]
+path = "photos.idx"
+
+[service]
+algorithms = ["average", "balanced"]
+
+[profiles]
+scans = ["luma", "blur 2"]
+none  = []
+
+[policy]
+rules  = ["Average", "document 2 6"]
+agree  = 1
+differ = ["uploader"]
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Algorithm != "screenshot" || c.Workers != 8 || c.Readers != 0 || c.Cache != "/var/cache/imghash # hashes" || c.Index.Path != "photos.idx" {
+		t.Fatalf("config %+v", c)
+	}
+
+	if len(c.Preprocess) != 2 || c.Preprocess[1] != "blur 1" {
+		t.Fatalf("preprocess %q", c.Preprocess)
+	}
+
+	if th := c.ApplyThresholds(imghash.AverageThresholds); th.Duplicate != 3 || th.NearDuplicate != 12 {
+		t.Fatalf("thresholds %+v", th)
+	}
+
+	img := synth.Shapes(64, 64, 1)
+	want := imghash.Preprocess(imghash.Average, imghash.Composite(color.White), imghash.Blur(1))
+	if h := c.HashFunc(imghash.Average)(img); h != want(img) {
+		t.Fatalf("hash %016x, want %016x", h, want(img))
+	}
+
+	if a := c.Service.Algorithms; len(a) != 2 || a[1] != "balanced" {
+		t.Fatalf("service algorithms %q", a)
+	}
+
+	want = imghash.Preprocess(imghash.Average, imghash.Luma, imghash.Blur(2))
+	if hf, ok := c.ProfileHashFunc("scans", imghash.Average); !ok || hf(img) != want(img) {
+		t.Fatalf("profile scans: %v", ok)
+	}
+
+	if hf, ok := c.ProfileHashFunc("none", imghash.Average); !ok || hf(img) != imghash.Average(img) {
+		t.Fatalf("profile none: %v", ok)
+	}
+
+	if _, ok := c.ProfileHashFunc("photos", imghash.Average); ok {
+		t.Fatalf("unknown profile found")
+	}
+
+	p := c.MatchPolicy()
+	if p == nil || len(p.Rules) != 2 || p.Agree != 1 || p.Rules[0].Algorithm != "average" {
+		t.Fatalf("policy %+v", p)
+	}
+
+	if th := p.Rules[1].Thresholds; th.Duplicate != 2 || th.NearDuplicate != 6 {
+		t.Fatalf("rule thresholds %+v", th)
+	}
+
+	if cs := p.Constraints; len(cs) != 1 || cs[0].Key != "uploader" || cs[0].Same {
+		t.Fatalf("constraints %+v", cs)
+	}
+}
+
+func TestHasher(t *testing.T) {
+	c, err := Read(strings.NewReader(`
+algorithm  = "Screenshot"
+preprocess = ["luma"]
+version    = 3
+
+[thresholds]
+duplicate = 1
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := c.Hasher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.String() != "screenshot@3" || h.Profile != "luma" {
+		t.Fatalf("hasher %s, profile %q", h, h.Profile)
+	}
+
+	a, _ := imghash.LookupAlgorithm("screenshot")
+	if th := h.Thresholds; th.Duplicate != 1 || th.NearDuplicate != a.Thresholds.NearDuplicate {
+		t.Fatalf("thresholds %+v", th)
+	}
+
+	img := synth.Shapes(64, 64, 2)
+	if want := imghash.Preprocess(a.Hash, imghash.Luma)(img); h.Hash(img) != want {
+		t.Fatalf("hash %016x, want %016x", h.Hash(img), want)
+	}
+
+	// An empty policy is the standard hasher.
+	h, err = new(Config).Hasher()
+	if err != nil || h.String() != imghash.StandardHasher().String() || h.Profile != "" {
+		t.Fatalf("empty: %v, %v", h, err)
+	}
+
+	c.Algorithm = "phash"
+	if _, err := c.Hasher(); !errors.Is(err, imghash.ErrUnknownAlgorithm) {
+		t.Fatalf("unknown algorithm: %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		text, err string
+	}{
+		{"algoritm = \"average\"", `line 1: unknown key "algoritm"`},
+		{"\n[index]\ncache = \"x\"", `line 3: unknown key "index.cache"`},
+		{"[server]", "line 1: unknown table [server]"},
+		{"workers = eight", "line 1: workers: invalid number eight"},
+		{"workers", "line 1: expected key = value"},
+		{"preprocess = [\"blur 1\",\n", "line 1: unterminated array"},
+		{"preprocess = [\"sharpen\"]", `unknown filter "sharpen"`},
+		{"preprocess = [\"blur\"]", "filter blur needs an argument"},
+		{"preprocess = [\"composite pink\"]", `invalid colour "pink"`},
+		{"preprocess = [\"channels 1,2\"]", `invalid channel weights "1,2"`},
+		{"[profiles]\nscans = [\"sharpen\"]", `unknown filter "sharpen"`},
+		{"[profiles]\nscans = \"luma\"", "line 2: profiles.scans: invalid array"},
+		{"[policy]\nrules = [\"phash\"]", `rule "phash": imghash: unknown algorithm "phash"`},
+		{"[policy]\nrules = [\"average 3\"]", `invalid rule "average 3"`},
+		{"[policy]\nrules = [\"average 3 x\"]", `invalid threshold "x"`},
+	}
+
+	for _, tt := range tests {
+		_, err := Read(strings.NewReader(tt.text))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%q: error %v, want %q", tt.text, err, tt.err)
+		}
+	}
+}
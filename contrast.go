@@ -0,0 +1,179 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+)
+
+// Equalize is a Filter which performs histogram equalization on the
+// luminance of the image. The result is a grayscale image whose pixel
+// values are spread evenly over the full range.
+//
+// Because equalization only depends on the order of pixel values, any
+// monotonic change to the tone curve -- gamma correction, contrast
+// and brightness adjustments -- is undone by it. Use it for hashers
+// which compare pixel values against each other, like Average.
+func Equalize(img image.Image) image.Image {
+	gray := luminance(img)
+	hist := histogram(gray)
+
+	// Turn the histogram into a cumulative distribution.
+	var total, min uint64
+	for i, n := range hist {
+		if n > 0 && min == 0 {
+			min = total + n
+		}
+
+		total += n
+		hist[i] = total
+	}
+
+	if total == min {
+		// A single value; nothing to equalize.
+		return gray
+	}
+
+	var v uint16
+	pix := gray.Pix
+
+	for i := 0; i < len(pix); i += 2 {
+		v = uint16(pix[i])<<8 | uint16(pix[i+1])
+		v = uint16((hist[v] - min) * 0xffff / (total - min))
+		pix[i] = uint8(v >> 8)
+		pix[i+1] = uint8(v)
+	}
+
+	return gray
+}
+
+// Stretch returns a Filter which linearly stretches the luminance of
+// the image to cover the full range. The darkest and brightest clip
+// fraction of pixels -- for example 0.01 for 1% -- are ignored when
+// determining the range, so a handful of outliers have no effect.
+//
+// Stretch is a gentler alternative to Equalize. It undoes linear
+// contrast and brightness changes, but not curve adjustments.
+func Stretch(clip float64) Filter {
+	if clip < 0 {
+		clip = 0
+	}
+
+	if clip > 0.5 {
+		clip = 0.5
+	}
+
+	return func(img image.Image) image.Image {
+		gray := luminance(img)
+		hist := histogram(gray)
+
+		var total uint64
+		for _, n := range hist {
+			total += n
+		}
+
+		// Find the range, skipping the clipped pixels on either end.
+		skip := uint64(clip * float64(total))
+		lo, hi := 0, len(hist)-1
+
+		for n := uint64(0); lo < hi; lo++ {
+			if n += hist[lo]; n > skip {
+				break
+			}
+		}
+
+		for n := uint64(0); hi > lo; hi-- {
+			if n += hist[hi]; n > skip {
+				break
+			}
+		}
+
+		if lo >= hi {
+			return gray
+		}
+
+		var v int
+		pix := gray.Pix
+
+		for i := 0; i < len(pix); i += 2 {
+			v = int(pix[i])<<8 | int(pix[i+1])
+
+			switch {
+			case v <= lo:
+				v = 0
+			case v >= hi:
+				v = 0xffff
+			default:
+				v = (v - lo) * 0xffff / (hi - lo)
+			}
+
+			pix[i] = uint8(v >> 8)
+			pix[i+1] = uint8(v)
+		}
+
+		return gray
+	}
+}
+
+// luminance returns a 16-bit grayscale copy of the image,
+// with its bounds moved to the origin.
+func luminance(img image.Image) *image.Gray16 {
+	img = copyable(plain(img))
+	rect := img.Bounds()
+	gray := image.NewGray16(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+
+	var x, y int
+
+	if m, ok := img.(*image.CMYK); ok && !generic.Load() {
+		return luminanceCMYK(m)
+	}
+
+	if p, ok := img.(*image.Paletted); ok && !generic.Load() {
+		// Convert each palette entry once. Indices outside
+		// the palette yield black.
+		var luma [256]uint16
+		for i, c := range p.Palette {
+			if i < len(luma) {
+				luma[i] = color.Gray16Model.Convert(c).(color.Gray16).Y
+			}
+		}
+
+		var i, o int
+		for y = rect.Min.Y; y < rect.Max.Y; y++ {
+			i = p.PixOffset(rect.Min.X, y)
+			o = gray.PixOffset(0, y-rect.Min.Y)
+
+			for x = rect.Min.X; x < rect.Max.X; x++ {
+				v := luma[p.Pix[i]]
+				gray.Pix[o] = uint8(v >> 8)
+				gray.Pix[o+1] = uint8(v)
+				i++
+				o += 2
+			}
+		}
+
+		return gray
+	}
+
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			gray.Set(x-rect.Min.X, y-rect.Min.Y, color.Gray16Model.Convert(img.At(x, y)))
+		}
+	}
+
+	return gray
+}
+
+// histogram counts the occurrences of each value in the image.
+func histogram(gray *image.Gray16) []uint64 {
+	hist := make([]uint64, 0x10000)
+	pix := gray.Pix
+
+	for i := 0; i < len(pix); i += 2 {
+		hist[uint16(pix[i])<<8|uint16(pix[i+1])]++
+	}
+
+	return hist
+}
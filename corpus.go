@@ -0,0 +1,540 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// ErrInvalidCorpus is returned when reading corpus files with invalid
+// contents.
+var ErrInvalidCorpus = errors.New("imghash: invalid corpus file")
+
+// ErrCorpusOrder is returned by CorpusWriter.Add for a hash below the
+// one added before it.
+var ErrCorpusOrder = errors.New("imghash: corpus hashes out of order")
+
+// corpusMagic identifies the corpus format.
+const corpusMagic = "IMGHCOR1"
+
+const (
+	corpusBlock = 4096 // Largest number of distinct hashes in a block.
+	riceEscape  = 32   // Quotient at which a gap is stored in full.
+)
+
+// A CorpusWriter writes a sorted corpus of hashes, compactly. Where a
+// list of hashes takes 8 bytes for each, a corpus stores the gaps
+// between successive hashes, which are far smaller than the hashes
+// themselves, and each repeated hash once, with a count. Gaps are Rice
+// coded, with the parameter fitted to every block of 4096 hashes:
+// distinct hashes spread evenly take about 66-log2(n) bits each for a
+// corpus of n hashes, 5 bytes at 100 million, and repeats next to
+// nothing. Collections with many exact copies shrink the most.
+//
+// The format starts with the magic string "IMGHCOR1", followed by the
+// blocks. Each block holds the number of distinct hashes in it, as an
+// unsigned varint, the Rice parameter k in a byte, and the length of
+// the bit stream, as an unsigned varint, before the stream itself. For
+// every distinct hash, the stream holds its gap to the hash before it,
+// or to 0 for the first: the gap shifted right by k in unary, as that
+// many 1 bits and a 0, then the low k bits of the gap. Quotients of 32
+// or more are written as 32 1 bits and the full gap in 64 bits. The
+// number of times the hash repeats follows, as an Elias gamma code.
+// Bits are written most significant first. A block of 0 hashes ends
+// the corpus.
+type CorpusWriter struct {
+	w     *bufio.Writer
+	enc   corpusEncoder
+	begun bool
+	last  uint64
+}
+
+// NewCorpusWriter returns a writer of a corpus to w. Call Close to
+// finish the corpus.
+func NewCorpusWriter(w io.Writer) *CorpusWriter {
+	bw := bufio.NewWriter(w)
+	return &CorpusWriter{w: bw, enc: corpusEncoder{w: bw}}
+}
+
+// Add adds a hash. Hashes must be added in increasing order, and fail
+// with ErrCorpusOrder otherwise. Repeats are allowed.
+func (c *CorpusWriter) Add(hash uint64) error {
+	if !c.begun {
+		if _, err := c.w.WriteString(corpusMagic); err != nil {
+			return err
+		}
+		c.begun = true
+	} else if hash < c.last {
+		return ErrCorpusOrder
+	}
+
+	c.last = hash
+	return c.enc.add(hash, 0)
+}
+
+// Close writes what remains of the corpus. It does not close the
+// underlying writer.
+func (c *CorpusWriter) Close() error {
+	if !c.begun {
+		if _, err := c.w.WriteString(corpusMagic); err != nil {
+			return err
+		}
+		c.begun = true
+	}
+
+	if err := c.enc.close(); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+// WriteCorpus writes the given hashes to w as a corpus, in order. It
+// sorts a copy of the hashes, and leaves them as they are.
+func WriteCorpus(w io.Writer, hashes []uint64) error {
+	sorted := make([]uint64, len(hashes))
+	copy(sorted, hashes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	c := NewCorpusWriter(w)
+	for _, h := range sorted {
+		if err := c.Add(h); err != nil {
+			return err
+		}
+	}
+
+	return c.Close()
+}
+
+// A CorpusReader reads a corpus written by a CorpusWriter, a block at
+// a time, so corpora of any size are read in constant memory.
+type CorpusReader struct {
+	dec   corpusDecoder
+	begun bool
+}
+
+// NewCorpusReader returns a reader of the corpus in r.
+func NewCorpusReader(r io.Reader) *CorpusReader {
+	return &CorpusReader{dec: corpusDecoder{r: bufio.NewReader(r)}}
+}
+
+// Next returns the next distinct hash of the corpus, in increasing
+// order, along with the number of times it was added. It returns io.EOF
+// at the end of the corpus, and ErrInvalidCorpus for invalid contents.
+func (c *CorpusReader) Next() (hash uint64, count int, err error) {
+	if !c.begun {
+		magic := make([]byte, len(corpusMagic))
+		if _, err = io.ReadFull(c.dec.r, magic); err != nil {
+			return
+		}
+
+		if string(magic) != corpusMagic {
+			err = ErrInvalidCorpus
+			return
+		}
+
+		c.begun = true
+	}
+
+	hash, n, err := c.dec.next()
+	return hash, int(n), err
+}
+
+// ScanCorpus reads the corpus in r, and calls fn for every distinct
+// hash within the given distance of query, with the number of times it
+// was added. An error from fn stops the scan and is returned. This is a
+// linear scan, as Database.Find does, reading the corpus as a stream.
+func ScanCorpus(r io.Reader, query, distance uint64, fn func(hash uint64, count int) error) error {
+	c := NewCorpusReader(r)
+
+	for {
+		hash, count, err := c.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if Distance(hash, query) <= distance {
+			if err := fn(hash, count); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// corpusEncoder writes the blocks of a corpus, as CorpusWriter
+// describes them. With ids set, every block is followed by the ID of
+// every key in it, as 4 big endian bytes, in the order they were added.
+type corpusEncoder struct {
+	w    io.Writer
+	ids  bool
+	prev uint64 // Last key of the block before.
+
+	keys, runs []uint64 // Distinct keys of the block, and their repeats.
+	id         []uint32
+
+	bits bitBuf
+	buf  []byte
+}
+
+// add adds a key, with its ID if the encoder stores them. Keys must be
+// added in increasing order.
+func (e *corpusEncoder) add(key uint64, id uint32) error {
+	if n := len(e.keys); n > 0 && e.keys[n-1] == key {
+		e.runs[n-1]++
+	} else {
+		if n == corpusBlock {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+
+		e.keys = append(e.keys, key)
+		e.runs = append(e.runs, 1)
+	}
+
+	if e.ids {
+		e.id = append(e.id, id)
+	}
+
+	return nil
+}
+
+// flush writes the keys added since the last block as a block.
+func (e *corpusEncoder) flush() error {
+	if len(e.keys) == 0 {
+		return nil
+	}
+
+	var k uint
+	if mean := (e.keys[len(e.keys)-1] - e.prev) / uint64(len(e.keys)); mean > 0 {
+		k = uint(bits.Len64(mean) - 1)
+	}
+
+	e.bits.reset()
+	prev := e.prev
+
+	for i, key := range e.keys {
+		gap := key - prev
+		if q := gap >> k; q >= riceEscape {
+			e.bits.ones(riceEscape)
+			e.bits.put(gap, 64)
+		} else {
+			e.bits.ones(uint(q))
+			e.bits.put(0, 1)
+			e.bits.put(gap, k)
+		}
+
+		e.bits.gamma(e.runs[i])
+		prev = key
+	}
+
+	stream := e.bits.bytes()
+
+	e.buf = binary.AppendUvarint(e.buf[:0], uint64(len(e.keys)))
+	e.buf = append(e.buf, byte(k))
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(stream)))
+	e.buf = append(e.buf, stream...)
+
+	for _, id := range e.id {
+		e.buf = binary.BigEndian.AppendUint32(e.buf, id)
+	}
+
+	if _, err := e.w.Write(e.buf); err != nil {
+		return err
+	}
+
+	e.prev = prev
+	e.keys, e.runs, e.id = e.keys[:0], e.runs[:0], e.id[:0]
+	return nil
+}
+
+// close writes the last block, and the end of the blocks.
+func (e *corpusEncoder) close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write([]byte{0})
+	return err
+}
+
+// corpusDecoder reads the blocks written by a corpusEncoder.
+type corpusDecoder struct {
+	r    *bufio.Reader
+	ids  bool
+	prev uint64
+	seen bool // Whether a key was read.
+	done bool
+
+	keys, runs []uint64
+	id         []uint32
+	stream     []byte
+
+	i    int    // Next distinct key.
+	j    int    // Next ID.
+	cur  uint64 // Key of the records returned by record.
+	left uint64 // Records of it still to return.
+}
+
+// next returns the next distinct key, and the number of times it
+// repeats. It returns io.EOF after the last.
+func (d *corpusDecoder) next() (key, count uint64, err error) {
+	for d.i == len(d.keys) {
+		if err = d.block(); err != nil {
+			return
+		}
+	}
+
+	key, count = d.keys[d.i], d.runs[d.i]
+	d.i++
+	return
+}
+
+// record returns the next key, once for every time it repeats, along
+// with its ID. It returns io.EOF after the last.
+func (d *corpusDecoder) record() (key uint64, id uint32, err error) {
+	if d.left == 0 {
+		if d.cur, d.left, err = d.next(); err != nil {
+			return
+		}
+	}
+
+	d.left--
+	id = d.id[d.j]
+	d.j++
+	return d.cur, id, nil
+}
+
+// block reads the next block.
+func (d *corpusDecoder) block() error {
+	if d.done {
+		return io.EOF
+	}
+
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return unexpected(err)
+	}
+
+	if n == 0 {
+		d.done = true
+		return io.EOF
+	}
+
+	if n > corpusBlock {
+		return ErrInvalidCorpus
+	}
+
+	k, err := d.r.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return unexpected(err)
+	}
+
+	// Every key takes at most the escape, 64 bits of gap and a gamma
+	// code of 127 bits.
+	if k > 63 || size > n*(riceEscape+64+127)/8+1 {
+		return ErrInvalidCorpus
+	}
+
+	if cap(d.stream) < int(size) {
+		d.stream = make([]byte, size)
+	}
+
+	d.stream = d.stream[:size]
+	if _, err = io.ReadFull(d.r, d.stream); err != nil {
+		return unexpected(err)
+	}
+
+	d.keys, d.runs = d.keys[:0], d.runs[:0]
+	d.i, d.j = 0, 0
+
+	src := bitSrc{b: d.stream}
+	var total uint64
+
+	for ; n > 0; n-- {
+		q, ok := src.unary(riceEscape)
+		if !ok {
+			return ErrInvalidCorpus
+		}
+
+		var gap uint64
+		if q == riceEscape {
+			gap, ok = src.get(64)
+		} else {
+			gap, ok = src.get(uint(k))
+			gap |= q << k
+		}
+
+		run, rok := src.gamma()
+		key := d.prev + gap
+
+		// Runs are returned as ints, which must not turn negative.
+		if !ok || !rok || key < d.prev || d.seen && gap == 0 || run > math.MaxInt32 {
+			return ErrInvalidCorpus
+		}
+
+		d.keys = append(d.keys, key)
+		d.runs = append(d.runs, run)
+		d.prev, d.seen = key, true
+		total += run
+	}
+
+	if !d.ids {
+		return nil
+	}
+
+	// IDs are 32 bits, so a block can not hold more of them.
+	if total > math.MaxInt32 {
+		return ErrInvalidCorpus
+	}
+
+	d.id = d.id[:0]
+	var buf [4]byte
+
+	for ; total > 0; total-- {
+		if _, err = io.ReadFull(d.r, buf[:]); err != nil {
+			return unexpected(err)
+		}
+		d.id = append(d.id, binary.BigEndian.Uint32(buf[:]))
+	}
+
+	return nil
+}
+
+// unexpected returns io.ErrUnexpectedEOF for io.EOF, and err otherwise.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A bitBuf collects bits, most significant first.
+type bitBuf struct {
+	b   []byte
+	acc uint64 // Pending bits, in the low n bits.
+	n   uint
+}
+
+func (w *bitBuf) reset() {
+	w.b, w.acc, w.n = w.b[:0], 0, 0
+}
+
+// put appends the low n bits of v.
+func (w *bitBuf) put(v uint64, n uint) {
+	if n > 32 {
+		w.put(v>>32, n-32)
+		v, n = v&0xffffffff, 32
+	}
+
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+
+	for w.n >= 8 {
+		w.n -= 8
+		w.b = append(w.b, byte(w.acc>>w.n))
+	}
+}
+
+// ones appends n 1 bits.
+func (w *bitBuf) ones(n uint) {
+	for ; n > 32; n -= 32 {
+		w.put(1<<32-1, 32)
+	}
+	w.put(1<<n-1, n)
+}
+
+// gamma appends the Elias gamma code of v, which must be at least 1.
+func (w *bitBuf) gamma(v uint64) {
+	n := uint(bits.Len64(v))
+	w.put(0, n-1)
+	w.put(v, n)
+}
+
+// bytes returns the bits, padded with 0 bits to a whole byte.
+func (w *bitBuf) bytes() []byte {
+	if w.n > 0 {
+		w.b = append(w.b, byte(w.acc<<(8-w.n)))
+		w.n = 0
+	}
+	return w.b
+}
+
+// A bitSrc reads the bits of a bitBuf.
+type bitSrc struct {
+	b   []byte
+	pos uint // Position, in bits.
+}
+
+// get reads n bits. It returns false past the end.
+func (r *bitSrc) get(n uint) (v uint64, ok bool) {
+	if r.pos+n > uint(len(r.b))*8 {
+		return 0, false
+	}
+
+	for n > 0 {
+		avail := 8 - r.pos%8
+		take := min(avail, n)
+		v = v<<take | uint64(r.b[r.pos/8]>>(avail-take))&(1<<take-1)
+		r.pos += take
+		n -= take
+	}
+
+	return v, true
+}
+
+// unary reads 1 bits up to a 0 bit, or up to limit of them, and returns
+// their number.
+func (r *bitSrc) unary(limit uint64) (uint64, bool) {
+	var q uint64
+	for q < limit {
+		b, ok := r.get(1)
+		if !ok {
+			return 0, false
+		}
+
+		if b == 0 {
+			break
+		}
+
+		q++
+	}
+
+	return q, true
+}
+
+// gamma reads an Elias gamma code.
+func (r *bitSrc) gamma() (uint64, bool) {
+	var zeros uint
+	for {
+		b, ok := r.get(1)
+		if !ok || zeros > 63 {
+			return 0, false
+		}
+
+		if b == 1 {
+			break
+		}
+
+		zeros++
+	}
+
+	v, ok := r.get(zeros)
+	return 1<<zeros | v, ok
+}
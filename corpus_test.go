@@ -0,0 +1,127 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCorpus(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Distinct hashes, hashes clustered close together, exact copies,
+	// and both ends of the range.
+	hashes := []uint64{0, 0, 1<<64 - 1}
+	for i := 0; i < 20000; i++ {
+		h := rng.Uint64()
+		hashes = append(hashes, h, h^1)
+		if i%10 == 0 {
+			hashes = append(hashes, h, h)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCorpus(&buf, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	// About 51 bits for each of the 40000 distinct hashes, and a few
+	// for each copy.
+	if size := buf.Len(); size > len(hashes)*6 {
+		t.Fatalf("%d bytes for %d hashes", size, len(hashes))
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	var got []uint64
+	r := NewCorpusReader(bytes.NewReader(buf.Bytes()))
+	for {
+		h, n, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for ; n > 0; n-- {
+			got = append(got, h)
+		}
+	}
+
+	if !reflect.DeepEqual(got, hashes) {
+		t.Fatalf("read %d hashes, want %d", len(got), len(hashes))
+	}
+
+	var near, want int
+	err := ScanCorpus(bytes.NewReader(buf.Bytes()), hashes[100], 1, func(h uint64, n int) error {
+		near += n
+		return nil
+	})
+
+	for _, h := range hashes {
+		if Distance(h, hashes[100]) <= 1 {
+			want++
+		}
+	}
+
+	if err != nil || near != want || want < 2 {
+		t.Fatalf("scanned %d near hashes, want %d: %v", near, want, err)
+	}
+
+	for _, n := range []int{len(corpusMagic) + 5, buf.Len() - 1} {
+		if err := ScanCorpus(bytes.NewReader(buf.Bytes()[:n]), 0, 0, func(uint64, int) error { return nil }); err == nil {
+			t.Fatalf("corpus cut at %d bytes read", n)
+		}
+	}
+
+	if err := ScanCorpus(bytes.NewReader([]byte("IMGHIDX1")), 0, 0, nil); err != ErrInvalidCorpus {
+		t.Fatalf("wrong magic: %v", err)
+	}
+
+	c := NewCorpusWriter(io.Discard)
+	if c.Add(2) != nil || c.Add(1) != ErrCorpusOrder {
+		t.Fatal("hashes out of order added")
+	}
+
+	// An empty corpus reads as such.
+	buf.Reset()
+	NewCorpusWriter(&buf).Close()
+	if _, _, err := NewCorpusReader(&buf).Next(); err != io.EOF {
+		t.Fatalf("empty corpus: %v", err)
+	}
+}
+
+func TestCorpusLongRuns(t *testing.T) {
+	// corruptBlock returns a block with the given keys and runs.
+	corruptBlock := func(keys, runs []uint64) []byte {
+		var buf bytes.Buffer
+		e := &corpusEncoder{w: &buf, keys: keys, runs: runs}
+		if err := e.flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		return append(buf.Bytes(), 0)
+	}
+
+	data := append([]byte(corpusMagic), corruptBlock([]uint64{5}, []uint64{1 << 40})...)
+	if _, n, err := NewCorpusReader(bytes.NewReader(data)).Next(); err != ErrInvalidCorpus {
+		t.Fatalf("run of 2^40: count %d, %v", n, err)
+	}
+
+	// Runs which fit on their own, but not together.
+	data = corruptBlock([]uint64{5, 6}, []uint64{math.MaxInt32, math.MaxInt32})
+	d := corpusDecoder{r: bufio.NewReader(bytes.NewReader(data)), ids: true}
+	if err := d.block(); err != ErrInvalidCorpus {
+		t.Fatalf("runs of 2^32 IDs: %v", err)
+	}
+}
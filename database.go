@@ -45,6 +45,13 @@ type Database struct {
 	entries []*Entry         // List of entries.
 	pathMap map[string]int   //(private) map of file paths to Entry index
 	hashMap map[uint64][]int //(private) map of file hashes to Entry indexes
+
+	// Backend compares hashes for Find. If nil, they are compared in Go.
+	Backend DistanceBackend
+
+	hashes []uint64        // Hash of every entry, by index.
+	goBack DistanceBackend // Backend used if Backend is nil.
+	loaded DistanceBackend // Backend holding the current hashes, if any.
 }
 
 // NewDatabase creates a new, empty database.
@@ -55,12 +62,16 @@ func NewDatabase() *Database {
 // Find finds all entries which have a Hamming Diance <= to the
 // specified distance with the given hash.
 // The list is sorted by relevance.
+//
+// The hashes are compared by the Backend. They are loaded into it on
+// the first query after the database changed. If the backend fails,
+// Find compares the hashes in Go instead.
 func (d *Database) Find(hash, distance uint64) ResultSet {
 	var rs ResultSet
 	var dist uint64
 
 	//shortcut the enumeration and do a hash lookup if the distance is zero.
-	if 0 == dist {
+	if 0 == distance {
 		for _, i := range d.hashMap[hash] {
 			rs = append(rs, &SearchResult{
 				Path:     d.entries[i].Path,
@@ -69,19 +80,18 @@ func (d *Database) Find(hash, distance uint64) ResultSet {
 			})
 		}
 	} else {
-		for _, e := range d.entries {
+		for _, i := range d.within(hash, distance) {
+			e := d.entries[i]
 			if nil == e {
 				continue
 			}
 			dist = Distance(e.Hash, hash)
 
-			if dist <= distance {
-				rs = append(rs, &SearchResult{
-					Path:     e.Path,
-					Hash:     e.Hash,
-					Distance: dist,
-				})
-			}
+			rs = append(rs, &SearchResult{
+				Path:     e.Path,
+				Hash:     e.Hash,
+				Distance: dist,
+			})
 		}
 	}
 
@@ -89,6 +99,33 @@ func (d *Database) Find(hash, distance uint64) ResultSet {
 	return rs
 }
 
+// within returns the indices of the hashes within distance of hash,
+// deleted entries included.
+func (d *Database) within(hash, distance uint64) []int {
+	b := d.Backend
+	if b == nil {
+		if d.goBack == nil {
+			d.goBack = NewGoBackend()
+		}
+		b = d.goBack
+	}
+
+	if d.loaded != b {
+		d.loaded = nil
+		if err := b.Load(d.hashes); err != nil {
+			return within(nil, d.hashes, 0, hash, distance)
+		}
+		d.loaded = b
+	}
+
+	idx, err := b.Within(hash, distance)
+	if err != nil {
+		return within(nil, d.hashes, 0, hash, distance)
+	}
+
+	return idx
+}
+
 // Load loads a database from the given file.
 // Leave the filename empty to use the default file.
 func (d *Database) Load(file string) (err error) {
@@ -152,12 +189,12 @@ func (d *Database) Load(file string) (err error) {
 
 		d.AddEntry(entry)
 	}
-
-	return
 }
 
 func (d *Database) AddEntry(entry *Entry) {
 	d.entries = append(d.entries, entry)
+	d.hashes = append(d.hashes, entry.Hash)
+	d.loaded = nil
 	newIndex := len(d.entries) - 1
 	d.pathMap[entry.Path] = newIndex
 	d.hashMap[entry.Hash] = append(d.hashMap[entry.Hash], newIndex)
@@ -169,16 +206,19 @@ func (d *Database) DeleteEntry(index int) {
 	entry := d.entries[index]
 	d.entries[index] = nil
 	delete(d.pathMap, entry.Path)
+	d.unmapHash(entry.Hash, index)
+}
 
+// unmapHash removes the entry index from the entries with hash.
+func (d *Database) unmapHash(hash uint64, index int) {
 	//there may be multiple entries with the same hash, so we rebuild the array
-	for i, e := range d.hashMap[entry.Hash] {
+	for i, e := range d.hashMap[hash] {
 		if e == index {
-			d.hashMap[entry.Hash][i] = d.hashMap[entry.Hash][len(d.hashMap[entry.Hash])-1]
-			d.hashMap[entry.Hash] = d.hashMap[entry.Hash][:len(d.hashMap[entry.Hash])-1]
+			d.hashMap[hash][i] = d.hashMap[hash][len(d.hashMap[hash])-1]
+			d.hashMap[hash] = d.hashMap[hash][:len(d.hashMap[hash])-1]
 			break
 		}
 	}
-
 }
 
 // Save saves the database to the given file.
@@ -218,7 +258,18 @@ func (d *Database) Set(file string, modtime int64, hash uint64) {
 
 	f := d.entries[index]
 	f.ModTime = modtime
-	f.Hash = hash
+
+	if f.Hash != hash {
+		d.unmapHash(f.Hash, index)
+		d.hashMap[hash] = append(d.hashMap[hash], index)
+
+		// The backend may hold on to the hashes until the next Load,
+		// so they are copied rather than changed in place.
+		d.hashes = append([]uint64(nil), d.hashes...)
+		d.hashes[index] = hash
+		d.loaded = nil
+		f.Hash = hash
+	}
 }
 
 // IsNew returns true if the given file has been updated
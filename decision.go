@@ -0,0 +1,193 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// DecisionOptions configure Decide.
+type DecisionOptions struct {
+	// Name of the algorithm the hashes were computed with, as registered
+	// with Register. Empty means "average".
+	Algorithm string
+
+	// Preprocessing the images went through before they were hashed, as
+	// a name or a list of filters. It is only recorded.
+	Profile string
+
+	// Thresholds to classify the distance by, in place of those of the
+	// algorithm. Fields left at 0 keep the algorithm's.
+	Thresholds Thresholds
+
+	// If set, the decision records which cells of the hashes differ.
+	Diff bool
+}
+
+// A Decision records whether two images were taken as copies of each
+// other, and on what grounds: the hashes, how they were computed, and
+// the thresholds their distance was held against. It is marshalled to
+// JSON with hashes in hexadecimal and verdicts by name, so moderation
+// decisions can be archived, and explained long after the thresholds
+// or algorithms in use have changed.
+type Decision struct {
+	A, B       uint64     // The hashes compared.
+	Algorithm  string     // Name of the algorithm they were computed with.
+	Profile    string     // Preprocessing the images went through.
+	Distance   uint64     // Hamming Distance between the hashes.
+	Thresholds Thresholds // Thresholds the distance was classified by.
+	Verdict    Verdict    // The verdict.
+
+	// Threshold is the one which decided the verdict: the duplicate
+	// threshold for duplicates, and the near-duplicate one otherwise.
+	Threshold uint64
+
+	// Diff shows the cells whose bits differ, for hashes of an 8x8 grid
+	// in RowMajor layout, if it was asked for. It holds 8 rows of 8
+	// characters; differing cells are an 'x', the others a '.'.
+	Diff []string
+}
+
+// decisionJSON is the JSON form of a Decision.
+type decisionJSON struct {
+	A          string   `json:"a"`
+	B          string   `json:"b"`
+	Algorithm  string   `json:"algorithm"`
+	Profile    string   `json:"profile,omitempty"`
+	Distance   uint64   `json:"distance"`
+	Duplicate  uint64   `json:"duplicate_threshold"`
+	Near       uint64   `json:"near_duplicate_threshold"`
+	Threshold  uint64   `json:"threshold"`
+	Verdict    Verdict  `json:"verdict"`
+	Similarity float64  `json:"similarity"`
+	Diff       []string `json:"diff,omitempty"`
+}
+
+// Decide compares two hashes, and returns the decision with its grounds.
+// Opts may be nil, to use the defaults. It returns an error wrapping
+// ErrUnknownAlgorithm if the algorithm is not registered.
+func Decide(a, b uint64, opts *DecisionOptions) (*Decision, error) {
+	var o DecisionOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Algorithm == "" {
+		o.Algorithm = "average"
+	}
+
+	algo, err := LookupAlgorithm(o.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	t := algo.Thresholds
+	if o.Thresholds.Duplicate > 0 {
+		t.Duplicate = o.Thresholds.Duplicate
+	}
+	if o.Thresholds.NearDuplicate > 0 {
+		t.NearDuplicate = o.Thresholds.NearDuplicate
+	}
+
+	return decide(a, b, o.Algorithm, o.Profile, t, o.Diff), nil
+}
+
+// decide returns the decision for two hashes.
+func decide(a, b uint64, algorithm, profile string, t Thresholds, diff bool) *Decision {
+	d := &Decision{
+		A:          a,
+		B:          b,
+		Algorithm:  algorithm,
+		Profile:    profile,
+		Distance:   Distance(a, b),
+		Thresholds: t,
+	}
+
+	d.Verdict = t.Classify(d.Distance)
+	d.Threshold = t.NearDuplicate
+	if d.Verdict == Duplicate {
+		d.Threshold = t.Duplicate
+	}
+
+	if diff {
+		d.Diff = diffGrid(a ^ b)
+	}
+
+	return d
+}
+
+// diffGrid returns the rows of the grid of differing bits.
+func diffGrid(mask uint64) []string {
+	rows := make([]string, 8)
+	var row [8]byte
+
+	for y := range rows {
+		for x := range row {
+			row[x] = '.'
+			if mask>>RowMajor.bit(x, y)&1 != 0 {
+				row[x] = 'x'
+			}
+		}
+		rows[y] = string(row[:])
+	}
+
+	return rows
+}
+
+// Similarity returns the similarity of the hashes, as Similarity does.
+func (d *Decision) Similarity() float64 {
+	return 1 - float64(d.Distance)/64
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d *Decision) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&decisionJSON{
+		A:          FormatHash(d.A),
+		B:          FormatHash(d.B),
+		Algorithm:  d.Algorithm,
+		Profile:    d.Profile,
+		Distance:   d.Distance,
+		Duplicate:  d.Thresholds.Duplicate,
+		Near:       d.Thresholds.NearDuplicate,
+		Threshold:  d.Threshold,
+		Verdict:    d.Verdict,
+		Similarity: d.Similarity(),
+		Diff:       d.Diff,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The similarity is not read
+// back; it follows from the distance.
+func (d *Decision) UnmarshalJSON(data []byte) error {
+	var v decisionJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	a, err := strconv.ParseUint(v.A, 16, 64)
+	if err != nil {
+		return fmt.Errorf("imghash: invalid hash %q", v.A)
+	}
+
+	b, err := strconv.ParseUint(v.B, 16, 64)
+	if err != nil {
+		return fmt.Errorf("imghash: invalid hash %q", v.B)
+	}
+
+	*d = Decision{
+		A:          a,
+		B:          b,
+		Algorithm:  v.Algorithm,
+		Profile:    v.Profile,
+		Distance:   v.Distance,
+		Thresholds: Thresholds{v.Duplicate, v.Near},
+		Verdict:    v.Verdict,
+		Threshold:  v.Threshold,
+		Diff:       v.Diff,
+	}
+
+	return nil
+}
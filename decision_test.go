@@ -0,0 +1,63 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	a := uint64(0x0838787c7c3e3c18)
+	b := a ^ 1 ^ 1<<9 ^ 1<<63
+
+	d, err := Decide(a, b, &DecisionOptions{Profile: "scans", Thresholds: Thresholds{Duplicate: 2}, Diff: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Distance != 3 || d.Verdict != NearDuplicate || d.Threshold != 9 || d.Thresholds != (Thresholds{2, 9}) || d.Algorithm != "average" {
+		t.Fatalf("decision %+v", d)
+	}
+
+	want := []string{"x.......", ".x......", "........", "........", "........", "........", "........", ".......x"}
+	if !reflect.DeepEqual(d.Diff, want) {
+		t.Fatalf("diff %q", d.Diff)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{`"a":"0838787c7c3e3c18"`, `"verdict":"near-duplicate"`, `"threshold":9`, `"profile":"scans"`} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("JSON lacks %s: %s", s, data)
+		}
+	}
+
+	var back Decision
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&back, d) {
+		t.Fatalf("decision read back as %+v, want %+v", back, d)
+	}
+
+	if d, _ = Decide(a, a^1, nil); d.Verdict != Duplicate || d.Threshold != 3 || d.Diff != nil {
+		t.Fatalf("duplicate decision %+v", d)
+	}
+
+	if _, err = Decide(a, b, &DecisionOptions{Algorithm: "nosuch"}); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("unknown algorithm: %v", err)
+	}
+
+	if err = json.Unmarshal([]byte(`{"a":"0","b":"0","verdict":"same"}`), &back); err == nil {
+		t.Fatalf("unknown verdict read")
+	}
+}
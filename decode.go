@@ -0,0 +1,220 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io"
+	"os"
+)
+
+// Decode decodes an image from the given reader. The format is
+// detected automatically. PNG, GIF and JPEG are supported by default.
+// Other formats can be added through RegisterDecoder, or through
+// image.RegisterFormat. Images with more than MaxPixels pixels are
+// rejected with ErrTooLarge, and images smaller than MinSize with
+// ErrTooSmall.
+//
+// If the image has an embedded ICC profile which describes a colour
+// space other than sRGB, the image is converted to sRGB. This ensures
+// the same photo exported as -- for example -- Adobe RGB and sRGB, ends
+// up with the same hash. Unsupported profiles are ignored.
+//
+// CMYK and YCCK JPEGs decode to *image.CMYK, with or without an Adobe
+// APP14 segment. Their CMYK profiles are not applied.
+//
+// For RAW files, the embedded JPEG preview is decoded instead.
+// Refer to ExtractPreview for details.
+func Decode(r io.Reader) (image.Image, error) {
+	img, _, err := decode(r, false)
+	return img, err
+}
+
+// decode decodes an image as Decode does. If audit is set, it also
+// returns the lossy conversions the image takes.
+func decode(r io.Reader, audit bool) (image.Image, Loss, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var loss Loss
+	if preview, err := ExtractPreview(data); err == nil {
+		data = preview
+		loss |= LossDepth
+	}
+
+	img, err := decodeData(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := checkSize(img.Bounds()); err != nil {
+		return nil, 0, err
+	}
+
+	if p := profileConverter(data); p != nil {
+		img = p.convert(img)
+	} else if audit && unsupportedProfile(data) {
+		loss |= LossProfile
+	}
+
+	if !audit {
+		return img, 0, nil
+	}
+
+	return img, loss | AuditImage(img), nil
+}
+
+// DecodeFile decodes the image in the given file.
+// Refer to Decode for details.
+func DecodeFile(file string) (image.Image, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return Decode(fd)
+}
+
+// profileConverter returns the parsed ICC profile embedded in data.
+// It returns nil if there is none, if it is not supported, or if it
+// describes sRGB.
+func profileConverter(data []byte) *iccProfile {
+	profile := embeddedProfile(data)
+	if profile == nil {
+		return nil
+	}
+
+	p, err := parseICC(profile)
+	if err != nil {
+		return nil
+	}
+
+	return p
+}
+
+// unsupportedProfile returns true if data embeds an ICC profile which
+// can not be converted.
+func unsupportedProfile(data []byte) bool {
+	profile := embeddedProfile(data)
+	if profile == nil {
+		return false
+	}
+
+	_, err := parseICC(profile)
+	return err != nil
+}
+
+// embeddedProfile returns the ICC profile embedded in the
+// given PNG or JPEG data. It returns nil if there is none.
+func embeddedProfile(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngProfile(data[8:])
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return jpegProfile(data[2:])
+	}
+
+	return nil
+}
+
+// maxProfileSize is the largest ICC profile read from a PNG. Matrix/TRC
+// profiles take a few kilobytes, and the largest LUT based ones a few
+// megabytes.
+const maxProfileSize = 4 << 20
+
+// pngProfile reads the profile from a PNG iCCP chunk.
+func pngProfile(data []byte) []byte {
+	for len(data) >= 12 {
+		size := int(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+
+		if size < 0 || size+12 > len(data) {
+			return nil
+		}
+
+		switch kind {
+		case "iCCP":
+			// Profile name, null separator, compression method
+			// and the zlib compressed profile.
+			chunk := data[8 : 8+size]
+			i := bytes.IndexByte(chunk, 0)
+			if i == -1 || i+2 > len(chunk) {
+				return nil
+			}
+
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[i+2:]))
+			if err != nil {
+				return nil
+			}
+
+			// Stop short of decompression bombs. A profile over
+			// the limit is taken to be absent.
+			profile, err := io.ReadAll(io.LimitReader(zr, maxProfileSize+1))
+			if err != nil || len(profile) > maxProfileSize {
+				return nil
+			}
+
+			return profile
+
+		case "IDAT", "IEND":
+			// The profile must come before the image data.
+			return nil
+		}
+
+		data = data[size+12:]
+	}
+
+	return nil
+}
+
+// jpegProfile reads the profile from JPEG APP2 segments.
+// Large profiles are split up over multiple segments.
+func jpegProfile(data []byte) []byte {
+	var chunks [][]byte
+	marker := []byte("ICC_PROFILE\x00")
+
+	for len(data) >= 4 && data[0] == 0xff {
+		kind := data[1]
+		size := int(binary.BigEndian.Uint16(data[2:]))
+
+		if kind == 0xda || size < 2 || size+2 > len(data) {
+			// Start of scan, or a malformed segment.
+			break
+		}
+
+		segment := data[4 : size+2]
+
+		if kind == 0xe2 && bytes.HasPrefix(segment, marker) && len(segment) > len(marker)+2 {
+			seq := int(segment[len(marker)])
+			count := int(segment[len(marker)+1])
+
+			if chunks == nil {
+				chunks = make([][]byte, count)
+			}
+
+			if seq >= 1 && seq <= len(chunks) {
+				chunks[seq-1] = segment[len(marker)+2:]
+			}
+		}
+
+		data = data[size+2:]
+	}
+
+	var profile []byte
+	for _, c := range chunks {
+		if c == nil {
+			return nil
+		}
+
+		profile = append(profile, c...)
+	}
+
+	return profile
+}
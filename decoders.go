@@ -0,0 +1,233 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownFormat is returned by Decode for images in a format without
+// a registered decoder. If the format is recognised, the error says which
+// one it is; errors.Is still matches ErrUnknownFormat.
+var ErrUnknownFormat = errors.New("imghash: unknown image format")
+
+// ErrTooSmall is returned by Decode for images narrower or shorter than
+// MinSize. Their hash would say little about them, and match that of
+// every other image too small to tell apart.
+var ErrTooSmall = errors.New("imghash: image is too small to hash")
+
+// MaxPixels is the largest number of pixels Decode accepts. Larger
+// images fail with ErrTooLarge, before memory is allocated for them.
+// This protects against small files which claim huge dimensions. For
+// DecodeAnimation, the limit applies to all frames together.
+var MaxPixels = 1 << 28
+
+// MinSize is the smallest width and height Decode accepts. Smaller
+// images fail with ErrTooSmall. Images without any pixels are always
+// rejected. Images which are accepted, but are smaller than the grid of
+// a hash, are scaled up; refer to HashFunc.
+var MinSize = 1
+
+// A Decoder decodes images in a single format.
+//
+// Decoders for formats outside the standard library -- like HEIC or
+// AVIF, which typically wrap a C library -- are added by registering a
+// Decoder with RegisterDecoder. The ximage subpackage registers WebP,
+// TIFF and BMP decoders from golang.org/x/image.
+type Decoder struct {
+	Name       string   // Name of the format, like "webp".
+	Magic      string   // Magic prefix. A '?' matches any byte.
+	Extensions []string // File extensions, including the dot.
+
+	// Decode decodes a single image.
+	Decode func(io.Reader) (image.Image, error)
+
+	// DecodeConfig returns the dimensions of an image, without decoding
+	// it. It is optional, but without it, MaxPixels is not enforced.
+	// For grid images, it returns those of the canvas.
+	DecodeConfig func(io.Reader) (image.Config, error)
+
+	// DecodeGrid decodes the tiles of a grid image, for formats like
+	// HEIC and AVIF. It is optional. When set, Decode hashes the canvas
+	// put together from the tiles, rather than the image returned by
+	// Decode. It returns a nil Grid for images not stored as a grid,
+	// which are then decoded with Decode.
+	DecodeGrid func(io.Reader) (*Grid, error)
+}
+
+// match returns true if data starts with the decoder's magic prefix.
+func (d *Decoder) match(data []byte) bool {
+	if len(data) < len(d.Magic) {
+		return false
+	}
+
+	for i := 0; i < len(d.Magic); i++ {
+		if d.Magic[i] != '?' && d.Magic[i] != data[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+var (
+	decoderMu sync.RWMutex
+	decoders  = []*Decoder{
+		{"png", "\x89PNG\r\n\x1a\n", []string{".png"}, png.Decode, png.DecodeConfig, nil},
+		{"jpeg", "\xff\xd8", []string{".jpg", ".jpeg"}, decodeJPEG, jpeg.DecodeConfig, nil},
+		{"gif", "GIF8?a", []string{".gif"}, gif.Decode, gif.DecodeConfig, nil},
+	}
+)
+
+// RegisterDecoder registers a decoder, for use by Decode and all
+// functions built on it. Decoders registered later take precedence
+// over earlier ones with a matching magic prefix. This allows
+// replacing the built-in decoders.
+//
+// Formats registered with image.RegisterFormat are decoded as well,
+// but their file extensions are not known to HashFS.
+func RegisterDecoder(d *Decoder) {
+	decoderMu.Lock()
+	decoders = append(decoders, d)
+	decoderMu.Unlock()
+}
+
+// Extensions returns the file extensions of all registered decoders,
+// and those of the RAW formats supported by ExtractPreview.
+func Extensions() []string {
+	decoderMu.RLock()
+	defer decoderMu.RUnlock()
+
+	exts := append([]string(nil), rawExtensions...)
+	for _, d := range decoders {
+		exts = append(exts, d.Extensions...)
+	}
+
+	return exts
+}
+
+// HasExtension returns true if the file has one of the extensions
+// returned by Extensions.
+func HasExtension(file string) bool {
+	ext := path.Ext(file)
+	for _, e := range Extensions() {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findDecoder returns the registered decoder for data, or nil.
+func findDecoder(data []byte) *Decoder {
+	decoderMu.RLock()
+	defer decoderMu.RUnlock()
+
+	for i := len(decoders) - 1; i >= 0; i-- {
+		if decoders[i].match(data) {
+			return decoders[i]
+		}
+	}
+
+	return nil
+}
+
+// knownFormats identifies common formats, to make it clear why
+// Decode fails for them.
+var knownFormats = []*Decoder{
+	{Name: "WebP", Magic: "RIFF????WEBP"},
+	{Name: "TIFF", Magic: "II*\x00"},
+	{Name: "TIFF", Magic: "MM\x00*"},
+	{Name: "BMP", Magic: "BM"},
+	{Name: "HEIC", Magic: "????ftypheic"},
+	{Name: "HEIC", Magic: "????ftypheix"},
+	{Name: "HEIF", Magic: "????ftypmif1"},
+	{Name: "AVIF", Magic: "????ftypavif"},
+}
+
+// decodeData decodes data with the matching decoder.
+func decodeData(data []byte) (image.Image, error) {
+	if d := findDecoder(data); d != nil {
+		if d.DecodeConfig != nil {
+			cfg, err := d.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+
+			if err := checkPixels(cfg.Width, cfg.Height, 1); err != nil {
+				return nil, err
+			}
+		}
+
+		if d.DecodeGrid != nil {
+			g, err := d.DecodeGrid(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+
+			if g != nil {
+				return g.Image()
+			}
+		}
+
+		return d.Decode(bytes.NewReader(data))
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		if err := checkPixels(cfg.Width, cfg.Height, 1); err != nil {
+			return nil, err
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(data))
+		return img, err
+	}
+
+	if err != image.ErrFormat {
+		return nil, err
+	}
+
+	for _, f := range knownFormats {
+		if f.match(data) {
+			return nil, fmt.Errorf("%w: %s has no registered decoder", ErrUnknownFormat, f.Name)
+		}
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// checkSize returns ErrTooSmall if rect is empty, or narrower or
+// shorter than MinSize.
+func checkSize(rect image.Rectangle) error {
+	if rect.Empty() || rect.Dx() < MinSize || rect.Dy() < MinSize {
+		return ErrTooSmall
+	}
+
+	return nil
+}
+
+// checkPixels returns ErrTooLarge if the given number of frames
+// of the given size hold more than MaxPixels pixels.
+func checkPixels(w, h, frames int) error {
+	if w <= 0 || h <= 0 || frames <= 0 {
+		return nil
+	}
+
+	if w > MaxPixels/h || w*h > MaxPixels/frames {
+		return ErrTooLarge
+	}
+
+	return nil
+}
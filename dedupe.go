@@ -0,0 +1,200 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// A digest holds the SHA-256 of a file's contents.
+type digest struct {
+	path string
+	sum  [sha256.Size]byte
+	err  error
+}
+
+// DedupeFiles finds groups of duplicate images among the files received
+// on the given channel. Images are duplicates if their hashes are within
+// the given Hamming Distance of each other. Only groups with at least two
+// members are returned. Members and groups are sorted by path.
+//
+// This is done in two tiers. Files are first grouped by the SHA-256 of
+// their contents. This is cheap, and catches byte-identical copies. Only
+// a single file from each group is then decoded and hashed with the
+// given HashFunc, and the groups are merged by Hamming Distance. On
+// collections with many literal copies, this saves most of the work.
+//
+// With a Checkpoint, the SHA-256 of every file is recorded as well, so
+// a resumed run does not read the files it got to before.
+//
+// Opts may be nil, to use the defaults. Callbacks only fire for files
+// which are decoded. Files which can not be read or decoded are reported
+// to OnError and Logger, and left out of the groups.
+func DedupeFiles(files <-chan string, hf HashFunc, distance uint64, opts *BatchOptions) [][]*Entry {
+	var o BatchOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	// Group files by content.
+	copies := make(map[[sha256.Size]byte][]string)
+	seen := make(map[string]bool)
+
+	for d := range digestFiles(files, &o) {
+		if d.err != nil {
+			d.err = classify(d.err)
+			if o.Logger != nil {
+				o.Logger.Warn("hash failed", "path", d.path, "error", d.err)
+			}
+
+			if o.OnError != nil {
+				o.OnError(d.path, d.err)
+			}
+			continue
+		}
+
+		// The same file may be listed more than once.
+		if seen[d.path] {
+			continue
+		}
+
+		seen[d.path] = true
+		copies[d.sum] = append(copies[d.sum], d.path)
+	}
+
+	// Hash a single representative of each group.
+	reps := make(map[string][]string, len(copies))
+	for _, group := range copies {
+		sort.Strings(group)
+		reps[group[0]] = group
+	}
+
+	paths := make(chan string)
+	go func() {
+		for path := range reps {
+			paths <- path
+		}
+
+		close(paths)
+	}()
+
+	var entries []*Entry
+	for r := range HashFiles(paths, hf, opts) {
+		if r.Err == nil {
+			entries = append(entries, &Entry{Path: r.Path, Hash: r.Hash})
+		}
+	}
+
+	// Merge groups by distance, and expand them to all copies.
+	var groups [][]*Entry
+	for _, c := range Cluster(entries, distance) {
+		var group []*Entry
+
+		for _, e := range c {
+			for _, path := range reps[e.Path] {
+				group = append(group, &Entry{Path: path, Hash: e.Hash})
+			}
+		}
+
+		if len(group) < 2 {
+			continue
+		}
+
+		sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].Path < groups[j][0].Path })
+	return groups
+}
+
+// digestFiles computes the SHA-256 of all files concurrently. Reading
+// is most of the work, so this uses one worker per reader, if set.
+func digestFiles(files <-chan string, o *BatchOptions) <-chan *digest {
+	workers := o.Workers
+	if o.Readers > 0 {
+		workers = o.Readers
+	}
+
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	limit := newThrottle(o)
+
+	out := make(chan *digest, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for file := range files {
+				d := &digest{path: file}
+				d.sum, d.err = digestFile(file, o.Checkpoint, limit)
+				out <- d
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// digestFile computes the SHA-256 of a single file. If cp is set,
+// the digest is looked up in, and recorded to it. Reads count
+// towards the limits of t, which may be nil.
+func digestFile(file string, cp *Checkpoint, t *throttle) (sum [sha256.Size]byte, err error) {
+	t.acquire()
+	defer t.release()
+
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+
+	var key string
+	if cp != nil {
+		var stat os.FileInfo
+		if stat, err = fd.Stat(); err != nil {
+			return
+		}
+
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+
+		var ok bool
+		key = checkpointKey("sha256", file, stat)
+		if sum, ok = cp.digest(key); ok {
+			return
+		}
+	}
+
+	h := sha256.New()
+	if _, err = io.Copy(h, t.reader(fd)); err != nil {
+		return
+	}
+
+	copy(sum[:], h.Sum(nil))
+
+	if cp != nil {
+		cp.putDigest(key, sum)
+	}
+	return
+}
@@ -0,0 +1,284 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DeduperOptions configure a Deduper. The zero value hashes images with
+// the Balanced preset, and keeps everything in memory.
+type DeduperOptions struct {
+	// Name of the algorithm to hash images with, as registered with
+	// Register. Empty means "balanced".
+	Algorithm string
+
+	// Thresholds to classify distances by, in place of those of the
+	// algorithm. Fields left at 0 keep the algorithm's. Images within
+	// the near-duplicate threshold of each other are duplicates.
+	Thresholds Thresholds
+
+	// Index to keep the hashes in, or nil for a new one. Entries already
+	// in it are grouped by NewDeduper. Save it to keep the hashes
+	// between runs.
+	Index *Index
+
+	// Cache of file hashes for AddFile and CheckFile, or nil for none.
+	// Files are keyed by algorithm, size, modification time and path,
+	// as Batch keys them.
+	Cache Cache
+}
+
+// A Deduper finds duplicates among the images added to it. It ties a
+// hash, an Index, the thresholds and Clusters together, for programs
+// which only need to know whether an image was seen before, and which
+// images are copies of each other:
+//
+//	d, err := imghash.NewDeduper(nil)
+//	...
+//	for _, file := range files {
+//		dups, err := d.AddFile(file)
+//		...
+//		for _, h := range dups {
+//			fmt.Printf("%s: %v of %s\n", file, d.Classify(h.Distance), h.ID)
+//		}
+//	}
+//
+//	for _, group := range d.Groups() {
+//		fmt.Println(group)
+//	}
+//
+// A Deduper is safe for concurrent use. Images are hashed outside of
+// its lock, so concurrent calls hash in parallel.
+type Deduper struct {
+	name       string
+	hash       HashFunc
+	thresholds Thresholds
+	cache      Cache
+
+	mu       sync.Mutex
+	index    *Index
+	clusters *Clusters
+}
+
+// NewDeduper creates a Deduper with the given options. Opts may be nil,
+// to use the defaults. It returns an error wrapping ErrUnknownAlgorithm
+// if the algorithm is not registered.
+func NewDeduper(opts *DeduperOptions) (*Deduper, error) {
+	var o DeduperOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Algorithm == "" {
+		o.Algorithm = Balanced.String()
+	}
+
+	a, err := LookupAlgorithm(o.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	t := a.Thresholds
+	if o.Thresholds.Duplicate > 0 {
+		t.Duplicate = o.Thresholds.Duplicate
+	}
+	if o.Thresholds.NearDuplicate > 0 {
+		t.NearDuplicate = o.Thresholds.NearDuplicate
+	}
+
+	if o.Index == nil {
+		o.Index = NewIndex()
+	}
+
+	d := &Deduper{
+		name:       o.Algorithm,
+		hash:       a.Hash,
+		thresholds: t,
+		cache:      o.Cache,
+		index:      o.Index,
+		clusters:   NewClusters(o.Index, t.NearDuplicate),
+	}
+
+	d.clusters.Update()
+	return d, nil
+}
+
+// Len returns the number of images added.
+func (d *Deduper) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.index.Len()
+}
+
+// Index returns the index the hashes are kept in. It must not be
+// changed while the Deduper is in use.
+func (d *Deduper) Index() *Index {
+	return d.index
+}
+
+// Thresholds returns the thresholds distances are classified by.
+func (d *Deduper) Thresholds() Thresholds {
+	return d.thresholds
+}
+
+// Classify returns the verdict for the distance of a hit.
+func (d *Deduper) Classify(dist uint64) Verdict {
+	return d.thresholds.Classify(dist)
+}
+
+// Decide returns the decision for two hashes computed with the algorithm
+// of the Deduper, such as that of an image and of one of its hits, for
+// keeping a record of why they were taken as copies. Diff asks for the
+// differing cells as well; refer to Decision.
+func (d *Deduper) Decide(a, b uint64, diff bool) *Decision {
+	return decide(a, b, d.name, "", d.thresholds, diff)
+}
+
+// Add hashes the image and adds it under the given ID, replacing any
+// image added under it before. It returns the images it duplicates, as
+// Check does.
+func (d *Deduper) Add(id string, img image.Image) Hits {
+	return d.AddHash(id, d.hash(img))
+}
+
+// AddFile decodes, hashes and adds the image in the given file, under
+// its path. It returns the images it duplicates, as Check does.
+func (d *Deduper) AddFile(file string) (Hits, error) {
+	hash, err := d.hashFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return d.AddHash(file, hash), nil
+}
+
+// AddHash adds an image by its hash, computed with the algorithm of the
+// Deduper. It returns the images it duplicates, as Check does.
+func (d *Deduper) AddHash(id string, hash uint64) Hits {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hits := d.check(hash, id)
+	d.clusters.Add(id, hash)
+	return hits
+}
+
+// Remove removes the image added under the given ID. Its group stays
+// together, as for Clusters.
+func (d *Deduper) Remove(id string) {
+	d.mu.Lock()
+	d.clusters.Remove(id)
+	d.mu.Unlock()
+}
+
+// Check returns the images added so far which the image duplicates:
+// those within the near-duplicate threshold, nearest first. Pass their
+// distances to Classify to tell exact duplicates apart.
+func (d *Deduper) Check(img image.Image) Hits {
+	return d.CheckHash(d.hash(img))
+}
+
+// CheckFile decodes and hashes the image in the given file, and returns
+// the images it duplicates, as Check does.
+func (d *Deduper) CheckFile(file string) (Hits, error) {
+	hash, err := d.hashFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return d.CheckHash(hash), nil
+}
+
+// CheckHash returns the images which an image of the given hash
+// duplicates, as Check does.
+func (d *Deduper) CheckHash(hash uint64) Hits {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.check(hash, "")
+}
+
+// check searches the index, leaving out the given ID.
+func (d *Deduper) check(hash uint64, id string) Hits {
+	hits := d.index.Search(hash, d.thresholds.NearDuplicate)
+
+	n := 0
+	for _, h := range hits {
+		if h.ID != id {
+			hits[n] = h
+			n++
+		}
+	}
+
+	return hits[:n]
+}
+
+// Groups returns the IDs of the images with duplicates, in groups of
+// copies of each other. Each group is sorted, and groups are ordered by
+// the ID of their oldest member. Images without duplicates are left out.
+func (d *Deduper) Groups() [][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var groups [][]string
+	for _, label := range d.clusters.Labels() {
+		if ids := d.clusters.Members(label); len(ids) > 1 {
+			groups = append(groups, ids)
+		}
+	}
+
+	return groups
+}
+
+// Group returns the IDs of the images in the group of the given ID, or
+// nil if no image was added under it.
+func (d *Deduper) Group(id string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	label, ok := d.clusters.Cluster(id)
+	if !ok {
+		return nil
+	}
+	return d.clusters.Members(label)
+}
+
+// hashFile hashes the image in the given file, through the cache.
+func (d *Deduper) hashFile(file string) (uint64, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+
+	defer fd.Close()
+
+	if d.cache == nil {
+		return ComputeReader(fd, d.hash)
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	path := file
+	if abs, err := filepath.Abs(file); err == nil {
+		path = abs
+	}
+
+	key := fmt.Sprintf("%s:file:%d:%d:%s", d.name, stat.Size(), stat.ModTime().UnixNano(), path)
+	if hash, ok := d.cache.Get(key); ok {
+		return hash, nil
+	}
+
+	hash, err := ComputeReader(fd, d.hash)
+	if err != nil {
+		return 0, err
+	}
+
+	d.cache.Put(key, hash)
+	return hash, nil
+}
@@ -0,0 +1,105 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeduper(t *testing.T) {
+	if _, err := NewDeduper(&DeduperOptions{Algorithm: "nope"}); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("unknown algorithm: %v", err)
+	}
+
+	d, err := NewDeduper(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := synth.Gradient(160, 120, 1), synth.Checker(160, 120, 2)
+
+	if hits := d.Add("a", a); len(hits) != 0 {
+		t.Fatalf("first image: %v", hits.IDs())
+	}
+	d.Add("b", b)
+
+	hits := d.Add("a-small", attack.Resize(0.5).Apply(a))
+	if len(hits) != 1 || hits[0].ID != "a" || d.Classify(hits[0].Distance) == Distinct {
+		t.Fatalf("resized copy: %+v", hits)
+	}
+
+	if hits := d.Check(attack.JPEG(75).Apply(b)); len(hits) != 1 || hits[0].ID != "b" {
+		t.Fatalf("check: %+v", hits)
+	}
+
+	if d.Len() != 3 {
+		t.Fatalf("len %d", d.Len())
+	}
+
+	if g := d.Groups(); !reflect.DeepEqual(g, [][]string{{"a", "a-small"}}) {
+		t.Fatalf("groups %v", g)
+	}
+
+	if g := d.Group("b"); !reflect.DeepEqual(g, []string{"b"}) {
+		t.Fatalf("group of b: %v", g)
+	}
+
+	// An existing index is grouped on creation.
+	e, err := NewDeduper(&DeduperOptions{Index: d.Index(), Thresholds: Thresholds{NearDuplicate: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Thresholds() != (Thresholds{AverageThresholds.Duplicate, 1}) {
+		t.Fatalf("thresholds %v", e.Thresholds())
+	}
+
+	if e.Group("a-small") == nil {
+		t.Fatal("index entries not grouped")
+	}
+
+	// Files are hashed through the cache.
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.png")
+	writeTestPNG(t, file, a)
+
+	cache, err := NewFileCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := NewDeduper(&DeduperOptions{Cache: cache})
+	if _, err := f.AddFile(file); err != nil || cache.Len() != 1 {
+		t.Fatalf("add file: %v, %d cached", err, cache.Len())
+	}
+
+	if hits, err := f.CheckFile(file); err != nil || len(hits) != 1 || hits[0].ID != file || hits[0].Distance != 0 {
+		t.Fatalf("check file: %+v, %v", hits, err)
+	}
+
+	if _, err := f.AddFile(filepath.Join(dir, "missing.png")); err == nil {
+		t.Fatal("missing file added")
+	}
+}
+
+func writeTestPNG(t *testing.T, file string, img image.Image) {
+	fd, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fd.Close()
+
+	if err := png.Encode(fd, img); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrDefaultHasherSet is returned by SetDefaultHasher once the default
+// hasher has been set, or used.
+var ErrDefaultHasherSet = errors.New("imghash: default hasher already set or in use")
+
+// A Hasher is a hash function with everything which goes into its
+// hashes pinned down, under a name and version. Hashes computed by
+// different hashers, or different versions of one, can not be
+// compared; store String with them, so they never are by mistake.
+type Hasher struct {
+	Algorithm  string     // Name of the algorithm, as registered with Register.
+	Profile    string     // Preprocessing before the algorithm, as a name or a list of filters. It is only recorded.
+	Version    int        // Version of the whole, to be bumped whenever its hashes change.
+	Hash       HashFunc   // The hash function, preprocessing included.
+	Thresholds Thresholds // Thresholds to classify the distances of its hashes by.
+}
+
+// String returns the name and version of the hasher, like "average@1".
+func (h *Hasher) String() string {
+	return fmt.Sprintf("%s@%d", h.Algorithm, h.Version)
+}
+
+// StandardHasher returns the hasher used by default, unless
+// SetDefaultHasher says otherwise. Its version 1, the only one so far,
+// is Average with AverageThresholds, run on the image as Decode returns
+// it, with no filters before it:
+//
+//   - Embedded ICC profiles other than sRGB are applied. CMYK profiles
+//     are not; CMYK pixels are converted to RGB as color.CMYK does.
+//   - Transparent pixels count as black, as premultiplied colours have
+//     them. No background is composited.
+//   - The image is scaled to 8x8 by averaging all pixels under each
+//     cell, converted to gray as color.Gray16Model does, and each cell
+//     is compared with the mean of all 64.
+//
+// The hashes of the fixtures package are those of this version. It
+// will not change; a hasher which hashes differently gets a new
+// version, which programs choose by setting it as the default.
+func StandardHasher() *Hasher {
+	return &Hasher{
+		Algorithm:  "average",
+		Version:    1,
+		Hash:       Average,
+		Thresholds: AverageThresholds,
+	}
+}
+
+// defaultHasher is the hasher set with SetDefaultHasher, or the
+// standard one once the default has been used.
+var defaultHasher atomic.Pointer[Hasher]
+
+// SetDefaultHasher sets the hasher which the functions of the package
+// use when they are passed no HashFunc: ComputeFile and the other
+// Compute functions, batches, and the upload middleware. The imghash
+// command defaults to its algorithm as well.
+//
+// Set it once, when the program starts, so all hashes come from the
+// same one. Once the default has been set, or used, it fails with
+// ErrDefaultHasherSet.
+func SetDefaultHasher(h *Hasher) error {
+	if h == nil || h.Hash == nil {
+		return errors.New("imghash: default hasher without a hash function")
+	}
+
+	if !defaultHasher.CompareAndSwap(nil, h) {
+		return ErrDefaultHasherSet
+	}
+
+	return nil
+}
+
+// DefaultHasher returns the hasher set with SetDefaultHasher, or the
+// standard one if none was. Either way, the default can no longer be
+// changed after this.
+func DefaultHasher() *Hasher {
+	defaultHasher.CompareAndSwap(nil, StandardHasher())
+	return defaultHasher.Load()
+}
@@ -0,0 +1,61 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/fixtures"
+	"image"
+	"testing"
+)
+
+func TestDefaultHasher(t *testing.T) {
+	defer defaultHasher.Store(nil)
+
+	// The standard hasher yields the pinned hashes of the fixtures.
+	for _, name := range []string{"checkerboard", "gradient_vertical"} {
+		want, _ := fixtures.Hash("average", name)
+
+		hash, err := ComputeBytes(fixtures.Bytes(name), nil)
+		if err != nil || hash != want {
+			t.Fatalf("%s: %016x, %v; want %016x", name, hash, err, want)
+		}
+	}
+
+	if s := DefaultHasher().String(); s != "average@1" {
+		t.Fatalf("default %s", s)
+	}
+
+	// The default was used, so it stays.
+	if err := SetDefaultHasher(StandardHasher()); err != ErrDefaultHasherSet {
+		t.Fatalf("set after use: %v", err)
+	}
+
+	defaultHasher.Store(nil)
+
+	if err := SetDefaultHasher(&Hasher{Algorithm: "none"}); err == nil {
+		t.Fatal("set without a hash function")
+	}
+
+	h := &Hasher{
+		Algorithm: "constant",
+		Version:   2,
+		Hash:      func(image.Image) uint64 { return 42 },
+	}
+
+	if err := SetDefaultHasher(h); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetDefaultHasher(StandardHasher()); err != ErrDefaultHasherSet {
+		t.Fatalf("set twice: %v", err)
+	}
+
+	if hash, err := ComputeBytes(fixtures.Bytes("gradient"), nil); err != nil || hash != 42 {
+		t.Fatalf("%d, %v", hash, err)
+	}
+
+	if got := DefaultHasher(); got != h || got.String() != "constant@2" {
+		t.Fatalf("default %s", got)
+	}
+}
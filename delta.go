@@ -0,0 +1,70 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+)
+
+// Delta compares images on a grid of deltaSize by deltaSize cells.
+// Averaging the cells hides the noise lossy compression adds around
+// edges, which differs between any two encodings of an image.
+const deltaSize = 64
+
+// deltaFloor is the smallest difference between two cells Delta
+// keeps, out of 0xffff. Smaller ones are compression noise, mostly.
+const deltaFloor = 0x1000
+
+// Delta returns an image of the differences between two aligned images,
+// such as two revisions of an asset. The images are scaled to the same
+// small grid, and each cell holds the largest difference between the
+// colour channels of a and b there, from black for none to white. Small
+// differences are left out, so two encodings of the same image yield a
+// black image.
+func Delta(a, b image.Image) *image.Gray16 {
+	a, b = resize(a, deltaSize, deltaSize), resize(b, deltaSize, deltaSize)
+	out := image.NewGray16(image.Rect(0, 0, deltaSize, deltaSize))
+
+	diff := func(p, q uint32) uint32 {
+		if p > q {
+			return p - q
+		}
+		return q - p
+	}
+
+	var x, y int
+	for y = 0; y < deltaSize; y++ {
+		for x = 0; x < deltaSize; x++ {
+			r1, g1, b1, a1 := a.At(x, y).RGBA()
+			r2, g2, b2, a2 := b.At(x, y).RGBA()
+
+			d := diff(r1, r2)
+			for _, c := range [...]uint32{diff(g1, g2), diff(b1, b2), diff(a1, a2)} {
+				if c > d {
+					d = c
+				}
+			}
+
+			if d < deltaFloor {
+				d = 0
+			}
+
+			out.SetGray16(x, y, color.Gray16{uint16(d)})
+		}
+	}
+
+	return out
+}
+
+// DeltaHash hashes the differences between two aligned images, as
+// found by Delta, with the given HashFunc. The hash tells where the
+// images differ, and in what shape, but not what the underlying images
+// look like. The same edit applied to many images, say a watermark
+// stamped in the same place, thus yields about the same hash for every
+// one of them, and grouping the hashes groups the edits. Images which
+// do not differ hash as a black image does: to 0, with Average.
+func DeltaHash(a, b image.Image, hf HashFunc) uint64 {
+	return hf(Delta(a, b))
+}
@@ -0,0 +1,65 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+func TestDeltaHash(t *testing.T) {
+	encode := func(img image.Image, quality int) image.Image {
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+		out, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// watermark stamps the same block of text on the image, at the
+	// given position.
+	mark := synth.Text(160, 48, 7)
+	watermark := func(img image.Image, at image.Point) image.Image {
+		out := image.NewRGBA(img.Bounds())
+		draw.Draw(out, out.Rect, img, image.Point{}, draw.Src)
+		draw.DrawMask(out, image.Rectangle{at, at.Add(image.Pt(160, 48))}, mark, image.Point{},
+			image.NewUniform(color.Alpha{192}), image.Point{}, draw.Over)
+		return out
+	}
+
+	var corner, top []uint64
+	for seed := int64(0); seed < 8; seed++ {
+		img := synth.Shapes(640, 480, seed)
+		original := encode(img, 90)
+
+		// Encoding the image again changes nothing.
+		if h := DeltaHash(original, encode(img, 75), Average); h != 0 {
+			t.Fatalf("%d: unchanged image hashes to %016x", seed, h)
+		}
+
+		corner = append(corner, DeltaHash(original, encode(watermark(img, image.Pt(460, 420)), 75), Average))
+		top = append(top, DeltaHash(original, encode(watermark(img, image.Pt(20, 20)), 75), Average))
+	}
+
+	for i := range corner {
+		if d := Distance(corner[i], corner[0]); d > AverageThresholds.Duplicate {
+			t.Fatalf("%d: distance %d to the same edit", i, d)
+		}
+
+		if d := Distance(top[i], top[0]); d > AverageThresholds.Duplicate {
+			t.Fatalf("%d: distance %d to the same edit", i, d)
+		}
+
+		if d := Distance(corner[i], top[0]); d <= AverageThresholds.Duplicate {
+			t.Fatalf("%d: distance %d to another edit", i, d)
+		}
+	}
+}
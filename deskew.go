@@ -0,0 +1,201 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// skewWidth is the width to which EstimateSkew scales images down.
+// Text lines remain distinct at this size, and it keeps the search fast.
+const skewWidth = 512
+
+// Binarize is a Filter which turns the image into black and white. The
+// threshold is chosen by Otsu's method: it is the luminance which best
+// separates the pixels into two groups, like ink and paper. Scans of the
+// same page at different exposures or scanner settings come out alike.
+func Binarize(img image.Image) image.Image {
+	gray := luminance(img)
+	threshold := otsu(histogram(gray))
+
+	rect := gray.Bounds()
+	out := image.NewGray(rect)
+
+	var x, y int
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			if gray.Gray16At(x, y).Y > threshold {
+				out.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+
+	return out
+}
+
+// otsu returns the threshold which maximizes the variance between the
+// values at or below it, and those above it. The histogram is reduced
+// to 256 levels first.
+func otsu(hist []uint64) uint16 {
+	var levels [256]float64
+	var total, sum float64
+
+	for v, n := range hist {
+		levels[v>>8] += float64(n)
+	}
+
+	for i, n := range levels {
+		total += n
+		sum += float64(i) * n
+	}
+
+	var best, bestVariance float64
+	var below, belowSum float64
+
+	for i, n := range levels {
+		below += n
+		belowSum += float64(i) * n
+
+		above := total - below
+		if below == 0 || above == 0 {
+			continue
+		}
+
+		d := belowSum/below - (sum-belowSum)/above
+		if v := below * above * d * d; v > bestVariance {
+			best, bestVariance = float64(i), v
+		}
+	}
+
+	return uint16(best)<<8 | 0xff
+}
+
+// EstimateSkew returns the angle, in degrees clockwise, by which the
+// text in the image is rotated. Angles up to maxDegrees in either
+// direction are considered.
+//
+// The estimate is found by shearing the dark pixels of the image at a
+// range of angles. At the right angle, text lines collapse into a few
+// rows, which makes the horizontal projection profile as sharp as it
+// gets. This works for pages with lines of text, and for most other
+// documents with horizontal structure. Images without it yield 0.
+func EstimateSkew(img image.Image, maxDegrees float64) float64 {
+	rect := img.Bounds()
+	if rect.Dx() < 2 || rect.Dy() < 2 || maxDegrees <= 0 {
+		return 0
+	}
+
+	w, h := rect.Dx(), rect.Dy()
+	if w > skewWidth {
+		w, h = skewWidth, h*skewWidth/w
+		if h < 2 {
+			h = 2
+		}
+		img = resize(img, w, h)
+	}
+
+	bw := Binarize(img).(*image.Gray)
+
+	// Collect the coordinates of dark pixels.
+	var xs, ys []float64
+	var x, y int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			if bw.GrayAt(x, y).Y == 0 {
+				xs = append(xs, float64(x))
+				ys = append(ys, float64(y))
+			}
+		}
+	}
+
+	if len(xs) == 0 || len(xs) == w*h {
+		return 0
+	}
+
+	// Sheared rows may fall outside the image by up to w*tan(maxDegrees).
+	offset := int(math.Ceil(float64(w)*math.Tan(maxDegrees*math.Pi/180))) + 1
+	rows := make([]float64, h+2*offset)
+
+	score := func(degrees float64) float64 {
+		for i := range rows {
+			rows[i] = 0
+		}
+
+		tan := math.Tan(degrees * math.Pi / 180)
+		for i := range xs {
+			rows[int(ys[i]-xs[i]*tan+0.5)+offset]++
+		}
+
+		var s float64
+		for i := 1; i < len(rows); i++ {
+			d := rows[i] - rows[i-1]
+			s += d * d
+		}
+
+		return s
+	}
+
+	// A coarse search over the full range, refined around the best angle.
+	search := func(from, to, step float64) float64 {
+		best, bestScore := 0.0, -1.0
+		for a := from; a <= to+step/2; a += step {
+			if s := score(a); s > bestScore {
+				best, bestScore = a, s
+			}
+		}
+		return best
+	}
+
+	coarse := search(-maxDegrees, maxDegrees, 0.2)
+	fine := search(math.Max(-maxDegrees, coarse-0.2), math.Min(maxDegrees, coarse+0.2), 0.02)
+	return math.Round(fine*100) / 100
+}
+
+// Deskew returns a Filter which straightens images rotated by up to
+// maxDegrees, as found by EstimateSkew. Rescans of the same page are
+// rarely placed at exactly the same angle; a skew of 1 or 2 degrees
+// is enough to change the bits of a hash. The output has the size of
+// the input, with uncovered corners filled in white. It is grayscale.
+func Deskew(maxDegrees float64) Filter {
+	return func(img image.Image) image.Image {
+		angle := EstimateSkew(img, maxDegrees)
+		gray := luminance(img)
+
+		if math.Abs(angle) < 0.05 {
+			return gray
+		}
+
+		return rotateGray(gray, -angle, color.Gray16{0xffff})
+	}
+}
+
+// rotateGray rotates the image clockwise around its centre, by the given
+// number of degrees. Pixels outside the source are set to bg.
+func rotateGray(src *image.Gray16, degrees float64, bg color.Gray16) *image.Gray16 {
+	rect := src.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	out := image.NewGray16(rect)
+
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	cx, cy := float64(w)/2, float64(h)/2
+
+	var x, y, sx, sy int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			fx, fy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			sx = int(math.Floor(cos*fx + sin*fy + cx))
+			sy = int(math.Floor(-sin*fx + cos*fy + cy))
+
+			if sx < 0 || sy < 0 || sx >= w || sy >= h {
+				out.SetGray16(rect.Min.X+x, rect.Min.Y+y, bg)
+			} else {
+				out.SetGray16(rect.Min.X+x, rect.Min.Y+y, src.Gray16At(rect.Min.X+sx, rect.Min.Y+sy))
+			}
+		}
+	}
+
+	return out
+}
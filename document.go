@@ -0,0 +1,18 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+// DocumentThresholds holds the recommended thresholds for hashes
+// computed by Document.
+var DocumentThresholds = Thresholds{Duplicate: 3, NearDuplicate: 9}
+
+// documentSkew is the largest skew Document corrects, in degrees.
+const documentSkew = 5
+
+// Document is a HashFunc tuned for scanned documents. Pages are
+// binarized and straightened with Binarize and Deskew, before being
+// hashed with Average. Rescans of the same page match, despite being
+// placed on the scanner at a slightly different angle, or scanned
+// with different exposure or threshold settings.
+var Document = Preprocess(Average, Binarize, Deskew(documentSkew))
@@ -0,0 +1,91 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestEstimateSkew(t *testing.T) {
+	page := drawPage(600, 800, 1)
+
+	for _, degrees := range []float64{-2, -0.5, 0, 1, 1.5, 3} {
+		img := rotateGray(luminance(page), degrees, color.Gray16{0xffff})
+		if got := EstimateSkew(img, 5); math.Abs(got-degrees) > 0.15 {
+			t.Errorf("rotated %g: estimated %g", degrees, got)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	page := drawPage(600, 800, 1)
+	h := Document(page)
+
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"skew", rotateGray(luminance(page), 1.5, color.Gray16{0xffff})},
+		{"skew back", rotateGray(luminance(page), -2, color.Gray16{0xffff})},
+		{"darker", attack.Chain(attack.Brightness(-0.2), attack.Contrast(0.7)).Apply(page)},
+		{"noise", attack.Noise(20, 1).Apply(rotateGray(luminance(page), 1, color.Gray16{0xffff}))},
+	} {
+		d := Distance(h, Document(tc.img))
+		if d > DocumentThresholds.Duplicate {
+			t.Errorf("%s: distance %d", tc.name, d)
+		}
+	}
+
+	for seed := int64(2); seed < 10; seed++ {
+		d := Distance(h, Document(drawPage(600, 800, seed)))
+		if d <= DocumentThresholds.NearDuplicate {
+			t.Errorf("page %d: distance %d", seed, d)
+		}
+	}
+}
+
+// drawPage draws a page of paragraphs, in grey ink on off-white paper.
+func drawPage(w, h int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{0xf0, 0xec, 0xe0, 0xff}), image.Point{}, draw.Src)
+
+	words := []string{"THE", "OF", "AND", "CONTRACT", "PARTY", "SHALL", "BE", "IN", "DATE", "PAGE", "NO.", "TERMS"}
+	ink := color.RGBA{0x30, 0x30, 0x40, 0xff}
+	scale := w / 300
+
+	margin := w / 10
+	for y := h / 10; y < h-h/10; {
+		// A paragraph of a few lines, the last one shorter.
+		lines := 1 + rng.Intn(6)
+		for i := 0; i < lines; i++ {
+			var line []string
+			width := w - 2*margin
+			if i == lines-1 {
+				width = width * (2 + rng.Intn(7)) / 10
+			}
+
+			for n := 0; n < width; {
+				word := words[rng.Intn(len(words))]
+				line = append(line, word)
+				n += (len(word) + 1) * 6 * scale
+			}
+
+			synth.DrawText(img, image.Pt(margin, y), scale, ink, strings.Join(line[:len(line)-1], " "))
+			y += 10 * scale
+		}
+
+		y += 10 * scale * (1 + rng.Intn(3))
+	}
+
+	return img
+}
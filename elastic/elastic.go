@@ -0,0 +1,159 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package elastic encodes hashes for near-duplicate search in
+Elasticsearch and OpenSearch.
+
+Search engines do not index Hamming Distance. Instead, each hash is
+split into a number of segments, which are indexed as keyword tokens
+along with the hash itself. Every differing bit changes at most one
+segment, so two hashes within distance d of each other share at least
+n-d of their n tokens. A query asks for documents with that many
+matching tokens, which the inverted index answers quickly, and then
+checks the exact distance of the remaining candidates in a script:
+
+	enc, err := elastic.New("phash", 16)
+	...
+	body, _ := json.Marshal(enc.Mapping())
+	// PUT /images/_mapping with body.
+
+	body, _ = json.Marshal(enc.Document(hash))
+	// Merge into the image document.
+
+	query, err := enc.Query(hash, 5)
+	...
+	body, _ = json.Marshal(query)
+	// POST /images/_search with body.
+
+More segments allow searching for larger distances, as the distance
+must be less than the number of segments. Fewer segments make the
+tokens more selective.
+*/
+package elastic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSegments is returned for a segment count which does not divide 64.
+var ErrSegments = errors.New("elastic: segment count must divide 64")
+
+// ErrDistance is returned for query distances no smaller than the
+// number of segments. The tokens can not narrow down such queries.
+var ErrDistance = errors.New("elastic: distance must be less than the segment count")
+
+// distanceScript computes the Hamming Distance between the
+// indexed hash and the query hash, in Painless.
+const distanceScript = "Long.bitCount(doc[params.field].value ^ params.hash)"
+
+// An Encoder turns hashes into document fields and queries.
+type Encoder struct {
+	field    string
+	segments int
+	width    uint
+}
+
+// New creates an encoder which stores hashes in the given field,
+// and their tokens in the field with a "_tokens" suffix. Segments
+// must divide 64.
+func New(field string, segments int) (*Encoder, error) {
+	if segments <= 0 || segments > 64 || 64%segments != 0 {
+		return nil, ErrSegments
+	}
+
+	return &Encoder{field, segments, uint(64 / segments)}, nil
+}
+
+// TokenField returns the name of the field holding the tokens.
+func (e *Encoder) TokenField() string {
+	return e.field + "_tokens"
+}
+
+// Tokens returns the tokens for a hash. Each holds the position and
+// value of a segment, as in "3:51f", so equal values at different
+// positions do not match.
+func (e *Encoder) Tokens(hash uint64) []string {
+	tokens := make([]string, e.segments)
+	mask := ^uint64(0) >> (64 - e.width)
+
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("%d:%x", i, hash>>(uint(i)*e.width)&mask)
+	}
+
+	return tokens
+}
+
+// Document returns the fields to add to the document of an image.
+// The hash is stored as a long, as search engines have no unsigned
+// type. Its bits are unchanged.
+func (e *Encoder) Document(hash uint64) map[string]interface{} {
+	return map[string]interface{}{
+		e.field:        int64(hash),
+		e.TokenField(): e.Tokens(hash),
+	}
+}
+
+// Mapping returns the index mapping for the fields.
+func (e *Encoder) Mapping() map[string]interface{} {
+	return map[string]interface{}{
+		"properties": map[string]interface{}{
+			e.field:        map[string]interface{}{"type": "long"},
+			e.TokenField(): map[string]interface{}{"type": "keyword", "norms": false},
+		},
+	}
+}
+
+// Query returns a search request body for documents whose hash is within
+// the given distance of hash. Matches are sorted by distance.
+func (e *Encoder) Query(hash, distance uint64) (map[string]interface{}, error) {
+	if distance >= uint64(e.segments) {
+		return nil, ErrDistance
+	}
+
+	tokens := e.Tokens(hash)
+	should := make([]interface{}, len(tokens))
+	for i, t := range tokens {
+		should[i] = map[string]interface{}{
+			"term": map[string]interface{}{e.TokenField(): t},
+		}
+	}
+
+	params := map[string]interface{}{
+		"field":    e.field,
+		"hash":     int64(hash),
+		"distance": distance,
+	}
+
+	return map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               should,
+				"minimum_should_match": e.segments - int(distance),
+				"filter": []interface{}{
+					map[string]interface{}{
+						"script": map[string]interface{}{
+							"script": map[string]interface{}{
+								"source": distanceScript + " <= params.distance",
+								"params": params,
+							},
+						},
+					},
+				},
+			},
+		},
+		"sort": []interface{}{
+			map[string]interface{}{
+				"_script": map[string]interface{}{
+					"type":  "number",
+					"order": "asc",
+					"script": map[string]interface{}{
+						"source": distanceScript,
+						"params": params,
+					},
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,86 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package elastic
+
+import (
+	"encoding/json"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	enc, err := New("phash", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"0:cdef", "1:89ab", "2:4567", "3:123"}
+	if tokens := enc.Tokens(0x0123456789abcdef); !reflect.DeepEqual(tokens, want) {
+		t.Fatalf("tokens %v, want %v", tokens, want)
+	}
+
+	// Hashes within distance d share at least n-d tokens.
+	enc, _ = New("phash", 16)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		a := rng.Uint64()
+		b := a ^ rng.Uint64()&rng.Uint64()&rng.Uint64()&rng.Uint64()
+		d := bits.OnesCount64(a ^ b)
+
+		var shared int
+		ta, tb := enc.Tokens(a), enc.Tokens(b)
+		for j := range ta {
+			if ta[j] == tb[j] {
+				shared++
+			}
+		}
+
+		if shared < 16-d {
+			t.Fatalf("%016x and %016x share %d tokens at distance %d", a, b, shared, d)
+		}
+	}
+
+	if _, err := New("phash", 5); err != ErrSegments {
+		t.Fatalf("5 segments: %v", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	enc, _ := New("phash", 16)
+
+	if _, err := enc.Query(1, 16); err != ErrDistance {
+		t.Fatalf("distance 16: %v", err)
+	}
+
+	q, err := enc.Query(1<<63, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := string(data)
+	for _, want := range []string{
+		`"minimum_should_match":11`,
+		`{"term":{"phash_tokens":"15:8"}}`,
+		`"hash":-9223372036854775808`,
+		`"source":"Long.bitCount(doc[params.field].value ^ params.hash) \u003c= params.distance"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("query lacks %s:\n%s", want, body)
+		}
+	}
+
+	doc, _ := json.Marshal(enc.Document(3))
+	if !strings.Contains(string(doc), `"phash":3`) || !strings.Contains(string(doc), `"0:3"`) {
+		t.Fatalf("unexpected document %s", doc)
+	}
+}
@@ -0,0 +1,268 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package eval measures how well a perceptual hash separates duplicates
+from distinct images, on pairs labeled by hand.
+
+For every possible threshold, it counts how many of the labeled pairs a
+hash classifies correctly. This yields the ROC curve and the precision
+and recall curve, the area under the ROC curve as a single figure of
+merit, and the threshold which separates the pairs best:
+
+	pairs, err := eval.ReadPairs(fd)
+	...
+	results, err := eval.EvaluateFiles(pairs, map[string]imghash.HashFunc{
+		"average": imghash.Average,
+	})
+	...
+	best := results["average"].Best
+	fmt.Printf("threshold %d: %.1f%% recall, %.1f%% false positives\n",
+		best.Threshold, 100*best.TPR(), 100*best.FPR())
+*/
+package eval
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"strings"
+)
+
+// ErrLabels is returned by Evaluate if the samples do not include
+// both duplicate and distinct pairs.
+var ErrLabels = errors.New("eval: need both duplicate and distinct pairs")
+
+// maxDistance is the largest possible distance between 64 bit hashes.
+const maxDistance = 64
+
+// A Pair is a labeled pair of image files.
+type Pair struct {
+	A, B      string
+	Duplicate bool // True if A and B are copies of the same image.
+}
+
+// A Sample is the Hamming Distance between the hashes of a labeled pair.
+type Sample struct {
+	Distance  uint64
+	Duplicate bool
+}
+
+// A Point holds the outcome of classifying all samples with a single
+// threshold. Pairs at or below the threshold count as duplicates.
+type Point struct {
+	Threshold uint64
+	TP, FP    int // Pairs classified as duplicates, correctly or not.
+	TN, FN    int // Pairs classified as distinct, correctly or not.
+}
+
+// TPR returns the true positive rate, or recall: the fraction
+// of duplicates which are found.
+func (p Point) TPR() float64 { return ratio(p.TP, p.TP+p.FN) }
+
+// FPR returns the false positive rate: the fraction of distinct
+// pairs which are mistaken for duplicates.
+func (p Point) FPR() float64 { return ratio(p.FP, p.FP+p.TN) }
+
+// Precision returns the fraction of pairs classified as duplicates
+// which really are. It is 1 if no pairs are classified as duplicates.
+func (p Point) Precision() float64 {
+	if p.TP+p.FP == 0 {
+		return 1
+	}
+
+	return ratio(p.TP, p.TP+p.FP)
+}
+
+// F1 returns the harmonic mean of precision and recall.
+func (p Point) F1() float64 {
+	pr, re := p.Precision(), p.TPR()
+	if pr+re == 0 {
+		return 0
+	}
+
+	return 2 * pr * re / (pr + re)
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+
+	return float64(a) / float64(b)
+}
+
+// Result holds the evaluation of a single hash.
+type Result struct {
+	// Points holds one entry for every threshold from 0 to 64. Plotting
+	// their TPR against their FPR yields the ROC curve; plotting their
+	// Precision against their TPR yields the precision and recall curve.
+	Points []Point
+
+	// AUC is the area under the ROC curve. A hash which separates all
+	// pairs perfectly scores 1. Guessing scores 0.5.
+	AUC float64
+
+	// Best is the point with the largest difference between its true
+	// and false positive rates, also known as Youden's J statistic.
+	// On ties, the lowest threshold wins.
+	Best Point
+}
+
+// Evaluate computes the ROC curve for the given samples.
+func Evaluate(samples []Sample) (*Result, error) {
+	// Count the samples by distance.
+	var dup, dist [maxDistance + 1]int
+	var ndup, ndist int
+
+	for _, s := range samples {
+		d := s.Distance
+		if d > maxDistance {
+			d = maxDistance
+		}
+
+		if s.Duplicate {
+			dup[d]++
+			ndup++
+		} else {
+			dist[d]++
+			ndist++
+		}
+	}
+
+	if ndup == 0 || ndist == 0 {
+		return nil, ErrLabels
+	}
+
+	r := &Result{Points: make([]Point, maxDistance+1)}
+
+	var tp, fp int
+	var prevTPR, prevFPR float64
+
+	for t := range r.Points {
+		tp += dup[t]
+		fp += dist[t]
+
+		p := Point{
+			Threshold: uint64(t),
+			TP:        tp,
+			FP:        fp,
+			TN:        ndist - fp,
+			FN:        ndup - tp,
+		}
+
+		r.Points[t] = p
+
+		// Trapezoids between consecutive points, starting at the
+		// origin, where nothing is classified as a duplicate.
+		r.AUC += (p.FPR() - prevFPR) * (p.TPR() + prevTPR) / 2
+		prevTPR, prevFPR = p.TPR(), p.FPR()
+
+		if t == 0 || p.TPR()-p.FPR() > r.Best.TPR()-r.Best.FPR() {
+			r.Best = p
+		}
+	}
+
+	return r, nil
+}
+
+// EvaluateFiles hashes the images in all pairs with each of the given
+// hashes, and evaluates them. Each file is decoded only once. Results
+// are keyed by the names of the hashes.
+func EvaluateFiles(pairs []Pair, hashes map[string]imghash.HashFunc) (map[string]*Result, error) {
+	values := make(map[string]map[string]uint64) // By file, then by name.
+
+	hash := func(file string) (map[string]uint64, error) {
+		if v, ok := values[file]; ok {
+			return v, nil
+		}
+
+		img, err := imghash.DecodeFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+
+		v := make(map[string]uint64, len(hashes))
+		for name, hf := range hashes {
+			v[name] = hf(img)
+		}
+
+		values[file] = v
+		return v, nil
+	}
+
+	samples := make(map[string][]Sample, len(hashes))
+
+	for _, p := range pairs {
+		a, err := hash(p.A)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := hash(p.B)
+		if err != nil {
+			return nil, err
+		}
+
+		for name := range hashes {
+			samples[name] = append(samples[name], Sample{
+				Distance:  imghash.Distance(a[name], b[name]),
+				Duplicate: p.Duplicate,
+			})
+		}
+	}
+
+	results := make(map[string]*Result, len(hashes))
+	for name := range hashes {
+		r, err := Evaluate(samples[name])
+		if err != nil {
+			return nil, err
+		}
+
+		results[name] = r
+	}
+
+	return results, nil
+}
+
+// ReadPairs reads labeled pairs from CSV data, with one pair per line:
+//
+//	a.jpg,a_small.jpg,1
+//	a.jpg,b.jpg,0
+//
+// The label is 1, true, yes or duplicate for duplicates, and 0, false,
+// no or distinct otherwise.
+func ReadPairs(r io.Reader) ([]Pair, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	var pairs []Pair
+
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return pairs, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		p := Pair{A: rec[0], B: rec[1]}
+
+		switch strings.ToLower(rec[2]) {
+		case "1", "true", "yes", "duplicate":
+			p.Duplicate = true
+		case "0", "false", "no", "distinct":
+		default:
+			line, _ := cr.FieldPos(2)
+			return nil, fmt.Errorf("eval: line %d: invalid label %q", line, rec[2])
+		}
+
+		pairs = append(pairs, p)
+	}
+}
@@ -0,0 +1,98 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package eval
+
+import (
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	// Duplicates at distances 0-4, distinct pairs at 3-30.
+	var samples []Sample
+	for _, d := range []uint64{0, 1, 1, 2, 4} {
+		samples = append(samples, Sample{d, true})
+	}
+
+	for _, d := range []uint64{3, 12, 20, 25, 30} {
+		samples = append(samples, Sample{d, false})
+	}
+
+	r, err := Evaluate(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Points) != 65 {
+		t.Fatalf("%d points", len(r.Points))
+	}
+
+	// A threshold of 2 finds 4 of 5 duplicates, without false
+	// positives. It ties with 4, which finds all duplicates with
+	// one false positive.
+	if best := r.Best; best.Threshold != 2 || best.TP != 4 || best.FP != 0 {
+		t.Fatalf("best %+v", best)
+	}
+
+	// Only the pair of (4, 3) is ordered wrongly.
+	if want := 24.0 / 25; math.Abs(r.AUC-want) > 1e-9 {
+		t.Fatalf("AUC %f, want %f", r.AUC, want)
+	}
+
+	if last := r.Points[64]; last.TPR() != 1 || last.FPR() != 1 {
+		t.Fatalf("last point %+v", last)
+	}
+
+	if _, err := Evaluate(samples[:5]); err != ErrLabels {
+		t.Fatalf("got %v, want ErrLabels", err)
+	}
+}
+
+func TestEvaluateFiles(t *testing.T) {
+	// A vertical gradient hashes nothing like the gopher.
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+
+	var x, y int
+	for y = 0; y < 64; y++ {
+		for x = 0; x < 64; x++ {
+			img.SetGray(x, y, color.Gray{uint8(4 * y)})
+		}
+	}
+
+	other := filepath.Join(t.TempDir(), "gradient.png")
+	fd, err := os.Create(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	png.Encode(fd, img)
+	fd.Close()
+
+	pairs, err := ReadPairs(strings.NewReader("# large, small, other\n" +
+		"../testdata/gopher_large.png,../testdata/gopher_small.png,1\n" +
+		"../testdata/gopher_large.png," + other + ",distinct\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := EvaluateFiles(pairs, map[string]imghash.HashFunc{"average": imghash.Average})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := results["average"]; r.AUC != 1 {
+		t.Fatalf("AUC %f, best %+v", r.AUC, r.Best)
+	}
+
+	if _, err := ReadPairs(strings.NewReader("a,b,maybe\n")); err == nil {
+		t.Fatal("invalid label accepted")
+	}
+}
@@ -0,0 +1,198 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package eval
+
+import (
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/attack"
+	"image"
+	"io/fs"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RecommendOptions configure Recommend and RecommendDir. The zero value
+// is a valid configuration.
+type RecommendOptions struct {
+	// Names of the registered algorithms to compare. Defaults to all
+	// of them.
+	Algorithms []string
+
+	// Edits which turn an image into a duplicate of itself. Defaults to
+	// attack.Standard.
+	Transforms []attack.Transform
+
+	// Number of images RecommendDir samples. Defaults to 50.
+	Images int
+
+	// Seed for sampling the images of RecommendDir.
+	Seed int64
+}
+
+// A Recommendation is the evaluation of a single algorithm on a sample
+// of a collection.
+type Recommendation struct {
+	Algorithm string
+
+	// Thresholds of the algorithm, with the near-duplicate threshold set
+	// to the one which separates the pairs best, and the duplicate
+	// threshold scaled along with it.
+	Thresholds imghash.Thresholds
+
+	Result *Result       // Evaluation of the pairs.
+	Time   time.Duration // Mean time to hash an image.
+}
+
+// Recommend compares algorithms on a sample of a collection, and returns
+// them ranked, best first. Opts may be nil, to use the defaults.
+//
+// No labels are needed: every image makes duplicate pairs with its
+// variants under the transforms, and distinct pairs with every other
+// image. Algorithms are ranked by the area under their ROC curve, then
+// by how well their best threshold separates the pairs, then by speed.
+// The sample should hold images like those of the collection, and at
+// least a few dozen of them; two is the least which works.
+func Recommend(images []image.Image, opts *RecommendOptions) ([]Recommendation, error) {
+	var o RecommendOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Algorithms == nil {
+		o.Algorithms = imghash.Algorithms()
+	}
+
+	if o.Transforms == nil {
+		o.Transforms = attack.Standard()
+	}
+
+	algorithms := make([]*imghash.Algorithm, len(o.Algorithms))
+	for i, name := range o.Algorithms {
+		a, err := imghash.LookupAlgorithm(name)
+		if err != nil {
+			return nil, err
+		}
+		algorithms[i] = a
+	}
+
+	// Hashes by algorithm, of every image, then of its variants.
+	hashes := make([][][]uint64, len(algorithms))
+	elapsed := make([]time.Duration, len(algorithms))
+
+	for i := range hashes {
+		hashes[i] = make([][]uint64, len(images))
+	}
+
+	variants := make([]image.Image, 1+len(o.Transforms))
+
+	for j, img := range images {
+		variants[0] = img
+		for k, t := range o.Transforms {
+			variants[1+k] = t.Apply(img)
+		}
+
+		for i, a := range algorithms {
+			start := time.Now()
+
+			v := make([]uint64, len(variants))
+			for k, img := range variants {
+				v[k] = a.Hash(img)
+			}
+
+			elapsed[i] += time.Since(start)
+			hashes[i][j] = v
+		}
+	}
+
+	recs := make([]Recommendation, len(algorithms))
+
+	for i, a := range algorithms {
+		var samples []Sample
+
+		for j, v := range hashes[i] {
+			for _, h := range v[1:] {
+				samples = append(samples, Sample{imghash.Distance(v[0], h), true})
+			}
+
+			for _, w := range hashes[i][j+1:] {
+				samples = append(samples, Sample{imghash.Distance(v[0], w[0]), false})
+			}
+		}
+
+		r, err := Evaluate(samples)
+		if err != nil {
+			return nil, err
+		}
+
+		recs[i] = Recommendation{
+			Algorithm:  o.Algorithms[i],
+			Thresholds: scaleThresholds(a.Thresholds, r.Best.Threshold),
+			Result:     r,
+			Time:       elapsed[i] / time.Duration(len(images)*len(variants)),
+		}
+	}
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		a, b := recs[i], recs[j]
+		ja, jb := a.Result.Best.TPR()-a.Result.Best.FPR(), b.Result.Best.TPR()-b.Result.Best.FPR()
+
+		switch {
+		case a.Result.AUC != b.Result.AUC:
+			return a.Result.AUC > b.Result.AUC
+		case ja != jb:
+			return ja > jb
+		}
+
+		return a.Time < b.Time
+	})
+
+	return recs, nil
+}
+
+// RecommendDir is like Recommend, for a random sample of the images
+// under dir. Files which fail to decode are left out of the sample.
+func RecommendDir(dir string, opts *RecommendOptions) ([]Recommendation, error) {
+	n, seed := 50, int64(0)
+	if opts != nil {
+		if opts.Images > 0 {
+			n = opts.Images
+		}
+		seed = opts.Seed
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && imghash.HasExtension(file) {
+			files = append(files, file)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+
+	var images []image.Image
+	for _, file := range files {
+		if len(images) == n {
+			break
+		}
+
+		if img, err := imghash.DecodeFile(file); err == nil {
+			images = append(images, img)
+		}
+	}
+
+	return Recommend(images, opts)
+}
@@ -0,0 +1,72 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package eval
+
+import (
+	"errors"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecommend(t *testing.T) {
+	imghash.Register("constant-test", func() *imghash.Algorithm {
+		return &imghash.Algorithm{Hash: func(image.Image) uint64 { return 0 }, Thresholds: imghash.AverageThresholds}
+	})
+
+	var images []image.Image
+	for _, img := range synth.Corpus(12, []image.Point{{96, 72}}, 1) {
+		images = append(images, img)
+	}
+
+	opts := &RecommendOptions{Algorithms: []string{"constant-test", "average"}}
+	recs, err := Recommend(images, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recs) != 2 || recs[0].Algorithm != "average" || recs[1].Algorithm != "constant-test" {
+		t.Fatalf("ranking %+v", recs)
+	}
+
+	best := recs[0]
+	if best.Result.AUC <= recs[1].Result.AUC || best.Thresholds.NearDuplicate != best.Result.Best.Threshold || best.Time <= 0 {
+		t.Fatalf("recommendation %+v", best)
+	}
+
+	if _, err := Recommend(images[:1], opts); err != ErrLabels {
+		t.Fatalf("single image: %v", err)
+	}
+
+	if _, err := Recommend(images, &RecommendOptions{Algorithms: []string{"nope"}}); !errors.Is(err, imghash.ErrUnknownAlgorithm) {
+		t.Fatalf("unknown algorithm: %v", err)
+	}
+
+	// A directory is sampled, without the files which fail to decode.
+	dir := t.TempDir()
+	for i, img := range images[:4] {
+		fd, err := os.Create(filepath.Join(dir, string(rune('a'+i))+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(fd, img)
+		fd.Close()
+	}
+	os.WriteFile(filepath.Join(dir, "broken.png"), []byte("not an image"), 0644)
+
+	opts.Images = 3
+	recs, err = RecommendDir(dir, opts)
+	if err != nil || recs[0].Algorithm != "average" {
+		t.Fatalf("directory: %+v, %v", recs, err)
+	}
+
+	// Each image has 18 variants, and there are 3 pairs of images.
+	if n := recs[0].Result.Points[64].TP + recs[0].Result.Points[64].FP; n != 3*18+3 {
+		t.Fatalf("%d pairs", n)
+	}
+}
@@ -0,0 +1,127 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package eval
+
+import (
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/attack"
+	"image"
+	"math"
+	"sort"
+)
+
+// A Stability tells how far the hash of a single image moves when the
+// image is perturbed. Images with little detail, or whose detail sits
+// right at the mean of the grid, flip many bits under mild edits; others
+// hardly any. Matching the former at a wider threshold, and the latter
+// at a narrower one, evens out recall and false positives.
+type Stability struct {
+	Hash  uint64   // Hash of the image itself.
+	Names []string // Names of the transforms applied.
+	Flips []uint64 // Bits flipped by each transform, in the same order.
+
+	Mean float64 // Mean number of bits flipped.
+	Max  uint64  // Most bits flipped by any transform.
+
+	// Fraction of the transforms which flip each bit, in bit order.
+	// Bits flipped by most are best not relied upon.
+	Bits [64]float64
+}
+
+// MeasureStability hashes the image, and each of its variants by the
+// given transforms, or by attack.Standard if ts is empty.
+func MeasureStability(img image.Image, hf imghash.HashFunc, ts []attack.Transform) *Stability {
+	if len(ts) == 0 {
+		ts = attack.Standard()
+	}
+
+	s := &Stability{
+		Hash:  hf(img),
+		Names: make([]string, len(ts)),
+		Flips: make([]uint64, len(ts)),
+	}
+
+	var sum uint64
+	for i, t := range ts {
+		diff := s.Hash ^ hf(t.Apply(img))
+
+		s.Names[i] = t.Name
+		s.Flips[i] = imghash.Distance(diff, 0)
+		sum += s.Flips[i]
+
+		if s.Flips[i] > s.Max {
+			s.Max = s.Flips[i]
+		}
+
+		for b := 0; b < 64; b++ {
+			if diff&(1<<uint(b)) != 0 {
+				s.Bits[b]++
+			}
+		}
+	}
+
+	for b := range s.Bits {
+		s.Bits[b] /= float64(len(ts))
+	}
+
+	s.Mean = float64(sum) / float64(len(ts))
+	return s
+}
+
+// Score returns the stability as a figure from 0 to 1: 1 if no transform
+// flips any bit, and 0 if they flip half of them on average, as for
+// unrelated images.
+func (s *Stability) Score() float64 {
+	return math.Max(0, 1-s.Mean/32)
+}
+
+// Quantile returns the smallest distance within which the given fraction
+// of the variants stayed, from 0 to 1. Quantile(0.9) makes a threshold
+// for this image which matches 9 out of 10 of its perturbed copies.
+func (s *Stability) Quantile(q float64) uint64 {
+	if len(s.Flips) == 0 {
+		return 0
+	}
+
+	flips := append([]uint64(nil), s.Flips...)
+	sort.Slice(flips, func(i, j int) bool { return flips[i] < flips[j] })
+
+	i := int(math.Ceil(q*float64(len(flips)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(flips) {
+		i = len(flips) - 1
+	}
+
+	return flips[i]
+}
+
+// Thresholds returns t, with the near-duplicate threshold widened or
+// narrowed to the given quantile of the distances, and the duplicate
+// threshold scaled along with it. The near-duplicate threshold stays
+// within half and twice that of t, so no single image is matched at a
+// threshold far off those of the others.
+func (s *Stability) Thresholds(t imghash.Thresholds, q float64) imghash.Thresholds {
+	near := s.Quantile(q)
+	if near < t.NearDuplicate/2 {
+		near = t.NearDuplicate / 2
+	}
+	if near > 2*t.NearDuplicate {
+		near = 2 * t.NearDuplicate
+	}
+
+	return scaleThresholds(t, near)
+}
+
+// scaleThresholds returns t with the given near-duplicate threshold, and
+// the duplicate threshold scaled along with it.
+func scaleThresholds(t imghash.Thresholds, near uint64) imghash.Thresholds {
+	if t.NearDuplicate > 0 {
+		t.Duplicate = (t.Duplicate*near + t.NearDuplicate/2) / t.NearDuplicate
+	}
+
+	t.NearDuplicate = near
+	return t
+}
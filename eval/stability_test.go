@@ -0,0 +1,57 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package eval
+
+import (
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"testing"
+)
+
+func TestStability(t *testing.T) {
+	// The variants of transforms 0 to 3 flip 0, 1, 2 and 3 bits.
+	hashes := []uint64{0, 0, 1, 3, 7}
+	hf := func(image.Image) uint64 {
+		h := hashes[0]
+		hashes = hashes[1:]
+		return h
+	}
+
+	ts := make([]attack.Transform, 4)
+	for i := range ts {
+		ts[i] = attack.Transform{Name: "none", Apply: func(img image.Image) image.Image { return img }}
+	}
+
+	s := MeasureStability(nil, hf, ts)
+
+	if s.Mean != 1.5 || s.Max != 3 || len(s.Flips) != 4 || s.Bits[0] != 0.75 || s.Bits[2] != 0.25 {
+		t.Fatalf("stability %+v", s)
+	}
+
+	if q := s.Quantile(0.5); q != 1 {
+		t.Fatalf("median %d", q)
+	}
+
+	if q := s.Quantile(1); q != 3 {
+		t.Fatalf("maximum %d", q)
+	}
+
+	if th := s.Thresholds(imghash.Thresholds{Duplicate: 2, NearDuplicate: 4}, 1); th != (imghash.Thresholds{Duplicate: 2, NearDuplicate: 3}) {
+		t.Fatalf("thresholds %v", th)
+	}
+
+	if th := s.Thresholds(imghash.Thresholds{Duplicate: 3, NearDuplicate: 9}, 0); th.NearDuplicate != 4 {
+		t.Fatalf("narrowed thresholds %v", th)
+	}
+
+	// Smooth images hold up better than noise.
+	smooth := MeasureStability(synth.Gradient(128, 96, 1), imghash.Average, nil)
+	noise := MeasureStability(synth.Noise(128, 96, 1), imghash.Average, nil)
+
+	if len(smooth.Names) != len(attack.Standard()) || smooth.Score() <= noise.Score() {
+		t.Fatalf("smooth %.3f, noise %.3f", smooth.Score(), noise.Score())
+	}
+}
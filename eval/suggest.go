@@ -0,0 +1,230 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package eval
+
+import (
+	"errors"
+	"github.com/jteeuwen/imghash"
+	"math"
+	"math/rand"
+)
+
+// ErrNoDuplicates is returned by Suggest if the distances do not
+// show any duplicates to separate from the rest.
+var ErrNoDuplicates = errors.New("eval: no duplicates among the distances")
+
+// A Histogram counts the pairs of hashes at each distance, from 0 to 64.
+type Histogram [maxDistance + 1]int
+
+// Total returns the number of pairs counted.
+func (h *Histogram) Total() int {
+	var n int
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// SampleDistances counts the distances between pairs of the given
+// hashes. If there are more than n pairs, n pairs are picked at random
+// from the given seed; otherwise, all pairs are counted. A value of
+// n < 1 counts all pairs.
+func SampleDistances(hashes []uint64, n int, seed int64) *Histogram {
+	var h Histogram
+	m := len(hashes)
+	pairs := m * (m - 1) / 2
+
+	if n < 1 || pairs <= n {
+		var i, j int
+		for i = 0; i < m; i++ {
+			for j = i + 1; j < m; j++ {
+				h[imghash.Distance(hashes[i], hashes[j])]++
+			}
+		}
+		return &h
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	for k := 0; k < n; k++ {
+		i := rng.Intn(m)
+		j := rng.Intn(m - 1)
+		if j >= i {
+			j++
+		}
+
+		h[imghash.Distance(hashes[i], hashes[j])]++
+	}
+
+	return &h
+}
+
+// A Suggestion is a threshold estimated from the distances in a
+// corpus, without labels.
+type Suggestion struct {
+	Threshold uint64
+
+	// Estimated fractions of distinct pairs at or below the threshold,
+	// and of duplicate pairs above it.
+	FPR, FNR float64
+
+	// Estimated fraction of all pairs which are duplicates.
+	Duplicates float64
+
+	// Mean and standard deviation of the distances between
+	// duplicates, and between distinct images.
+	IntraMean, IntraStdDev float64
+	InterMean, InterStdDev float64
+}
+
+// Suggest estimates the best threshold for a corpus from its histogram
+// of distances, as returned by SampleDistances.
+//
+// Distances between distinct images pile up around half the hash
+// size; those between duplicates sit close to 0. Suggest fits a mixture
+// of two normal distributions to the histogram, one for either class,
+// by expectation-maximization. The threshold is the one which, like
+// Result.Best, maximizes the true positive rate minus the false
+// positive rate, as far as the fitted distributions tell.
+//
+// The estimates are only as good as the fit. Only a few duplicates
+// among many pairs, or a corpus of very similar images, make for
+// rough estimates; labeled pairs and Evaluate give exact figures.
+func Suggest(h *Histogram) (*Suggestion, error) {
+	total := float64(h.Total())
+	if total == 0 {
+		return nil, ErrNoDuplicates
+	}
+
+	// Start with the duplicates near 0, and all other pairs
+	// distributed as the whole histogram is.
+	var mean, sq float64
+	for d, c := range h {
+		mean += float64(d) * float64(c)
+		sq += float64(d*d) * float64(c)
+	}
+
+	mean /= total
+	intra := component{weight: 0.05, mean: 1, variance: 2}
+	inter := component{weight: 0.95, mean: mean, variance: math.Max(sq/total-mean*mean, 1)}
+
+	var resp [maxDistance + 1]float64 // Share of each bin owned by intra.
+
+	for iter := 0; iter < 500; iter++ {
+		pa, pb := intra.pmf(), inter.pmf()
+
+		for d := range resp {
+			a, b := intra.weight*pa[d], inter.weight*pb[d]
+			if a+b > 0 {
+				resp[d] = a / (a + b)
+			} else if float64(d) < (intra.mean+inter.mean)/2 {
+				resp[d] = 1
+			} else {
+				resp[d] = 0
+			}
+		}
+
+		prev := intra
+		intra = fit(h, resp[:], false)
+		inter = fit(h, resp[:], true)
+
+		if intra.weight == 0 {
+			return nil, ErrNoDuplicates
+		}
+
+		if math.Abs(intra.mean-prev.mean) < 1e-9 && math.Abs(intra.weight-prev.weight) < 1e-12 {
+			break
+		}
+	}
+
+	// Less than a single duplicate pair, or two classes which are
+	// not separated, mean there is nothing to tell apart.
+	if intra.weight*total < 1 || intra.mean >= inter.mean {
+		return nil, ErrNoDuplicates
+	}
+
+	pa, pb := intra.pmf(), inter.pmf()
+	s := &Suggestion{
+		Duplicates:  intra.weight,
+		IntraMean:   intra.mean,
+		IntraStdDev: math.Sqrt(intra.variance),
+		InterMean:   inter.mean,
+		InterStdDev: math.Sqrt(inter.variance),
+	}
+
+	// Cumulative rates for each threshold.
+	var tpr, fpr float64
+	best := -1.0
+
+	for t := range pa {
+		tpr += pa[t]
+		fpr += pb[t]
+
+		if j := tpr - fpr; j > best {
+			best = j
+			s.Threshold = uint64(t)
+			s.FPR, s.FNR = fpr, math.Max(1-tpr, 0)
+		}
+	}
+
+	return s, nil
+}
+
+// A component is one of the normal distributions of a mixture.
+type component struct {
+	weight, mean, variance float64
+}
+
+// pmf returns the probability of each distance under the component,
+// normalized over the distances which can occur.
+func (c component) pmf() []float64 {
+	p := make([]float64, maxDistance+1)
+
+	var sum float64
+	for d := range p {
+		x := float64(d) - c.mean
+		p[d] = math.Exp(-x * x / (2 * c.variance))
+		sum += p[d]
+	}
+
+	for d := range p {
+		if sum > 0 {
+			p[d] /= sum
+		}
+	}
+
+	return p
+}
+
+// fit returns the component which best fits the histogram, with each
+// bin weighted by resp, or by 1-resp if invert is set.
+func fit(h *Histogram, resp []float64, invert bool) component {
+	var n, sum, sq, total float64
+
+	for d, c := range h {
+		w := resp[d]
+		if invert {
+			w = 1 - w
+		}
+
+		w *= float64(c)
+		n += w
+		sum += w * float64(d)
+		sq += w * float64(d*d)
+		total += float64(c)
+	}
+
+	if n == 0 {
+		return component{}
+	}
+
+	mean := sum / n
+
+	// A floor on the variance keeps a class of exact copies
+	// from collapsing onto a single distance.
+	return component{
+		weight:   n / total,
+		mean:     mean,
+		variance: math.Max(sq/n-mean*mean, 0.25),
+	}
+}
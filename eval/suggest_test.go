@@ -0,0 +1,55 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package eval
+
+import (
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"testing"
+)
+
+func TestSampleDistances(t *testing.T) {
+	hashes := []uint64{0, 1, 3, 7}
+
+	h := SampleDistances(hashes, 0, 1)
+	if h.Total() != 6 || h[1] != 3 || h[2] != 2 || h[3] != 1 {
+		t.Fatalf("all pairs: %v", h[:4])
+	}
+
+	h = SampleDistances(hashes, 4, 1)
+	if h.Total() != 4 || h[0] != 0 {
+		t.Fatalf("sampled pairs: %v", h[:4])
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	var corpus []image.Image
+	var hashes []uint64
+	for i := 0; i < 150; i++ {
+		img := synth.Shapes(96, 96, int64(i))
+		corpus = append(corpus, img)
+		hashes = append(hashes, imghash.Average(img))
+	}
+
+	if s, err := Suggest(SampleDistances(hashes, 0, 1)); err != ErrNoDuplicates {
+		t.Fatalf("distinct images only: %+v, %v", s, err)
+	}
+
+	// Add mildly altered copies of a third of the images.
+	transforms := []attack.Transform{attack.Crop(0.05), attack.Rotate(1), attack.Noise(16, 1)}
+	for i, img := range corpus[:50] {
+		hashes = append(hashes, imghash.Average(transforms[i%len(transforms)].Apply(img)))
+	}
+
+	s, err := Suggest(SampleDistances(hashes, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Threshold < 1 || s.Threshold > 8 || s.FPR > 0.01 || s.Duplicates == 0 || s.IntraMean >= s.InterMean {
+		t.Fatalf("suggestion %+v", s)
+	}
+}
@@ -0,0 +1,504 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// ErrClusterFull is returned by ExternalCluster.Add once it holds the
+// largest number of hashes it supports.
+var ErrClusterFull = errors.New("imghash: external cluster is full")
+
+// ExternalOptions configures an ExternalCluster.
+type ExternalOptions struct {
+	// Dir is the directory to spill to. Defaults to the temporary
+	// directory of the system.
+	Dir string
+
+	// RunSize is the number of hashes sorted in memory at a time, at 16
+	// bytes each. Defaults to 1<<22, which takes 64 MiB.
+	RunSize int
+
+	// Window is the number of hashes each hash is compared with, among
+	// those sharing a block of bits with it. Defaults to 256.
+	Window int
+}
+
+// An ExternalCluster groups hashes into clusters of near-duplicates, as
+// Cluster does, for collections too large to hold in memory. Paths and
+// hashes are spilled to disk as they are added, and compared in sorted
+// runs. It takes a fixed amount of memory for the runs, plus 4 bytes for
+// every hash added: about 2 GiB for 500 million images.
+//
+// The hashes are split into distance+1 blocks of bits. Two hashes within
+// the distance have at least one block in common, so the hashes are
+// sorted by each block in turn, and each is compared with the hashes
+// before it which share the block. Sorting by the other bits next puts
+// close hashes next to each other, so limiting this to a window of the
+// nearest is exact, unless more than a window of hashes share a block
+// without being near-duplicates. Low distances are cheapest: each block
+// is another pass over all hashes.
+//
+// An ExternalCluster is not safe for concurrent use. Close it to
+// remove its files.
+type ExternalCluster struct {
+	distance uint64
+	opts     ExternalOptions
+	dir      string
+
+	paths   *os.File // Paths, prefixed by their length.
+	entries *os.File // Offset of the path and hash, for every ID.
+	pw, ew  *bufio.Writer
+	offset  uint64
+
+	parent []uint32 // Union-find over IDs.
+}
+
+// NewExternalCluster creates an ExternalCluster for the given Hamming
+// Distance. Opts may be nil, to use the defaults.
+func NewExternalCluster(distance uint64, opts *ExternalOptions) (*ExternalCluster, error) {
+	c := &ExternalCluster{distance: distance}
+	if opts != nil {
+		c.opts = *opts
+	}
+
+	if c.opts.RunSize <= 0 {
+		c.opts.RunSize = 1 << 22
+	}
+
+	if c.opts.Window <= 0 {
+		c.opts.Window = 256
+	}
+
+	var err error
+	if c.dir, err = os.MkdirTemp(c.opts.Dir, "imghash-cluster-"); err != nil {
+		return nil, err
+	}
+
+	if c.paths, err = os.Create(filepath.Join(c.dir, "paths")); err == nil {
+		c.entries, err = os.Create(filepath.Join(c.dir, "entries"))
+	}
+
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	c.pw = bufio.NewWriter(c.paths)
+	c.ew = bufio.NewWriter(c.entries)
+	return c, nil
+}
+
+// Close removes all files of the cluster.
+func (c *ExternalCluster) Close() error {
+	if c.paths != nil {
+		c.paths.Close()
+	}
+
+	if c.entries != nil {
+		c.entries.Close()
+	}
+
+	return os.RemoveAll(c.dir)
+}
+
+// Len returns the number of hashes added.
+func (c *ExternalCluster) Len() int {
+	return len(c.parent)
+}
+
+// Add adds the hash of the image at the given path. Paths are expected
+// to be unique.
+func (c *ExternalCluster) Add(path string, hash uint64) error {
+	if uint64(len(c.parent)) >= math.MaxUint32 {
+		return ErrClusterFull
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(path)))
+
+	if _, err := c.pw.Write(buf[:n]); err != nil {
+		return err
+	}
+
+	if _, err := c.pw.WriteString(path); err != nil {
+		return err
+	}
+
+	var entry [16]byte
+	binary.BigEndian.PutUint64(entry[:], c.offset)
+	binary.BigEndian.PutUint64(entry[8:], hash)
+
+	if _, err := c.ew.Write(entry[:]); err != nil {
+		return err
+	}
+
+	c.offset += uint64(n + len(path))
+	c.parent = append(c.parent, uint32(len(c.parent)))
+	return nil
+}
+
+// Clusters calls fn for every cluster of at least two entries, in the
+// order their first entry was added. The entries in each cluster are
+// sorted by path. An error from fn stops the iteration and is returned.
+func (c *ExternalCluster) Clusters(fn func([]*Entry) error) error {
+	if err := c.pw.Flush(); err != nil {
+		return err
+	}
+
+	if err := c.ew.Flush(); err != nil {
+		return err
+	}
+
+	blocks := int(c.distance) + 1
+	if blocks > 64 {
+		blocks = 64
+	}
+
+	var start, b int
+	for b = 0; b < blocks; b++ {
+		size := 64 / blocks
+		if b < 64%blocks {
+			size++
+		}
+
+		if err := c.pass(start, size); err != nil {
+			return err
+		}
+
+		start += size
+	}
+
+	return c.emit(fn)
+}
+
+// pass compares the hashes sharing the block of size bits at the given
+// offset from the top, and joins the near-duplicates among them.
+func (c *ExternalCluster) pass(start, size int) error {
+	s := c.newSorter()
+	defer s.close()
+
+	err := c.scan(func(id uint32, _, hash uint64) error {
+		return s.add(record{bits.RotateLeft64(hash, start), id})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	window := make([]record, 0, c.opts.Window)
+	shift := uint(64 - size)
+
+	return s.sorted(func(r record) error {
+		if len(window) > 0 && window[0].key>>shift != r.key>>shift {
+			window = window[:0]
+		}
+
+		for _, w := range window {
+			if Distance(w.key, r.key) <= c.distance {
+				c.union(w.id, r.id)
+			}
+		}
+
+		if len(window) == cap(window) {
+			copy(window, window[1:])
+			window = window[:len(window)-1]
+		}
+
+		window = append(window, r)
+		return nil
+	})
+}
+
+// emit sorts the IDs by cluster, and calls fn for every cluster of
+// at least two.
+func (c *ExternalCluster) emit(fn func([]*Entry) error) error {
+	s := c.newSorter()
+	defer s.close()
+
+	var id int
+	for id = range c.parent {
+		if err := s.add(record{uint64(c.find(uint32(id))), uint32(id)}); err != nil {
+			return err
+		}
+	}
+
+	var group []uint32
+	var root uint64
+
+	flush := func() error {
+		if len(group) < 2 {
+			return nil
+		}
+
+		out := make([]*Entry, len(group))
+		for i, id := range group {
+			e, err := c.entry(id)
+			if err != nil {
+				return err
+			}
+			out[i] = e
+		}
+
+		sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+		return fn(out)
+	}
+
+	err := s.sorted(func(r record) error {
+		if len(group) > 0 && r.key != root {
+			if err := flush(); err != nil {
+				return err
+			}
+			group = group[:0]
+		}
+
+		root = r.key
+		group = append(group, r.id)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// scan calls fn for every entry, in order of ID.
+func (c *ExternalCluster) scan(fn func(id uint32, offset, hash uint64) error) error {
+	r := bufio.NewReader(io.NewSectionReader(c.entries, 0, int64(len(c.parent))*16))
+
+	var entry [16]byte
+	var id int
+
+	for id = range c.parent {
+		if _, err := io.ReadFull(r, entry[:]); err != nil {
+			return err
+		}
+
+		err := fn(uint32(id), binary.BigEndian.Uint64(entry[:]), binary.BigEndian.Uint64(entry[8:]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// entry reads the path and hash of the given ID.
+func (c *ExternalCluster) entry(id uint32) (*Entry, error) {
+	var buf [16]byte
+	if _, err := c.entries.ReadAt(buf[:], int64(id)*16); err != nil {
+		return nil, err
+	}
+
+	offset := int64(binary.BigEndian.Uint64(buf[:]))
+	r := bufio.NewReader(io.NewSectionReader(c.paths, offset, int64(c.offset)-offset))
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([]byte, n)
+	if _, err := io.ReadFull(r, path); err != nil {
+		return nil, err
+	}
+
+	return &Entry{Path: string(path), Hash: binary.BigEndian.Uint64(buf[8:])}, nil
+}
+
+// find returns the root of the cluster of the given ID, which is
+// the first ID added to it.
+func (c *ExternalCluster) find(id uint32) uint32 {
+	for c.parent[id] != id {
+		c.parent[id] = c.parent[c.parent[id]]
+		id = c.parent[id]
+	}
+
+	return id
+}
+
+// union joins the clusters of a and b.
+func (c *ExternalCluster) union(a, b uint32) {
+	a, b = c.find(a), c.find(b)
+
+	switch {
+	case a < b:
+		c.parent[b] = a
+	case b < a:
+		c.parent[a] = b
+	}
+}
+
+// A record is sorted by its key, then its ID.
+type record struct {
+	key uint64
+	id  uint32
+}
+
+func (r record) less(o record) bool {
+	return r.key < o.key || r.key == o.key && r.id < o.id
+}
+
+// A sorter sorts records in runs of limited size, which are spilled
+// to disk and merged.
+type sorter struct {
+	dir  string
+	buf  []record
+	runs []*os.File
+}
+
+func (c *ExternalCluster) newSorter() *sorter {
+	return &sorter{dir: c.dir, buf: make([]record, 0, c.opts.RunSize)}
+}
+
+// add adds a record, and spills a run once the buffer is full.
+func (s *sorter) add(r record) error {
+	if len(s.buf) == cap(s.buf) {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	s.buf = append(s.buf, r)
+	return nil
+}
+
+// spill writes the buffered records to a run, sorted.
+func (s *sorter) spill() error {
+	sort.Slice(s.buf, func(i, j int) bool { return s.buf[i].less(s.buf[j]) })
+
+	fd, err := os.Create(filepath.Join(s.dir, "run"+strconv.Itoa(len(s.runs))))
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, fd)
+	w := bufio.NewWriter(fd)
+	enc := corpusEncoder{w: w, ids: true}
+
+	for _, r := range s.buf {
+		if err := enc.add(r.key, r.id); err != nil {
+			return err
+		}
+	}
+
+	if err := enc.close(); err != nil {
+		return err
+	}
+
+	s.buf = s.buf[:0]
+	return w.Flush()
+}
+
+// sorted calls fn for all records, in order.
+func (s *sorter) sorted(fn func(record) error) error {
+	if len(s.runs) == 0 {
+		sort.Slice(s.buf, func(i, j int) bool { return s.buf[i].less(s.buf[j]) })
+		for _, r := range s.buf {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(s.buf) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	var m runMerge
+	for _, fd := range s.runs {
+		if _, err := fd.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		run := &runReader{dec: corpusDecoder{r: bufio.NewReader(fd), ids: true}}
+		if ok, err := run.next(); err != nil {
+			return err
+		} else if ok {
+			m = append(m, run)
+		}
+	}
+
+	heap.Init(&m)
+	for len(m) > 0 {
+		run := m[0]
+		if err := fn(run.rec); err != nil {
+			return err
+		}
+
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(&m, 0)
+		} else {
+			heap.Pop(&m)
+		}
+	}
+
+	return nil
+}
+
+// close removes the runs.
+func (s *sorter) close() {
+	for _, fd := range s.runs {
+		fd.Close()
+		os.Remove(fd.Name())
+	}
+}
+
+// A runReader reads the records of a run. Runs are written in the
+// blocks of a corpus, with the ID of every record; a full run takes a
+// little over 9 bytes for each, down from 12.
+type runReader struct {
+	dec corpusDecoder
+	rec record
+}
+
+// next reads the next record. It returns false at the end of the run.
+func (r *runReader) next() (bool, error) {
+	key, id, err := r.dec.record()
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	r.rec = record{key, id}
+	return true, nil
+}
+
+// runMerge is a heap of runs, by their current record.
+type runMerge []*runReader
+
+func (m runMerge) Len() int            { return len(m) }
+func (m runMerge) Less(i, j int) bool  { return m[i].rec.less(m[j].rec) }
+func (m runMerge) Swap(i, j int)       { m[i], m[j] = m[j], m[i] }
+func (m *runMerge) Push(x interface{}) { *m = append(*m, x.(*runReader)) }
+
+func (m *runMerge) Pop() interface{} {
+	old := *m
+	r := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,105 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExternalCluster(t *testing.T) {
+	// Random hashes, each with a few near-duplicates.
+	rng := rand.New(rand.NewSource(1))
+
+	var entries []*Entry
+	var i, j int
+
+	for i = 0; i < 300; i++ {
+		hash := rng.Uint64()
+		for j = rng.Intn(3); j >= 0; j-- {
+			h := hash
+			for k := rng.Intn(4); k > 0; k-- {
+				h ^= 1 << uint(rng.Intn(64))
+			}
+
+			entries = append(entries, &Entry{Path: fmt.Sprintf("%03d/%d.jpg", i, j), Hash: h})
+		}
+	}
+
+	rng.Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+
+	var want [][]*Entry
+	for _, c := range Cluster(entries, 5) {
+		if len(c) > 1 {
+			want = append(want, c)
+		}
+	}
+
+	// Small runs make for many of them to merge.
+	dir := t.TempDir()
+	c, err := NewExternalCluster(5, &ExternalOptions{Dir: dir, RunSize: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range entries {
+		if err := c.Add(e.Path, e.Hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got [][]*Entry
+	if err := c.Clusters(func(g []*Entry) error { got = append(got, g); return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clusters come in the order of their first entry.
+	first := make(map[string]int, len(entries))
+	for i, e := range entries {
+		first[e.Path] = i
+	}
+
+	for i = 1; i < len(got); i++ {
+		if firstAdded(first, got[i-1]) > firstAdded(first, got[i]) {
+			t.Fatalf("cluster %d out of order", i)
+		}
+	}
+
+	index := make(map[string][]*Entry, len(got))
+	for _, g := range got {
+		index[g[0].Path] = g
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d clusters, want %d", len(got), len(want))
+	}
+
+	for _, w := range want {
+		if g := index[w[0].Path]; !reflect.DeepEqual(g, w) {
+			t.Fatalf("cluster of %s: got %d entries, want %d", w[0].Path, len(g), len(w))
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) > 0 {
+		t.Fatalf("%d files left after Close", len(files))
+	}
+}
+
+// firstAdded returns the lowest position of the entries of the cluster.
+func firstAdded(pos map[string]int, c []*Entry) int {
+	m := len(pos)
+	for _, e := range c {
+		if pos[e.Path] < m {
+			m = pos[e.Path]
+		}
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// A FailureKind classifies why a file could not be hashed.
+type FailureKind int
+
+// Known failure kinds.
+const (
+	FailureRead        FailureKind = iota // The file could not be opened or read.
+	FailureUnsupported                    // The file is not in a known image format.
+	FailureTruncated                      // The image data ends early.
+	FailureTooLarge                       // The image exceeds MaxPixels.
+	FailureDecode                         // The image data is corrupt.
+	FailureTooSmall                       // The image is smaller than MinSize.
+	FailureTimeout                        // Hashing took longer than BatchOptions.Timeout.
+	FailureLossy                          // The image takes a lossy conversion, with Strict strictness.
+	failureKinds
+)
+
+var failureNames = [...]string{"read", "unsupported", "truncated", "too large", "decode", "too small", "timeout", "lossy"}
+
+func (k FailureKind) String() string {
+	if k < 0 || k >= failureKinds {
+		return fmt.Sprintf("FailureKind(%d)", int(k))
+	}
+	return failureNames[k]
+}
+
+// A HashError is the error of a file which failed to hash in a batch.
+// Its message is that of the underlying error; errors.Is and errors.As
+// see through it.
+type HashError struct {
+	Kind FailureKind
+	Err  error
+}
+
+func (e *HashError) Error() string { return e.Err.Error() }
+func (e *HashError) Unwrap() error { return e.Err }
+
+// Classify returns the kind of failure err describes. Decoders in the
+// standard library do not all report truncated data the same way; those
+// which do not wrap io.ErrUnexpectedEOF are recognised by their message.
+func Classify(err error) FailureKind {
+	var he *HashError
+	var pe *fs.PathError
+
+	switch {
+	case errors.As(err, &he):
+		return he.Kind
+	case errors.Is(err, ErrTooLarge):
+		return FailureTooLarge
+	case errors.Is(err, ErrTooSmall):
+		return FailureTooSmall
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return FailureTimeout
+	case errors.Is(err, ErrLossy):
+		return FailureLossy
+	case errors.Is(err, ErrUnknownFormat), errors.Is(err, image.ErrFormat):
+		return FailureUnsupported
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, ErrIncomplete):
+		return FailureTruncated
+	case errors.As(err, &pe):
+		return FailureRead
+	}
+
+	msg := err.Error()
+	if strings.Contains(msg, "unexpected EOF") || strings.Contains(msg, "not enough pixel data") {
+		return FailureTruncated
+	}
+
+	return FailureDecode
+}
+
+// classify wraps err in a HashError, if it is not one already.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*HashError); ok {
+		return err
+	}
+
+	return &HashError{Classify(err), err}
+}
@@ -0,0 +1,89 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFailureKinds(t *testing.T) {
+	img := checkerboard(image.Rect(0, 0, 64, 64), 8)
+
+	var pngData, jpegData, gifData bytes.Buffer
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpegData, img, nil)
+	gif.Encode(&gifData, img, nil)
+
+	corrupt := append([]byte(nil), pngData.Bytes()...)
+	for i := len(corrupt) / 3; i < len(corrupt)/3+20; i++ {
+		corrupt[i] ^= 0x55
+	}
+
+	half := func(b *bytes.Buffer) []byte { return b.Bytes()[:b.Len()/2] }
+
+	fsys := fstest.MapFS{
+		"good.png":      {Data: pngData.Bytes()},
+		"truncated.png": {Data: half(&pngData)},
+		"truncated.jpg": {Data: half(&jpegData)},
+		"truncated.gif": {Data: half(&gifData)},
+		"corrupt.png":   {Data: corrupt},
+		"text.png":      {Data: []byte("not an image")},
+	}
+
+	want := map[string]FailureKind{
+		"truncated.png": FailureTruncated,
+		"truncated.jpg": FailureTruncated,
+		"truncated.gif": FailureTruncated,
+		"corrupt.png":   FailureDecode,
+		"text.png":      FailureUnsupported,
+	}
+
+	var last Progress
+	opts := &BatchOptions{OnProgress: func(p Progress) { last = p }}
+
+	for r := range HashFS(context.Background(), fsys, Average, opts) {
+		if r.Path == "good.png" {
+			if r.Err != nil {
+				t.Fatalf("%s: %v", r.Path, r.Err)
+			}
+			continue
+		}
+
+		var he *HashError
+		if !errors.As(r.Err, &he) || he.Kind != want[r.Path] {
+			t.Fatalf("%s: got %v (%v), want %v", r.Path, Classify(r.Err), r.Err, want[r.Path])
+		}
+	}
+
+	if last.Failed != 5 || last.Failures[FailureTruncated] != 3 || last.Failures[FailureUnsupported] != 1 {
+		t.Fatalf("progress %+v", last)
+	}
+
+	defer func(max int) { MaxPixels = max }(MaxPixels)
+	MaxPixels = 100
+
+	_, err := ComputeReader(bytes.NewReader(pngData.Bytes()), Average)
+	if Classify(err) != FailureTooLarge {
+		t.Fatalf("large image: %v", err)
+	}
+
+	_, err = os.Open("testdata/missing.png")
+	if Classify(err) != FailureRead {
+		t.Fatalf("missing file: %v", err)
+	}
+
+	if Classify(fmt.Errorf("wrapped: %w", &HashError{FailureTooLarge, err})) != FailureTooLarge {
+		t.Fatal("wrapped HashError not classified by its kind")
+	}
+}
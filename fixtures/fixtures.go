@@ -0,0 +1,188 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package fixtures holds reference images and the hashes they are expected
+to produce. The test in this package checks all of them, so any change
+to the output of an algorithm is caught, rather than silently breaking
+hashes stored by users.
+
+The images cover the decoding paths of the package: PNG, JPEG and GIF,
+16-bit grayscale, paletted images and transparency. The vectors are kept
+in a plain text file, vectors.txt, so implementations in other languages
+can be checked against them too. Each line holds an algorithm, an image
+and its hash in hexadecimal:
+
+	average gopher_large.png 0838787c7c3e3c18
+
+Besides the algorithms of this package, there are vectors for the
+compatibility modes of the compat package, under the names they are
+registered by: libphash, imagehash-average, imagehash-phash,
+imagehash-dhash and blockhash. These were computed by the compat
+package; they hold it to its current output. Agreement with the
+libraries themselves is checked separately, by the parity suite of
+compat/libphash for libpHash.
+
+Applications which store or compare hashes can test against real
+values with the images of this package, without keeping images of
+their own. Besides the files, it builds checkerboards and gradients in
+code. Names lists all of them, Image and Bytes return each decoded or
+encoded, and Hash its hash:
+
+	func TestUpload(t *testing.T) {
+		want, _ := fixtures.Hash("average", "gopher.jpg")
+		resp := upload(t, fixtures.Bytes("gopher.jpg"))
+		if resp.Hash != want {
+			...
+		}
+	}
+*/
+package fixtures
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed images vectors.txt
+var files embed.FS
+
+// A Vector is the expected hash of a reference image.
+type Vector struct {
+	Algorithm string // Name of the algorithm, like "average".
+	Image     string // Name of the image in Images.
+	Hash      uint64
+}
+
+// Images returns the reference images.
+func Images() fs.FS {
+	sub, err := fs.Sub(files, "images")
+	if err != nil {
+		panic(err)
+	}
+
+	return sub
+}
+
+// Vectors returns all test vectors.
+func Vectors() []Vector {
+	data, err := files.ReadFile("vectors.txt")
+	if err != nil {
+		panic(err)
+	}
+
+	vectors, err := parseVectors(data)
+	if err != nil {
+		panic(err)
+	}
+
+	return vectors
+}
+
+// Names returns the names of all images, those in Images and those
+// built in code, sorted.
+func Names() []string {
+	names, err := fs.Glob(Images(), "*")
+	if err != nil {
+		panic(err)
+	}
+
+	for name := range generated {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Bytes returns the named image, encoded. Images built in code are
+// encoded as PNG. It panics if there is no such image.
+func Bytes(name string) []byte {
+	if g, ok := generated[name]; ok {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, g.image()); err != nil {
+			panic(err)
+		}
+		return buf.Bytes()
+	}
+
+	data, err := fs.ReadFile(Images(), name)
+	if err != nil {
+		panic(fmt.Sprintf("fixtures: unknown image %q", name))
+	}
+
+	return data
+}
+
+// Image returns the named image, decoded. It panics if there is no such
+// image.
+func Image(name string) image.Image {
+	if g, ok := generated[name]; ok {
+		return g.image()
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(Bytes(name)))
+	if err != nil {
+		panic(fmt.Sprintf("fixtures: %s: %v", name, err))
+	}
+
+	return img
+}
+
+// Hash returns the hash of the named image by the given algorithm, and
+// false if there is none.
+func Hash(algorithm, name string) (uint64, bool) {
+	if g, ok := generated[name]; ok {
+		hash, ok := g.hashes[algorithm]
+		return hash, ok
+	}
+
+	for _, v := range Vectors() {
+		if v.Algorithm == algorithm && v.Image == name {
+			return v.Hash, true
+		}
+	}
+
+	return 0, false
+}
+
+// parseVectors parses the contents of vectors.txt. Empty lines
+// and lines starting with # are ignored.
+func parseVectors(data []byte) ([]Vector, error) {
+	var vectors []Vector
+	var line int
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line++
+
+		text := strings.TrimSpace(s.Text())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("fixtures: line %d: expected 3 fields", line)
+		}
+
+		hash, err := strconv.ParseUint(fields[2], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: line %d: %v", line, err)
+		}
+
+		vectors = append(vectors, Vector{fields[0], fields[1], hash})
+	}
+
+	return vectors, s.Err()
+}
@@ -0,0 +1,147 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package fixtures
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/compat"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite vectors.txt with the current hashes.")
+
+// algorithms lists the algorithms with test vectors, compatibility
+// modes included.
+var algorithms = map[string]imghash.HashFunc{
+	"average":           imghash.Average,
+	"blockhash":         compat.Blockhash,
+	"imagehash-average": compat.ImageHashAverage,
+	"imagehash-dhash":   compat.ImageHashDHash,
+	"imagehash-phash":   compat.ImageHashPHash,
+	"libphash":          compat.PHash,
+}
+
+func TestVectors(t *testing.T) {
+	images := Images()
+	vectors := Vectors()
+
+	if *update {
+		updateVectors(t, images)
+		return
+	}
+
+	// Every image must be covered by every algorithm.
+	names, err := fs.Glob(images, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := len(names) * len(algorithms); len(vectors) != want {
+		t.Fatalf("%d vectors, want %d; run go test -update", len(vectors), want)
+	}
+
+	for _, v := range vectors {
+		hf, ok := algorithms[v.Algorithm]
+		if !ok {
+			t.Fatalf("%s: unknown algorithm %q", v.Image, v.Algorithm)
+		}
+
+		hash := hashImage(t, images, v.Image, hf)
+		if hash != v.Hash {
+			t.Errorf("%s %s: hash %016x, want %016x", v.Algorithm, v.Image, hash, v.Hash)
+		}
+	}
+}
+
+func TestGenerated(t *testing.T) {
+	for name, g := range generated {
+		for algo, want := range g.hashes {
+			hf, ok := algorithms[algo]
+			if !ok {
+				t.Fatalf("%s: unknown algorithm %q", name, algo)
+			}
+
+			if hash := hf(Image(name)); hash != want {
+				t.Errorf("%s %s: hash %016x, want %016x", algo, name, hash, want)
+			}
+
+			// The encoded image hashes the same.
+			hash, err := imghash.ComputeBytes(Bytes(name), hf)
+			if err != nil || hash != want {
+				t.Errorf("%s %s: encoded hash %016x, %v", algo, name, hash, err)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	names := Names()
+	if len(names) != len(Vectors())/len(algorithms)+len(generated) || !sort.StringsAreSorted(names) {
+		t.Fatalf("names %q", names)
+	}
+
+	for _, name := range names {
+		want, ok := Hash("average", name)
+		if !ok {
+			t.Fatalf("%s: no hash", name)
+		}
+
+		if hash := imghash.Average(Image(name)); hash != want {
+			t.Errorf("%s: decoded image hash %016x, want %016x", name, hash, want)
+		}
+	}
+
+	if _, ok := Hash("document", "gopher.jpg"); ok {
+		t.Fatalf("hash for an algorithm without vectors")
+	}
+}
+
+func hashImage(t *testing.T, images fs.FS, name string, hf imghash.HashFunc) uint64 {
+	data, err := fs.ReadFile(images, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := imghash.ComputeBytes(data, hf)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	return hash
+}
+
+// updateVectors rewrites vectors.txt. Only do this for intended changes
+// in the output of an algorithm; stored hashes will no longer match.
+func updateVectors(t *testing.T, images fs.FS) {
+	names, err := fs.Glob(images, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Algorithm, image and expected hash. Generated by go test -update.\n")
+
+	algos := make([]string, 0, len(algorithms))
+	for algo := range algorithms {
+		algos = append(algos, algo)
+	}
+
+	sort.Strings(algos)
+
+	for _, algo := range algos {
+		for _, name := range names {
+			fmt.Fprintf(&sb, "%s %s %016x\n", algo, name, hashImage(t, images, name, algorithms[algo]))
+		}
+	}
+
+	if err := os.WriteFile("vectors.txt", []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package fixtures
+
+import (
+	"image"
+	"image/color"
+)
+
+// generated lists the images built in code, by name, and their hashes
+// by algorithm. They take no space in the module, and do not depend on
+// an encoder.
+var generated = map[string]struct {
+	image  func() image.Image
+	hashes map[string]uint64
+}{
+	"checkerboard": {
+		func() image.Image { return Checkerboard(64, 8) },
+		map[string]uint64{
+			"average":           0x55aa55aa55aa55aa,
+			"blockhash":         0x55aa55aa55aa55aa,
+			"imagehash-average": 0x55aa55aa55aa55aa,
+			"imagehash-dhash":   0xa55aa55aa55aa55a,
+			"imagehash-phash":   0xd7aa55822aa2fda2,
+			"libphash":          0x7f80af80afa8aba8,
+		},
+	},
+	"checkerboard_coarse": {
+		func() image.Image { return Checkerboard(64, 2) },
+		map[string]uint64{
+			"average":           0x0f0f0f0ff0f0f0f0,
+			"blockhash":         0x0f0f0f0ff0f0f0f0,
+			"imagehash-average": 0x0f0f0f0ff0f0f0f0,
+			"imagehash-dhash":   0x5a5a185a24002424,
+			"imagehash-phash":   0xd71919e6669191e6,
+			"libphash":          0x6699996666999966,
+		},
+	},
+	"gradient": {
+		func() image.Image { return Gradient(64, 64, false) },
+		map[string]uint64{
+			"average":           0xf0f0f0f0f0f0f0f0,
+			"blockhash":         0x0f0f0f0f0f0f0f0f,
+			"imagehash-average": 0x0f0f0f0f0f0f0f0f,
+			"imagehash-dhash":   0xffffffffffffffff,
+			"imagehash-phash":   0x82bb5a8a53b68da5,
+			"libphash":          0,
+		},
+	},
+	"gradient_vertical": {
+		func() image.Image { return Gradient(64, 64, true) },
+		map[string]uint64{
+			"average":           0xffffffff00000000,
+			"blockhash":         0x00ff00ff00ff00ff,
+			"imagehash-average": 0x00000000ffffffff,
+			"imagehash-dhash":   0,
+			"imagehash-phash":   0xd7287a687920ff08,
+			"libphash":          0,
+		},
+	},
+}
+
+// Checkerboard returns a square image of the given size, with cells by
+// cells squares of black and white, black in the top left corner.
+func Checkerboard(size, cells int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x*cells/size+y*cells/size)%2 == 1 {
+				img.Pix[img.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+
+	return img
+}
+
+// Gradient returns an image which goes from black to white, from left
+// to right, or from top to bottom if vertical is set.
+func Gradient(w, h int, vertical bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 0xff * x / max(w-1, 1)
+			if vertical {
+				v = 0xff * y / max(h-1, 1)
+			}
+
+			img.SetGray(x, y, color.Gray{uint8(v)})
+		}
+	}
+
+	return img
+}
@@ -0,0 +1,30 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+// Fold32 derives a 32 bit prefilter code from a hash, by XORing its
+// upper and lower halves. It is meant for cheap bucketing and filtering
+// in systems which can not index 64 bits efficiently.
+//
+// Each bit of the folded code differs only if an odd number of the bits
+// folded onto it differ, so the distance between two folded codes never
+// exceeds the distance between the full hashes:
+//
+//	Distance(uint64(Fold32(a)), uint64(Fold32(b))) <= Distance(a, b)
+//
+// A search for hashes within distance d can therefore first discard all
+// codes further than d apart, without missing any matches. The reverse
+// does not hold: unrelated hashes may fold onto nearby codes, so the
+// remaining candidates must be compared in full.
+func Fold32(hash uint64) uint32 {
+	return uint32(hash ^ hash>>32)
+}
+
+// Fold16 derives a 16 bit prefilter code from a hash, by folding it
+// twice. The same guarantee as for Fold32 applies, but it discards
+// more information, so more candidates remain to be compared in full.
+func Fold16(hash uint64) uint16 {
+	v := Fold32(hash)
+	return uint16(v ^ v>>16)
+}
@@ -0,0 +1,34 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	if v := Fold32(0xffff0000_0000ffff); v != 0xffffffff {
+		t.Fatalf("Fold32: %08x", v)
+	}
+
+	if v := Fold16(0x0001_0002_0004_0008); v != 0x000f {
+		t.Fatalf("Fold16: %04x", v)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		a := rng.Uint64()
+		b := a ^ (rng.Uint64() & rng.Uint64() & rng.Uint64())
+		d := Distance(a, b)
+
+		if f := Distance(uint64(Fold32(a)), uint64(Fold32(b))); f > d {
+			t.Fatalf("Fold32 distance %d exceeds %d", f, d)
+		}
+
+		if f := Distance(uint64(Fold16(a)), uint64(Fold16(b))); f > d {
+			t.Fatalf("Fold16 distance %d exceeds %d", f, d)
+		}
+	}
+}
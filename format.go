@@ -0,0 +1,36 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "encoding/binary"
+
+const hexDigits = "0123456789abcdef"
+
+// AppendHash appends the hash to dst as 16 lower case hexadecimal digits,
+// as the %016x verb of fmt formats it, and returns the extended slice.
+// The output does not depend on the locale, and nothing is allocated if
+// dst has room for it. Use it over fmt where hashes are written in bulk,
+// as in logs and files of records.
+func AppendHash(dst []byte, hash uint64) []byte {
+	var buf [16]byte
+
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = hexDigits[hash&0xf]
+		hash >>= 4
+	}
+
+	return append(dst, buf[:]...)
+}
+
+// AppendHashBinary appends the hash to dst as 8 big endian bytes, and
+// returns the extended slice.
+func AppendHashBinary(dst []byte, hash uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, hash)
+}
+
+// FormatHash returns the hash as AppendHash writes it.
+func FormatHash(hash uint64) string {
+	var buf [16]byte
+	return string(AppendHash(buf[:0], hash))
+}
@@ -0,0 +1,67 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestAppendHash(t *testing.T) {
+	for _, h := range []uint64{0, 1, 0xf, 0xdeadbeef, 0x0123456789abcdef, 1<<63 | 1, ^uint64(0)} {
+		want := fmt.Sprintf("%016x", h)
+
+		if got := string(AppendHash([]byte("x"), h)); got != "x"+want {
+			t.Fatalf("%#x: %q, want %q", h, got, "x"+want)
+		}
+
+		if got := FormatHash(h); got != want {
+			t.Fatalf("%#x: %q, want %q", h, got, want)
+		}
+
+		if got := AppendHashBinary(nil, h); binary.BigEndian.Uint64(got) != h || len(got) != 8 {
+			t.Fatalf("%#x: binary %x", h, got)
+		}
+	}
+
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = AppendHash(buf[:0], 0x0123456789abcdef)
+		buf = AppendHashBinary(buf, 0x0123456789abcdef)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("%v allocations", allocs)
+	}
+
+	m := MultiHash{1, 0xfedcba9876543210}
+	text, _ := m.AppendText([]byte("m="))
+	if string(text) != "m="+m.String() || m.String() != "0000000000000001fedcba9876543210" {
+		t.Fatalf("multi-hash text %q", text)
+	}
+
+	data, _ := m.MarshalBinary()
+	if b, _ := m.AppendBinary([]byte{9}); !bytes.Equal(b, append([]byte{9}, data...)) {
+		t.Fatalf("multi-hash binary %x", b)
+	}
+
+	var h Hash1024
+	h[0], h[15] = 0xab, 1<<63
+	if s, err := ParseHash1024(h.String()); err != nil || s != h {
+		t.Fatalf("1024 bit round trip: %v", err)
+	}
+
+	if b, _ := h.AppendBinary(nil); len(b) != 128 || binary.BigEndian.Uint64(b[120:]) != h[15] {
+		t.Fatalf("1024 bit binary %x", b)
+	}
+}
+
+func BenchmarkAppendHash(b *testing.B) {
+	buf := make([]byte, 0, 16)
+	for i := 0; i < b.N; i++ {
+		buf = AppendHash(buf[:0], uint64(i)*0x9e3779b97f4a7c15)
+	}
+}
@@ -0,0 +1,121 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash/fixtures"
+	"image"
+	"image/color"
+	"io/fs"
+	"math"
+	"testing"
+)
+
+// fuzzMaxPixels limits decoded images during fuzzing, so inputs
+// claiming huge dimensions do not exhaust memory.
+const fuzzMaxPixels = 1 << 20
+
+// limitPixels sets MaxPixels to fuzzMaxPixels, until the fuzz test ends.
+func limitPixels(f *testing.F) {
+	max := MaxPixels
+	MaxPixels = fuzzMaxPixels
+	f.Cleanup(func() { MaxPixels = max })
+}
+
+// addImageSeeds adds the reference images to the fuzz corpus.
+func addImageSeeds(f *testing.F) {
+	images := fixtures.Images()
+
+	names, err := fs.Glob(images, "*")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, name := range names {
+		data, err := fs.ReadFile(images, name)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(data)
+	}
+}
+
+func FuzzComputeBytes(f *testing.F) {
+	addImageSeeds(f)
+	f.Add(makeProgressiveJPEG(blockPattern(1).(*image.Gray)))
+	for _, profile := range malformedProfiles() {
+		f.Add(pngWithProfile(blockPattern(1), profile))
+	}
+	limitPixels(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ComputeBytes(data, Average)
+		ComputeReaderAt(bytes.NewReader(data), int64(len(data)), Average, &ReaderAtOptions{DCOnly: true})
+		ExtractPreview(data)
+		ColorProfile(data)
+
+		p := NewProgressiveHasher(Average)
+		p.Write(data[:len(data)/2])
+		p.Hash()
+		p.Write(data[len(data)/2:])
+		p.Hash()
+	})
+}
+
+func FuzzDecodeAnimation(f *testing.F) {
+	addImageSeeds(f)
+	rect := image.Rect(0, 0, 32, 32)
+	frames := []image.Image{checkerboard(rect, 8), checkerboard(rect, 4)}
+	f.Add(makeGIF(f, frames, rect, 2, 10))
+	f.Add(makeAPNG(f, frames, rect, 10))
+	limitPixels(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frames, err := DecodeAnimation(bytes.NewReader(data))
+		if err == nil {
+			HashAnimation(frames, Average)
+		}
+	})
+}
+
+func FuzzHashers(f *testing.F) {
+	f.Add(0, 0, 64, 48, int64(1))
+	f.Add(-10, -20, 10, 20, int64(2))
+	f.Add(5, 5, 5, 5, int64(3))
+	f.Add(10, 10, 0, 0, int64(4))
+	f.Add(0, 0, 1, 1<<20, int64(5))
+	f.Add(math.MinInt, math.MinInt, math.MaxInt, math.MaxInt, int64(6))
+	f.Add(math.MinInt/4, math.MinInt/4, math.MaxInt/4, math.MaxInt/4, int64(7))
+
+	mask := ExcludeRects(image.Rect(0, 0, 16, 16), image.Rect(0, 0, 4, 4))
+
+	f.Fuzz(func(t *testing.T, x0, y0, x1, y1 int, seed int64) {
+		img := &fuzzImage{image.Rectangle{image.Pt(x0, y0), image.Pt(x1, y1)}, uint64(seed)}
+
+		Average(img)
+		AverageCells(img)
+		AverageMask(mask)(img)
+		AverageMask(img)(mask)
+		GridFeatures(img)
+	})
+}
+
+// fuzzImage is an image with arbitrary bounds, which need not be
+// well-formed. It returns invalid colours, with components
+// exceeding alpha, for some pixels.
+type fuzzImage struct {
+	rect image.Rectangle
+	seed uint64
+}
+
+func (m *fuzzImage) ColorModel() color.Model { return color.RGBA64Model }
+func (m *fuzzImage) Bounds() image.Rectangle { return m.rect }
+
+func (m *fuzzImage) At(x, y int) color.Color {
+	v := (uint64(x)*0x9e3779b97f4a7c15 ^ uint64(y)*0xc2b2ae3d27d4eb4f) + m.seed
+	v ^= v >> 29
+	return color.RGBA64{uint16(v), uint16(v >> 16), uint16(v >> 32), uint16(v >> 48)}
+}
@@ -0,0 +1,75 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// ErrInvalidGrid is returned by Grid.Image for grids whose tiles do
+// not fit together.
+var ErrInvalidGrid = errors.New("imghash: invalid tile grid")
+
+// A Grid is an image stored as a grid of tiles. HEIC and AVIF files
+// store photos this way: iPhones write a 4032x3024 photo as 48 tiles of
+// 512x512 pixels, with the primary image of the file a grid which
+// references them. Decoders which only return the first tile, as many
+// HEIC libraries do by default, make the hash of a photo that of its
+// top left corner. Set DecodeGrid on a Decoder to hash the whole canvas.
+type Grid struct {
+	Columns, Rows int           // Number of tiles across and down.
+	Tiles         []image.Image // Tiles of equal size, row by row.
+
+	// Size of the canvas. The tiles on the right and bottom edges
+	// overlap it when it is not a multiple of the tile size, and the
+	// overlap is cropped. Zero values cover all tiles.
+	Width, Height int
+}
+
+// Image returns the canvas, with the tiles put together. It fails with
+// ErrInvalidGrid if the tiles differ in size, do not match the number
+// of columns and rows, or do not cover the canvas, and with ErrTooLarge
+// for canvases of more than MaxPixels pixels.
+func (g *Grid) Image() (image.Image, error) {
+	if g.Columns <= 0 || g.Rows <= 0 || len(g.Tiles) != g.Columns*g.Rows {
+		return nil, ErrInvalidGrid
+	}
+
+	size := g.Tiles[0].Bounds().Size()
+	for _, tile := range g.Tiles[1:] {
+		if tile.Bounds().Size() != size {
+			return nil, ErrInvalidGrid
+		}
+	}
+
+	w, h := g.Width, g.Height
+	if w == 0 {
+		w = g.Columns * size.X
+	}
+
+	if h == 0 {
+		h = g.Rows * size.Y
+	}
+
+	if w < 0 || h < 0 || w > g.Columns*size.X || h > g.Rows*size.Y {
+		return nil, ErrInvalidGrid
+	}
+
+	if err := checkPixels(w, h, 1); err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	var i int
+	for i = range g.Tiles {
+		tile := g.Tiles[i]
+		at := image.Pt(i%g.Columns*size.X, i/g.Columns*size.Y)
+		draw.Draw(canvas, image.Rectangle{at, at.Add(size)}, tile, tile.Bounds().Min, draw.Src)
+	}
+
+	return canvas, nil
+}
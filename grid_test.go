@@ -0,0 +1,72 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestGrid(t *testing.T) {
+	// A 60x50 canvas, in 2x2 tiles of 32x32 pixels. The tiles on the
+	// right and bottom edge hold padding beyond the canvas.
+	src := blockPattern(7)
+	canvas := image.Rect(0, 0, 60, 50)
+
+	var tiles []image.Image
+	var x, y int
+
+	for y = 0; y < 2; y++ {
+		for x = 0; x < 2; x++ {
+			tile := image.NewGray(image.Rect(0, 0, 32, 32))
+			for i := range tile.Pix {
+				px, py := x*32+i%32, y*32+i/32
+				if image.Pt(px, py).In(canvas) {
+					tile.Pix[i] = src.(*image.Gray).GrayAt(px, py).Y
+				} else {
+					tile.Pix[i] = 0xff
+				}
+			}
+			tiles = append(tiles, tile)
+		}
+	}
+
+	defer func(d []*Decoder) { decoders = d }(decoders)
+	RegisterDecoder(&Decoder{
+		Name:  "heic",
+		Magic: "????ftypheic",
+		Decode: func(io.Reader) (image.Image, error) {
+			return tiles[0], nil
+		},
+		DecodeGrid: func(io.Reader) (*Grid, error) {
+			return &Grid{Columns: 2, Rows: 2, Tiles: tiles, Width: 60, Height: 50}, nil
+		},
+	})
+
+	img, err := Decode(bytes.NewReader([]byte("\x00\x00\x00\x18ftypheic")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds() != canvas {
+		t.Fatalf("got bounds %v, want %v", img.Bounds(), canvas)
+	}
+
+	want := src.(*image.Gray).SubImage(canvas)
+	if hash, want := Average(img), Average(want); hash != want {
+		t.Fatalf("hash %016x, want %016x", hash, want)
+	}
+
+	for _, g := range []*Grid{
+		{Columns: 2, Rows: 1, Tiles: tiles},
+		{Columns: 2, Rows: 2, Tiles: tiles, Width: 65},
+		{Columns: 2, Rows: 2, Tiles: append(tiles[:3:3], src)},
+	} {
+		if _, err := g.Image(); err != ErrInvalidGrid {
+			t.Fatalf("%dx%d grid: got %v, want ErrInvalidGrid", g.Columns, g.Rows, err)
+		}
+	}
+}
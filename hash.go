@@ -3,9 +3,19 @@
 
 package imghash
 
-import "image"
+import (
+	"fmt"
+	"image"
+	"strings"
+)
 
 // A HashFunc computes a Perceptual Hash for a given image.
+//
+// Images smaller than the grid a hash is computed on are scaled up, with
+// each pixel covering one or more whole cells. Such a hash is only as
+// detailed as the image: a 1x1 image is flat, and hashes as any other
+// flat image does. An image without any pixels hashes as a black one.
+// Decode rejects images too small to be worth hashing; refer to MinSize.
 type HashFunc func(image.Image) uint64
 
 // Distance calculates the Hamming Distance between the two input hashes.
@@ -18,3 +28,71 @@ func Distance(a, b uint64) uint64 {
 
 	return dist
 }
+
+// Similarity returns the similarity between the two hashes, in the
+// range 0-1. Identical hashes have a similarity of 1.
+func Similarity(a, b uint64) float64 {
+	return 1 - float64(Distance(a, b))/64
+}
+
+// A Verdict classifies the relation between two images.
+type Verdict int
+
+// Known verdicts.
+const (
+	Distinct Verdict = iota
+	NearDuplicate
+	Duplicate
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Duplicate:
+		return "duplicate"
+	case NearDuplicate:
+		return "near-duplicate"
+	}
+
+	return "distinct"
+}
+
+// MarshalText implements encoding.TextMarshaler, writing the verdict by
+// name.
+func (v Verdict) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (v *Verdict) UnmarshalText(text []byte) error {
+	for _, c := range []Verdict{Distinct, NearDuplicate, Duplicate} {
+		if strings.EqualFold(string(text), c.String()) {
+			*v = c
+			return nil
+		}
+	}
+
+	return fmt.Errorf("imghash: unknown verdict %q", text)
+}
+
+// Thresholds define the maximum Hamming Distances at which two hashes
+// are considered duplicates and near-duplicates of each other. Each
+// hashing algorithm has its own recommended values.
+type Thresholds struct {
+	Duplicate     uint64 // Maximum distance for duplicates.
+	NearDuplicate uint64 // Maximum distance for near-duplicates.
+}
+
+// AverageThresholds holds the recommended thresholds for Average hashes.
+var AverageThresholds = Thresholds{Duplicate: 3, NearDuplicate: 9}
+
+// Classify returns the verdict for the given Hamming Distance.
+func (t Thresholds) Classify(dist uint64) Verdict {
+	switch {
+	case dist <= t.Duplicate:
+		return Duplicate
+	case dist <= t.NearDuplicate:
+		return NearDuplicate
+	}
+
+	return Distinct
+}
@@ -0,0 +1,128 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"image"
+	"sort"
+	"strconv"
+)
+
+// ErrInvalidHash1024 is returned when parsing a malformed Hash1024.
+var ErrInvalidHash1024 = errors.New("imghash: invalid 1024 bit hash")
+
+// A Hash1024 is a 1024 bit Perceptual Hash, stored as 16 words.
+// Bit i of the hash is bit i%64 of word i/64.
+type Hash1024 [16]uint64
+
+// Average1024Thresholds holds the recommended thresholds for
+// Average1024 hashes.
+var Average1024Thresholds = Thresholds{Duplicate: 48, NearDuplicate: 144}
+
+// Average1024 computes a 1024 bit hash from a 32x32 grid, for archives
+// where false positives are unacceptable. With 64 bits, unrelated images
+// collide too often once a collection reaches hundreds of millions of
+// images. The finer grid also separates images which differ in smaller
+// details.
+//
+// Each cell is compared against the median of all cells, rather than
+// their mean, the way blockhash does. This sets half of all bits for
+// every image, which keeps the hashes of unrelated images far apart.
+//
+// Hashing takes longer than Average, and the hash is somewhat more
+// sensitive to cropping and rotation.
+func Average1024(img image.Image) Hash1024 {
+	img = resize(img, 32, 32)
+	img = grayscale(img)
+	cells := gridValues(img)
+
+	sorted := append([]uint32(nil), cells...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+
+	var h Hash1024
+	for bit, v := range cells {
+		if v > median {
+			h[bit/64] |= 1 << uint(bit%64)
+		}
+	}
+
+	return h
+}
+
+// Distance1024 calculates the Hamming Distance between two 1024 bit hashes.
+func Distance1024(a, b Hash1024) uint64 {
+	var dist uint64
+
+	for i := range a {
+		dist += Distance(a[i], b[i])
+	}
+
+	return dist
+}
+
+// Fold64 folds the hash into 64 bits, by XORing all its words. This
+// makes the hash usable with Index, Database and everything else which
+// handles 64 bit hashes.
+//
+// As with Fold32, folded hashes are never further apart than the full
+// hashes. An index of folded hashes, queried with the full threshold,
+// returns every match along with some false candidates, which are then
+// discarded by comparing the full hashes with Distance1024.
+func (h Hash1024) Fold64() uint64 {
+	var v uint64
+
+	for _, w := range h {
+		v ^= w
+	}
+
+	return v
+}
+
+// String returns the hash as a 256 digit hexadecimal string, with the
+// first word first.
+func (h Hash1024) String() string {
+	var buf [256]byte
+	b, _ := h.AppendText(buf[:0])
+	return string(b)
+}
+
+// AppendText implements encoding.TextAppender, appending the format
+// written by String to b.
+func (h Hash1024) AppendText(b []byte) ([]byte, error) {
+	for _, w := range h {
+		b = AppendHash(b, w)
+	}
+	return b, nil
+}
+
+// AppendBinary implements encoding.BinaryAppender, appending the words
+// to b as 8 byte, big endian values, the first word first.
+func (h Hash1024) AppendBinary(b []byte) ([]byte, error) {
+	for _, w := range h {
+		b = AppendHashBinary(b, w)
+	}
+	return b, nil
+}
+
+// ParseHash1024 parses a hash in the format written by String.
+func ParseHash1024(s string) (Hash1024, error) {
+	var h Hash1024
+
+	if len(s) != 16*len(h) {
+		return h, ErrInvalidHash1024
+	}
+
+	for i := range h {
+		w, err := strconv.ParseUint(s[16*i:16*i+16], 16, 64)
+		if err != nil {
+			return h, ErrInvalidHash1024
+		}
+
+		h[i] = w
+	}
+
+	return h, nil
+}
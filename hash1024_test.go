@@ -0,0 +1,47 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"testing"
+)
+
+func TestAverage1024(t *testing.T) {
+	a := synth.Shapes(320, 240, 1)
+	b := synth.Shapes(320, 240, 2)
+	ha, hb := Average1024(a), Average1024(b)
+
+	// The median splits the cells in half, give or take ties.
+	var ones uint64
+	for _, w := range ha {
+		ones += Distance(w, 0)
+	}
+
+	if ones == 0 || ones > 512 {
+		t.Fatalf("%d bits set", ones)
+	}
+
+	if d := Distance1024(ha, Average1024(attack.Resize(0.5).Apply(a))); d > Average1024Thresholds.Duplicate {
+		t.Fatalf("distance to resized copy %d", d)
+	}
+
+	if d := Distance1024(ha, hb); d <= Average1024Thresholds.NearDuplicate {
+		t.Fatalf("distance to unrelated image %d", d)
+	}
+
+	if Distance(ha.Fold64(), hb.Fold64()) > Distance1024(ha, hb) {
+		t.Fatal("folded distance exceeds full distance")
+	}
+
+	p, err := ParseHash1024(ha.String())
+	if err != nil || p != ha {
+		t.Fatalf("parse: %v, %v", p, err)
+	}
+
+	if _, err := ParseHash1024(ha.String()[1:]); err != ErrInvalidHash1024 {
+		t.Fatalf("parse truncated: %v", err)
+	}
+}
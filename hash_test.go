@@ -4,9 +4,21 @@
 package imghash
 
 import (
+	"bytes"
+	"errors"
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
 	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
 	"image/png"
+	"io"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -21,12 +33,30 @@ func TestResize(t *testing.T) {
 
 	img = resize(img, 32, 32)
 
-	err = saveImg(img, "testdata/gopher_32x32.png")
+	err = saveImg(img, filepath.Join(t.TempDir(), "gopher_32x32.png"))
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestPaletted(t *testing.T) {
+	src := synth.Gradient(100, 80, 1)
+	img := image.NewPaletted(image.Rect(7, 3, 107, 83), palette.Plan9)
+	draw.FloydSteinberg.Draw(img, img.Rect, src, image.Point{})
+
+	// Hide the concrete type, to take the generic path.
+	generic := struct{ image.Image }{img}
+
+	a, b := resize(img, 16, 16).(*image.RGBA64), resize(generic, 16, 16).(*image.RGBA64)
+	if !bytes.Equal(a.Pix, b.Pix) {
+		t.Fatal("resized Paletted image differs from the generic path")
+	}
+
+	if !bytes.Equal(luminance(img).Pix, luminance(generic).Pix) {
+		t.Fatal("luminance of Paletted image differs from the generic path")
+	}
+}
+
 func TestAverage(t *testing.T) {
 	a := getHash(t, Average, "testdata/gopher_large.png")
 	b := getHash(t, Average, "testdata/gopher_small.png")
@@ -37,6 +67,209 @@ func TestAverage(t *testing.T) {
 	}
 }
 
+func TestRobustness(t *testing.T) {
+	img, err := loadImg("testdata/gopher_large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Edits which leave the image content in place should
+	// not affect the hash. Crops and rotations do.
+	want := Average(img)
+	for _, a := range []attack.Transform{
+		attack.JPEG(50),
+		attack.Resize(0.25),
+		attack.Resize(2),
+		attack.Rotate(1),
+		attack.Noise(16, 1),
+		attack.Brightness(0.1),
+		attack.Contrast(0.8),
+		attack.Watermark(0.3, 0.5),
+		attack.Chain(attack.Resize(0.5), attack.JPEG(75)),
+	} {
+		if d := Distance(want, Average(a.Apply(img))); d > MaxDistance {
+			t.Errorf("%s: distance %d", a.Name, d)
+		}
+	}
+}
+
+func TestHighBitDepth(t *testing.T) {
+	rect := image.Rect(0, 0, 100, 75)
+	g8 := image.NewGray(rect)
+	g16 := image.NewGray16(rect)
+	c64 := image.NewRGBA64(rect)
+
+	var x, y int
+	var v uint8
+	for y = 0; y < rect.Max.Y; y++ {
+		for x = 0; x < rect.Max.X; x++ {
+			v = uint8((x*x + 3*y) % 251)
+			g8.SetGray(x, y, color.Gray{v})
+			g16.SetGray16(x, y, color.Gray16{uint16(v) * 0x101})
+			c64.SetRGBA64(x, y, color.RGBA64{uint16(v) * 0x101, uint16(v) * 0x101, uint16(v) * 0x101, 0xffff})
+		}
+	}
+
+	a, b, c := Average(g8), Average(g16), Average(c64)
+	if a != b || a != c {
+		t.Fatalf("Hash mismatch: 0x%x 0x%x 0x%x\n", a, b, c)
+	}
+}
+
+func TestAverageMask(t *testing.T) {
+	img, err := loadImg("testdata/gopher_large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Burn a "clock" into the top-left corner of a copy.
+	clock := image.Rect(0, 0, 90, 60)
+	b := image.NewRGBA(img.Bounds())
+	draw.Draw(b, b.Rect, img, image.Point{}, draw.Src)
+	draw.Draw(b, clock, image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	hf := AverageMask(ExcludeRects(img.Bounds(), clock))
+	if ha, hb := hf(img), hf(b); ha != hb {
+		t.Fatalf("Hash mismatch: 0x%x 0x%x\n", ha, hb)
+	}
+
+	// The same mask works for smaller copies.
+	small, err := loadImg("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dist := Distance(hf(img), hf(small)); dist > MaxDistance {
+		t.Fatalf("Hash mismatch: %d\n", dist)
+	}
+}
+
+func TestAverageWith(t *testing.T) {
+	img, err := loadImg("testdata/gopher_large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if AverageWith(nil)(img) != Average(img) {
+		t.Fatal("default options differ from Average")
+	}
+
+	mask := ExcludeRects(img.Bounds(), image.Rect(0, 0, 90, 60))
+	if AverageWith(&AverageOptions{Mask: mask})(img) != AverageMask(mask)(img) {
+		t.Fatal("mask option differs from AverageMask")
+	}
+
+	// The median sets about half the bits.
+	if n := Distance(AverageWith(&AverageOptions{Median: true})(img), 0); n < 28 || n > 32 {
+		t.Fatalf("median hash sets %d bits", n)
+	}
+
+	// Weighting the centre makes hashes hold up better to vignetting.
+	var plain, weighted uint64
+	hf := AverageWith(&AverageOptions{CenterWeight: 4})
+
+	for i := 0; i < 60; i++ {
+		img := synth.Shapes(128, 128, int64(i))
+		v := vignette(img, 0.5)
+
+		plain += Distance(Average(img), Average(v))
+		weighted += Distance(hf(img), hf(v))
+	}
+
+	if weighted >= plain {
+		t.Fatalf("centre weighted distance %d, plain %d", weighted, plain)
+	}
+}
+
+func TestAverageTrim(t *testing.T) {
+	if m := avgTrimmedMean([]uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 100}, nil, 0.1); m != 5 {
+		t.Fatalf("trimmed mean %d, want 5", m)
+	}
+
+	// A flat, noisy scene, and the same with a blown-out highlight
+	// covering four cells.
+	rng := rand.New(rand.NewSource(1))
+	a := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range a.Pix {
+		a.Pix[i] = uint8(0x60 + rng.Intn(0x40))
+	}
+
+	b := image.NewGray(a.Rect)
+	copy(b.Pix, a.Pix)
+	draw.Draw(b, image.Rect(0, 0, 16, 16), image.White, image.Point{}, draw.Src)
+
+	hf := AverageWith(&AverageOptions{Trim: 0.1})
+	plain, trimmed := Distance(Average(a), Average(b)), Distance(hf(a), hf(b))
+
+	if trimmed > 8 || trimmed >= plain {
+		t.Fatalf("trimmed distance %d, plain %d", trimmed, plain)
+	}
+}
+
+func TestAverageNeighborhood(t *testing.T) {
+	// Local thresholds hold up better to light falling off across
+	// the image.
+	var plain, local uint64
+	hf := AverageWith(&AverageOptions{Neighborhood: 2})
+
+	for i := 0; i < 60; i++ {
+		img := synth.Shapes(128, 128, int64(i))
+		g := gradientLight(img, 0.6)
+
+		plain += Distance(Average(img), Average(g))
+		local += Distance(hf(img), hf(g))
+	}
+
+	if local >= plain {
+		t.Fatalf("local distance %d, plain %d", local, plain)
+	}
+
+	// A window covering the grid from every cell is the global mean.
+	// The smallest is 7 cells either way, from the corners of 8x8.
+	img := synth.Shapes(128, 128, 1)
+	if h := AverageWith(&AverageOptions{Neighborhood: 7})(img); h != Average(img) {
+		t.Fatalf("full window %016x, want %016x", h, Average(img))
+	}
+}
+
+// gradientLight darkens the image from left to right, by up to strength.
+func gradientLight(img image.Image, strength float64) image.Image {
+	rect := img.Bounds()
+	out := image.NewRGBA(rect)
+
+	var x, y int
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			f := 1 - strength*float64(x-rect.Min.X)/float64(rect.Dx())
+
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			out.Set(x, y, color.RGBA{uint8(float64(c.R) * f), uint8(float64(c.G) * f), uint8(float64(c.B) * f), c.A})
+		}
+	}
+
+	return out
+}
+
+// vignette darkens the image towards its corners, by up to strength.
+func vignette(img image.Image, strength float64) image.Image {
+	rect := img.Bounds()
+	out := image.NewRGBA(rect)
+
+	var x, y int
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			dx := float64(x-rect.Min.X)/float64(rect.Dx()) - 0.5
+			dy := float64(y-rect.Min.Y)/float64(rect.Dy()) - 0.5
+			f := 1 - strength*math.Min(1, (dx*dx+dy*dy)*2)
+
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			out.Set(x, y, color.RGBA{uint8(float64(c.R) * f), uint8(float64(c.G) * f), uint8(float64(c.B) * f), c.A})
+		}
+	}
+
+	return out
+}
+
 func getHash(t *testing.T, hf HashFunc, file string) uint64 {
 	img, err := loadImg(file)
 
@@ -78,3 +311,143 @@ func saveImg(img image.Image, file string) error {
 
 	return nil
 }
+
+func TestDecoderRegistry(t *testing.T) {
+	// Unregistered, but recognised formats name themselves.
+	webp := []byte("RIFF\x10\x00\x00\x00WEBPVP8 ")
+	if _, err := Decode(bytes.NewReader(webp)); !errors.Is(err, ErrUnknownFormat) || !strings.Contains(err.Error(), "WebP") {
+		t.Fatalf("unexpected error for WebP: %v", err)
+	}
+
+	if HasExtension("photo.xyz") {
+		t.Fatalf("unregistered extension matched")
+	}
+
+	// Register a decoder for a made up format.
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	RegisterDecoder(&Decoder{
+		Name:       "xyz",
+		Magic:      "XYZ?",
+		Extensions: []string{".xyz"},
+		Decode: func(io.Reader) (image.Image, error) {
+			return img, nil
+		},
+	})
+
+	out, err := Decode(bytes.NewReader([]byte("XYZ1data")))
+	if err != nil || out != img {
+		t.Fatalf("registered decoder not used: %v", err)
+	}
+
+	if !HasExtension("PHOTO.XYZ") {
+		t.Fatalf("registered extension not matched")
+	}
+}
+
+func TestMaxPixels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(max int) { MaxPixels = max }(MaxPixels)
+	MaxPixels = 100 * 50
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	MaxPixels--
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != ErrTooLarge {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+
+	// Generated images are sampled, rather than read in full.
+	img := &image.Uniform{color.White}
+	if hash := Average(img); hash != 0 {
+		t.Fatalf("uniform image hashed to %016x", hash)
+	}
+}
+
+func TestUniformImages(t *testing.T) {
+	// Images of a single colour hash to 0 with every algorithm, through
+	// the filters too, whether generated or not.
+	for _, c := range []color.Color{color.White, color.Black, color.RGBA{10, 200, 30, 255}, color.Transparent} {
+		uniform := &image.Uniform{c}
+		flat := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		draw.Draw(flat, flat.Rect, uniform, image.Point{}, draw.Src)
+
+		for _, name := range Algorithms() {
+			a, _ := LookupAlgorithm(name)
+			if h, f := a.Hash(uniform), a.Hash(flat); h != 0 || f != 0 {
+				t.Fatalf("%s: %v hashed to %016x, flat %016x", name, c, h, f)
+			}
+		}
+
+		filters := []Filter{IgnoreAlpha, Composite(color.White), Equalize, Binarize, Luma, Channels(0, 0, 1), Stretch(0.01), Deskew(5), Upright}
+		if h := Preprocess(Average, filters...)(uniform); h != 0 {
+			t.Fatalf("%v: filtered hash %016x", c, h)
+		}
+
+		if o := OrientImage(uniform, Rotate90); o.Bounds().Dx() > sampleSize {
+			t.Fatalf("%v: rotated to %v", c, o.Bounds())
+		}
+	}
+}
+
+func TestTinyImages(t *testing.T) {
+	// Images smaller than the grid hash as their nearest neighbour
+	// upscales do, whether or not the grid is a multiple of their size.
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []image.Point{{1, 1}, {2, 2}, {3, 3}, {5, 7}} {
+		small := image.NewGray(image.Rect(0, 0, size.X, size.Y))
+		rng.Read(small.Pix)
+
+		large := image.NewGray(image.Rect(0, 0, 8*size.X, 8*size.Y))
+		var x, y int
+		for y = 0; y < large.Rect.Dy(); y++ {
+			for x = 0; x < large.Rect.Dx(); x++ {
+				large.SetGray(x, y, small.GrayAt(x/8, y/8))
+			}
+		}
+
+		if a, b := Average(small), Average(large); a != b {
+			t.Fatalf("%v: hash 0x%x, upscaled 0x%x", size, a, b)
+		}
+	}
+
+	if h := Average(image.NewGray(image.Rect(4, 4, 4, 9))); h != 0 {
+		t.Fatalf("empty image hashed to 0x%x", h)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 6))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ComputeBytes(buf.Bytes(), Average); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(min int) { MinSize = min }(MinSize)
+	MinSize = 2
+
+	_, err := ComputeBytes(buf.Bytes(), Average)
+	if err != ErrTooSmall || Classify(err) != FailureTooSmall {
+		t.Fatalf("got %v, want ErrTooSmall", err)
+	}
+
+	MinSize = 0
+	if checkSize(image.Rect(0, 0, 0, 6)) != ErrTooSmall {
+		t.Fatal("empty image accepted")
+	}
+}
+
+func BenchmarkAverage(b *testing.B) {
+	imgs := synth.Corpus(60, nil, 1)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Average(imgs[i%len(imgs)])
+	}
+}
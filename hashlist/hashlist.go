@@ -0,0 +1,455 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package hashlist reads and writes lists of hashes in the formats they are
+shared in between organisations, so lists from elsewhere can be searched
+with an imghash.Index or matched with an imghash.Blocklist:
+
+	entries, err := hashlist.Load("shared.csv")
+	...
+	n, err := hashlist.AddToIndex(index, entries, "average", 50)
+
+Three formats are supported:
+
+  - CSV, as the PDQ reference hasher writes it: the hash, its quality
+    and the name of the file it was computed from, per line. The last
+    two may be missing. A header line naming the columns is skipped.
+  - JSON: objects with a "hash", and optionally an "id", "algorithm"
+    and "quality". Files may hold one array of them, or one per line.
+  - Hex: one hash per line, optionally tagged with its algorithm, as in
+    "average:ff00ff00ff00ff00", and followed by whitespace and an ID.
+    Blank lines and lines starting with # are skipped.
+
+Hashes are kept in hexadecimal, so lists of hashes of any length can be
+read and written. Only those of 64 bits fit into an index; PDQ hashes are
+256 bits long.
+*/
+package hashlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidHash is returned for hashes which are not hexadecimal.
+var ErrInvalidHash = errors.New("hashlist: invalid hash")
+
+// An Entry is a single hash of a list.
+type Entry struct {
+	ID        string // Name of the image, or of the file it was hashed from. May be empty.
+	Algorithm string // Algorithm which computed the hash, if the list says.
+	Hash      string // Hexadecimal, in lower case.
+	Quality   int    // Quality of the hash from 0 to 100, as PDQ reports it, or -1.
+}
+
+// Bits returns the length of the hash, in bits.
+func (e *Entry) Bits() int {
+	return 4 * len(e.Hash)
+}
+
+// Uint64 returns the hash as a 64 bit value, as this package computes
+// them. Hashes of any other length yield an error.
+func (e *Entry) Uint64() (uint64, error) {
+	if len(e.Hash) != 16 {
+		return 0, fmt.Errorf("hashlist: %d bit hash %q does not fit 64 bits", e.Bits(), e.Hash)
+	}
+	return strconv.ParseUint(e.Hash, 16, 64)
+}
+
+// A Format is one of the supported list formats.
+type Format int
+
+// Known formats.
+const (
+	CSV Format = iota
+	JSON
+	Hex
+	formats
+)
+
+var formatNames = [...]string{"csv", "json", "hex"}
+
+func (f Format) String() string {
+	if f < 0 || f >= formats {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formatNames[f]
+}
+
+// ParseFormat returns the format with the given name: csv, json or hex.
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if n == strings.ToLower(name) {
+			return Format(f), nil
+		}
+	}
+	return 0, fmt.Errorf("hashlist: unknown format %q", name)
+}
+
+// FormatOf returns the format of a file, by its extension: .csv, .json
+// or .jsonl. Any other file is taken to be a hex list.
+func FormatOf(file string) Format {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		return CSV
+	case ".json", ".jsonl":
+		return JSON
+	}
+	return Hex
+}
+
+// Load reads the list in the given file, in the format FormatOf tells.
+func Load(file string) ([]Entry, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return Read(fd, FormatOf(file))
+}
+
+// Save writes the entries to the given file, in the format FormatOf
+// tells.
+func Save(file string, entries []Entry) (err error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return
+	}
+
+	if err = Write(fd, FormatOf(file), entries); err != nil {
+		fd.Close()
+		return
+	}
+
+	return fd.Close()
+}
+
+// Read reads a list in the given format from r.
+func Read(r io.Reader, f Format) ([]Entry, error) {
+	switch f {
+	case CSV:
+		return readCSV(r)
+	case JSON:
+		return readJSON(r)
+	case Hex:
+		return readHex(r)
+	}
+	return nil, fmt.Errorf("hashlist: unknown format %v", f)
+}
+
+// Write writes the entries to w, in the given format. The CSV format has
+// no column for the algorithm, and leaves it out. JSON entries are written
+// one per line.
+func Write(w io.Writer, f Format, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+
+	switch f {
+	case CSV:
+		cw := csv.NewWriter(bw)
+		for _, e := range entries {
+			quality := ""
+			if e.Quality >= 0 {
+				quality = strconv.Itoa(e.Quality)
+			}
+			cw.Write([]string{e.Hash, quality, e.ID})
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+
+	case JSON:
+		enc := json.NewEncoder(bw)
+		for _, e := range entries {
+			if err := enc.Encode(toJSON(e)); err != nil {
+				return err
+			}
+		}
+
+	case Hex:
+		for _, e := range entries {
+			if len(e.Algorithm) > 0 {
+				fmt.Fprintf(bw, "%s:", e.Algorithm)
+			}
+
+			io.WriteString(bw, e.Hash)
+			if len(e.ID) > 0 {
+				fmt.Fprintf(bw, " %s", e.ID)
+			}
+
+			bw.WriteByte('\n')
+		}
+
+	default:
+		return fmt.Errorf("hashlist: unknown format %v", f)
+	}
+
+	return bw.Flush()
+}
+
+// readCSV reads a list in the CSV format.
+func readCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var entries []Entry
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+
+		if err != nil {
+			return entries, err
+		}
+
+		if line == 1 && strings.EqualFold(rec[0], "hash") {
+			continue
+		}
+
+		e := Entry{Quality: -1}
+		if e.Hash, err = normalize(rec[0]); err != nil {
+			return entries, fmt.Errorf("hashlist: line %d: %v", line, err)
+		}
+
+		if len(rec) > 1 && len(rec[1]) > 0 {
+			if e.Quality, err = parseQuality(rec[1]); err != nil {
+				return entries, fmt.Errorf("hashlist: line %d: %v", line, err)
+			}
+		}
+
+		if len(rec) > 2 {
+			e.ID = rec[2]
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+// jsonEntry is an entry of the JSON format.
+type jsonEntry struct {
+	ID        string `json:"id,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Hash      string `json:"hash"`
+	Quality   *int   `json:"quality,omitempty"`
+}
+
+// toJSON returns the entry in the JSON format.
+func toJSON(e Entry) jsonEntry {
+	j := jsonEntry{ID: e.ID, Algorithm: e.Algorithm, Hash: e.Hash}
+	if e.Quality >= 0 {
+		j.Quality = &e.Quality
+	}
+	return j
+}
+
+// readJSON reads a list in the JSON format.
+func readJSON(r io.Reader) ([]Entry, error) {
+	br := bufio.NewReader(r)
+
+	// A single array, or a stream of objects.
+	var list []jsonEntry
+	if b, err := peekNonSpace(br); err == nil && b == '[' {
+		if err := json.NewDecoder(br).Decode(&list); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := json.NewDecoder(br)
+		for {
+			var j jsonEntry
+			err := dec.Decode(&j)
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, j)
+		}
+	}
+
+	entries := make([]Entry, len(list))
+	for i, j := range list {
+		hash, err := normalize(j.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("hashlist: entry %d: %v", i+1, err)
+		}
+
+		e := Entry{ID: j.ID, Algorithm: j.Algorithm, Hash: hash, Quality: -1}
+		if j.Quality != nil {
+			if *j.Quality < 0 || *j.Quality > 100 {
+				return nil, fmt.Errorf("hashlist: entry %d: invalid quality %d", i+1, *j.Quality)
+			}
+			e.Quality = *j.Quality
+		}
+
+		entries[i] = e
+	}
+
+	return entries, nil
+}
+
+// peekNonSpace returns the first byte of r which is not white space,
+// without consuming it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+
+		br.ReadByte()
+	}
+}
+
+// readHex reads a list in the hex format.
+func readHex(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	s := bufio.NewScanner(r)
+
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		e := Entry{Quality: -1}
+		hash := text
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			hash, e.ID = text[:i], strings.TrimSpace(text[i:])
+		}
+
+		if i := strings.LastIndexByte(hash, ':'); i >= 0 {
+			e.Algorithm, hash = hash[:i], hash[i+1:]
+		}
+
+		var err error
+		if e.Hash, err = normalize(hash); err != nil {
+			return entries, fmt.Errorf("hashlist: line %d: %v", line, err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, s.Err()
+}
+
+// normalize validates a hexadecimal hash, and returns it in lower case.
+func normalize(hash string) (string, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if len(hash) == 0 || len(hash)%2 != 0 {
+		return "", ErrInvalidHash
+	}
+
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", ErrInvalidHash
+	}
+
+	return hash, nil
+}
+
+// parseQuality parses a quality value, from 0 to 100.
+func parseQuality(s string) (int, error) {
+	q, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || q < 0 || q > 100 {
+		return 0, fmt.Errorf("invalid quality %q", s)
+	}
+	return q, nil
+}
+
+// Hashes returns the entries of the given algorithm, at or above the
+// given quality, as entries of the imghash package. Entries without an
+// algorithm are taken to be of the given one, and those without a quality
+// to be good enough. Their IDs become the paths; entries without one go
+// by their hash. Hashes which are not 64 bits long yield an error.
+func Hashes(entries []Entry, algorithm string, minQuality int) ([]*imghash.Entry, error) {
+	var out []*imghash.Entry
+
+	for i := range entries {
+		e := &entries[i]
+		if len(e.Algorithm) > 0 && len(algorithm) > 0 && !strings.EqualFold(e.Algorithm, algorithm) {
+			continue
+		}
+
+		if e.Quality >= 0 && e.Quality < minQuality {
+			continue
+		}
+
+		hash, err := e.Uint64()
+		if err != nil {
+			return nil, err
+		}
+
+		id := e.ID
+		if len(id) == 0 {
+			id = e.Hash
+		}
+
+		out = append(out, &imghash.Entry{Path: id, Hash: hash})
+	}
+
+	return out, nil
+}
+
+// AddToIndex adds the entries Hashes selects to the index, and returns
+// their number. The algorithm defaults to that of the index; an index
+// without one takes it on.
+func AddToIndex(x *imghash.Index, entries []Entry, algorithm string, minQuality int) (int, error) {
+	if len(algorithm) == 0 {
+		algorithm = x.Algorithm
+	}
+
+	if len(x.Algorithm) > 0 && !strings.EqualFold(x.Algorithm, algorithm) {
+		return 0, fmt.Errorf("hashlist: index uses algorithm %q, not %q", x.Algorithm, algorithm)
+	}
+
+	hashes, err := Hashes(entries, algorithm, minQuality)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range hashes {
+		x.Add(e.Path, e.Hash)
+	}
+
+	if len(x.Algorithm) == 0 {
+		x.Algorithm = algorithm
+	}
+
+	return len(hashes), nil
+}
+
+// FromIndex returns the entries of the index, in order of their IDs,
+// tagged with its algorithm.
+func FromIndex(x *imghash.Index) []Entry {
+	ids := x.IDs()
+	entries := make([]Entry, len(ids))
+
+	for i, id := range ids {
+		hash, _ := x.Hash(id)
+		entries[i] = Entry{ID: id, Algorithm: x.Algorithm, Hash: fmt.Sprintf("%016x", hash), Quality: -1}
+	}
+
+	return entries
+}
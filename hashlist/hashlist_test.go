@@ -0,0 +1,103 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package hashlist
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	pdq := "f8f8f0cee0f4a84f06370a22038f63f0b36e2ed596621e1d33e6b39c4e9c9b22"
+
+	tests := []struct {
+		format Format
+		input  string
+		want   []Entry
+	}{
+		{CSV, "hash,quality,filename\n" + pdq + ",100,a.jpg\nFF00FF00FF00FF00\n", []Entry{
+			{ID: "a.jpg", Hash: pdq, Quality: 100},
+			{Hash: "ff00ff00ff00ff00", Quality: -1},
+		}},
+		{JSON, `[{"hash": "` + pdq + `", "quality": 90, "id": "a"}]`, []Entry{
+			{ID: "a", Hash: pdq, Quality: 90},
+		}},
+		{JSON, "{\"hash\": \"ff00ff00ff00ff00\", \"algorithm\": \"average\"}\n{\"hash\": \"00000000000000ff\"}\n", []Entry{
+			{Algorithm: "average", Hash: "ff00ff00ff00ff00", Quality: -1},
+			{Hash: "00000000000000ff", Quality: -1},
+		}},
+		{Hex, "# Shared list\n\naverage:ff00ff00ff00ff00 photos/a cat.jpg\n00000000000000ff\n", []Entry{
+			{ID: "photos/a cat.jpg", Algorithm: "average", Hash: "ff00ff00ff00ff00", Quality: -1},
+			{Hash: "00000000000000ff", Quality: -1},
+		}},
+	}
+
+	for _, tt := range tests {
+		got, err := Read(strings.NewReader(tt.input), tt.format)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.format, err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%v: entries %+v, want %+v", tt.format, got, tt.want)
+		}
+
+		// Written lists read back the same, but for the algorithm in CSV.
+		var buf bytes.Buffer
+		if err := Write(&buf, tt.format, got); err != nil {
+			t.Fatal(err)
+		}
+
+		back, err := Read(&buf, tt.format)
+		if tt.format == CSV {
+			for i := range got {
+				got[i].Algorithm = ""
+			}
+		}
+
+		if err != nil || !reflect.DeepEqual(back, got) {
+			t.Fatalf("%v: read back %+v, %v", tt.format, back, err)
+		}
+	}
+
+	for _, f := range []Format{CSV, JSON, Hex} {
+		if _, err := Read(strings.NewReader(`{"hash": "xyz"}`+"\n"), f); err == nil {
+			t.Fatalf("%v: invalid hash accepted", f)
+		}
+	}
+}
+
+func TestAddToIndex(t *testing.T) {
+	entries := []Entry{
+		{ID: "a", Algorithm: "average", Hash: "ff00ff00ff00ff00", Quality: -1},
+		{ID: "b", Algorithm: "screenshot", Hash: "00ff00ff00ff00ff", Quality: -1},
+		{Hash: "0f0f0f0f0f0f0f0f", Quality: 80},
+		{ID: "d", Hash: "000000000000000f", Quality: 20},
+	}
+
+	x := imghash.NewIndex()
+	n, err := AddToIndex(x, entries, "average", 50)
+	if err != nil || n != 2 {
+		t.Fatalf("added %d: %v", n, err)
+	}
+
+	if x.Algorithm != "average" || !reflect.DeepEqual(x.IDs(), []string{"0f0f0f0f0f0f0f0f", "a"}) {
+		t.Fatalf("index %q: %v", x.Algorithm, x.IDs())
+	}
+
+	if _, err := AddToIndex(x, entries, "screenshot", 0); err == nil {
+		t.Fatal("hashes of another algorithm added")
+	}
+
+	if _, err := AddToIndex(imghash.NewIndex(), []Entry{{Hash: strings.Repeat("00", 32), Quality: -1}}, "", 0); err == nil {
+		t.Fatal("256 bit hash added")
+	}
+
+	if got := FromIndex(x); got[1].ID != "a" || got[1].Hash != "ff00ff00ff00ff00" || got[1].Algorithm != "average" {
+		t.Fatalf("exported %+v", got)
+	}
+}
@@ -0,0 +1,470 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrInvalidHashStore is returned when opening a malformed hash store file.
+var ErrInvalidHashStore = errors.New("imghash: invalid hash store file")
+
+// hashStoreHeader is the first line of a hash store file.
+const hashStoreHeader = "# imghash hash store v1"
+
+// A Record is a hash in a HashStore, with the metadata stored along.
+type Record struct {
+	ID      string
+	Hash    uint64
+	Meta    map[string]string // Optional.
+	Expires time.Time         // Zero if the record does not expire.
+}
+
+// expired returns true if the record has expired at the given time.
+func (r *Record) expired(now time.Time) bool {
+	return !r.Expires.IsZero() && !now.Before(r.Expires)
+}
+
+// A HashStore holds hashes by ID, along with metadata, for a limited
+// time if need be. Moderation systems which may only keep what they
+// have seen for a retention period give every record a time to live;
+// once it has passed, the record is no longer returned, and Expire
+// removes it for good.
+//
+// Implementations must be safe for concurrent use. MemoryHashStore and
+// FileHashStore are the default implementations.
+type HashStore interface {
+	// Put stores a record. Its Expires field is set from ttl: a record
+	// expires ttl after it is stored, or never if ttl <= 0. If the ID
+	// already exists, its record is replaced.
+	Put(ctx context.Context, r Record, ttl time.Duration) error
+
+	// Get returns the record with the given ID, and whether it exists
+	// and has not expired.
+	Get(ctx context.Context, id string) (Record, bool, error)
+
+	// Query finds all records within the given distance of hash,
+	// sorted by distance, then by ID.
+	Query(ctx context.Context, hash, distance uint64) (Hits, error)
+
+	// Delete removes the record with the given ID, if it exists.
+	Delete(ctx context.Context, id string) error
+
+	// Expire removes all expired records, and returns their number.
+	Expire(ctx context.Context) (int, error)
+}
+
+// records holds the state shared by the implementations of HashStore.
+// It is not safe for concurrent use.
+type records struct {
+	byID  map[string]Record
+	index *Index
+}
+
+func newRecords() records {
+	return records{byID: make(map[string]Record), index: NewIndex()}
+}
+
+func (rs *records) put(r Record) {
+	rs.byID[r.ID] = r
+	rs.index.Add(r.ID, r.Hash)
+}
+
+func (rs *records) delete(id string) {
+	delete(rs.byID, id)
+	rs.index.Remove(id)
+}
+
+func (rs *records) get(id string, now time.Time) (Record, bool) {
+	r, ok := rs.byID[id]
+	if !ok || r.expired(now) {
+		return Record{}, false
+	}
+	return r, true
+}
+
+func (rs *records) query(hash, distance uint64, now time.Time) Hits {
+	var hits Hits
+	for _, sr := range rs.index.Query(hash, distance) {
+		if r, ok := rs.get(sr.Path, now); ok {
+			hits = append(hits, Hit{r, sr.Distance})
+		}
+	}
+
+	hits.Sort()
+	return hits
+}
+
+// expire removes all records expired at now, and returns their IDs.
+func (rs *records) expire(now time.Time) []string {
+	var ids []string
+	for id, r := range rs.byID {
+		if r.expired(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range ids {
+		rs.delete(id)
+	}
+
+	return ids
+}
+
+// stamp returns r, with its Expires field set from ttl.
+func stamp(r Record, ttl time.Duration, now time.Time) Record {
+	r.Expires = time.Time{}
+	if ttl > 0 {
+		r.Expires = now.Add(ttl)
+	}
+	return r
+}
+
+// MemoryHashStore is a HashStore kept in memory.
+type MemoryHashStore struct {
+	// Returns the current time, by which records expire.
+	// Defaults to time.Now.
+	Now func() time.Time
+
+	mu sync.RWMutex
+	rs records
+}
+
+// NewMemoryHashStore creates an empty store.
+func NewMemoryHashStore() *MemoryHashStore {
+	return &MemoryHashStore{Now: time.Now, rs: newRecords()}
+}
+
+// Len returns the number of records, including expired ones which have
+// not been removed yet.
+func (s *MemoryHashStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.rs.byID)
+}
+
+func (s *MemoryHashStore) Put(ctx context.Context, r Record, ttl time.Duration) error {
+	s.mu.Lock()
+	s.rs.put(stamp(r, ttl, s.Now()))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryHashStore) Get(ctx context.Context, id string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.rs.get(id, s.Now())
+	return r, ok, nil
+}
+
+func (s *MemoryHashStore) Query(ctx context.Context, hash, distance uint64) (Hits, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rs.query(hash, distance, s.Now()), nil
+}
+
+func (s *MemoryHashStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	s.rs.delete(id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryHashStore) Expire(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.rs.expire(s.Now())), nil
+}
+
+// FileHashStore is a HashStore kept in memory, and in a file.
+//
+// Like a Checkpoint, every change is appended to the file right away, so
+// it survives the process being killed. Expire rewrites the file without
+// the expired records, and the records they replaced, so it does not
+// grow without bounds.
+type FileHashStore struct {
+	// Returns the current time, by which records expire.
+	// Defaults to time.Now.
+	Now func() time.Time
+
+	mu   sync.RWMutex
+	rs   records
+	file string
+	fd   *os.File
+	w    *bufio.Writer
+}
+
+// OpenFileHashStore opens the given hash store file, and loads the
+// records in it. A missing file is created.
+func OpenFileHashStore(file string) (*FileHashStore, error) {
+	fd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileHashStore{Now: time.Now, rs: newRecords(), file: file, fd: fd}
+	if err = s.load(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	s.w = bufio.NewWriter(fd)
+	return s, nil
+}
+
+// load reads all records, and positions the file after the last
+// complete one. A new file gets a header.
+func (s *FileHashStore) load() error {
+	r := bufio.NewReader(s.fd)
+
+	header, err := r.ReadString('\n')
+	if err == io.EOF && len(header) == 0 {
+		_, err = fmt.Fprintln(s.fd, hashStoreHeader)
+		return err
+	}
+
+	if err != nil || header[:len(header)-1] != hashStoreHeader {
+		return ErrInvalidHashStore
+	}
+
+	offset := int64(len(header))
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line was cut short; drop it.
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !s.parse(bytes.TrimSuffix(line, []byte("\n"))) {
+			return ErrInvalidHashStore
+		}
+
+		offset += int64(len(line))
+	}
+
+	if err := s.fd.Truncate(offset); err != nil {
+		return err
+	}
+
+	_, err = s.fd.Seek(offset, io.SeekStart)
+	return err
+}
+
+// parse parses a single change. Records are written as "p", followed by
+// the hash, the expiry time in Unix nanoseconds or 0, the quoted ID and
+// the metadata in JSON. Deletions are written as "d", followed by the
+// quoted ID.
+func (s *FileHashStore) parse(line []byte) bool {
+	fields := bytes.SplitN(line, []byte(" "), 4)
+
+	switch {
+	case len(fields) == 2 && string(fields[0]) == "d":
+		id, err := strconv.Unquote(string(fields[1]))
+		if err != nil {
+			return false
+		}
+
+		s.rs.delete(id)
+		return true
+
+	case len(fields) == 4 && string(fields[0]) == "p":
+		hash, err := strconv.ParseUint(string(fields[1]), 16, 64)
+		if err != nil {
+			return false
+		}
+
+		expires, err := strconv.ParseInt(string(fields[2]), 10, 64)
+		if err != nil {
+			return false
+		}
+
+		quoted, err := strconv.QuotedPrefix(string(fields[3]))
+		if err != nil || len(fields[3]) < len(quoted)+1 {
+			return false
+		}
+
+		r := Record{Hash: hash}
+		r.ID, _ = strconv.Unquote(quoted)
+		if err := json.Unmarshal(fields[3][len(quoted)+1:], &r.Meta); err != nil {
+			return false
+		}
+
+		if expires != 0 {
+			r.Expires = time.Unix(0, expires)
+		}
+
+		s.rs.put(r)
+		return true
+	}
+
+	return false
+}
+
+// formatRecord writes a record, as parse reads it.
+func formatRecord(w io.Writer, r *Record) error {
+	meta, err := json.Marshal(r.Meta)
+	if err != nil {
+		return err
+	}
+
+	var expires int64
+	if !r.Expires.IsZero() {
+		expires = r.Expires.UnixNano()
+	}
+
+	line := make([]byte, 0, 40+len(r.ID)+len(meta))
+	line = append(line, 'p', ' ')
+	line = AppendHash(line, r.Hash)
+	line = append(line, ' ')
+	line = strconv.AppendInt(line, expires, 10)
+	line = append(line, ' ')
+	line = strconv.AppendQuote(line, r.ID)
+	line = append(line, ' ')
+	line = append(line, meta...)
+	line = append(line, '\n')
+
+	_, err = w.Write(line)
+	return err
+}
+
+// Len returns the number of records, including expired ones which have
+// not been removed yet.
+func (s *FileHashStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.rs.byID)
+}
+
+func (s *FileHashStore) Put(ctx context.Context, r Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r = stamp(r, ttl, s.Now())
+	if err := formatRecord(s.w, &r); err != nil {
+		return err
+	}
+
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	s.rs.put(r)
+	return nil
+}
+
+func (s *FileHashStore) Get(ctx context.Context, id string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.rs.get(id, s.Now())
+	return r, ok, nil
+}
+
+func (s *FileHashStore) Query(ctx context.Context, hash, distance uint64) (Hits, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rs.query(hash, distance, s.Now()), nil
+}
+
+func (s *FileHashStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rs.byID[id]; !ok {
+		return nil
+	}
+
+	fmt.Fprintf(s.w, "d %s\n", strconv.Quote(id))
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	s.rs.delete(id)
+	return nil
+}
+
+// Expire removes all expired records, and rewrites the file with those
+// which remain. The file is replaced atomically.
+func (s *FileHashStore) Expire(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.rs.expire(s.Now()))
+	return n, s.compact()
+}
+
+// compact rewrites the file with the current records, with the lock held.
+func (s *FileHashStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".imghash-store-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	ids := make([]string, 0, len(s.rs.byID))
+	for id := range s.rs.byID {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintln(w, hashStoreHeader)
+
+	for _, id := range ids {
+		r := s.rs.byID[id]
+		if err := formatRecord(w, &r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// Continue appending to the new file.
+	s.fd.Close()
+	s.fd = tmp
+	s.w = bufio.NewWriter(tmp)
+	return nil
+}
+
+// Close closes the file. The store must not be used afterwards.
+func (s *FileHashStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.w.Flush(); err != nil {
+		s.fd.Close()
+		return err
+	}
+
+	return s.fd.Close()
+}
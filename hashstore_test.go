@@ -0,0 +1,111 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHashStores(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hashes")
+
+	fs, err := OpenFileHashStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fs.Close()
+
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+
+	ms := NewMemoryHashStore()
+	ms.Now, fs.Now = clock, clock
+
+	for _, s := range []HashStore{ms, fs} {
+		testHashStore(t, s, &now)
+		now = time.Unix(1700000000, 0)
+	}
+
+	// Reopen the file, with the records left after Expire
+	// and the changes made after it.
+	fs.Put(context.Background(), Record{ID: "late", Hash: 7}, 0)
+	fs.Close()
+
+	fs, err = OpenFileHashStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Now = func() time.Time { return now.Add(2 * time.Hour) }
+	ctx := context.Background()
+
+	if r, ok, _ := fs.Get(ctx, "kept"); !ok || r.Hash != 0xff || r.Meta["source"] != "upload a b" {
+		t.Fatalf("reopened record %+v, %v", r, ok)
+	}
+
+	if _, ok, _ := fs.Get(ctx, "late"); !ok || fs.Len() != 3 {
+		t.Fatalf("reopened %d records", fs.Len())
+	}
+
+	if n, err := fs.Expire(ctx); n != 1 || err != nil {
+		t.Fatalf("expired %d, %v", n, err)
+	}
+
+	fs.Close()
+
+	data, _ := os.ReadFile(file)
+	if fs, err = OpenFileHashStore(file); err != nil || fs.Len() != 2 {
+		t.Fatalf("compacted file %q: %v", data, err)
+	}
+}
+
+func testHashStore(t *testing.T, s HashStore, now *time.Time) {
+	ctx := context.Background()
+	meta := map[string]string{"source": "upload a b", "user": `"x"`}
+
+	s.Put(ctx, Record{ID: "kept", Hash: 0xff, Meta: meta}, 0)
+	s.Put(ctx, Record{ID: "near", Hash: 0xfe, Expires: now.Add(time.Minute)}, time.Hour)
+	s.Put(ctx, Record{ID: "short", Hash: 0xfc}, time.Minute)
+	s.Put(ctx, Record{ID: "deleted", Hash: 0xff}, 0)
+	s.Delete(ctx, "deleted")
+
+	r, ok, err := s.Get(ctx, "kept")
+	if !ok || err != nil || !reflect.DeepEqual(r.Meta, meta) || !r.Expires.IsZero() {
+		t.Fatalf("%T: get %+v, %v, %v", s, r, ok, err)
+	}
+
+	if r, _, _ := s.Get(ctx, "near"); !r.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("%T: expiry %v", s, r.Expires)
+	}
+
+	hits, err := s.Query(ctx, 0xff, 2)
+	if err != nil || len(hits) != 3 || hits[0].ID != "kept" || hits[1].ID != "near" || hits[2].Distance != 2 {
+		t.Fatalf("%T: query %+v, %v", s, hits, err)
+	}
+
+	// Expired records disappear before they are removed.
+	*now = now.Add(30 * time.Minute)
+
+	if _, ok, _ := s.Get(ctx, "short"); ok {
+		t.Fatalf("%T: expired record found", s)
+	}
+
+	if hits, _ := s.Query(ctx, 0xff, 2); len(hits) != 2 {
+		t.Fatalf("%T: query after expiry %+v", s, hits)
+	}
+
+	if n, err := s.Expire(ctx); n != 1 || err != nil {
+		t.Fatalf("%T: expired %d, %v", s, n, err)
+	}
+
+	if n, _ := s.Expire(ctx); n != 0 {
+		t.Fatalf("%T: expired %d again", s, n)
+	}
+}
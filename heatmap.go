@@ -0,0 +1,144 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// HeatmapColor is the colour Heatmap.Overlay paints changed tiles in.
+var HeatmapColor = color.RGBA{0xff, 0x00, 0x00, 0xff}
+
+// heatmapScale is the tile distance at which Overlay paints a tile at
+// full strength. Unrelated images differ by about half their bits.
+const heatmapScale = 32
+
+// A Heatmap holds the Hamming Distances between corresponding tiles
+// of two images.
+type Heatmap struct {
+	Cols, Rows int
+	Distances  []uint64 // Distance of each tile, row by row.
+}
+
+// CompareTiles splits both images into a grid of cols by rows tiles, and
+// hashes each tile with the given HashFunc. Tiles are placed relative to
+// the image bounds, so images of different sizes can be compared. The
+// resulting heatmap tells which regions of the images differ, and by
+// how much.
+//
+// Tiles hold at least one pixel; the grid is made coarser for images too
+// small for it. Hash functions need some detail to work with, so tiles
+// of 16 pixels or more on each side give the most meaningful results.
+func CompareTiles(a, b image.Image, cols, rows int, hf HashFunc) *Heatmap {
+	ra, rb := a.Bounds(), b.Bounds()
+	cols = clampTiles(cols, ra.Dx(), rb.Dx())
+	rows = clampTiles(rows, ra.Dy(), rb.Dy())
+
+	h := &Heatmap{Cols: cols, Rows: rows, Distances: make([]uint64, cols*rows)}
+
+	var col, row int
+	for row = 0; row < rows; row++ {
+		for col = 0; col < cols; col++ {
+			ta := Region(a, h.Tile(ra, col, row))
+			tb := Region(b, h.Tile(rb, col, row))
+			h.Distances[row*cols+col] = Distance(hf(ta), hf(tb))
+		}
+	}
+
+	return h
+}
+
+// clampTiles limits the number of tiles along an axis to the
+// number of pixels along it, in the smallest of the images.
+func clampTiles(n, a, b int) int {
+	if b < a {
+		a = b
+	}
+
+	if n > a {
+		n = a
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+// At returns the distance of the given tile.
+func (h *Heatmap) At(col, row int) uint64 {
+	return h.Distances[row*h.Cols+col]
+}
+
+// Max returns the largest tile distance.
+func (h *Heatmap) Max() uint64 {
+	var max uint64
+	for _, d := range h.Distances {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// Tile returns the rectangle covered by the given tile,
+// in an image with the given bounds.
+func (h *Heatmap) Tile(bounds image.Rectangle, col, row int) image.Rectangle {
+	w, ht := bounds.Dx(), bounds.Dy()
+	return image.Rect(
+		bounds.Min.X+col*w/h.Cols, bounds.Min.Y+row*ht/h.Rows,
+		bounds.Min.X+(col+1)*w/h.Cols, bounds.Min.Y+(row+1)*ht/h.Rows,
+	)
+}
+
+// Changed returns the rectangles of all tiles whose distance exceeds
+// the threshold, in an image with the given bounds.
+func (h *Heatmap) Changed(bounds image.Rectangle, threshold uint64) []image.Rectangle {
+	var out []image.Rectangle
+
+	var col, row int
+	for row = 0; row < h.Rows; row++ {
+		for col = 0; col < h.Cols; col++ {
+			if h.At(col, row) > threshold {
+				out = append(out, h.Tile(bounds, col, row))
+			}
+		}
+	}
+
+	return out
+}
+
+// Overlay returns a copy of img, with each tile tinted in HeatmapColor.
+// The tint is invisible for unchanged tiles, and grows stronger with
+// the tile's distance.
+func (h *Heatmap) Overlay(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+
+	src := image.NewUniform(HeatmapColor)
+
+	var col, row int
+	for row = 0; row < h.Rows; row++ {
+		for col = 0; col < h.Cols; col++ {
+			d := h.At(col, row)
+			if d == 0 {
+				continue
+			}
+
+			if d > heatmapScale {
+				d = heatmapScale
+			}
+
+			// Leave some of the image visible, even at full strength.
+			mask := image.NewUniform(color.Alpha{uint8(d * 0xc0 / heatmapScale)})
+			draw.DrawMask(out, h.Tile(bounds, col, row), src, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,58 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/draw"
+	"testing"
+)
+
+func TestCompareTiles(t *testing.T) {
+	a := synth.Shapes(256, 192, 1)
+	b := synth.Shapes(256, 192, 1)
+
+	// Replace a region covering tiles (1, 1) and (2, 1) of a 4x3 grid.
+	changed := image.Rect(64, 64, 192, 128)
+	draw.Draw(b, changed, synth.Noise(256, 192, 2), changed.Min, draw.Src)
+
+	h := CompareTiles(a, b, 4, 3, Average)
+	if h.Cols != 4 || h.Rows != 3 || len(h.Distances) != 12 {
+		t.Fatalf("grid %dx%d", h.Cols, h.Rows)
+	}
+
+	rects := h.Changed(a.Bounds(), 0)
+	if len(rects) != 2 || rects[0] != image.Rect(64, 64, 128, 128) || rects[1] != image.Rect(128, 64, 192, 128) {
+		t.Fatalf("changed %v; distances %v", rects, h.Distances)
+	}
+
+	// Only changed tiles are tinted.
+	out := h.Overlay(a)
+	if out.At(10, 10) != a.At(10, 10) {
+		t.Fatalf("unchanged tile tinted")
+	}
+
+	if r, _, _, _ := out.At(100, 100).RGBA(); r < 0x4000 {
+		t.Fatalf("changed tile not tinted: %v", out.At(100, 100))
+	}
+
+	// Scaled copies line up, and tiny images get a coarser grid.
+	large := image.NewRGBA(image.Rect(0, 0, 512, 384))
+
+	var x, y int
+	for y = 0; y < 384; y++ {
+		for x = 0; x < 512; x++ {
+			large.Set(x, y, a.At(x/2, y/2))
+		}
+	}
+
+	if h := CompareTiles(a, large, 4, 3, Average); h.Max() > 2 {
+		t.Fatalf("scaled copy: %v", h.Distances)
+	}
+
+	if h := CompareTiles(a, image.NewGray(image.Rect(0, 0, 2, 1)), 4, 3, Average); h.Cols != 2 || h.Rows != 1 {
+		t.Fatalf("tiny image: %dx%d", h.Cols, h.Rows)
+	}
+}
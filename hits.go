@@ -0,0 +1,135 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// A Hit is a record found by a query, with its distance to the query.
+type Hit struct {
+	Record
+	Distance uint64 // Hamming Distance to the query.
+}
+
+// Hits holds the results of a query, as returned by Index.Search and
+// HashStore.Query. Each hit carries its ID, hash and metadata, so no
+// second lookup is needed to make use of it.
+type Hits []Hit
+
+// Sort sorts the hits by distance, and hits at the same distance by ID.
+// Queries return their hits in this order.
+func (h Hits) Sort() {
+	h.SortBy(func(a, b *Hit) bool {
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		return a.ID < b.ID
+	})
+}
+
+// SortBy sorts the hits with the given ordering, which returns true
+// if a belongs before b. The sort is stable.
+func (h Hits) SortBy(less func(a, b *Hit) bool) {
+	sort.SliceStable(h, func(i, j int) bool { return less(&h[i], &h[j]) })
+}
+
+// IDs returns the IDs of the hits, in order.
+func (h Hits) IDs() []string {
+	ids := make([]string, len(h))
+	for i := range h {
+		ids[i] = h[i].ID
+	}
+	return ids
+}
+
+// Within returns the leading hits at or below the given distance.
+// The hits must be sorted by distance.
+func (h Hits) Within(distance uint64) Hits {
+	n := sort.Search(len(h), func(i int) bool { return h[i].Distance > distance })
+	return h[:n]
+}
+
+// An OrientedHit is a hit of Index.SearchOriented, with the orientation
+// of the query image it matched in.
+type OrientedHit struct {
+	Hit
+	Orientation Orientation
+}
+
+// A Ranking orders hits by a secondary signature, like a colour hash
+// after a search by structure. Average and the other structural hashes
+// put a photo and its black and white copy at distance 0; a colour hash
+// tells which of the two a query is closest to.
+type Ranking struct {
+	// Secondary returns the distance of a hit to the query by the
+	// secondary signature. MetaHash reads it from the metadata.
+	Secondary func(h *Hit) float64
+
+	// Weight of the secondary distance. With 0, the secondary
+	// distance only breaks ties between hits at the same Hamming
+	// Distance. Otherwise, hits are ordered by their Hamming Distance
+	// plus the weighted secondary distance.
+	Weight float64
+}
+
+// MetaHash returns a Ranking.Secondary function which compares the hash
+// of the query by a second algorithm with the hash stored in the
+// metadata of each hit under the given key, in hexadecimal. Store it
+// with Index.SetMeta or a HashStore. Hits without one come last.
+func MetaHash(key string, query uint64) func(h *Hit) float64 {
+	return func(h *Hit) float64 {
+		hash, err := strconv.ParseUint(h.Meta[key], 16, 64)
+		if err != nil {
+			return math.Inf(1)
+		}
+		return float64(Distance(hash, query))
+	}
+}
+
+// Rank sorts the hits with the given Ranking. Hits which rank the same
+// are sorted by Hamming Distance, then by ID. A nil Ranking sorts as
+// Sort does. With a Weight, the hits may no longer be sorted by Hamming
+// Distance, which Within needs.
+func (h Hits) Rank(r *Ranking) {
+	if r == nil || r.Secondary == nil {
+		h.Sort()
+		return
+	}
+
+	type ranked struct {
+		hit              Hit
+		score, secondary float64
+	}
+
+	rs := make([]ranked, len(h))
+	for i := range h {
+		rs[i] = ranked{hit: h[i], secondary: r.Secondary(&h[i])}
+
+		if r.Weight != 0 {
+			rs[i].score = float64(h[i].Distance) + r.Weight*rs[i].secondary
+		} else {
+			rs[i].score = float64(h[i].Distance)
+		}
+	}
+
+	sort.SliceStable(rs, func(i, j int) bool {
+		a, b := &rs[i], &rs[j]
+		switch {
+		case a.score != b.score:
+			return a.score < b.score
+		case a.hit.Distance != b.hit.Distance:
+			return a.hit.Distance < b.hit.Distance
+		case a.secondary != b.secondary:
+			return a.secondary < b.secondary
+		}
+		return a.hit.ID < b.hit.ID
+	})
+
+	for i := range rs {
+		h[i] = rs[i].hit
+	}
+}
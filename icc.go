@@ -0,0 +1,328 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ErrUnsupportedProfile is returned by ColorProfile for ICC profiles
+// which can not be converted. Only RGB matrix/TRC profiles are supported.
+// This covers the common ones, like Adobe RGB and Display P3.
+var ErrUnsupportedProfile = errors.New("imghash: unsupported ICC profile")
+
+// lutSize is the number of entries in the tone curve lookup tables.
+const lutSize = 4096
+
+// xyzToSRGB converts D50 XYZ values to linear sRGB.
+// This is the inverse of the Bradford-adapted sRGB primaries
+// as they appear in an ICC sRGB profile.
+var xyzToSRGB = [9]float64{
+	3.1338561, -1.6168667, -0.4906146,
+	-0.9787684, 1.9161415, 0.0334540,
+	0.0719453, -0.2289914, 1.4052427,
+}
+
+// srgbPrimaries holds the rXYZ, gXYZ and bXYZ columns of an sRGB profile.
+var srgbPrimaries = [9]float64{
+	0.4360747, 0.3850649, 0.1430804,
+	0.2225045, 0.7168786, 0.0606169,
+	0.0139322, 0.0971045, 0.7141733,
+}
+
+// iccProfile holds the parts of an ICC profile needed to convert
+// colours to sRGB.
+type iccProfile struct {
+	matrix [9]float64          // Linear RGB to sRGB.
+	trc    [3][lutSize]float64 // Encoded to linear, per channel.
+}
+
+// ColorProfile parses the given ICC profile and returns a Filter which
+// converts images encoded in that colour space to sRGB.
+//
+// Decode applies this automatically to images carrying an embedded
+// profile. ColorProfile is meant for callers who decode images by other
+// means, but still have access to the profile data.
+//
+// Profiles describing sRGB itself yield a Filter which returns the
+// image unchanged.
+func ColorProfile(data []byte) (Filter, error) {
+	p, err := parseICC(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if p == nil {
+		return func(img image.Image) image.Image { return img }, nil
+	}
+
+	return p.convert, nil
+}
+
+// parseICC parses an RGB matrix/TRC profile.
+// It returns nil if the profile is equivalent to sRGB.
+func parseICC(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, ErrUnsupportedProfile
+	}
+
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, ErrUnsupportedProfile
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:]))
+
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(data) {
+			return nil, ErrUnsupportedProfile
+		}
+
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, ErrUnsupportedProfile
+		}
+
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	var primaries [9]float64
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, ok := parseXYZ(tags[sig])
+		if !ok {
+			return nil, ErrUnsupportedProfile
+		}
+
+		primaries[c] = xyz[0]
+		primaries[3+c] = xyz[1]
+		primaries[6+c] = xyz[2]
+	}
+
+	if isSRGB(primaries) {
+		return nil, nil
+	}
+
+	p := new(iccProfile)
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		if !parseTRC(tags[sig], &p.trc[c]) {
+			return nil, ErrUnsupportedProfile
+		}
+	}
+
+	// Combine both conversions into a single matrix.
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			for k := 0; k < 3; k++ {
+				p.matrix[row*3+col] += xyzToSRGB[row*3+k] * primaries[k*3+col]
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// isSRGB returns true if the given primaries match those of sRGB.
+func isSRGB(primaries [9]float64) bool {
+	for i := range primaries {
+		if math.Abs(primaries[i]-srgbPrimaries[i]) > 0.002 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseXYZ reads an XYZType tag.
+func parseXYZ(tag []byte) (xyz [3]float64, ok bool) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return
+	}
+
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+i*4:])
+	}
+
+	return xyz, true
+}
+
+// parseTRC reads a curveType or parametricCurveType tag into
+// the given lookup table.
+func parseTRC(tag []byte, lut *[lutSize]float64) bool {
+	if len(tag) < 12 {
+		return false
+	}
+
+	var f func(float64) float64
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+
+		switch {
+		case n == 0:
+			f = func(v float64) float64 { return v }
+
+		case n == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			f = func(v float64) float64 { return math.Pow(v, gamma) }
+
+		// Compare n by division, as n*2 overflows int on 32-bit
+		// platforms, where n is negative for counts over 2^31.
+		case n > 1 && n <= (len(tag)-12)/2:
+			table := tag[12 : 12+n*2]
+			f = func(v float64) float64 {
+				pos := v * float64(n-1)
+				i := int(pos)
+				if i >= n-1 {
+					return float64(binary.BigEndian.Uint16(table[(n-1)*2:])) / 0xffff
+				}
+
+				a := float64(binary.BigEndian.Uint16(table[i*2:]))
+				b := float64(binary.BigEndian.Uint16(table[i*2+2:]))
+				return (a + (b-a)*(pos-float64(i))) / 0xffff
+			}
+
+		default:
+			return false
+		}
+
+	case "para":
+		f = parametricCurve(tag)
+		if f == nil {
+			return false
+		}
+
+	default:
+		return false
+	}
+
+	for i := range lut {
+		lut[i] = f(float64(i) / (lutSize - 1))
+	}
+
+	return true
+}
+
+// parametricCurve returns the function described by a
+// parametricCurveType tag, or nil if it is malformed.
+func parametricCurve(tag []byte) func(float64) float64 {
+	counts := [...]int{1, 3, 4, 5, 7}
+	kind := int(binary.BigEndian.Uint16(tag[8:]))
+
+	if kind >= len(counts) || len(tag) < 12+counts[kind]*4 {
+		return nil
+	}
+
+	// Unused parameters keep values which turn the
+	// more complex functions into the simpler ones.
+	p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+	for i := 0; i < counts[kind]; i++ {
+		p[i] = s15Fixed16(tag[12+i*4:])
+	}
+
+	g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+	switch kind {
+	case 0:
+		return func(x float64) float64 { return math.Pow(x, g) }
+	case 1:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return math.Pow(a*x+b, g)
+			}
+			return 0
+		}
+	case 2:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return math.Pow(a*x+b, g) + c
+			}
+			return c
+		}
+	case 3:
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(a*x+b, g)
+			}
+			return c * x
+		}
+	}
+
+	return func(x float64) float64 {
+		if x >= d {
+			return math.Pow(a*x+b, g) + e
+		}
+		return c*x + f
+	}
+}
+
+// s15Fixed16 decodes a signed 15.16 fixed point number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 0x10000
+}
+
+// convert returns a copy of img, converted to sRGB.
+func (p *iccProfile) convert(img image.Image) image.Image {
+	rect := img.Bounds()
+	out := image.NewNRGBA64(rect)
+
+	var x, y int
+	var c color.NRGBA64
+	var r, g, b float64
+	m := &p.matrix
+
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			c = unpremultiplied(img.At(x, y))
+
+			r = lookup(&p.trc[0], c.R)
+			g = lookup(&p.trc[1], c.G)
+			b = lookup(&p.trc[2], c.B)
+
+			c.R = encodeSRGB(m[0]*r + m[1]*g + m[2]*b)
+			c.G = encodeSRGB(m[3]*r + m[4]*g + m[5]*b)
+			c.B = encodeSRGB(m[6]*r + m[7]*g + m[8]*b)
+
+			out.SetNRGBA64(x, y, c)
+		}
+	}
+
+	return out
+}
+
+// lookup returns the linear value for v, interpolating between
+// entries of the lookup table.
+func lookup(lut *[lutSize]float64, v uint16) float64 {
+	pos := float64(v) * (lutSize - 1) / 0xffff
+	i := int(pos)
+
+	if i >= lutSize-1 {
+		return lut[lutSize-1]
+	}
+
+	return lut[i] + (lut[i+1]-lut[i])*(pos-float64(i))
+}
+
+// encodeSRGB applies the sRGB transfer function to a linear value.
+func encodeSRGB(v float64) uint16 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 0xffff
+	case v <= 0.0031308:
+		v *= 12.92
+	default:
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+
+	return uint16(v*0xffff + 0.5)
+}
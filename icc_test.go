@@ -0,0 +1,179 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// Adobe RGB (1998) primaries, adapted to D50.
+var adobePrimaries = [9]float64{
+	0.6097559, 0.2052401, 0.1492240,
+	0.3111242, 0.6256560, 0.0632197,
+	0.0194811, 0.0608902, 0.7448387,
+}
+
+func TestColorProfile(t *testing.T) {
+	f, err := ColorProfile(makeProfile(srgbPrimaries, 563))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{0x20, 0x80, 0x40, 0xff})
+
+	if f(img) != image.Image(img) {
+		t.Fatalf("sRGB profile should not alter the image\n")
+	}
+
+	f, err = ColorProfile(makeProfile(adobePrimaries, 563))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neutral colours are the same in both spaces, give or take
+	// the difference between a 2.2 gamma curve and that of sRGB.
+	img.SetRGBA(0, 0, color.RGBA{0x80, 0x80, 0x80, 0xff})
+	r, g, b, _ := f(img).At(0, 0).RGBA()
+
+	if diff(r, 0x8080) > 0x200 || diff(g, 0x8080) > 0x200 || diff(b, 0x8080) > 0x200 {
+		t.Fatalf("Unexpected colour: %x %x %x\n", r, g, b)
+	}
+
+	// Adobe RGB has a much wider green gamut than sRGB.
+	// A saturated green must become more saturated still.
+	img.SetRGBA(0, 0, color.RGBA{0x40, 0x80, 0x40, 0xff})
+	r, g, b, _ = f(img).At(0, 0).RGBA()
+
+	if r >= 0x4040 || g <= 0x8080 || b >= 0x4040 {
+		t.Fatalf("Unexpected colour: %x %x %x\n", r, g, b)
+	}
+
+	if _, err = ColorProfile([]byte("nonsense")); err != ErrUnsupportedProfile {
+		t.Fatalf("Expected ErrUnsupportedProfile, got %v\n", err)
+	}
+}
+
+func TestMalformedProfile(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	img.SetRGBA(3, 5, color.RGBA{0x40, 0x80, 0x40, 0xff})
+	want := Average(img)
+
+	for _, data := range malformedProfiles() {
+		if _, err := ColorProfile(data); err != ErrUnsupportedProfile {
+			t.Fatalf("Expected ErrUnsupportedProfile, got %v\n", err)
+		}
+
+		// Decode ignores the profile.
+		hash, err := ComputeBytes(pngWithProfile(img, data), Average)
+		if err != nil || hash != want {
+			t.Fatalf("hash %016x, %v; want %016x", hash, err, want)
+		}
+	}
+
+	// Profiles which inflate past the limit are taken to be absent.
+	data := pngWithProfile(img, make([]byte, maxProfileSize+1))
+	if p := embeddedProfile(data); p != nil {
+		t.Fatalf("read a profile of %d bytes", len(p))
+	}
+
+	data = pngWithProfile(img, makeProfile(adobePrimaries, 563))
+	if p := embeddedProfile(data); p == nil {
+		t.Fatal("no profile read")
+	}
+}
+
+// malformedProfiles returns profiles whose red TRC tag is cut short,
+// or claims more entries than it holds.
+func malformedProfiles() [][]byte {
+	var out [][]byte
+
+	for _, size := range []uint32{12, 13} {
+		data := makeProfile(adobePrimaries, 563)
+		binary.BigEndian.PutUint32(data[132+3*12+8:], size)
+		out = append(out, data)
+	}
+
+	data := makeProfile(adobePrimaries, 563)
+	offset := binary.BigEndian.Uint32(data[132+3*12+4:])
+	binary.BigEndian.PutUint32(data[offset+8:], 0xffffffff)
+	return append(out, data)
+}
+
+// pngWithProfile encodes img as a PNG, with the given profile embedded
+// in an iCCP chunk.
+func pngWithProfile(img image.Image, profile []byte) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	data := buf.Bytes()
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(profile)
+	zw.Close()
+
+	chunk := append([]byte("iCCPicc\x00\x00"), z.Bytes()...)
+	var size, crc [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(chunk)-4))
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(chunk))
+
+	// The chunk goes right after the signature and IHDR.
+	out := append([]byte{}, data[:33]...)
+	out = append(out, size[:]...)
+	out = append(out, chunk...)
+	out = append(out, crc[:]...)
+	return append(out, data[33:]...)
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// makeProfile builds a minimal RGB matrix/TRC profile with the given
+// primaries and a gamma curve, encoded as an u8Fixed8 number.
+func makeProfile(primaries [9]float64, gamma uint16) []byte {
+	sigs := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	data := make([]byte, 132+len(sigs)*12)
+
+	copy(data[16:], "RGB XYZ ")
+	copy(data[36:], "acsp")
+	binary.BigEndian.PutUint32(data[128:], uint32(len(sigs)))
+
+	for i, sig := range sigs {
+		var tag []byte
+
+		if i < 3 {
+			tag = make([]byte, 20)
+			copy(tag, "XYZ ")
+
+			for j := 0; j < 3; j++ {
+				v := int32(primaries[j*3+i] * 0x10000)
+				binary.BigEndian.PutUint32(tag[8+j*4:], uint32(v))
+			}
+		} else {
+			tag = make([]byte, 14)
+			copy(tag, "curv")
+			binary.BigEndian.PutUint32(tag[8:], 1)
+			binary.BigEndian.PutUint16(tag[12:], gamma)
+		}
+
+		entry := data[132+i*12:]
+		copy(entry, sig)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(data)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tag)))
+		data = append(data, tag...)
+	}
+
+	return data
+}
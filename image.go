@@ -10,9 +10,15 @@ import (
 )
 
 // grayscale turns the image into a grayscale image.
+// The result is kept at 16 bits per pixel, so no precision is lost
+// on high bit depth input.
 func grayscale(img image.Image) image.Image {
+	if gray, ok := plain(img).(*image.Gray16); ok {
+		return gray
+	}
+
 	rect := img.Bounds()
-	gray := image.NewGray(rect)
+	gray := image.NewGray16(rect)
 
 	var x, y int
 	for y = rect.Min.Y; y < rect.Max.Y; y++ {
@@ -25,18 +31,22 @@ func grayscale(img image.Image) image.Image {
 }
 
 // average converts the sums to averages and returns the result.
+// The sums are expected to hold 16-bit colour values.
 func average(sum []uint64, w, h int, n uint64) image.Image {
-	ret := image.NewRGBA(image.Rect(0, 0, w, h))
+	ret := image.NewRGBA64(image.Rect(0, 0, w, h))
 	pix := ret.Pix
 
 	var x, y, idx int
+	var v uint64
 	for y = 0; y < h; y++ {
 		for x = 0; x < w; x++ {
 			idx = 4 * (y*w + x)
-			pix[idx] = uint8(sum[idx] / n)
-			pix[idx+1] = uint8(sum[idx+1] / n)
-			pix[idx+2] = uint8(sum[idx+2] / n)
-			pix[idx+3] = uint8(sum[idx+3] / n)
+
+			for c := 0; c < 4; c++ {
+				v = sum[idx+c] / n
+				pix[2*(idx+c)] = uint8(v >> 8)
+				pix[2*(idx+c)+1] = uint8(v)
+			}
 		}
 	}
 
@@ -55,16 +65,60 @@ func resize(m image.Image, w, h int) image.Image {
 		return image.NewRGBA64(image.Rect(0, 0, w, h))
 	}
 
-	switch m := m.(type) {
-	case *image.RGBA:
-		return resizeRGBA(m, r, w, h)
+	var sum []uint64
+	var n uint64
+
+	m, p := pyramidOf(m)
+	if p != nil && pyramidSize%w == 0 && pyramidSize%h == 0 {
+		sum, n = p.sums(w, h)
+	} else {
+		sum, n = resizeSums(m, w, h)
+	}
+
+	return average(sum, w, h, n)
+}
+
+// resizeSums returns the colour channels of a w by h copy of the
+// non-empty image m, with each pixel as the sum of the source pixels
+// it covers, weighted by the area they cover. Divided by n, these are
+// the pixels of the copy.
+func resizeSums(m image.Image, w, h int) ([]uint64, uint64) {
+	r := m.Bounds()
 
-	case *image.YCbCr:
-		if m, ok := resizeYCbCr(m, r, w, h); ok {
-			return m
+	if !generic.Load() {
+		switch m := m.(type) {
+		case *image.RGBA:
+			return resizeRGBA(m, r, w, h)
+
+		case *image.RGBA64:
+			return resizeRGBA64(m, r, w, h)
+
+		case *image.Gray:
+			return resizeGray(m, r, w, h)
+
+		case *image.Gray16:
+			return resizeGray16(m, r, w, h)
+
+		case *image.NRGBA:
+			return resizeNRGBA(m, r, w, h)
+
+		case *image.YCbCr:
+			return resizeYCbCr(m, r, w, h)
+
+		case *image.Paletted:
+			return resizePaletted(m, r, w, h)
+
+		case *image.CMYK:
+			return resizeCMYK(m, r, w, h)
+
+		case *rawImage:
+			return resizeRaw(m, r, w, h)
 		}
 	}
 
+	m = bounded(m)
+	r = m.Bounds()
+
 	ww, hh := uint64(w), uint64(h)
 	dx, dy := uint64(r.Dx()), uint64(r.Dy())
 	n, sum := dx*dy, make([]uint64, 4*w*h)
@@ -87,7 +141,7 @@ func resize(m image.Image, w, h int) image.Image {
 			a64 = uint64(a32)
 
 			// Spread the source pixel over 1 or more destination rows.
-			py = uint64(y) * hh
+			py = uint64(y-miny) * hh
 			for remy = hh; remy > 0; {
 				qy = dy - (py % dy)
 
@@ -96,7 +150,7 @@ func resize(m image.Image, w, h int) image.Image {
 				}
 
 				// Spread the source pixel over 1 or more destination columns.
-				px = uint64(x) * ww
+				px = uint64(x-minx) * ww
 				index = 4 * ((py/dy)*ww + (px / dx))
 
 				for remx = ww; remx > 0; {
@@ -121,23 +175,11 @@ func resize(m image.Image, w, h int) image.Image {
 		}
 	}
 
-	return average(sum, w, h, n*0x0101)
+	return sum, n
 }
 
-// resizeYCbCr returns a scaled copy of the YCbCr image slice r of m.
-// The returned image has width w and height h.
-func resizeYCbCr(m *image.YCbCr, r image.Rectangle, w, h int) (image.Image, bool) {
-	var verticalRes int
-
-	switch m.SubsampleRatio {
-	case image.YCbCrSubsampleRatio420:
-		verticalRes = 2
-	case image.YCbCrSubsampleRatio422:
-		verticalRes = 1
-	default:
-		return nil, false
-	}
-
+// resizeYCbCr is resizeSums for the YCbCr image slice r of m.
+func resizeYCbCr(m *image.YCbCr, r image.Rectangle, w, h int) ([]uint64, uint64) {
 	ww, hh := uint64(w), uint64(h)
 	dx, dy := uint64(r.Dx()), uint64(r.Dy())
 	n, sum := dx*dy, make([]uint64, 4*w*h)
@@ -146,25 +188,22 @@ func resizeYCbCr(m *image.YCbCr, r image.Rectangle, w, h int) (image.Image, bool
 	var r8, g8, b8 uint8
 	var r64, g64, b64, remx, remy, index uint64
 	var py, px, qx, qy, qxy uint64
-	var Y, Cb, Cr []uint8
+	var yi, ci int
 
 	minx, miny := r.Min.X, r.Min.Y
 	maxx, maxy := r.Max.X, r.Max.Y
 
 	for y = miny; y < maxy; y++ {
-		Y = m.Y[y*m.YStride:]
-		Cb = m.Cb[y/verticalRes*m.CStride:]
-		Cr = m.Cr[y/verticalRes*m.CStride:]
-
 		for x = minx; x < maxx; x++ {
 			// Get the source pixel.
-			r8, g8, b8 = color.YCbCrToRGB(Y[x], Cb[x/2], Cr[x/2])
-			r64 = uint64(r8)
-			g64 = uint64(g8)
-			b64 = uint64(b8)
+			yi, ci = m.YOffset(x, y), m.COffset(x, y)
+			r8, g8, b8 = color.YCbCrToRGB(m.Y[yi], m.Cb[ci], m.Cr[ci])
+			r64 = uint64(r8) * 0x101
+			g64 = uint64(g8) * 0x101
+			b64 = uint64(b8) * 0x101
 
 			// Spread the source pixel over 1 or more destination rows.
-			py = uint64(y) * hh
+			py = uint64(y-miny) * hh
 
 			for remy = hh; remy > 0; {
 				qy = dy - (py % dy)
@@ -174,7 +213,7 @@ func resizeYCbCr(m *image.YCbCr, r image.Rectangle, w, h int) (image.Image, bool
 				}
 
 				// Spread the source pixel over 1 or more destination columns.
-				px = uint64(x) * ww
+				px = uint64(x-minx) * ww
 				index = 4 * ((py/dy)*ww + (px / dx))
 
 				for remx = ww; remx > 0; {
@@ -200,12 +239,11 @@ func resizeYCbCr(m *image.YCbCr, r image.Rectangle, w, h int) (image.Image, bool
 		}
 	}
 
-	return average(sum, w, h, n), true
+	return sum, n
 }
 
-// resizeRGBA returns a scaled copy of the RGBA image slice r of m.
-// The returned image has width w and height h.
-func resizeRGBA(m *image.RGBA, r image.Rectangle, w, h int) image.Image {
+// resizeRGBA is resizeSums for the RGBA image slice r of m.
+func resizeRGBA(m *image.RGBA, r image.Rectangle, w, h int) ([]uint64, uint64) {
 	ww, hh := uint64(w), uint64(h)
 	dx, dy := uint64(r.Dx()), uint64(r.Dy())
 	n, sum := dx*dy, make([]uint64, 4*w*h)
@@ -223,14 +261,14 @@ func resizeRGBA(m *image.RGBA, r image.Rectangle, w, h int) image.Image {
 
 		for x = minx; x < maxx; x++ {
 			// Get the source pixel.
-			r64 = uint64(m.Pix[pixOffset+0])
-			g64 = uint64(m.Pix[pixOffset+1])
-			b64 = uint64(m.Pix[pixOffset+2])
-			a64 = uint64(m.Pix[pixOffset+3])
+			r64 = uint64(m.Pix[pixOffset+0]) * 0x101
+			g64 = uint64(m.Pix[pixOffset+1]) * 0x101
+			b64 = uint64(m.Pix[pixOffset+2]) * 0x101
+			a64 = uint64(m.Pix[pixOffset+3]) * 0x101
 			pixOffset += 4
 
 			// Spread the source pixel over 1 or more destination rows.
-			py = uint64(y) * hh
+			py = uint64(y-miny) * hh
 
 			for remy = hh; remy > 0; {
 				qy = dy - (py % dy)
@@ -240,7 +278,7 @@ func resizeRGBA(m *image.RGBA, r image.Rectangle, w, h int) image.Image {
 				}
 
 				// Spread the source pixel over 1 or more destination columns.
-				px = uint64(x) * ww
+				px = uint64(x-minx) * ww
 				index = 4 * ((py/dy)*ww + (px / dx))
 
 				for remx = ww; remx > 0; {
@@ -266,5 +304,230 @@ func resizeRGBA(m *image.RGBA, r image.Rectangle, w, h int) image.Image {
 		}
 	}
 
-	return average(sum, w, h, n)
+	return sum, n
+}
+
+// resizeRGBA64 is resizeSums for the RGBA64 image slice r of m.
+func resizeRGBA64(m *image.RGBA64, r image.Rectangle, w, h int) ([]uint64, uint64) {
+	ww, hh := uint64(w), uint64(h)
+	dx, dy := uint64(r.Dx()), uint64(r.Dy())
+	n, sum := dx*dy, make([]uint64, 4*w*h)
+
+	var x, y int
+	var p []uint8
+	var pixOffset int
+	var r64, g64, b64, a64 uint64
+
+	minx, miny := r.Min.X, r.Min.Y
+	maxx, maxy := r.Max.X, r.Max.Y
+
+	for y = miny; y < maxy; y++ {
+		pixOffset = m.PixOffset(minx, y)
+
+		for x = minx; x < maxx; x++ {
+			// Get the source pixel.
+			p = m.Pix[pixOffset : pixOffset+8]
+			r64 = uint64(p[0])<<8 | uint64(p[1])
+			g64 = uint64(p[2])<<8 | uint64(p[3])
+			b64 = uint64(p[4])<<8 | uint64(p[5])
+			a64 = uint64(p[6])<<8 | uint64(p[7])
+			pixOffset += 8
+
+			spread(sum, x-minx, y-miny, ww, hh, dx, dy, r64, g64, b64, a64)
+		}
+	}
+
+	return sum, n
+}
+
+// resizeNRGBA is resizeSums for the NRGBA image slice r of m.
+func resizeNRGBA(m *image.NRGBA, r image.Rectangle, w, h int) ([]uint64, uint64) {
+	ww, hh := uint64(w), uint64(h)
+	dx, dy := uint64(r.Dx()), uint64(r.Dy())
+	n, sum := dx*dy, make([]uint64, 4*w*h)
+
+	var x, y int
+	var p []uint8
+	var pixOffset int
+	var r64, g64, b64, a64 uint64
+
+	minx, miny := r.Min.X, r.Min.Y
+	maxx, maxy := r.Max.X, r.Max.Y
+
+	for y = miny; y < maxy; y++ {
+		pixOffset = m.PixOffset(minx, y)
+
+		for x = minx; x < maxx; x++ {
+			// Get the source pixel, premultiplied as color.NRGBA does.
+			p = m.Pix[pixOffset : pixOffset+4]
+			a64 = uint64(p[3]) * 0x101
+			r64 = uint64(p[0]) * 0x101 * a64 / 0xffff
+			g64 = uint64(p[1]) * 0x101 * a64 / 0xffff
+			b64 = uint64(p[2]) * 0x101 * a64 / 0xffff
+			pixOffset += 4
+
+			spread(sum, x-minx, y-miny, ww, hh, dx, dy, r64, g64, b64, a64)
+		}
+	}
+
+	return sum, n
+}
+
+// resizeGray is resizeSums for the Gray image slice r of m.
+func resizeGray(m *image.Gray, r image.Rectangle, w, h int) ([]uint64, uint64) {
+	ww, hh := uint64(w), uint64(h)
+	dx, dy := uint64(r.Dx()), uint64(r.Dy())
+	n, sum := dx*dy, make([]uint64, 4*w*h)
+
+	var x, y int
+	var pixOffset int
+	var v uint64
+
+	minx, miny := r.Min.X, r.Min.Y
+	maxx, maxy := r.Max.X, r.Max.Y
+
+	for y = miny; y < maxy; y++ {
+		pixOffset = m.PixOffset(minx, y)
+
+		for x = minx; x < maxx; x++ {
+			v = uint64(m.Pix[pixOffset]) * 0x101
+			pixOffset++
+
+			spread(sum, x-minx, y-miny, ww, hh, dx, dy, v, v, v, 0xffff)
+		}
+	}
+
+	return sum, n
+}
+
+// resizeGray16 is resizeSums for the Gray16 image slice r of m.
+func resizeGray16(m *image.Gray16, r image.Rectangle, w, h int) ([]uint64, uint64) {
+	ww, hh := uint64(w), uint64(h)
+	dx, dy := uint64(r.Dx()), uint64(r.Dy())
+	n, sum := dx*dy, make([]uint64, 4*w*h)
+
+	var x, y int
+	var pixOffset int
+	var v uint64
+
+	minx, miny := r.Min.X, r.Min.Y
+	maxx, maxy := r.Max.X, r.Max.Y
+
+	for y = miny; y < maxy; y++ {
+		pixOffset = m.PixOffset(minx, y)
+
+		for x = minx; x < maxx; x++ {
+			// Get the source pixel.
+			v = uint64(m.Pix[pixOffset])<<8 | uint64(m.Pix[pixOffset+1])
+			pixOffset += 2
+
+			spread(sum, x-minx, y-miny, ww, hh, dx, dy, v, v, v, 0xffff)
+		}
+	}
+
+	return sum, n
+}
+
+// resizePaletted is resizeSums for the Paletted image slice r of m.
+// Colours are looked up once per palette entry, rather than once per
+// pixel.
+func resizePaletted(m *image.Paletted, r image.Rectangle, w, h int) ([]uint64, uint64) {
+	ww, hh := uint64(w), uint64(h)
+	dx, dy := uint64(r.Dx()), uint64(r.Dy())
+	n, sum := dx*dy, make([]uint64, 4*w*h)
+
+	// Indices outside the palette yield transparent black.
+	var palette [256][4]uint64
+	for i, c := range m.Palette {
+		if i >= len(palette) {
+			break
+		}
+
+		r32, g32, b32, a32 := c.RGBA()
+		palette[i] = [4]uint64{uint64(r32), uint64(g32), uint64(b32), uint64(a32)}
+	}
+
+	var x, y int
+	var pixOffset int
+	var c *[4]uint64
+
+	minx, miny := r.Min.X, r.Min.Y
+	maxx, maxy := r.Max.X, r.Max.Y
+
+	for y = miny; y < maxy; y++ {
+		pixOffset = m.PixOffset(minx, y)
+
+		for x = minx; x < maxx; x++ {
+			c = &palette[m.Pix[pixOffset]]
+			pixOffset++
+
+			spread(sum, x-minx, y-miny, ww, hh, dx, dy, c[0], c[1], c[2], c[3])
+		}
+	}
+
+	return sum, n
+}
+
+// resizeRaw is resizeSums for the raw frame slice r of m.
+func resizeRaw(m *rawImage, r image.Rectangle, w, h int) ([]uint64, uint64) {
+	ww, hh := uint64(w), uint64(h)
+	dx, dy := uint64(r.Dx()), uint64(r.Dy())
+	n, sum := dx*dy, make([]uint64, 4*w*h)
+
+	var x, y int
+	var r32, g32, b32, a32 uint32
+
+	minx, miny := r.Min.X, r.Min.Y
+	maxx, maxy := r.Max.X, r.Max.Y
+
+	for y = miny; y < maxy; y++ {
+		for x = minx; x < maxx; x++ {
+			r32, g32, b32, a32 = m.pixel(x, y).RGBA()
+			spread(sum, x-minx, y-miny, ww, hh, dx, dy, uint64(r32), uint64(g32), uint64(b32), uint64(a32))
+		}
+	}
+
+	return sum, n
+}
+
+// spread adds the source pixel at (x, y) to all destination pixels
+// it overlaps, weighted by the amount of overlap.
+func spread(sum []uint64, x, y int, ww, hh, dx, dy, r, g, b, a uint64) {
+	var remx, remy, index uint64
+	var py, px, qx, qy, qxy uint64
+
+	// Spread the source pixel over 1 or more destination rows.
+	py = uint64(y) * hh
+
+	for remy = hh; remy > 0; {
+		qy = dy - (py % dy)
+
+		if qy > remy {
+			qy = remy
+		}
+
+		// Spread the source pixel over 1 or more destination columns.
+		px = uint64(x) * ww
+		index = 4 * ((py/dy)*ww + (px / dx))
+
+		for remx = ww; remx > 0; {
+			qx = dx - (px % dx)
+
+			if qx > remx {
+				qx = remx
+			}
+
+			qxy = qx * qy
+			sum[index] += r * qxy
+			sum[index+1] += g * qxy
+			sum[index+2] += b * qxy
+			sum[index+3] += a * qxy
+			index += 4
+			px += qx
+			remx -= qx
+		}
+
+		py += qy
+		remy -= qy
+	}
 }
@@ -7,10 +7,6 @@ import (
 	"flag"
 	"fmt"
 	"github.com/jteeuwen/imghash"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"os"
 	"path/filepath"
 )
@@ -27,7 +23,7 @@ func main() {
 	parseArgs()
 
 	// Compute averahe hash for the input image.
-	hash, err := getHash(imghash.Average, file)
+	hash, err := imghash.ComputeFile(file, imghash.Average)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -55,22 +51,6 @@ func main() {
 	}
 }
 
-func getHash(hf imghash.HashFunc, file string) (uint64, error) {
-	fd, err := os.Open(file)
-	if err != nil {
-		return 0, err
-	}
-
-	defer fd.Close()
-
-	img, _, err := image.Decode(fd)
-	if err != nil {
-		return 0, err
-	}
-
-	return hf(img), nil
-}
-
 func parseArgs() {
 	flag.Usage = func() {
 		fmt.Printf("Usage: %s [options] <filename>\n\n", os.Args[0])
@@ -7,10 +7,6 @@ import (
 	"flag"
 	"fmt"
 	"github.com/jteeuwen/imghash"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"os"
 	"path"
 	"path/filepath"
@@ -100,7 +96,7 @@ func writeDB(file string, modtime int64) bool {
 		return false
 	}
 
-	hash, err := getHash(file)
+	hash, err := imghash.ComputeFile(file, hasher)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
 		return false
@@ -110,23 +106,6 @@ func writeDB(file string, modtime int64) bool {
 	return true
 }
 
-// getHash creates a perceptual hash for the given file.
-func getHash(file string) (uint64, error) {
-	fd, err := os.Open(file)
-	if err != nil {
-		return 0, err
-	}
-
-	defer fd.Close()
-
-	img, _, err := image.Decode(fd)
-	if err != nil {
-		return 0, err
-	}
-
-	return hasher(img), nil
-}
-
 // prettySize returns a human-friendly version of the given
 // file size in bytes.
 func prettySize(size uint64) string {
@@ -0,0 +1,79 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+// DefaultRefineMargin is the margin of Index.Incremental, when given 0.
+const DefaultRefineMargin = 4
+
+// An IncrementalSearch answers a stream of queries, each a refinement
+// of the one before: the hashes of a query image as a user crops and
+// adjusts it, say. It finds what Index.Search does, but walks the tree
+// far less often.
+//
+// Each walk collects the entries within the margin, beyond the distance
+// asked for. Later queries whose hash is no further from that of the
+// walk than the margin allows are then answered from those entries
+// alone, by the triangle inequality. A query which moves further starts
+// a new walk, around its own hash.
+//
+// The search runs on a snapshot of the index, taken when it was made,
+// and does not see later changes to it. An IncrementalSearch is not safe
+// for concurrent use.
+type IncrementalSearch struct {
+	index  *Index
+	margin uint64
+
+	walked     bool
+	hash       uint64            // Hash around which the candidates were collected.
+	radius     uint64            // Distance up to which they were collected.
+	candidates []*bkNode[string] // Nodes with IDs within radius of hash.
+}
+
+// Incremental returns an IncrementalSearch over a snapshot of the index,
+// which collects candidates up to margin beyond the distance of each
+// query, or DefaultRefineMargin for 0. A wider margin lets queries move
+// further before the next walk, at the cost of more candidates to check
+// on each. Taking the snapshot is a change to the index, as for Snapshot.
+func (x *Index) Incremental(margin uint64) *IncrementalSearch {
+	if margin == 0 {
+		margin = DefaultRefineMargin
+	}
+
+	return &IncrementalSearch{index: x.Snapshot(), margin: margin}
+}
+
+// Search finds the entries within distance of the hash, as Index.Search
+// does.
+func (s *IncrementalSearch) Search(hash, distance uint64) Hits {
+	visited := len(s.candidates)
+
+	// Everything within distance of the query is within radius of
+	// the hash the candidates were collected for, if the query lies
+	// within radius-distance of it.
+	if d := Distance(hash, s.hash); !s.walked || d > s.radius || distance > s.radius-d {
+		s.walked, s.hash, s.radius = true, hash, distance+s.margin
+		if s.radius < distance {
+			s.radius = distance
+		}
+
+		s.candidates = s.candidates[:0]
+		visited = s.index.visit(s.index.root, hash, s.radius, func(n *bkNode[string], _ uint64) {
+			s.candidates = append(s.candidates, n)
+		})
+	}
+
+	var hits Hits
+	for _, n := range s.candidates {
+		if dist := Distance(n.hash, hash); dist <= distance {
+			for _, id := range n.ids {
+				hits = append(hits, Hit{Record{ID: id, Hash: n.hash, Meta: s.index.meta[id]}, dist})
+			}
+		}
+	}
+
+	currentMetrics().IndexQueried(visited, len(hits))
+
+	hits.Sort()
+	return hits
+}
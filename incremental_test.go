@@ -0,0 +1,50 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestIncrementalSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x := NewIndex()
+
+	for i := 0; i < 2000; i++ {
+		x.Add(fmt.Sprintf("%04d", i), rng.Uint64())
+	}
+
+	// Queries drift away from a stored hash, a bit at a time: first
+	// within the margin, then beyond it.
+	start, _ := x.Hash("0042")
+	for i := 0; i < 8; i++ {
+		x.Add(fmt.Sprintf("near%d", i), start^1<<uint(rng.Intn(64))^1<<uint(rng.Intn(64)))
+	}
+
+	s := x.Incremental(0)
+	x.Add("later", start)
+
+	hash := start
+	for i := 0; i < 40; i++ {
+		want := x.Snapshot()
+		want.Remove("later")
+
+		if got, want := s.Search(hash, 6), want.Search(hash, 6); !reflect.DeepEqual(got, want) {
+			t.Fatalf("query %d: hits %v, want %v", i, got.IDs(), want.IDs())
+		}
+
+		if i < DefaultRefineMargin && s.hash != start {
+			t.Fatalf("query %d walked the tree again", i)
+		}
+
+		hash ^= 1 << uint(i%64)
+	}
+
+	if s.hash == start {
+		t.Fatal("distant query answered from stale candidates")
+	}
+}
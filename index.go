@@ -0,0 +1,800 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// indexMagic identifies the persistent index format. Indexes with
+// metadata are written in the second version of it.
+const (
+	indexMagic     = "IMGHIDX1"
+	indexMagicMeta = "IMGHIDX2"
+)
+
+// ErrInvalidIndex is returned when loading a malformed index file.
+var ErrInvalidIndex = errors.New("imghash: invalid index file")
+
+// An Index holds hashes by ID and answers radius queries: it finds all
+// hashes within a given Hamming Distance of a query hash.
+//
+// It is implemented as a BK-tree. This exploits the fact that Hamming
+// Distance is a metric, to avoid comparing the query against most of
+// the stored hashes. Unlike Database.Find, this keeps queries fast for
+// large collections.
+//
+// An Index is not safe for concurrent use, but its snapshots can be used
+// alongside it; refer to Snapshot.
+type Index struct {
+	Algorithm string // Name of the hashing algorithm used, if known.
+
+	bkTree[string]
+	ids  map[string]uint64            // Hash for each ID.
+	meta map[string]map[string]string // Metadata, for IDs which have any.
+
+	// Set if the metadata is shared with snapshots, and must be copied
+	// before it is changed.
+	metaShared bool
+
+	// Set for snapshots, whose ids are collected from the tree on
+	// first use.
+	collect *sync.Once
+}
+
+// bkNode is a single node in a BK-tree. Its children are keyed by
+// their distance to the node's hash.
+type bkNode[K comparable] struct {
+	hash     uint64
+	ids      []K
+	children map[uint64]*bkNode[K]
+	gen      uint64 // Generation of the index which created the node.
+
+	// The children sorted by distance, once Warm has run, until they
+	// change.
+	edges []bkEdge[K]
+}
+
+// setChild sets the child at the given distance.
+func (n *bkNode[K]) setChild(dist uint64, child *bkNode[K]) {
+	if n.children == nil {
+		n.children = make(map[uint64]*bkNode[K])
+	}
+
+	if n.children[dist] != child {
+		n.edges = nil
+	}
+
+	n.children[dist] = child
+}
+
+// bkTree is a BK-tree of IDs of type K, by hash. It leaves the lookup
+// of IDs to the index it is part of.
+type bkTree[K comparable] struct {
+	root *bkNode[K]
+
+	// Nodes of other generations are shared with snapshots, and are
+	// copied before they are changed.
+	gen uint64
+}
+
+// insert adds the ID to the node of the hash, which is created if there
+// is none.
+func (t *bkTree[K]) insert(id K, hash uint64) {
+	if t.root == nil {
+		t.root = &bkNode[K]{hash: hash, ids: []K{id}, gen: t.gen}
+		return
+	}
+
+	t.root = t.writable(t.root)
+	node := t.root
+	for {
+		dist := Distance(node.hash, hash)
+		if dist == 0 {
+			node.ids = append(node.ids, id)
+			return
+		}
+
+		child, ok := node.children[dist]
+		if !ok {
+			node.setChild(dist, &bkNode[K]{hash: hash, ids: []K{id}, gen: t.gen})
+			return
+		}
+
+		child = t.writable(child)
+		node.setChild(dist, child)
+		node = child
+	}
+}
+
+// delete removes the ID from the node of the hash. The node itself is
+// left as-is, so removal is cheap.
+func (t *bkTree[K]) delete(id K, hash uint64) {
+	if t.root == nil {
+		return
+	}
+
+	t.root = t.writable(t.root)
+	node := t.root
+	for {
+		dist := Distance(node.hash, hash)
+		if dist == 0 {
+			for i, v := range node.ids {
+				if v == id {
+					node.ids = append(node.ids[:i], node.ids[i+1:]...)
+					break
+				}
+			}
+			return
+		}
+
+		child := node.children[dist]
+		if child == nil {
+			return
+		}
+
+		child = t.writable(child)
+		node.setChild(dist, child)
+		node = child
+	}
+}
+
+// indexGen is the last generation handed out to an index.
+var indexGen uint64
+
+// NewIndex creates a new, empty index.
+func NewIndex() *Index {
+	return &Index{
+		bkTree: bkTree[string]{gen: atomic.AddUint64(&indexGen, 1)},
+		ids:    make(map[string]uint64),
+	}
+}
+
+// Snapshot returns a copy of the index. It takes constant time: the
+// copy shares all of its data with the index, and either copies only
+// the parts it changes, when it changes them.
+//
+// A snapshot can be queried and saved while the index it was taken
+// from goes on serving queries and changes, without locks between the
+// two. This lets a server write its index to disk, or send it to a
+// replica, without a pause. Taking the snapshot is a change to the
+// index; it must not happen concurrently with other calls on it.
+func (x *Index) Snapshot() *Index {
+	x.load()
+
+	s := &Index{
+		Algorithm:  x.Algorithm,
+		bkTree:     bkTree[string]{root: x.root, gen: atomic.AddUint64(&indexGen, 1)},
+		meta:       x.meta,
+		metaShared: true,
+		collect:    new(sync.Once),
+	}
+
+	x.gen = atomic.AddUint64(&indexGen, 1)
+	x.metaShared = true
+	return s
+}
+
+// load collects the IDs of a snapshot from its tree, the first time
+// they are needed.
+func (x *Index) load() {
+	if x.collect == nil {
+		return
+	}
+
+	x.collect.Do(func() {
+		x.ids = make(map[string]uint64)
+
+		var walk func(*bkNode[string])
+		walk = func(n *bkNode[string]) {
+			for _, id := range n.ids {
+				x.ids[id] = n.hash
+			}
+
+			for _, child := range n.children {
+				walk(child)
+			}
+		}
+
+		if x.root != nil {
+			walk(x.root)
+		}
+	})
+}
+
+// writable returns node, or a copy of it if it is shared with another
+// index, which can be changed freely.
+func (t *bkTree[K]) writable(node *bkNode[K]) *bkNode[K] {
+	if node.gen == t.gen {
+		return node
+	}
+
+	c := &bkNode[K]{hash: node.hash, ids: append([]K(nil), node.ids...), gen: t.gen}
+	if len(node.children) > 0 {
+		c.children = make(map[uint64]*bkNode[K], len(node.children))
+		for d, child := range node.children {
+			c.children[d] = child
+		}
+	}
+
+	return c
+}
+
+// writableMeta makes the metadata safe to change.
+func (x *Index) writableMeta() {
+	if !x.metaShared {
+		return
+	}
+
+	meta := make(map[string]map[string]string, len(x.meta))
+	for id, m := range x.meta {
+		meta[id] = m
+	}
+
+	x.meta = meta
+	x.metaShared = false
+}
+
+// Len returns the number of IDs in the index.
+func (x *Index) Len() int {
+	x.load()
+	return len(x.ids)
+}
+
+// Add adds the given ID and hash to the index. If the ID
+// already exists, its hash is replaced.
+func (x *Index) Add(id string, hash uint64) {
+	x.load()
+
+	if _, ok := x.ids[id]; ok {
+		// Keep the metadata of the entry being replaced.
+		defer x.SetMeta(id, x.meta[id])
+		x.Remove(id)
+	}
+
+	x.ids[id] = hash
+	x.insert(id, hash)
+}
+
+// Remove removes the given ID from the index.
+// The tree itself is left as-is, so removal is cheap; Compact
+// tidies it up.
+func (x *Index) Remove(id string) {
+	x.load()
+
+	hash, ok := x.ids[id]
+	if !ok {
+		return
+	}
+
+	delete(x.ids, id)
+	if _, ok := x.meta[id]; ok {
+		x.writableMeta()
+		delete(x.meta, id)
+	}
+
+	x.delete(id, hash)
+}
+
+// Hash returns the hash for the given ID.
+func (x *Index) Hash(id string) (uint64, bool) {
+	x.load()
+	hash, ok := x.ids[id]
+	return hash, ok
+}
+
+// IDs returns all IDs in the index, sorted.
+func (x *Index) IDs() []string {
+	x.load()
+	ids := make([]string, 0, len(x.ids))
+	for id := range x.ids {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// SetMeta attaches metadata to the given ID, which must be in the index.
+// It replaces any metadata set before, and is kept until the ID is
+// removed. Nil metadata removes it.
+func (x *Index) SetMeta(id string, meta map[string]string) {
+	x.load()
+
+	if _, ok := x.ids[id]; !ok {
+		return
+	}
+
+	if len(meta) == 0 && x.meta[id] == nil {
+		return
+	}
+
+	x.writableMeta()
+
+	if len(meta) == 0 {
+		delete(x.meta, id)
+		return
+	}
+
+	if x.meta == nil {
+		x.meta = make(map[string]map[string]string)
+	}
+
+	x.meta[id] = meta
+}
+
+// Meta returns the metadata of the given ID, if it has any.
+func (x *Index) Meta(id string) map[string]string {
+	return x.meta[id]
+}
+
+// Search is like Query, but returns the ID and metadata of every entry
+// along with its hash and distance. The hits are sorted by distance,
+// then by ID.
+func (x *Index) Search(hash, distance uint64) Hits {
+	var hits Hits
+
+	visited := x.visit(x.root, hash, distance, func(n *bkNode[string], dist uint64) {
+		for _, id := range n.ids {
+			hits = append(hits, Hit{Record{ID: id, Hash: n.hash, Meta: x.meta[id]}, dist})
+		}
+	})
+
+	currentMetrics().IndexQueried(visited, len(hits))
+
+	hits.Sort()
+	return hits
+}
+
+// SearchRanked is like Search, but orders the hits with the given
+// Ranking, as Hits.Rank does. The search itself is by the primary hash
+// alone; the Ranking only reorders what it finds:
+//
+//	hits := index.SearchRanked(Average(img), 9, &Ranking{
+//		Secondary: MetaHash("colour", colour(img)),
+//	})
+func (x *Index) SearchRanked(hash, distance uint64, r *Ranking) Hits {
+	hits := x.Search(hash, distance)
+	if r != nil {
+		hits.Rank(r)
+	}
+	return hits
+}
+
+// SearchOriented is like Search, but finds the entries which match the
+// query in any of the eight orientations, as Orientations computes
+// them. Mirrored and rotated copies of an image are then found along
+// with the others, in a single pass over the index. Each hit holds the
+// orientation with the smallest distance, and the first of those if
+// several tie; the entry looks like the query image in it. This only
+// applies to the hashes Orient does.
+func (x *Index) SearchOriented(hash, distance uint64) []OrientedHit {
+	var hits []OrientedHit
+	hashes := Orientations(hash)
+
+	visited := x.visitOriented(x.root, &hashes, distance, func(n *bkNode[string], dists *[8]uint64) {
+		o := Identity
+		for i := range dists {
+			if dists[i] < dists[o] {
+				o = Orientation(i)
+			}
+		}
+
+		if dists[o] > distance {
+			return
+		}
+
+		for _, id := range n.ids {
+			hits = append(hits, OrientedHit{Hit{Record{ID: id, Hash: n.hash, Meta: x.meta[id]}, dists[o]}, o})
+		}
+	})
+
+	currentMetrics().IndexQueried(visited, len(hits))
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Distance != hits[j].Distance {
+			return hits[i].Distance < hits[j].Distance
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	return hits
+}
+
+// Query finds all entries which have a Hamming Distance <= to
+// the specified distance with the given hash. The list is sorted by
+// distance. The Path field of each result holds the ID.
+func (x *Index) Query(hash, distance uint64) ResultSet {
+	var rs ResultSet
+
+	visited := x.visit(x.root, hash, distance, func(n *bkNode[string], dist uint64) {
+		for _, id := range n.ids {
+			rs = append(rs, &SearchResult{Path: id, Hash: n.hash, Distance: dist})
+		}
+	})
+
+	currentMetrics().IndexQueried(visited, len(rs))
+
+	sort.Stable(rs)
+	return rs
+}
+
+// SearchFunc calls f for every entry within distance of hash, as Search
+// would find it, until f returns false. The hits come in the order of
+// the tree, not by distance, and are never collected: broad queries
+// for common hashes, which match a large part of the index, take no
+// memory for their results, and stop as soon as the caller has seen
+// enough of them. f must not change the index.
+func (x *Index) SearchFunc(hash, distance uint64, f func(Hit) bool) {
+	var n int
+	visited, _ := x.visitUntil(x.root, hash, distance, func(node *bkNode[string], dist uint64) bool {
+		for _, id := range node.ids {
+			n++
+			if !f(Hit{Record{ID: id, Hash: node.hash, Meta: x.meta[id]}, dist}) {
+				return false
+			}
+		}
+		return true
+	})
+
+	currentMetrics().IndexQueried(visited, n)
+}
+
+// Count returns the number of entries within distance of hash, as Query
+// would find them, without collecting them. Dashboards which only ask
+// how many copies of an image there are need not pay for the results.
+func (x *Index) Count(hash, distance uint64) int {
+	n, visited := x.count(hash, distance)
+	currentMetrics().IndexQueried(visited, n)
+	return n
+}
+
+// count returns the number of IDs within distance of hash, and the
+// number of nodes it visited.
+func (t *bkTree[K]) count(hash, distance uint64) (n, visited int) {
+	visited = t.visit(t.root, hash, distance, func(node *bkNode[K], _ uint64) {
+		n += len(node.ids)
+	})
+	return n, visited
+}
+
+// visit calls f for every node within distance of hash.
+// It returns the number of nodes it visited.
+func (t *bkTree[K]) visit(node *bkNode[K], hash, distance uint64, f func(*bkNode[K], uint64)) int {
+	visited, _ := t.visitUntil(node, hash, distance, func(n *bkNode[K], dist uint64) bool {
+		f(n, dist)
+		return true
+	})
+	return visited
+}
+
+// visitUntil is visit, but stops as soon as f returns false. It also
+// returns false if it stopped.
+func (t *bkTree[K]) visitUntil(node *bkNode[K], hash, distance uint64, f func(*bkNode[K], uint64) bool) (int, bool) {
+	if node == nil {
+		return 0, true
+	}
+
+	visited := 1
+	dist := Distance(node.hash, hash)
+	if dist <= distance && len(node.ids) > 0 && !f(node, dist) {
+		return visited, false
+	}
+
+	// By the triangle inequality, matches can only be found in
+	// children at a distance of dist-distance to dist+distance.
+	min := uint64(0)
+	if dist > distance {
+		min = dist - distance
+	}
+
+	if node.edges != nil {
+		i := sort.Search(len(node.edges), func(i int) bool { return node.edges[i].dist >= min })
+		for _, e := range node.edges[i:] {
+			if e.dist > dist+distance {
+				break
+			}
+
+			n, ok := t.visitUntil(e.node, hash, distance, f)
+			if visited += n; !ok {
+				return visited, false
+			}
+		}
+		return visited, true
+	}
+
+	for d, child := range node.children {
+		if d >= min && d <= dist+distance {
+			n, ok := t.visitUntil(child, hash, distance, f)
+			if visited += n; !ok {
+				return visited, false
+			}
+		}
+	}
+
+	return visited, true
+}
+
+// visitOriented is visit, for the eight hashes at once. It calls f for
+// every node within distance of any of them, with the distance to each.
+// Children are only passed over if none of the hashes can match there.
+func (t *bkTree[K]) visitOriented(node *bkNode[K], hashes *[8]uint64, distance uint64, f func(*bkNode[K], *[8]uint64)) int {
+	if node == nil {
+		return 0
+	}
+
+	var dists [8]uint64
+	var match bool
+
+	for i, hash := range hashes {
+		dists[i] = Distance(node.hash, hash)
+		match = match || dists[i] <= distance
+	}
+
+	if match && len(node.ids) > 0 {
+		f(node, &dists)
+	}
+
+	visited := 1
+	for d, child := range node.children {
+		for _, dist := range dists {
+			if d+distance >= dist && d <= dist+distance {
+				visited += t.visitOriented(child, hashes, distance, f)
+				break
+			}
+		}
+	}
+
+	return visited
+}
+
+// WriteTo writes the index to w, in a compact binary format.
+//
+// The format starts with the magic string "IMGHIDX1", followed by the
+// algorithm name and the number of entries. Each entry holds an 8 byte,
+// big endian hash and the ID. Strings are prefixed by their length
+// and all lengths and counts are stored as unsigned varints.
+//
+// Indexes with metadata start with "IMGHIDX2" instead. Every entry is
+// then followed by the number of metadata keys, and each key and value,
+// in key order.
+func (x *Index) WriteTo(w io.Writer) (int64, error) {
+	x.load()
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		cw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		io.WriteString(cw, s)
+	}
+
+	withMeta := len(x.meta) > 0
+	if withMeta {
+		io.WriteString(cw, indexMagicMeta)
+	} else {
+		io.WriteString(cw, indexMagic)
+	}
+
+	putString(x.Algorithm)
+	putUvarint(uint64(len(x.ids)))
+
+	// Write entries in ID order, so identical indexes
+	// produce identical files.
+	for _, id := range x.IDs() {
+		binary.BigEndian.PutUint64(buf[:], x.ids[id])
+		cw.Write(buf[:8])
+		putString(id)
+
+		if !withMeta {
+			continue
+		}
+
+		meta := x.meta[id]
+		keys := make([]string, 0, len(meta))
+		for k := range meta {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+		putUvarint(uint64(len(keys)))
+
+		for _, k := range keys {
+			putString(k)
+			putString(meta[k])
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// ReadFrom reads an index written by WriteTo from r. The entries
+// are added to the index.
+func (x *Index) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(cr, magic); err != nil {
+		return cr.n, err
+	}
+
+	withMeta := string(magic) == indexMagicMeta
+	if string(magic) != indexMagic && !withMeta {
+		return cr.n, ErrInvalidIndex
+	}
+
+	algo, err := readString(cr)
+	if err != nil {
+		return cr.n, err
+	}
+
+	count, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return cr.n, err
+	}
+
+	x.Algorithm = algo
+
+	var buf [8]byte
+	for ; count > 0; count-- {
+		if _, err = io.ReadFull(cr, buf[:]); err != nil {
+			return cr.n, err
+		}
+
+		id, err := readString(cr)
+		if err != nil {
+			return cr.n, err
+		}
+
+		x.Add(id, binary.BigEndian.Uint64(buf[:]))
+
+		if !withMeta {
+			continue
+		}
+
+		meta, err := readMeta(cr)
+		if err != nil {
+			return cr.n, err
+		}
+
+		x.SetMeta(id, meta)
+	}
+
+	return cr.n, nil
+}
+
+// Save saves the index to the given file.
+func (x *Index) Save(file string) (err error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return
+	}
+
+	if _, err = x.WriteTo(fd); err != nil {
+		fd.Close()
+		return
+	}
+
+	return fd.Close()
+}
+
+// Load loads an index from the given file.
+func (x *Index) Load(file string) (err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+
+	_, err = x.ReadFrom(fd)
+	return
+}
+
+// readMeta reads the metadata of an index entry.
+func readMeta(r *countReader) (map[string]string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	if n > 1<<16 {
+		return nil, ErrInvalidIndex
+	}
+
+	meta := make(map[string]string, n)
+	for ; n > 0; n-- {
+		k, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		meta[k] = v
+	}
+
+	return meta, nil
+}
+
+// readString reads a length-prefixed string.
+func readString(r *countReader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+
+	if size > 1<<20 {
+		return "", ErrInvalidIndex
+	}
+
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+// countWriter counts the bytes written to w. It remembers the
+// first error, after which all writes are ignored.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// countReader counts the bytes read from r.
+type countReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
@@ -0,0 +1,474 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	index := NewIndex()
+	hashes := make(map[string]uint64)
+
+	for i := 0; i < 2000; i++ {
+		id := fmt.Sprintf("%d", i)
+		hashes[id] = rng.Uint64()
+
+		// Make some of them near-duplicates of earlier ones.
+		if i > 0 && i%3 == 0 {
+			hashes[id] = hashes[fmt.Sprintf("%d", i-1)] ^ (1 << uint(rng.Intn(64)))
+		}
+
+		index.Add(id, hashes[id])
+	}
+
+	index.Remove("10")
+	delete(hashes, "10")
+
+	for _, distance := range []uint64{0, 1, 5, 20} {
+		q := hashes["42"]
+		rs := index.Query(q, distance)
+
+		var want int
+		for _, h := range hashes {
+			if Distance(h, q) <= distance {
+				want++
+			}
+		}
+
+		if len(rs) != want {
+			t.Fatalf("Distance %d: want %d results, got %d\n", distance, want, len(rs))
+		}
+
+		if n := index.Count(q, distance); n != want {
+			t.Fatalf("Distance %d: counted %d results, want %d\n", distance, n, want)
+		}
+
+		for i, r := range rs {
+			if r.Distance != Distance(r.Hash, q) || r.Hash != hashes[r.Path] {
+				t.Fatalf("Invalid result: %+v\n", r)
+			}
+
+			if i > 0 && rs[i-1].Distance > r.Distance {
+				t.Fatalf("Results not sorted\n")
+			}
+		}
+	}
+}
+
+func TestIndexSearchFunc(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	index := NewIndex()
+	for i := 0; i < 2000; i++ {
+		index.Add(fmt.Sprint(i), rng.Uint64())
+	}
+
+	q := rng.Uint64()
+	for _, distance := range []uint64{5, 20, 64} {
+		var hits Hits
+		index.SearchFunc(q, distance, func(h Hit) bool {
+			hits = append(hits, h)
+			return true
+		})
+
+		hits.Sort()
+		if want := index.Search(q, distance); !reflect.DeepEqual(hits, want) {
+			t.Fatalf("distance %d: %d hits, want %d", distance, len(hits), len(want))
+		}
+	}
+
+	var n int
+	index.SearchFunc(q, 64, func(Hit) bool {
+		n++
+		return n < 10
+	})
+
+	if n != 10 {
+		t.Fatalf("stopped after %d hits", n)
+	}
+
+	// Stores stream until the function or the context stops them.
+	store := IndexStore(index)
+	n = 0
+	err := StreamStore(context.Background(), store, q, 64, func(*SearchResult) bool {
+		n++
+		return true
+	})
+
+	if err != nil || n != 2000 {
+		t.Fatalf("stream: %d results, %v", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := StreamStore(ctx, store, q, 64, func(*SearchResult) bool { return true }); err != context.Canceled {
+		t.Fatalf("cancelled stream: %v", err)
+	}
+}
+
+func TestIndexEncoding(t *testing.T) {
+	a := NewIndex()
+	a.Algorithm = "average"
+	a.Add("foo.png", 0x0838787c7c3e3c18)
+	a.Add("bar/baz.jpg", 0xffffffff00000000)
+
+	var buf bytes.Buffer
+	n, err := a.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo reported %d bytes, wrote %d\n", n, buf.Len())
+	}
+
+	b := NewIndex()
+	if _, err = b.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Algorithm != a.Algorithm || b.Len() != a.Len() {
+		t.Fatalf("Index mismatch: %q %d\n", b.Algorithm, b.Len())
+	}
+
+	for _, id := range []string{"foo.png", "bar/baz.jpg"} {
+		ha, _ := a.Hash(id)
+		if hb, ok := b.Hash(id); !ok || ha != hb {
+			t.Fatalf("Hash mismatch for %s: 0x%x 0x%x\n", id, ha, hb)
+		}
+	}
+
+	if _, err = b.ReadFrom(bytes.NewBufferString("nonsense")); err != ErrInvalidIndex {
+		t.Fatalf("Expected ErrInvalidIndex, got %v\n", err)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	x := NewIndex()
+	x.Add("c", 0x0f)
+	x.Add("b", 0x0e)
+	x.Add("a", 0x0e)
+	x.Add("far", 0xf0)
+	x.SetMeta("b", map[string]string{"url": "https://example.com/b.png"})
+	x.SetMeta("missing", map[string]string{"k": "v"})
+
+	// Replacing the hash keeps the metadata.
+	x.Add("b", 0x0c)
+
+	hits := x.Search(0x0f, 2)
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"c", "a", "b"}) {
+		t.Fatalf("hits %v", ids)
+	}
+
+	if hits[2].Hash != 0x0c || hits[2].Distance != 2 || hits[2].Meta["url"] != "https://example.com/b.png" {
+		t.Fatalf("hit %+v", hits[2])
+	}
+
+	if within := hits.Within(1); len(within) != 2 {
+		t.Fatalf("within 1: %v", within.IDs())
+	}
+
+	hits.SortBy(func(a, b *Hit) bool { return a.ID < b.ID })
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"a", "b", "c"}) {
+		t.Fatalf("sorted by ID: %v", ids)
+	}
+
+	// Metadata survives encoding; indexes without it keep the old format.
+	var buf bytes.Buffer
+	x.WriteTo(&buf)
+
+	y := NewIndex()
+	if _, err := y.ReadFrom(&buf); err != nil || !reflect.DeepEqual(y.Meta("b"), x.Meta("b")) || y.Meta("missing") != nil {
+		t.Fatalf("decoded metadata %v, %v", y.Meta("b"), err)
+	}
+
+	x.Remove("b")
+	buf.Reset()
+	x.WriteTo(&buf)
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte(indexMagic)) {
+		t.Fatalf("format %q", buf.Bytes()[:8])
+	}
+}
+
+func TestIndexSearchRanked(t *testing.T) {
+	x := NewIndex()
+	x.Add("grey", 0x0f)
+	x.Add("colour", 0x0f)
+	x.Add("colour-crop", 0x0e)
+	x.Add("plain", 0x0f)
+	x.SetMeta("grey", map[string]string{"colour": "0000000000000000"})
+	x.SetMeta("colour", map[string]string{"colour": "00000000000000ff"})
+	x.SetMeta("colour-crop", map[string]string{"colour": "00000000000000fe"})
+
+	// Ties at distance 0 are broken by the colour hash; hits without
+	// one come last.
+	secondary := MetaHash("colour", 0xff)
+	hits := x.SearchRanked(0x0f, 1, &Ranking{Secondary: secondary})
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"colour", "grey", "plain", "colour-crop"}) {
+		t.Fatalf("ties broken: %v", ids)
+	}
+
+	// Weighted, the colour hash outranks a small structural distance.
+	hits = x.SearchRanked(0x0f, 1, &Ranking{Secondary: secondary, Weight: 0.5})
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"colour", "colour-crop", "grey", "plain"}) {
+		t.Fatalf("re-ranked: %v", ids)
+	}
+
+	if ids := x.SearchRanked(0x0f, 1, nil).IDs(); !reflect.DeepEqual(ids, x.Search(0x0f, 1).IDs()) {
+		t.Fatalf("without ranking: %v", ids)
+	}
+}
+
+func TestIndexSearchOriented(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x := NewIndex()
+
+	for i := 0; i < 2000; i++ {
+		x.Add(fmt.Sprintf("%04d", i), rng.Uint64())
+	}
+
+	// Mirrored copies of an image are found, in the orientation they
+	// were mirrored in.
+	img := blockPattern(4)
+	x.Add("mirrored", Average(OrientImage(img, FlipH)))
+	x.Add("rotated", Average(OrientImage(img, Rotate90)))
+
+	hash := Average(img)
+	hits := x.SearchOriented(hash, 0)
+
+	got := make(map[string]Orientation)
+	for _, h := range hits {
+		got[h.ID] = h.Orientation
+	}
+
+	if len(hits) != 2 || got["mirrored"] != FlipH || got["rotated"] != Rotate90 {
+		t.Fatalf("hits %+v", hits)
+	}
+
+	// A single pass finds what searching each orientation does.
+	for i := 0; i < 20; i++ {
+		hash, _ := x.Hash(fmt.Sprintf("%04d", rng.Intn(2000)))
+		hash = Orient(hash, Orientation(rng.Intn(8))) ^ 1<<uint(rng.Intn(64)) ^ 1<<uint(rng.Intn(64))
+
+		want := make(map[string]uint64)
+		for _, q := range Orientations(hash) {
+			for _, h := range x.Search(q, 14) {
+				if d, ok := want[h.ID]; !ok || h.Distance < d {
+					want[h.ID] = h.Distance
+				}
+			}
+		}
+
+		hits := x.SearchOriented(hash, 14)
+		if len(hits) != len(want) {
+			t.Fatalf("%016x: %d hits, want %d", hash, len(hits), len(want))
+		}
+
+		for j, h := range hits {
+			if h.Distance != want[h.ID] || Distance(Orient(hash, h.Orientation), h.Hash) != h.Distance {
+				t.Fatalf("%016x: hit %+v, want distance %d", hash, h, want[h.ID])
+			}
+
+			if j > 0 && hits[j-1].Distance > h.Distance {
+				t.Fatalf("%016x: hits out of order", hash)
+			}
+		}
+	}
+}
+
+func TestIndexSnapshot(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	x := NewIndex()
+	for i := 0; i < 2000; i++ {
+		x.Add(fmt.Sprint(i), rng.Uint64()&0xffff)
+	}
+
+	x.SetMeta("1", map[string]string{"k": "v"})
+
+	var want bytes.Buffer
+	x.WriteTo(&want)
+	queries := []uint64{0, 0xff, 0xf0f0, 0xffff}
+	before := make([][]string, len(queries))
+	for i, q := range queries {
+		before[i] = x.Search(q, 3).IDs()
+	}
+
+	s := x.Snapshot()
+
+	// Change the index while the snapshot is in use.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 2000; i++ {
+			id := fmt.Sprint(i)
+			switch i % 3 {
+			case 0:
+				x.Remove(id)
+			case 1:
+				x.Add(id, rng.Uint64()&0xffff)
+			default:
+				x.SetMeta(id, map[string]string{"k": id})
+			}
+		}
+
+		x.Add("new", 0)
+	}()
+
+	for i, q := range queries {
+		if got := s.Search(q, 3).IDs(); !reflect.DeepEqual(got, before[i]) {
+			t.Fatalf("query %04x: %v, want %v", q, got, before[i])
+		}
+	}
+
+	var got bytes.Buffer
+	s.WriteTo(&got)
+	<-done
+
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatal("snapshot changed along with the index")
+	}
+
+	if s.Len() != 2000 || x.Len() != 2000-667+1 {
+		t.Fatalf("%d entries in the snapshot, %d in the index", s.Len(), x.Len())
+	}
+
+	// The snapshot is an index of its own.
+	s.Add("snap", 1)
+	if _, ok := x.Hash("snap"); ok || s.Len() != 2001 || s.Meta("2")["k"] == "2" {
+		t.Fatal("change to the snapshot shows in the index")
+	}
+}
+
+func TestClusters(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Groups of near-duplicates, around random centres, and noise.
+	var entries []*Entry
+	for i := 0; i < 600; i++ {
+		hash := rng.Uint64()
+		if i%3 > 0 {
+			hash = entries[i-i%3].Hash ^ 1<<uint(rng.Intn(64))
+		}
+		entries = append(entries, &Entry{Path: fmt.Sprintf("%03d", i), Hash: hash})
+	}
+
+	// The clusters of each entry, as sets of IDs.
+	partition := func(c *Clusters) map[string]string {
+		out := make(map[string]string)
+		for _, l := range c.Labels() {
+			members := fmt.Sprint(c.Members(l))
+			for _, id := range c.Members(l) {
+				out[id] = members
+			}
+		}
+		return out
+	}
+
+	want := make(map[string]string)
+	for _, g := range Cluster(entries, 4) {
+		var ids []string
+		for _, e := range g {
+			ids = append(ids, e.Path)
+		}
+		for _, id := range ids {
+			want[id] = fmt.Sprint(ids)
+		}
+	}
+
+	// Half the entries clustered at once, the others added one by one.
+	x := NewIndex()
+	for _, e := range entries[:300] {
+		x.Add(e.Path, e.Hash)
+	}
+
+	c := NewClusters(x, 4)
+	if n := c.Update(); n != 300 {
+		t.Fatalf("clustered %d entries", n)
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	c = NewClusters(x, 4)
+	if _, err := c.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range entries[300:] {
+		label, _ := c.Add(e.Path, e.Hash)
+		if l, _ := c.Cluster(e.Path); l != label {
+			t.Fatalf("%s: added to %s, but in %s", e.Path, label, l)
+		}
+	}
+
+	if got := partition(c); !reflect.DeepEqual(got, want) {
+		t.Fatal("incremental clusters differ from Cluster")
+	}
+
+	if c.Update() != 0 {
+		t.Fatal("entries clustered twice")
+	}
+
+	// Entries linking clusters merge them.
+	x.Add("a", 0)
+	x.Add("b", 0x3f)
+	c.Update()
+
+	label, merged := c.Add("link", 0x7)
+	if la, _ := c.Cluster("a"); la != label || len(merged) != 1 || fmt.Sprint(c.Members(label)) != "[a b link]" {
+		t.Fatalf("link joined %s, merging %v: %v", label, merged, c.Members(label))
+	}
+
+	// Removed labels pass to the oldest remaining member.
+	c.Remove("a")
+	if l, _ := c.Cluster("b"); l != "b" || c.Members("a") != nil {
+		t.Fatalf("label after removal %q", l)
+	}
+
+	if _, err := NewClusters(x, 5).ReadFrom(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatal("clusters of another distance accepted")
+	}
+}
+
+func TestCluster(t *testing.T) {
+	entries := []*Entry{
+		{Path: "d", Hash: 0xff00},
+		{Path: "a", Hash: 0x0000},
+		{Path: "b", Hash: 0x0003},
+		{Path: "c", Hash: 0x000f}, // Linked to a through b.
+		{Path: "e", Hash: 0xff01},
+		{Path: "f", Hash: 0xf0f0f0f0},
+	}
+
+	clusters := Cluster(entries, 2)
+	want := [][]string{{"a", "b", "c"}, {"d", "e"}, {"f"}}
+
+	if len(clusters) != len(want) {
+		t.Fatalf("Want %d clusters, got %d\n", len(want), len(clusters))
+	}
+
+	for i, c := range clusters {
+		if len(c) != len(want[i]) {
+			t.Fatalf("Cluster %d: want %v, got %d entries\n", i, want[i], len(c))
+		}
+
+		for j, e := range c {
+			if e.Path != want[i][j] {
+				t.Fatalf("Cluster %d: want %v, got %s at %d\n", i, want[i], e.Path, j)
+			}
+		}
+	}
+}
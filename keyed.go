@@ -0,0 +1,109 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"runtime"
+	"sort"
+)
+
+// A KeyedIndex is an Index whose entries are identified by keys of any
+// comparable type, rather than by strings: UUIDs, database row IDs,
+// composite keys or pointers to records of your own. Hits carry the
+// keys themselves, so no table is needed to map them back.
+//
+// It shares the BK-tree of Index, and answers the same queries as fast.
+// Keys have no textual form, so it can not be saved, and carries no
+// metadata; keep those with the records the keys refer to. Like an
+// Index, a KeyedIndex is not safe for concurrent use.
+type KeyedIndex[K comparable] struct {
+	bkTree[K]
+	keys map[K]uint64 // Hash for each key.
+}
+
+// A KeyedHit is an entry of a KeyedIndex found by a search.
+type KeyedHit[K comparable] struct {
+	Key      K
+	Hash     uint64
+	Distance uint64
+}
+
+// NewKeyedIndex creates a new, empty index.
+func NewKeyedIndex[K comparable]() *KeyedIndex[K] {
+	return &KeyedIndex[K]{keys: make(map[K]uint64)}
+}
+
+// Len returns the number of keys in the index.
+func (x *KeyedIndex[K]) Len() int {
+	return len(x.keys)
+}
+
+// Add adds the given key and hash to the index. If the key already
+// exists, its hash is replaced.
+func (x *KeyedIndex[K]) Add(key K, hash uint64) {
+	x.Remove(key)
+	x.keys[key] = hash
+	x.insert(key, hash)
+}
+
+// Remove removes the given key from the index.
+func (x *KeyedIndex[K]) Remove(key K) {
+	hash, ok := x.keys[key]
+	if !ok {
+		return
+	}
+
+	delete(x.keys, key)
+	x.delete(key, hash)
+}
+
+// Hash returns the hash for the given key.
+func (x *KeyedIndex[K]) Hash(key K) (uint64, bool) {
+	hash, ok := x.keys[key]
+	return hash, ok
+}
+
+// Keys returns all keys in the index, in no particular order.
+func (x *KeyedIndex[K]) Keys() []K {
+	keys := make([]K, 0, len(x.keys))
+	for key := range x.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Search finds all entries within distance of hash, as Index.Search
+// does. The hits are sorted by distance; those at the same distance
+// come in no particular order.
+func (x *KeyedIndex[K]) Search(hash, distance uint64) []KeyedHit[K] {
+	var hits []KeyedHit[K]
+
+	visited := x.visit(x.root, hash, distance, func(n *bkNode[K], dist uint64) {
+		for _, key := range n.ids {
+			hits = append(hits, KeyedHit[K]{key, n.hash, dist})
+		}
+	})
+
+	currentMetrics().IndexQueried(visited, len(hits))
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Distance < hits[j].Distance })
+	return hits
+}
+
+// Count returns the number of entries within distance of hash, as
+// Index.Count does.
+func (x *KeyedIndex[K]) Count(hash, distance uint64) int {
+	n, visited := x.count(hash, distance)
+	currentMetrics().IndexQueried(visited, n)
+	return n
+}
+
+// Warm prepares the index for queries, as Index.Warm does.
+func (x *KeyedIndex[K]) Warm() IndexStats {
+	s := IndexStats{Entries: len(x.keys)}
+	x.warm(&s)
+
+	runtime.GC()
+	return s
+}
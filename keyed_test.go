@@ -0,0 +1,46 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"testing"
+)
+
+func TestKeyedIndex(t *testing.T) {
+	type key struct {
+		shard int
+		row   uint64
+	}
+
+	x := NewKeyedIndex[key]()
+	x.Add(key{1, 10}, 0x0f)
+	x.Add(key{1, 11}, 0x0e)
+	x.Add(key{2, 10}, 0xf0)
+	x.Add(key{2, 11}, 0x0f)
+
+	hits := x.Search(0x0f, 1)
+	if len(hits) != 3 || hits[0].Distance != 0 || hits[2] != (KeyedHit[key]{key{1, 11}, 0x0e, 1}) {
+		t.Fatalf("hits %+v", hits)
+	}
+
+	x.Add(key{1, 11}, 0xf1)
+	x.Remove(key{2, 11})
+	x.Remove(key{3, 0})
+
+	if hits = x.Search(0x0f, 1); len(hits) != 1 || hits[0].Key != (key{1, 10}) {
+		t.Fatalf("hits after changes %+v", hits)
+	}
+
+	if hash, ok := x.Hash(key{1, 11}); !ok || hash != 0xf1 || x.Len() != 3 || len(x.Keys()) != 3 {
+		t.Fatalf("hash %x, %v; %d keys", hash, ok, x.Len())
+	}
+
+	if s := x.Warm(); s.Entries != 3 || s.Nodes != 4 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	if hits = x.Search(0xf0, 1); len(hits) != 2 {
+		t.Fatalf("hits after warm %+v", hits)
+	}
+}
@@ -0,0 +1,125 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package keypoint
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// A Descriptor is a 256 bit BRIEF descriptor: each bit tells which of
+// two pixels near the keypoint is the brighter. Descriptors of the same
+// feature in two copies of an image differ in a few bits; those of
+// unrelated features in about half.
+type Descriptor [4]uint64
+
+// Distance returns the number of bits in which two descriptors differ.
+func (d *Descriptor) Distance(o *Descriptor) int {
+	return bits.OnesCount64(d[0]^o[0]) + bits.OnesCount64(d[1]^o[1]) +
+		bits.OnesCount64(d[2]^o[2]) + bits.OnesCount64(d[3]^o[3])
+}
+
+// patchRadius is the radius of the patch around a keypoint which its
+// descriptor samples.
+const patchRadius = 15
+
+// pattern holds the pairs of offsets compared for each bit of a
+// descriptor. They are drawn once, from a fixed seed, from an isotropic
+// Gaussian with a standard deviation of a fifth of the patch size, as
+// in the original BRIEF paper. Changing them changes every descriptor.
+var pattern = func() (p [256][4]int8) {
+	rng := rand.New(rand.NewSource(1))
+	sigma := float64(2*patchRadius+1) / 5
+
+	draw := func() int8 {
+		v := math.Round(rng.NormFloat64() * sigma)
+		return int8(math.Max(-patchRadius, math.Min(patchRadius, v)))
+	}
+
+	for i := range p {
+		p[i] = [4]int8{draw(), draw(), draw(), draw()}
+	}
+
+	return
+}()
+
+// describe returns the descriptor of the keypoint at (x, y) in the
+// smoothed image g. The patch around it must lie within the image.
+func describe(g *gray, x, y int) Descriptor {
+	var d Descriptor
+
+	for i, p := range pattern {
+		a := g.pix[(y+int(p[1]))*g.w+x+int(p[0])]
+		b := g.pix[(y+int(p[3]))*g.w+x+int(p[2])]
+		if a < b {
+			d[i/64] |= 1 << uint(i%64)
+		}
+	}
+
+	return d
+}
+
+// A Pair matches keypoint A of one image with keypoint B of another.
+type Pair struct {
+	A, B     int // Indices into the Points of either Features.
+	Distance int // Distance between their descriptors.
+}
+
+// Matching thresholds. A match must be closer than maxDistance, and
+// closer than ratio times the next best candidate, so features which
+// look like many others -- in repeating texture, say -- are left out.
+const (
+	maxDistance = 64
+	ratio       = 0.8
+)
+
+// Match returns the pairs of keypoints in a and b which are each
+// other's nearest neighbour by descriptor, and distinct enough from
+// the alternatives to be trusted.
+func Match(a, b *Features) []Pair {
+	fwd := nearest(a.Points, b.Points)
+	back := nearest(b.Points, a.Points)
+
+	var pairs []Pair
+	for i, m := range fwd {
+		if m.B < 0 || back[m.B].B != i {
+			continue
+		}
+
+		pairs = append(pairs, Pair{A: i, B: m.B, Distance: m.Distance})
+	}
+
+	return pairs
+}
+
+// nearest returns, for each keypoint in a, its best match in b, or a
+// Pair with B < 0 if it has none.
+func nearest(a, b []Keypoint) []Pair {
+	out := make([]Pair, len(a))
+
+	for i := range a {
+		best, second := math.MaxInt32, math.MaxInt32
+		out[i] = Pair{A: i, B: -1}
+
+		for j := range b {
+			d := a[i].Desc.Distance(&b[j].Desc)
+			if d < best {
+				best, second = d, best
+				out[i].B = j
+			} else if d < second {
+				second = d
+			}
+		}
+
+		if best >= maxDistance || float64(best) >= ratio*float64(second) {
+			out[i].B = -1
+			continue
+		}
+
+		out[i].Distance = best
+	}
+
+	return out
+}
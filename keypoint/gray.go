@@ -0,0 +1,152 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package keypoint
+
+import (
+	"image"
+)
+
+// gray is an 8 bit greyscale image, with pixels in row-major order.
+type gray struct {
+	pix  []uint8
+	w, h int
+}
+
+// at returns the pixel at (x, y). Pixels outside the image repeat
+// the nearest edge pixel.
+func (g *gray) at(x, y int) uint8 {
+	if x < 0 {
+		x = 0
+	} else if x >= g.w {
+		x = g.w - 1
+	}
+
+	if y < 0 {
+		y = 0
+	} else if y >= g.h {
+		y = g.h - 1
+	}
+
+	return g.pix[y*g.w+x]
+}
+
+// scaled returns the luminance of img, scaled so that its longer side
+// has size pixels, and the number of original pixels per scaled pixel.
+// Each scaled pixel averages the pixels of the original which it
+// covers, or takes the nearest one when scaling up.
+func scaled(img image.Image, size int) (*gray, float64) {
+	rect := img.Bounds()
+	sw, sh := rect.Dx(), rect.Dy()
+	if sw <= 0 || sh <= 0 {
+		return &gray{}, 1
+	}
+
+	long := sw
+	if sh > long {
+		long = sh
+	}
+
+	scale := float64(long) / float64(size)
+	w := int(float64(sw)/scale + 0.5)
+	h := int(float64(sh)/scale + 0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	// Luminance of the original, in the same weights as Go's
+	// color.GrayModel.
+	src := make([]uint32, sw*sh)
+
+	var x, y, sx, sy int
+	for y = 0; y < sh; y++ {
+		for x = 0; x < sw; x++ {
+			r, g, b, _ := img.At(rect.Min.X+x, rect.Min.Y+y).RGBA()
+			src[y*sw+x] = (19595*r + 38470*g + 7471*b + 1<<15) >> 24
+		}
+	}
+
+	// Source ranges covered by each scaled column and row.
+	span := func(i, n, limit int) (int, int) {
+		lo := i * limit / n
+		hi := (i + 1) * limit / n
+		if hi <= lo {
+			hi = lo + 1
+		}
+		return lo, hi
+	}
+
+	out := &gray{pix: make([]uint8, w*h), w: w, h: h}
+	for y = 0; y < h; y++ {
+		y0, y1 := span(y, h, sh)
+
+		for x = 0; x < w; x++ {
+			x0, x1 := span(x, w, sw)
+
+			var sum uint32
+			for sy = y0; sy < y1; sy++ {
+				for sx = x0; sx < x1; sx++ {
+					sum += src[sy*sw+sx]
+				}
+			}
+
+			n := uint32((y1 - y0) * (x1 - x0))
+			out.pix[y*w+x] = uint8((sum + n/2) / n)
+		}
+	}
+
+	return out, float64(sw) / float64(w)
+}
+
+// blur returns a copy of g, blurred twice with a box of the given
+// radius, which is close to a Gaussian.
+func (g *gray) blur(radius int) *gray {
+	out := &gray{pix: append([]uint8(nil), g.pix...), w: g.w, h: g.h}
+	if g.w == 0 || g.h == 0 {
+		return out
+	}
+
+	tmp := make([]uint8, len(g.pix))
+	for pass := 0; pass < 2; pass++ {
+		boxBlur(tmp, out.pix, g.w, g.h, 1, g.w, radius)
+		boxBlur(out.pix, tmp, g.h, g.w, g.w, 1, radius)
+	}
+
+	return out
+}
+
+// boxBlur blurs the lines of src into dst, along one axis. There are
+// n lines of length pixels each; step is the distance between pixels
+// in a line, and stride the distance between lines. Pixels past the
+// ends of a line repeat the pixel at the end.
+func boxBlur(dst, src []uint8, length, n, step, stride, radius int) {
+	size := uint32(2*radius + 1)
+
+	var line, x, j int
+	for line = 0; line < n; line++ {
+		base := line * stride
+
+		at := func(x int) int {
+			if x < 0 {
+				x = 0
+			} else if x >= length {
+				x = length - 1
+			}
+			return base + x*step
+		}
+
+		var sum uint32
+		for j = -radius; j <= radius; j++ {
+			sum += uint32(src[at(j)])
+		}
+
+		for x = 0; x < length; x++ {
+			dst[base+x*step] = uint8((sum + size/2) / size)
+			sum += uint32(src[at(x+radius+1)])
+			sum -= uint32(src[at(x-radius)])
+		}
+	}
+}
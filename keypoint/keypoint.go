@@ -0,0 +1,276 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package keypoint verifies hash matches by their geometry: two images are
+only copies if the same features show up in both, in the same layout.
+
+Flat images, and images full of texture -- gravel, foliage, fabric --
+are where perceptual hashes produce the most false positives. The hash
+of one patch of grass is much like that of another. Verify detects
+corners in both images with FAST, describes each with a binary BRIEF
+descriptor, matches them, and checks that enough of the matches agree
+on a single transform of scale, rotation and translation:
+
+	r := keypoint.Verify(a, b, nil)
+	if !r.Match {
+		// Not copies, whatever their hashes say.
+	}
+
+Matching costs some tens of milliseconds per pair, far more than
+comparing hashes, so it is meant for the candidates a hash search
+turns up. Crops, resizes, re-encoding and small rotations are
+tolerated. Descriptors are not rotation invariant, so rotations by more
+than about 10 degrees, and mirrored copies, fail to verify.
+
+Images with few corners -- flat colour, smooth gradients, a handful of
+shapes -- cannot be verified, copies or not. Result.Points tells if
+there was enough to go on.
+
+Verify is deterministic. The BRIEF pattern and the samples the
+transform is estimated from are drawn from fixed seeds, so the same
+pair of images yields the same result on every machine and run.
+*/
+package keypoint
+
+import (
+	"image"
+	"sort"
+)
+
+// Defaults for Options.
+const (
+	DefaultSize       = 256
+	DefaultThreshold  = 20
+	DefaultMaxPoints  = 500
+	DefaultMinInliers = 12
+	DefaultMinRatio   = 0.5
+)
+
+// Options configure Detect and Verify. The zero value uses the defaults.
+type Options struct {
+	// Images are scaled so their longer side has this many pixels,
+	// before keypoints are detected. Defaults to DefaultSize.
+	Size int
+
+	// Least difference in brightness, from 0 to 255, between a corner
+	// and the pixels around it. Defaults to DefaultThreshold.
+	Threshold int
+
+	// Most keypoints to keep per image, the strongest first.
+	// Defaults to DefaultMaxPoints.
+	MaxPoints int
+
+	// Least number of matches which must agree on a transform for
+	// Verify to report a match. Defaults to DefaultMinInliers.
+	MinInliers int
+
+	// Least fraction of the matches which must agree on the transform.
+	// Features which repeat, like the letters of a text, find matches
+	// in unrelated images, but these rarely agree on a transform.
+	// Defaults to DefaultMinRatio.
+	MinRatio float64
+}
+
+// defaults returns the options with their defaults applied.
+func (o *Options) defaults() Options {
+	var out Options
+	if o != nil {
+		out = *o
+	}
+
+	if out.Size <= 0 {
+		out.Size = DefaultSize
+	}
+
+	if out.Threshold <= 0 {
+		out.Threshold = DefaultThreshold
+	}
+
+	if out.MaxPoints <= 0 {
+		out.MaxPoints = DefaultMaxPoints
+	}
+
+	if out.MinInliers <= 0 {
+		out.MinInliers = DefaultMinInliers
+	}
+
+	if out.MinRatio <= 0 {
+		out.MinRatio = DefaultMinRatio
+	}
+
+	return out
+}
+
+// A Keypoint is a corner found in an image.
+type Keypoint struct {
+	// Position, in the coordinates of the scaled image. Multiply by
+	// Scale for the coordinates of the original.
+	X, Y float64
+
+	Score int        // Strength of the corner.
+	Desc  Descriptor // Appearance of its surroundings.
+}
+
+// A Features holds the keypoints of an image.
+type Features struct {
+	Points []Keypoint
+	Scale  float64 // Original pixels per scaled pixel.
+	Width  int     // Size of the scaled image.
+	Height int
+}
+
+// Detect finds the keypoints of an image. Opts may be nil, to use the
+// defaults. Flat images yield no keypoints at all.
+func Detect(img image.Image, opts *Options) *Features {
+	o := opts.defaults()
+	g, scale := scaled(img, o.Size)
+
+	points := fast(g, o.Threshold)
+	sort.Slice(points, func(i, j int) bool { return points[i].Score > points[j].Score })
+
+	// BRIEF compares pixels within a patch around the keypoint,
+	// on a smoothed copy of the image.
+	smooth := g.blur(2)
+
+	var kept []Keypoint
+	for _, p := range points {
+		if len(kept) == o.MaxPoints {
+			break
+		}
+
+		x, y := int(p.X), int(p.Y)
+		if x < patchRadius || y < patchRadius || x >= g.w-patchRadius || y >= g.h-patchRadius {
+			continue
+		}
+
+		p.Desc = describe(smooth, x, y)
+		kept = append(kept, p)
+	}
+
+	return &Features{Points: kept, Scale: scale, Width: g.w, Height: g.h}
+}
+
+// circle holds the offsets of the 16 pixels on a circle of radius 3,
+// which FAST compares against the centre.
+var circle = [16][2]int{
+	{0, -3}, {1, -3}, {2, -2}, {3, -1}, {3, 0}, {3, 1}, {2, 2}, {1, 3},
+	{0, 3}, {-1, 3}, {-2, 2}, {-3, 1}, {-3, 0}, {-3, -1}, {-2, -2}, {-1, -3},
+}
+
+// arc is the number of contiguous pixels on the circle which must all
+// be brighter, or all darker, than the centre. This is FAST-9.
+const arc = 9
+
+// fast returns the corners of g, after non-maximum suppression.
+func fast(g *gray, threshold int) []Keypoint {
+	scores := make([]int, g.w*g.h)
+
+	var x, y, i int
+	var ring [16]int
+	for y = 3; y < g.h-3; y++ {
+		for x = 3; x < g.w-3; x++ {
+			c := int(g.pix[y*g.w+x])
+			hi, lo := c+threshold, c-threshold
+
+			// Of any 9 contiguous pixels, at least two of the four
+			// on the compass points must pass. Test those first.
+			var bright, dark int
+			for i = 0; i < 16; i += 4 {
+				v := int(g.at(x+circle[i][0], y+circle[i][1]))
+				if v > hi {
+					bright++
+				} else if v < lo {
+					dark++
+				}
+			}
+
+			if bright < 2 && dark < 2 {
+				continue
+			}
+
+			for i = range ring {
+				ring[i] = int(g.at(x+circle[i][0], y+circle[i][1]))
+			}
+
+			if score := cornerScore(&ring, c, threshold); score > 0 {
+				scores[y*g.w+x] = score
+			}
+		}
+	}
+
+	// Keep corners which are the strongest among their neighbours.
+	var points []Keypoint
+	for y = 3; y < g.h-3; y++ {
+		for x = 3; x < g.w-3; x++ {
+			s := scores[y*g.w+x]
+			if s == 0 || !localMax(scores, g.w, x, y) {
+				continue
+			}
+
+			points = append(points, Keypoint{X: float64(x), Y: float64(y), Score: s})
+		}
+	}
+
+	return points
+}
+
+// cornerScore returns the score of a corner with centre value c and
+// the given ring of pixels, or 0 if it is not a corner. The score is
+// the sum of the differences beyond the threshold, over the ring.
+func cornerScore(ring *[16]int, c, threshold int) int {
+	var brighter, darker, run, best int
+
+	for _, sign := range [2]int{1, -1} {
+		run = 0
+		for i := 0; i < 16+arc; i++ {
+			if sign*(ring[i%16]-c) > threshold {
+				run++
+				if run > best {
+					best = run
+				}
+			} else {
+				run = 0
+			}
+		}
+
+		if best >= arc {
+			break
+		}
+	}
+
+	if best < arc {
+		return 0
+	}
+
+	for _, v := range ring {
+		if d := v - c; d > threshold {
+			brighter += d - threshold
+		} else if d < -threshold {
+			darker += -d - threshold
+		}
+	}
+
+	if brighter > darker {
+		return brighter
+	}
+	return darker
+}
+
+// localMax returns true if the score at (x, y) is the largest in its
+// 3x3 neighbourhood. Ties go to the first in row-major order.
+func localMax(scores []int, w, x, y int) bool {
+	s := scores[y*w+x]
+
+	var dx, dy int
+	for dy = -1; dy <= 1; dy++ {
+		for dx = -1; dx <= 1; dx++ {
+			n := scores[(y+dy)*w+x+dx]
+			if n > s || (n == s && (dy < 0 || (dy == 0 && dx < 0))) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
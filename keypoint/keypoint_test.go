@@ -0,0 +1,96 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package keypoint
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"math"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	transforms := []attack.Transform{
+		attack.JPEG(50),
+		attack.Crop(0.1),
+		attack.Resize(0.5),
+		attack.Rotate(5),
+		attack.Chain(attack.Crop(0.05), attack.JPEG(60), attack.Resize(0.7)),
+	}
+
+	var seed int64
+	for _, gen := range []synth.Generator{synth.Noise, synth.Text} {
+		for seed = 0; seed < 5; seed++ {
+			img := gen(320, 240, seed)
+
+			for _, tf := range transforms {
+				if r := Verify(img, tf.Apply(img), nil); !r.Match {
+					t.Errorf("seed %d, %s: copy not verified: %+v", seed, tf.Name, r)
+				}
+			}
+
+			// Noise shares no features with other noise. Texts share
+			// their letters, but not the layout of them.
+			if r := Verify(img, gen(320, 240, seed+100), nil); r.Match {
+				t.Errorf("seed %d: distinct images verified: %+v", seed, r)
+			}
+		}
+	}
+
+	// Without corners, there is nothing to verify.
+	if r := Verify(synth.Flat(64, 64, 1), synth.Flat(64, 64, 1), nil); r.Match || r.Points != [2]int{} {
+		t.Fatalf("flat images: %+v", r)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	img := synth.Noise(320, 240, 1)
+
+	near := func(a, b, eps float64) bool { return math.Abs(a-b) <= eps }
+
+	r := Verify(img, attack.Crop(0.1).Apply(img), nil)
+	if !near(r.Scale, 1, 0.01) || !near(r.Angle, 0, 0.01) || !near(r.DX, -16, 1) || !near(r.DY, -12, 1) {
+		t.Fatalf("crop: %+v", r)
+	}
+
+	r = Verify(img, attack.Resize(0.5).Apply(img), nil)
+	if !near(r.Scale, 0.5, 0.01) || !near(r.Angle, 0, 0.01) {
+		t.Fatalf("resize: %+v", r)
+	}
+
+	r = Verify(img, attack.Rotate(5).Apply(img), nil)
+	if !near(r.Scale, 1, 0.01) || !near(r.Angle*180/math.Pi, -5, 0.5) {
+		t.Fatalf("rotate: %+v", r)
+	}
+}
+
+func TestDescriptor(t *testing.T) {
+	a := Descriptor{0, 1, 3, 7}
+	b := Descriptor{}
+
+	if d := a.Distance(&b); d != 6 {
+		t.Fatalf("distance %d, want 6", d)
+	}
+
+	if d := a.Distance(&a); d != 0 {
+		t.Fatalf("distance to self %d", d)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	// The pattern is drawn from a fixed seed, so descriptors are the
+	// same on every machine and in every release. Any change to it
+	// changes all of them.
+	var sum, i int
+	for _, p := range pattern {
+		for _, v := range p {
+			i++
+			sum += i * int(v)
+		}
+	}
+
+	if sum != -25934 {
+		t.Fatalf("pattern checksum %d", sum)
+	}
+}
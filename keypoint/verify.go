@@ -0,0 +1,179 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package keypoint
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+	"math/rand"
+)
+
+// A Result describes how well two images agree on their keypoints.
+type Result struct {
+	Points  [2]int // Keypoints found in either image.
+	Pairs   int    // Keypoints matched by descriptor.
+	Inliers int    // Matches consistent with the transform.
+
+	// The transform which maps the first image onto the second, in the
+	// coordinates of the original images: a point is scaled, rotated
+	// counterclockwise by Angle radians, then moved by (DX, DY).
+	Scale, Angle float64
+	DX, DY       float64
+
+	// Match is set if at least Options.MinInliers matches, and
+	// Options.MinRatio of all matches, agree on the transform.
+	Match bool
+}
+
+// RANSAC parameters. Matches are inliers if the transform puts them
+// within tolerance pixels, in the scaled images, of their counterpart.
+// Transforms which scale by more than maxScale either way are not
+// considered plausible copies.
+const (
+	iterations = 500
+	tolerance  = 4
+	maxScale   = 4
+	minSpan    = 8
+)
+
+// Verify detects and matches the keypoints of a and b, and reports
+// whether enough of them agree on a single transform for the two to be
+// copies. Opts may be nil, to use the defaults.
+//
+// To check one image against many, detect its keypoints once, and use
+// VerifyFeatures.
+func Verify(a, b image.Image, opts *Options) *Result {
+	return VerifyFeatures(Detect(a, opts), Detect(b, opts), opts)
+}
+
+// VerifyFeatures is like Verify, for keypoints detected beforehand.
+// Both must be detected with the same options.
+func VerifyFeatures(a, b *Features, opts *Options) *Result {
+	o := opts.defaults()
+	pairs := Match(a, b)
+
+	r := &Result{Points: [2]int{len(a.Points), len(b.Points)}, Pairs: len(pairs)}
+	if len(pairs) < 2 || len(pairs) < o.MinInliers {
+		return r
+	}
+
+	pa := make([]complex128, len(pairs))
+	pb := make([]complex128, len(pairs))
+	for i, p := range pairs {
+		pa[i] = complex(a.Points[p.A].X, a.Points[p.A].Y)
+		pb[i] = complex(b.Points[p.B].X, b.Points[p.B].Y)
+	}
+
+	// Each pair of matches fixes a similarity transform, z -> m*z + c.
+	// Keep the one with the most inliers. The seed is fixed, so the
+	// result is the same on every run.
+	rng := rand.New(rand.NewSource(1))
+	var m, c complex128
+	best := 0
+
+	for iter := 0; iter < iterations; iter++ {
+		i := rng.Intn(len(pairs))
+		j := rng.Intn(len(pairs) - 1)
+		if j >= i {
+			j++
+		}
+
+		da := pa[j] - pa[i]
+		if cmplx.Abs(da) < minSpan {
+			continue
+		}
+
+		tm := (pb[j] - pb[i]) / da
+		if s := cmplx.Abs(tm); s > maxScale || s < 1.0/maxScale {
+			continue
+		}
+
+		tc := pb[i] - tm*pa[i]
+		if n := countInliers(pa, pb, tm, tc, nil); n > best {
+			best, m, c = n, tm, tc
+		}
+	}
+
+	if best < 2 {
+		return r
+	}
+
+	// Refine the transform by least squares over its inliers.
+	inliers := make([]bool, len(pairs))
+	countInliers(pa, pb, m, c, inliers)
+	if fm, fc, ok := fit(pa, pb, inliers); ok {
+		if n := countInliers(pa, pb, fm, fc, nil); n >= best {
+			best, m, c = n, fm, fc
+		}
+	}
+
+	// Back to the coordinates of the originals.
+	m *= complex(b.Scale/a.Scale, 0)
+	c *= complex(b.Scale, 0)
+
+	r.Inliers = best
+	r.Scale = cmplx.Abs(m)
+	r.Angle = -cmplx.Phase(m) // Image y points down.
+	r.DX, r.DY = real(c), imag(c)
+	r.Match = best >= o.MinInliers && float64(best) >= o.MinRatio*float64(len(pairs))
+	return r
+}
+
+// countInliers returns the number of matches which the transform
+// z -> m*z + c puts within tolerance of their counterpart, and marks
+// them in mark, if it is not nil.
+func countInliers(pa, pb []complex128, m, c complex128, mark []bool) int {
+	var n int
+	for i := range pa {
+		d := m*pa[i] + c - pb[i]
+		in := real(d)*real(d)+imag(d)*imag(d) <= tolerance*tolerance
+		if in {
+			n++
+		}
+
+		if mark != nil {
+			mark[i] = in
+		}
+	}
+	return n
+}
+
+// fit returns the similarity transform which maps the marked points of
+// pa closest to those of pb, in the least squares sense.
+func fit(pa, pb []complex128, mark []bool) (m, c complex128, ok bool) {
+	var ma, mb complex128
+	var n float64
+	for i := range pa {
+		if mark[i] {
+			ma += pa[i]
+			mb += pb[i]
+			n++
+		}
+	}
+
+	if n < 2 {
+		return 0, 0, false
+	}
+
+	ma /= complex(n, 0)
+	mb /= complex(n, 0)
+
+	var num complex128
+	var den float64
+	for i := range pa {
+		if mark[i] {
+			da := pa[i] - ma
+			num += (pb[i] - mb) * cmplx.Conj(da)
+			den += real(da)*real(da) + imag(da)*imag(da)
+		}
+	}
+
+	if den == 0 || math.IsNaN(den) {
+		return 0, 0, false
+	}
+
+	m = num / complex(den, 0)
+	return m, mb - m*ma, true
+}
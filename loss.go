@@ -0,0 +1,163 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// ErrLossy is the error of a file which was not hashed with Strict
+// strictness, because it would have taken a lossy conversion.
+var ErrLossy = errors.New("imghash: lossy conversion")
+
+// A Loss is a set of lossy conversions an image went through on its
+// way to a hash. Its hash was then computed from a less faithful
+// representation than the file holds.
+type Loss uint8
+
+// Known lossy conversions.
+const (
+	// CMYK pixels were converted to RGB by the naive formula of the
+	// image/color package, without a colour profile. Printed colours
+	// come out too bright and saturated.
+	LossCMYK Loss = 1 << iota
+
+	// An embedded ICC profile was not supported, and ignored. The
+	// pixels were taken as sRGB.
+	LossProfile
+
+	// Samples of more than 8 bits were reduced to 8 bits. RAW files
+	// are hashed by their embedded JPEG preview, rather than by their
+	// sensor data.
+	LossDepth
+
+	// Transparent pixels were flattened onto an opaque background,
+	// black unless a Composite filter chose another. Their colour
+	// channels play no part in the hash.
+	LossAlpha
+)
+
+var lossNames = [...]string{"cmyk", "profile", "depth", "alpha"}
+
+// String lists the conversions by name, separated by commas, like
+// "cmyk,profile". The empty set is "none".
+func (l Loss) String() string {
+	if l == 0 {
+		return "none"
+	}
+
+	var names []string
+	for i, name := range lossNames {
+		if l&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// A Strictness tells how a batch treats images which take a lossy
+// conversion before they are hashed.
+type Strictness int
+
+// Known strictness levels.
+const (
+	Lenient Strictness = iota // Lossy conversions go unnoticed.
+	Audit                     // Lossy conversions are reported.
+	Strict                    // Images which take lossy conversions fail with ErrLossy.
+)
+
+func (s Strictness) String() string {
+	switch s {
+	case Audit:
+		return "audit"
+	case Strict:
+		return "strict"
+	}
+
+	return "lenient"
+}
+
+// MarshalText implements encoding.TextMarshaler, writing the strictness
+// by name.
+func (s Strictness) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Strictness) UnmarshalText(text []byte) error {
+	for _, c := range []Strictness{Lenient, Audit, Strict} {
+		if strings.EqualFold(string(text), c.String()) {
+			*s = c
+			return nil
+		}
+	}
+
+	return fmt.Errorf("imghash: unknown strictness %q", text)
+}
+
+// DecodeAudited is like Decode, but also returns the lossy conversions
+// the image takes, in decoding and in being hashed. Archival users can
+// tell from these whether a hash describes the image as it was stored.
+//
+// Telling whether an image is transparent takes a pass over its pixels,
+// which Decode does not make.
+func DecodeAudited(r io.Reader) (image.Image, Loss, error) {
+	return decode(r, true)
+}
+
+// AuditImage returns the lossy conversions an image decoded by other
+// means than Decode takes in being hashed. It can not tell losses to
+// the file format or the colour profile.
+func AuditImage(img image.Image) Loss {
+	var loss Loss
+
+	switch img.(type) {
+	case *image.CMYK:
+		loss |= LossCMYK
+	}
+
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		loss |= LossAlpha
+	}
+
+	return loss
+}
+
+// auditKey is the context key of the auditor of a file in a batch.
+type auditKey struct{}
+
+// An auditor records the lossy conversions of a file in a batch.
+type auditor struct {
+	strictness Strictness
+	loss       Loss
+}
+
+// withAuditor returns ctx, carrying a.
+func withAuditor(ctx context.Context, a *auditor) context.Context {
+	return context.WithValue(ctx, auditKey{}, a)
+}
+
+// auditorOf returns the auditor in ctx, or nil if there is none.
+func auditorOf(ctx context.Context) *auditor {
+	a, _ := ctx.Value(auditKey{}).(*auditor)
+	return a
+}
+
+// record records the losses of a file. It returns an error if the
+// file must not be hashed with them.
+func (a *auditor) record(loss Loss) error {
+	a.loss = loss
+
+	if loss != 0 && a.strictness == Strict {
+		return &HashError{FailureLossy, fmt.Errorf("%w: %v", ErrLossy, loss)}
+	}
+
+	return nil
+}
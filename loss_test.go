@@ -0,0 +1,116 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAuditImage(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+
+	cmyk := image.NewCMYK(rect)
+	if got := AuditImage(cmyk); got != LossCMYK {
+		t.Fatalf("cmyk: %v", got)
+	}
+
+	nrgba := image.NewNRGBA(rect)
+	if got := AuditImage(nrgba); got != LossAlpha {
+		t.Fatalf("transparent: %v", got)
+	}
+
+	for i := 3; i < len(nrgba.Pix); i += 4 {
+		nrgba.Pix[i] = 0xff
+	}
+
+	if got := AuditImage(nrgba); got != 0 {
+		t.Fatalf("opaque: %v", got)
+	}
+}
+
+func TestLossString(t *testing.T) {
+	if s := (LossCMYK | LossAlpha).String(); s != "cmyk,alpha" {
+		t.Fatalf("string %q", s)
+	}
+
+	if s := Loss(0).String(); s != "none" {
+		t.Fatalf("string %q", s)
+	}
+
+	var s Strictness
+	if err := s.UnmarshalText([]byte("Strict")); err != nil || s != Strict {
+		t.Fatalf("strictness %v: %v", s, err)
+	}
+
+	if err := s.UnmarshalText([]byte("pedantic")); err == nil {
+		t.Fatal("unknown strictness accepted")
+	}
+}
+
+func TestDecodeAudited(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	img.Set(3, 3, color.NRGBA{0xff, 0, 0, 0x80})
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+
+	_, loss, err := DecodeAudited(bytes.NewReader(buf.Bytes()))
+	if err != nil || loss != LossAlpha {
+		t.Fatalf("losses %v: %v", loss, err)
+	}
+
+	_, loss, err = DecodeAudited(bytes.NewReader(encodeJPEG(t, image.NewGray(image.Rect(0, 0, 16, 16)))))
+	if err != nil || loss != 0 {
+		t.Fatalf("losses %v: %v", loss, err)
+	}
+}
+
+func TestBatchStrictness(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 16, 16)))
+
+	fsys := fstest.MapFS{
+		"clear.png": {Data: buf.Bytes()},
+		"gray.jpg":  {Data: encodeJPEG(t, image.NewGray(image.Rect(0, 0, 16, 16)))},
+	}
+
+	results := func(s Strictness) map[string]*BatchResult {
+		m := make(map[string]*BatchResult)
+		for r := range HashFS(context.Background(), fsys, Average, &BatchOptions{Strictness: s}) {
+			m[r.Path] = r
+		}
+		return m
+	}
+
+	for _, r := range results(Lenient) {
+		if r.Err != nil || r.Losses != 0 {
+			t.Fatalf("lenient %s: %v, %v", r.Path, r.Losses, r.Err)
+		}
+	}
+
+	rs := results(Audit)
+	if r := rs["clear.png"]; r.Err != nil || r.Losses != LossAlpha {
+		t.Fatalf("audit %s: %v, %v", r.Path, r.Losses, r.Err)
+	}
+
+	if r := rs["gray.jpg"]; r.Err != nil || r.Losses != 0 {
+		t.Fatalf("audit %s: %v, %v", r.Path, r.Losses, r.Err)
+	}
+
+	rs = results(Strict)
+	if r := rs["clear.png"]; Classify(r.Err) != FailureLossy || !errors.Is(r.Err, ErrLossy) {
+		t.Fatalf("strict %s: %v", r.Path, r.Err)
+	}
+
+	if r := rs["gray.jpg"]; r.Err != nil {
+		t.Fatalf("strict %s: %v", r.Path, r.Err)
+	}
+}
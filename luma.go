@@ -0,0 +1,77 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"math"
+)
+
+// Luma is a Filter which reduces images to their luminance. Decoded
+// JPEG images yield their luma plane as is, without a copy; the chroma
+// planes, and how they are upsampled and converted back to RGB, then
+// no longer play any part in the hash.
+//
+// Go decodes baseline and progressive encodings of the same JPEG to the
+// same pixels, so their hashes match either way. Encoders which write
+// the progressive version of a file often change its chroma subsampling
+// as well, though, and other decoders upsample chroma in their own way.
+// Either flips the odd bit of hashes computed from RGB, but leaves the
+// luma plane, and the hashes of this filter, alone.
+func Luma(img image.Image) image.Image {
+	switch m := plain(img).(type) {
+	case *image.Gray, *image.Gray16:
+		return m
+
+	case *image.YCbCr:
+		return &image.Gray{Pix: m.Y, Stride: m.YStride, Rect: m.Rect}
+	}
+
+	return luminance(img)
+}
+
+// Channels returns a Filter which reduces images to a weighted sum of
+// their red, green and blue channels, for images whose detail is in the
+// channels luminance weighs little: astronomical images taken through
+// narrow band filters, say, or stained tissue under a microscope. The
+// weights are relative, so they need not add up to 1. Pass a weight of 1
+// for one channel and 0 for the others to hash that channel alone.
+// Negative weights count as 0; with all weights 0, Channels is Luma.
+func Channels(r, g, b float64) Filter {
+	r, g, b = math.Max(r, 0), math.Max(g, 0), math.Max(b, 0)
+	sum := r + g + b
+
+	if sum == 0 {
+		return Luma
+	}
+
+	// Weights out of 1<<16, adding up to it.
+	wr := uint32(math.Round(r / sum * 65536))
+	wg := uint32(math.Round(g / sum * 65536))
+	if wr+wg > 65536 {
+		wg = 65536 - wr
+	}
+	wb := 65536 - wr - wg
+
+	return func(img image.Image) image.Image {
+		img = copyable(img)
+		rect := img.Bounds()
+		gray := image.NewGray16(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+
+		var x, y, o int
+		for y = rect.Min.Y; y < rect.Max.Y; y++ {
+			o = gray.PixOffset(0, y-rect.Min.Y)
+
+			for x = rect.Min.X; x < rect.Max.X; x++ {
+				cr, cg, cb, _ := img.At(x, y).RGBA()
+				v := (uint64(wr)*uint64(cr) + uint64(wg)*uint64(cg) + uint64(wb)*uint64(cb) + 1<<15) >> 16
+				gray.Pix[o] = uint8(v >> 8)
+				gray.Pix[o+1] = uint8(v)
+				o += 2
+			}
+		}
+
+		return gray
+	}
+}
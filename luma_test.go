@@ -0,0 +1,372 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+func TestChannels(t *testing.T) {
+	// The detail of interest is in the blue channel, which luminance
+	// weighs least, and is drowned out by that of the red one.
+	img := imageFunc(image.Rect(2, 3, 34, 27), func(x, y int) color.Color {
+		return color.RGBA64{uint16(x * 2000), 0x8000, uint16(x * y * 97), 0xffff}
+	})
+
+	blue := Channels(0, 0, 1)(img).(*image.Gray16)
+	if blue.Bounds() != image.Rect(0, 0, 32, 24) {
+		t.Fatalf("bounds %v", blue.Bounds())
+	}
+
+	for y := 3; y < 27; y++ {
+		for x := 2; x < 34; x++ {
+			if v := blue.Gray16At(x-2, y-3).Y; v != uint16(x*y*97) {
+				t.Fatalf("(%d, %d): %#x, want %#x", x, y, v, uint16(x*y*97))
+			}
+		}
+	}
+
+	// Weights are relative.
+	even := Channels(2, 2, 2)(img).(*image.Gray16)
+	if v, want := even.Gray16At(5, 5).Y, (7*2000+0x8000+uint32(uint16(7*8*97))+1)/3; uint32(v) != want {
+		t.Fatalf("even weights: %#x, want %#x", v, want)
+	}
+
+	if Preprocess(Average, Channels(0, 0, 1))(img) == Average(img) {
+		t.Fatal("blue channel hashes as luminance")
+	}
+
+	if Preprocess(Average, Channels(0, -1, 0))(img) != Preprocess(Average, Luma)(img) {
+		t.Fatal("zero weights differ from luma")
+	}
+}
+
+func TestProgressiveBaseline(t *testing.T) {
+	img := synth.Shapes(96, 64, 4)
+
+	decode := func(data []byte) image.Image {
+		out, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	for _, sub := range []bool{false, true} {
+		c := newTestJPEG(img, sub)
+		base, prog := decode(c.encode(false)), decode(c.encode(true))
+
+		for _, name := range Algorithms() {
+			a, err := LookupAlgorithm(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := a.Hash(prog), a.Hash(base); got != want {
+				t.Fatalf("%s, subsampled %v: progressive hash %016x, baseline %016x", name, sub, got, want)
+			}
+		}
+	}
+
+	// The same luma, with and without chroma subsampling.
+	full := decode(newTestJPEG(img, false).encode(true))
+	half := decode(newTestJPEG(img, true).encode(false))
+	hf := Preprocess(Average, Luma)
+
+	if got, want := hf(half), hf(full); got != want {
+		t.Fatalf("luma hash %016x, want %016x", got, want)
+	}
+}
+
+// A testJPEG holds the quantized DCT coefficients of a colour image,
+// which it encodes as either a baseline or a progressive JPEG. Both
+// decode to exactly the same pixels, as when one is losslessly
+// transcoded into the other.
+type testJPEG struct {
+	w, h   int
+	sub    bool           // Chroma at half the size, rather than full size.
+	blocks [3][][64]int32 // Per component: blocks in raster order, in zigzag order.
+	bw     [3]int         // Per component: blocks per row.
+}
+
+const (
+	testQuantLuma   = 4
+	testQuantChroma = 6
+)
+
+// newTestJPEG computes the coefficients of the image, whose size must
+// be a multiple of 16.
+func newTestJPEG(img image.Image, sub bool) *testJPEG {
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	c := &testJPEG{w: w, h: h, sub: sub}
+
+	var planes [3][]float64
+	for i := range planes {
+		planes[i] = make([]float64, w*h)
+	}
+
+	var x, y, i int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			p := color.RGBAModel.Convert(img.At(rect.Min.X+x, rect.Min.Y+y)).(color.RGBA)
+			yy, cb, cr := color.RGBToYCbCr(p.R, p.G, p.B)
+			planes[0][y*w+x], planes[1][y*w+x], planes[2][y*w+x] = float64(yy), float64(cb), float64(cr)
+		}
+	}
+
+	zigzag := testZigzag()
+
+	for i = range planes {
+		pw, ph, scale := w, h, 1
+		quant := float64(testQuantLuma)
+
+		if i > 0 {
+			quant = testQuantChroma
+			if sub {
+				pw, ph, scale = w/2, h/2, 2
+			}
+		}
+
+		// Chroma is subsampled by averaging.
+		at := func(x, y int) float64 {
+			var sum float64
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					sum += planes[i][(y*scale+dy)*w+x*scale+dx]
+				}
+			}
+			return sum/float64(scale*scale) - 128
+		}
+
+		c.bw[i] = pw / 8
+		for y = 0; y < ph; y += 8 {
+			for x = 0; x < pw; x += 8 {
+				var b [64]int32
+				for v := 0; v < 8; v++ {
+					for u := 0; u < 8; u++ {
+						var f float64
+						for yy := 0; yy < 8; yy++ {
+							for xx := 0; xx < 8; xx++ {
+								f += at(x+xx, y+yy) *
+									math.Cos(float64(2*xx+1)*float64(u)*math.Pi/16) *
+									math.Cos(float64(2*yy+1)*float64(v)*math.Pi/16)
+							}
+						}
+
+						cu, cv := 1.0, 1.0
+						if u == 0 {
+							cu = math.Sqrt2 / 2
+						}
+						if v == 0 {
+							cv = math.Sqrt2 / 2
+						}
+
+						b[zigzag[v*8+u]] = int32(math.Round(cu * cv * f / 4 / quant))
+					}
+				}
+
+				c.blocks[i] = append(c.blocks[i], b)
+			}
+		}
+	}
+
+	return c
+}
+
+// testZigzag returns the position in zigzag order of each coefficient,
+// in natural order.
+func testZigzag() [64]int {
+	var z [64]int
+	var n int
+
+	for d := 0; d < 15; d++ {
+		for i := 0; i <= d; i++ {
+			u, v := i, d-i
+			if d%2 == 0 {
+				u, v = d-i, i
+			}
+
+			if u < 8 && v < 8 {
+				z[v*8+u] = n
+				n++
+			}
+		}
+	}
+
+	return z
+}
+
+// encode returns the coefficients as a baseline JPEG, or a progressive
+// one with successive approximation of DC, and two spectral bands of AC.
+func (c *testJPEG) encode(progressive bool) []byte {
+	var out bytes.Buffer
+	var bw bitWriter
+
+	segment := func(marker byte, data ...byte) {
+		out.Write([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+		out.Write(data)
+	}
+
+	out.Write([]byte{0xff, 0xd8})
+	segment(0xdb, append([]byte{0}, bytes.Repeat([]byte{testQuantLuma}, 64)...)...)
+	segment(0xdb, append([]byte{1}, bytes.Repeat([]byte{testQuantChroma}, 64)...)...)
+
+	sof, sampling := byte(0xc0), byte(0x11)
+	if progressive {
+		sof = 0xc2
+	}
+	if c.sub {
+		sampling = 0x22
+	}
+
+	segment(sof, 8, byte(c.h>>8), byte(c.h), byte(c.w>>8), byte(c.w), 3,
+		1, sampling, 0, 2, 0x11, 1, 3, 0x11, 1)
+
+	// DC table: all 12 categories coded in 4 bits. AC table: all 162
+	// symbols coded in 8 bits, in the order of acSymbols.
+	dc := []byte{0x00, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	segment(0xc4, append(dc, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)...)
+
+	acSymbols := []byte{0x00, 0xf0}
+	for r := 0; r < 16; r++ {
+		for s := 1; s <= 10; s++ {
+			acSymbols = append(acSymbols, byte(r<<4|s))
+		}
+	}
+
+	acCodes := make(map[byte]uint)
+	for i, s := range acSymbols {
+		acCodes[s] = uint(i)
+	}
+
+	segment(0xc4, append([]byte{0x10, 0, 0, 0, 0, 0, 0, 0, byte(len(acSymbols)), 0, 0, 0, 0, 0, 0, 0, 0}, acSymbols...)...)
+
+	value := func(v int32) {
+		size, mag := uint(0), v
+		if mag < 0 {
+			mag = -mag
+		}
+		for ; mag > 0; mag >>= 1 {
+			size++
+		}
+		if v < 0 {
+			v += 1<<size - 1
+		}
+		bw.write(uint(v), size)
+	}
+
+	// dcValue writes the difference of v to the previous DC value.
+	dcValue := func(v int32, prev *int32) {
+		diff := v - *prev
+		*prev = v
+
+		size, mag := uint(0), diff
+		if mag < 0 {
+			mag = -mag
+		}
+		for ; mag > 0; mag >>= 1 {
+			size++
+		}
+
+		bw.write(size, 4)
+		value(diff)
+	}
+
+	// acBand writes coefficients ss to se of the block.
+	acBand := func(b *[64]int32, ss, se int) {
+		var run uint
+		for k := ss; k <= se; k++ {
+			if b[k] == 0 {
+				run++
+				continue
+			}
+
+			for ; run >= 16; run -= 16 {
+				bw.write(acCodes[0xf0], 8)
+			}
+
+			size, mag := 0, b[k]
+			if mag < 0 {
+				mag = -mag
+			}
+			for ; mag > 0; mag >>= 1 {
+				size++
+			}
+
+			bw.write(acCodes[byte(run<<4)|byte(size)], 8)
+			value(b[k])
+			run = 0
+		}
+
+		if run > 0 {
+			bw.write(acCodes[0x00], 8)
+		}
+	}
+
+	// interleaved calls fn for each block of each component, in the
+	// order of an interleaved scan.
+	interleaved := func(fn func(comp int, b *[64]int32)) {
+		n := 1
+		if c.sub {
+			n = 2
+		}
+
+		for my := 0; my < c.h/(8*n); my++ {
+			for mx := 0; mx < c.w/(8*n); mx++ {
+				for by := 0; by < n; by++ {
+					for bx := 0; bx < n; bx++ {
+						fn(0, &c.blocks[0][(my*n+by)*c.bw[0]+mx*n+bx])
+					}
+				}
+
+				fn(1, &c.blocks[1][my*c.bw[1]+mx])
+				fn(2, &c.blocks[2][my*c.bw[2]+mx])
+			}
+		}
+	}
+
+	var prev [3]int32
+
+	if !progressive {
+		segment(0xda, 3, 1, 0x00, 2, 0x00, 3, 0x00, 0, 63, 0)
+		interleaved(func(comp int, b *[64]int32) {
+			dcValue(b[0], &prev[comp])
+			acBand(b, 1, 63)
+		})
+		out.Write(bw.flush())
+
+		out.Write([]byte{0xff, 0xd9})
+		return out.Bytes()
+	}
+
+	// The DC coefficients without their last bit, then that bit.
+	segment(0xda, 3, 1, 0x00, 2, 0x00, 3, 0x00, 0, 0, 0x01)
+	interleaved(func(comp int, b *[64]int32) { dcValue(b[0]>>1, &prev[comp]) })
+	out.Write(bw.flush())
+
+	segment(0xda, 3, 1, 0x00, 2, 0x00, 3, 0x00, 0, 0, 0x10)
+	interleaved(func(comp int, b *[64]int32) { bw.write(uint(b[0]&1), 1) })
+	out.Write(bw.flush())
+
+	// Two bands of AC coefficients, one component at a time.
+	for comp := 0; comp < 3; comp++ {
+		for _, band := range [][2]int{{1, 5}, {6, 63}} {
+			segment(0xda, 1, byte(comp+1), 0x00, byte(band[0]), byte(band[1]), 0)
+			for i := range c.blocks[comp] {
+				acBand(&c.blocks[comp][i], band[0], band[1])
+			}
+			out.Write(bw.flush())
+		}
+	}
+
+	out.Write([]byte{0xff, 0xd9})
+	return out.Bytes()
+}
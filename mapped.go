@@ -0,0 +1,440 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// ErrReadOnly is returned for changes to a read-only store.
+var ErrReadOnly = errors.New("imghash: read-only store")
+
+// mappedMagic identifies the mapped index format.
+const mappedMagic = "IMGHMAP1"
+
+// Sizes of the parts of the mapped index format, in bytes.
+const (
+	mappedHeader = 64
+	mappedNode   = 24
+	mappedEdge   = 8
+	mappedID     = 8
+)
+
+// WriteMapped writes the index to w, in the format OpenMapped maps into
+// memory. Metadata is left out.
+//
+// The format holds the BK-tree of the index as it is, in tables of
+// fixed size records, which refer to each other by index rather than
+// by pointer. All numbers are little endian. A 64 byte header holds the
+// magic string "IMGHMAP1", the number of nodes, the number of IDs and
+// the length of the algorithm name, as 8 byte numbers, and is padded
+// with zeros. It is followed by:
+//
+//   - the nodes, in breadth first order from the root, as 24 bytes
+//     each: the hash, the index of the first edge to a child, the
+//     number of children, the index of the first ID and the number of
+//     IDs, the last four as 4 byte numbers;
+//   - the edges to the children of every node, one less than there are
+//     nodes, each as the distance to the child and the index of the
+//     child, as 4 byte numbers, sorted by distance;
+//   - the offsets of the IDs in the strings which follow, as 8 byte
+//     numbers, with one more at the end of the last one;
+//   - the IDs, back to back, sorted within each node, and the name of
+//     the algorithm.
+//
+// Children always come after their parents, so readers can check a
+// file holds a tree before they walk it.
+func (x *Index) WriteMapped(w io.Writer) (int64, error) {
+	x.load()
+
+	// Number the nodes breadth first, and collect the children of each,
+	// sorted by distance.
+	var nodes []*bkNode[string]
+	var children [][]bkEdge[string]
+
+	if x.root != nil {
+		nodes = append(nodes, x.root)
+	}
+
+	for i := 0; i < len(nodes); i++ {
+		n := nodes[i]
+		edges := make([]bkEdge[string], 0, len(n.children))
+		for d, child := range n.children {
+			edges = append(edges, bkEdge[string]{d, child})
+		}
+
+		sort.Slice(edges, func(i, j int) bool { return edges[i].dist < edges[j].dist })
+		for _, e := range edges {
+			nodes = append(nodes, e.node)
+		}
+
+		children = append(children, edges)
+	}
+
+	var entries int
+	for _, n := range nodes {
+		entries += len(n.ids)
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	var buf [mappedHeader]byte
+	copy(buf[:], mappedMagic)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(len(nodes)))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(entries))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(len(x.Algorithm)))
+	cw.Write(buf[:])
+
+	var edge, id uint32
+	for i, n := range nodes {
+		binary.LittleEndian.PutUint64(buf[0:], n.hash)
+		binary.LittleEndian.PutUint32(buf[8:], edge)
+		binary.LittleEndian.PutUint32(buf[12:], uint32(len(children[i])))
+		binary.LittleEndian.PutUint32(buf[16:], id)
+		binary.LittleEndian.PutUint32(buf[20:], uint32(len(n.ids)))
+		cw.Write(buf[:mappedNode])
+
+		edge += uint32(len(children[i]))
+		id += uint32(len(n.ids))
+	}
+
+	// Children were numbered in the order of the edges.
+	child := uint32(1)
+	for _, edges := range children {
+		for _, e := range edges {
+			binary.LittleEndian.PutUint32(buf[0:], uint32(e.dist))
+			binary.LittleEndian.PutUint32(buf[4:], child)
+			cw.Write(buf[:mappedEdge])
+			child++
+		}
+	}
+
+	sorted := make([][]string, len(nodes))
+	var off uint64
+	for i, n := range nodes {
+		sorted[i] = append([]string(nil), n.ids...)
+		sort.Strings(sorted[i])
+
+		for _, s := range sorted[i] {
+			binary.LittleEndian.PutUint64(buf[:], off)
+			cw.Write(buf[:mappedID])
+			off += uint64(len(s))
+		}
+	}
+
+	binary.LittleEndian.PutUint64(buf[:], off)
+	cw.Write(buf[:mappedID])
+
+	for _, ids := range sorted {
+		for _, s := range ids {
+			io.WriteString(cw, s)
+		}
+	}
+
+	io.WriteString(cw, x.Algorithm)
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// SaveMapped saves the index to the given file, as WriteMapped writes it.
+func (x *Index) SaveMapped(file string) (err error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return
+	}
+
+	if _, err = x.WriteMapped(fd); err != nil {
+		fd.Close()
+		return
+	}
+
+	return fd.Close()
+}
+
+// A MappedIndex is a read-only index, mapped into memory from a file
+// written by WriteMapped. Its pages are those of the file in the page
+// cache, so processes on one host which map the same file share one
+// copy of it, and opening an index takes no time, however large it is.
+// Pages are read from disk as queries first need them.
+//
+// A MappedIndex is safe for concurrent use. It must not be used after
+// it is closed, and the file must not be changed while it is open:
+// replace it with a new file instead, and open that.
+//
+// On systems without mmap, the file is read into memory instead.
+type MappedIndex struct {
+	data    []byte
+	release func() error
+
+	nodes, entries  uint64
+	edges, ids, str uint64 // Offsets of the tables.
+	algorithm       string
+}
+
+// OpenMapped maps the index in the given file into memory. It fails with
+// ErrInvalidIndex if the file is not a mapped index.
+func OpenMapped(file string) (*MappedIndex, error) {
+	data, release, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMappedIndex(data)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	m.release = release
+	return m, nil
+}
+
+// newMappedIndex checks the header and the size of the tables of an
+// index in data.
+func newMappedIndex(data []byte) (*MappedIndex, error) {
+	if len(data) < mappedHeader || string(data[:8]) != mappedMagic {
+		return nil, ErrInvalidIndex
+	}
+
+	m := &MappedIndex{
+		data:    data,
+		nodes:   binary.LittleEndian.Uint64(data[8:]),
+		entries: binary.LittleEndian.Uint64(data[16:]),
+	}
+
+	algoLen := binary.LittleEndian.Uint64(data[24:])
+	size := uint64(len(data))
+
+	if m.nodes >= 1<<32 || m.entries >= 1<<32 || algoLen > 1<<20 {
+		return nil, ErrInvalidIndex
+	}
+
+	m.edges = mappedHeader + m.nodes*mappedNode
+	m.ids = m.edges
+	if m.nodes > 0 {
+		m.ids += (m.nodes - 1) * mappedEdge
+	}
+	m.str = m.ids + (m.entries+1)*mappedID
+
+	if m.str > size || algoLen > size-m.str || m.uint64(m.str-mappedID) != size-m.str-algoLen {
+		return nil, ErrInvalidIndex
+	}
+
+	m.algorithm = string(data[size-algoLen:])
+	return m, nil
+}
+
+// uint64 returns the 8 byte number at the given offset.
+func (m *MappedIndex) uint64(off uint64) uint64 {
+	return binary.LittleEndian.Uint64(m.data[off:])
+}
+
+// uint32 returns the 4 byte number at the given offset.
+func (m *MappedIndex) uint32(off uint64) uint64 {
+	return uint64(binary.LittleEndian.Uint32(m.data[off:]))
+}
+
+// Close unmaps the index.
+func (m *MappedIndex) Close() error {
+	if m.release == nil {
+		return nil
+	}
+
+	err := m.release()
+	m.release, m.data = nil, nil
+	return err
+}
+
+// Algorithm returns the name of the hashing algorithm of the index, if
+// it is known.
+func (m *MappedIndex) Algorithm() string { return m.algorithm }
+
+// Len returns the number of IDs in the index.
+func (m *MappedIndex) Len() int { return int(m.entries) }
+
+// Search is like Index.Search. Hits carry no metadata.
+func (m *MappedIndex) Search(hash, distance uint64) Hits {
+	var hits Hits
+	visited := m.visit(hash, distance, func(rec, h, dist uint64) {
+		m.eachID(rec, func(id string) {
+			hits = append(hits, Hit{Record{ID: id, Hash: h}, dist})
+		})
+	})
+
+	currentMetrics().IndexQueried(visited, len(hits))
+
+	hits.Sort()
+	return hits
+}
+
+// Query is like Index.Query.
+func (m *MappedIndex) Query(hash, distance uint64) ResultSet {
+	var rs ResultSet
+	visited := m.visit(hash, distance, func(rec, h, dist uint64) {
+		m.eachID(rec, func(id string) {
+			rs = append(rs, &SearchResult{Path: id, Hash: h, Distance: dist})
+		})
+	})
+
+	currentMetrics().IndexQueried(visited, len(rs))
+
+	sort.Stable(rs)
+	return rs
+}
+
+// SearchFunc is like Index.SearchFunc. Hits carry no metadata.
+func (m *MappedIndex) SearchFunc(hash, distance uint64, f func(Hit) bool) {
+	var n int
+	visited := m.visitUntil(hash, distance, func(rec, h, dist uint64) bool {
+		ok := true
+		m.eachID(rec, func(id string) {
+			if ok {
+				n++
+				ok = f(Hit{Record{ID: id, Hash: h}, dist})
+			}
+		})
+		return ok
+	})
+
+	currentMetrics().IndexQueried(visited, n)
+}
+
+// Count is like Index.Count. It reads the IDs of no entry.
+func (m *MappedIndex) Count(hash, distance uint64) int {
+	var n int
+	visited := m.visit(hash, distance, func(rec, _, _ uint64) {
+		first, count := m.uint32(rec+16), m.uint32(rec+20)
+		if first < m.entries {
+			n += int(min(count, m.entries-first))
+		}
+	})
+
+	currentMetrics().IndexQueried(visited, n)
+	return n
+}
+
+// visit calls f with the offset of the record of every node within
+// distance of hash, its hash and its distance, and returns the number
+// of nodes it visited. Records which point outside their tables, or to
+// nodes which do not come after their parent, are skipped, so a damaged
+// file yields fewer results rather than a crash or a loop.
+func (m *MappedIndex) visit(hash, distance uint64, f func(rec, hash, dist uint64)) int {
+	return m.visitUntil(hash, distance, func(rec, hash, dist uint64) bool {
+		f(rec, hash, dist)
+		return true
+	})
+}
+
+// visitUntil is visit, but stops as soon as f returns false.
+func (m *MappedIndex) visitUntil(hash, distance uint64, f func(rec, hash, dist uint64) bool) int {
+	if m.nodes == 0 {
+		return 0
+	}
+
+	var visited int
+	stack := []uint64{0}
+
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		visited++
+
+		rec := mappedHeader + n*mappedNode
+		h := m.uint64(rec)
+		dist := Distance(h, hash)
+
+		if dist <= distance && !f(rec, h, dist) {
+			break
+		}
+
+		// By the triangle inequality, matches can only be found in
+		// children at a distance of dist-distance to dist+distance.
+		min := uint64(0)
+		if dist > distance {
+			min = dist - distance
+		}
+
+		first, count := m.uint32(rec+8), m.uint32(rec+12)
+		if first+count > m.nodes-1 {
+			continue
+		}
+
+		edge := func(i uint64) uint64 { return m.edges + (first+i)*mappedEdge }
+		i := uint64(sort.Search(int(count), func(i int) bool { return m.uint32(edge(uint64(i))) >= min }))
+
+		for ; i < count; i++ {
+			e := edge(i)
+			if m.uint32(e) > dist+distance {
+				break
+			}
+
+			if child := m.uint32(e + 4); child > n && child < m.nodes {
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	return visited
+}
+
+// eachID calls f for every ID of the node with the given record.
+func (m *MappedIndex) eachID(rec uint64, f func(id string)) {
+	first, count := m.uint32(rec+16), m.uint32(rec+20)
+	for i := first; i < first+count && i < m.entries; i++ {
+		off := m.ids + i*mappedID
+		from, to := m.str+m.uint64(off), m.str+m.uint64(off+mappedID)
+		if from <= to && to <= uint64(len(m.data)) {
+			f(string(m.data[from:to]))
+		}
+	}
+}
+
+// MappedStore returns a read-only Store backed by the given index. Adds
+// and removes fail with ErrReadOnly.
+func MappedStore(m *MappedIndex) Store {
+	return mappedStore{m}
+}
+
+// mappedStore is a Store over a MappedIndex, which needs no lock.
+type mappedStore struct {
+	m *MappedIndex
+}
+
+func (s mappedStore) Add(ctx context.Context, id string, hash uint64) error {
+	return ErrReadOnly
+}
+
+func (s mappedStore) Remove(ctx context.Context, id string) error {
+	return ErrReadOnly
+}
+
+func (s mappedStore) Query(ctx context.Context, hash, distance uint64) (ResultSet, error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+	return s.m.Query(hash, distance), nil
+}
+
+func (s mappedStore) QueryFunc(ctx context.Context, hash, distance uint64, f func(*SearchResult) bool) (err error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+	s.m.SearchFunc(hash, distance, streamHits(ctx, f, &err))
+	return err
+}
+
+func (s mappedStore) Count(ctx context.Context, hash, distance uint64) (int, error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+	return s.m.Count(hash, distance), nil
+}
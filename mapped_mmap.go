@@ -0,0 +1,44 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package imghash
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the given file into memory, read-only and shared with
+// other processes mapping it. It returns the data and a function which
+// unmaps it.
+func mapFile(file string) ([]byte, func() error, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := fi.Size()
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+
+	if int64(int(size)) != size {
+		return nil, nil, ErrTooLarge
+	}
+
+	data, err := syscall.Mmap(int(fd.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "mmap", Path: file, Err: err}
+	}
+
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,18 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package imghash
+
+import "os"
+
+// mapFile reads the given file into memory, where it can not be mapped.
+func mapFile(file string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,147 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMappedIndex(t *testing.T) {
+	x := NewIndex()
+	x.Algorithm = "average"
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		h := rng.Uint64()
+		x.Add(fmt.Sprintf("img-%d", i), h)
+		if i%10 == 0 {
+			x.Add(fmt.Sprintf("copy-%d", i), h^1<<uint(i%64))
+		}
+	}
+
+	x.Remove("img-10")
+
+	file := filepath.Join(t.TempDir(), "test.map")
+	if err := x.SaveMapped(file); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := OpenMapped(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer m.Close()
+
+	if m.Len() != x.Len() || m.Algorithm() != "average" {
+		t.Fatalf("%d entries of %q", m.Len(), m.Algorithm())
+	}
+
+	for i := 0; i < 300; i++ {
+		h := rng.Uint64()
+		if i%3 == 0 {
+			h, _ = x.Hash(fmt.Sprintf("img-%d", i))
+		}
+
+		for _, d := range []uint64{0, 4, 12} {
+			want, got := x.Search(h, d), m.Search(h, d)
+			if len(want) != len(got) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+				t.Fatalf("search %016x within %d: %v, want %v", h, d, got, want)
+			}
+
+			var streamed Hits
+			m.SearchFunc(h, d, func(hit Hit) bool {
+				streamed = append(streamed, hit)
+				return true
+			})
+
+			streamed.Sort()
+			if len(want) > 0 && !reflect.DeepEqual(streamed, want) {
+				t.Fatalf("streamed %016x within %d: %v, want %v", h, d, streamed, want)
+			}
+
+			if n := m.Count(h, d); n != len(want) {
+				t.Fatalf("count %016x within %d: %d, want %d", h, d, n, len(want))
+			}
+		}
+	}
+
+	s := MappedStore(m)
+	if err := s.Add(context.Background(), "new", 1); err != ErrReadOnly {
+		t.Fatalf("add: %v", err)
+	}
+
+	h, _ := x.Hash("img-20")
+	rs, err := s.Query(context.Background(), h, 1)
+	if err != nil || len(rs) != 2 || rs[0].Path != "img-20" || rs[1].Path != "copy-20" {
+		t.Fatalf("query: %v, %v", rs, err)
+	}
+
+	if n, err := CountStore(context.Background(), s, h, 1); n != 2 || err != nil {
+		t.Fatalf("count: %d, %v", n, err)
+	}
+}
+
+func TestMappedIndexEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewIndex().WriteMapped(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := newMappedIndex(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Len() != 0 || len(m.Search(0, 64)) != 0 {
+		t.Fatalf("empty index has entries")
+	}
+}
+
+func TestMappedIndexInvalid(t *testing.T) {
+	x := NewIndex()
+	for i := 0; i < 100; i++ {
+		x.Add(fmt.Sprint(i), uint64(i)*0x0101010101010101)
+	}
+
+	var buf bytes.Buffer
+	x.WriteMapped(&buf)
+	data := buf.Bytes()
+
+	for _, n := range []int{0, 8, 63, len(data) - 1} {
+		if _, err := newMappedIndex(data[:n]); err != ErrInvalidIndex {
+			t.Errorf("truncated to %d bytes: %v", n, err)
+		}
+	}
+
+	// Damaged records must not crash or loop, whatever they hold.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		bad := append([]byte(nil), data...)
+		for j := 0; j < 8; j++ {
+			bad[mappedHeader+rng.Intn(len(bad)-mappedHeader)] = byte(rng.Intn(256))
+		}
+
+		if m, err := newMappedIndex(bad); err == nil {
+			m.Search(rng.Uint64(), 32)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "plain.idx")
+	x.Save(file)
+	if _, err := OpenMapped(file); err != ErrInvalidIndex {
+		t.Fatalf("plain index: %v", err)
+	}
+
+	if _, err := OpenMapped(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Fatalf("missing file: %v", err)
+	}
+}
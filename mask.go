@@ -0,0 +1,99 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// AverageMask returns a HashFunc which computes an Average hash,
+// while ignoring the parts of the image which are transparent in the
+// given mask. Only the alpha channel of the mask is used.
+//
+// The mask is stretched over the image, so the same mask can be used
+// for images of varying sizes, as long as the masked regions are at
+// the same relative position. Masked pixels affect neither the mean,
+// nor the bits they fall in. Bits which are masked entirely are always
+// zero, so they never contribute to the Hamming Distance.
+//
+// This is useful for images with known, changing overlays. For example,
+// a timestamp burned into the corner of a video frame, or a watermark.
+func AverageMask(mask image.Image) HashFunc {
+	return func(img image.Image) uint64 {
+		lum, cov := applyMask(img, mask)
+		cells, weights := maskedCells(resize(lum, 8, 8), resize(cov, 8, 8))
+		mean := avgMean(cells, weights)
+		return avgHash(cells, mean, weights)
+	}
+}
+
+// ExcludeRects creates a mask for use with AverageMask. It covers the
+// given bounds, with the given rectangles excluded.
+func ExcludeRects(bounds image.Rectangle, rects ...image.Rectangle) *image.Alpha {
+	mask := image.NewAlpha(bounds)
+	draw.Draw(mask, bounds, image.Opaque, image.Point{}, draw.Src)
+
+	for _, r := range rects {
+		draw.Draw(mask, r, image.Transparent, image.Point{}, draw.Src)
+	}
+
+	return mask
+}
+
+// applyMask returns the luminance of img multiplied by the coverage
+// of the mask, and the coverage itself.
+func applyMask(img, mask image.Image) (lum, cov *image.Gray16) {
+	img, mask = bounded(img), bounded(mask)
+
+	rect := img.Bounds()
+	mrect := mask.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+
+	lum = image.NewGray16(image.Rect(0, 0, w, h))
+	cov = image.NewGray16(image.Rect(0, 0, w, h))
+
+	if mrect.Empty() {
+		return
+	}
+
+	var x, y, mx, my int
+	var a uint32
+	var l color.Gray16
+
+	for y = 0; y < h; y++ {
+		my = mrect.Min.Y + y*mrect.Dy()/h
+
+		for x = 0; x < w; x++ {
+			mx = mrect.Min.X + x*mrect.Dx()/w
+
+			if _, _, _, a = mask.At(mx, my).RGBA(); a == 0 {
+				continue
+			}
+
+			l = color.Gray16Model.Convert(img.At(rect.Min.X+x, rect.Min.Y+y)).(color.Gray16)
+			lum.SetGray16(x, y, color.Gray16{uint16(uint32(l.Y) * a / 0xffff)})
+			cov.SetGray16(x, y, color.Gray16{uint16(a)})
+		}
+	}
+
+	return
+}
+
+// maskedCells turns the downscaled luminance and coverage into cell
+// values and weights. Each cell value is the average of the unmasked
+// pixels it covers.
+func maskedCells(lum, cov image.Image) (cells, weights []uint32) {
+	cells = gridValues(lum)
+	weights = gridValues(cov)
+
+	for i, w := range weights {
+		if w > 0 {
+			cells[i] = uint32(uint64(cells[i]) * 0xffff / uint64(w))
+		}
+	}
+
+	return
+}
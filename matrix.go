@@ -0,0 +1,144 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrMatrixIDs is returned by WriteMatrixCSV if there are not as many
+// IDs as hashes.
+var ErrMatrixIDs = errors.New("imghash: IDs do not match hashes")
+
+// A MatrixEncoding tells how WriteNPY and WriteMatrixCSV turn hashes into
+// rows of numbers, for tools which lay out a collection in a plane, like
+// t-SNE and UMAP. Either way, a row has a column per bit, starting with
+// the most significant one.
+type MatrixEncoding int
+
+// Known matrix encodings.
+const (
+	// Bits are 0 or 1. The squared Euclidean distance between two rows
+	// is the Hamming Distance between their hashes.
+	MatrixBits MatrixEncoding = iota
+
+	// Bits are -1 or 1. The cosine similarity of two rows is the
+	// Similarity of their hashes, stretched to the range -1 to 1, so
+	// tools using the cosine metric rank neighbours as Distance does.
+	MatrixSigned
+)
+
+func (e MatrixEncoding) String() string {
+	if e == MatrixSigned {
+		return "signed"
+	}
+
+	return "bits"
+}
+
+// MarshalText implements encoding.TextMarshaler, writing the encoding
+// by name.
+func (e MatrixEncoding) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (e *MatrixEncoding) UnmarshalText(text []byte) error {
+	for _, c := range []MatrixEncoding{MatrixBits, MatrixSigned} {
+		if strings.EqualFold(string(text), c.String()) {
+			*e = c
+			return nil
+		}
+	}
+
+	return fmt.Errorf("imghash: unknown matrix encoding %q", text)
+}
+
+// values returns the numbers a cleared and a set bit are encoded as.
+func (e MatrixEncoding) values() (clear, set int) {
+	if e == MatrixSigned {
+		return -1, 1
+	}
+
+	return 0, 1
+}
+
+// WriteNPY writes the hashes to w as a NumPy .npy file, holding an n by
+// 64 matrix of float32 values, one row per hash, as numpy.load reads
+// it. The file holds no IDs; rows are in the order of hashes.
+func WriteNPY(w io.Writer, hashes []uint64, enc MatrixEncoding) error {
+	header := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, 64), }", len(hashes))
+
+	// The magic, version and length take 10 bytes. The header is padded
+	// with spaces and ends in a newline, so the data starts aligned.
+	pad := 63 - (10+len(header))%64
+	header += strings.Repeat(" ", pad) + "\n"
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("\x93NUMPY\x01\x00")
+	binary.Write(bw, binary.LittleEndian, uint16(len(header)))
+	bw.WriteString(header)
+
+	clear, set := enc.values()
+	values := [2]uint32{
+		math.Float32bits(float32(clear)),
+		math.Float32bits(float32(set)),
+	}
+
+	var row [64 * 4]byte
+	for _, hash := range hashes {
+		for i := 0; i < 64; i++ {
+			binary.LittleEndian.PutUint32(row[4*i:], values[hash>>(63-i)&1])
+		}
+
+		if _, err := bw.Write(row[:]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteMatrixCSV writes the hashes to w as CSV, with a header row, and a
+// row per hash holding its ID followed by 64 columns, b0 to b63.
+func WriteMatrixCSV(w io.Writer, ids []string, hashes []uint64, enc MatrixEncoding) error {
+	if len(ids) != len(hashes) {
+		return ErrMatrixIDs
+	}
+
+	cw := csv.NewWriter(w)
+	row := make([]string, 65)
+
+	row[0] = "id"
+	for i := 0; i < 64; i++ {
+		row[1+i] = "b" + strconv.Itoa(i)
+	}
+
+	cw.Write(row)
+
+	clear, set := enc.values()
+	values := [2]string{strconv.Itoa(clear), strconv.Itoa(set)}
+
+	for n, hash := range hashes {
+		row[0] = ids[n]
+		for i := 0; i < 64; i++ {
+			row[1+i] = values[hash>>(63-i)&1]
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,70 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWriteNPY(t *testing.T) {
+	hashes := []uint64{0x8000000000000001, 0}
+
+	var buf bytes.Buffer
+	if err := WriteNPY(&buf, hashes, MatrixSigned); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("\x93NUMPY\x01\x00")) {
+		t.Fatalf("magic %q", data[:8])
+	}
+
+	size := int(binary.LittleEndian.Uint16(data[8:]))
+	header := string(data[10 : 10+size])
+	if (10+size)%64 != 0 || !strings.HasSuffix(header, "\n") || !strings.Contains(header, "'shape': (2, 64)") {
+		t.Fatalf("header %q", header)
+	}
+
+	body := data[10+size:]
+	if len(body) != 2*64*4 {
+		t.Fatalf("%d bytes of data", len(body))
+	}
+
+	value := func(row, col int) float32 {
+		return math.Float32frombits(binary.LittleEndian.Uint32(body[4*(64*row+col):]))
+	}
+
+	if value(0, 0) != 1 || value(0, 1) != -1 || value(0, 63) != 1 || value(1, 0) != -1 {
+		t.Fatalf("values %v %v %v %v", value(0, 0), value(0, 1), value(0, 63), value(1, 0))
+	}
+}
+
+func TestWriteMatrixCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMatrixCSV(&buf, []string{"a.png"}, []uint64{0xc000000000000000}, MatrixBits); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || len(rows[1]) != 65 || rows[0][1] != "b0" {
+		t.Fatalf("rows %v", rows)
+	}
+
+	if r := rows[1]; r[0] != "a.png" || r[1] != "1" || r[2] != "1" || r[3] != "0" || r[64] != "0" {
+		t.Fatalf("row %v", r)
+	}
+
+	if err := WriteMatrixCSV(&buf, nil, []uint64{0}, MatrixBits); err != ErrMatrixIDs {
+		t.Fatalf("mismatched IDs: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives instrumentation events from this package. It can be
+// used to feed a monitoring system, like Prometheus or expvar.
+//
+// Implementations must be safe for concurrent use, and should return
+// quickly: they are called inline.
+type Metrics interface {
+	// ImageHashed is called for every image decoded and hashed by
+	// the Compute functions, with the time this took.
+	ImageHashed(elapsed time.Duration)
+
+	// DecodeFailed is called when the Compute functions fail
+	// to decode an image.
+	DecodeFailed(err error)
+
+	// IndexQueried is called for every Index query, with the number
+	// of tree nodes visited and the number of results found.
+	IndexQueried(visited, results int)
+}
+
+// metrics holds the current Metrics implementation, wrapped in a
+// metricsHolder: atomic.Value requires a consistent concrete type.
+var metrics atomic.Value
+
+type metricsHolder struct{ m Metrics }
+
+func init() {
+	metrics.Store(metricsHolder{nopMetrics{}})
+}
+
+// SetMetrics sets the Metrics implementation which receives all
+// instrumentation events. Pass nil to disable instrumentation.
+// This is typically called once, at program startup.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+
+	metrics.Store(metricsHolder{m})
+}
+
+// currentMetrics returns the current Metrics implementation.
+func currentMetrics() Metrics {
+	return metrics.Load().(metricsHolder).m
+}
+
+// nopMetrics discards all events.
+type nopMetrics struct{}
+
+func (nopMetrics) ImageHashed(time.Duration) {}
+func (nopMetrics) DecodeFailed(error)        {}
+func (nopMetrics) IndexQueried(int, int)     {}
@@ -0,0 +1,83 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records all events it receives.
+type recordingMetrics struct {
+	mu       sync.Mutex
+	hashed   []time.Duration
+	failures []error
+	queries  [][2]int // Nodes visited and results found.
+}
+
+func (m *recordingMetrics) ImageHashed(elapsed time.Duration) {
+	m.mu.Lock()
+	m.hashed = append(m.hashed, elapsed)
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) DecodeFailed(err error) {
+	m.mu.Lock()
+	m.failures = append(m.failures, err)
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) IndexQueried(visited, results int) {
+	m.mu.Lock()
+	m.queries = append(m.queries, [2]int{visited, results})
+	m.mu.Unlock()
+}
+
+func TestMetrics(t *testing.T) {
+	m := &recordingMetrics{}
+	SetMetrics(m)
+	defer SetMetrics(nil)
+
+	hash, err := ComputeFile("testdata/gopher_small.png", Average)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.hashed) != 1 || m.hashed[0] < 0 || len(m.failures) != 0 {
+		t.Fatalf("hashed %v, failures %v", m.hashed, m.failures)
+	}
+
+	_, err = ComputeReader(strings.NewReader("not an image"), Average)
+	if err == nil || len(m.failures) != 1 || m.failures[0] != err || len(m.hashed) != 1 {
+		t.Fatalf("failures %v for %v, hashed %v", m.failures, err, m.hashed)
+	}
+
+	x := NewIndex()
+	x.Add("a", hash)
+	x.Add("b", hash^1)
+	x.Add("c", ^hash)
+
+	if hits := x.Search(hash, 1); len(hits) != 2 {
+		t.Fatalf("%d hits", len(hits))
+	}
+
+	if len(m.queries) != 1 || m.queries[0][0] < 1 || m.queries[0][1] != 2 {
+		t.Fatalf("queries %v", m.queries)
+	}
+
+	if n := x.Count(hash, 64); n != 3 || len(m.queries) != 2 || m.queries[1] != [2]int{3, 3} {
+		t.Fatalf("queries %v, counting %d", m.queries, n)
+	}
+
+	// Nil disables instrumentation.
+	SetMetrics(nil)
+	ComputeFile("testdata/gopher_small.png", Average)
+	x.Search(hash, 1)
+
+	if len(m.hashed) != 1 || len(m.queries) != 2 {
+		t.Fatalf("events after SetMetrics(nil): hashed %v, queries %v", m.hashed, m.queries)
+	}
+}
@@ -0,0 +1,342 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package migrate re-hashes a collection of images with a new algorithm,
+while keeping the IDs they are known by.
+
+The results go to a mapping file, which lists the ID, path, old hash and
+new hash of every image, in CSV. It is appended to as images are hashed.
+If a run is interrupted, running it again with the same mapping file
+picks up where it left off:
+
+	x := imghash.NewIndex()
+	err := x.Load("photos.idx")
+	...
+	p, err := migrate.Run(migrate.FromIndex(x), migrate.Hash64(imghash.Screenshot(nil)),
+		"photos.csv", nil)
+	...
+	fmt.Printf("%d migrated, %d failed\n", p.Done, p.Failed)
+
+Hashers produce text, so hashes of any length can be migrated to:
+
+	h := func(img image.Image) string { return imghash.Average1024(img).String() }
+*/
+package migrate
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"image"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// header is the first row of a mapping file.
+var header = []string{"id", "path", "old", "new", "error"}
+
+// ErrMapping is returned for files which are not mapping files.
+var ErrMapping = errors.New("migrate: invalid mapping file")
+
+// An Item is a single image to migrate.
+type Item struct {
+	ID   string // ID the image is known by.
+	Path string // File holding the image.
+	Old  string // Hash under the old algorithm, if known.
+}
+
+// A Hasher computes the new hash of an image, as text.
+type Hasher func(image.Image) string
+
+// Hash64 returns a Hasher for a 64 bit HashFunc. Hashes are written
+// as 16 digit hexadecimal numbers.
+func Hash64(hf imghash.HashFunc) Hasher {
+	return func(img image.Image) string {
+		return fmt.Sprintf("%016x", hf(img))
+	}
+}
+
+// FromIndex returns an item for each entry in the index, in ID order.
+// IDs are expected to be file paths, as the CLI stores them.
+func FromIndex(x *imghash.Index) []*Item {
+	ids := x.IDs()
+	items := make([]*Item, len(ids))
+
+	for i, id := range ids {
+		hash, _ := x.Hash(id)
+		items[i] = &Item{ID: id, Path: id, Old: fmt.Sprintf("%016x", hash)}
+	}
+
+	return items
+}
+
+// ReadManifest reads a list of items, in CSV. Each row holds an ID and
+// a path, optionally followed by the old hash. A header row starting
+// with "id" is skipped.
+func ReadManifest(r io.Reader) ([]*Item, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	var items []*Item
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(items) == 0 && row[0] == "id" {
+			continue
+		}
+
+		if len(row) < 2 {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("migrate: line %d: missing path", line)
+		}
+
+		item := &Item{ID: row[0], Path: row[1]}
+		if len(row) > 2 {
+			item.Old = row[2]
+		}
+
+		items = append(items, item)
+	}
+}
+
+// A Record is a single row of a mapping file.
+type Record struct {
+	Item
+	New string // Hash under the new algorithm.
+	Err string // Error which prevented hashing, if any.
+}
+
+// ReadMapping reads a mapping file. Images which were hashed more than
+// once, because a failure was retried, only yield their last record.
+// Records are returned in the order their IDs first appear.
+func ReadMapping(r io.Reader) ([]*Record, error) {
+	records, _, err := readMapping(r)
+	return records, err
+}
+
+// readMapping reads a mapping file. It also returns the offset just
+// past the last complete row, so a last row cut short by a crash can
+// be dropped. Such a row is not an error.
+func readMapping(r io.Reader) ([]*Record, int64, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(header)
+
+	row, err := cr.Read()
+	if err == io.EOF {
+		return nil, 0, nil
+	}
+
+	if err != nil || row[0] != header[0] {
+		return nil, 0, ErrMapping
+	}
+
+	var records []*Record
+	index := make(map[string]int)
+	offset := cr.InputOffset()
+
+	for {
+		row, err = cr.Read()
+		if err == io.EOF {
+			return records, offset, nil
+		}
+
+		if err != nil {
+			// Only the last row may have been written partially.
+			if _, err = cr.Read(); err != io.EOF {
+				return nil, 0, ErrMapping
+			}
+			return records, offset, nil
+		}
+
+		offset = cr.InputOffset()
+		rec := &Record{Item{row[0], row[1], row[2]}, row[3], row[4]}
+
+		if i, ok := index[rec.ID]; ok {
+			records[i] = rec
+		} else {
+			index[rec.ID] = len(records)
+			records = append(records, rec)
+		}
+	}
+}
+
+// Progress describes how far along a migration is.
+type Progress struct {
+	Total   int // Number of items.
+	Skipped int // Items hashed by an earlier run.
+	Done    int // Items hashed by this run, including failures.
+	Failed  int // Items which failed to hash.
+}
+
+// Options configure a migration. The zero value is a valid configuration.
+type Options struct {
+	// Number of concurrent workers. A value < 1 uses one worker per CPU.
+	Workers int
+
+	// Called after each item, from a single goroutine.
+	OnProgress func(Progress)
+}
+
+// Run hashes all items with h, and appends the results to the mapping
+// file, which is created if it does not exist. Items which already have
+// a new hash in the file are skipped; items which failed before are tried
+// again. Opts may be nil, to use the defaults.
+//
+// Images which fail to decode are recorded with their error, and do not
+// stop the migration. Errors writing the mapping file do.
+func Run(items []*Item, h Hasher, mapping string, opts *Options) (Progress, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Workers < 1 {
+		o.Workers = runtime.NumCPU()
+	}
+
+	p := Progress{Total: len(items)}
+
+	fd, err := os.OpenFile(mapping, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return p, err
+	}
+
+	defer fd.Close()
+
+	done, err := resume(fd)
+	if err != nil {
+		return p, err
+	}
+
+	var todo []*Item
+	for _, item := range items {
+		if done[item.ID] {
+			p.Skipped++
+		} else {
+			todo = append(todo, item)
+		}
+	}
+
+	offset, err := fd.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return p, err
+	}
+
+	cw := csv.NewWriter(fd)
+	if offset == 0 {
+		cw.Write(header)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for rec := range hashItems(todo, h, o.Workers, stop) {
+		cw.Write([]string{rec.ID, rec.Path, rec.Old, rec.New, rec.Err})
+
+		// Keep the file complete up to the last item, in case
+		// the process is killed.
+		if cw.Flush(); cw.Error() != nil {
+			return p, cw.Error()
+		}
+
+		p.Done++
+		if len(rec.Err) > 0 {
+			p.Failed++
+		}
+
+		if o.OnProgress != nil {
+			o.OnProgress(p)
+		}
+	}
+
+	return p, fd.Close()
+}
+
+// resume reads the mapping file, truncates it after its last complete
+// row, and leaves the file offset there. It returns the IDs which have
+// been hashed successfully.
+func resume(fd *os.File) (map[string]bool, error) {
+	records, offset, err := readMapping(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fd.Truncate(offset); err != nil {
+		return nil, err
+	}
+
+	if _, err = fd.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]bool, len(records))
+	for _, rec := range records {
+		if len(rec.Err) == 0 {
+			done[rec.ID] = true
+		}
+	}
+
+	return done, nil
+}
+
+// hashItems hashes the items on a pool of workers, until
+// all are done, or stop is closed.
+func hashItems(items []*Item, h Hasher, workers int, stop <-chan struct{}) <-chan *Record {
+	in := make(chan *Item)
+	out := make(chan *Record)
+
+	go func() {
+		defer close(in)
+
+		for _, item := range items {
+			select {
+			case in <- item:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for item := range in {
+				rec := &Record{Item: *item}
+
+				if img, err := imghash.DecodeFile(item.Path); err != nil {
+					rec.Err = err.Error()
+				} else {
+					rec.New = h(img)
+				}
+
+				select {
+				case out <- rec:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
@@ -0,0 +1,126 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/synth"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	index := imghash.NewIndex()
+
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		png.Encode(&buf, synth.Shapes(64, 64, int64(i)))
+
+		file := filepath.Join(dir, fmt.Sprintf("%d.png", i))
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		index.Add(file, uint64(i))
+	}
+
+	// An image which is missing, and will fail.
+	missing := filepath.Join(dir, "missing.png")
+	index.Add(missing, 42)
+
+	items := FromIndex(index)
+	h := Hash64(imghash.Average)
+	mapping := filepath.Join(dir, "mapping.csv")
+
+	p, err := Run(items[:5], h, mapping, &Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Done != 5 || p.Skipped != 0 || p.Failed != 0 {
+		t.Fatalf("first run %+v", p)
+	}
+
+	// Simulate a crash halfway through writing a row.
+	fd, err := os.OpenFile(mapping, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.WriteString(items[5].ID + "," + items[5].Path + ",00")
+	fd.Close()
+
+	var progress int
+	p, err = Run(items, h, mapping, &Options{OnProgress: func(Progress) { progress++ }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Total != 11 || p.Skipped != 5 || p.Done != 6 || p.Failed != 1 || progress != 6 {
+		t.Fatalf("second run %+v, %d progress calls", p, progress)
+	}
+
+	// The failure is tried again, and fails again.
+	if p, err = Run(items, h, mapping, nil); err != nil || p.Skipped != 10 || p.Failed != 1 {
+		t.Fatalf("third run %+v, %v", p, err)
+	}
+
+	data, err := os.ReadFile(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(data), "id,path,old,new,error\n") || strings.Count(string(data), "\n") != 13 {
+		t.Fatalf("mapping:\n%s", data)
+	}
+
+	records, err := ReadMapping(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 11 {
+		t.Fatalf("%d records", len(records))
+	}
+
+	for _, r := range records {
+		if r.Path == missing {
+			if len(r.Err) == 0 || r.Old != "000000000000002a" {
+				t.Fatalf("missing image: %+v", r)
+			}
+			continue
+		}
+
+		want, _ := imghash.ComputeFile(r.Path, imghash.Average)
+		if r.New != fmt.Sprintf("%016x", want) || len(r.Err) > 0 {
+			t.Fatalf("record %+v, want %016x", r, want)
+		}
+	}
+
+	// Damage in the middle of the file is not mistaken for a crash.
+	damaged := strings.Replace(string(data), ",\n", "\n", 1)
+	if _, err := ReadMapping(strings.NewReader(damaged)); err != ErrMapping {
+		t.Fatalf("damaged mapping: %v", err)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	items, err := ReadManifest(strings.NewReader("id,path,old\na,a.jpg\nb,b.jpg,00ff\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[0].Path != "a.jpg" || items[1].Old != "00ff" {
+		t.Fatalf("items %+v", items)
+	}
+
+	if _, err := ReadManifest(strings.NewReader("a\n")); err == nil {
+		t.Fatal("missing path accepted")
+	}
+}
@@ -0,0 +1,157 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"strconv"
+)
+
+// ErrInvalidMultiHash is returned when decoding a malformed MultiHash.
+var ErrInvalidMultiHash = errors.New("imghash: invalid multi-hash")
+
+// A MultiHash holds the hashes of a single image, computed by several
+// algorithms. It is stored as one value, but compared per component.
+//
+// Two multi-hashes can only be compared meaningfully if they were
+// computed by the same hash functions, in the same order.
+type MultiHash []uint64
+
+// MultiHasher returns a function which computes a MultiHash with
+// one component for each of the given hash functions.
+//
+// The image is scaled down once, to 32x32, for all of them. Hashes
+// which scale it to a grid whose sides divide 32, like the 8x8 of
+// Average, derive their grid from that copy. They yield the same
+// hashes as when run on their own, at a fraction of the cost.
+func MultiHasher(hfs ...HashFunc) func(image.Image) MultiHash {
+	return func(img image.Image) MultiHash {
+		m := make(MultiHash, len(hfs))
+		img = withPyramid(img)
+		for i, hf := range hfs {
+			m[i] = hf(img)
+		}
+		return m
+	}
+}
+
+// Distances returns the Hamming Distance between each pair of
+// components. Components only present in one of the hashes
+// count as entirely different, with a distance of 64.
+func (m MultiHash) Distances(o MultiHash) []uint64 {
+	n := len(m)
+	if len(o) > n {
+		n = len(o)
+	}
+
+	dist := make([]uint64, n)
+	for i := range dist {
+		if i < len(m) && i < len(o) {
+			dist[i] = Distance(m[i], o[i])
+		} else {
+			dist[i] = 64
+		}
+	}
+
+	return dist
+}
+
+// Distance returns the combined Hamming Distance over all components.
+// This is the sum of the values returned by Distances.
+func (m MultiHash) Distance(o MultiHash) uint64 {
+	var sum uint64
+
+	for _, d := range m.Distances(o) {
+		sum += d
+	}
+
+	return sum
+}
+
+// String returns the components as 16 digit hexadecimal
+// strings, without separators.
+func (m MultiHash) String() string {
+	return string(m.appendText(make([]byte, 0, 16*len(m))))
+}
+
+// AppendText implements encoding.TextAppender, appending the format
+// written by String to b.
+func (m MultiHash) AppendText(b []byte) ([]byte, error) {
+	return m.appendText(b), nil
+}
+
+// appendText appends the components to b, as String writes them.
+func (m MultiHash) appendText(b []byte) []byte {
+	for _, h := range m {
+		b = AppendHash(b, h)
+	}
+	return b
+}
+
+// ParseMultiHash parses a multi-hash in the format written by String.
+func ParseMultiHash(s string) (MultiHash, error) {
+	if len(s)%16 != 0 {
+		return nil, ErrInvalidMultiHash
+	}
+
+	m := make(MultiHash, len(s)/16)
+	for i := range m {
+		h, err := strconv.ParseUint(s[16*i:16*i+16], 16, 64)
+		if err != nil {
+			return nil, ErrInvalidMultiHash
+		}
+
+		m[i] = h
+	}
+
+	return m, nil
+}
+
+// MarshalBinary encodes the components as 8 byte, big endian values.
+func (m MultiHash) MarshalBinary() ([]byte, error) {
+	return m.AppendBinary(make([]byte, 0, 8*len(m)))
+}
+
+// AppendBinary implements encoding.BinaryAppender, appending the format
+// written by MarshalBinary to b.
+func (m MultiHash) AppendBinary(b []byte) ([]byte, error) {
+	for _, h := range m {
+		b = AppendHashBinary(b, h)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a multi-hash written by MarshalBinary.
+func (m *MultiHash) UnmarshalBinary(data []byte) error {
+	if len(data)%8 != 0 {
+		return ErrInvalidMultiHash
+	}
+
+	v := make(MultiHash, len(data)/8)
+	for i := range v {
+		v[i] = binary.BigEndian.Uint64(data[8*i:])
+	}
+
+	*m = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, using the format
+// written by String.
+func (m MultiHash) MarshalText() ([]byte, error) {
+	return m.appendText(make([]byte, 0, 16*len(m))), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *MultiHash) UnmarshalText(data []byte) error {
+	v, err := ParseMultiHash(string(data))
+	if err != nil {
+		return err
+	}
+
+	*m = v
+	return nil
+}
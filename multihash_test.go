@@ -0,0 +1,59 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestMultiHash(t *testing.T) {
+	img := checkerboard(image.Rect(0, 0, 32, 32), 8)
+	mh := MultiHasher(Average, Preprocess(Average, Equalize))
+	a := mh(img)
+
+	if len(a) != 2 || a[0] != Average(img) {
+		t.Fatalf("unexpected hash %v", a)
+	}
+
+	b := MultiHash{a[0] ^ 0x7, a[1] ^ 0x1}
+	if d := a.Distances(b); !reflect.DeepEqual(d, []uint64{3, 1}) {
+		t.Fatalf("distances %v", d)
+	}
+
+	if d := a.Distance(b); d != 4 {
+		t.Fatalf("distance %d", d)
+	}
+
+	if d := a.Distances(a[:1]); !reflect.DeepEqual(d, []uint64{0, 64}) {
+		t.Fatalf("distances to shorter hash %v", d)
+	}
+
+	s := MultiHash{0x0123456789abcdef, 1}.String()
+	if s != "0123456789abcdef0000000000000001" {
+		t.Fatalf("string %q", s)
+	}
+
+	p, err := ParseMultiHash(s)
+	if err != nil || !reflect.DeepEqual(p, MultiHash{0x0123456789abcdef, 1}) {
+		t.Fatalf("parse: %v, %v", p, err)
+	}
+
+	for _, s := range []string{"0123", "0123456789abcdeg"} {
+		if _, err := ParseMultiHash(s); err != ErrInvalidMultiHash {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+	}
+
+	data, _ := b.MarshalBinary()
+	var c MultiHash
+	if err := c.UnmarshalBinary(data); err != nil || !reflect.DeepEqual(b, c) {
+		t.Fatalf("binary round trip: %v, %v", c, err)
+	}
+
+	if err := c.UnmarshalBinary(data[:5]); err != ErrInvalidMultiHash {
+		t.Fatalf("unmarshal truncated: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "math/bits"
+
+// Neighbors calls f for hash, and for every other hash within the given
+// distance of it, in order of increasing distance. It stops when f
+// returns false.
+//
+// This turns a radius query into a number of exact lookups, so a plain
+// key-value store -- like Redis or Bigtable -- can find near-duplicates
+// without a specialized index. The number of hashes grows quickly with
+// the distance: CountNeighbors(64, distance) says how many there are.
+// Up to distance 2, that is 2081 lookups; at distance 3, already 43745.
+// For larger distances, store hashes under a prefix and use
+// PrefixNeighbors instead.
+func Neighbors(hash, distance uint64, f func(hash, distance uint64) bool) {
+	neighbors(hash, 64, distance, f)
+}
+
+// PrefixNeighbors calls f for the first bits bits of hash, and for every
+// other prefix of that length within the given distance of it, in order
+// of increasing distance. It stops when f returns false.
+//
+// If hashes are stored in buckets keyed by their prefix, a hash within
+// distance d of a query has a prefix within distance d of the query's.
+// Reading the buckets for these prefixes, and comparing the hashes found
+// there against the query, therefore finds all matches:
+//
+//	imghash.PrefixNeighbors(query, 16, 6, func(prefix, _ uint64) bool {
+//		for _, h := range buckets[prefix] {
+//			if imghash.Distance(h, query) <= 6 {
+//				matches = append(matches, h)
+//			}
+//		}
+//		return true
+//	})
+//
+// Shorter prefixes mean fewer buckets to read, but larger ones.
+// CountNeighbors(bits, distance) returns the number of buckets.
+func PrefixNeighbors(hash uint64, bits uint, distance uint64, f func(prefix, distance uint64) bool) {
+	if bits == 0 {
+		f(0, 0)
+		return
+	}
+
+	if bits > 64 {
+		bits = 64
+	}
+
+	neighbors(hash>>(64-bits), bits, distance, f)
+}
+
+// CountNeighbors returns the number of values of the given width in bits
+// which are within the given distance of any one value, including it.
+// This is the number of times Neighbors and PrefixNeighbors call their
+// function, unless it stops them. The count saturates at the largest
+// uint64.
+func CountNeighbors(width uint, distance uint64) uint64 {
+	if width > 64 {
+		width = 64
+	}
+
+	var sum, c uint64 = 0, 1
+	var k uint64
+
+	for k = 0; k <= distance && k <= uint64(width); k++ {
+		if sum+c < sum {
+			return ^uint64(0)
+		}
+
+		sum += c
+
+		// The binomial coefficients fit in 64 bits, but
+		// their product with the next factor may not.
+		hi, lo := bits.Mul64(c, uint64(width)-k)
+		c, _ = bits.Div64(hi, lo, k+1)
+	}
+
+	return sum
+}
+
+// neighbors calls f for every value of the given width within distance
+// of v, by increasing distance. It returns false if f stopped it.
+func neighbors(v uint64, width uint, distance uint64, f func(uint64, uint64) bool) bool {
+	var d uint64
+	for d = 0; d <= distance && d <= uint64(width); d++ {
+		if !flipBits(v, width, 0, d, d, f) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// flipBits calls f for every value which differs from v in exactly n of
+// the bits from bit from up to width. The distance d is passed along.
+func flipBits(v uint64, width, from uint, n, d uint64, f func(uint64, uint64) bool) bool {
+	if n == 0 {
+		return f(v, d)
+	}
+
+	for bit := from; uint64(bit)+n <= uint64(width); bit++ {
+		if !flipBits(v^1<<bit, width, bit+1, n-1, d, f) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,84 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestNeighbors(t *testing.T) {
+	const hash = 0xf0e1d2c3b4a59687
+
+	seen := make(map[uint64]bool)
+	var last uint64
+
+	Neighbors(hash, 2, func(h, d uint64) bool {
+		if seen[h] || Distance(h, hash) != d || d < last {
+			t.Fatalf("neighbor 0x%x at distance %d, after %d", h, d, last)
+		}
+
+		seen[h], last = true, d
+		return true
+	})
+
+	if n := uint64(len(seen)); n != CountNeighbors(64, 2) || n != 2081 {
+		t.Fatalf("%d neighbors, want 2081", n)
+	}
+
+	var calls int
+	Neighbors(hash, 3, func(h, d uint64) bool {
+		calls++
+		return calls < 10
+	})
+
+	if calls != 10 {
+		t.Fatalf("%d calls after stopping at 10", calls)
+	}
+
+	if CountNeighbors(64, 64) != ^uint64(0) || CountNeighbors(8, 64) != 256 || CountNeighbors(16, 0) != 1 {
+		t.Fatal("wrong neighbor counts")
+	}
+}
+
+func TestPrefixNeighbors(t *testing.T) {
+	const bits, distance = 12, 7
+
+	rng := rand.New(rand.NewSource(1))
+	query := rng.Uint64()
+
+	// Random hashes, some of them near the query.
+	buckets := make(map[uint64][]uint64)
+	var want int
+	for i := 0; i < 5000; i++ {
+		h := rng.Uint64()
+		if i%5 == 0 {
+			h = query
+			for j := rng.Intn(10); j > 0; j-- {
+				h ^= 1 << uint(rng.Intn(64))
+			}
+		}
+
+		if Distance(h, query) <= distance {
+			want++
+		}
+
+		buckets[h>>(64-bits)] = append(buckets[h>>(64-bits)], h)
+	}
+
+	var got, probes int
+	PrefixNeighbors(query, bits, distance, func(prefix, d uint64) bool {
+		probes++
+		for _, h := range buckets[prefix] {
+			if Distance(h, query) <= distance {
+				got++
+			}
+		}
+		return true
+	})
+
+	if got != want || uint64(probes) != CountNeighbors(bits, distance) {
+		t.Fatalf("found %d of %d matches in %d buckets", got, want, probes)
+	}
+}
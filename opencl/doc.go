@@ -0,0 +1,38 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package opencl implements imghash.DistanceBackend on a GPU, through
+OpenCL. It compares a query against every loaded hash in parallel, for
+collections of 100 million hashes and more, where even a linear scan
+over all CPUs takes too long:
+
+	b, err := opencl.New()
+	if err != nil {
+		return err
+	}
+
+	defer b.Close()
+	db.Backend = b
+
+The hashes are copied to device memory once, when they are loaded, so
+a query only transfers the indices of its matches. Every 100 million
+hashes take 800 MB of device memory.
+
+The backend is only included when building with the opencl tag, as it
+needs cgo and the OpenCL headers and library:
+
+	go build -tags opencl
+
+Without the tag, New fails with ErrUnavailable.
+*/
+package opencl
+
+import "errors"
+
+// ErrUnavailable is returned by New if the package was built
+// without the opencl tag.
+var ErrUnavailable = errors.New("opencl: built without the opencl tag")
+
+// ErrNoDevice is returned by New if there is no OpenCL device.
+var ErrNoDevice = errors.New("opencl: no device found")
@@ -0,0 +1,347 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build opencl
+
+package opencl
+
+/*
+#cgo linux LDFLAGS: -lOpenCL
+#cgo windows LDFLAGS: -lOpenCL
+#cgo darwin LDFLAGS: -framework OpenCL
+
+#define CL_TARGET_OPENCL_VERSION 120
+
+#include <stdlib.h>
+#ifdef __APPLE__
+#include <OpenCL/opencl.h>
+#else
+#include <CL/cl.h>
+#endif
+
+// The kernel compares one hash against the query, and appends its
+// index to out if it is within distance. The count of matches goes
+// on past size, so too small a buffer can be detected.
+static const char *source =
+	"__kernel void within(__global const ulong *hashes, const uint n,\n"
+	"		const ulong query, const uint distance,\n"
+	"		__global uint *out, const uint size, volatile __global uint *count) {\n"
+	"	uint i = get_global_id(0);\n"
+	"	if (i < n && popcount(hashes[i] ^ query) <= distance) {\n"
+	"		uint j = atomic_inc(count);\n"
+	"		if (j < size)\n"
+	"			out[j] = i;\n"
+	"	}\n"
+	"}\n";
+
+static cl_program build(cl_context ctx, cl_device_id dev, cl_int *err) {
+	cl_program p = clCreateProgramWithSource(ctx, 1, &source, NULL, err);
+	if (*err != CL_SUCCESS)
+		return NULL;
+
+	*err = clBuildProgram(p, 1, &dev, "", NULL, NULL);
+	if (*err != CL_SUCCESS) {
+		clReleaseProgram(p);
+		return NULL;
+	}
+
+	return p;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// errTooMany is returned by Load for more hashes than a
+// 32 bit index can address.
+var errTooMany = errors.New("opencl: too many hashes")
+
+// Number of work items the global size is rounded up to.
+const workGroup = 256
+
+// An Error is an error code returned by OpenCL.
+type Error int
+
+func (e Error) Error() string { return fmt.Sprintf("opencl: error %d", int(e)) }
+
+// check returns the error for the given status, or nil.
+func check(status C.cl_int) error {
+	if status != C.CL_SUCCESS {
+		return Error(status)
+	}
+	return nil
+}
+
+// A Backend compares hashes on an OpenCL device. It implements
+// imghash.DistanceBackend. It is not safe for concurrent use.
+type Backend struct {
+	ctx    C.cl_context
+	queue  C.cl_command_queue
+	prog   C.cl_program
+	kernel C.cl_kernel
+
+	hashes C.cl_mem // Loaded hashes, if any.
+	n      int      // Number of loaded hashes.
+	out    C.cl_mem // Indices of matches.
+	size   int      // Capacity of out.
+	count  C.cl_mem // Number of matches.
+}
+
+// New returns a Backend on the first GPU found. If there is none, it
+// uses any other OpenCL device, like a CPU driver. It returns
+// ErrNoDevice if there is no device at all.
+func New() (*Backend, error) {
+	dev, ok := findDevice()
+	if !ok {
+		return nil, ErrNoDevice
+	}
+
+	b := &Backend{}
+	var status C.cl_int
+
+	b.ctx = C.clCreateContext(nil, 1, &dev, nil, nil, &status)
+	if err := check(status); err != nil {
+		return nil, err
+	}
+
+	b.queue = C.clCreateCommandQueue(b.ctx, dev, 0, &status)
+	if err := check(status); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	b.prog = C.build(b.ctx, dev, &status)
+	if err := check(status); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	name := C.CString("within")
+	defer C.free(unsafe.Pointer(name))
+
+	b.kernel = C.clCreateKernel(b.prog, name, &status)
+	if err := check(status); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	b.count = C.clCreateBuffer(b.ctx, C.CL_MEM_READ_WRITE, 4, nil, &status)
+	if err := check(status); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// findDevice returns the first GPU on any platform, or
+// failing that, the first device of any kind.
+func findDevice() (C.cl_device_id, bool) {
+	var platforms [16]C.cl_platform_id
+	var np C.cl_uint
+
+	if C.clGetPlatformIDs(C.cl_uint(len(platforms)), &platforms[0], &np) != C.CL_SUCCESS {
+		return nil, false
+	}
+
+	var dev C.cl_device_id
+	var n C.cl_uint
+
+	for _, kind := range []C.cl_device_type{C.CL_DEVICE_TYPE_GPU, C.CL_DEVICE_TYPE_ALL} {
+		for _, p := range platforms[:np] {
+			if C.clGetDeviceIDs(p, kind, 1, &dev, &n) == C.CL_SUCCESS && n > 0 {
+				return dev, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Load copies the hashes to device memory. The slice itself is not
+// kept, so it may be modified once Load returns.
+func (b *Backend) Load(hashes []uint64) error {
+	if len(hashes) > math.MaxUint32 {
+		return errTooMany
+	}
+
+	release(&b.hashes)
+	b.n = 0
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var status C.cl_int
+	flags := C.cl_mem_flags(C.CL_MEM_READ_ONLY | C.CL_MEM_COPY_HOST_PTR)
+	b.hashes = C.clCreateBuffer(b.ctx, flags, C.size_t(8*len(hashes)), unsafe.Pointer(&hashes[0]), &status)
+	if err := check(status); err != nil {
+		b.hashes = nil
+		return err
+	}
+
+	b.n = len(hashes)
+	return nil
+}
+
+// Within returns the indices of the loaded hashes within the
+// given distance of query, in increasing order.
+func (b *Backend) Within(query, distance uint64) ([]int, error) {
+	if b.n == 0 {
+		return nil, nil
+	}
+
+	if distance > 64 {
+		distance = 64
+	}
+
+	if b.out == nil {
+		if err := b.grow(b.n / 1024); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		count, err := b.run(query, distance)
+		if err != nil {
+			return nil, err
+		}
+
+		// Too many matches for the buffer: run again with a larger one.
+		if count > b.size {
+			if err := b.grow(count); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return b.indices(count)
+	}
+}
+
+// run runs the kernel, and returns the number of matches.
+func (b *Backend) run(query, distance uint64) (int, error) {
+	var zero C.cl_uint
+	if err := check(C.clEnqueueWriteBuffer(b.queue, b.count, C.CL_TRUE, 0, 4, unsafe.Pointer(&zero), 0, nil, nil)); err != nil {
+		return 0, err
+	}
+
+	hashes, out, count := b.hashes, b.out, b.count
+	n, size := C.cl_uint(b.n), C.cl_uint(b.size)
+	q, d := C.cl_ulong(query), C.cl_uint(distance)
+
+	args := []struct {
+		size uintptr
+		ptr  unsafe.Pointer
+	}{
+		{unsafe.Sizeof(hashes), unsafe.Pointer(&hashes)},
+		{unsafe.Sizeof(n), unsafe.Pointer(&n)},
+		{unsafe.Sizeof(q), unsafe.Pointer(&q)},
+		{unsafe.Sizeof(d), unsafe.Pointer(&d)},
+		{unsafe.Sizeof(out), unsafe.Pointer(&out)},
+		{unsafe.Sizeof(size), unsafe.Pointer(&size)},
+		{unsafe.Sizeof(count), unsafe.Pointer(&count)},
+	}
+
+	for i, a := range args {
+		if err := check(C.clSetKernelArg(b.kernel, C.cl_uint(i), C.size_t(a.size), a.ptr)); err != nil {
+			return 0, err
+		}
+	}
+
+	global := C.size_t((b.n + workGroup - 1) / workGroup * workGroup)
+	if err := check(C.clEnqueueNDRangeKernel(b.queue, b.kernel, 1, nil, &global, nil, 0, nil, nil)); err != nil {
+		return 0, err
+	}
+
+	var matches C.cl_uint
+	if err := check(C.clEnqueueReadBuffer(b.queue, b.count, C.CL_TRUE, 0, 4, unsafe.Pointer(&matches), 0, nil, nil)); err != nil {
+		return 0, err
+	}
+
+	return int(matches), nil
+}
+
+// indices reads the first count indices from the output buffer.
+func (b *Backend) indices(count int) ([]int, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	idx := make([]uint32, count)
+	if err := check(C.clEnqueueReadBuffer(b.queue, b.out, C.CL_TRUE, 0, C.size_t(4*count), unsafe.Pointer(&idx[0]), 0, nil, nil)); err != nil {
+		return nil, err
+	}
+
+	// Work items finish in any order.
+	out := make([]int, count)
+	for i, v := range idx {
+		out[i] = int(v)
+	}
+
+	sort.Ints(out)
+	return out, nil
+}
+
+// grow replaces the output buffer with one for size indices.
+func (b *Backend) grow(size int) error {
+	if size < workGroup {
+		size = workGroup
+	}
+
+	release(&b.out)
+	b.size = 0
+
+	var status C.cl_int
+	b.out = C.clCreateBuffer(b.ctx, C.CL_MEM_WRITE_ONLY, C.size_t(4*size), nil, &status)
+	if err := check(status); err != nil {
+		b.out = nil
+		return err
+	}
+
+	b.size = size
+	return nil
+}
+
+// Close releases the device memory and other resources of the backend.
+func (b *Backend) Close() error {
+	release(&b.hashes)
+	release(&b.out)
+	release(&b.count)
+
+	if b.kernel != nil {
+		C.clReleaseKernel(b.kernel)
+		b.kernel = nil
+	}
+
+	if b.prog != nil {
+		C.clReleaseProgram(b.prog)
+		b.prog = nil
+	}
+
+	if b.queue != nil {
+		C.clReleaseCommandQueue(b.queue)
+		b.queue = nil
+	}
+
+	if b.ctx != nil {
+		C.clReleaseContext(b.ctx)
+		b.ctx = nil
+	}
+
+	return nil
+}
+
+// release releases the memory object m, if any.
+func release(m *C.cl_mem) {
+	if *m != nil {
+		C.clReleaseMemObject(*m)
+		*m = nil
+	}
+}
@@ -0,0 +1,22 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build !opencl
+
+package opencl
+
+// A Backend compares hashes on an OpenCL device. This build has no
+// OpenCL support; all of its methods fail with ErrUnavailable.
+type Backend struct{}
+
+// New fails with ErrUnavailable.
+func New() (*Backend, error) { return nil, ErrUnavailable }
+
+// Load fails with ErrUnavailable.
+func (b *Backend) Load(hashes []uint64) error { return ErrUnavailable }
+
+// Within fails with ErrUnavailable.
+func (b *Backend) Within(query, distance uint64) ([]int, error) { return nil, ErrUnavailable }
+
+// Close does nothing.
+func (b *Backend) Close() error { return nil }
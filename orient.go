@@ -0,0 +1,114 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "fmt"
+
+// An Orientation is one of the eight ways to rotate an image by a
+// multiple of 90 degrees, and optionally mirror it.
+type Orientation int
+
+// Known orientations. Rotations are clockwise.
+const (
+	Identity   Orientation = iota // The image as is.
+	Rotate90                      // Rotated by 90 degrees.
+	Rotate180                     // Rotated by 180 degrees.
+	Rotate270                     // Rotated by 270 degrees.
+	FlipH                         // Mirrored left to right.
+	FlipV                         // Mirrored top to bottom.
+	Transpose                     // Mirrored along the top left to bottom right diagonal.
+	Transverse                    // Mirrored along the top right to bottom left diagonal.
+	orientations
+)
+
+var orientationNames = [...]string{"identity", "rotate 90", "rotate 180", "rotate 270", "flip horizontal", "flip vertical", "transpose", "transverse"}
+
+func (o Orientation) String() string {
+	if o < 0 || o >= orientations {
+		return fmt.Sprintf("Orientation(%d)", int(o))
+	}
+	return orientationNames[o]
+}
+
+// Orient returns the hash the image hashed to hash would have, if it
+// were rotated or mirrored as o says. No image is needed: the bits are
+// moved around the grid they were computed on.
+//
+// This holds for hashes which set one bit per cell of an 8x8 grid, in
+// row-major order, from values which do not depend on where the cells
+// are. Average is such a hash, as is AverageWith, unless it is given a
+// Mask. Shrinking the image to the grid distributes the pixels over the
+// cells the same way in any orientation, so for these, Orient yields
+// exactly the hash of the transformed image. It does not apply to the
+// other hashes in this package.
+func Orient(hash uint64, o Orientation) uint64 {
+	var out uint64
+	var x, y int
+
+	for y = 0; y < 8; y++ {
+		for x = 0; x < 8; x++ {
+			if hash&(1<<uint(y*8+x)) != 0 {
+				nx, ny := orient(o, 8, x, y)
+				out |= 1 << uint(ny*8+nx)
+			}
+		}
+	}
+
+	return out
+}
+
+// Orientations returns the hashes of all eight orientations of the
+// image hashed to hash, indexed by Orientation. Querying an index with
+// each of them finds copies of the image which were rotated or
+// mirrored, without hashing the image eight times. Refer to Orient for
+// the hashes this applies to.
+func Orientations(hash uint64) [8]uint64 {
+	var out [8]uint64
+	for o := range out {
+		out[o] = Orient(hash, Orientation(o))
+	}
+	return out
+}
+
+// Orient1024 is Orient, for hashes from Average1024.
+func Orient1024(hash Hash1024, o Orientation) Hash1024 {
+	var out Hash1024
+	var x, y, bit int
+
+	for y = 0; y < 32; y++ {
+		for x = 0; x < 32; x++ {
+			bit = y*32 + x
+			if hash[bit/64]&(1<<uint(bit%64)) != 0 {
+				nx, ny := orient(o, 32, x, y)
+				bit = ny*32 + nx
+				out[bit/64] |= 1 << uint(bit%64)
+			}
+		}
+	}
+
+	return out
+}
+
+// orient returns where cell (x, y) of an n by n grid ends up,
+// in orientation o.
+func orient(o Orientation, n, x, y int) (int, int) {
+	switch o {
+	case Rotate90:
+		return n - 1 - y, x
+	case Rotate180:
+		return n - 1 - x, n - 1 - y
+	case Rotate270:
+		return y, n - 1 - x
+	case FlipH:
+		return n - 1 - x, y
+	case FlipV:
+		return x, n - 1 - y
+	case Transpose:
+		return y, x
+	case Transverse:
+		return n - 1 - y, n - 1 - x
+	}
+
+	return x, y
+}
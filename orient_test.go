@@ -0,0 +1,80 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	img := synth.Shapes(70, 50, 4)
+
+	hashers := map[string]HashFunc{
+		"average":  Average,
+		"weighted": AverageWith(&AverageOptions{CenterWeight: 2, Trim: 0.1}),
+		"median":   AverageWith(&AverageOptions{Median: true}),
+	}
+
+	for name, hf := range hashers {
+		hash := hf(img)
+		all := Orientations(hash)
+
+		for o := Identity; o < orientations; o++ {
+			if want := hf(orientImage(img, o)); all[o] != want {
+				t.Errorf("%s, %v: got 0x%x, want 0x%x", name, o, all[o], want)
+			}
+		}
+	}
+
+	hash := Average1024(img)
+	for o := Identity; o < orientations; o++ {
+		if got, want := Orient1024(hash, o), Average1024(orientImage(img, o)); got != want {
+			t.Errorf("1024, %v: distance %d", o, Distance1024(got, want))
+		}
+	}
+
+	if h := Average(img); Orient(Orient(h, Rotate90), Rotate270) != h || Orient(Orient(h, Transpose), Transpose) != h {
+		t.Fatal("orientations do not undo each other")
+	}
+}
+
+// orientImage rotates or mirrors img as o says.
+func orientImage(img *image.RGBA, o Orientation) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	if o == Rotate90 || o == Rotate270 || o == Transpose || o == Transverse {
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	var x, y, nx, ny int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			switch o {
+			case Rotate90:
+				nx, ny = h-1-y, x
+			case Rotate180:
+				nx, ny = w-1-x, h-1-y
+			case Rotate270:
+				nx, ny = y, w-1-x
+			case FlipH:
+				nx, ny = w-1-x, y
+			case FlipV:
+				nx, ny = x, h-1-y
+			case Transpose:
+				nx, ny = y, x
+			case Transverse:
+				nx, ny = h-1-y, w-1-x
+			default:
+				nx, ny = x, y
+			}
+
+			out.SetRGBA(nx, ny, img.RGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,194 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrInvalidLayout is returned for a PackLayout which can not be used.
+var ErrInvalidLayout = errors.New("imghash: invalid pack layout")
+
+// A PackLayout describes how PackHashes lays out hashes in memory, for
+// Hamming matchers on GPUs or FPGAs. The zero value packs each hash into
+// a single 64 bit, little endian word, without any padding.
+type PackLayout struct {
+	// Bytes per word: 1, 2, 4 or 8. Defaults to 8. Bit i of a hash is
+	// bit i%(8*WordSize) of word i/(8*WordSize) of its row; for words
+	// smaller than the hash, the least significant word comes first.
+	WordSize int
+
+	// Store words most significant byte first.
+	BigEndian bool
+
+	// Bytes per row, each holding one hash, padded with zeros. It must be
+	// a multiple of WordSize. Defaults to the size of the hash, which
+	// packs the rows without gaps.
+	Stride int
+
+	// The whole block is padded with zeros to a multiple of Align bytes,
+	// as some devices require of buffers they read from. Zero or one
+	// leaves the block as is.
+	Align int
+
+	// Bytes per row index in the results of a matcher, as read by
+	// ReadIndices: 4 or 8. Defaults to 4.
+	IndexSize int
+}
+
+// PackHashes writes the hashes to w as a single block of rows, in the
+// given order and layout. A nil layout uses the defaults. A row's index
+// in the block is its index in hashes, so ReadIndices can map results
+// back onto them. It returns the number of bytes written.
+func PackHashes(w io.Writer, hashes []uint64, layout *PackLayout) (int64, error) {
+	return packRows(w, len(hashes), 1, layout, func(i int) []uint64 {
+		return hashes[i : i+1]
+	})
+}
+
+// PackHashes1024 is PackHashes, for 1024 bit hashes.
+func PackHashes1024(w io.Writer, hashes []Hash1024, layout *PackLayout) (int64, error) {
+	return packRows(w, len(hashes), len(Hash1024{}), layout, func(i int) []uint64 {
+		return hashes[i][:]
+	})
+}
+
+// ReadIndices reads the row indices a matcher reports, until the end of
+// r. Rows are numbered as PackHashes laid them out. Indices which do not
+// refer to one of the given number of rows -- the padding, or a sentinel
+// for no match, like all ones -- are left out.
+func ReadIndices(r io.Reader, rows int, layout *PackLayout) ([]int, error) {
+	var l PackLayout
+	if layout != nil {
+		l = *layout
+	}
+
+	switch l.IndexSize {
+	case 0:
+		l.IndexSize = 4
+	case 4, 8:
+	default:
+		return nil, ErrInvalidLayout
+	}
+
+	order := byteOrder(l)
+	buf := make([]byte, l.IndexSize)
+	br := bufio.NewReader(r)
+
+	var out []int
+	for {
+		_, err := io.ReadFull(br, buf)
+		switch err {
+		case nil:
+		case io.EOF:
+			return out, nil
+		default:
+			return out, err
+		}
+
+		var v uint64
+		if l.IndexSize == 4 {
+			v = uint64(order.Uint32(buf))
+		} else {
+			v = order.Uint64(buf)
+		}
+
+		if v < uint64(rows) {
+			out = append(out, int(v))
+		}
+	}
+}
+
+// packRows writes n rows of the given number of 64 bit words each.
+func packRows(w io.Writer, n, words int, layout *PackLayout, row func(int) []uint64) (int64, error) {
+	l, err := packLayout(layout, 8*words)
+	if err != nil {
+		return 0, err
+	}
+
+	order := byteOrder(l)
+	out := make([]byte, l.Stride)
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	var i, j int
+	for i = 0; i < n; i++ {
+		// Split each 64 bit word into smaller ones, low bits first.
+		var o int
+		for _, v := range row(i) {
+			for j = 0; j < 8/l.WordSize; j++ {
+				part := v >> uint(8*l.WordSize*j)
+
+				switch l.WordSize {
+				case 1:
+					out[o] = byte(part)
+				case 2:
+					order.PutUint16(out[o:], uint16(part))
+				case 4:
+					order.PutUint32(out[o:], uint32(part))
+				case 8:
+					order.PutUint64(out[o:], part)
+				}
+
+				o += l.WordSize
+			}
+		}
+
+		cw.Write(out)
+	}
+
+	if l.Align > 1 {
+		if pad := int(cw.n % int64(l.Align)); pad > 0 {
+			cw.Write(make([]byte, l.Align-pad))
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// packLayout returns the layout with its defaults applied, for
+// hashes of the given size in bytes.
+func packLayout(layout *PackLayout, size int) (PackLayout, error) {
+	var l PackLayout
+	if layout != nil {
+		l = *layout
+	}
+
+	if l.WordSize == 0 {
+		l.WordSize = 8
+	}
+
+	if l.IndexSize == 0 {
+		l.IndexSize = 4
+	}
+
+	if l.Stride == 0 {
+		l.Stride = size
+	}
+
+	switch {
+	case l.WordSize != 1 && l.WordSize != 2 && l.WordSize != 4 && l.WordSize != 8,
+		l.IndexSize != 4 && l.IndexSize != 8,
+		l.Stride < size || l.Stride%l.WordSize != 0,
+		l.Align < 0:
+		return l, ErrInvalidLayout
+	}
+
+	return l, nil
+}
+
+// byteOrder returns the byte order of the layout.
+func byteOrder(l PackLayout) binary.ByteOrder {
+	if l.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
@@ -0,0 +1,75 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPackHashes(t *testing.T) {
+	hashes := []uint64{0x0102030405060708, 0xf0e0d0c0b0a09080}
+
+	var buf bytes.Buffer
+	n, err := PackHashes(&buf, hashes, nil)
+	if err != nil || n != 16 {
+		t.Fatalf("%d bytes, %v", n, err)
+	}
+
+	if binary.LittleEndian.Uint64(buf.Bytes()[8:]) != hashes[1] {
+		t.Fatalf("default layout: %x", buf.Bytes())
+	}
+
+	// 32 bit big endian words, low word first, 16 byte rows,
+	// aligned to 64 bytes.
+	buf.Reset()
+	n, err = PackHashes(&buf, hashes, &PackLayout{WordSize: 4, BigEndian: true, Stride: 16, Align: 64})
+	if err != nil || n != 64 {
+		t.Fatalf("%d bytes, %v", n, err)
+	}
+
+	want := []byte{
+		0x05, 0x06, 0x07, 0x08, 0x01, 0x02, 0x03, 0x04, 0, 0, 0, 0, 0, 0, 0, 0,
+		0xb0, 0xa0, 0x90, 0x80, 0xf0, 0xe0, 0xd0, 0xc0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	if !bytes.Equal(buf.Bytes()[:32], want) || !bytes.Equal(buf.Bytes()[32:], make([]byte, 32)) {
+		t.Fatalf("packed %x", buf.Bytes())
+	}
+
+	var h Hash1024
+	h[0], h[15] = 1, 1<<63
+	buf.Reset()
+	if n, err = PackHashes1024(&buf, []Hash1024{h}, &PackLayout{WordSize: 1}); err != nil || n != 128 {
+		t.Fatalf("%d bytes, %v", n, err)
+	}
+
+	if b := buf.Bytes(); b[0] != 1 || b[127] != 0x80 {
+		t.Fatalf("packed %x", b)
+	}
+
+	for _, l := range []*PackLayout{{WordSize: 3}, {Stride: 4}, {WordSize: 4, Stride: 10}, {IndexSize: 2}} {
+		if _, err := PackHashes(&buf, hashes, l); err != ErrInvalidLayout {
+			t.Fatalf("layout %+v: %v", l, err)
+		}
+	}
+}
+
+func TestReadIndices(t *testing.T) {
+	var buf bytes.Buffer
+	for _, v := range []uint32{1, 0, 7, 0xffffffff, 2} {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], v)
+		buf.Write(b[:])
+	}
+
+	// A partial index at the end is an error.
+	buf.WriteByte(0)
+
+	rows, err := ReadIndices(&buf, 3, &PackLayout{BigEndian: true})
+	if err == nil || len(rows) != 3 || rows[0] != 1 || rows[1] != 0 || rows[2] != 2 {
+		t.Fatalf("rows %v, %v", rows, err)
+	}
+}
@@ -0,0 +1,173 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNoPage is returned by DecodePages for pages a file does not have.
+var ErrNoPage = errors.New("imghash: no such page")
+
+// tagSubfileType marks the kind of image a TIFF directory holds. Bit 0
+// is set for reduced resolution copies of another page.
+const tagSubfileType = 0x00fe
+
+// maxPages limits the number of pages read from a single file, so
+// cyclic or endless page lists are cheap. Faxes and scanned documents
+// stay well below it.
+const maxPages = 4096
+
+// A Page is a single page of a multi-page file.
+type Page struct {
+	Index int // Position of the page in the file, from 0.
+	Image image.Image
+}
+
+// PageHash holds the hash of a single page.
+type PageHash struct {
+	Index int    // Position of the page in the file, from 0.
+	Hash  uint64 // Perceptual Image hash.
+}
+
+// PageCount returns the number of pages in the file: the number of
+// images in a multi-page TIFF, and 1 for any other file. Reduced
+// resolution copies of pages, which scanners store as previews, do not
+// count as pages.
+func PageCount(data []byte) int {
+	if pages := tiffPages(data); len(pages) > 0 {
+		return len(pages)
+	}
+
+	return 1
+}
+
+// DecodePages decodes the given pages of a multi-page file, by index
+// from 0, in the order given. Without indices, it decodes all pages.
+// Files other than multi-page TIFFs, like faxes and scanned documents,
+// have a single page; Decode only ever returns the first. Indices past
+// the last page fail with ErrNoPage.
+//
+// Each page is decoded like Decode would decode it, so TIFF pages need
+// a registered TIFF decoder, which the ximage subpackage provides. The
+// pages are limited to MaxPixels pixels in total.
+func DecodePages(r io.Reader, pages ...int) ([]*Page, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// RAW files are TIFF as well, but only their preview is decoded.
+	offsets := tiffPages(data)
+	if _, err := ExtractPreview(data); err == nil || len(offsets) == 0 {
+		offsets = []uint32{0}
+	}
+
+	if len(pages) == 0 {
+		pages = make([]int, len(offsets))
+		for i := range pages {
+			pages[i] = i
+		}
+	}
+
+	out := make([]*Page, 0, len(pages))
+	var total int
+
+	for _, i := range pages {
+		if i < 0 || i >= len(offsets) {
+			return nil, ErrNoPage
+		}
+
+		page := data
+		if offsets[i] != 0 {
+			// Offsets in TIFF files are from the start of the file, so
+			// pointing the header at the page's directory makes the page
+			// the first image of the file.
+			page = append([]byte(nil), data...)
+			tiffOrder(data).PutUint32(page[4:], offsets[i])
+		}
+
+		img, err := Decode(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+
+		rect := img.Bounds()
+		if total += rect.Dx() * rect.Dy(); total > MaxPixels {
+			return nil, ErrTooLarge
+		}
+
+		out = append(out, &Page{Index: i, Image: img})
+	}
+
+	return out, nil
+}
+
+// DecodePagesFile decodes the given pages of the file.
+// Refer to DecodePages for details.
+func DecodePagesFile(file string, pages ...int) ([]*Page, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return DecodePages(fd, pages...)
+}
+
+// ComputePages decodes the given pages of the file in r, as DecodePages
+// does, and computes their hashes using the given HashFunc. Pass 0 to
+// hash the first page only, or no indices to hash all of them.
+func ComputePages(r io.Reader, hf HashFunc, pages ...int) ([]PageHash, error) {
+	start := time.Now()
+	m := currentMetrics()
+
+	decoded, err := DecodePages(r, pages...)
+	if err != nil {
+		m.DecodeFailed(err)
+		return nil, err
+	}
+
+	out := make([]PageHash, len(decoded))
+	for i, p := range decoded {
+		out[i] = PageHash{p.Index, hf(p.Image)}
+	}
+
+	m.ImageHashed(time.Since(start))
+	return out, nil
+}
+
+// tiffPages returns the offsets of the directories of the pages in a
+// TIFF file, in order. It returns nil for other files.
+func tiffPages(data []byte) []uint32 {
+	t := tiffFile{data: data, order: tiffOrder(data)}
+	if t.order == nil || len(data) < 8 {
+		return nil
+	}
+
+	var pages []uint32
+	seen := make(map[uint32]bool)
+
+	for offset := t.order.Uint32(data[4:]); offset != 0 && !seen[offset] && len(seen) < maxPages; {
+		seen[offset] = true
+
+		ifd, next, ok := t.ifd(offset)
+		if !ok {
+			break
+		}
+
+		if kind := t.uints(ifd[tagSubfileType]); len(kind) == 0 || kind[0]&1 == 0 {
+			pages = append(pages, offset)
+		}
+
+		offset = next
+	}
+
+	return pages
+}
@@ -0,0 +1,164 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+func TestDecodePages(t *testing.T) {
+	// Decoder for the first page of the TIFF files makeTIFF builds.
+	defer func(d []*Decoder) { decoders = d }(decoders)
+	RegisterDecoder(&Decoder{
+		Name:  "tiff",
+		Magic: "II*\x00",
+		Decode: func(r io.Reader) (image.Image, error) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+
+			tf := tiffFile{data: data, order: binary.LittleEndian}
+			ifd, _, ok := tf.ifd(binary.LittleEndian.Uint32(data[4:]))
+			if !ok {
+				return nil, errors.New("invalid directory")
+			}
+
+			strips := tf.previews(ifd)
+			if len(strips) == 0 {
+				return nil, errors.New("no strip")
+			}
+
+			return jpeg.Decode(bytes.NewReader(strips[0]))
+		},
+	})
+
+	var want []uint64
+	var strips [][]byte
+
+	for _, n := range []int64{2, 3, 5} {
+		jpg := encodeJPEG(t, blockPattern(n))
+		hash, err := ComputeBytes(jpg, Average)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, hash)
+		strips = append(strips, jpg)
+	}
+
+	// A reduced resolution copy of the first page, as a preview.
+	preview := encodeJPEG(t, resize(blockPattern(4), 16, 16))
+	data := makeTIFF([][]byte{strips[0], preview, strips[1], strips[2]}, []bool{false, true, false, false})
+
+	if n := PageCount(data); n != 3 {
+		t.Fatalf("got %d pages, want 3", n)
+	}
+
+	hashes, err := ComputePages(bytes.NewReader(data), Average)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hashes) != 3 {
+		t.Fatalf("got %d hashes, want 3", len(hashes))
+	}
+
+	for i, h := range hashes {
+		if h.Index != i || h.Hash != want[i] {
+			t.Fatalf("page %d: got %d, %016x, want %016x", i, h.Index, h.Hash, want[i])
+		}
+	}
+
+	hashes, err = ComputePages(bytes.NewReader(data), Average, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hashes) != 2 || hashes[0] != (PageHash{2, want[2]}) || hashes[1] != (PageHash{0, want[0]}) {
+		t.Fatalf("selected pages: got %v", hashes)
+	}
+
+	if _, err := DecodePages(bytes.NewReader(data), 3); err != ErrNoPage {
+		t.Fatalf("got %v, want ErrNoPage", err)
+	}
+
+	// Other files have a single page.
+	pages, err := DecodePages(bytes.NewReader(strips[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 1 || pages[0].Index != 0 || Average(pages[0].Image) != want[1] {
+		t.Fatalf("single page: got %d pages", len(pages))
+	}
+
+	if _, err := DecodePages(bytes.NewReader(strips[1]), 1); err != ErrNoPage {
+		t.Fatalf("got %v, want ErrNoPage", err)
+	}
+
+	// MaxPixels holds for all pages together.
+	defer func(max int) { MaxPixels = max }(MaxPixels)
+	rect := blockPattern(2).Bounds()
+	MaxPixels = 2 * rect.Dx() * rect.Dy()
+
+	if _, err := DecodePages(bytes.NewReader(data)); err != ErrTooLarge {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+}
+
+// makeTIFF builds a little endian TIFF file with a directory for each
+// of the given JPEG strips, marked as reduced resolution if set.
+func makeTIFF(strips [][]byte, reduced []bool) []byte {
+	var buf bytes.Buffer
+
+	put := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	entry := func(tag, typ uint16, value uint32) {
+		put(tag)
+		put(typ)
+		put(uint32(1))
+		put(value)
+	}
+
+	// Header, a directory of 4 entries for each strip, then the strips.
+	const size = 2 + 4*12 + 4
+	at := 8 + uint32(len(strips))*size
+
+	buf.WriteString("II*\x00")
+	put(uint32(8))
+
+	var i int
+	for i = range strips {
+		var kind uint32
+		if reduced[i] {
+			kind = 1
+		}
+
+		next := 8 + uint32(i+1)*size
+		if i == len(strips)-1 {
+			next = 0
+		}
+
+		put(uint16(4))
+		entry(tagSubfileType, 4, kind)
+		entry(tagCompression, 4, 6)
+		entry(tagStripOffsets, 4, at)
+		entry(tagStripCounts, 4, uint32(len(strips[i])))
+		put(next)
+
+		at += uint32(len(strips[i]))
+	}
+
+	for i = range strips {
+		buf.Write(strips[i])
+	}
+
+	return buf.Bytes()
+}
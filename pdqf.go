@@ -0,0 +1,168 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// PDQ works on a 64x64 luma image, of which it keeps the 16x16 lowest
+// frequencies of the DCT.
+const (
+	pdqSize     = 64
+	pdqFeatures = 16
+	pdqPasses   = 2 // Passes of the Jarosz filter.
+)
+
+// pdqDCT holds the rows of the 64 point DCT matrix for frequencies
+// 1 to 16, as PDQ computes them.
+var pdqDCT = func() (m [pdqFeatures][pdqSize]float32) {
+	scale := math.Sqrt(2.0 / pdqSize)
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] = float32(scale * math.Cos(math.Pi/2/pdqSize*float64(i+1)*float64(2*j+1)))
+		}
+	}
+	return
+}()
+
+// PDQFeatures returns the 256 PDQF features of a frame, for ComputeTMK:
+// the DCT coefficients PDQ takes the median of, before they are reduced
+// to bits. These are the frame features of the TMK+PDQF reference
+// implementation.
+//
+// The image is converted to luma, with weights of 0.299, 0.587 and 0.114
+// for red, green and blue, and transparency ignored. It is blurred with
+// two passes of a box filter a 128th of its size along either axis, and
+// the 64x64 pixels at the centres of a regular grid are kept. The
+// features are the coefficients for the 16x16 lowest frequencies of their
+// DCT, leaving out the constant ones, row by row. Like PDQ, it works in
+// single precision.
+//
+// The reference has ffmpeg scale frames to 64x64 before computing their
+// features, where this blurs and samples them as PDQ does for images.
+// The box filter for frames of 64x64 pixels spans a single pixel, so
+// only the features of frames of other sizes differ slightly from the
+// reference.
+func PDQFeatures(img image.Image) []float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return make([]float64, pdqFeatures*pdqFeatures)
+	}
+
+	luma := make([]float32, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			luma[y*w+x] = 0.299*float32(c.R) + 0.587*float32(c.G) + 0.114*float32(c.B)
+		}
+	}
+
+	jaroszFilter(luma, w, h, pdqWindow(w), pdqWindow(h))
+
+	var small [pdqSize][pdqSize]float32
+	for i := range small {
+		y := int((float64(i) + 0.5) * float64(h) / pdqSize)
+		for j := range small[i] {
+			x := int((float64(j) + 0.5) * float64(w) / pdqSize)
+			small[i][j] = luma[y*w+x]
+		}
+	}
+
+	// The coefficients are D * small * D', with the products and
+	// sums in single precision, as PDQ computes them.
+	var tmp [pdqFeatures][pdqSize]float32
+	for i := range tmp {
+		for j := 0; j < pdqSize; j++ {
+			var sum float32
+			for k := 0; k < pdqSize; k++ {
+				sum += float32(pdqDCT[i][k] * small[k][j])
+			}
+			tmp[i][j] = sum
+		}
+	}
+
+	out := make([]float64, 0, pdqFeatures*pdqFeatures)
+	for i := 0; i < pdqFeatures; i++ {
+		for j := 0; j < pdqFeatures; j++ {
+			var sum float32
+			for k := 0; k < pdqSize; k++ {
+				sum += float32(tmp[i][k] * pdqDCT[j][k])
+			}
+			out = append(out, float64(sum))
+		}
+	}
+
+	return out
+}
+
+// pdqWindow returns the size of the box filter for a side of the
+// given length: about half the number of pixels per output pixel.
+func pdqWindow(n int) int {
+	return (n + 2*pdqSize - 1) / (2 * pdqSize)
+}
+
+// jaroszFilter blurs the w by h image in place, with repeated box
+// filters along the rows and columns.
+func jaroszFilter(img []float32, w, h, windowX, windowY int) {
+	tmp := make([]float32, len(img))
+
+	for pass := 0; pass < pdqPasses; pass++ {
+		for y := 0; y < h; y++ {
+			boxFilter(img[y*w:], tmp[y*w:], w, 1, windowX)
+		}
+
+		for x := 0; x < w; x++ {
+			boxFilter(tmp[x:], img[x:], h, w, windowY)
+		}
+	}
+}
+
+// boxFilter writes the means of the window around each of the n values
+// of in, stride apart, to out. Near the ends, the window shrinks to the
+// values there are.
+func boxFilter(in, out []float32, n, stride, window int) {
+	if window > n {
+		window = n
+	}
+
+	half := (window + 2) / 2
+
+	var sum float32
+	var size, l, r, o int
+
+	for i := 0; i < half-1; i++ {
+		sum += in[r]
+		size++
+		r += stride
+	}
+
+	for i := 0; i < window-half+1; i++ {
+		sum += in[r]
+		size++
+		out[o] = sum / float32(size)
+		r += stride
+		o += stride
+	}
+
+	for i := 0; i < n-window; i++ {
+		sum += in[r]
+		sum -= in[l]
+		out[o] = sum / float32(size)
+		l += stride
+		r += stride
+		o += stride
+	}
+
+	for i := 0; i < half-1; i++ {
+		sum -= in[l]
+		size--
+		out[o] = sum / float32(size)
+		l += stride
+		o += stride
+	}
+}
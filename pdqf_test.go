@@ -0,0 +1,128 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// dctBasis returns a size x size image of the DCT basis function for
+// horizontal frequency u and vertical frequency v, scaled by a around a
+// mid gray, scaled up by the given factor by repeating pixels.
+func dctBasis(u, v int, a float64, factor int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 64*factor, 64*factor))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			b := math.Cos(math.Pi/128*float64(u*(2*x+1))) * math.Cos(math.Pi/128*float64(v*(2*y+1)))
+			c := color.Gray{uint8(math.Round(128 + a*b))}
+
+			for i := 0; i < factor*factor; i++ {
+				img.SetGray(x*factor+i%factor, y*factor+i/factor, c)
+			}
+		}
+	}
+
+	return img
+}
+
+func TestPDQFeatures(t *testing.T) {
+	// The DCT rows are orthonormal, so the features of a basis function
+	// of amplitude a are 32a at its own frequency, and 0 elsewhere. The
+	// constant ones are not among the features, so the mid gray around
+	// which it varies does not show. Rounding the pixels to 8 bits adds
+	// a little noise.
+	for _, tt := range []struct{ u, v int }{{1, 1}, {2, 1}, {1, 2}, {16, 16}, {5, 11}} {
+		f := PDQFeatures(dctBasis(tt.u, tt.v, 100, 1))
+		if len(f) != 256 {
+			t.Fatalf("%d features", len(f))
+		}
+
+		want := (tt.v-1)*16 + tt.u - 1
+		for i, v := range f {
+			if i == want && math.Abs(v-3200) > 32 || i != want && math.Abs(v) > 32 {
+				t.Errorf("basis %d,%d: feature %d is %.1f", tt.u, tt.v, i, v)
+			}
+		}
+	}
+
+	// Frequencies above 16 are left out.
+	for i, v := range PDQFeatures(dctBasis(17, 3, 100, 1)) {
+		if math.Abs(v) > 32 {
+			t.Errorf("basis 17,3: feature %d is %.1f", i, v)
+		}
+	}
+
+	// Flat images have no features.
+	flat := image.NewUniform(color.Gray{90})
+	for i, v := range PDQFeatures(&wrappedImage{flat, image.Rect(0, 0, 100, 70)}) {
+		if math.Abs(v) > 1e-3 {
+			t.Fatalf("flat: feature %d is %g", i, v)
+		}
+	}
+
+	// Images of up to 128 pixels along a side are filtered with a
+	// window of a single pixel, so sampling every other pixel of one
+	// twice the size yields the same features, up to rounding.
+	a := PDQFeatures(dctBasis(3, 4, 80, 1))
+	b := PDQFeatures(dctBasis(3, 4, 80, 2))
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-3 {
+			t.Fatalf("feature %d: %g at 64x64, %g at 128x128", i, a[i], b[i])
+		}
+	}
+
+	// Larger images are blurred first, which barely changes the
+	// lowest frequencies.
+	c := PDQFeatures(dctBasis(3, 4, 80, 5))
+	if s := cosine(a, c); s < 0.99 {
+		t.Fatalf("similarity at 320x320: %.4f", s)
+	}
+
+	if f := PDQFeatures(image.NewGray(image.Rect(0, 0, 0, 0))); len(f) != 256 {
+		t.Fatalf("empty image: %d features", len(f))
+	}
+}
+
+// wrappedImage gives an image other bounds.
+type wrappedImage struct {
+	image.Image
+	r image.Rectangle
+}
+
+func (m *wrappedImage) Bounds() image.Rectangle { return m.r }
+
+func TestBoxFilter(t *testing.T) {
+	// Windows of even sizes reach further ahead than back.
+	in := []float32{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, tt := range []struct {
+		window int
+		want   []float32
+	}{
+		{1, []float32{1, 2, 3, 4, 5, 6, 7, 8}},
+		{2, []float32{1.5, 2.5, 3.5, 4.5, 5.5, 6.5, 7.5, 8}},
+		{3, []float32{1.5, 2, 3, 4, 5, 6, 7, 7.5}},
+		{4, []float32{2, 2.5, 3.5, 4.5, 5.5, 6.5, 7, 7.5}},
+	} {
+		out := make([]float32, len(in))
+		boxFilter(in, out, len(in), 1, tt.window)
+
+		for i := range out {
+			if out[i] != tt.want[i] {
+				t.Errorf("window %d: %v, want %v", tt.window, out, tt.want)
+				break
+			}
+		}
+	}
+
+	// Columns are filtered in place, stride apart.
+	col := []float32{1, 0, 2, 0, 3, 0}
+	out := make([]float32, len(col))
+	boxFilter(col, out, 3, 2, 3)
+	if out[0] != 1.5 || out[2] != 2 || out[4] != 2.5 || out[1] != 0 {
+		t.Fatalf("strided %v", out)
+	}
+}
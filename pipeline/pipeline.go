@@ -0,0 +1,487 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package pipeline finds near-duplicates in a stream of images, as they
+come in from a crawler, an upload queue or any other source.
+
+Each image is fetched, decoded, preprocessed and hashed with one or
+more algorithms. Its hashes are then looked up in an index of all
+images seen so far, and added to it. Images which match earlier ones
+are reported to a callback:
+
+	p := pipeline.New(&pipeline.Options{
+		Hashes:   []imghash.HashFunc{imghash.Average, imghash.Screenshot(nil)},
+		Distance: 10,
+		OnMatch: func(r *pipeline.Result) {
+			fmt.Printf("%s duplicates %s\n", r.ID, r.Matches[0].ID)
+		},
+	})
+
+	sources := make(chan pipeline.Source)
+	go func() {
+		for _, u := range urls {
+			sources <- pipeline.URL(u)
+		}
+		close(sources)
+	}()
+
+	err := p.Run(ctx, sources)
+
+Fetching is retried with exponential backoff, since servers and networks
+fail temporarily. Decoding is not: an image which does not decode once
+will not decode on the next try either.
+
+A pipeline can serve user-facing lookups while a backfill runs through
+it. Sources have a priority, and the workers of all runs and lookups are
+shared: whenever one is free, it takes an Interactive source before any
+Bulk one. Setting Options.Reserved keeps workers free for interactive
+sources, so a lookup does not wait for a slow bulk download to finish:
+
+	p := pipeline.New(&pipeline.Options{Workers: 16, Reserved: 2})
+	go p.Run(ctx, backfill)
+	...
+	r, err := p.Lookup(ctx, pipeline.Reader("upload", req.Body))
+*/
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"image"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrReused is returned by the Open function of a Reader source, when
+// it is asked for its data a second time.
+var ErrReused = errors.New("pipeline: reader can not be read again")
+
+// SpanFetch is the name of the span around fetching a source, with all
+// its retries. The other stages use the spans of the imghash package.
+const SpanFetch = "pipeline.fetch"
+
+// Defaults for Options.
+const (
+	defaultRetries = 3
+	defaultBackoff = 500 * time.Millisecond
+)
+
+// A Priority is the lane a source is scheduled in.
+type Priority int
+
+// Known priorities.
+const (
+	Bulk        Priority = iota // Ingest and backfills.
+	Interactive                 // User-facing lookups, which go first.
+	priorities
+)
+
+var priorityNames = [...]string{"bulk", "interactive"}
+
+func (p Priority) String() string {
+	if p < 0 || p >= priorities {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// A Source is an image to feed into a pipeline.
+type Source struct {
+	// Identifies the image in the index and in results.
+	// Sources with the same ID replace each other.
+	ID string
+
+	// Opens the image data. It is called again for every retry.
+	// If nil, the ID is downloaded as a URL.
+	Open func(ctx context.Context) (io.ReadCloser, error)
+
+	// Lane the source is scheduled in. Defaults to Bulk.
+	Priority Priority
+}
+
+// URL returns a Source which downloads the image at the given URL, with
+// Options.Client and Options.Fetch. The URL is its ID.
+func URL(rawurl string) Source {
+	return Source{ID: rawurl}
+}
+
+// Reader returns a Source which reads the image from r. A reader can
+// only be read once, so the source can not be retried. If r is an
+// io.Closer, it is closed when the pipeline is done with it.
+func Reader(id string, r io.Reader) Source {
+	var mu sync.Mutex
+	return Source{
+		ID: id,
+		Open: func(context.Context) (io.ReadCloser, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if r == nil {
+				return nil, ErrReused
+			}
+
+			rc, ok := r.(io.ReadCloser)
+			if !ok {
+				rc = io.NopCloser(r)
+			}
+
+			r = nil
+			return rc, nil
+		},
+	}
+}
+
+// A Match is an earlier image which resembles the current one.
+type Match struct {
+	ID       string
+	Distance uint64 // Combined distance, as MultiHash.Distance.
+}
+
+// A Result is the outcome for a single source.
+type Result struct {
+	ID      string
+	Hash    imghash.MultiHash // One component per Options.Hashes.
+	Matches []Match           // Closest first; empty for new images.
+}
+
+// Options configure a pipeline. All callbacks are optional; they are
+// called from the worker goroutines, so they must be safe for
+// concurrent use.
+type Options struct {
+	// Hash functions to compute for every image. Defaults to Average.
+	Hashes []imghash.HashFunc
+
+	// Filters to apply to every image before it is hashed.
+	Filters []imghash.Filter
+
+	// Largest combined distance, over all hashes, at which two images
+	// match. The default of 0 only matches images with identical hashes.
+	Distance uint64
+
+	// Number of images processed at the same time, over all runs and
+	// lookups. A value < 1 uses one worker per CPU.
+	Workers int
+
+	// Number of workers kept free for Interactive sources. Bulk sources
+	// never take the last Reserved workers, so interactive ones do not
+	// wait for them. It is at most Workers-1. Defaults to 0.
+	Reserved int
+
+	// Number of times to retry a source which could not be fetched,
+	// and the time to wait before the first retry. The wait doubles
+	// with every retry. Default to 3 retries, and half a second.
+	// Set Retries < 0 to not retry at all.
+	Retries int
+	Backoff time.Duration
+
+	// Decides whether a fetch error is worth retrying. By default,
+	// all are, except for ErrTooLarge, ErrContentType and ErrReused.
+	Retry func(err error) bool
+
+	// Used by URL sources. Client may be nil, to use http.DefaultClient,
+	// and Fetch may be nil, to use the defaults of imghash.FetchURL.
+	Client *http.Client
+	Fetch  *imghash.FetchOptions
+
+	// Called for every image, once it has been hashed and indexed.
+	OnResult func(r *Result)
+
+	// Called for every image which matches at least one earlier image.
+	OnMatch func(r *Result)
+
+	// Called for every source which failed. The pipeline continues.
+	OnError func(id string, err error)
+}
+
+// A Pipeline hashes images and matches them against all images it
+// has seen before. It is safe for concurrent use.
+type Pipeline struct {
+	opts   Options
+	hasher func(image.Image) imghash.MultiHash
+	sched  *scheduler
+
+	mu     sync.Mutex
+	index  *imghash.Index // First component of every hash.
+	hashes map[string]imghash.MultiHash
+}
+
+// New creates an empty pipeline. Opts may be nil, to use the defaults.
+func New(opts *Options) *Pipeline {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+
+	if len(o.Hashes) == 0 {
+		o.Hashes = []imghash.HashFunc{imghash.Average}
+	}
+
+	if o.Workers < 1 {
+		o.Workers = runtime.NumCPU()
+	}
+
+	if o.Reserved >= o.Workers {
+		o.Reserved = o.Workers - 1
+	}
+
+	if o.Retries == 0 {
+		o.Retries = defaultRetries
+	}
+
+	if o.Backoff <= 0 {
+		o.Backoff = defaultBackoff
+	}
+
+	if o.Retry == nil {
+		o.Retry = retryable
+	}
+
+	return &Pipeline{
+		opts:   o,
+		hasher: imghash.MultiHasher(o.Hashes...),
+		sched:  newScheduler(o.Workers, o.Reserved),
+		index:  imghash.NewIndex(),
+		hashes: make(map[string]imghash.MultiHash),
+	}
+}
+
+// Len returns the number of images in the index.
+func (p *Pipeline) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.hashes)
+}
+
+// Hash returns the hash of the image with the given ID, and whether
+// it is in the index.
+func (p *Pipeline) Hash(id string) (imghash.MultiHash, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m, ok := p.hashes[id]
+	return m, ok
+}
+
+// Add adds an image to the index, with hashes computed beforehand, and
+// returns the images it matches. This seeds a pipeline with images
+// from an earlier run. The hash must have one component per
+// Options.Hashes.
+func (p *Pipeline) Add(id string, hash imghash.MultiHash) []Match {
+	return p.add(context.Background(), id, hash)
+}
+
+// add adds an image to the index, tracing the query and the addition
+// under the span in ctx.
+func (p *Pipeline) add(ctx context.Context, id string, hash imghash.MultiHash) []Match {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	matches := p.match(ctx, id, hash)
+
+	_, end := imghash.StartSpan(ctx, imghash.SpanIndexAdd)
+	p.index.Add(id, hash[0])
+	p.hashes[id] = hash
+	end(nil)
+
+	return matches
+}
+
+// match returns the images in the index which match the hash, other
+// than the one with the given ID, closest first. The caller holds p.mu.
+func (p *Pipeline) match(ctx context.Context, id string, hash imghash.MultiHash) []Match {
+	_, end := imghash.StartSpan(ctx, imghash.SpanIndexQuery)
+	defer end(nil)
+
+	var matches []Match
+	for _, r := range p.index.Query(hash[0], p.opts.Distance) {
+		if r.Path == id {
+			continue
+		}
+
+		if d := hash.Distance(p.hashes[r.Path]); d <= p.opts.Distance {
+			matches = append(matches, Match{ID: r.Path, Distance: d})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	return matches
+}
+
+// Run processes the sources received on the given channel, until it is
+// closed or the context is done. It returns once all workers have
+// finished, with the error of the context, if any.
+//
+// Images are matched against all images added before them, including
+// those of earlier runs. Two duplicates processed at the same time
+// match one way: whichever is added to the index last reports the other.
+//
+// Runs may overlap, and share the workers of the pipeline. Sources are
+// started in the order of their priority, then in the order they were
+// received. A source which is being processed is not interrupted.
+func (p *Pipeline) Run(ctx context.Context, sources <-chan Source) error {
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case src, ok := <-sources:
+					if !ok {
+						return
+					}
+
+					if p.sched.acquire(ctx, src.Priority) != nil {
+						return
+					}
+
+					p.process(ctx, src)
+					p.sched.release()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Lookup hashes a single source and returns the images it matches,
+// without adding it to the index. It runs as an Interactive source,
+// regardless of src.Priority, on a worker shared with all runs, and
+// returns once it is done. Callbacks are not called.
+func (p *Pipeline) Lookup(ctx context.Context, src Source) (*Result, error) {
+	if err := p.sched.acquire(ctx, Interactive); err != nil {
+		return nil, err
+	}
+
+	defer p.sched.release()
+
+	hash, err := p.hash(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &Result{ID: src.ID, Hash: hash, Matches: p.match(ctx, src.ID, hash)}, nil
+}
+
+// process runs a single source through the pipeline.
+func (p *Pipeline) process(ctx context.Context, src Source) {
+	r := &Result{ID: src.ID}
+
+	var err error
+	if r.Hash, err = p.hash(ctx, src); err != nil {
+		// Don't report sources abandoned because the run was stopped.
+		if ctx.Err() != nil {
+			return
+		}
+
+		if p.opts.OnError != nil {
+			p.opts.OnError(src.ID, err)
+		}
+		return
+	}
+
+	r.Matches = p.add(ctx, src.ID, r.Hash)
+
+	if p.opts.OnResult != nil {
+		p.opts.OnResult(r)
+	}
+
+	if len(r.Matches) > 0 && p.opts.OnMatch != nil {
+		p.opts.OnMatch(r)
+	}
+}
+
+// hash fetches, decodes, preprocesses and hashes a source, tracing
+// each stage under the span in ctx.
+func (p *Pipeline) hash(ctx context.Context, src Source) (imghash.MultiHash, error) {
+	fctx, end := imghash.StartSpan(ctx, SpanFetch)
+	data, err := p.fetch(fctx, src)
+	end(err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, end = imghash.StartSpan(ctx, imghash.SpanDecode)
+	img, err := imghash.Decode(bytes.NewReader(data))
+	end(err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	img = imghash.ApplyFilters(ctx, img, p.opts.Filters...)
+
+	_, end = imghash.StartSpan(ctx, imghash.SpanHash)
+	defer end(nil)
+
+	return p.hasher(img), nil
+}
+
+// fetch reads the data of a source, with retries.
+func (p *Pipeline) fetch(ctx context.Context, src Source) ([]byte, error) {
+	wait := p.opts.Backoff
+
+	for try := 0; ; try++ {
+		data, err := p.read(ctx, src)
+		if err == nil || try >= p.opts.Retries || !p.opts.Retry(err) {
+			return data, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+	}
+}
+
+// read reads the data of a source once.
+func (p *Pipeline) read(ctx context.Context, src Source) ([]byte, error) {
+	if src.Open == nil {
+		return imghash.FetchURL(ctx, p.opts.Client, src.ID, p.opts.Fetch)
+	}
+
+	rc, err := src.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// retryable is the default for Options.Retry.
+func retryable(err error) bool {
+	switch {
+	case errors.Is(err, imghash.ErrTooLarge), errors.Is(err, imghash.ErrContentType),
+		errors.Is(err, ErrReused), errors.Is(err, context.Canceled):
+		return false
+	}
+	return true
+}
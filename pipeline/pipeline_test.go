@@ -0,0 +1,318 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func encode(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRun(t *testing.T) {
+	original := synth.Shapes(128, 96, 1)
+	images := map[string][]byte{
+		"original": encode(t, original),
+		"copy":     encode(t, attack.JPEG(60).Apply(original)),
+		"other":    encode(t, synth.Text(128, 96, 2)),
+		"broken":   []byte("not an image"),
+	}
+
+	var mu sync.Mutex
+	var matched, failed []string
+	var results int
+
+	p := New(&Options{
+		Hashes:   []imghash.HashFunc{imghash.Average, imghash.Screenshot(nil)},
+		Distance: 8,
+		Workers:  1,
+		OnResult: func(r *Result) {
+			mu.Lock()
+			results++
+			mu.Unlock()
+		},
+		OnMatch: func(r *Result) {
+			mu.Lock()
+			matched = append(matched, r.ID+"="+r.Matches[0].ID)
+			mu.Unlock()
+		},
+		OnError: func(id string, err error) {
+			mu.Lock()
+			failed = append(failed, id)
+			mu.Unlock()
+		},
+	})
+
+	// A single worker, so the copy comes after the original.
+	sources := make(chan Source)
+	go func() {
+		for _, id := range []string{"original", "other", "copy", "broken"} {
+			sources <- Reader(id, bytes.NewReader(images[id]))
+		}
+		close(sources)
+	}()
+
+	if err := p.Run(context.Background(), sources); err != nil {
+		t.Fatal(err)
+	}
+
+	if results != 3 || p.Len() != 3 {
+		t.Fatalf("%d results, %d indexed", results, p.Len())
+	}
+
+	if len(matched) != 1 || matched[0] != "copy=original" {
+		t.Fatalf("matches %v", matched)
+	}
+
+	if len(failed) != 1 || failed[0] != "broken" {
+		t.Fatalf("failures %v", failed)
+	}
+
+	if h, ok := p.Hash("other"); !ok || len(h) != 2 {
+		t.Fatalf("hash of other: %v, %v", h, ok)
+	}
+
+	// Seeded hashes are matched like the others.
+	h, _ := p.Hash("original")
+	if m := p.Add("seeded", h); len(m) != 2 || m[0] != (Match{"original", 0}) || m[1].ID != "copy" {
+		t.Fatalf("seeded matches %v", m)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	data := encode(t, synth.Shapes(64, 64, 1))
+
+	var calls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flaky.png", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "text/html")
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var ids []string
+	p := New(&Options{
+		Client:   srv.Client(),
+		Backoff:  time.Millisecond,
+		OnResult: func(r *Result) { mu.Lock(); ids = append(ids, r.ID); mu.Unlock() },
+		OnError:  func(id string, err error) { mu.Lock(); ids = append(ids, "error "+id); mu.Unlock() },
+	})
+
+	run := func(url string) {
+		sources := make(chan Source, 1)
+		sources <- URL(url)
+		close(sources)
+
+		calls = 0
+		if err := p.Run(context.Background(), sources); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(srv.URL + "/flaky.png")
+	if calls != 3 || len(ids) != 1 || !strings.HasSuffix(ids[0], "/flaky.png") {
+		t.Fatalf("%d calls, results %v", calls, ids)
+	}
+
+	// Responses which are not images are not retried.
+	run(srv.URL + "/page.html")
+	if calls != 1 || len(ids) != 2 || !strings.HasPrefix(ids[1], "error ") {
+		t.Fatalf("%d calls, results %v", calls, ids)
+	}
+
+	// Nor are readers which have been read.
+	src := Reader("once", bytes.NewReader(data))
+	src.Open(context.Background())
+	if _, err := src.Open(context.Background()); err != ErrReused {
+		t.Fatalf("second open: %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var mu sync.Mutex
+	var errs []string
+	p := New(&Options{
+		Workers: 1,
+		OnError: func(id string, err error) { mu.Lock(); errs = append(errs, id); mu.Unlock() },
+	})
+
+	sources := make(chan Source)
+	go func() {
+		sources <- Source{ID: "blocked", Open: func(ctx context.Context) (io.ReadCloser, error) {
+			cancel()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}}
+	}()
+
+	if err := p.Run(ctx, sources); err != context.Canceled {
+		t.Fatalf("run: %v", err)
+	}
+
+	if len(errs) != 0 || p.Len() != 0 {
+		t.Fatalf("errors %v, %d indexed", errs, p.Len())
+	}
+}
+
+func TestReserved(t *testing.T) {
+	data := encode(t, synth.Shapes(64, 64, 1))
+
+	started := make(chan string, 2)
+	gate := make(chan struct{})
+
+	source := func(id string) Source {
+		return Source{ID: id, Open: func(context.Context) (io.ReadCloser, error) {
+			started <- id
+			if id == "bulk0" {
+				<-gate
+			}
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}}
+	}
+
+	var mu sync.Mutex
+	var ids []string
+	p := New(&Options{
+		Workers:  2,
+		Reserved: 1,
+		OnResult: func(r *Result) { mu.Lock(); ids = append(ids, r.ID); mu.Unlock() },
+	})
+
+	sources := make(chan Source, 2)
+	sources <- source("bulk0")
+	sources <- source("bulk1")
+	close(sources)
+
+	done := make(chan error)
+	go func() { done <- p.Run(context.Background(), sources) }()
+
+	if id := <-started; id != "bulk0" {
+		t.Fatalf("started %s first", id)
+	}
+
+	// The second worker is kept for lookups, while bulk0 is stuck.
+	r, err := p.Lookup(context.Background(), Reader("lookup", bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Matches) != 0 || len(r.Hash) != 1 {
+		t.Fatalf("lookup: %v", r)
+	}
+
+	select {
+	case id := <-started:
+		t.Fatalf("%s started on the reserved worker", id)
+	default:
+	}
+
+	close(gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != "bulk0" || ids[1] != "bulk1" || p.Len() != 2 {
+		t.Fatalf("results %v, %d indexed", ids, p.Len())
+	}
+
+	// Lookups match, but are not added.
+	if r, err = p.Lookup(context.Background(), Reader("again", bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Matches) != 2 || r.Matches[0].ID != "bulk0" || p.Len() != 2 {
+		t.Fatalf("matches %v, %d indexed", r.Matches, p.Len())
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := newScheduler(1, 0)
+	ctx := context.Background()
+
+	if err := s.acquire(ctx, Bulk); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until n sources are queued in the lane.
+	queued := func(prio Priority, n int) {
+		for {
+			s.mu.Lock()
+			m := len(s.waiting[prio])
+			s.mu.Unlock()
+
+			if m == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	order := make(chan Priority, 2)
+
+	for _, prio := range []Priority{Bulk, Interactive} {
+		wg.Add(1)
+		go func(prio Priority) {
+			defer wg.Done()
+			s.acquire(ctx, prio)
+			order <- prio
+			s.release()
+		}(prio)
+
+		queued(prio, 1)
+	}
+
+	// A bulk source which gives up waiting leaves the lane.
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() {
+		queued(Bulk, 2)
+		cancel()
+	}()
+
+	if err := s.acquire(cancelled, Bulk); err != context.Canceled {
+		t.Fatalf("cancelled acquire: %v", err)
+	}
+
+	s.release()
+	if a, b := <-order, <-order; a != Interactive || b != Bulk {
+		t.Fatalf("order %v, %v", a, b)
+	}
+
+	wg.Wait()
+	if s.free != 1 {
+		t.Fatalf("%d free workers, want 1", s.free)
+	}
+}
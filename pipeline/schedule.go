@@ -0,0 +1,84 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// A scheduler hands out workers to sources, by priority. Interactive
+// sources get any free worker; bulk sources only get one while more
+// than the reserved number are free, and no interactive source waits.
+type scheduler struct {
+	mu       sync.Mutex
+	free     int
+	reserved int
+	waiting  [priorities][]chan struct{} // First come, first served.
+}
+
+func newScheduler(workers, reserved int) *scheduler {
+	return &scheduler{free: workers, reserved: reserved}
+}
+
+// acquire waits for a worker for a source of the given priority. It
+// returns the error of the context if it is done first.
+func (s *scheduler) acquire(ctx context.Context, prio Priority) error {
+	if prio < 0 || prio >= priorities {
+		prio = Bulk
+	}
+
+	s.mu.Lock()
+
+	ready := make(chan struct{})
+	s.waiting[prio] = append(s.waiting[prio], ready)
+	s.dispatch()
+
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, c := range s.waiting[prio] {
+		if c == ready {
+			s.waiting[prio] = append(s.waiting[prio][:i], s.waiting[prio][i+1:]...)
+			return ctx.Err()
+		}
+	}
+
+	// The worker was handed out as the context was done.
+	s.free++
+	s.dispatch()
+	return ctx.Err()
+}
+
+// release returns a worker.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	s.free++
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// dispatch hands free workers to waiting sources. The caller holds s.mu.
+func (s *scheduler) dispatch() {
+	for s.free > 0 {
+		lane := &s.waiting[Interactive]
+		if len(*lane) == 0 {
+			if lane = &s.waiting[Bulk]; len(*lane) == 0 || s.free <= s.reserved {
+				return
+			}
+		}
+
+		close((*lane)[0])
+		*lane = (*lane)[1:]
+		s.free--
+	}
+}
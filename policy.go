@@ -0,0 +1,139 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+// A Policy turns the hashes of two images by several algorithms, and
+// their metadata, into a single verdict. It holds the rules by which an
+// application decides what counts as a copy, which are otherwise spread
+// over the code around its calls to Classify:
+//
+//	p := &imghash.Policy{
+//		Rules: []imghash.PolicyRule{
+//			{Algorithm: "average"},
+//			{Algorithm: "document", Thresholds: imghash.Thresholds{Duplicate: 2}},
+//		},
+//		Agree:       2,
+//		Constraints: []imghash.Constraint{{Key: "uploader", Same: false}},
+//	}
+//
+//	d, err := p.Evaluate(upload, known)
+//
+// The config subpackage reads policies from the [policy] table of a
+// configuration file.
+type Policy struct {
+	// Rules compare the hashes of one algorithm each. No rules compare
+	// Average hashes by their own thresholds.
+	Rules []PolicyRule
+
+	// Number of rules which must agree on a verdict for it to stand.
+	// Zero requires all of them to.
+	Agree int
+
+	// Constraints on the metadata of the images. Images which fail any
+	// are Distinct, whatever their hashes.
+	Constraints []Constraint
+}
+
+// A PolicyRule compares the hashes of the images by one algorithm.
+type PolicyRule struct {
+	// Name of the algorithm, as registered with Register.
+	Algorithm string
+
+	// Thresholds to classify the distance by, in place of those of the
+	// algorithm. Fields left at 0 keep the algorithm's.
+	Thresholds Thresholds
+}
+
+// A Constraint holds the images to the value of one metadata key. Images
+// without the key have an empty value.
+type Constraint struct {
+	Key string `json:"key"`
+
+	// If set, the images must have the same value to be copies.
+	// Otherwise they must have different ones: a user uploading their
+	// own image again is not a copy, say.
+	Same bool `json:"same"`
+}
+
+// A Subject is what a Policy knows of an image.
+type Subject struct {
+	Hashes map[string]uint64 // Hashes of the image, by algorithm name.
+	Meta   map[string]string // Metadata of the image.
+}
+
+// A PolicyDecision is the verdict of a Policy, and its grounds.
+type PolicyDecision struct {
+	Verdict Verdict `json:"verdict"`
+
+	// Decisions of the rules, in order. Rules for which either image has
+	// no hash have none, and count as finding the images Distinct.
+	Decisions []*Decision `json:"decisions"`
+
+	// Constraint the images failed, if any.
+	Violated *Constraint `json:"violated,omitempty"`
+}
+
+// Evaluate applies the policy to two images. It returns an error
+// wrapping ErrUnknownAlgorithm if a rule names an algorithm which is not
+// registered.
+//
+// The verdict is Duplicate if enough rules find the images duplicates,
+// and NearDuplicate if enough find them at least near-duplicates.
+// Otherwise, or if the images fail a constraint, it is Distinct.
+func (p *Policy) Evaluate(a, b *Subject) (*PolicyDecision, error) {
+	rules := p.Rules
+	if len(rules) == 0 {
+		rules = []PolicyRule{{Algorithm: "average"}}
+	}
+
+	need := p.Agree
+	if need <= 0 || need > len(rules) {
+		need = len(rules)
+	}
+
+	d := &PolicyDecision{Decisions: []*Decision{}}
+
+	var dup, near int
+	for _, r := range rules {
+		ha, okA := a.Hashes[r.Algorithm]
+		hb, okB := b.Hashes[r.Algorithm]
+
+		// Unknown algorithms are errors, with hashes or without.
+		rd, err := Decide(ha, hb, &DecisionOptions{Algorithm: r.Algorithm, Thresholds: r.Thresholds})
+		if err != nil {
+			return nil, err
+		}
+
+		if !okA || !okB {
+			continue
+		}
+
+		d.Decisions = append(d.Decisions, rd)
+
+		switch rd.Verdict {
+		case Duplicate:
+			dup++
+			near++
+		case NearDuplicate:
+			near++
+		}
+	}
+
+	switch {
+	case dup >= need:
+		d.Verdict = Duplicate
+	case near >= need:
+		d.Verdict = NearDuplicate
+	}
+
+	for i, c := range p.Constraints {
+		if (a.Meta[c.Key] == b.Meta[c.Key]) != c.Same {
+			d.Verdict = Distinct
+			d.Violated = &p.Constraints[i]
+			break
+		}
+	}
+
+	return d, nil
+}
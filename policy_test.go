@@ -0,0 +1,83 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	a := &Subject{
+		Hashes: map[string]uint64{"average": 0x0838787c7c3e3c18, "document": 0xff00},
+		Meta:   map[string]string{"uploader": "ann", "site": "photos"},
+	}
+
+	b := &Subject{
+		Hashes: map[string]uint64{"average": 0x0838787c7c3e3c18 ^ 0x3f, "document": 0xff01},
+		Meta:   map[string]string{"uploader": "bob", "site": "photos"},
+	}
+
+	p := &Policy{
+		Rules: []PolicyRule{
+			{Algorithm: "average"},
+			{Algorithm: "document", Thresholds: Thresholds{Duplicate: 2}},
+		},
+		Constraints: []Constraint{{Key: "site", Same: true}, {Key: "uploader"}},
+	}
+
+	tests := []struct {
+		agree int
+		want  Verdict
+	}{
+		{0, NearDuplicate}, // Average finds a near-duplicate at 6, document a duplicate.
+		{1, Duplicate},
+		{2, NearDuplicate},
+	}
+
+	for _, tt := range tests {
+		p.Agree = tt.agree
+		d, err := p.Evaluate(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d.Verdict != tt.want || len(d.Decisions) != 2 || d.Violated != nil {
+			t.Fatalf("agree %d: %+v", tt.agree, d)
+		}
+	}
+
+	// The same uploader is no copy.
+	b.Meta["uploader"] = "ann"
+	d, _ := p.Evaluate(a, b)
+	if d.Verdict != Distinct || d.Violated == nil || d.Violated.Key != "uploader" {
+		t.Fatalf("constraint: %+v", d)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil || !strings.Contains(string(data), `"violated":{"key":"uploader","same":false}`) {
+		t.Fatalf("JSON %s: %v", data, err)
+	}
+
+	// Rules without both hashes can not agree.
+	b.Meta["uploader"] = "bob"
+	delete(b.Hashes, "document")
+	p.Agree = 0
+
+	if d, _ = p.Evaluate(a, b); d.Verdict != Distinct || len(d.Decisions) != 1 {
+		t.Fatalf("missing hash: %+v", d)
+	}
+
+	p.Rules = append(p.Rules, PolicyRule{Algorithm: "nosuch"})
+	if _, err = p.Evaluate(a, b); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("unknown algorithm: %v", err)
+	}
+
+	// No rules compare Average hashes.
+	if d, _ = (&Policy{}).Evaluate(a, a); d.Verdict != Duplicate || d.Decisions[0].Algorithm != "average" {
+		t.Fatalf("empty policy: %+v", d)
+	}
+}
@@ -0,0 +1,349 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package postgres stores hashes in a PostgreSQL table and finds them
+with radius queries.
+
+Hashes are stored as bigint. Postgres has no unsigned integers, so the
+64 bits are stored as is, and read back as a signed value. The Hamming
+Distance is computed in SQL as bit_count((hash # $1)::bit(64)), which
+requires Postgres 14 or newer:
+
+	db, err := sql.Open("pgx", "postgres://localhost/images")
+	...
+	store := postgres.New(db, nil)
+	err = store.CreateTable(ctx)
+	...
+	err = store.Insert(ctx, map[string]uint64{"a.jpg": hash})
+	...
+	results, err := store.Query(ctx, hash, 5)
+
+Without an index, every query compares against all rows. That is fast
+enough for a few million hashes. For more, install the bktree extension
+from https://github.com/fake-name/pg-spgist_hamming and set
+Options.BKTree, which indexes the hashes in a BK-tree.
+
+The package does not import a database driver; any driver supporting
+$1 style placeholders works. The generated SQL is available through
+Store.Schema, InsertSQL, QuerySQL and BatchQuerySQL, for use with other
+database tooling.
+*/
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"sort"
+	"strings"
+)
+
+// DefaultTable is the table name used if Options does not specify one.
+const DefaultTable = "imghash"
+
+// maxBatch is the maximum number of rows inserted, or hashes queried,
+// in a single statement. Postgres allows at most 65535 parameters.
+const maxBatch = 1000
+
+// Options configure a Store.
+type Options struct {
+	// Name of the table holding the hashes. Defaults to DefaultTable.
+	Table string
+
+	// Use the bktree extension to index the hashes. It must be
+	// installed on the server.
+	BKTree bool
+}
+
+// A Store reads and writes hashes in a Postgres table, which holds an
+// ID and a hash per row.
+type Store struct {
+	db     *sql.DB
+	table  string
+	bktree bool
+}
+
+// New creates a store for the given database. Opts may be nil,
+// to use the defaults.
+func New(db *sql.DB, opts *Options) *Store {
+	s := &Store{db: db, table: DefaultTable}
+
+	if opts != nil {
+		if len(opts.Table) > 0 {
+			s.table = opts.Table
+		}
+
+		s.bktree = opts.BKTree
+	}
+
+	return s
+}
+
+// Encode returns the value stored in the database for a hash.
+func Encode(hash uint64) int64 {
+	return int64(hash)
+}
+
+// Decode returns the hash for a value stored in the database.
+func Decode(v int64) uint64 {
+	return uint64(v)
+}
+
+// Schema returns the statements which create the table and its
+// indexes, if they do not exist yet.
+func (s *Store) Schema() []string {
+	table := quoteIdent(s.table)
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id text PRIMARY KEY, hash bigint NOT NULL)", table),
+	}
+
+	if s.bktree {
+		stmts = append(stmts,
+			"CREATE EXTENSION IF NOT EXISTS bktree",
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING spgist (hash bktree_ops)",
+				quoteIdent(s.table+"_hash_bktree"), table))
+	} else {
+		// Speeds up exact matches, and lookups of known hashes.
+		stmts = append(stmts,
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (hash)",
+				quoteIdent(s.table+"_hash"), table))
+	}
+
+	return stmts
+}
+
+// CreateTable runs the statements returned by Schema.
+func (s *Store) CreateTable(ctx context.Context) error {
+	for _, stmt := range s.Schema() {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InsertSQL returns a statement which inserts n rows, replacing the
+// hashes of existing IDs. It takes the ID and hash of each row as
+// parameters, in that order.
+func (s *Store) InsertSQL(n int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "INSERT INTO %s (id, hash) VALUES ", quoteIdent(s.table))
+
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "($%d, $%d)", 2*i+1, 2*i+2)
+	}
+
+	sb.WriteString(" ON CONFLICT (id) DO UPDATE SET hash = EXCLUDED.hash")
+	return sb.String()
+}
+
+// QuerySQL returns a query for all rows within a distance of a hash.
+// It takes the hash and the distance as parameters, and yields the ID,
+// hash and distance of each match, closest first.
+func (s *Store) QuerySQL() string {
+	return fmt.Sprintf("SELECT id, hash, %s AS distance FROM %s WHERE %s ORDER BY distance, id",
+		distanceSQL("hash", "$1"), quoteIdent(s.table), s.withinSQL("hash", "$1", "$2"))
+}
+
+// BatchQuerySQL returns a query for all rows within a distance of any
+// of n hashes. It takes the n hashes and the distance as parameters. It
+// yields the position of the query hash, counting from 0, followed by
+// the ID, hash and distance of each match.
+func (s *Store) BatchQuerySQL(n int) string {
+	var sb strings.Builder
+	sb.WriteString("SELECT q.n, t.id, t.hash, ")
+	sb.WriteString(distanceSQL("t.hash", "q.hash"))
+	sb.WriteString(" AS distance FROM (VALUES ")
+
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "(%d, $%d::bigint)", i, i+1)
+	}
+
+	fmt.Fprintf(&sb, ") AS q (n, hash) JOIN %s AS t ON %s ORDER BY q.n, distance, t.id",
+		quoteIdent(s.table), s.withinSQL("t.hash", "q.hash", fmt.Sprintf("$%d", n+1)))
+
+	return sb.String()
+}
+
+// distanceSQL returns an expression computing the Hamming
+// Distance between two bigint expressions.
+func distanceSQL(a, b string) string {
+	return fmt.Sprintf("bit_count((%s # %s)::bit(64))", a, b)
+}
+
+// withinSQL returns a condition which holds for hashes within
+// the given distance of another hash.
+func (s *Store) withinSQL(column, hash, distance string) string {
+	if s.bktree {
+		return fmt.Sprintf("%s <@ (%s, %s)", column, hash, distance)
+	}
+
+	return fmt.Sprintf("%s <= %s", distanceSQL(column, hash), distance)
+}
+
+// Insert stores the given hashes by ID. Hashes of existing IDs are
+// replaced. Rows are inserted in batches, in ID order.
+func (s *Store) Insert(ctx context.Context, hashes map[string]uint64) error {
+	ids := make([]string, 0, len(hashes))
+	for id := range hashes {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxBatch {
+			n = maxBatch
+		}
+
+		args := make([]interface{}, 0, 2*n)
+		for _, id := range ids[:n] {
+			args = append(args, id, Encode(hashes[id]))
+		}
+
+		if _, err := s.db.ExecContext(ctx, s.InsertSQL(n), args...); err != nil {
+			return err
+		}
+
+		ids = ids[n:]
+	}
+
+	return nil
+}
+
+// Add stores a single hash. It implements imghash.Store.
+func (s *Store) Add(ctx context.Context, id string, hash uint64) error {
+	return s.Insert(ctx, map[string]uint64{id: hash})
+}
+
+// Remove removes a single row. It implements imghash.Store.
+func (s *Store) Remove(ctx context.Context, id string) error {
+	return s.Delete(ctx, id)
+}
+
+// Delete removes the rows with the given IDs.
+func (s *Store) Delete(ctx context.Context, ids ...string) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxBatch {
+			n = maxBatch
+		}
+
+		var sb strings.Builder
+		args := make([]interface{}, n)
+
+		fmt.Fprintf(&sb, "DELETE FROM %s WHERE id IN (", quoteIdent(s.table))
+		for i, id := range ids[:n] {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "$%d", i+1)
+			args[i] = id
+		}
+		sb.WriteString(")")
+
+		if _, err := s.db.ExecContext(ctx, sb.String(), args...); err != nil {
+			return err
+		}
+
+		ids = ids[n:]
+	}
+
+	return nil
+}
+
+// Query returns all rows within the given distance of hash, closest
+// first. The Path of each result holds the ID.
+func (s *Store) Query(ctx context.Context, hash, distance uint64) (imghash.ResultSet, error) {
+	rows, err := s.db.QueryContext(ctx, s.QuerySQL(), Encode(hash), int64(distance))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var rs imghash.ResultSet
+	for rows.Next() {
+		var r imghash.SearchResult
+		var h, d int64
+
+		if err = rows.Scan(&r.Path, &h, &d); err != nil {
+			return nil, err
+		}
+
+		r.Hash, r.Distance = Decode(h), uint64(d)
+		rs = append(rs, &r)
+	}
+
+	return rs, rows.Err()
+}
+
+// QueryBatch runs a radius query for each of the given hashes, in as
+// few statements as possible. It returns a result set per hash.
+func (s *Store) QueryBatch(ctx context.Context, hashes []uint64, distance uint64) ([]imghash.ResultSet, error) {
+	out := make([]imghash.ResultSet, len(hashes))
+
+	for base := 0; base < len(hashes); base += maxBatch {
+		n := len(hashes) - base
+		if n > maxBatch {
+			n = maxBatch
+		}
+
+		args := make([]interface{}, 0, n+1)
+		for _, h := range hashes[base : base+n] {
+			args = append(args, Encode(h))
+		}
+
+		args = append(args, int64(distance))
+		if err := s.queryBatch(ctx, s.BatchQuerySQL(n), args, out[base:base+n]); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// queryBatch runs a single batch query, and sorts the
+// results into out by the position of their query hash.
+func (s *Store) queryBatch(ctx context.Context, query string, args []interface{}, out []imghash.ResultSet) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r imghash.SearchResult
+		var n int
+		var h, d int64
+
+		if err = rows.Scan(&n, &r.Path, &h, &d); err != nil {
+			return err
+		}
+
+		if n < 0 || n >= len(out) {
+			return fmt.Errorf("postgres: query index %d out of range", n)
+		}
+
+		r.Hash, r.Distance = Decode(h), uint64(d)
+		out[n] = append(out[n], &r)
+	}
+
+	return rows.Err()
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,151 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"strings"
+	"testing"
+)
+
+var _ imghash.Store = (*Store)(nil)
+
+func TestSQL(t *testing.T) {
+	s := New(nil, &Options{Table: `my"hashes`})
+
+	want := `INSERT INTO "my""hashes" (id, hash) VALUES ($1, $2), ($3, $4) ` +
+		`ON CONFLICT (id) DO UPDATE SET hash = EXCLUDED.hash`
+	if q := s.InsertSQL(2); q != want {
+		t.Fatalf("insert:\n%s\nwant:\n%s", q, want)
+	}
+
+	want = `SELECT id, hash, bit_count((hash # $1)::bit(64)) AS distance FROM "my""hashes" ` +
+		`WHERE bit_count((hash # $1)::bit(64)) <= $2 ORDER BY distance, id`
+	if q := s.QuerySQL(); q != want {
+		t.Fatalf("query:\n%s\nwant:\n%s", q, want)
+	}
+
+	s = New(nil, &Options{BKTree: true})
+
+	want = `SELECT q.n, t.id, t.hash, bit_count((t.hash # q.hash)::bit(64)) AS distance ` +
+		`FROM (VALUES (0, $1::bigint), (1, $2::bigint)) AS q (n, hash) ` +
+		`JOIN "imghash" AS t ON t.hash <@ (q.hash, $3) ORDER BY q.n, distance, t.id`
+	if q := s.BatchQuerySQL(2); q != want {
+		t.Fatalf("batch query:\n%s\nwant:\n%s", q, want)
+	}
+
+	if schema := strings.Join(s.Schema(), ";"); !strings.Contains(schema, "USING spgist (hash bktree_ops)") {
+		t.Fatalf("schema without bktree index: %s", schema)
+	}
+
+	if Decode(Encode(1<<63|5)) != 1<<63|5 {
+		t.Fatal("encoding round trip")
+	}
+}
+
+func TestStore(t *testing.T) {
+	var conn fakeConn
+	db := sql.OpenDB(&conn)
+	defer db.Close()
+
+	s := New(db, nil)
+	ctx := context.Background()
+
+	hashes := make(map[string]uint64)
+	for i := 0; i < maxBatch+1; i++ {
+		hashes[fmt.Sprintf("%04d", i)] = uint64(i)
+	}
+
+	if err := s.Insert(ctx, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conn.execs) != 2 || len(conn.args[0]) != 2*maxBatch || len(conn.args[1]) != 2 {
+		t.Fatalf("%d statements", len(conn.execs))
+	}
+
+	if conn.args[1][0] != "1000" || conn.args[1][1] != int64(1000) {
+		t.Fatalf("last row %v", conn.args[1])
+	}
+
+	// Results for the second query hash come first,
+	// to check they are sorted into place.
+	conn.rows = [][]driver.Value{
+		{int64(1), "b", int64(-1), int64(0)},
+		{int64(0), "a", int64(3), int64(1)},
+		{int64(1), "c", int64(-2), int64(1)},
+	}
+
+	rs, err := s.QueryBatch(ctx, []uint64{2, 1<<64 - 1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rs) != 2 || len(rs[0]) != 1 || len(rs[1]) != 2 {
+		t.Fatalf("unexpected results %v", rs)
+	}
+
+	if r := rs[1][0]; r.Path != "b" || r.Hash != 1<<64-1 || r.Distance != 0 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+
+	if args := conn.args[len(conn.args)-1]; args[1] != int64(-1) || args[2] != int64(1) {
+		t.Fatalf("query arguments %v", args)
+	}
+}
+
+// fakeConn is a database driver which records all statements,
+// and answers every query with the same rows.
+type fakeConn struct {
+	execs []string
+	args  [][]driver.Value
+	rows  [][]driver.Value
+}
+
+func (c *fakeConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *fakeConn) Driver() driver.Driver                        { return nil }
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error)    { return &fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                                 { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                    { return nil, driver.ErrSkip }
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.execs = append(s.c.execs, s.query)
+	s.c.args = append(s.c.args, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.c.args = append(s.c.args, args)
+	return &fakeRows{rows: s.c.rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"n", "id", "hash", "distance"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,25 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"image"
+)
+
+// A Filter transforms an image before it is hashed.
+type Filter func(image.Image) image.Image
+
+// Preprocess returns a HashFunc which runs the given filters over an
+// image, in order, before handing the result to hf.
+//
+// For example, to hash transparent images as if they were drawn on
+// a white background:
+//
+//	hf := Preprocess(Average, Composite(color.White))
+func Preprocess(hf HashFunc, filters ...Filter) HashFunc {
+	return func(img image.Image) uint64 {
+		return hf(ApplyFilters(context.Background(), img, filters...))
+	}
+}
@@ -0,0 +1,174 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestComposite(t *testing.T) {
+	// Two logos which differ only in what the encoder left
+	// behind in the colour channels of transparent pixels.
+	a := transparentLogo(color.NRGBA{0, 0, 0, 0})
+	b := transparentLogo(color.NRGBA{0xff, 0x10, 0x80, 0})
+
+	hf := Preprocess(Average, Composite(color.White))
+	ha, hb := hf(a), hf(b)
+
+	if ha != hb {
+		t.Fatalf("Hash mismatch: 0x%x 0x%x\n", ha, hb)
+	}
+
+	c := Composite(color.White)(a).At(0, 0)
+	if r, g, b, a := c.RGBA(); r != 0xffff || g != 0xffff || b != 0xffff || a != 0xffff {
+		t.Fatalf("Expected white background, got %v\n", c)
+	}
+}
+
+func TestIgnoreAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{0x80, 0x40, 0x20, 0})
+
+	r, g, b, a := IgnoreAlpha(img).At(0, 0).RGBA()
+	if r != 0x8080 || g != 0x4040 || b != 0x2020 || a != 0xffff {
+		t.Fatalf("Unexpected colour: %x %x %x %x\n", r, g, b, a)
+	}
+}
+
+func TestEqualize(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 64, 48))
+	b := image.NewGray(a.Rect)
+
+	var x, y int
+	var v float64
+	for y = 0; y < 48; y++ {
+		for x = 0; x < 64; x++ {
+			// A dull image, and a gamma-corrected copy of it.
+			v = float64(100+(x*7+y*y)%40) / 255
+			a.SetGray(x, y, color.Gray{uint8(v*255 + 0.5)})
+			b.SetGray(x, y, color.Gray{uint8(math.Sqrt(v)*255 + 0.5)})
+		}
+	}
+
+	hf := Preprocess(Average, Equalize)
+	ha, hb := hf(a), hf(b)
+
+	if ha != hb {
+		t.Fatalf("Hash mismatch: 0x%x 0x%x\n", ha, hb)
+	}
+
+	hf = Preprocess(Average, Stretch(0.01))
+	if hf(a) == 0 {
+		t.Fatalf("Stretch removed all detail\n")
+	}
+}
+
+func TestBlur(t *testing.T) {
+	img := image.NewPaletted(image.Rect(5, 5, 133, 133), palette.Plan9)
+	draw.FloydSteinberg.Draw(img, img.Rect, synth.Gradient(128, 128, 1), image.Point{})
+
+	if Blur(0)(img) != image.Image(img) {
+		t.Fatal("Blur(0) altered the image")
+	}
+
+	blurred := Blur(1)(img)
+	if blurred.Bounds() != img.Bounds() {
+		t.Fatalf("bounds %v, want %v", blurred.Bounds(), img.Bounds())
+	}
+
+	// A flat area stays flat.
+	flat := image.NewGray(image.Rect(0, 0, 8, 8))
+	draw.Draw(flat, flat.Rect, image.NewUniform(color.Gray{0x80}), image.Point{}, draw.Src)
+	if r, _, _, _ := Blur(2)(flat).At(0, 7).RGBA(); r != 0x8080 {
+		t.Fatalf("blurred flat grey to %x", r)
+	}
+
+	// Dithering the same image twice, with and without noise, gives
+	// patterns which only agree once blurred.
+	var plain, smooth uint64
+	for i := int64(0); i < 20; i++ {
+		src := attack.Noise(3, i).Apply(synth.Gradient(256, 256, i))
+		a := image.NewPaletted(src.Bounds(), palette.Plan9)
+		b := image.NewPaletted(src.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(a, a.Rect, src, image.Point{})
+		draw.FloydSteinberg.Draw(b, b.Rect, attack.JPEG(90).Apply(src), image.Point{})
+
+		hf := Screenshot(nil)
+		plain += Distance(hf(a), hf(b))
+		hf = Preprocess(hf, Blur(1))
+		smooth += Distance(hf(a), hf(b))
+	}
+
+	if smooth >= plain {
+		t.Fatalf("distance %d with blur, %d without", smooth, plain)
+	}
+}
+
+func TestAspect(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 20, 110, 70))
+	draw.Draw(img, img.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	sq := CenterCrop(img).Bounds()
+	if sq != image.Rect(35, 20, 85, 70) {
+		t.Fatalf("Unexpected crop: %v\n", sq)
+	}
+
+	pad := PadSquare(color.Black)(img)
+	if pad.Bounds() != image.Rect(0, 0, 100, 100) {
+		t.Fatalf("Unexpected padding: %v\n", pad.Bounds())
+	}
+
+	if r, _, _, _ := pad.At(0, 0).RGBA(); r != 0 {
+		t.Fatalf("Expected black padding\n")
+	}
+
+	if r, _, _, _ := pad.At(50, 50).RGBA(); r != 0xffff {
+		t.Fatalf("Expected the image in the centre\n")
+	}
+
+	// A copy with bars added on either side matches the
+	// original once cropped, but not when stretched.
+	a, err := loadImg("testdata/gopher_large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := image.NewRGBA(image.Rect(0, 0, 400, 250))
+	draw.Draw(b, image.Rect(75, 0, 325, 250), a, image.Point{}, draw.Src)
+
+	hf := Preprocess(Average, CenterCrop)
+	if dist := Distance(hf(a), hf(b)); dist > MaxDistance {
+		t.Fatalf("Hash mismatch: %d\n", dist)
+	}
+
+	if dist := Distance(Average(a), Average(b)); dist <= MaxDistance {
+		t.Fatalf("Expected stretched hashes to differ: %d\n", dist)
+	}
+}
+
+// transparentLogo creates a black square on a transparent background,
+// filling the transparent pixels with the given colour.
+func transparentLogo(fill color.NRGBA) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+
+	var x, y int
+	for y = 0; y < 64; y++ {
+		for x = 0; x < 64; x++ {
+			if x >= 16 && x < 48 && y >= 16 && y < 48 {
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 0xff})
+			} else {
+				img.SetNRGBA(x, y, fill)
+			}
+		}
+	}
+
+	return img
+}
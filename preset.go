@@ -0,0 +1,77 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "fmt"
+
+// A Preset is a ready-made choice of hash and preprocessing, for programs
+// which would rather not pick their own. All presets hash an 8x8 grid
+// into 64 bits, which indexes and stores hold, and share the thresholds
+// of Average. Each is registered as an algorithm, by the name String
+// returns, so "-a robust" or algorithm = "robust" select it as well.
+//
+// The figures below come from the eval package, on 60 images of the synth
+// corpus at 640x480, each paired with its 18 variants of attack.Standard
+// as duplicates, and with each of the others as distinct images. Recall
+// is the fraction of duplicate pairs within the near-duplicate threshold
+// of 9 bits; false positives the fraction of distinct pairs within it.
+// Times are per hash of a decoded image, on a single core.
+//
+//	preset    AUC    recall  false positives  time
+//	fastest   0.931  84.0%   4.1%             7 ms
+//	balanced  0.964  85.9%   4.0%             7 ms
+//	robust    0.962  86.8%   4.0%             63 ms
+//
+// Hashing time is spent mostly in the resize, which all three share. Run
+// "imghash eval" on pairs of your own images to see how they compare on
+// those.
+type Preset int
+
+// Known presets.
+const (
+	// Fastest is the plain Average hash. Use it where hashes need to
+	// match those of older indexes, or other programs computing it.
+	Fastest Preset = iota
+
+	// Balanced compares the cells against their median, weighting the
+	// centre of the image 4 times as much as its corners. It separates
+	// duplicates from distinct images better than Fastest, at about the
+	// same cost, and is the one to start with.
+	Balanced
+
+	// Robust is Balanced after stretching the contrast, clipping 1% of
+	// the pixels, and blurring by 2 pixels. That catches a few more of
+	// the noisy, recompressed and re-exposed copies, at close to ten
+	// times the cost. Beyond the threshold, it spreads duplicates further
+	// apart too, so by AUC it is no better than Balanced.
+	Robust
+
+	presetCount
+)
+
+var presetNames = [...]string{"fastest", "balanced", "robust"}
+
+func (p Preset) String() string {
+	if p < 0 || p >= presetCount {
+		return fmt.Sprintf("Preset(%d)", int(p))
+	}
+	return presetNames[p]
+}
+
+// Algorithm returns the hash and thresholds of the preset.
+func (p Preset) Algorithm() *Algorithm {
+	balanced := AverageWith(&AverageOptions{Median: true, CenterWeight: 4})
+
+	var hf HashFunc
+	switch p {
+	case Balanced:
+		hf = balanced
+	case Robust:
+		hf = Preprocess(balanced, Stretch(0.01), Blur(2))
+	default:
+		hf = Average
+	}
+
+	return &Algorithm{hf, AverageThresholds, AverageCapabilities}
+}
@@ -0,0 +1,44 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"math/bits"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	img := synth.Gradient(128, 96, 3)
+
+	for p := Preset(0); p < presetCount; p++ {
+		a, err := LookupAlgorithm(p.String())
+		if err != nil {
+			t.Fatalf("%v: %v", p, err)
+		}
+
+		if want := p.Algorithm().Hash(img); a.Hash(img) != want {
+			t.Fatalf("%v: registered hash differs", p)
+		}
+
+		if a.Thresholds != AverageThresholds {
+			t.Fatalf("%v: thresholds %v", p, a.Thresholds)
+		}
+	}
+
+	if Fastest.Algorithm().Hash(img) != Average(img) {
+		t.Fatal("fastest is not the average hash")
+	}
+
+	// Comparing against the median sets about half the bits.
+	for _, p := range []Preset{Balanced, Robust} {
+		if n := bits.OnesCount64(p.Algorithm().Hash(img)); n < 24 || n > 40 {
+			t.Fatalf("%v: %d bits set", p, n)
+		}
+	}
+
+	if s := Preset(7).String(); s != "Preset(7)" {
+		t.Fatalf("unknown preset %q", s)
+	}
+}
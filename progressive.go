@@ -0,0 +1,225 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrIncomplete is returned by ProgressiveHasher when it has not
+// received enough data to compute even a provisional hash.
+var ErrIncomplete = errors.New("imghash: not enough image data")
+
+// Confidence describes how much of an image a provisional hash is based on.
+type Confidence int
+
+// Known confidence levels.
+const (
+	// No hash is available yet.
+	NoConfidence Confidence = iota
+
+	// The hash is based on the DC coefficients of some, but not all,
+	// colour components. Typically, only the luminance is known.
+	LowConfidence
+
+	// The hash is based on the DC coefficients of all components, and
+	// possibly some AC coefficients. The DC coefficients hold the mean
+	// of every 8x8 block, so hashes working on a heavily downscaled
+	// image, like Average, rarely change beyond this point.
+	HighConfidence
+
+	// The image is complete.
+	FullConfidence
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case LowConfidence:
+		return "low"
+	case HighConfidence:
+		return "high"
+	case FullConfidence:
+		return "full"
+	}
+
+	return "none"
+}
+
+// A ProgressiveHasher computes provisional hashes of an image while
+// it is being downloaded. Data is written to it as it arrives, and Hash
+// can be called at any point.
+//
+// Progressive JPEGs are stored as a series of scans, which each refine
+// the whole image. After every complete scan, the data received so far
+// decodes to a blurred version of the image, whose hash is typically
+// close to the final one. This lets a crawler recognise known images
+// early, and abort the download. Other images yield a hash only once
+// they are complete.
+//
+// A ProgressiveHasher is not safe for concurrent use.
+type ProgressiveHasher struct {
+	hf   HashFunc
+	data []byte
+
+	// Parser state, up to the end of the last complete scan.
+	pos         int           // Offset at which parsing resumes.
+	cut         int           // End of the last complete scan.
+	progressive bool          // Whether the frame is progressive.
+	components  map[byte]bool // Components, and whether their DC is known.
+	done        bool          // End of image reached, or not a progressive JPEG.
+
+	// Result for the data up to cut.
+	hashed     int
+	hash       uint64
+	confidence Confidence
+	err        error
+}
+
+// NewProgressiveHasher creates a hasher, which hashes images
+// using the given HashFunc.
+func NewProgressiveHasher(hf HashFunc) *ProgressiveHasher {
+	return &ProgressiveHasher{hf: hf, components: make(map[byte]bool), err: ErrIncomplete}
+}
+
+// Write appends image data. It never fails.
+func (p *ProgressiveHasher) Write(data []byte) (int, error) {
+	p.data = append(p.data, data...)
+	return len(data), nil
+}
+
+// Hash returns a hash of the data written so far, and the confidence in
+// it. It returns ErrIncomplete if not enough data is available yet.
+func (p *ProgressiveHasher) Hash() (uint64, Confidence, error) {
+	if !p.done {
+		p.parse()
+	}
+
+	if p.done {
+		// The image is complete, or not a progressive JPEG.
+		// Either way, only the full data can be decoded.
+		if p.confidence != FullConfidence {
+			hash, err := ComputeBytes(p.data, p.hf)
+			if err == nil {
+				p.hash, p.confidence, p.err = hash, FullConfidence, nil
+			}
+		}
+
+		return p.hash, p.confidence, p.err
+	}
+
+	if p.cut > p.hashed {
+		hash, err := ComputeBytes(append(p.data[:p.cut:p.cut], 0xff, 0xd9), p.hf)
+		if err == nil {
+			p.hash, p.confidence, p.err = hash, p.scanConfidence(), nil
+		}
+
+		p.hashed = p.cut
+	}
+
+	return p.hash, p.confidence, p.err
+}
+
+// scanConfidence returns the confidence level for the scans seen so far.
+func (p *ProgressiveHasher) scanConfidence() Confidence {
+	for _, dc := range p.components {
+		if !dc {
+			return LowConfidence
+		}
+	}
+
+	return HighConfidence
+}
+
+// parse reads JPEG markers from where it last stopped, and records the
+// end of every scan it finds. A scan is complete once the marker which
+// follows it has been received. Parsing stops at the end of the data,
+// and resumes at the last complete segment or scan.
+func (p *ProgressiveHasher) parse() {
+	jr := &jpegReader{r: bufio.NewReader(bytes.NewReader(p.data[p.pos:])), pos: p.pos}
+
+	m, err := jr.marker()
+	if p.pos == 0 && err == nil {
+		if m != 0xd8 {
+			p.done = true
+			return
+		}
+
+		p.pos = jr.pos
+		m, err = jr.marker()
+	}
+
+	for err == nil {
+		switch {
+		case m == 0xd9:
+			p.done = true
+			return
+
+		case m >= 0xc0 && m <= 0xcf && m != 0xc4 && m != 0xc8 && m != 0xcc:
+			// Only progressive Huffman coded frames
+			// can be decoded scan by scan.
+			var seg []byte
+			if seg, err = jr.segment(); err != nil {
+				break
+			}
+
+			if m != 0xc2 || len(seg) < 6 {
+				p.done = true
+				return
+			}
+
+			var i int
+			for i = 0; i < int(seg[5]) && 8+3*i < len(seg); i++ {
+				p.components[seg[6+3*i]] = false
+			}
+
+			p.progressive = true
+			p.pos = jr.pos
+
+		case m == 0xda:
+			var seg []byte
+			if seg, err = jr.segment(); err != nil {
+				break
+			}
+
+			if !p.progressive || len(seg) == 0 || 1+2*int(seg[0]) >= len(seg) {
+				p.done = true
+				return
+			}
+
+			if m, err = jr.skipScan(); err != nil {
+				break
+			}
+
+			// Scans covering the DC coefficients start
+			// their spectral selection at zero.
+			if seg[1+2*int(seg[0])] == 0 {
+				var i int
+				for i = 0; i < int(seg[0]); i++ {
+					p.components[seg[1+2*i]] = true
+				}
+			}
+
+			// Resume at the marker following the scan.
+			p.cut = jr.pos - 2
+			p.pos = p.cut
+			continue
+
+		default:
+			if err = jr.skipSegment(); err != nil {
+				break
+			}
+
+			p.pos = jr.pos
+		}
+
+		m, err = jr.marker()
+	}
+
+	// Running out of data just means the rest has
+	// not arrived yet. Anything else is malformed.
+	p.done = err != io.EOF && err != io.ErrUnexpectedEOF
+}
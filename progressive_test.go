@@ -0,0 +1,71 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestProgressiveHasher(t *testing.T) {
+	img := blockPattern(3)
+	data := makeProgressiveJPEG(img.(*image.Gray))
+	want := Average(img)
+
+	// The DC scan ends at the DHT marker preceding the AC scan.
+	i := bytes.Index(data, []byte{0xff, 0xc4, 0x00, 0x14})
+
+	p := NewProgressiveHasher(Average)
+	p.Write(data[:i+1])
+
+	if _, c, err := p.Hash(); err != ErrIncomplete || c != NoConfidence {
+		t.Fatalf("before the first scan: %v, %v", c, err)
+	}
+
+	p.Write(data[i+1 : i+2])
+
+	hash, c, err := p.Hash()
+	if err != nil || c != HighConfidence || hash != want {
+		t.Fatalf("after the DC scan: %016x, %v, %v", hash, c, err)
+	}
+
+	// Feed the rest in small pieces.
+	for i += 2; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+
+		p.Write(data[i:end])
+		if _, c, err = p.Hash(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash, c, err = p.Hash()
+	if err != nil || c != FullConfidence || hash != want {
+		t.Fatalf("complete: %016x, %v, %v", hash, c, err)
+	}
+
+	// Baseline JPEGs only hash once they are complete.
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p = NewProgressiveHasher(Average)
+	p.Write(buf.Bytes()[:buf.Len()/2])
+
+	if _, c, err := p.Hash(); err != ErrIncomplete || c != NoConfidence {
+		t.Fatalf("partial baseline: %v, %v", c, err)
+	}
+
+	p.Write(buf.Bytes()[buf.Len()/2:])
+
+	if _, c, err := p.Hash(); err != nil || c != FullConfidence {
+		t.Fatalf("complete baseline: %v, %v", c, err)
+	}
+}
@@ -0,0 +1,283 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrProvenanceCycle is returned when linking an image to a parent
+// which was itself derived from the image.
+var ErrProvenanceCycle = errors.New("imghash: image would derive from itself")
+
+// Metadata keys under which records carry their provenance.
+const (
+	MetaParent     = "parent"     // ID of the record this one was derived from.
+	MetaDerivation = "derivation" // How, as a Derivation name.
+)
+
+// A Derivation tells how an image was derived from its parent.
+type Derivation int
+
+// Known derivations.
+const (
+	Derived        Derivation = iota // Derived in some other way.
+	DerivedCrop                      // A crop of the parent.
+	DerivedResize                    // A scaled copy of the parent.
+	DerivedFrame                     // A frame of a video or animation.
+	DerivedConvert                   // The parent in another format or quality.
+	DerivedEdit                      // The parent, retouched or filtered.
+	derivations
+)
+
+var derivationNames = [...]string{"derived", "crop", "resize", "frame", "convert", "edit"}
+
+func (d Derivation) String() string {
+	if d < 0 || d >= derivations {
+		return fmt.Sprintf("Derivation(%d)", int(d))
+	}
+	return derivationNames[d]
+}
+
+// ParseDerivation returns the derivation with the given name.
+func ParseDerivation(name string) (Derivation, error) {
+	for d, n := range derivationNames {
+		if n == name {
+			return Derivation(d), nil
+		}
+	}
+	return 0, fmt.Errorf("imghash: unknown derivation %q", name)
+}
+
+// SetParent records in the metadata of r that it was derived from the
+// record with the given ID, as AddRecord reads it back.
+func (r *Record) SetParent(parent string, how Derivation) {
+	if r.Meta == nil {
+		r.Meta = make(map[string]string)
+	}
+	r.Meta[MetaParent] = parent
+	r.Meta[MetaDerivation] = how.String()
+}
+
+// Parent returns the ID of the record r was derived from, and how, as
+// SetParent recorded them. It returns false if r is an original.
+// Unknown derivations read as Derived.
+func (r *Record) Parent() (string, Derivation, bool) {
+	parent, ok := r.Meta[MetaParent]
+	if !ok || len(parent) == 0 {
+		return "", 0, false
+	}
+
+	how, err := ParseDerivation(r.Meta[MetaDerivation])
+	if err != nil {
+		how = Derived
+	}
+
+	return parent, how, true
+}
+
+// A Relation tells how two images are related by their provenance.
+type Relation int
+
+// Known relations.
+const (
+	Independent Relation = iota // Neither was derived from what the other was.
+	Ancestor                    // The first was derived, in one or more steps, into the second.
+	Descendant                  // The first was derived from the second.
+	Kin                         // Both were derived from the same original.
+	relations
+)
+
+var relationNames = [...]string{"independent", "ancestor", "descendant", "kin"}
+
+func (r Relation) String() string {
+	if r < 0 || r >= relations {
+		return fmt.Sprintf("Relation(%d)", int(r))
+	}
+	return relationNames[r]
+}
+
+// A DerivationStep links an image to the parent it was derived from.
+type DerivationStep struct {
+	ID     string
+	Parent string
+	How    Derivation
+}
+
+// Provenance tracks which images were derived from which, so matches
+// between a crop or a thumbnail and its original can be told apart from
+// images which are alike but were made independently: the same scene
+// shot twice, or a copy uploaded by someone else. Asset management
+// systems keep the links in the metadata of their records, with
+// Record.SetParent, and load them with AddRecord.
+//
+// Every image has at most one parent; an image without one is an
+// original. It is not safe for concurrent use.
+type Provenance struct {
+	parents  map[string]DerivationStep
+	children map[string][]string
+}
+
+// NewProvenance returns an empty Provenance, in which every image is
+// an original.
+func NewProvenance() *Provenance {
+	return &Provenance{
+		parents:  make(map[string]DerivationStep),
+		children: make(map[string][]string),
+	}
+}
+
+// Link records that the image id was derived from parent, replacing
+// any parent it had. It fails with ErrProvenanceCycle if parent was
+// derived from id, or is id itself.
+func (p *Provenance) Link(id, parent string, how Derivation) error {
+	for a := parent; ; {
+		if a == id {
+			return ErrProvenanceCycle
+		}
+
+		s, ok := p.parents[a]
+		if !ok {
+			break
+		}
+		a = s.Parent
+	}
+
+	p.Unlink(id)
+	p.parents[id] = DerivationStep{id, parent, how}
+	p.children[parent] = append(p.children[parent], id)
+	return nil
+}
+
+// Unlink makes id an original. Images derived from it stay so.
+func (p *Provenance) Unlink(id string) {
+	s, ok := p.parents[id]
+	if !ok {
+		return
+	}
+
+	delete(p.parents, id)
+
+	siblings := p.children[s.Parent]
+	for i, c := range siblings {
+		if c == id {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+
+	if len(siblings) == 0 {
+		delete(p.children, s.Parent)
+	} else {
+		p.children[s.Parent] = siblings
+	}
+}
+
+// AddRecord links r to the parent recorded in its metadata, if it has
+// one. It makes r an original otherwise.
+func (p *Provenance) AddRecord(r Record) error {
+	parent, how, ok := r.Parent()
+	if !ok {
+		p.Unlink(r.ID)
+		return nil
+	}
+	return p.Link(r.ID, parent, how)
+}
+
+// Parent returns the image id was derived from, and how. It returns
+// false if id is an original.
+func (p *Provenance) Parent(id string) (string, Derivation, bool) {
+	s, ok := p.parents[id]
+	return s.Parent, s.How, ok
+}
+
+// Chain returns the steps by which id was derived from its original,
+// from id itself back to the original. It is empty for originals.
+func (p *Provenance) Chain(id string) []DerivationStep {
+	var chain []DerivationStep
+	for s, ok := p.parents[id]; ok; s, ok = p.parents[s.Parent] {
+		chain = append(chain, s)
+	}
+	return chain
+}
+
+// Origin returns the original id was derived from, in one or more
+// steps, or id itself if it is an original.
+func (p *Provenance) Origin(id string) string {
+	for s, ok := p.parents[id]; ok; s, ok = p.parents[s.Parent] {
+		id = s.Parent
+	}
+	return id
+}
+
+// Descendants returns all images derived from id, in one or more
+// steps, sorted by ID.
+func (p *Provenance) Descendants(id string) []string {
+	var out []string
+	queue := append([]string(nil), p.children[id]...)
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		out = append(out, c)
+		queue = append(queue, p.children[c]...)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// Relate returns how image a is related to image b. An image is kin
+// of itself.
+func (p *Provenance) Relate(a, b string) Relation {
+	switch {
+	case a == b:
+		return Kin
+	case p.derives(a, b):
+		return Ancestor
+	case p.derives(b, a):
+		return Descendant
+	case p.Origin(a) == p.Origin(b):
+		return Kin
+	}
+	return Independent
+}
+
+// derives returns true if b was derived from a, in one or more steps.
+func (p *Provenance) derives(a, b string) bool {
+	for s, ok := p.parents[b]; ok; s, ok = p.parents[s.Parent] {
+		if s.Parent == a {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitGroup splits a group of matching images, as returned by
+// DedupeFiles, by their originals. Each part holds the images derived
+// from one original, in the order of the group: derived assets. Images
+// in different parts are independent duplicates. Parts with a single
+// image are included, and the parts are in the order of their first
+// images.
+func (p *Provenance) SplitGroup(group []*Entry) [][]*Entry {
+	var parts [][]*Entry
+	index := make(map[string]int)
+
+	for _, e := range group {
+		origin := p.Origin(e.Path)
+
+		i, ok := index[origin]
+		if !ok {
+			i = len(parts)
+			index[origin] = i
+			parts = append(parts, nil)
+		}
+
+		parts[i] = append(parts[i], e)
+	}
+
+	return parts
+}
@@ -0,0 +1,94 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	p := NewProvenance()
+	for _, l := range []DerivationStep{
+		{"thumb.jpg", "crop.jpg", DerivedResize},
+		{"crop.jpg", "photo.raw", DerivedCrop},
+		{"web.jpg", "photo.raw", DerivedConvert},
+		{"frame.png", "clip.mp4", DerivedFrame},
+	} {
+		if err := p.Link(l.ID, l.Parent, l.How); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Link("photo.raw", "thumb.jpg", Derived); err != ErrProvenanceCycle {
+		t.Fatalf("cycle linked: %v", err)
+	}
+
+	want := []DerivationStep{{"thumb.jpg", "crop.jpg", DerivedResize}, {"crop.jpg", "photo.raw", DerivedCrop}}
+	if c := p.Chain("thumb.jpg"); !reflect.DeepEqual(c, want) {
+		t.Fatalf("chain %v", c)
+	}
+
+	if o := p.Origin("thumb.jpg"); o != "photo.raw" {
+		t.Fatalf("origin %s", o)
+	}
+
+	if d := p.Descendants("photo.raw"); !reflect.DeepEqual(d, []string{"crop.jpg", "thumb.jpg", "web.jpg"}) {
+		t.Fatalf("descendants %v", d)
+	}
+
+	for _, c := range []struct {
+		a, b string
+		want Relation
+	}{
+		{"photo.raw", "thumb.jpg", Ancestor},
+		{"thumb.jpg", "photo.raw", Descendant},
+		{"thumb.jpg", "web.jpg", Kin},
+		{"web.jpg", "frame.png", Independent},
+		{"other.jpg", "web.jpg", Independent},
+	} {
+		if r := p.Relate(c.a, c.b); r != c.want {
+			t.Errorf("%s, %s: %s, want %s", c.a, c.b, r, c.want)
+		}
+	}
+
+	group := []*Entry{{Path: "web.jpg"}, {Path: "upload.jpg"}, {Path: "thumb.jpg"}}
+	parts := p.SplitGroup(group)
+	if len(parts) != 2 || len(parts[0]) != 2 || parts[0][1].Path != "thumb.jpg" || parts[1][0].Path != "upload.jpg" {
+		t.Fatalf("parts %v", parts)
+	}
+
+	// Moving crop.jpg moves what was derived from it along.
+	p.Unlink("crop.jpg")
+	if p.Origin("thumb.jpg") != "crop.jpg" || p.Relate("thumb.jpg", "web.jpg") != Independent {
+		t.Fatal("unlinked crop still derived from the photo")
+	}
+}
+
+func TestProvenanceRecords(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryHashStore()
+
+	r := Record{ID: "crop.jpg", Hash: 1}
+	r.SetParent("photo.jpg", DerivedCrop)
+	s.Put(ctx, r, 0)
+	s.Put(ctx, Record{ID: "photo.jpg", Hash: 3, Meta: map[string]string{"owner": "me"}}, 0)
+
+	p := NewProvenance()
+	hits, _ := s.Query(ctx, 0, 64)
+	for _, h := range hits {
+		if err := p.AddRecord(h.Record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if parent, how, ok := p.Parent("crop.jpg"); !ok || parent != "photo.jpg" || how != DerivedCrop {
+		t.Fatalf("parent %q, %s, %v", parent, how, ok)
+	}
+
+	if _, _, ok := p.Parent("photo.jpg"); ok {
+		t.Fatal("original has a parent")
+	}
+}
@@ -0,0 +1,119 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"sync"
+)
+
+// pyramidSize is the size of the base of an image pyramid. Grids whose
+// sides divide it are derived from the base, rather than the image.
+const pyramidSize = 32
+
+// A pyramid caches an image, scaled down to grids of 32x32, 16x16, 8x8
+// and so on, while several hashes of it are computed. Each is derived
+// from the sums of the base, of which its cells cover whole blocks, so
+// it is exactly what resize computes from the image itself.
+type pyramid struct {
+	img  image.Image
+	once sync.Once
+	base []uint64 // Sums of the base, as resizeSums returns them.
+	n    uint64
+
+	mu     sync.Mutex
+	levels map[image.Point][]uint64
+}
+
+// A pyramidImage is an image being hashed by MultiHasher, along with
+// its pyramid. resize reads its grids from the pyramid. Everything else
+// sees the image it wraps: its pixels through the methods it embeds, and
+// its type through plain.
+type pyramidImage struct {
+	image.Image
+	p *pyramid
+}
+
+// withPyramid returns img, wrapped with a pyramid for resize to read
+// from, for as long as the wrapper is used.
+func withPyramid(img image.Image) image.Image {
+	if img == nil {
+		return nil
+	}
+
+	if _, ok := img.(*pyramidImage); ok {
+		return img
+	}
+
+	return &pyramidImage{Image: img, p: &pyramid{img: img}}
+}
+
+// pyramidOf returns the image m wraps and its pyramid, or m itself and
+// nil if it has none.
+func pyramidOf(m image.Image) (image.Image, *pyramid) {
+	pi, ok := m.(*pyramidImage)
+	if !ok {
+		return m, nil
+	}
+
+	if generic.Load() {
+		return pi.Image, nil
+	}
+
+	return pi.Image, pi.p
+}
+
+// plain returns the image m wraps, if it has a pyramid, and m otherwise.
+// Code which treats some types of images differently calls it first.
+func plain(m image.Image) image.Image {
+	if pi, ok := m.(*pyramidImage); ok {
+		return pi.Image
+	}
+	return m
+}
+
+// sums returns the sums of the w by h level, as resizeSums returns them
+// for the image. Both sides must divide pyramidSize.
+func (p *pyramid) sums(w, h int) ([]uint64, uint64) {
+	p.once.Do(func() {
+		p.base, p.n = resizeSums(p.img, pyramidSize, pyramidSize)
+	})
+
+	if w == pyramidSize && h == pyramidSize {
+		return p.base, p.n
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	size := image.Pt(w, h)
+	if sum, ok := p.levels[size]; ok {
+		return sum, p.n
+	}
+
+	// Each cell of the level covers fx by fy cells of the base. Their
+	// overlap with every source pixel is that many times as large.
+	fx, fy := pyramidSize/w, pyramidSize/h
+	sum := make([]uint64, 4*w*h)
+
+	var x, y, c int
+	for y = 0; y < pyramidSize; y++ {
+		for x = 0; x < pyramidSize; x++ {
+			for c = 0; c < 4; c++ {
+				sum[4*((y/fy)*w+x/fx)+c] += p.base[4*(y*pyramidSize+x)+c]
+			}
+		}
+	}
+
+	for c = range sum {
+		sum[c] /= uint64(fx * fy)
+	}
+
+	if p.levels == nil {
+		p.levels = make(map[image.Point][]uint64)
+	}
+
+	p.levels[size] = sum
+	return sum, p.n
+}
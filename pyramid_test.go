@@ -0,0 +1,110 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+func TestPyramid(t *testing.T) {
+	src := synth.Shapes(61, 47, 2)
+
+	rgba := image.NewRGBA(src.Bounds())
+	draw.Draw(rgba, rgba.Rect, src, image.Point{}, draw.Src)
+
+	ycc := image.NewYCbCr(image.Rect(3, 5, 64, 52), image.YCbCrSubsampleRatio420)
+	for i := range ycc.Y {
+		ycc.Y[i] = uint8(i * 7)
+	}
+	for i := range ycc.Cb {
+		ycc.Cb[i], ycc.Cr[i] = uint8(i*3), uint8(255-i)
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"rgba", rgba},
+		{"ycbcr", ycc},
+		{"gray", checkerboard(image.Rect(0, 0, 500, 333), 13)},
+		{"tiny", checkerboard(image.Rect(0, 0, 5, 3), 1)},
+		{"generic", imageFunc(rgba.Rect, func(x, y int) color.Color { return rgba.At(x, y) })},
+	}
+
+	sizes := []int{1, 2, 4, 8, 16, 32}
+
+	for _, tt := range tests {
+		for _, w := range sizes {
+			for _, h := range sizes {
+				want := resize(tt.img, w, h)
+
+				got := resize(withPyramid(tt.img), w, h)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("%s: %dx%d level differs from resized copy", tt.name, w, h)
+				}
+			}
+		}
+
+		var hfs []HashFunc
+		for _, name := range Algorithms() {
+			a, err := LookupAlgorithm(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hfs = append(hfs, a.Hash)
+		}
+
+		m := MultiHasher(hfs...)(tt.img)
+		for i, hf := range hfs {
+			if want := hf(tt.img); m[i] != want {
+				t.Fatalf("%s: hash %d is %016x, want %016x", tt.name, i, m[i], want)
+			}
+		}
+	}
+}
+
+// grayRows is an image held in a slice, so its values can not be
+// map keys.
+type grayRows [][]uint8
+
+func (g grayRows) ColorModel() color.Model { return color.GrayModel }
+func (g grayRows) Bounds() image.Rectangle { return image.Rect(0, 0, len(g[0]), len(g)) }
+func (g grayRows) At(x, y int) color.Color { return color.Gray{g[y][x]} }
+
+func TestPyramidUnhashable(t *testing.T) {
+	rows := make(grayRows, 40)
+	for y := range rows {
+		rows[y] = make([]uint8, 50)
+		for x := range rows[y] {
+			rows[y][x] = uint8(x * y)
+		}
+	}
+
+	// A struct holding such an image is comparable, but not hashable.
+	img := struct{ image.Image }{rows}
+	want := Average(rows)
+
+	if got := Average(img); got != want {
+		t.Fatalf("hash %016x, want %016x", got, want)
+	}
+
+	if m := MultiHasher(Average, Average)(img); m[0] != want || m[1] != want {
+		t.Fatalf("multi-hash %v, want %016x", m, want)
+	}
+}
+
+func BenchmarkMultiHasher(b *testing.B) {
+	imgs := synth.Corpus(60, nil, 1)
+	mh := MultiHasher(Average, AverageWith(&AverageOptions{Trim: 0.1}), AverageWith(&AverageOptions{Median: true}))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		mh(imgs[i%len(imgs)])
+	}
+}
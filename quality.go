@@ -0,0 +1,43 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "image"
+
+// Quality rates how much detail an image holds, on a scale of 0 to 100,
+// the way PDQ does. Near-blank images -- flat colours, faint gradients --
+// score low. Their hashes are dominated by noise and compression
+// artefacts, and match all kinds of unrelated images. Downstream systems
+// should treat them with suspicion, or not match them at all. PDQ
+// considers hashes of images scoring under 50 unreliable.
+//
+// The score is the sum of the differences between neighbouring pixels
+// in a 64x64 grayscale version of the image, in percent of the full
+// range, divided by 90 and capped at 100.
+func Quality(img image.Image) int {
+	img = resize(img, 64, 64)
+	cells := gridValues(grayscale(img))
+
+	var x, y, sum int
+	diff := func(a, b uint32) int {
+		d := (int(a) - int(b)) * 100 / 0xffff
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+
+	for y = 0; y < 64; y++ {
+		for x = 0; x < 63; x++ {
+			sum += diff(cells[64*y+x], cells[64*y+x+1])
+			sum += diff(cells[64*x+y], cells[64*(x+1)+y])
+		}
+	}
+
+	if sum/90 > 100 {
+		return 100
+	}
+
+	return sum / 90
+}
@@ -0,0 +1,26 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"testing"
+)
+
+func TestQuality(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		gen      synth.Generator
+		min, max int
+	}{
+		{"flat", synth.Flat, 0, 0},
+		{"checker", synth.Checker, 50, 100},
+		{"noise", synth.Noise, 100, 100},
+	} {
+		q := Quality(tc.gen(256, 256, 1))
+		if q < tc.min || q > tc.max {
+			t.Errorf("%s: quality %d, want %d-%d", tc.name, q, tc.min, tc.max)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrInvalidVectors is returned for vectors of the wrong length, and when
+// decoding malformed PackedVectors.
+var ErrInvalidVectors = errors.New("imghash: invalid packed vectors")
+
+// A Quantizer maps the values of feature vectors, like those of a
+// FeatureFunc, to 8 bits each: linearly from Min to Max onto 0 to 255.
+// Values outside of the range are clamped.
+type Quantizer struct {
+	Min, Max float64
+}
+
+// CalibrateQuantizer returns the Quantizer whose range covers the values
+// of the sample vectors, less the given fraction of outliers at either
+// end. Clipping a few outliers spends the 256 levels on the values which
+// are common, at the cost of flattening the rare extremes.
+func CalibrateQuantizer(samples [][]float64, clip float64) Quantizer {
+	var values []float64
+	for _, v := range samples {
+		values = append(values, v...)
+	}
+
+	if len(values) == 0 {
+		return Quantizer{0, 1}
+	}
+
+	sort.Float64s(values)
+	clip = math.Max(0, math.Min(clip, 0.5))
+	lo := int(clip * float64(len(values)-1))
+
+	q := Quantizer{values[lo], values[len(values)-1-lo]}
+	if q.Max <= q.Min {
+		q.Max = q.Min + 1
+	}
+
+	return q
+}
+
+// step returns the difference in value between adjacent levels.
+func (q Quantizer) step() float64 {
+	return (q.Max - q.Min) / 255
+}
+
+// Quantize returns the vector at 8 bits per value.
+func (q Quantizer) Quantize(v []float64) []byte {
+	out := make([]byte, len(v))
+	q.quantize(out, v)
+	return out
+}
+
+// quantize writes the levels of v to out.
+func (q Quantizer) quantize(out []byte, v []float64) {
+	step := q.step()
+	for i, f := range v {
+		out[i] = byte(math.Round(math.Max(0, math.Min((f-q.Min)/step, 255))))
+	}
+}
+
+// Dequantize returns the values of a vector returned by Quantize. Each
+// is within half a step, (Max-Min)/510, of the value it was quantized
+// from, unless that was clamped.
+func (q Quantizer) Dequantize(b []byte) []float64 {
+	step := q.step()
+	out := make([]float64, len(b))
+	for i, l := range b {
+		out[i] = q.Min + float64(l)*step
+	}
+	return out
+}
+
+// Distance returns the approximate Euclidean distance between the
+// quantized vectors a and b, in the units of the original values.
+func (q Quantizer) Distance(a, b []byte) float64 {
+	if len(a) != len(b) {
+		return math.Inf(1)
+	}
+
+	var sum int64
+	for i := range a {
+		d := int64(a[i]) - int64(b[i])
+		sum += d * d
+	}
+
+	return math.Sqrt(float64(sum)) * q.step()
+}
+
+// QuantizationRecall measures how well q keeps the ranking of the sample
+// vectors. Each sample is taken as a query against all others, and their
+// k nearest by the approximate distance to the query are compared with
+// the k nearest by the exact distance. It returns the fraction of the
+// exact neighbours which are found, from 0 to 1.
+//
+// Use it on a sample of the corpus, to pick the clip of CalibrateQuantizer,
+// or to tell whether 8 bits per value suffice for it at all.
+func QuantizationRecall(q Quantizer, samples [][]float64, k int) float64 {
+	if k > len(samples)-1 {
+		k = len(samples) - 1
+	}
+
+	if k <= 0 {
+		return 1
+	}
+
+	packed := NewPackedVectors(q, len(samples[0]))
+	for _, v := range samples {
+		if _, err := packed.Add(v); err != nil {
+			return 0
+		}
+	}
+
+	var found int
+	exact := make([]float64, len(samples))
+
+	for i, v := range samples {
+		for j, o := range samples {
+			exact[j] = euclidean(v, o)
+		}
+
+		want := make(map[int]bool, k)
+		for _, j := range nearest(len(samples), k+1, func(j int) float64 { return exact[j] }) {
+			if j != i && len(want) < k {
+				want[j] = true
+			}
+		}
+
+		approx := nearest(len(samples), k+1, func(j int) float64 { return packed.Distance(j, v) })
+		n := 0
+		for _, j := range approx {
+			if j != i && n < k {
+				if want[j] {
+					found++
+				}
+				n++
+			}
+		}
+	}
+
+	return float64(found) / float64(k*len(samples))
+}
+
+// nearest returns the indices of the k smallest of n distances, in
+// order. Ties go to the lowest index.
+func nearest(n, k int, dist func(int) float64) []int {
+	idx := make([]int, n)
+	d := make([]float64, n)
+	for i := range idx {
+		idx[i], d[i] = i, dist(i)
+	}
+
+	sort.SliceStable(idx, func(a, b int) bool { return d[idx[a]] < d[idx[b]] })
+
+	if k < n {
+		idx = idx[:k]
+	}
+
+	return idx
+}
+
+// euclidean returns the Euclidean distance between two vectors.
+func euclidean(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// PackedVectors stores feature vectors of a single length at 8 bits per
+// value, back to back in one slice. That takes a quarter of the memory
+// of float32 vectors, and an eighth of that of []float64, without a
+// slice header per vector. Vectors are numbered in the order they were
+// added in.
+type PackedVectors struct {
+	Quantizer Quantizer
+	Dim       int // Length of each vector.
+	data      []byte
+}
+
+// NewPackedVectors returns an empty store of vectors of length dim.
+func NewPackedVectors(q Quantizer, dim int) *PackedVectors {
+	return &PackedVectors{Quantizer: q, Dim: dim}
+}
+
+// Len returns the number of vectors stored.
+func (p *PackedVectors) Len() int {
+	if p.Dim == 0 {
+		return 0
+	}
+	return len(p.data) / p.Dim
+}
+
+// Add quantizes and stores v, and returns its number. It returns
+// ErrInvalidVectors if v has the wrong length.
+func (p *PackedVectors) Add(v []float64) (int, error) {
+	if len(v) != p.Dim || p.Dim == 0 {
+		return 0, ErrInvalidVectors
+	}
+
+	n := len(p.data)
+	p.data = append(p.data, make([]byte, p.Dim)...)
+	p.Quantizer.quantize(p.data[n:], v)
+	return n / p.Dim, nil
+}
+
+// Quantized returns the levels of vector i, as Quantize returns them.
+// The slice shares its memory with the store.
+func (p *PackedVectors) Quantized(i int) []byte {
+	return p.data[i*p.Dim : (i+1)*p.Dim : (i+1)*p.Dim]
+}
+
+// At returns the approximate values of vector i.
+func (p *PackedVectors) At(i int) []float64 {
+	return p.Quantizer.Dequantize(p.Quantized(i))
+}
+
+// Distance returns the approximate Euclidean distance between vector
+// i and v. Only the stored vector is quantized, which ranks nearest
+// neighbours better than quantizing v as well. For vectors of unit
+// length, like those of GridFeatures, Euclidean distance ranks as
+// cosine similarity does.
+func (p *PackedVectors) Distance(i int, v []float64) float64 {
+	if len(v) != p.Dim {
+		return math.Inf(1)
+	}
+
+	q := p.Quantizer
+	step := q.step()
+
+	var sum float64
+	for j, l := range p.Quantized(i) {
+		d := q.Min + float64(l)*step - v[j]
+		sum += d * d
+	}
+
+	return math.Sqrt(sum)
+}
+
+// Nearest returns the numbers of the k vectors nearest to v, nearest
+// first, by the approximate distance of Distance.
+func (p *PackedVectors) Nearest(v []float64, k int) []int {
+	return nearest(p.Len(), k, func(i int) float64 { return p.Distance(i, v) })
+}
+
+// MarshalBinary encodes the quantizer, the length of the vectors and
+// their levels.
+func (p *PackedVectors) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 20, 20+len(p.data))
+	binary.BigEndian.PutUint64(data, math.Float64bits(p.Quantizer.Min))
+	binary.BigEndian.PutUint64(data[8:], math.Float64bits(p.Quantizer.Max))
+	binary.BigEndian.PutUint32(data[16:], uint32(p.Dim))
+	return append(data, p.data...), nil
+}
+
+// UnmarshalBinary decodes vectors written by MarshalBinary.
+func (p *PackedVectors) UnmarshalBinary(data []byte) error {
+	if len(data) < 20 {
+		return ErrInvalidVectors
+	}
+
+	dim := int(binary.BigEndian.Uint32(data[16:]))
+	if (dim == 0 && len(data) > 20) || (dim > 0 && (len(data)-20)%dim != 0) {
+		return ErrInvalidVectors
+	}
+
+	p.Quantizer.Min = math.Float64frombits(binary.BigEndian.Uint64(data))
+	p.Quantizer.Max = math.Float64frombits(binary.BigEndian.Uint64(data[8:]))
+	p.Dim = dim
+	p.data = append([]byte(nil), data[20:]...)
+	return nil
+}
@@ -0,0 +1,71 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPackedVectors(t *testing.T) {
+	var samples [][]float64
+	for _, img := range synth.Corpus(120, []image.Point{{64, 48}}, 1) {
+		samples = append(samples, GridFeatures(img))
+	}
+
+	q := CalibrateQuantizer(samples, 0.001)
+	if q.Min >= 0 || q.Max <= 0 {
+		t.Fatalf("range %v", q)
+	}
+
+	p := NewPackedVectors(q, 64)
+	for i, v := range samples {
+		if n, err := p.Add(v); err != nil || n != i {
+			t.Fatalf("add %d: %d, %v", i, n, err)
+		}
+	}
+
+	if p.Len() != len(samples) {
+		t.Fatalf("len %d", p.Len())
+	}
+
+	// Values in range come back within half a step.
+	for i, v := range samples {
+		for j, f := range p.At(i) {
+			if v[j] >= q.Min && v[j] <= q.Max && math.Abs(f-v[j]) > (q.Max-q.Min)/510+1e-12 {
+				t.Fatalf("vector %d, value %d: %v, want %v", i, j, f, v[j])
+			}
+		}
+	}
+
+	// Each vector is nearest to itself, or to one about as near; the
+	// corpus holds flat images, whose features are all the same.
+	for i, v := range samples {
+		n := p.Nearest(v, 1)[0]
+		if d := euclidean(v, samples[n]); d > 8*(q.Max-q.Min)/255 {
+			t.Fatalf("vector %d: nearest %d, at %v", i, n, d)
+		}
+	}
+
+	if r := QuantizationRecall(q, samples, 5); r < 0.95 {
+		t.Fatalf("recall %.3f", r)
+	}
+
+	if _, err := p.Add(samples[0][:10]); err != ErrInvalidVectors {
+		t.Fatalf("short vector: %v", err)
+	}
+
+	data, _ := p.MarshalBinary()
+	var o PackedVectors
+	if err := o.UnmarshalBinary(data); err != nil || !reflect.DeepEqual(&o, p) {
+		t.Fatalf("round trip: %v", err)
+	}
+
+	if o.UnmarshalBinary(data[:len(data)-1]) != ErrInvalidVectors {
+		t.Fatal("truncated vectors accepted")
+	}
+}
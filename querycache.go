@@ -0,0 +1,255 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheOptions configure a CachedStore.
+type CacheOptions struct {
+	// Number of queries to keep the results of. Defaults to 1024.
+	Size int
+
+	// How long results are kept. 0 keeps them until they are evicted,
+	// or a change to the store makes them stale.
+	MaxAge time.Duration
+}
+
+// A CachedStore is a Store which keeps the results of recent queries,
+// so a query repeated with the same hash and distance, as crawlers do
+// when they come across an image again, is answered without walking
+// the store again. The least recently used results are evicted first.
+//
+// Changes made through the CachedStore drop the cached results they
+// affect: those within distance of an added hash, and those which hold
+// the ID added or removed. Changes made to the underlying store by
+// other means, as by other processes sharing a database, are not seen
+// until the results expire; set a MaxAge for such stores.
+type CachedStore struct {
+	store Store
+	size  int
+	age   time.Duration
+
+	mu           sync.Mutex
+	entries      map[queryKey]*list.Element
+	lru          list.List // Of *queryEntry, most recently used first.
+	hits, misses uint64
+	changes      uint64 // Number of changes made; see Query.
+}
+
+// queryKey identifies a query.
+type queryKey struct {
+	hash, distance uint64
+}
+
+// queryEntry holds the results of a query.
+type queryEntry struct {
+	key     queryKey
+	results ResultSet
+	expires time.Time
+}
+
+// NewCachedStore returns a store which makes its changes to s, and
+// caches the results of queries to it. Opts may be nil, to use the
+// defaults.
+func NewCachedStore(s Store, opts *CacheOptions) *CachedStore {
+	c := &CachedStore{
+		store:   s,
+		size:    1024,
+		entries: make(map[queryKey]*list.Element),
+	}
+
+	if opts != nil {
+		if opts.Size > 0 {
+			c.size = opts.Size
+		}
+		c.age = opts.MaxAge
+	}
+
+	return c
+}
+
+// Add adds the hash, and drops the cached results it changes.
+func (c *CachedStore) Add(ctx context.Context, id string, hash uint64) error {
+	err := c.store.Add(ctx, id, hash)
+	c.invalidate(func(e *queryEntry) bool {
+		return Distance(e.key.hash, hash) <= e.key.distance || e.results.has(id)
+	})
+	return err
+}
+
+// Remove removes the ID, and drops the cached results which hold it.
+func (c *CachedStore) Remove(ctx context.Context, id string) error {
+	err := c.store.Remove(ctx, id)
+	c.invalidate(func(e *queryEntry) bool {
+		return e.results.has(id)
+	})
+	return err
+}
+
+// Query returns the cached results of the query, if there are any, and
+// queries the underlying store otherwise. Errors are not cached.
+func (c *CachedStore) Query(ctx context.Context, hash, distance uint64) (ResultSet, error) {
+	key := queryKey{hash, distance}
+	now := time.Now()
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*queryEntry)
+		if e.expires.IsZero() || now.Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.hits++
+			rs := e.results.clone()
+			c.mu.Unlock()
+			return rs, nil
+		}
+
+		c.remove(el)
+	}
+	c.misses++
+	changes := c.changes
+	c.mu.Unlock()
+
+	rs, err := c.store.Query(ctx, hash, distance)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &queryEntry{key: key, results: rs.clone()}
+	if c.age > 0 {
+		e.expires = now.Add(c.age)
+	}
+
+	// Results which a change made during the query may have missed are
+	// not cached.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.changes != changes {
+		return rs, nil
+	}
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+
+	return rs, nil
+}
+
+// Count returns the number of cached results of the query, if there are
+// any, and counts them in the underlying store otherwise, as CountStore
+// does. Counts are not cached.
+func (c *CachedStore) Count(ctx context.Context, hash, distance uint64) (int, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[queryKey{hash, distance}]; ok {
+		e := el.Value.(*queryEntry)
+		if e.expires.IsZero() || time.Now().Before(e.expires) {
+			n := len(e.results)
+			c.mu.Unlock()
+			return n, nil
+		}
+	}
+	c.mu.Unlock()
+
+	return CountStore(ctx, c.store, hash, distance)
+}
+
+// QueryFunc streams the results from the underlying store, as
+// StreamStore does. Streamed results are not cached.
+func (c *CachedStore) QueryFunc(ctx context.Context, hash, distance uint64, f func(*SearchResult) bool) error {
+	return StreamStore(ctx, c.store, hash, distance, f)
+}
+
+// Verify checks the underlying store, as VerifyStore does. Cached
+// results are not checked; Purge drops them.
+func (c *CachedStore) Verify(ctx context.Context) error {
+	return VerifyStore(ctx, c.store)
+}
+
+// Compact compacts the underlying store, as CompactStore does. It does
+// not change the results of queries, so cached ones are kept.
+func (c *CachedStore) Compact(ctx context.Context, opts *CompactOptions) error {
+	return CompactStore(ctx, c.store, opts)
+}
+
+// Snapshot returns a snapshot of the underlying store, if it is a
+// Snapshotter, or nil otherwise.
+func (c *CachedStore) Snapshot() *Index {
+	if snap, ok := c.store.(Snapshotter); ok {
+		return snap.Snapshot()
+	}
+	return nil
+}
+
+// Stats returns the number of queries answered from the cache, and of
+// those passed on to the underlying store.
+func (c *CachedStore) Stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Purge drops all cached results.
+func (c *CachedStore) Purge() {
+	c.mu.Lock()
+	c.entries = make(map[queryKey]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+}
+
+// invalidate drops the cached results for which stale returns true.
+func (c *CachedStore) invalidate(stale func(*queryEntry) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.changes++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if stale(el.Value.(*queryEntry)) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// remove drops the given entry. The caller holds the lock.
+func (c *CachedStore) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*queryEntry).key)
+	c.lru.Remove(el)
+}
+
+// has returns true if the set holds a result for the given ID.
+func (r ResultSet) has(id string) bool {
+	for _, res := range r {
+		if res.Path == id {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of the set, which callers may change without
+// changing the cached one.
+func (r ResultSet) clone() ResultSet {
+	if r == nil {
+		return nil
+	}
+
+	rs := make(ResultSet, len(r))
+	results := make([]SearchResult, len(r))
+	for i, res := range r {
+		results[i] = *res
+		rs[i] = &results[i]
+	}
+	return rs
+}
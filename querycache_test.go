@@ -0,0 +1,66 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex()
+	index.Add("a", 0x0f)
+	index.Add("b", 0xff)
+
+	c := NewCachedStore(IndexStore(index), &CacheOptions{Size: 2})
+
+	query := func(hash, distance uint64, want int) {
+		t.Helper()
+		rs, err := c.Query(ctx, hash, distance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rs) != want {
+			t.Fatalf("query %x/%d: %d results, want %d", hash, distance, len(rs), want)
+		}
+	}
+
+	query(0x0f, 0, 1)
+	query(0x0f, 0, 1)
+	if n, _ := CountStore(ctx, c, 0xff, 4); n != 2 {
+		t.Fatalf("counted %d", n)
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 1 {
+		t.Fatalf("%d hits, %d misses", hits, misses)
+	}
+
+	// Results handed out are copies.
+	rs, _ := c.Query(ctx, 0x0f, 0)
+	rs[0].Path = "changed"
+	query(0x0f, 0, 1)
+	if rs, _ = c.Query(ctx, 0x0f, 0); rs[0].Path != "a" {
+		t.Fatalf("cached result changed to %q", rs[0].Path)
+	}
+
+	// An add within distance of a query drops its results.
+	c.Add(ctx, "c", 0x0e)
+	query(0x0f, 1, 2)
+	query(0x0f, 0, 1)
+
+	// So does moving or removing an ID they hold.
+	c.Add(ctx, "a", 0xf000)
+	query(0x0f, 1, 1)
+	c.Remove(ctx, "c")
+	query(0x0f, 1, 0)
+
+	// The least recently used results are evicted.
+	query(0xff, 0, 1)
+	query(0xf000, 0, 1)
+	_, misses := c.Stats()
+	query(0x0f, 1, 0)
+	if _, m := c.Stats(); m != misses+1 {
+		t.Fatalf("evicted query answered from cache")
+	}
+}
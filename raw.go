@@ -0,0 +1,241 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"strings"
+)
+
+// ErrNoPreview is returned by ExtractPreview for files which are not
+// RAW files, or which hold no usable JPEG preview.
+var ErrNoPreview = errors.New("imghash: no embedded preview")
+
+// TIFF tags used to find embedded previews.
+const (
+	tagCompression  = 0x0103
+	tagMake         = 0x010f
+	tagStripOffsets = 0x0111
+	tagStripCounts  = 0x0117
+	tagSubIFDs      = 0x014a
+	tagJPEGOffset   = 0x0201
+	tagJPEGLength   = 0x0202
+)
+
+// maxIFDs limits the number of directories read from a single file,
+// so malformed files with cyclic or endless directory lists are cheap.
+const maxIFDs = 32
+
+// rawExtensions are the file extensions of the supported RAW formats.
+var rawExtensions = []string{".cr2", ".nef", ".arw"}
+
+// rawMakes are the camera makers whose RAW files are recognised. The
+// files themselves are TIFF, so this is what sets them apart.
+var rawMakes = []string{"canon", "nikon", "sony"}
+
+// ExtractPreview returns the largest JPEG preview embedded in a Canon
+// CR2, Nikon NEF or Sony ARW file. It returns ErrNoPreview for other data.
+//
+// Cameras store a full or near full size JPEG alongside the sensor
+// data, which is what they show on their screen. Computing the hash of
+// that preview, instead of developing the RAW data, makes a RAW file
+// hash the same as the JPEG the camera wrote next to it. Decode does
+// this automatically for RAW files.
+func ExtractPreview(data []byte) ([]byte, error) {
+	t := tiffFile{data: data, order: tiffOrder(data)}
+	if t.order == nil || len(data) < 8 {
+		return nil, ErrNoPreview
+	}
+
+	// CR2 files are marked right after the TIFF header.
+	raw := len(data) >= 10 && string(data[8:10]) == "CR"
+
+	var preview []byte
+	var area int
+
+	queue := []uint32{t.order.Uint32(data[4:])}
+	seen := make(map[uint32]bool)
+
+	for len(queue) > 0 && len(seen) < maxIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+
+		if offset == 0 || seen[offset] {
+			continue
+		}
+
+		seen[offset] = true
+
+		ifd, next, ok := t.ifd(offset)
+		if !ok {
+			continue
+		}
+
+		queue = append(queue, next)
+		queue = append(queue, t.uints(ifd[tagSubIFDs])...)
+
+		if e, ok := ifd[tagMake]; ok && isRAWMake(string(e.value)) {
+			raw = true
+		}
+
+		// Keep the candidate with the most pixels. This also rules out
+		// the lossless JPEG CR2 files use for the sensor data, which
+		// the image/jpeg package does not support.
+		for _, jpg := range t.previews(ifd) {
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(jpg))
+			if err == nil && cfg.Width*cfg.Height > area {
+				preview, area = jpg, cfg.Width*cfg.Height
+			}
+		}
+	}
+
+	if !raw || preview == nil {
+		return nil, ErrNoPreview
+	}
+
+	return preview, nil
+}
+
+// isRAWMake returns true if the given Make tag names a camera
+// maker with a supported RAW format.
+func isRAWMake(maker string) bool {
+	maker = strings.ToLower(maker)
+	for _, m := range rawMakes {
+		if strings.HasPrefix(maker, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tiffFile reads the directory structure of a TIFF file.
+type tiffFile struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// tiffOrder returns the byte order of a TIFF file, or nil if data
+// is not one.
+func tiffOrder(data []byte) binary.ByteOrder {
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")):
+		return binary.LittleEndian
+	case bytes.HasPrefix(data, []byte("MM\x00*")):
+		return binary.BigEndian
+	}
+
+	return nil
+}
+
+// A tiffEntry holds a single tag from a directory. Value holds the
+// raw data of all its values.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// ifd reads the directory at the given offset. It returns the
+// entries by tag, and the offset of the next directory.
+func (t *tiffFile) ifd(offset uint32) (map[uint16]tiffEntry, uint32, bool) {
+	data := t.data
+	if uint64(offset)+2 > uint64(len(data)) {
+		return nil, 0, false
+	}
+
+	n := int(t.order.Uint16(data[offset:]))
+	end := uint64(offset) + 2 + 12*uint64(n)
+	if end+4 > uint64(len(data)) {
+		return nil, 0, false
+	}
+
+	ifd := make(map[uint16]tiffEntry, n)
+
+	var i int
+	for i = 0; i < n; i++ {
+		e := data[int(offset)+2+12*i:]
+		typ := t.order.Uint16(e[2:])
+		count := t.order.Uint32(e[4:])
+
+		var size uint64
+		switch typ {
+		case 1, 2, 7:
+			size = 1
+		case 3:
+			size = 2
+		case 4, 13:
+			size = 4
+		default:
+			continue
+		}
+
+		size *= uint64(count)
+		value := e[8:12]
+
+		if size > 4 {
+			at := uint64(t.order.Uint32(e[8:]))
+			if at+size > uint64(len(data)) {
+				continue
+			}
+
+			value = data[at:]
+		}
+
+		tag := t.order.Uint16(e)
+		ifd[tag] = tiffEntry{typ, count, value[:size]}
+	}
+
+	return ifd, t.order.Uint32(data[end:]), true
+}
+
+// uints returns the values of an integer entry.
+func (t *tiffFile) uints(e tiffEntry) []uint32 {
+	var out []uint32
+	var i uint32
+
+	for i = 0; i < e.count; i++ {
+		switch e.typ {
+		case 3:
+			out = append(out, uint32(t.order.Uint16(e.value[2*i:])))
+		case 4, 13:
+			out = append(out, t.order.Uint32(e.value[4*i:]))
+		}
+	}
+
+	return out
+}
+
+// previews returns the JPEG streams referenced by a directory. These
+// are either given as a JPEG interchange format offset and length, or
+// as a single strip using the old style JPEG compression.
+func (t *tiffFile) previews(ifd map[uint16]tiffEntry) [][]byte {
+	var out [][]byte
+
+	add := func(offsets, lengths []uint32) {
+		if len(offsets) != 1 || len(lengths) != 1 {
+			return
+		}
+
+		start, end := uint64(offsets[0]), uint64(offsets[0])+uint64(lengths[0])
+		if end > uint64(len(t.data)) {
+			return
+		}
+
+		if jpg := t.data[start:end]; bytes.HasPrefix(jpg, []byte{0xff, 0xd8}) {
+			out = append(out, jpg)
+		}
+	}
+
+	add(t.uints(ifd[tagJPEGOffset]), t.uints(ifd[tagJPEGLength]))
+
+	if c := t.uints(ifd[tagCompression]); len(c) == 1 && c[0] == 6 {
+		add(t.uints(ifd[tagStripOffsets]), t.uints(ifd[tagStripCounts]))
+	}
+
+	return out
+}
@@ -0,0 +1,122 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestExtractPreview(t *testing.T) {
+	thumb := encodeJPEG(t, resize(blockPattern(3), 16, 16))
+	preview := encodeJPEG(t, blockPattern(3))
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		raw := makeRAW(order, "NIKON CORPORATION", thumb, preview)
+
+		data, err := ExtractPreview(raw)
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+
+		if !bytes.Equal(data, preview) {
+			t.Fatalf("%v: got a %d byte preview, want %d bytes", order, len(data), len(preview))
+		}
+
+		img, err := Decode(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := ComputeBytes(preview, Average)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hash := Average(img); hash != want {
+			t.Fatalf("%v: hash %016x, want %016x", order, hash, want)
+		}
+	}
+
+	// TIFF files from other sources are left alone.
+	if _, err := ExtractPreview(makeRAW(binary.LittleEndian, "Scanner Inc", thumb, preview)); err != ErrNoPreview {
+		t.Fatalf("got %v, want ErrNoPreview", err)
+	}
+
+	if _, err := ExtractPreview(preview); err != ErrNoPreview {
+		t.Fatalf("got %v, want ErrNoPreview", err)
+	}
+
+	if !HasExtension("DSC_0001.NEF") {
+		t.Fatal("NEF extension not known")
+	}
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// makeRAW builds a TIFF file laid out like a NEF. The first directory
+// names the camera maker and holds a thumbnail. The preview is stored
+// as an old style JPEG strip, in a sub directory.
+func makeRAW(order binary.ByteOrder, maker string, thumb, preview []byte) []byte {
+	var buf bytes.Buffer
+
+	put := func(v interface{}) { binary.Write(&buf, order, v) }
+	entry := func(tag, typ uint16, count, value uint32) {
+		put(tag)
+		put(typ)
+		put(count)
+
+		if typ == 3 && count == 1 {
+			put(uint16(value))
+			put(uint16(0))
+		} else {
+			put(value)
+		}
+	}
+
+	maker += "\x00"
+
+	// Header, two directories of 4 and 3 entries, then the data.
+	ifd0 := uint32(8)
+	ifd1 := ifd0 + 2 + 4*12 + 4
+	makeAt := ifd1 + 2 + 3*12 + 4
+	thumbAt := makeAt + uint32(len(maker))
+	previewAt := thumbAt + uint32(len(thumb))
+
+	if order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+
+	put(ifd0)
+
+	put(uint16(4))
+	entry(tagMake, 2, uint32(len(maker)), makeAt)
+	entry(tagSubIFDs, 4, 1, ifd1)
+	entry(tagJPEGOffset, 4, 1, thumbAt)
+	entry(tagJPEGLength, 4, 1, uint32(len(thumb)))
+	put(uint32(0))
+
+	put(uint16(3))
+	entry(tagCompression, 3, 1, 6)
+	entry(tagStripOffsets, 4, 1, previewAt)
+	entry(tagStripCounts, 4, 1, uint32(len(preview)))
+	put(uint32(0))
+
+	buf.WriteString(maker)
+	buf.Write(thumb)
+	buf.Write(preview)
+	return buf.Bytes()
+}
@@ -0,0 +1,190 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+// ErrInvalidFrame is returned by WrapRaw and ComputeRaw for buffers
+// too small for the given format and size.
+var ErrInvalidFrame = errors.New("imghash: invalid raw frame")
+
+// A PixelFormat is the layout of a raw frame buffer, as video decoders
+// write them. Names match those of ffmpeg's -pix_fmt.
+type PixelFormat int
+
+// Known pixel formats.
+const (
+	PixelGray PixelFormat = iota // 8-bit luma.
+	PixelRGB                     // 8-bit red, green and blue.
+	PixelBGR                     // 8-bit blue, green and red.
+	PixelRGBA                    // 8-bit red, green, blue and straight alpha.
+	PixelBGRA                    // 8-bit blue, green, red and straight alpha.
+	PixelI420                    // Planar 4:2:0: a luma plane, then a Cb and a Cr plane at half size.
+	PixelNV12                    // Semi-planar 4:2:0: a luma plane, then one of interleaved Cb and Cr.
+	pixelFormats
+)
+
+var pixelFormatNames = [...]string{"gray", "rgb24", "bgr24", "rgba", "bgra", "yuv420p", "nv12"}
+
+// pixelSizes holds the bytes per pixel of the first plane of each format.
+var pixelSizes = [...]int{1, 3, 3, 4, 4, 1, 1}
+
+func (f PixelFormat) String() string {
+	if f < 0 || f >= pixelFormats {
+		return fmt.Sprintf("PixelFormat(%d)", int(f))
+	}
+	return pixelFormatNames[f]
+}
+
+// WrapRaw returns an image over the w by h frame in buf, without
+// copying it. Stride is the number of bytes per row of the first plane,
+// or 0 for rows without padding. The planes of I420 and NV12 follow each
+// other directly; their chroma rows have half the stride, and the full
+// stride, respectively.
+//
+// Gray, RGBA and I420 frames yield an *image.Gray, *image.NRGBA and
+// *image.YCbCr. Changes to buf show through in the image.
+func WrapRaw(buf []byte, format PixelFormat, w, h, stride int) (image.Image, error) {
+	if format < 0 || format >= pixelFormats || w <= 0 || h <= 0 {
+		return nil, ErrInvalidFrame
+	}
+
+	row := w * pixelSizes[format]
+	if format == PixelNV12 {
+		row = 2 * ((w + 1) / 2)
+	}
+
+	if stride == 0 {
+		stride = row
+	}
+
+	if stride < row {
+		return nil, ErrInvalidFrame
+	}
+
+	rect := image.Rect(0, 0, w, h)
+	size := stride * h
+	ch := (h + 1) / 2
+
+	switch format {
+	case PixelI420:
+		cstride := (stride + 1) / 2
+		csize := cstride * ch
+		if len(buf) < size+2*csize {
+			return nil, ErrInvalidFrame
+		}
+
+		return &image.YCbCr{
+			Y:              buf[:size],
+			Cb:             buf[size : size+csize],
+			Cr:             buf[size+csize : size+2*csize],
+			YStride:        stride,
+			CStride:        cstride,
+			SubsampleRatio: image.YCbCrSubsampleRatio420,
+			Rect:           rect,
+		}, nil
+
+	case PixelNV12:
+		if len(buf) < size+stride*ch {
+			return nil, ErrInvalidFrame
+		}
+
+		return &rawImage{pix: buf[:size], uv: buf[size : size+stride*ch], format: format, stride: stride, rect: rect}, nil
+	}
+
+	if len(buf) < size {
+		return nil, ErrInvalidFrame
+	}
+
+	switch format {
+	case PixelGray:
+		return &image.Gray{Pix: buf[:size], Stride: stride, Rect: rect}, nil
+	case PixelRGBA:
+		return &image.NRGBA{Pix: buf[:size], Stride: stride, Rect: rect}, nil
+	}
+
+	return &rawImage{pix: buf[:size], format: format, stride: stride, rect: rect}, nil
+}
+
+// ComputeRaw computes the hash of the raw frame in buf using the given
+// HashFunc. The frame is read in place, as described for WrapRaw; the
+// scaling step of the hashes reads its pixels directly, so this costs
+// about as much as hashing a decoded image of the same size.
+func ComputeRaw(buf []byte, format PixelFormat, w, h, stride int, hf HashFunc) (uint64, error) {
+	start := time.Now()
+	m := currentMetrics()
+
+	img, err := WrapRaw(buf, format, w, h, stride)
+	if err != nil {
+		m.DecodeFailed(err)
+		return 0, err
+	}
+
+	hash := hf(img)
+	m.ImageHashed(time.Since(start))
+	return hash, nil
+}
+
+// A rawImage is a frame in one of the pixel formats without a
+// counterpart in the image package. Its origin is at (0, 0).
+type rawImage struct {
+	pix    []byte // First plane.
+	uv     []byte // Chroma plane of NV12.
+	format PixelFormat
+	stride int
+	rect   image.Rectangle
+}
+
+func (m *rawImage) ColorModel() color.Model { return color.NRGBAModel }
+func (m *rawImage) Bounds() image.Rectangle { return m.rect }
+
+func (m *rawImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(m.rect)) {
+		return color.NRGBA{}
+	}
+
+	// Frames in YCbCr convert as image.YCbCr does.
+	if m.format == PixelNV12 {
+		return m.ycbcr(x, y)
+	}
+
+	return m.pixel(x, y)
+}
+
+// ycbcr returns the pixel at (x, y) of an NV12 frame.
+func (m *rawImage) ycbcr(x, y int) color.YCbCr {
+	p := m.uv[(y/2)*m.stride+x&^1:]
+	return color.YCbCr{m.pix[y*m.stride+x], p[0], p[1]}
+}
+
+// pixel returns the pixel at (x, y), which must be inside the image.
+// YCbCr pixels are converted at 8 bits per channel, as when scaling
+// an image.YCbCr.
+func (m *rawImage) pixel(x, y int) color.NRGBA {
+	var p []byte
+
+	switch m.format {
+	case PixelRGB:
+		p = m.pix[y*m.stride+3*x:]
+		return color.NRGBA{p[0], p[1], p[2], 0xff}
+
+	case PixelBGR:
+		p = m.pix[y*m.stride+3*x:]
+		return color.NRGBA{p[2], p[1], p[0], 0xff}
+
+	case PixelBGRA:
+		p = m.pix[y*m.stride+4*x:]
+		return color.NRGBA{p[2], p[1], p[0], p[3]}
+	}
+
+	c := m.ycbcr(x, y)
+	r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+	return color.NRGBA{r, g, b, 0xff}
+}
@@ -0,0 +1,158 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestComputeRaw(t *testing.T) {
+	// An odd size, with padded rows, checks the chroma layout. Packed
+	// frames are all padded to the stride of the widest pixels.
+	const w, h, stride, packedStride = 61, 47, 72, 4*61 + 3
+
+	ref := image.NewNRGBA(image.Rect(0, 0, w, h))
+	gray := blockPattern(2).(*image.Gray)
+
+	var x, y int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			v := gray.GrayAt(x, y).Y
+			ref.SetNRGBA(x, y, color.NRGBA{v, 255 - v, uint8(x * 4), uint8(128 + y)})
+		}
+	}
+
+	packed := func(order []int, alpha bool) []byte {
+		n := len(order)
+		buf := make([]byte, packedStride*h)
+
+		for y = 0; y < h; y++ {
+			for x = 0; x < w; x++ {
+				c := ref.NRGBAAt(x, y)
+				if !alpha {
+					c.A = 0xff
+				}
+
+				p := []byte{c.R, c.G, c.B, c.A}
+				for i, o := range order {
+					buf[y*packedStride+x*n+i] = p[o]
+				}
+			}
+		}
+		return buf
+	}
+
+	opaque := func(x, y int) color.Color {
+		c := ref.NRGBAAt(x, y)
+		c.A = 0xff
+		return c
+	}
+
+	// YCbCr frames hash as the YCbCr image they hold.
+	ycc := image.NewYCbCr(ref.Rect, image.YCbCrSubsampleRatio420)
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			c := ref.NRGBAAt(x, y)
+			Y, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			ycc.Y[ycc.YOffset(x, y)] = Y
+			ycc.Cb[ycc.COffset(x, y)] = cb
+			ycc.Cr[ycc.COffset(x, y)] = cr
+		}
+	}
+
+	cw, ch := (w+1)/2, (h+1)/2
+	i420 := make([]byte, stride*h+2*(stride/2)*ch)
+	nv12 := make([]byte, stride*h+stride*ch)
+
+	for y = 0; y < h; y++ {
+		copy(i420[y*stride:], ycc.Y[y*ycc.YStride:y*ycc.YStride+w])
+		copy(nv12[y*stride:], ycc.Y[y*ycc.YStride:y*ycc.YStride+w])
+	}
+
+	for y = 0; y < ch; y++ {
+		for x = 0; x < cw; x++ {
+			cb, cr := ycc.Cb[y*ycc.CStride+x], ycc.Cr[y*ycc.CStride+x]
+			i420[stride*h+y*(stride/2)+x] = cb
+			i420[stride*h+(stride/2)*ch+y*(stride/2)+x] = cr
+			nv12[stride*h+y*stride+2*x] = cb
+			nv12[stride*h+y*stride+2*x+1] = cr
+		}
+	}
+
+	tests := []struct {
+		format PixelFormat
+		buf    []byte
+		stride int
+		want   image.Image
+	}{
+		{PixelGray, packed([]int{0}, false), packedStride, imageFunc(ref.Rect, func(x, y int) color.Color { return color.Gray{ref.Pix[ref.PixOffset(x, y)]} })},
+		{PixelRGB, packed([]int{0, 1, 2}, false), packedStride, imageFunc(ref.Rect, opaque)},
+		{PixelBGR, packed([]int{2, 1, 0}, false), packedStride, imageFunc(ref.Rect, opaque)},
+		{PixelRGBA, packed([]int{0, 1, 2, 3}, true), packedStride, ref},
+		{PixelBGRA, packed([]int{2, 1, 0, 3}, true), packedStride, ref},
+		{PixelI420, i420, stride, ycc},
+		{PixelNV12, nv12, stride, ycc},
+	}
+
+	for _, tt := range tests {
+		img, err := WrapRaw(tt.buf, tt.format, w, h, tt.stride)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.format, err)
+		}
+
+		for y = 0; y < h; y++ {
+			for x = 0; x < w; x++ {
+				r0, g0, b0, a0 := img.At(x, y).RGBA()
+				r1, g1, b1, a1 := tt.want.At(x, y).RGBA()
+				if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+					t.Fatalf("%v: pixel (%d, %d) differs", tt.format, x, y)
+				}
+			}
+		}
+
+		// Hashing reads the frame in place; the reference takes the
+		// generic path.
+		for _, hf := range []HashFunc{Average, Preprocess(Average, CenterCrop)} {
+			got, err := ComputeRaw(tt.buf, tt.format, w, h, tt.stride, hf)
+			if err != nil {
+				t.Fatalf("%v: %v", tt.format, err)
+			}
+
+			// YCbCr images scale at 8 bits per channel, not through At.
+			want := hf(tt.want)
+			if _, ok := tt.want.(*image.YCbCr); !ok {
+				want = hf(imageFunc(ref.Rect, tt.want.At))
+			}
+
+			if got != want {
+				t.Fatalf("%v: hash %016x, want %016x", tt.format, got, want)
+			}
+		}
+	}
+
+	if _, err := ComputeRaw(make([]byte, w*h), PixelRGB, w, h, 0, Average); err != ErrInvalidFrame {
+		t.Fatalf("short buffer: %v", err)
+	}
+
+	if _, err := WrapRaw(make([]byte, 4*w*h), PixelRGBA, w, h, 2*w); err != ErrInvalidFrame {
+		t.Fatalf("short stride: %v", err)
+	}
+}
+
+// funcImage is an image of the given bounds with pixels from at, of a
+// type the scaling code does not know.
+type funcImage struct {
+	rect image.Rectangle
+	at   func(x, y int) color.Color
+}
+
+func imageFunc(r image.Rectangle, at func(x, y int) color.Color) image.Image {
+	return &funcImage{r, at}
+}
+
+func (m *funcImage) ColorModel() color.Model { return color.RGBA64Model }
+func (m *funcImage) Bounds() image.Rectangle { return m.rect }
+func (m *funcImage) At(x, y int) color.Color { return m.at(x, y) }
@@ -0,0 +1,233 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// defaultChunkSize is the size of range reads, if ReaderAtOptions
+// does not specify one.
+const defaultChunkSize = 256 << 10
+
+// errNotDC is returned internally for JPEGs which can not be decoded
+// from their DC scans alone.
+var errNotDC = errors.New("imghash: no leading DC scans")
+
+// ReaderAtOptions configure ComputeReaderAt.
+type ReaderAtOptions struct {
+	// Number of bytes requested per ReadAt call. Object stores charge
+	// per request, so this should not be too small. Defaults to 256KB.
+	ChunkSize int
+
+	// If set, progressive JPEGs are decoded from their leading DC scans
+	// only, and the rest of the file is never read. The DC coefficients
+	// hold the mean of every 8x8 block, which is all hashes working on a
+	// heavily downscaled image -- like Average -- need. For large photos,
+	// this typically reads a small fraction of the file.
+	//
+	// Other images, including baseline JPEGs, are read in full.
+	DCOnly bool
+}
+
+// ComputeReaderAt decodes the image of the given size in r and computes
+// its hash using the given HashFunc. Opts may be nil, to use the defaults.
+//
+// This is meant for object stores like S3 or GCS, which expose files
+// through range requests. Data is read sequentially, in large chunks.
+func ComputeReaderAt(r io.ReaderAt, size int64, hf HashFunc, opts *ReaderAtOptions) (uint64, error) {
+	chunk := defaultChunkSize
+	if opts != nil && opts.ChunkSize > 0 {
+		chunk = opts.ChunkSize
+	}
+
+	if opts != nil && opts.DCOnly {
+		data, err := jpegDCScans(r, size, chunk)
+
+		switch err {
+		case nil:
+			return ComputeBytes(data, hf)
+		case errNotDC:
+		default:
+			return 0, err
+		}
+	}
+
+	return ComputeReader(bufio.NewReaderSize(io.NewSectionReader(r, 0, size), chunk), hf)
+}
+
+// jpegDCScans reads a progressive JPEG up to the end of its leading DC
+// scans. It returns that part of the file, terminated by an EOI marker,
+// which the image/jpeg package decodes as a blurred image.
+//
+// It returns errNotDC for other files.
+func jpegDCScans(r io.ReaderAt, size int64, chunk int) ([]byte, error) {
+	var data bytes.Buffer
+	jr := &jpegReader{r: bufio.NewReaderSize(io.TeeReader(io.NewSectionReader(r, 0, size), &data), chunk)}
+
+	if m, err := jr.marker(); err != nil || m != 0xd8 {
+		return nil, errNotDC
+	}
+
+	var progressive, scans bool
+
+	m, err := jr.marker()
+	for err == nil {
+		begin := jr.pos - 2
+
+		switch {
+		case m == 0xd9:
+			// End of image, without any AC scans.
+			if !progressive || !scans {
+				return nil, errNotDC
+			}
+
+			return data.Bytes()[:jr.pos], nil
+
+		case m >= 0xc0 && m <= 0xcf && m != 0xc4 && m != 0xc8 && m != 0xcc:
+			// Only progressive Huffman coded frames qualify.
+			if m != 0xc2 {
+				return nil, errNotDC
+			}
+			progressive = true
+			err = jr.skipSegment()
+
+		case m == 0xda:
+			var seg []byte
+			if seg, err = jr.segment(); err != nil {
+				break
+			}
+
+			// Spectral selection start and end follow the
+			// component selectors.
+			if len(seg) == 0 {
+				return nil, errNotDC
+			}
+
+			n := 1 + 2*int(seg[0])
+			if n+1 >= len(seg) {
+				return nil, errNotDC
+			}
+
+			if seg[n] != 0 || seg[n+1] != 0 {
+				// The first AC scan. Everything before it stays.
+				if !progressive || !scans {
+					return nil, errNotDC
+				}
+
+				return append(data.Bytes()[:begin:begin], 0xff, 0xd9), nil
+			}
+
+			scans = true
+			m, err = jr.skipScan()
+			continue
+
+		default:
+			err = jr.skipSegment()
+		}
+
+		if err == nil {
+			m, err = jr.marker()
+		}
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errNotDC
+	}
+
+	return nil, err
+}
+
+// jpegReader reads JPEG markers and segments, and keeps
+// track of its position in the file.
+type jpegReader struct {
+	r   *bufio.Reader
+	pos int
+}
+
+func (j *jpegReader) readByte() (byte, error) {
+	b, err := j.r.ReadByte()
+	if err == nil {
+		j.pos++
+	}
+	return b, err
+}
+
+// marker reads the next marker, and returns its code.
+func (j *jpegReader) marker() (byte, error) {
+	b, err := j.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if b != 0xff {
+		return 0, errNotDC
+	}
+
+	// Markers may be preceded by any number of fill bytes.
+	for b == 0xff {
+		if b, err = j.readByte(); err != nil {
+			return 0, err
+		}
+	}
+
+	return b, nil
+}
+
+// segment reads the contents of a marker segment.
+func (j *jpegReader) segment() ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(j.r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	n := int(hdr[0])<<8 | int(hdr[1])
+	if n < 2 {
+		return nil, errNotDC
+	}
+
+	seg := make([]byte, n-2)
+	if _, err := io.ReadFull(j.r, seg); err != nil {
+		return nil, err
+	}
+
+	j.pos += n
+	return seg, nil
+}
+
+// skipSegment skips the contents of a marker segment.
+func (j *jpegReader) skipSegment() error {
+	_, err := j.segment()
+	return err
+}
+
+// skipScan skips entropy coded data, and returns the
+// code of the marker which follows it.
+func (j *jpegReader) skipScan() (byte, error) {
+	for {
+		b, err := j.readByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if b != 0xff {
+			continue
+		}
+
+		for b == 0xff {
+			if b, err = j.readByte(); err != nil {
+				return 0, err
+			}
+		}
+
+		// Stuffed zero bytes and restart markers are
+		// part of the scan.
+		if b != 0 && (b < 0xd0 || b > 0xd7) {
+			return b, nil
+		}
+	}
+}
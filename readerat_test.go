@@ -0,0 +1,179 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestComputeReaderAt(t *testing.T) {
+	img := blockPattern(7)
+	data := makeProgressiveJPEG(img.(*image.Gray))
+	want := Average(img)
+
+	for _, dc := range []bool{false, true} {
+		r := &countingReaderAt{data: data}
+		opts := &ReaderAtOptions{ChunkSize: 1024, DCOnly: dc}
+
+		hash, err := ComputeReaderAt(r, int64(len(data)), Average, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hash != want {
+			t.Fatalf("dc=%v: hash %016x, want %016x", dc, hash, want)
+		}
+
+		if dc && r.max > len(data)/4 {
+			t.Fatalf("DC only: read %d of %d bytes", r.max, len(data))
+		}
+
+		if !dc && r.max != len(data) {
+			t.Fatalf("full: read %d of %d bytes", r.max, len(data))
+		}
+	}
+
+	// Baseline JPEGs are read in full.
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReaderAt{data: buf.Bytes()}
+	if _, err := ComputeReaderAt(r, int64(buf.Len()), Average, &ReaderAtOptions{DCOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.max != buf.Len() {
+		t.Fatalf("baseline: read %d of %d bytes", r.max, buf.Len())
+	}
+}
+
+// countingReaderAt records the highest offset read.
+type countingReaderAt struct {
+	data []byte
+	max  int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := bytes.NewReader(r.data).ReadAt(p, off)
+	if int(off)+n > r.max {
+		r.max = int(off) + n
+	}
+	return n, err
+}
+
+// makeProgressiveJPEG encodes a grayscale image, made up of solid 8x8
+// blocks, as a progressive JPEG. The DC scan encodes the image exactly.
+// It is followed by an AC scan with all coefficients set to zero, and a
+// large comment, which the DC only mode should never read.
+func makeProgressiveJPEG(img *image.Gray) []byte {
+	var out bytes.Buffer
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	segment := func(marker byte, data ...byte) {
+		out.Write([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+		out.Write(data)
+	}
+
+	out.Write([]byte{0xff, 0xd8})
+
+	// Quantization table of ones.
+	segment(0xdb, append([]byte{0}, bytes.Repeat([]byte{1}, 64)...)...)
+
+	// Progressive frame, a single component.
+	segment(0xc2, 8, byte(h>>8), byte(h), byte(w>>8), byte(w), 1, 1, 0x11, 0)
+
+	// DC table: all 12 categories coded in 4 bits.
+	dc := []byte{0x00, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	segment(0xc4, append(dc, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)...)
+
+	// DC scan.
+	segment(0xda, 1, 1, 0x00, 0, 0, 0)
+
+	var bw bitWriter
+	var prev int
+
+	for y := 0; y < h; y += 8 {
+		for x := 0; x < w; x += 8 {
+			v := 8 * (int(img.GrayAt(x, y).Y) - 128)
+			diff := v - prev
+			prev = v
+
+			size, mag := 0, diff
+			if mag < 0 {
+				mag = -mag
+			}
+
+			for ; mag > 0; mag >>= 1 {
+				size++
+			}
+
+			if diff < 0 {
+				diff += 1<<uint(size) - 1
+			}
+
+			bw.write(uint(size), 4)
+			bw.write(uint(diff), uint(size))
+		}
+	}
+
+	out.Write(bw.flush())
+
+	// AC table with a single symbol: an end-of-band run of 64+.
+	segment(0xc4, 0x10, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x60)
+
+	// AC scan, ending all bands of the 64 blocks at once.
+	segment(0xda, 1, 1, 0x00, 1, 63, 0)
+	bw.write(0, 1)
+	bw.write(uint(w*h/64-64), 6)
+	out.Write(bw.flush())
+
+	segment(0xfe, bytes.Repeat([]byte("padding "), 8000)...)
+	out.Write([]byte{0xff, 0xd9})
+	return out.Bytes()
+}
+
+// bitWriter writes entropy coded JPEG data.
+type bitWriter struct {
+	buf   []byte
+	bits  uint32
+	nbits uint
+}
+
+func (b *bitWriter) write(v, n uint) {
+	for i := n; i > 0; i-- {
+		b.bits = b.bits<<1 | uint32(v>>(i-1))&1
+		b.nbits++
+
+		if b.nbits == 8 {
+			b.emit()
+		}
+	}
+}
+
+func (b *bitWriter) emit() {
+	c := byte(b.bits)
+	b.buf = append(b.buf, c)
+
+	if c == 0xff {
+		b.buf = append(b.buf, 0)
+	}
+
+	b.bits, b.nbits = 0, 0
+}
+
+// flush pads the last byte with ones, and returns all data written.
+func (b *bitWriter) flush() []byte {
+	for b.nbits > 0 {
+		b.write(1, 1)
+	}
+
+	out := b.buf
+	b.buf = nil
+	return out
+}
@@ -0,0 +1,183 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// errProtocol is returned for replies which do not follow RESP.
+var errProtocol = errors.New("redis: protocol error")
+
+// An Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// conn is a single connection to the server. It speaks just enough of
+// RESP, the Redis protocol, for the commands the store needs. Commands
+// are buffered until flush, so they can be pipelined.
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// dial connects to the server, and authenticates and selects
+// the database if needed.
+func dial(ctx context.Context, addr string, opts *Options) (*conn, error) {
+	var d net.Dialer
+
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{c: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var setup [][]string
+	if len(opts.Password) > 0 {
+		setup = append(setup, []string{"AUTH", opts.Password})
+	}
+
+	if opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(opts.DB)})
+	}
+
+	if len(setup) > 0 {
+		if _, err = c.do(ctx, setup...); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// send buffers a command.
+func (c *conn) send(args ...string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+}
+
+// do sends all commands at once, and returns their replies. The
+// first error reply is returned as an error, after reading all
+// replies. Any other error leaves the connection unusable.
+func (c *conn) do(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.c.SetDeadline(deadline)
+	} else {
+		c.c.SetDeadline(time.Time{})
+	}
+
+	for _, cmd := range cmds {
+		c.send(cmd...)
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var replyErr error
+	replies := make([]interface{}, len(cmds))
+
+	for i := range replies {
+		v, err := c.reply()
+		if err != nil {
+			return nil, err
+		}
+
+		if e, ok := v.(Error); ok && replyErr == nil {
+			replyErr = e
+		}
+
+		replies[i] = v
+	}
+
+	return replies, replyErr
+}
+
+// reply reads a single reply. Simple and bulk strings are returned as
+// string, integers as int64, arrays as []interface{} and error replies
+// as Error. Null replies are returned as nil.
+func (c *conn) reply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+
+	case '-':
+		return Error(line), nil
+
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+
+		return string(buf[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = c.reply(); err != nil {
+				return nil, err
+			}
+		}
+
+		return values, nil
+	}
+
+	return nil, errProtocol
+}
+
+// Close closes the connection.
+func (c *conn) Close() error {
+	return c.c.Close()
+}
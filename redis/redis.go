@@ -0,0 +1,481 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package redis implements imghash.Store on top of Redis, so several
+processes -- like replicas of a hashing service -- can share a single,
+searchable collection of hashes, without each holding its own index.
+
+Radius queries use multi-index hashing. Every hash is split into a
+number of segments, and its ID is added to one bucket per segment,
+keyed by the segment's value. If two hashes are within distance d of
+each other, then by the pigeonhole principle, at least one of their m
+segments differs in no more than d/m bits. A query therefore only
+reads the buckets for segment values within d/m bits of its own, and
+compares the hashes found there in full.
+
+With the default of 4 segments of 16 bits, a query for distance 9 reads
+4*137 buckets. The number of buckets grows quickly with the distance.
+Queries which would read more than MaxProbes buckets scan all hashes
+instead.
+
+The package talks to the server directly, and needs no client library.
+*/
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Defaults for Options.
+const (
+	DefaultPrefix    = "imghash"
+	DefaultSegments  = 4
+	DefaultMaxConns  = 8
+	DefaultMaxProbes = 4096
+)
+
+// ErrSegments is returned for a segment count which does not divide 64.
+var ErrSegments = errors.New("redis: segment count must divide 64")
+
+// errConflict is returned when a hash is changed concurrently,
+// too many times in a row.
+var errConflict = errors.New("redis: too many concurrent updates")
+
+// Number of attempts for an update, when other clients
+// modify the same ID concurrently.
+const maxAttempts = 10
+
+// Maximum number of keys passed to a single command.
+const maxKeys = 1000
+
+// Options configure a Store.
+type Options struct {
+	Password string // Password for AUTH, if any.
+	DB       int    // Database number to SELECT.
+
+	// Prefix for all keys. Defaults to DefaultPrefix.
+	Prefix string
+
+	// Number of segments each hash is split into. It must divide 64.
+	// More segments make queries for larger distances cheaper, but
+	// yield more candidates to compare. All clients sharing a store
+	// must use the same value. Defaults to DefaultSegments.
+	Segments int
+
+	// Maximum number of idle connections kept open.
+	// Defaults to DefaultMaxConns.
+	MaxConns int
+
+	// Maximum number of buckets a query reads, before falling back
+	// to a full scan. Defaults to DefaultMaxProbes.
+	MaxProbes int
+}
+
+// A Store keeps hashes in Redis. It is safe for concurrent use.
+//
+// Each ID is stored in its own key, which holds the hash as a 16 digit
+// hexadecimal string. The buckets are sets of IDs.
+type Store struct {
+	addr      string
+	opts      Options
+	width     uint // Bits per segment.
+	maxProbes int
+	pool      chan *conn
+}
+
+// New creates a store for the server at the given address. Opts may
+// be nil, to use the defaults. Connections are opened when needed.
+func New(addr string, opts *Options) (*Store, error) {
+	s := &Store{addr: addr}
+	if opts != nil {
+		s.opts = *opts
+	}
+
+	if len(s.opts.Prefix) == 0 {
+		s.opts.Prefix = DefaultPrefix
+	}
+
+	if s.opts.Segments == 0 {
+		s.opts.Segments = DefaultSegments
+	}
+
+	if s.opts.Segments < 0 || s.opts.Segments > 64 || 64%s.opts.Segments != 0 {
+		return nil, ErrSegments
+	}
+
+	if s.opts.MaxConns <= 0 {
+		s.opts.MaxConns = DefaultMaxConns
+	}
+
+	s.maxProbes = s.opts.MaxProbes
+	if s.maxProbes <= 0 {
+		s.maxProbes = DefaultMaxProbes
+	}
+
+	s.width = uint(64 / s.opts.Segments)
+	s.pool = make(chan *conn, s.opts.MaxConns)
+	return s, nil
+}
+
+// Close closes all idle connections.
+func (s *Store) Close() error {
+	for {
+		select {
+		case c := <-s.pool:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Add adds the given ID and hash. If the ID already exists,
+// its hash is replaced.
+func (s *Store) Add(ctx context.Context, id string, hash uint64) error {
+	return s.update(ctx, id, func(cmds [][]string) [][]string {
+		cmds = append(cmds, []string{"SET", s.idKey(id), fmt.Sprintf("%016x", hash)})
+		for _, key := range s.buckets(hash) {
+			cmds = append(cmds, []string{"SADD", key, id})
+		}
+		return cmds
+	})
+}
+
+// Remove removes the given ID, if it exists.
+func (s *Store) Remove(ctx context.Context, id string) error {
+	return s.update(ctx, id, func(cmds [][]string) [][]string {
+		return append(cmds, []string{"DEL", s.idKey(id)})
+	})
+}
+
+// update removes the ID from the buckets of its current hash, and
+// adds the commands returned by f, in a single transaction. This is
+// retried if another client changes the ID in the meantime.
+func (s *Store) update(ctx context.Context, id string, f func([][]string) [][]string) error {
+	return s.with(ctx, func(c *conn) error {
+		for attempt := 0; attempt < maxAttempts; attempt++ {
+			replies, err := c.do(ctx, []string{"WATCH", s.idKey(id)}, []string{"GET", s.idKey(id)})
+			if err != nil {
+				return err
+			}
+
+			cmds := [][]string{{"MULTI"}}
+			if old, ok := replies[1].(string); ok {
+				hash, err := strconv.ParseUint(old, 16, 64)
+				if err != nil {
+					return fmt.Errorf("redis: invalid hash %q for %q", old, id)
+				}
+
+				for _, key := range s.buckets(hash) {
+					cmds = append(cmds, []string{"SREM", key, id})
+				}
+			}
+
+			cmds = append(f(cmds), []string{"EXEC"})
+
+			replies, err = c.do(ctx, cmds...)
+			if err != nil {
+				return err
+			}
+
+			// EXEC yields nil if the watched key changed, or the
+			// replies of the queued commands otherwise.
+			if results, ok := replies[len(replies)-1].([]interface{}); ok {
+				for _, r := range results {
+					if e, ok := r.(Error); ok {
+						return e
+					}
+				}
+
+				return nil
+			}
+		}
+
+		return errConflict
+	})
+}
+
+// Query finds all hashes within the given distance of hash, sorted by
+// distance and ID. The Path field of each result holds the ID.
+func (s *Store) Query(ctx context.Context, hash, distance uint64) (imghash.ResultSet, error) {
+	var rs imghash.ResultSet
+
+	err := s.with(ctx, func(c *conn) error {
+		var ids []string
+		var err error
+
+		if keys := s.probes(hash, distance); keys != nil {
+			ids, err = s.candidates(ctx, c, keys)
+		} else {
+			ids, err = s.scan(ctx, c)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		sort.Strings(ids)
+		rs, err = s.compare(ctx, c, ids, hash, distance)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Stable(rs)
+	return rs, nil
+}
+
+// candidates returns the IDs in the given buckets.
+func (s *Store) candidates(ctx context.Context, c *conn, keys []string) ([]string, error) {
+	var cmds [][]string
+
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxKeys {
+			n = maxKeys
+		}
+
+		cmds = append(cmds, append([]string{"SUNION"}, keys[:n]...))
+		keys = keys[n:]
+	}
+
+	replies, err := c.do(ctx, cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+
+	for _, r := range replies {
+		for _, v := range stringValues(r) {
+			if !seen[v] {
+				seen[v] = true
+				ids = append(ids, v)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// scan returns all IDs in the store.
+func (s *Store) scan(ctx context.Context, c *conn) ([]string, error) {
+	prefix := s.idKey("")
+	pattern := escapeGlob(prefix) + "*"
+
+	// SCAN may return keys more than once.
+	seen := make(map[string]bool)
+	var ids []string
+	cursor := "0"
+
+	for {
+		replies, err := c.do(ctx, []string{"SCAN", cursor, "MATCH", pattern, "COUNT", "1000"})
+		if err != nil {
+			return nil, err
+		}
+
+		r, ok := replies[0].([]interface{})
+		if !ok || len(r) != 2 {
+			return nil, errProtocol
+		}
+
+		for _, key := range stringValues(r[1]) {
+			if !seen[key] {
+				seen[key] = true
+				ids = append(ids, strings.TrimPrefix(key, prefix))
+			}
+		}
+
+		if cursor, ok = r[0].(string); !ok {
+			return nil, errProtocol
+		}
+
+		if cursor == "0" {
+			return ids, nil
+		}
+	}
+}
+
+// compare reads the hashes of the given IDs, and returns
+// those within distance of hash.
+func (s *Store) compare(ctx context.Context, c *conn, ids []string, hash, distance uint64) (imghash.ResultSet, error) {
+	var rs imghash.ResultSet
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxKeys {
+			n = maxKeys
+		}
+
+		cmd := []string{"MGET"}
+		for _, id := range ids[:n] {
+			cmd = append(cmd, s.idKey(id))
+		}
+
+		replies, err := c.do(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		values, _ := replies[0].([]interface{})
+		for i, v := range values {
+			str, ok := v.(string)
+			if !ok || i >= n {
+				continue // Removed in the meantime.
+			}
+
+			h, err := strconv.ParseUint(str, 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("redis: invalid hash %q for %q", str, ids[i])
+			}
+
+			if d := imghash.Distance(h, hash); d <= distance {
+				rs = append(rs, &imghash.SearchResult{Path: ids[i], Hash: h, Distance: d})
+			}
+		}
+
+		ids = ids[n:]
+	}
+
+	return rs, nil
+}
+
+// with calls f with a connection from the pool.
+func (s *Store) with(ctx context.Context, f func(*conn) error) error {
+	var c *conn
+
+	select {
+	case c = <-s.pool:
+	default:
+		var err error
+		if c, err = dial(ctx, s.addr, &s.opts); err != nil {
+			return err
+		}
+	}
+
+	err := f(c)
+
+	// Connections stay usable after error replies, but
+	// not after I/O or protocol errors.
+	if _, ok := err.(Error); err != nil && !ok {
+		c.Close()
+		return err
+	}
+
+	select {
+	case s.pool <- c:
+	default:
+		c.Close()
+	}
+
+	return err
+}
+
+// idKey returns the key holding the hash for an ID.
+func (s *Store) idKey(id string) string {
+	return s.opts.Prefix + ":id:" + id
+}
+
+// bucketKey returns the key of the bucket for the given segment value.
+func (s *Store) bucketKey(segment int, value uint64) string {
+	return fmt.Sprintf("%s:b:%d:%x", s.opts.Prefix, segment, value)
+}
+
+// segment returns the value of the given segment of a hash.
+func (s *Store) segment(hash uint64, i int) uint64 {
+	return hash >> (uint(i) * s.width) & (^uint64(0) >> (64 - s.width))
+}
+
+// buckets returns the buckets a hash is stored in.
+func (s *Store) buckets(hash uint64) []string {
+	keys := make([]string, s.opts.Segments)
+	for i := range keys {
+		keys[i] = s.bucketKey(i, s.segment(hash, i))
+	}
+	return keys
+}
+
+// probes returns the buckets to read for a query, or nil
+// if there are more than maxProbes of them.
+func (s *Store) probes(hash, distance uint64) []string {
+	radius := int(distance / uint64(s.opts.Segments))
+	if distance >= 64 || combinations(int(s.width), radius)*s.opts.Segments > s.maxProbes {
+		return nil
+	}
+
+	var keys []string
+	for i := 0; i < s.opts.Segments; i++ {
+		seg := s.segment(hash, i)
+		flipBits(seg, s.width, 0, radius, func(v uint64) {
+			keys = append(keys, s.bucketKey(i, v))
+		})
+	}
+
+	return keys
+}
+
+// combinations returns the number of values of the given width
+// which differ from a given value in at most r bits.
+func combinations(width, r int) int {
+	sum, c := 0, 1
+
+	for k := 0; k <= r && k <= width; k++ {
+		sum += c
+		c = c * (width - k) / (k + 1)
+	}
+
+	return sum
+}
+
+// flipBits calls f for v, and every value which differs from it in up
+// to r bits, counting from bit from.
+func flipBits(v uint64, width, from uint, r int, f func(uint64)) {
+	f(v)
+
+	if r == 0 {
+		return
+	}
+
+	for bit := from; bit < width; bit++ {
+		flipBits(v^1<<bit, width, bit+1, r-1, f)
+	}
+}
+
+// stringValues returns the strings in an array reply.
+func stringValues(reply interface{}) []string {
+	values, _ := reply.([]interface{})
+	out := make([]string, 0, len(values))
+
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+// escapeGlob escapes the characters with a special
+// meaning in SCAN patterns.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]^-\`, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,302 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"io"
+	"math/rand"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var _ imghash.Store = (*Store)(nil)
+
+func TestStore(t *testing.T) {
+	srv := newFakeServer(t)
+	ctx := context.Background()
+
+	// Compare against an in-memory index, with queries which use the
+	// buckets, and queries which fall back to a full scan.
+	for _, opts := range []*Options{nil, {Segments: 8, Prefix: "x*"}, {MaxProbes: 1, Prefix: "scan"}} {
+		s, err := New(srv.addr, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		index := imghash.NewIndex()
+		rng := rand.New(rand.NewSource(1))
+		base := rng.Uint64()
+
+		for i := 0; i < 200; i++ {
+			id := fmt.Sprintf("img%d", i%150)
+			hash := base ^ rng.Uint64()&rng.Uint64()&rng.Uint64()&rng.Uint64()
+
+			index.Add(id, hash)
+			if err := s.Add(ctx, id, hash); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 20; i++ {
+			id := fmt.Sprintf("img%d", 5*i)
+			index.Remove(id)
+			if err := s.Remove(ctx, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, distance := range []uint64{0, 3, 9, 15} {
+			want := index.Query(base, distance)
+			sort.SliceStable(want, func(i, j int) bool {
+				return want[i].Distance < want[j].Distance ||
+					want[i].Distance == want[j].Distance && want[i].Path < want[j].Path
+			})
+
+			got, err := s.Query(ctx, base, distance)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(results(got)) != fmt.Sprint(results(want)) {
+				t.Fatalf("%+v, distance %d:\n%v\nwant:\n%v", opts, distance, results(got), results(want))
+			}
+		}
+
+		s.Close()
+	}
+
+	if _, err := New(srv.addr, &Options{Segments: 3}); err != ErrSegments {
+		t.Fatalf("3 segments: %v", err)
+	}
+}
+
+// results formats a result set for comparison.
+func results(rs imghash.ResultSet) []string {
+	out := make([]string, len(rs))
+	for i, r := range rs {
+		out[i] = fmt.Sprintf("%s:%016x:%d", r.Path, r.Hash, r.Distance)
+	}
+	return out
+}
+
+// fakeServer implements the Redis commands used by Store, in memory.
+type fakeServer struct {
+	addr     string
+	mu       sync.Mutex
+	strings  map[string]string
+	sets     map[string]map[string]bool
+	versions map[string]int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	s := &fakeServer{
+		addr:     l.Addr().String(),
+		strings:  make(map[string]string),
+		sets:     make(map[string]map[string]bool),
+		versions: make(map[string]int),
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+
+	var queue [][]string
+	var multi bool
+	watched := make(map[string]int)
+
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		name := strings.ToUpper(cmd[0])
+		switch {
+		case name == "MULTI":
+			multi = true
+			w.WriteString("+OK\r\n")
+
+		case name == "EXEC":
+			s.mu.Lock()
+			ok := true
+			for key, v := range watched {
+				ok = ok && s.versions[key] == v
+			}
+
+			if ok {
+				fmt.Fprintf(w, "*%d\r\n", len(queue))
+				for _, q := range queue {
+					s.exec(w, q)
+				}
+			} else {
+				w.WriteString("*-1\r\n")
+			}
+			s.mu.Unlock()
+
+			queue, multi = nil, false
+			watched = make(map[string]int)
+
+		case name == "WATCH":
+			s.mu.Lock()
+			watched[cmd[1]] = s.versions[cmd[1]]
+			s.mu.Unlock()
+			w.WriteString("+OK\r\n")
+
+		case multi:
+			queue = append(queue, cmd)
+			w.WriteString("+QUEUED\r\n")
+
+		default:
+			s.mu.Lock()
+			s.exec(w, cmd)
+			s.mu.Unlock()
+		}
+
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+// exec runs a single command, with the lock held.
+func (s *fakeServer) exec(w *bufio.Writer, cmd []string) {
+	array := func(values []string) {
+		fmt.Fprintf(w, "*%d\r\n", len(values))
+		for _, v := range values {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+		}
+	}
+
+	switch strings.ToUpper(cmd[0]) {
+	case "GET":
+		if v, ok := s.strings[cmd[1]]; ok {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+		} else {
+			w.WriteString("$-1\r\n")
+		}
+
+	case "SET":
+		s.strings[cmd[1]] = cmd[2]
+		s.versions[cmd[1]]++
+		w.WriteString("+OK\r\n")
+
+	case "DEL":
+		delete(s.strings, cmd[1])
+		s.versions[cmd[1]]++
+		w.WriteString(":1\r\n")
+
+	case "MGET":
+		fmt.Fprintf(w, "*%d\r\n", len(cmd)-1)
+		for _, key := range cmd[1:] {
+			if v, ok := s.strings[key]; ok {
+				fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				w.WriteString("$-1\r\n")
+			}
+		}
+
+	case "SADD", "SREM":
+		set := s.sets[cmd[1]]
+		if set == nil {
+			set = make(map[string]bool)
+			s.sets[cmd[1]] = set
+		}
+
+		for _, m := range cmd[2:] {
+			set[m] = cmd[0] == "SADD"
+		}
+		w.WriteString(":1\r\n")
+
+	case "SUNION":
+		var out []string
+		seen := make(map[string]bool)
+		for _, key := range cmd[1:] {
+			for m, ok := range s.sets[key] {
+				if ok && !seen[m] {
+					seen[m] = true
+					out = append(out, m)
+				}
+			}
+		}
+		array(out)
+
+	case "SCAN":
+		var keys []string
+		for key := range s.strings {
+			if ok, _ := path.Match(cmd[3], key); ok {
+				keys = append(keys, key)
+			}
+		}
+		w.WriteString("*2\r\n$1\r\n0\r\n")
+		array(keys)
+
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", cmd[0])
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := make([]string, n)
+	for i := range cmd {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		cmd[i] = string(buf[:size])
+	}
+
+	return cmd, nil
+}
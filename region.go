@@ -0,0 +1,49 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+)
+
+// Region returns the part of img inside rect, without copying any
+// pixels. This hashes a part of a larger image, like a photo on a
+// scanned page or a frame in a contact sheet:
+//
+//	hash := Average(Region(page, photo))
+//
+// Like SubImage, the returned image keeps the coordinates of img: its
+// bounds are rect, clipped to those of img, rather than starting at
+// (0, 0). Images with a SubImage method, which includes all concrete
+// image types in the standard library, are sliced with it. The hashers
+// keep their fast paths for those. Other images are wrapped in a view.
+func Region(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Intersect(img.Bounds())
+
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	return &regionImage{img, rect}
+}
+
+// regionImage is the part of img inside rect.
+type regionImage struct {
+	img  image.Image
+	rect image.Rectangle
+}
+
+func (r *regionImage) ColorModel() color.Model { return r.img.ColorModel() }
+func (r *regionImage) Bounds() image.Rectangle { return r.rect }
+
+func (r *regionImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}).In(r.rect) {
+		return r.img.ColorModel().Convert(color.Transparent)
+	}
+
+	return r.img.At(x, y)
+}
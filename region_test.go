@@ -0,0 +1,140 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"testing"
+)
+
+// Image types with fast paths, or which take the generic path.
+var boundsTypes = []string{"rgba", "rgba64", "nrgba", "gray", "gray16", "ycbcr", "paletted", "cmyk", "generic"}
+
+func TestShiftedBounds(t *testing.T) {
+	src := synth.Shapes(70, 50, 1)
+	size := src.Rect.Size()
+
+	hashers := map[string]HashFunc{
+		"average":    Average,
+		"weighted":   AverageWith(&AverageOptions{CenterWeight: 2, Trim: 0.1}),
+		"median":     AverageWith(&AverageOptions{Median: true}),
+		"mask":       AverageMask(ExcludeRects(image.Rect(0, 0, 8, 8), image.Rect(0, 0, 3, 2))),
+		"screenshot": Screenshot(nil),
+		"1024":       func(img image.Image) uint64 { h := Average1024(img); return h[3] ^ h[7] ^ h[12] },
+		"equalize":   Preprocess(Average, Equalize),
+		"stretch":    Preprocess(Average, Stretch(0.01)),
+		"binarize":   Preprocess(Average, Binarize),
+		"deskew":     Preprocess(Average, Deskew(5)),
+		"crop":       Preprocess(Average, CenterCrop),
+		"pad":        Preprocess(Average, PadSquare(color.White)),
+		"composite":  Preprocess(Average, Composite(color.White)),
+		"alpha":      Preprocess(Average, IgnoreAlpha),
+		"blur":       Preprocess(Average, Blur(1)),
+	}
+
+	// Offsets are even, so subsampled chroma lines up the same way.
+	shifted := image.Rectangle{image.Pt(-14, 22), image.Pt(-14, 22).Add(size)}
+	at := image.Pt(6, 8)
+
+	for _, kind := range boundsTypes {
+		base := convertTo(kind, src, image.Rectangle{Max: size})
+		moved := convertTo(kind, src, shifted)
+
+		// The same pixels, in the middle of a larger, noisy canvas.
+		canvas := synth.Noise(size.X+30, size.Y+40, 2)
+		draw.Draw(canvas, image.Rectangle{at, at.Add(size)}, src, image.Point{}, draw.Src)
+		sub := Region(convertTo(kind, canvas, canvas.Rect), image.Rectangle{at, at.Add(size)})
+
+		for name, hf := range hashers {
+			want := hf(base)
+			if got := hf(moved); got != want {
+				t.Errorf("%s, %s: shifted bounds hash to 0x%x, want 0x%x", kind, name, got, want)
+			}
+
+			if got := hf(sub); got != want {
+				t.Errorf("%s, %s: sub-image hashes to 0x%x, want 0x%x", kind, name, got, want)
+			}
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	img := synth.Shapes(64, 48, 3)
+	rect := image.Rect(10, 5, 40, 48)
+
+	sub := Region(img, rect)
+	if sub.Bounds() != rect {
+		t.Fatalf("bounds %v, want %v", sub.Bounds(), rect)
+	}
+
+	if r := sub.(*image.RGBA); &r.Pix[0] != &img.Pix[img.PixOffset(10, 5)] {
+		t.Fatal("pixels were copied")
+	}
+
+	view := Region(struct{ image.Image }{img}, image.Rect(10, 5, 40, 100))
+	if view.Bounds() != rect {
+		t.Fatalf("view bounds %v, want %v", view.Bounds(), rect)
+	}
+
+	if Average(view) != Average(sub) {
+		t.Fatal("view and sub-image hash differently")
+	}
+
+	if _, _, _, a := view.At(0, 0).RGBA(); a != 0 {
+		t.Fatal("view shows pixels outside of its bounds")
+	}
+}
+
+// convertTo returns a copy of src, starting at its origin, as an image
+// of the given kind with the given bounds.
+func convertTo(kind string, src image.Image, rect image.Rectangle) image.Image {
+	var dst draw.Image
+
+	switch kind {
+	case "rgba", "generic":
+		dst = image.NewRGBA(rect)
+	case "rgba64":
+		dst = image.NewRGBA64(rect)
+	case "nrgba":
+		dst = image.NewNRGBA(rect)
+	case "gray":
+		dst = image.NewGray(rect)
+	case "gray16":
+		dst = image.NewGray16(rect)
+	case "paletted":
+		dst = image.NewPaletted(rect, palette.Plan9)
+	case "cmyk":
+		dst = image.NewCMYK(rect)
+	case "ycbcr":
+		m := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+		sr := src.Bounds()
+
+		var x, y int
+		for y = rect.Min.Y; y < rect.Max.Y; y++ {
+			for x = rect.Min.X; x < rect.Max.X; x++ {
+				c := color.YCbCrModel.Convert(src.At(sr.Min.X+x-rect.Min.X, sr.Min.Y+y-rect.Min.Y)).(color.YCbCr)
+				m.Y[m.YOffset(x, y)] = c.Y
+
+				// The top left pixel of each block sets its chroma.
+				if (x-rect.Min.X)%2 == 0 && (y-rect.Min.Y)%2 == 0 {
+					m.Cb[m.COffset(x, y)], m.Cr[m.COffset(x, y)] = c.Cb, c.Cr
+				}
+			}
+		}
+
+		return m
+	}
+
+	draw.Draw(dst, rect, src, src.Bounds().Min, draw.Src)
+
+	if kind == "generic" {
+		return struct{ image.Image }{dst}
+	}
+
+	return dst
+}
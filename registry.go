@@ -0,0 +1,94 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownAlgorithm is returned by LookupAlgorithm for names no
+// algorithm is registered under.
+var ErrUnknownAlgorithm = errors.New("imghash: unknown algorithm")
+
+// An Algorithm pairs a hash function with the thresholds to classify
+// its distances by.
+type Algorithm struct {
+	Hash       HashFunc
+	Thresholds Thresholds
+
+	// Capabilities describe what the hashes hold up to, so programs
+	// can pick an algorithm for their requirements with MatchAlgorithms.
+	// Algorithms which leave them out are taken to hold up to nothing.
+	Capabilities Capabilities
+}
+
+// A Factory creates an Algorithm. It is called once for every lookup,
+// so algorithms may hold state of their own.
+type Factory func() *Algorithm
+
+var (
+	algorithmMu sync.RWMutex
+	algorithms  = map[string]Factory{
+		"average":    func() *Algorithm { return &Algorithm{Average, AverageThresholds, AverageCapabilities} },
+		"document":   func() *Algorithm { return &Algorithm{Document, DocumentThresholds, DocumentCapabilities} },
+		"screenshot": func() *Algorithm { return &Algorithm{Screenshot(nil), ScreenshotThresholds, ScreenshotCapabilities} },
+		"fastest":    Fastest.Algorithm,
+		"balanced":   Balanced.Algorithm,
+		"robust":     Robust.Algorithm,
+	}
+)
+
+// Register makes an algorithm available by name, to LookupAlgorithm and
+// everything built on it: the -a option of the CLI and imghashd, the
+// algorithm of configuration files, and the algorithm recorded in an
+// index. Names are not case sensitive. Registering a name again replaces
+// the earlier factory, which allows replacing the built-in algorithms:
+// average, document and screenshot, and the presets fastest, balanced and
+// robust.
+//
+// Packages with hashes of their own register them from an init function,
+// as ximage does for decoders. A blank import then adds them to a program:
+//
+//	func init() {
+//		imghash.Register("inhouse", func() *imghash.Algorithm {
+//			return &imghash.Algorithm{Hash: inhouse.Hash, Thresholds: inhouse.Thresholds}
+//		})
+//	}
+func Register(name string, factory Factory) {
+	algorithmMu.Lock()
+	algorithms[strings.ToLower(name)] = factory
+	algorithmMu.Unlock()
+}
+
+// LookupAlgorithm returns a new instance of the algorithm registered
+// under the given name.
+func LookupAlgorithm(name string) (*Algorithm, error) {
+	algorithmMu.RLock()
+	factory, ok := algorithms[strings.ToLower(name)]
+	algorithmMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAlgorithm, name)
+	}
+
+	return factory(), nil
+}
+
+// Algorithms returns the names of all registered algorithms, sorted.
+func Algorithms() []string {
+	algorithmMu.RLock()
+	defer algorithmMu.RUnlock()
+
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
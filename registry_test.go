@@ -0,0 +1,81 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	var made int
+	Register("Test-Constant", func() *Algorithm {
+		made++
+		return &Algorithm{func(image.Image) uint64 { return 42 }, Thresholds{1, 2}, Capabilities{}}
+	})
+
+	a, err := LookupAlgorithm("test-constant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Hash(nil) != 42 || a.Thresholds.NearDuplicate != 2 {
+		t.Fatalf("algorithm %+v", a)
+	}
+
+	// Each lookup creates a new instance.
+	LookupAlgorithm("TEST-CONSTANT")
+	if made != 2 {
+		t.Fatalf("factory called %d times", made)
+	}
+
+	var found bool
+	for _, name := range Algorithms() {
+		found = found || name == "test-constant"
+	}
+
+	if !found {
+		t.Fatalf("algorithms %v", Algorithms())
+	}
+
+	if a, err := LookupAlgorithm("Average"); err != nil || a.Thresholds != AverageThresholds {
+		t.Fatalf("average: %+v, %v", a, err)
+	}
+
+	if _, err := LookupAlgorithm("missing"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("missing algorithm: %v", err)
+	}
+}
+
+func TestMatchAlgorithms(t *testing.T) {
+	req, err := ParseCapabilities("scale=full, color")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req != (Capabilities{Scale: RobustFull, Color: RobustPartial}) {
+		t.Fatalf("parsed %v", req)
+	}
+
+	if s := req.String(); s != "scale=full,rotation=none,crop=none,color=partial" {
+		t.Fatalf("string %q", s)
+	}
+
+	// The least robust match comes first.
+	if got := MatchAlgorithms(req); strings.Join(got, " ") != "average balanced fastest robust screenshot document" {
+		t.Fatalf("matches %v", got)
+	}
+
+	if got := MatchAlgorithms(Capabilities{Rotation: RobustPartial}); strings.Join(got, " ") != "document" {
+		t.Fatalf("rotation matches %v", got)
+	}
+
+	for _, s := range []string{"scale=most", "shear"} {
+		if _, err := ParseCapabilities(s); err == nil {
+			t.Fatalf("parsed %q", s)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/jteeuwen/imghash"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"sort"
+)
+
+// GalleryOptions configure WriteGallery.
+type GalleryOptions struct {
+	// Title of the page. Defaults to "Matched images".
+	Title string
+
+	// How the hashes were computed, and the thresholds to hold their
+	// distances against, as for imghash.Decide.
+	Decision imghash.DecisionOptions
+
+	// Hashes the tiles of the heatmaps. Defaults to imghash.Average;
+	// use the hash function the groups were found with.
+	HashFunc imghash.HashFunc
+
+	// Longest side of the thumbnails, in pixels. Defaults to 192.
+	Size int
+
+	// Maximum number of pairs to show. The others are only counted.
+	// 0 shows all of them.
+	MaxPairs int
+}
+
+// A galleryPair is a member of a group, shown next to the first.
+type galleryPair struct {
+	Path     string
+	Decision *imghash.Decision
+	Thumb    template.URL // Thumbnail, with the heatmap painted over it.
+	Err      string       // Why the image could not be shown.
+}
+
+// A galleryGroup is a group of matched images.
+type galleryGroup struct {
+	Path  string
+	Hash  string
+	Thumb template.URL
+	Err   string
+	Pairs []*galleryPair
+	max   uint64 // Largest distance of a pair.
+}
+
+// galleryPage is the data of the gallery template.
+type galleryPage struct {
+	Title      string
+	Algorithm  string
+	Profile    string
+	Thresholds imghash.Thresholds
+	Groups     []*galleryGroup // Groups shown.
+	Total      int             // Number of groups.
+	Pairs      int             // Number of pairs.
+	Shown      int             // Number of pairs shown.
+	Verdicts   map[string]int
+	Histogram  []int // Number of pairs at each distance.
+}
+
+// WriteGallery writes an HTML page which shows the groups of matched
+// images, as returned by imghash.DedupeFiles, for people to check what
+// the matcher takes as copies before its thresholds go to production.
+//
+// Each member of a group is shown next to the first, with the grounds
+// of the decision: the distance between their hashes, the verdict and
+// the threshold which decided it, the cells of the hashes which differ,
+// and a heatmap of the regions of the image which differ most. Groups
+// with the largest distances come first, as those most need a look.
+// Thumbnails are embedded in the page, so it can be passed on as it is.
+// Images which can not be decoded are shown by the error instead.
+func WriteGallery(w io.Writer, groups [][]*imghash.Entry, opts *GalleryOptions) error {
+	var o GalleryOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	if len(o.Title) == 0 {
+		o.Title = "Matched images"
+	}
+
+	if o.HashFunc == nil {
+		o.HashFunc = imghash.Average
+	}
+
+	if o.Size <= 0 {
+		o.Size = 192
+	}
+
+	o.Decision.Diff = true
+
+	page := &galleryPage{Title: o.Title, Verdicts: make(map[string]int)}
+
+	for _, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+
+		gg := &galleryGroup{Path: g[0].Path, Hash: imghash.FormatHash(g[0].Hash)}
+		for _, e := range g[1:] {
+			d, err := imghash.Decide(g[0].Hash, e.Hash, &o.Decision)
+			if err != nil {
+				return err
+			}
+
+			gg.Pairs = append(gg.Pairs, &galleryPair{Path: e.Path, Decision: d})
+			if d.Distance > gg.max {
+				gg.max = d.Distance
+			}
+
+			page.Pairs++
+			page.Verdicts[d.Verdict.String()]++
+			page.Algorithm, page.Profile, page.Thresholds = d.Algorithm, d.Profile, d.Thresholds
+
+			for len(page.Histogram) <= int(d.Distance) {
+				page.Histogram = append(page.Histogram, 0)
+			}
+			page.Histogram[d.Distance]++
+		}
+
+		sort.SliceStable(gg.Pairs, func(i, j int) bool {
+			return gg.Pairs[i].Decision.Distance > gg.Pairs[j].Decision.Distance
+		})
+
+		page.Groups = append(page.Groups, gg)
+	}
+
+	sort.SliceStable(page.Groups, func(i, j int) bool { return page.Groups[i].max > page.Groups[j].max })
+	page.Total = len(page.Groups)
+
+	// Decode the images of the pairs shown, one group at a time.
+	shown := page.Groups[:0]
+	for _, gg := range page.Groups {
+		if o.MaxPairs > 0 && page.Shown >= o.MaxPairs {
+			break
+		}
+
+		if n := o.MaxPairs - page.Shown; o.MaxPairs > 0 && len(gg.Pairs) > n {
+			gg.Pairs = gg.Pairs[:n]
+		}
+
+		first, err := imghash.DecodeFile(gg.Path)
+		if err != nil {
+			gg.Err = err.Error()
+		} else {
+			gg.Thumb = thumbnail(first, nil, o.Size)
+		}
+
+		for _, p := range gg.Pairs {
+			img, err := imghash.DecodeFile(p.Path)
+			switch {
+			case err != nil:
+				p.Err = err.Error()
+			case first == nil:
+				p.Thumb = thumbnail(img, nil, o.Size)
+			default:
+				p.Thumb = thumbnail(img, imghash.CompareTiles(first, img, 8, 8, o.HashFunc), o.Size)
+			}
+		}
+
+		page.Shown += len(gg.Pairs)
+		shown = append(shown, gg)
+	}
+
+	page.Groups = shown
+	return htmlGallery.Execute(w, page)
+}
+
+// thumbnail returns a JPEG thumbnail of the image as a data URL, with
+// the heatmap painted over it, if there is one.
+func thumbnail(img image.Image, h *imghash.Heatmap, size int) template.URL {
+	b := img.Bounds()
+	w, ht := b.Dx(), b.Dy()
+	if w == 0 || ht == 0 {
+		return ""
+	}
+
+	if w > size || ht > size {
+		if w >= ht {
+			w, ht = size, max(ht*size/w, 1)
+		} else {
+			w, ht = max(w*size/ht, 1), size
+		}
+		img = imghash.Scale(img, w, ht, imghash.KernelBox)
+	}
+
+	if h != nil {
+		img = h.Overlay(img)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return ""
+	}
+
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+var htmlGallery = template.Must(template.New("gallery").Funcs(template.FuncMap{
+	// cells returns which cells of a row of a diff differ.
+	"cells": func(row string) []bool {
+		cells := make([]bool, len(row))
+		for i := range row {
+			cells[i] = row[i] == 'x'
+		}
+		return cells
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.pair td { width: 210px; }
+.diff { border-collapse: collapse; margin: 0; }
+.diff td { width: 8px; height: 8px; padding: 0; border: 1px solid #eee; }
+.diff td.x { background: #d00; }
+.duplicate { background: #e6f4e6; }
+.near-duplicate { background: #fff4d6; }
+.distinct { background: #fde6e6; }
+.path { font-size: small; word-break: break-all; }
+.error { color: #a00; font-size: small; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Pairs}} pairs in {{.Total}} groups{{if lt .Shown .Pairs}}, of which {{.Shown}} are shown{{end}}.
+Hashes computed with {{.Algorithm}}{{if .Profile}}, after {{.Profile}}{{end}}.
+Duplicates within {{.Thresholds.Duplicate}}, near-duplicates within {{.Thresholds.NearDuplicate}}.</p>
+<table>
+<tr><th>Verdict</th><th>Pairs</th></tr>
+{{range $v, $n := .Verdicts}}<tr class="{{$v}}"><td>{{$v}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Distance</th>{{range $d, $n := .Histogram}}{{if $n}}<td>{{$d}}</td>{{end}}{{end}}</tr>
+<tr><th>Pairs</th>{{range .Histogram}}{{if .}}<td>{{.}}</td>{{end}}{{end}}</tr>
+</table>
+{{range $i, $g := .Groups}}
+<h2>Group {{$i}}</h2>
+<table>
+<tr class="pair"><td>{{if $g.Thumb}}<img src="{{$g.Thumb}}">{{else}}<span class="error">{{$g.Err}}</span>{{end}}
+<div class="path">{{$g.Path}}</div><div>{{$g.Hash}}</div></td>
+{{range $g.Pairs}}{{with .Decision}}<td class="{{.Verdict}}">{{end}}{{if .Thumb}}<img src="{{.Thumb}}">{{else}}<span class="error">{{.Err}}</span>{{end}}
+<div class="path">{{.Path}}</div>{{with .Decision}}
+<div>{{printf "%016x" .B}}: {{.Verdict}} at distance {{.Distance}}, threshold {{.Threshold}}</div>
+<table class="diff">{{range .Diff}}<tr>{{range cells .}}<td{{if .}} class="x"{{end}}></td>{{end}}</tr>{{end}}</table>{{end}}</td>
+{{end}}</tr>
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,409 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package report turns groups of duplicate images into a report, which
+says which file of each group to keep, and what to do with the others.
+
+A Policy picks the file to keep. Policies are compared in order, so
+later ones only break ties of earlier ones. A plan of actions can be
+added, which deletes the other files, or replaces them with hardlinks
+to the kept file. Nothing is changed until the actions are applied:
+
+	groups := imghash.DedupeFiles(files, imghash.Average, 5, nil)
+	files, err := report.Stat(groups)
+	...
+	r := report.New(files, report.Chain(
+		report.PreferDirectory("/photos/originals"),
+		report.LargestResolution,
+		report.OldestModTime,
+	))
+
+	r.Plan(report.Hardlink)
+	r.WriteHTML(fd)
+
+	for _, a := range r.Actions {
+		if err := a.Apply(); err != nil {
+			...
+		}
+	}
+*/
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A File is a member of a group of duplicates.
+type File struct {
+	Path          string
+	Hash          uint64
+	Width, Height int
+	Size          int64
+	ModTime       time.Time
+
+	info os.FileInfo
+}
+
+// Pixels returns the number of pixels in the image.
+func (f *File) Pixels() int {
+	return f.Width * f.Height
+}
+
+// Stat reads the size, modification time and dimensions of all files in
+// the given groups, as returned by imghash.DedupeFiles. Dimensions are
+// read by decoding each image, so all formats supported by imghash.Decode
+// work.
+func Stat(groups [][]*imghash.Entry) ([][]*File, error) {
+	out := make([][]*File, len(groups))
+
+	for i, g := range groups {
+		out[i] = make([]*File, len(g))
+
+		for j, e := range g {
+			f, err := statFile(e.Path, e.Hash)
+			if err != nil {
+				return nil, err
+			}
+
+			out[i][j] = f
+		}
+	}
+
+	return out, nil
+}
+
+// statFile reads the information for a single file.
+func statFile(path string, hash uint64) (*File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := imghash.DecodeFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("report: %s: %v", path, err)
+	}
+
+	b := img.Bounds()
+	return &File{
+		Path:    path,
+		Hash:    hash,
+		Width:   b.Dx(),
+		Height:  b.Dy(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		info:    info,
+	}, nil
+}
+
+// A Policy compares two files of a group. It returns a negative value
+// if a should rather be kept than b, a positive value if b should rather
+// be kept, and 0 if it has no preference.
+type Policy func(a, b *File) int
+
+// Chain returns a policy which asks each of the given policies in order,
+// until one of them has a preference.
+func Chain(policies ...Policy) Policy {
+	return func(a, b *File) int {
+		for _, p := range policies {
+			if c := p(a, b); c != 0 {
+				return c
+			}
+		}
+
+		return 0
+	}
+}
+
+// LargestResolution prefers the image with the most pixels.
+func LargestResolution(a, b *File) int {
+	return compare(int64(b.Pixels()), int64(a.Pixels()))
+}
+
+// LargestFile prefers the largest file. Among images of the same
+// resolution, this is usually the one with the least compression.
+func LargestFile(a, b *File) int {
+	return compare(b.Size, a.Size)
+}
+
+// OldestModTime prefers the file which was modified the longest ago.
+// This is usually the original, rather than a copy.
+func OldestModTime(a, b *File) int {
+	return compare(a.ModTime.UnixNano(), b.ModTime.UnixNano())
+}
+
+// PreferDirectory returns a policy which prefers files in the given
+// directories, or below them. Directories listed first are preferred
+// over later ones.
+func PreferDirectory(dirs ...string) Policy {
+	rank := func(f *File) int64 {
+		path := filepath.Clean(f.Path)
+
+		for i, dir := range dirs {
+			dir = filepath.Clean(dir)
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				return int64(i)
+			}
+		}
+
+		return int64(len(dirs))
+	}
+
+	return func(a, b *File) int {
+		return compare(rank(a), rank(b))
+	}
+}
+
+// compare returns -1, 0 or 1 if a is less than, equal to,
+// or greater than b.
+func compare(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// An Op is the action taken for a duplicate.
+type Op int
+
+// Known actions.
+const (
+	Delete   Op = iota // Delete the file.
+	Hardlink           // Replace the file with a hardlink to the kept file.
+)
+
+func (op Op) String() string {
+	switch op {
+	case Delete:
+		return "delete"
+	case Hardlink:
+		return "hardlink"
+	}
+
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// An Action removes a single duplicate.
+type Action struct {
+	Op   Op
+	Path string // The duplicate.
+	Keep string // The file kept in its place.
+}
+
+// Apply performs the action. Hardlinks are created under a temporary
+// name next to the duplicate, and renamed over it. This only works if
+// both files are on the same file system.
+func (a *Action) Apply() error {
+	switch a.Op {
+	case Delete:
+		return os.Remove(a.Path)
+
+	case Hardlink:
+		tmp := a.Path + ".imghash-link"
+		if err := os.Link(a.Keep, tmp); err != nil {
+			return err
+		}
+
+		if err := os.Rename(tmp, a.Path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("report: unknown action %v", a.Op)
+}
+
+func (a *Action) String() string {
+	return fmt.Sprintf("%s %s (keep %s)", a.Op, a.Path, a.Keep)
+}
+
+// A Group is a single group of duplicates.
+type Group struct {
+	Keep       *File   // The file to keep.
+	Duplicates []*File // The other files, sorted by path.
+}
+
+// A Report lists the file to keep of each group, and the
+// planned actions for the others.
+type Report struct {
+	Groups  []*Group
+	Actions []*Action
+}
+
+// New creates a report for the given groups. The file to keep is chosen
+// by the policy; ties are broken by path. Policy may be nil, to prefer
+// the largest resolution, and then the oldest file. The groups themselves
+// are not modified.
+func New(groups [][]*File, policy Policy) *Report {
+	if policy == nil {
+		policy = Chain(LargestResolution, OldestModTime)
+	}
+
+	r := &Report{Groups: make([]*Group, 0, len(groups))}
+
+	for _, g := range groups {
+		if len(g) == 0 {
+			continue
+		}
+
+		files := append([]*File(nil), g...)
+		sort.SliceStable(files, func(i, j int) bool {
+			if c := policy(files[i], files[j]); c != 0 {
+				return c < 0
+			}
+			return files[i].Path < files[j].Path
+		})
+
+		dups := files[1:]
+		sort.Slice(dups, func(i, j int) bool { return dups[i].Path < dups[j].Path })
+		r.Groups = append(r.Groups, &Group{Keep: files[0], Duplicates: dups})
+	}
+
+	return r
+}
+
+// Plan sets the report's actions to apply op to every duplicate. For
+// Hardlink, files which already are hardlinks of the kept file are left
+// out. It returns the actions.
+func (r *Report) Plan(op Op) []*Action {
+	r.Actions = nil
+
+	for _, g := range r.Groups {
+		for _, f := range g.Duplicates {
+			if op == Hardlink && f.info != nil && g.Keep.info != nil && os.SameFile(f.info, g.Keep.info) {
+				continue
+			}
+
+			r.Actions = append(r.Actions, &Action{Op: op, Path: f.Path, Keep: g.Keep.Path})
+		}
+	}
+
+	return r.Actions
+}
+
+// Plain representations of the report, for encoding.
+type (
+	jsonFile struct {
+		Path    string    `json:"path"`
+		Hash    string    `json:"hash"`
+		Width   int       `json:"width"`
+		Height  int       `json:"height"`
+		Size    int64     `json:"size"`
+		ModTime time.Time `json:"mtime"`
+	}
+
+	jsonGroup struct {
+		Keep       *jsonFile   `json:"keep"`
+		Duplicates []*jsonFile `json:"duplicates"`
+	}
+
+	jsonAction struct {
+		Op   string `json:"action"`
+		Path string `json:"path"`
+		Keep string `json:"keep"`
+	}
+
+	jsonReport struct {
+		Groups  []*jsonGroup  `json:"groups"`
+		Actions []*jsonAction `json:"actions"`
+	}
+)
+
+// plain returns the report in its plain representation.
+func (r *Report) plain() *jsonReport {
+	file := func(f *File) *jsonFile {
+		return &jsonFile{f.Path, fmt.Sprintf("%016x", f.Hash), f.Width, f.Height, f.Size, f.ModTime}
+	}
+
+	out := &jsonReport{
+		Groups:  make([]*jsonGroup, len(r.Groups)),
+		Actions: make([]*jsonAction, len(r.Actions)),
+	}
+
+	for i, g := range r.Groups {
+		jg := &jsonGroup{Keep: file(g.Keep), Duplicates: make([]*jsonFile, len(g.Duplicates))}
+		for j, f := range g.Duplicates {
+			jg.Duplicates[j] = file(f)
+		}
+		out.Groups[i] = jg
+	}
+
+	for i, a := range r.Actions {
+		out.Actions[i] = &jsonAction{a.Op.String(), a.Path, a.Keep}
+	}
+
+	return out
+}
+
+// WriteJSON writes the report to w, as a single JSON object. Hashes
+// are written as hexadecimal strings, as JSON numbers can not hold
+// 64 bit integers reliably.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.plain())
+}
+
+// WriteHTML writes the report to w, as a standalone HTML page with a
+// table per group. Images are shown through their paths, so the page
+// works best when opened from the machine which holds the files.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r.plain())
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"url": func(path string) template.URL {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		return template.URL("file://" + filepath.ToSlash(path))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Duplicate images</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+img { max-width: 160px; max-height: 160px; }
+.keep { background: #e6f4e6; }
+</style>
+</head>
+<body>
+<h1>Duplicate images</h1>
+<p>{{len .Groups}} groups, {{len .Actions}} planned actions.</p>
+{{range $i, $g := .Groups}}
+<h2>Group {{$i}}</h2>
+<table>
+<tr><th></th><th>Path</th><th>Hash</th><th>Size</th><th>Modified</th><th></th></tr>
+{{with $g.Keep}}<tr class="keep"><td><img src="{{url .Path}}"></td><td>{{.Path}}</td><td>{{.Hash}}</td><td>{{.Width}}x{{.Height}}, {{.Size}} bytes</td><td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td><td>keep</td></tr>{{end}}
+{{range $g.Duplicates}}<tr><td><img src="{{url .Path}}"></td><td>{{.Path}}</td><td>{{.Hash}}</td><td>{{.Width}}x{{.Height}}, {{.Size}} bytes</td><td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td><td>duplicate</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Actions}}<h2>Planned actions</h2>
+<table>
+<tr><th>Action</th><th>Path</th><th>Kept file</th></tr>
+{{range .Actions}}<tr><td>{{.Op}}</td><td>{{.Path}}</td><td>{{.Keep}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,182 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	// Three copies of an image: the largest, the oldest, and one
+	// in the preferred directory.
+	large := writeImage(t, filepath.Join(dir, "a", "large.png"), 64, now)
+	old := writeImage(t, filepath.Join(dir, "b", "old.png"), 32, now.Add(-time.Hour))
+	pref := writeImage(t, filepath.Join(dir, "keep", "pref.png"), 32, now)
+
+	groups, err := Stat([][]*imghash.Entry{{
+		{Path: large, Hash: 1},
+		{Path: old, Hash: 1},
+		{Path: pref, Hash: 3},
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f := groups[0][0]; f.Width != 64 || f.Height != 64 || f.Size == 0 {
+		t.Fatalf("stat %+v", f)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		keep   string
+	}{
+		{"default", nil, large},
+		{"oldest", OldestModTime, old},
+		{"directory", PreferDirectory(filepath.Join(dir, "keep")), pref},
+		{"chain", Chain(PreferDirectory(filepath.Join(dir, "x")), OldestModTime), old},
+		{"tie", func(a, b *File) int { return 0 }, large},
+	} {
+		r := New(groups, tc.policy)
+		if g := r.Groups[0]; g.Keep.Path != tc.keep || len(g.Duplicates) != 2 {
+			t.Fatalf("%s: keep %s, want %s", tc.name, g.Keep.Path, tc.keep)
+		}
+	}
+
+	r := New(groups, nil)
+	actions := r.Plan(Hardlink)
+	if len(actions) != 2 || actions[0].Path != old || actions[0].Keep != large {
+		t.Fatalf("plan %v", actions)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Groups[0].Keep.Hash != "0000000000000001" || doc.Actions[1].Op != "hardlink" {
+		t.Fatalf("json %s", buf.Bytes())
+	}
+
+	buf.Reset()
+	if err := r.WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "<td>hardlink</td><td>"+pref+"</td>") {
+		t.Fatalf("html %s", buf.Bytes())
+	}
+
+	// Nothing has changed until the actions are applied.
+	for _, a := range actions {
+		if err := a.Apply(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The links are left out of a new plan.
+	if groups, err = Stat([][]*imghash.Entry{{{Path: large}, {Path: old}, {Path: pref}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if actions := New(groups, nil).Plan(Hardlink); len(actions) != 0 {
+		t.Fatalf("plan after linking %v", actions)
+	}
+
+	actions = New(groups, nil).Plan(Delete)
+	for _, a := range actions {
+		if err := a.Apply(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("deleted file: %v", err)
+	}
+
+	if _, err := os.Stat(large); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeImage writes a square PNG file, with the given modification time.
+func writeImage(t *testing.T, file string, size int, mtime time.Time) string {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size)))
+
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestGallery(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	a := writeImage(t, filepath.Join(dir, "a.png"), 400, now)
+	b := writeImage(t, filepath.Join(dir, "b.png"), 64, now)
+	c := writeImage(t, filepath.Join(dir, "c.png"), 64, now)
+
+	groups := [][]*imghash.Entry{
+		{{Path: a, Hash: 0}, {Path: b, Hash: 1}},
+		{{Path: c, Hash: 0}, {Path: filepath.Join(dir, "missing.png"), Hash: 1<<5 - 1}, {Path: b, Hash: 0}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGallery(&buf, groups, &GalleryOptions{Title: "Audit", MaxPairs: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	page := buf.String()
+	for _, s := range []string{
+		"<title>Audit</title>",
+		"3 pairs in 2 groups, of which 2 are shown",
+		"near-duplicate at distance 5, threshold 9",
+		"duplicate at distance 0, threshold 3",
+		`src="data:image/jpeg;base64,`,
+		`<td class="x">`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("gallery lacks %q", s)
+		}
+	}
+
+	// The group with the largest distance comes first, and the pairs
+	// past the maximum are left out.
+	if i, j := strings.Index(page, "missing.png"), strings.Index(page, "a.png"); i < 0 || j >= 0 {
+		t.Errorf("groups shown out of order, or too many pairs shown")
+	}
+
+	if strings.Count(page, `src="data:image/jpeg;base64,`) != 2 {
+		t.Errorf("expected 2 thumbnails, for c.png and b.png")
+	}
+}
@@ -0,0 +1,157 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A Signal is something other than its hash which decides how high a hit
+// should rank, like its upload time, its resolution or the trust in its
+// source. Value returns it for a hit, higher values ranking higher, or
+// NaN if the hit lacks it.
+type Signal struct {
+	Value  func(h *Hit) float64
+	Weight float64
+}
+
+// A Reranking orders the hits of a query by a score which combines their
+// Hamming Distance with other signals. Where a Ranking only refines the
+// order by distance, a Reranking replaces it, for the order a product
+// wants to show the hits in.
+type Reranking struct {
+	// Score returns the score of every hit, by index; hits with higher
+	// scores come first. It sees all hits at once, so it can compare
+	// them. If nil, the hits are scored by the fields below.
+	Score func(hits Hits) []float64
+
+	// Weight of the Hamming Distance, which lowers the score.
+	Distance float64
+
+	// Signals to add to the score. Each one, and the distance, is
+	// scaled to the range 0-1 over the hits before it is weighted,
+	// so weights compare signals in any unit: the hit with the
+	// lowest value gets 0, the one with the highest 1. Hits which
+	// lack a signal get 0 for it.
+	Signals []Signal
+}
+
+// Rerank sorts the hits with the given Reranking. Hits with the same
+// score are sorted by Hamming Distance, then by ID. A nil Reranking
+// sorts as Sort does. The hits are no longer sorted by distance, which
+// Within needs, so call it last.
+func (h Hits) Rerank(r *Reranking) {
+	if r == nil {
+		h.Sort()
+		return
+	}
+
+	var scores []float64
+	if r.Score != nil {
+		scores = r.Score(h)
+	} else {
+		scores = r.score(h)
+	}
+
+	type scored struct {
+		hit   Hit
+		score float64
+	}
+
+	ss := make([]scored, len(h))
+	for i := range h {
+		ss[i] = scored{h[i], scores[i]}
+	}
+
+	sort.SliceStable(ss, func(i, j int) bool {
+		a, b := &ss[i], &ss[j]
+		switch {
+		case a.score != b.score:
+			return a.score > b.score
+		case a.hit.Distance != b.hit.Distance:
+			return a.hit.Distance < b.hit.Distance
+		}
+		return a.hit.ID < b.hit.ID
+	})
+
+	for i := range ss {
+		h[i] = ss[i].hit
+	}
+}
+
+// score returns the weighted sum of the scaled signals, less the
+// weighted, scaled distance, of every hit.
+func (r *Reranking) score(h Hits) []float64 {
+	scores := make([]float64, len(h))
+	values := make([]float64, len(h))
+
+	if r.Distance != 0 {
+		for i := range h {
+			values[i] = float64(h[i].Distance)
+		}
+		addScaled(scores, values, -r.Distance)
+	}
+
+	for _, s := range r.Signals {
+		for i := range h {
+			values[i] = s.Value(&h[i])
+		}
+		addScaled(scores, values, s.Weight)
+	}
+
+	return scores
+}
+
+// addScaled adds the values, scaled to the range 0-1 and weighted, to
+// the scores. NaN values add nothing, nor do values which are all the
+// same.
+func addScaled(scores, values []float64, weight float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+
+	if !(hi > lo) {
+		return
+	}
+
+	for i, v := range values {
+		if !math.IsNaN(v) {
+			scores[i] += weight * (v - lo) / (hi - lo)
+		}
+	}
+}
+
+// MetaNumber returns a Signal.Value function which reads a number from
+// the metadata of each hit under the given key, like a resolution or a
+// trust level. Hits without one lack the signal.
+func MetaNumber(key string) func(h *Hit) float64 {
+	return func(h *Hit) float64 {
+		v, err := strconv.ParseFloat(h.Meta[key], 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return v
+	}
+}
+
+// MetaTime returns a Signal.Value function which reads a time in RFC 3339
+// format from the metadata of each hit under the given key, like an
+// upload time. Later times rank higher; give the signal a negative
+// weight to favour the originals of reposted images. Hits without one
+// lack the signal.
+func MetaTime(key string) func(h *Hit) float64 {
+	return func(h *Hit) float64 {
+		t, err := time.Parse(time.RFC3339, h.Meta[key])
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(t.UnixNano()) / float64(time.Second)
+	}
+}
@@ -0,0 +1,49 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHitsRerank(t *testing.T) {
+	hits := Hits{
+		{Record{ID: "repost", Meta: map[string]string{"uploaded": "2026-03-01T12:00:00Z", "width": "640"}}, 0},
+		{Record{ID: "original", Meta: map[string]string{"uploaded": "2025-01-01T00:00:00Z", "width": "4000"}}, 3},
+		{Record{ID: "crop", Meta: map[string]string{"uploaded": "2026-01-01T00:00:00Z", "width": "1200"}}, 6},
+		{Record{ID: "unknown"}, 1},
+	}
+
+	// Older and larger wins, though further away. Weights compare the
+	// scaled signals, so seconds and pixels weigh alike.
+	r := &Reranking{
+		Distance: 1,
+		Signals: []Signal{
+			{MetaTime("uploaded"), -1},
+			{MetaNumber("width"), 1},
+		},
+	}
+
+	hits.Rerank(r)
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"original", "unknown", "repost", "crop"}) {
+		t.Fatalf("re-ranked: %v", ids)
+	}
+
+	// A score sees all hits; ties fall back to distance, then ID.
+	hits.Rerank(&Reranking{Score: func(h Hits) []float64 { return make([]float64, len(h)) }})
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"repost", "unknown", "original", "crop"}) {
+		t.Fatalf("ties: %v", ids)
+	}
+
+	hits.Rerank(&Reranking{Signals: []Signal{{MetaNumber("width"), 1}}})
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"original", "crop", "repost", "unknown"}) {
+		t.Fatalf("by width: %v", ids)
+	}
+
+	hits.Rerank(nil)
+	if ids := hits.IDs(); !reflect.DeepEqual(ids, []string{"repost", "unknown", "original", "crop"}) {
+		t.Fatalf("without reranking: %v", ids)
+	}
+}
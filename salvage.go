@@ -0,0 +1,695 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+)
+
+// errNotSalvageable is returned for damaged files of which nothing
+// can be recovered.
+var errNotSalvageable = errors.New("imghash: image can not be salvaged")
+
+// A Salvage is the hash of a possibly damaged image file, computed by
+// ComputeSalvage.
+type Salvage struct {
+	Hash uint64
+
+	// Partial is set if the file was damaged, and the hash is of the
+	// part of the image which could be recovered.
+	Partial bool
+
+	// Coverage is the fraction of the rows of the image which were
+	// recovered, in the range 0-1. A progressive JPEG is recovered in
+	// full, if at a lower level of detail.
+	Coverage float64
+
+	// Known has the bits set of the cells of an 8x8 grid, in row-major
+	// order, which lie in the recovered part of the image. Only these
+	// bits of a grid hash, like Average, say anything about the image.
+	// All bits are set if the image was recovered in full.
+	Known uint64
+}
+
+// ComputeSalvage is like ComputeReader, but makes a best effort to hash
+// damaged files, instead of failing outright. This lets files recovered
+// from a disk in part be matched against an index of known images.
+//
+// If the image decodes, its hash is returned as is. Otherwise, what can
+// be recovered of it is hashed: the rows of a truncated PNG up to where
+// its data ends, the MCU rows of a truncated baseline JPEG, and the
+// complete scans of a progressive JPEG. PNGs must not be interlaced.
+// The rows which were lost are filled in with the mean colour of the
+// others, and the result has Partial set.
+//
+// Use Index.SearchPartial to look up a partial hash, so the lost rows
+// do not count against its matches. Other files, and those of which
+// not a single row survives, fail with the error of Decode.
+func ComputeSalvage(r io.Reader, hf HashFunc) (*Salvage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := Decode(bytes.NewReader(data))
+	if err == nil {
+		return &Salvage{Hash: hf(img), Coverage: 1, Known: ^uint64(0)}, nil
+	}
+
+	if kind := Classify(err); kind != FailureTruncated && kind != FailureDecode {
+		return nil, err
+	}
+
+	if s, ok := salvageProgressive(data, hf); ok {
+		return s, nil
+	}
+
+	var part image.Image
+	var height int
+	var serr error
+
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		part, height, serr = salvagePNG(data)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		part, height, serr = salvageJPEG(data)
+	default:
+		return nil, err
+	}
+
+	if serr != nil {
+		return nil, err
+	}
+
+	rows := part.Bounds().Dy()
+	return &Salvage{
+		Hash:     hf(fillRows(part, height)),
+		Partial:  true,
+		Coverage: float64(rows) / float64(height),
+		Known:    knownCells(rows, height),
+	}, nil
+}
+
+// SalvageFile is like ComputeSalvage, for the image in the given file.
+func SalvageFile(file string, hf HashFunc) (*Salvage, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return ComputeSalvage(fd, hf)
+}
+
+// SearchPartial is like Search, but only counts the given known bits of
+// the hash towards the distance, as those of a Salvage. The bits which
+// are not known may take any value in the hits.
+func (x *Index) SearchPartial(hash, known, distance uint64) Hits {
+	unknown := Distance(^known, 0)
+	if unknown == 0 {
+		return x.Search(hash, distance)
+	}
+
+	var hits Hits
+	for _, h := range x.Search(hash, distance+unknown) {
+		if h.Distance = Distance(h.Hash&known, hash&known); h.Distance <= distance {
+			hits = append(hits, h)
+		}
+	}
+
+	hits.Sort()
+	return hits
+}
+
+// knownCells returns the cells of an 8x8 grid which lie entirely in the
+// top rows of an image of the given height.
+func knownCells(rows, height int) uint64 {
+	var known uint64
+
+	for y := 0; y < 8; y++ {
+		// A cell is known if all rows it is averaged over are.
+		if ((y+1)*height+7)/8 > rows {
+			break
+		}
+
+		known |= 0xff << (8 * y)
+	}
+
+	return known
+}
+
+// fillRows returns part extended to the given height, with the rows
+// added filled in with its mean colour.
+func fillRows(part image.Image, height int) image.Image {
+	rect := part.Bounds()
+	full := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), height))
+	draw.Draw(full, rect.Sub(rect.Min), part, rect.Min, draw.Src)
+
+	var r, g, b, n uint64
+	var x, y int
+
+	for y = rect.Min.Y; y < rect.Max.Y; y++ {
+		for x = rect.Min.X; x < rect.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(part.At(x, y)).(color.NRGBA64)
+			r, g, b, n = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), n+1
+		}
+	}
+
+	if n > 0 {
+		mean := color.NRGBA64{uint16(r / n), uint16(g / n), uint16(b / n), 0xffff}
+		lost := image.Rect(0, rect.Dy(), rect.Dx(), height)
+		draw.Draw(full, lost, image.NewUniform(mean), image.Point{}, draw.Src)
+	}
+
+	return full
+}
+
+// salvageProgressive hashes the complete scans of a truncated
+// progressive JPEG, as ProgressiveHasher does.
+func salvageProgressive(data []byte, hf HashFunc) (*Salvage, bool) {
+	p := NewProgressiveHasher(hf)
+	p.Write(data)
+
+	hash, c, err := p.Hash()
+	if err != nil || c == NoConfidence || c == FullConfidence {
+		return nil, false
+	}
+
+	return &Salvage{Hash: hash, Partial: true, Coverage: 1, Known: ^uint64(0)}, true
+}
+
+// salvagePNG decodes the rows of a truncated PNG which come before the
+// point at which its compressed data ends. It returns them, along with
+// the height of the full image.
+func salvagePNG(data []byte) (image.Image, int, error) {
+	var header, palette, trns []byte
+	var idat []byte
+
+	for pos := 8; pos+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		pos += 8
+
+		end := pos + n
+		if n < 0 || end > len(data) {
+			end = len(data)
+		}
+
+		chunk := data[pos:end]
+		pos = end + 4
+
+		switch kind {
+		case "IHDR":
+			header = append([]byte(nil), chunk...)
+		case "PLTE":
+			palette = chunk
+		case "tRNS":
+			trns = chunk
+		case "IDAT":
+			idat = append(idat, chunk...)
+		}
+
+		if kind == "IEND" || kind != "IDAT" && idat != nil {
+			break
+		}
+	}
+
+	if len(header) != 13 || header[12] != 0 {
+		return nil, 0, errNotSalvageable
+	}
+
+	width := int(binary.BigEndian.Uint32(header[0:]))
+	height := int(binary.BigEndian.Uint32(header[4:]))
+	if err := checkPixels(width, height, 1); err != nil || width <= 0 || height <= 0 {
+		return nil, 0, errNotSalvageable
+	}
+
+	var channels int
+	switch header[9] {
+	case 0, 3:
+		channels = 1
+	case 2:
+		channels = 3
+	case 4:
+		channels = 2
+	case 6:
+		channels = 4
+	default:
+		return nil, 0, errNotSalvageable
+	}
+
+	// Every row starts with a byte giving its filter type.
+	stride := 1 + (width*channels*int(header[8])+7)/8
+
+	zr, err := zlib.NewReader(bytes.NewReader(idat))
+	if err != nil {
+		return nil, 0, errNotSalvageable
+	}
+
+	raw := make([]byte, height*stride)
+	n, _ := io.ReadFull(zr, raw)
+
+	rows := n / stride
+	if rows == 0 {
+		return nil, 0, errNotSalvageable
+	}
+
+	// Rewrite the image with the rows recovered, and decode that.
+	var out, z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(raw[:rows*stride])
+	zw.Close()
+
+	binary.BigEndian.PutUint32(header[4:], uint32(rows))
+	out.WriteString("\x89PNG\r\n\x1a\n")
+	writePNGChunk(&out, "IHDR", header)
+	if palette != nil {
+		writePNGChunk(&out, "PLTE", palette)
+	}
+	if trns != nil {
+		writePNGChunk(&out, "tRNS", trns)
+	}
+	writePNGChunk(&out, "IDAT", z.Bytes())
+	writePNGChunk(&out, "IEND", nil)
+
+	img, err := Decode(&out)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return img, height, nil
+}
+
+// writePNGChunk writes a PNG chunk of the given kind.
+func writePNGChunk(w *bytes.Buffer, kind string, data []byte) {
+	var b [4]byte
+
+	binary.BigEndian.PutUint32(b[:], uint32(len(data)))
+	w.Write(b[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+
+	w.WriteString(kind)
+	w.Write(data)
+	w.Write(crc.Sum(b[:0]))
+}
+
+// salvageJPEG decodes the rows of a truncated baseline JPEG up to the
+// last complete row of MCUs. It decodes the Huffman codes of the first
+// scan to find the end of that row, and decodes a copy of the file cut
+// off there, with the height in its frame header reduced to match. It
+// returns the rows, along with the height of the full image.
+func salvageJPEG(data []byte) (image.Image, int, error) {
+	var f jpegFrame
+	var sos []byte
+	var scan int
+
+	for pos := 2; sos == nil; {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, 0, errNotSalvageable
+		}
+
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+
+		if marker == 0xd8 || marker == 0x01 || marker >= 0xd0 && marker <= 0xd7 {
+			pos += 2
+			continue
+		}
+
+		n := int(data[pos+2])<<8 | int(data[pos+3])
+		if n < 2 || pos+2+n > len(data) {
+			return nil, 0, errNotSalvageable
+		}
+
+		seg := data[pos+4 : pos+2+n]
+		var ok bool
+
+		switch marker {
+		case 0xc0, 0xc1:
+			f.sof = pos + 4
+			ok = f.parseFrame(seg)
+		case 0xc4:
+			ok = f.parseTables(seg)
+		case 0xdd:
+			ok = len(seg) >= 2
+			if ok {
+				f.restart = int(seg[0])<<8 | int(seg[1])
+			}
+		case 0xda:
+			sos, scan, ok = seg, pos+2+n, f.sof > 0
+		case 0xd9:
+			ok = false
+		default:
+			// SOF markers of other kinds of JPEG.
+			ok = marker < 0xc2 || marker > 0xcf || marker == 0xc4 || marker == 0xc8 || marker == 0xcc
+		}
+
+		if !ok {
+			return nil, 0, errNotSalvageable
+		}
+
+		pos += 2 + n
+	}
+
+	cut, rows, ok := f.walk(sos, data, scan)
+	if !ok || rows == 0 {
+		return nil, 0, errNotSalvageable
+	}
+
+	out := append(cut, 0xff, 0xd9)
+	height := f.height
+	if h := rows * f.mcuHeight; h < height {
+		out[f.sof+1], out[f.sof+2] = byte(h>>8), byte(h)
+	}
+
+	img, err := Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return img, height, nil
+}
+
+// jpegFrame holds what salvageJPEG needs to know of a baseline JPEG.
+type jpegFrame struct {
+	sof        int // Offset of the frame header in the file.
+	width      int
+	height     int
+	components []jpegComponent
+	hmax, vmax int
+	mcuHeight  int
+	restart    int // Restart interval, in MCUs.
+	tables     [2][4]*huffTable
+}
+
+// jpegComponent is a colour component of a JPEG frame.
+type jpegComponent struct {
+	id   byte
+	h, v int
+}
+
+// parseFrame parses a baseline frame header.
+func (f *jpegFrame) parseFrame(seg []byte) bool {
+	if len(seg) < 6 || seg[0] != 8 {
+		return false
+	}
+
+	f.height = int(seg[1])<<8 | int(seg[2])
+	f.width = int(seg[3])<<8 | int(seg[4])
+	n := int(seg[5])
+
+	if f.width == 0 || f.height == 0 || n == 0 || len(seg) < 6+3*n {
+		return false
+	}
+
+	f.hmax, f.vmax = 1, 1
+	f.components = make([]jpegComponent, n)
+
+	for i := range f.components {
+		c := jpegComponent{seg[6+3*i], int(seg[7+3*i] >> 4), int(seg[7+3*i] & 15)}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 {
+			return false
+		}
+
+		f.hmax, f.vmax = max(f.hmax, c.h), max(f.vmax, c.v)
+		f.components[i] = c
+	}
+
+	// The blocks of a single component are not interleaved, and
+	// every MCU holds one block.
+	if n == 1 {
+		f.hmax, f.vmax = 1, 1
+		f.components[0].h, f.components[0].v = 1, 1
+	}
+
+	f.mcuHeight = 8 * f.vmax
+	return true
+}
+
+// parseTables parses a segment of Huffman tables.
+func (f *jpegFrame) parseTables(seg []byte) bool {
+	for len(seg) > 0 {
+		if len(seg) < 17 || seg[0]>>4 > 1 || seg[0]&15 > 3 {
+			return false
+		}
+
+		var counts [16]int
+		var total int
+		for i := range counts {
+			counts[i] = int(seg[1+i])
+			total += counts[i]
+		}
+
+		if total > 256 || len(seg) < 17+total {
+			return false
+		}
+
+		f.tables[seg[0]>>4][seg[0]&15] = newHuffTable(counts, seg[17:17+total])
+		seg = seg[17+total:]
+	}
+
+	return true
+}
+
+// walk decodes the Huffman codes of the scan which starts at offset pos
+// of data, until the data ends. It returns the data up to the end of the
+// last complete row of MCUs, padded to a whole byte, and the number of
+// rows. The scan must hold all components of the frame.
+func (f *jpegFrame) walk(sos, data []byte, pos int) ([]byte, int, bool) {
+	if len(sos) < 1 || int(sos[0]) != len(f.components) || len(sos) < 1+2*len(f.components) {
+		return nil, 0, false
+	}
+
+	type block struct {
+		dc, ac *huffTable
+	}
+
+	// The blocks of a single MCU, in the order in which they are coded.
+	var blocks []block
+	for i, c := range f.components {
+		sel := sos[2+2*i]
+		if sos[1+2*i] != c.id {
+			return nil, 0, false
+		}
+
+		b := block{f.tables[0][sel>>4&3], f.tables[1][sel&3]}
+		if b.dc == nil || b.ac == nil {
+			return nil, 0, false
+		}
+
+		for j := 0; j < c.h*c.v; j++ {
+			blocks = append(blocks, b)
+		}
+	}
+
+	perRow := (f.width + 8*f.hmax - 1) / (8 * f.hmax)
+	rows := (f.height + f.mcuHeight - 1) / f.mcuHeight
+
+	s := &scanReader{data: data, pos: pos}
+	var cut []byte
+	var done int
+
+	for mcu := 0; mcu < perRow*rows; mcu++ {
+		if f.restart > 0 && mcu > 0 && mcu%f.restart == 0 && !s.restart() {
+			break
+		}
+
+		var ok bool
+		for _, b := range blocks {
+			if ok = s.block(b.dc, b.ac); !ok {
+				break
+			}
+		}
+
+		if !ok {
+			break
+		}
+
+		if (mcu+1)%perRow == 0 {
+			cut, done = s.cut(), (mcu+1)/perRow
+		}
+	}
+
+	return cut, done, true
+}
+
+// A huffTable decodes the Huffman codes of a JPEG.
+type huffTable struct {
+	maxCode [17]int32 // Largest code of every length, or -1.
+	offset  [17]int32 // Index of the value of the first code of every length, less that code.
+	values  []byte
+}
+
+// newHuffTable builds a table from the number of codes of every length,
+// and their values, as given in a DHT segment.
+func newHuffTable(counts [16]int, values []byte) *huffTable {
+	t := &huffTable{values: values}
+
+	var code, k int32
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		t.maxCode[l] = -1
+
+		if n > 0 {
+			t.offset[l] = k - code
+			code, k = code+n, k+n
+			t.maxCode[l] = code - 1
+		}
+
+		code <<= 1
+	}
+
+	return t
+}
+
+// scanReader reads the bits of entropy coded JPEG data.
+type scanReader struct {
+	data  []byte
+	pos   int  // Offset of the next byte.
+	start int  // Offset of the current byte.
+	cur   byte // Current byte.
+	bits  uint // Bits left in the current byte.
+}
+
+// bit reads a single bit. It returns false at the end of the data, or
+// at a marker.
+func (s *scanReader) bit() (int32, bool) {
+	if s.bits == 0 {
+		if s.pos >= len(s.data) {
+			return 0, false
+		}
+
+		s.start, s.cur = s.pos, s.data[s.pos]
+		s.pos++
+
+		// Data bytes of 0xff are followed by a zero byte.
+		if s.cur == 0xff {
+			if s.pos >= len(s.data) || s.data[s.pos] != 0 {
+				return 0, false
+			}
+			s.pos++
+		}
+
+		s.bits = 8
+	}
+
+	s.bits--
+	return int32(s.cur>>s.bits) & 1, true
+}
+
+// skip skips n bits.
+func (s *scanReader) skip(n int) bool {
+	for ; n > 0; n-- {
+		if _, ok := s.bit(); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decode reads and decodes a single Huffman code.
+func (s *scanReader) decode(t *huffTable) (byte, bool) {
+	var code int32
+
+	for l := 1; l <= 16; l++ {
+		b, ok := s.bit()
+		if !ok {
+			return 0, false
+		}
+
+		if code = code<<1 | b; code <= t.maxCode[l] {
+			i := t.offset[l] + code
+			if int(i) >= len(t.values) {
+				return 0, false
+			}
+			return t.values[i], true
+		}
+	}
+
+	return 0, false
+}
+
+// block reads the codes of an 8x8 block.
+func (s *scanReader) block(dc, ac *huffTable) bool {
+	n, ok := s.decode(dc)
+	if !ok || n > 16 || !s.skip(int(n)) {
+		return false
+	}
+
+	for k := 1; k < 64; k++ {
+		rs, ok := s.decode(ac)
+		if !ok {
+			return false
+		}
+
+		if rs&15 == 0 {
+			if rs != 0xf0 {
+				break // End of block.
+			}
+			k += 15
+			continue
+		}
+
+		k += int(rs >> 4)
+		if !s.skip(int(rs & 15)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// restart reads the restart marker at the end of a restart interval.
+func (s *scanReader) restart() bool {
+	s.bits = 0
+
+	if s.pos+1 >= len(s.data) || s.data[s.pos] != 0xff {
+		return false
+	}
+
+	for s.pos < len(s.data) && s.data[s.pos] == 0xff {
+		s.pos++
+	}
+
+	if s.pos >= len(s.data) || s.data[s.pos] < 0xd0 || s.data[s.pos] > 0xd7 {
+		return false
+	}
+
+	s.pos++
+	return true
+}
+
+// cut returns a copy of the data up to the bits read so far, with the
+// rest of the current byte padded with ones.
+func (s *scanReader) cut() []byte {
+	if s.bits == 0 {
+		return append([]byte(nil), s.data[:s.pos]...)
+	}
+
+	out := append([]byte(nil), s.data[:s.start]...)
+	b := s.cur | (byte(1)<<s.bits - 1)
+	out = append(out, b)
+
+	if b == 0xff {
+		out = append(out, 0)
+	}
+
+	return out
+}
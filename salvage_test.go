@@ -0,0 +1,112 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputeSalvage(t *testing.T) {
+	img := synth.Gradient(128, 96, 3)
+	want := Average(img)
+
+	x := NewIndex()
+	x.Add("original", want)
+	x.Add("other", Average(synth.Checker(128, 96, 5)))
+
+	var pngData, jpegData bytes.Buffer
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpegData, img, &jpeg.Options{Quality: 90})
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"png", pngData.Bytes()},
+		{"jpeg", jpegData.Bytes()},
+	} {
+		s, err := ComputeSalvage(bytes.NewReader(tc.data), Average)
+		if err != nil || s.Partial || s.Coverage != 1 || s.Known != ^uint64(0) || s.Hash != want {
+			t.Fatalf("%s: complete file: %+v, %v", tc.name, s, err)
+		}
+
+		if _, err := ComputeBytes(tc.data[:len(tc.data)*2/3], Average); err == nil {
+			t.Fatalf("%s: truncated file decodes", tc.name)
+		}
+
+		s, err = ComputeSalvage(bytes.NewReader(tc.data[:len(tc.data)*2/3]), Average)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if !s.Partial || s.Coverage <= 0.25 || s.Coverage >= 1 || s.Known == 0 || s.Known == ^uint64(0) {
+			t.Fatalf("%s: truncated file: %+v", tc.name, s)
+		}
+
+		hits := x.SearchPartial(s.Hash, s.Known, 6)
+		if len(hits) != 1 || hits[0].ID != "original" {
+			t.Fatalf("%s: matches %+v of %016x, known %016x", tc.name, hits, s.Hash, s.Known)
+		}
+
+		if _, err := ComputeSalvage(bytes.NewReader(tc.data[:40]), Average); err == nil {
+			t.Fatalf("%s: header salvaged", tc.name)
+		}
+	}
+
+	// Progressive JPEGs are hashed up to their last complete scan.
+	block := blockPattern(3)
+	data := makeProgressiveJPEG(block.(*image.Gray))
+	s, err := ComputeSalvage(bytes.NewReader(data[:len(data)-100]), Average)
+	if err != nil || !s.Partial || s.Hash != Average(block) || s.Known != ^uint64(0) {
+		t.Fatalf("progressive: %+v, %v", s, err)
+	}
+
+	if _, err := ComputeSalvage(bytes.NewReader([]byte("not an image")), Average); err == nil {
+		t.Fatal("garbage salvaged")
+	}
+
+	if k := knownCells(50, 100); k != 0xffffffff {
+		t.Fatalf("known cells %016x", k)
+	}
+}
+
+func TestBatchSalvage(t *testing.T) {
+	dir := t.TempDir()
+	whole, broken := filepath.Join(dir, "whole.png"), filepath.Join(dir, "broken.png")
+	writeTestPNG(t, whole, synth.Gradient(128, 96, 1))
+
+	data, _ := os.ReadFile(whole)
+	os.WriteFile(broken, data[:len(data)/2], 0644)
+
+	files := make(chan string, 2)
+	files <- whole
+	files <- broken
+	close(files)
+
+	results, err := HashAll(context.Background(), files, Average, &BatchOptions{Salvage: true, Ordered: true})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("%d results, %v", len(results), err)
+	}
+
+	if r := results[0]; r.Partial || r.Err != nil {
+		t.Fatalf("whole file: %+v", r)
+	}
+
+	s, err := SalvageFile(broken, Average)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := results[1]; !r.Partial || r.Err != nil || r.Hash != s.Hash || Distance(r.Hash&s.Known, results[0].Hash&s.Known) > 2 {
+		t.Fatalf("broken file: %+v", r)
+	}
+}
@@ -0,0 +1,88 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// Images with more pixels than maxHashPixels are not read in full, but
+// point sampled on a grid of at most sampleSize pixels in either
+// direction. This applies to image.Image implementations which generate
+// their pixels, like image.Uniform, as their bounds can be arbitrarily
+// large. Iterating over all of them would take forever.
+const (
+	maxHashPixels = 1 << 26
+	sampleSize    = 1024
+)
+
+// bounded returns img, or a sampled view of it if it is too large to
+// read in full. The view always has its origin at (0, 0).
+func bounded(img image.Image) image.Image {
+	return boundedTo(img, maxHashPixels)
+}
+
+// copyable returns img, or a sampled view of it if it holds more than
+// MaxPixels pixels. Filters which copy an image copy the sample of such
+// images: no decoded image is as large, only generated ones are, like
+// image.Uniform, whose copy would not fit in memory.
+func copyable(img image.Image) image.Image {
+	return boundedTo(img, MaxPixels)
+}
+
+// boundedTo returns img, or a sampled view of it if it holds more than
+// max pixels.
+func boundedTo(img image.Image, max int) image.Image {
+	r := img.Bounds()
+	dx, dy := r.Dx(), r.Dy()
+
+	// Dx and Dy are negative for malformed bounds, and
+	// for bounds whose size overflows an int.
+	if dx <= 0 || dy <= 0 || dx <= max/dy {
+		return img
+	}
+
+	w, h := dx, dy
+	if w > sampleSize {
+		w = sampleSize
+	}
+
+	if h > sampleSize {
+		h = sampleSize
+	}
+
+	return &sampledImage{img, r, w, h}
+}
+
+// sampledImage is a view of src, scaled down to w by h pixels. Each
+// pixel holds the colour at the centre of the area it covers.
+type sampledImage struct {
+	img  image.Image
+	src  image.Rectangle
+	w, h int
+}
+
+func (s *sampledImage) ColorModel() color.Model { return s.img.ColorModel() }
+func (s *sampledImage) Bounds() image.Rectangle { return image.Rect(0, 0, s.w, s.h) }
+
+func (s *sampledImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(s.Bounds()) {
+		return color.RGBA64{}
+	}
+
+	return s.img.At(
+		s.src.Min.X+scaleCoord(x, s.w, s.src.Dx()),
+		s.src.Min.Y+scaleCoord(y, s.h, s.src.Dy()),
+	)
+}
+
+// scaleCoord maps the centre of pixel i out of n onto a range of size
+// pixels. The product is computed in 128 bits, so it can not overflow.
+func scaleCoord(i, n, size int) int {
+	hi, lo := bits.Mul64(uint64(2*i+1), uint64(size))
+	q, _ := bits.Div64(hi, lo, uint64(2*n))
+	return int(q)
+}
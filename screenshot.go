@@ -0,0 +1,118 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "image"
+
+// ScreenshotThresholds holds the recommended thresholds for hashes
+// computed by Screenshot.
+var ScreenshotThresholds = Thresholds{Duplicate: 4, NearDuplicate: 6}
+
+// screenshotGrid is the size of the grid edges are detected on.
+// Each bit covers a block of 4x4 of its cells.
+const screenshotGrid = 32
+
+// ScreenshotOptions configure Screenshot.
+type ScreenshotOptions struct {
+	// Mask hides regions which change between otherwise identical
+	// screenshots, like clocks and notification icons. Only its alpha
+	// channel is used, and it is stretched over the image, as with
+	// AverageMask. Defaults to StatusBarMask(0.05, 0.05).
+	Mask image.Image
+}
+
+// StatusBarMask returns a mask for use with Screenshot, which hides
+// the given fractions of the image height at the top and the bottom.
+// This covers the status bar of phones and the menu bar of desktops
+// at the top, and task bars and navigation bars at the bottom.
+func StatusBarMask(top, bottom float64) *image.Alpha {
+	const size = 1000
+
+	bounds := image.Rect(0, 0, 1, size)
+	return ExcludeRects(bounds,
+		image.Rect(0, 0, 1, int(top*size)),
+		image.Rect(0, size-int(bottom*size), 1, size))
+}
+
+// Screenshot returns a HashFunc tuned for screenshots of user interfaces.
+// Opts may be nil, to use the defaults.
+//
+// UI imagery is mostly flat colour, with detail in thin lines and small
+// text. Downscaling straight to 8x8, as Average does, averages all of
+// it into the background. Instead, edges are detected on a 32x32 grid,
+// and each bit tells whether a block of that grid holds more edges than
+// the average block. Screens with the same layout and content hash the
+// same, regardless of their resolution or colour scheme.
+//
+// Masked regions do not count towards the average, and bits covering
+// them entirely are always zero.
+func Screenshot(opts *ScreenshotOptions) HashFunc {
+	var mask image.Image = StatusBarMask(0.05, 0.05)
+	if opts != nil && opts.Mask != nil {
+		mask = opts.Mask
+	}
+
+	return func(img image.Image) uint64 {
+		lum, cov := applyMask(img, mask)
+		cells, weights := screenshotEdges(resize(lum, screenshotGrid, screenshotGrid),
+			resize(cov, screenshotGrid, screenshotGrid))
+		mean := avgMean(cells, weights)
+		return avgHash(cells, mean, weights)
+	}
+}
+
+// screenshotEdges computes the edge strength of each cell of the
+// downscaled luminance, and sums it into 8x8 blocks. Edges are only
+// counted between cells which are both fully unmasked. It returns the
+// block values, and the number of unmasked cells in each block.
+func screenshotEdges(lum, cov image.Image) (blocks, weights []uint32) {
+	values, coverage := maskedCells(lum, cov)
+	blocks = make([]uint32, 64)
+	weights = make([]uint32, 64)
+
+	const block = screenshotGrid / 8
+
+	at := func(x, y int) uint32 { return values[y*screenshotGrid+x] }
+	covered := func(x, y int) bool { return coverage[y*screenshotGrid+x] >= 0xffff-0xff }
+
+	var x, y int
+	for y = 0; y < screenshotGrid; y++ {
+		for x = 0; x < screenshotGrid; x++ {
+			if !covered(x, y) {
+				continue
+			}
+
+			var edge uint32
+			if x+1 < screenshotGrid && covered(x+1, y) {
+				edge += absDiff(at(x, y), at(x+1, y))
+			}
+
+			if y+1 < screenshotGrid && covered(x, y+1) {
+				edge += absDiff(at(x, y), at(x, y+1))
+			}
+
+			i := (y/block)*8 + x/block
+			blocks[i] += edge
+			weights[i]++
+		}
+	}
+
+	// Blocks partially masked hold fewer edges. Scale them up, so they
+	// compare fairly against the mean.
+	for i, w := range weights {
+		if w > 0 {
+			blocks[i] = uint32(uint64(blocks[i]) * block * block / uint64(w))
+		}
+	}
+
+	return
+}
+
+// absDiff returns the absolute difference of a and b.
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,78 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestScreenshot(t *testing.T) {
+	hf := Screenshot(nil)
+	a := drawScreen(360, 640, 1, "12:00", false)
+
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"clock", drawScreen(360, 640, 1, "23:59", false)},
+		{"dark", drawScreen(360, 640, 1, "12:00", true)},
+		{"scaled", drawScreen(720, 1280, 1, "12:00", false)},
+		{"jpeg", attack.JPEG(75).Apply(a)},
+	} {
+		if d := Distance(hf(a), hf(tc.img)); d > ScreenshotThresholds.Duplicate {
+			t.Errorf("%s: distance %d", tc.name, d)
+		}
+	}
+
+	for seed := int64(2); seed < 10; seed++ {
+		b := drawScreen(360, 640, seed, "12:00", false)
+		if d := Distance(hf(a), hf(b)); d <= ScreenshotThresholds.NearDuplicate {
+			t.Errorf("screen %d: distance %d", seed, d)
+		}
+	}
+}
+
+// drawScreen draws a list view, as found in many apps, with a status bar
+// and title bar on top.
+func drawScreen(w, h int, seed int64, clock string, dark bool) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	scale := w / 120
+
+	bg, fg := color.RGBA{0xff, 0xff, 0xff, 0xff}, color.RGBA{0x20, 0x20, 0x20, 0xff}
+	if dark {
+		bg, fg = color.RGBA{0x10, 0x10, 0x10, 0xff}, color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, image.NewUniform(bg), image.Point{}, draw.Src)
+
+	// Status bar and title bar.
+	synth.DrawText(img, image.Pt(w/2-15*scale, h/100), scale, fg, clock)
+	bar := image.Rect(0, h/20, w, h/8)
+	draw.Draw(img, bar, image.NewUniform(color.RGBA{0x30, 0x60, 0xc0, 0xff}), image.Point{}, draw.Src)
+
+	words := []string{"INBOX", "SETTINGS", "PHOTOS", "MUSIC", "MAPS", "NOTES", "MAIL", "FILES"}
+	synth.DrawText(img, image.Pt(w/20, h/20+h/40), scale, color.White, words[rng.Intn(len(words))])
+
+	// Rows of varying height, with a divider, an icon and a label.
+	for y := h / 8; y < h; {
+		rh := h/16 + rng.Intn(h/10)
+		if rng.Intn(2) == 0 {
+			icon := image.Rect(w/20, y+rh/4, w/20+rh/2, y+3*rh/4)
+			draw.Draw(img, icon, image.NewUniform(color.RGBA{uint8(rng.Intn(256)), 0x80, 0x40, 0xff}), image.Point{}, draw.Src)
+		}
+
+		synth.DrawText(img, image.Pt(w/20+rh, y+rh/3), scale, fg, words[rng.Intn(len(words))])
+		draw.Draw(img, image.Rect(0, y+rh-1, w, y+rh), image.NewUniform(color.RGBA{0x80, 0x80, 0x80, 0xff}), image.Point{}, draw.Src)
+		y += rh
+	}
+
+	return img
+}
@@ -0,0 +1,164 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package selftest checks, in a running process, that hashes come out as
+they should: it hashes the reference images of the fixtures package,
+and compares the hashes with the vectors they are known to produce. A
+miscompiled or broken code path, a decoder which changed with the Go
+release the program was built with, or a damaged index would otherwise
+go on returning wrong matches without a sign.
+
+Services run it once at startup, and again on demand:
+
+	if r := selftest.Run(ctx, &selftest.Options{Store: store}); !r.OK() {
+		log.Fatal(r.Err())
+	}
+
+It takes a few milliseconds, and the check of a store as long as a pass
+over all of its hashes.
+
+The vectors include those of the compatibility modes of the compat
+package, so importing this package registers them as well.
+*/
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	_ "github.com/jteeuwen/imghash/compat"
+	"github.com/jteeuwen/imghash/fixtures"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Options configure Run.
+type Options struct {
+	// If set, the store is checked with imghash.VerifyStore as well.
+	Store imghash.Store
+}
+
+// A Failure is a check which did not pass.
+type Failure struct {
+	Check string `json:"check"`          // Name of the check, like "hash/average/gopher.png".
+	Got   string `json:"got,omitempty"`  // Result of the check.
+	Want  string `json:"want,omitempty"` // Expected result.
+	Err   string `json:"error,omitempty"`
+}
+
+func (f Failure) String() string {
+	if len(f.Err) > 0 {
+		return fmt.Sprintf("%s: %s", f.Check, f.Err)
+	}
+	return fmt.Sprintf("%s: got %s, want %s", f.Check, f.Got, f.Want)
+}
+
+// A Report holds the outcome of Run.
+type Report struct {
+	Passed   int                `json:"passed"`
+	Failed   int                `json:"failed"`
+	Failures []Failure          `json:"failures"`
+	Paths    []imghash.CodePath `json:"paths"` // Code paths the hashes were computed with.
+	Elapsed  time.Duration      `json:"elapsed"`
+}
+
+// OK returns true if every check passed.
+func (r *Report) OK() bool {
+	return r.Failed == 0
+}
+
+// Err returns an error listing the failures, or nil if there were none.
+func (r *Report) Err() error {
+	if r.OK() {
+		return nil
+	}
+
+	lines := make([]string, len(r.Failures))
+	for i, f := range r.Failures {
+		lines[i] = f.String()
+	}
+
+	return fmt.Errorf("selftest: %d of %d checks failed: %s", r.Failed, r.Passed+r.Failed, strings.Join(lines, "; "))
+}
+
+// check records the outcome of a single check.
+func (r *Report) check(name, got, want string, err error) {
+	if err == nil && got == want {
+		r.Passed++
+		return
+	}
+
+	f := Failure{Check: name, Got: got, Want: want}
+	if err != nil {
+		f = Failure{Check: name, Err: err.Error()}
+	}
+
+	r.Failed++
+	r.Failures = append(r.Failures, f)
+}
+
+// Run hashes the reference images with every algorithm there are
+// vectors for, and compares the hashes with the vectors. It finds the
+// hashes again through an index, and checks the store of opts too,
+// if there is one. Opts may be nil.
+func Run(ctx context.Context, opts *Options) *Report {
+	start := time.Now()
+	r := &Report{Paths: imghash.CodePaths()}
+
+	images := fixtures.Images()
+	vectors := fixtures.Vectors()
+	x := imghash.NewIndex()
+
+	for _, v := range vectors {
+		if ctx.Err() != nil {
+			break
+		}
+
+		name := "hash/" + v.Algorithm + "/" + v.Image
+		a, err := imghash.LookupAlgorithm(v.Algorithm)
+		if err != nil {
+			r.check(name, "", "", err)
+			continue
+		}
+
+		data, err := fs.ReadFile(images, v.Image)
+		if err != nil {
+			r.check(name, "", "", err)
+			continue
+		}
+
+		hash, err := imghash.ComputeBytes(data, a.Hash)
+		r.check(name, imghash.FormatHash(hash), imghash.FormatHash(v.Hash), err)
+		x.Add(name, v.Hash)
+	}
+
+	// The index must find each hash at distance 0, and all of them at
+	// the greatest distance.
+	for _, v := range vectors {
+		name := "hash/" + v.Algorithm + "/" + v.Image
+		hits := x.Search(v.Hash, 0)
+
+		var found bool
+		for _, h := range hits {
+			found = found || h.ID == name
+		}
+
+		r.check("index/"+v.Algorithm+"/"+v.Image, fmt.Sprint(found), "true", nil)
+	}
+
+	r.check("index/all", fmt.Sprint(x.Count(0, 64)), fmt.Sprint(x.Len()), x.Verify())
+	r.check("distance", fmt.Sprint(imghash.Distance(0x0f0f, 1<<64-1)), "56", nil)
+
+	if opts != nil && opts.Store != nil {
+		r.check("store", "", "", imghash.VerifyStore(ctx, opts.Store))
+	}
+
+	if err := ctx.Err(); err != nil {
+		r.check("run", "", "", err)
+	}
+
+	r.Elapsed = time.Since(start)
+	return r
+}
@@ -0,0 +1,46 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package selftest
+
+import (
+	"context"
+	"github.com/jteeuwen/imghash"
+	"strings"
+	"testing"
+)
+
+// brokenStore fails its check.
+type brokenStore struct {
+	imghash.Store
+}
+
+func (brokenStore) Verify(ctx context.Context) error {
+	return imghash.ErrCorruptIndex
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	r := Run(ctx, &Options{Store: imghash.IndexStore(imghash.NewIndex())})
+	if !r.OK() || r.Passed == 0 || len(r.Paths) == 0 {
+		t.Fatalf("report %+v: %v", r, r.Err())
+	}
+
+	r = Run(ctx, &Options{Store: brokenStore{}})
+	if r.OK() || r.Failed != 1 || r.Failures[0].Check != "store" {
+		t.Fatalf("report of a broken store %+v", r)
+	}
+
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "corrupt index") {
+		t.Fatalf("error %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if r = Run(cancelled, nil); r.OK() || !strings.Contains(r.Err().Error(), context.Canceled.Error()) {
+		t.Fatalf("cancelled run %+v", r)
+	}
+
+}
@@ -0,0 +1,184 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import "time"
+
+// Defaults for AlignOptions.
+const (
+	defaultAlignDistance = 16
+	defaultAlignGap      = 4
+)
+
+// AlignOptions configure AlignSequences.
+type AlignOptions struct {
+	// Hamming Distance at which a pair of aligned hashes neither adds to
+	// the score of an alignment, nor takes from it. Closer pairs extend
+	// an alignment; pairs further apart end it, unless closer ones make
+	// up for them. Defaults to 16.
+	Distance uint64
+
+	// Penalty, in bits of distance, for aligning a hash with the same
+	// hash of the other sequence as the one before it. This lets
+	// sequences taken at different frame rates, or missing a few frames,
+	// stay aligned. Defaults to 4.
+	Gap float64
+}
+
+// An Alignment is the best matching stretch of two sequences of hashes,
+// as found by AlignSequences. A stretch runs from A up to EndA in the
+// first sequence, and from B up to EndB in the second.
+type Alignment struct {
+	A, EndA int
+	B, EndB int
+
+	Pairs    int     // Number of pairs of hashes aligned.
+	Distance float64 // Mean Hamming Distance of the aligned pairs.
+
+	// Coverage is the fraction of the shorter sequence which is part of
+	// the alignment. It is 1 if one sequence is a clip of the other.
+	Coverage float64
+
+	// Offset is the time between the start of the stretch in the first
+	// sequence and that in the second, for AlignVideos.
+	Offset time.Duration
+}
+
+// Similarity returns the similarity of the aligned stretches, in the
+// range 0-1, as Similarity does for single hashes. It is 0 if nothing
+// was aligned.
+func (al *Alignment) Similarity() float64 {
+	if al.Pairs == 0 {
+		return 0
+	}
+
+	return 1 - al.Distance/64
+}
+
+// alignCell is the best alignment ending at a single pair of hashes.
+type alignCell struct {
+	score float64
+	a, b  int    // Start of the alignment.
+	pairs int    // Number of pairs aligned.
+	dist  uint64 // Sum of their distances.
+}
+
+// AlignSequences finds the stretches of two sequences of hashes, like
+// the frames of two videos or of two bursts of photos, which match best,
+// and returns their alignment. Opts may be nil, to use the defaults.
+//
+// Where VideoDistance aligns the shorter sequence in full, this slides
+// either one over the other, and aligns only the stretch they have in
+// common. Clips trimmed at different points, which only overlap, are
+// matched by the part they share. Within the stretch, the alignment
+// may drift, as it does with dynamic time warping, at a cost. If no
+// pair of hashes is closer than opts.Distance, nothing is aligned,
+// and the returned alignment has no pairs.
+//
+// This uses the Smith-Waterman algorithm, in time proportional to the
+// product of the lengths of the sequences, and space to the length of
+// the second.
+func AlignSequences(a, b []uint64, opts *AlignOptions) Alignment {
+	neutral, gap := float64(defaultAlignDistance), float64(defaultAlignGap)
+	if opts != nil {
+		if opts.Distance > 0 {
+			neutral = float64(opts.Distance)
+		}
+		if opts.Gap > 0 {
+			gap = opts.Gap
+		}
+	}
+
+	prev := make([]alignCell, len(b))
+	cur := make([]alignCell, len(b))
+
+	var best alignCell
+	var endA, endB int
+	var i, j int
+
+	for i = range a {
+		for j = range b {
+			d := Distance(a[i], b[j])
+			s := neutral - float64(d)
+
+			// Start a new alignment here, or extend the best one
+			// ending at a neighbouring pair.
+			c := alignCell{s, i, j, 1, d}
+
+			if i > 0 && j > 0 {
+				c = extendAlignment(c, prev[j-1], s, d)
+			}
+			if i > 0 {
+				c = extendAlignment(c, prev[j], s-gap, d)
+			}
+			if j > 0 {
+				c = extendAlignment(c, cur[j-1], s-gap, d)
+			}
+
+			if c.score <= 0 {
+				c = alignCell{}
+			}
+
+			cur[j] = c
+
+			if c.score > best.score {
+				best, endA, endB = c, i+1, j+1
+			}
+		}
+
+		prev, cur = cur, prev
+	}
+
+	if best.pairs == 0 {
+		return Alignment{}
+	}
+
+	al := Alignment{
+		A: best.a, EndA: endA,
+		B: best.b, EndB: endB,
+		Pairs:    best.pairs,
+		Distance: float64(best.dist) / float64(best.pairs),
+	}
+
+	if len(a) <= len(b) {
+		al.Coverage = float64(endA-best.a) / float64(len(a))
+	} else {
+		al.Coverage = float64(endB-best.b) / float64(len(b))
+	}
+
+	return al
+}
+
+// extendAlignment returns c, or the alignment from p extended by one
+// pair with the given score and distance, whichever scores higher.
+func extendAlignment(c, p alignCell, s float64, d uint64) alignCell {
+	if p.pairs == 0 || p.score+s <= c.score {
+		return c
+	}
+
+	return alignCell{p.score + s, p.a, p.b, p.pairs + 1, p.dist + d}
+}
+
+// AlignVideos aligns the keyframes of two videos, as AlignSequences
+// does, and sets the offset of the alignment: how much later the shared
+// stretch starts in the first video than in the second. Opts may be
+// nil, to use the defaults.
+func AlignVideos(a, b *VideoSignature, opts *AlignOptions) Alignment {
+	ha := make([]uint64, len(a.Keyframes))
+	for i, k := range a.Keyframes {
+		ha[i] = k.Hash
+	}
+
+	hb := make([]uint64, len(b.Keyframes))
+	for i, k := range b.Keyframes {
+		hb[i] = k.Hash
+	}
+
+	al := AlignSequences(ha, hb, opts)
+	if al.Pairs > 0 {
+		al.Offset = a.Keyframes[al.A].Time - b.Keyframes[al.B].Time
+	}
+
+	return al
+}
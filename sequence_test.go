@@ -0,0 +1,74 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestAlignSequences(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	frames := make([]uint64, 100)
+	for i := range frames {
+		frames[i] = rng.Uint64()
+	}
+
+	// Two clips trimmed at different points, which share frames 40 to 70.
+	// The second one is re-encoded, and has a frame dropped and one
+	// repeated.
+	a := frames[10:70]
+	var b []uint64
+	for i, h := range frames[40:100] {
+		switch i {
+		case 5:
+			continue
+		case 12:
+			b = append(b, h^1)
+		}
+		b = append(b, h^1<<uint(i%64))
+	}
+
+	al := AlignSequences(a, b, nil)
+	if al.A != 30 || al.EndA != 60 || al.B != 0 || al.EndB != 30 || al.Pairs != 31 {
+		t.Fatalf("alignment %+v", al)
+	}
+
+	if al.Distance > 3 || al.Similarity() < 0.95 || al.Coverage != 0.5 {
+		t.Fatalf("alignment %+v", al)
+	}
+
+	if r := AlignSequences(b, a, nil); r.A != al.B || r.EndB != al.EndA || r.Distance != al.Distance {
+		t.Fatalf("reverse alignment %+v", r)
+	}
+
+	// A clip is covered in full.
+	if al := AlignSequences(frames, frames[20:50], nil); al.A != 20 || al.EndA != 50 || al.Coverage != 1 {
+		t.Fatalf("clip %+v", al)
+	}
+
+	// Unrelated sequences share nothing.
+	other := make([]uint64, 50)
+	for i := range other {
+		other[i] = rng.Uint64()
+	}
+
+	if al := AlignSequences(frames, other, &AlignOptions{Distance: 8}); al.Pairs != 0 || al.Similarity() != 0 {
+		t.Fatalf("unrelated %+v", al)
+	}
+
+	// Videos are aligned in time.
+	va, vb := new(VideoSignature), new(VideoSignature)
+	for i, h := range frames[:60] {
+		va.Keyframes = append(va.Keyframes, FrameHash{Hash: h, Time: time.Duration(i) * time.Second})
+	}
+	for i, h := range frames[45:] {
+		vb.Keyframes = append(vb.Keyframes, FrameHash{Hash: h, Time: time.Duration(i) * time.Second})
+	}
+
+	if al := AlignVideos(va, vb, nil); al.Offset != 45*time.Second || al.Pairs != 15 {
+		t.Fatalf("videos %+v", al)
+	}
+}
@@ -0,0 +1,85 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"sort"
+	"time"
+)
+
+// Series groups the given entries into series of similar images taken
+// shortly after each other, like the shots of a burst. Entries are taken
+// in order of their ModTime, which holds a time in seconds since the Unix
+// epoch. An entry joins a series if its hash is within the given Hamming
+// Distance of the series' last image, and it was taken no later than the
+// given window after it. This lets a series follow a subject which
+// moves, or a camera which pans, as long as each shot resembles the
+// previous one.
+//
+// If an entry matches several series, it joins the one whose last hash
+// is closest. Otherwise it starts a new series.
+//
+// Only series with at least two members are returned. Members are
+// sorted by time, and series by the time of their first member. Ties
+// are broken by path.
+func Series(entries []*Entry, distance uint64, window time.Duration) [][]*Entry {
+	sorted := append([]*Entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].ModTime != sorted[j].ModTime {
+			return sorted[i].ModTime < sorted[j].ModTime
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+
+	var series, open [][]*Entry
+
+	for _, e := range sorted {
+		best := -1
+		var bestDistance uint64
+
+		// Close series which are too old to be joined, and find the
+		// closest among the others. Entries come in order of time, so
+		// a closed series can never be joined again.
+		n := 0
+		for _, s := range open {
+			last := s[len(s)-1]
+			if time.Duration(e.ModTime-last.ModTime)*time.Second > window {
+				if len(s) > 1 {
+					series = append(series, s)
+				}
+				continue
+			}
+
+			open[n] = s
+			if d := Distance(e.Hash, last.Hash); d <= distance && (best < 0 || d < bestDistance) {
+				best, bestDistance = n, d
+			}
+			n++
+		}
+
+		open = open[:n]
+
+		if best < 0 {
+			open = append(open, []*Entry{e})
+		} else {
+			open[best] = append(open[best], e)
+		}
+	}
+
+	for _, s := range open {
+		if len(s) > 1 {
+			series = append(series, s)
+		}
+	}
+
+	sort.SliceStable(series, func(i, j int) bool {
+		a, b := series[i][0], series[j][0]
+		if a.ModTime != b.ModTime {
+			return a.ModTime < b.ModTime
+		}
+		return a.Path < b.Path
+	})
+
+	return series
+}
@@ -0,0 +1,60 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSeries(t *testing.T) {
+	entries := []*Entry{
+		// A burst which drifts by two bits per shot.
+		{Path: "a1", Hash: 0x00, ModTime: 100},
+		{Path: "a2", Hash: 0x03, ModTime: 101},
+		{Path: "a3", Hash: 0x0f, ModTime: 101},
+		{Path: "a4", Hash: 0x3f, ModTime: 103},
+
+		// An interleaved burst of another subject.
+		{Path: "b1", Hash: 0xff00, ModTime: 100},
+		{Path: "b2", Hash: 0xff01, ModTime: 102},
+
+		// The same subject as a, but much later.
+		{Path: "c1", Hash: 0x00, ModTime: 200},
+		{Path: "c2", Hash: 0x01, ModTime: 201},
+
+		// A lone shot.
+		{Path: "d1", Hash: 0xffff0000, ModTime: 300},
+	}
+
+	got := fmt.Sprint(paths(Series(entries, 2, 2*time.Second)))
+	if want := "[[a1 a2 a3 a4] [b1 b2] [c1 c2]]"; got != want {
+		t.Fatalf("series %s, want %s", got, want)
+	}
+
+	// With a shorter window, a4 and b2 come too late.
+	got = fmt.Sprint(paths(Series(entries, 2, time.Second)))
+	if want := "[[a1 a2 a3] [c1 c2]]"; got != want {
+		t.Fatalf("series %s, want %s", got, want)
+	}
+
+	// A window which spans everything joins c to a, but still
+	// separates the subjects.
+	got = fmt.Sprint(paths(Series(entries, 6, time.Hour)))
+	if want := "[[a1 a2 a3 a4 c1 c2] [b1 b2]]"; got != want {
+		t.Fatalf("series %s, want %s", got, want)
+	}
+}
+
+// paths returns the paths of all entries in the groups.
+func paths(groups [][]*Entry) [][]string {
+	out := make([][]string, len(groups))
+	for i, g := range groups {
+		for _, e := range g {
+			out[i] = append(out[i], e.Path)
+		}
+	}
+	return out
+}
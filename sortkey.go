@@ -0,0 +1,70 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+// SortKey maps an Average hash to a key which sorts similar hashes close
+// together. A plain ORDER BY on the key, in a database without a
+// similarity index, then clusters similar images: showing the rows around
+// an image makes for cheap "similar images" browsing.
+//
+// The bits of the hash are read along a Hilbert curve through its 8x8
+// grid, so neighbouring cells end up in neighbouring bits, and images
+// which agree in a region of the grid share a prefix. The result is
+// turned into a rank in Gray code order. Consecutive ranks differ in a
+// single bit, so unlike a plain sort, no two keys are adjacent merely
+// because a carry changed many bits at once.
+//
+// No ordering on a single key keeps all similar hashes together: two
+// hashes differing only in the first cells along the curve sort far
+// apart. The key is meant for browsing, not to replace a radius query.
+// Distinct hashes have distinct keys.
+//
+// Databases with signed 64 bit integers only, like PostgreSQL's bigint,
+// sort int64(SortKey(hash) ^ 1<<63) in the same order.
+func SortKey(hash uint64) uint64 {
+	var code uint64
+	for i, cell := range hilbertCells {
+		code |= (hash >> cell & 1) << uint(63-i)
+	}
+
+	// The rank of a Gray code is the XOR of all its
+	// bits at or above each position.
+	for shift := uint(1); shift < 64; shift <<= 1 {
+		code ^= code >> shift
+	}
+
+	return code
+}
+
+// hilbertCells lists the bits of an 8x8 grid, in the order a Hilbert
+// curve visits their cells.
+var hilbertCells = func() (cells [64]uint) {
+	for d := range cells {
+		x, y := hilbert(8, d)
+		cells[d] = uint(y*8 + x)
+	}
+	return
+}()
+
+// hilbert returns the cell at distance d along a Hilbert curve
+// through an n by n grid. The size n is a power of two.
+func hilbert(n, d int) (x, y int) {
+	for s := 1; s < n; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+
+		if ry == 0 {
+			if rx == 1 {
+				x, y = s-1-x, s-1-y
+			}
+			x, y = y, x
+		}
+
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+
+	return x, y
+}
@@ -0,0 +1,61 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"sort"
+	"testing"
+)
+
+func TestSortKey(t *testing.T) {
+	// The curve visits every cell once, moving to a neighbour each step.
+	var seen uint64
+	for i, cell := range hilbertCells {
+		seen |= 1 << cell
+		if i > 0 {
+			a, b := hilbertCells[i-1], hilbertCells[i]
+			if dx, dy := int(a%8)-int(b%8), int(a/8)-int(b/8); dx*dx+dy*dy != 1 {
+				t.Fatalf("cells %d and %d are not neighbours", a, b)
+			}
+		}
+	}
+
+	if seen != ^uint64(0) {
+		t.Fatalf("cells visited: 0x%x", seen)
+	}
+
+	// A corpus of images and altered copies. Sorted by key, neighbouring
+	// hashes should be closer than when sorted by value.
+	var hashes []uint64
+	transforms := []attack.Transform{attack.Crop(0.05), attack.JPEG(50), attack.Brightness(0.1)}
+	for i := 0; i < 100; i++ {
+		img := synth.Shapes(64, 64, int64(i))
+		hashes = append(hashes, Average(img))
+		for _, tf := range transforms {
+			hashes = append(hashes, Average(tf.Apply(img)))
+		}
+	}
+
+	spread := func(key func(uint64) uint64) uint64 {
+		sorted := append([]uint64(nil), hashes...)
+		sort.Slice(sorted, func(i, j int) bool { return key(sorted[i]) < key(sorted[j]) })
+
+		var sum uint64
+		for i := 1; i < len(sorted); i++ {
+			sum += Distance(sorted[i-1], sorted[i])
+		}
+		return sum
+	}
+
+	plain, keyed := spread(func(h uint64) uint64 { return h }), spread(SortKey)
+	if keyed >= plain {
+		t.Fatalf("distance between neighbours %d sorted by key, %d by value", keyed, plain)
+	}
+
+	if SortKey(0) != 0 || SortKey(1<<hilbertCells[0]) != ^uint64(0) || SortKey(1<<hilbertCells[63]) != 1 {
+		t.Fatal("unexpected keys")
+	}
+}
@@ -0,0 +1,257 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package sqlfunc generates SQL which computes the Hamming Distance
+between stored hashes in the database itself, so radius queries over
+small collections can run there, without an index in Go.
+
+Hashes may be stored in either of the forms of this package: as 64 bit
+integers, bit for bit as postgres.Encode stores them, or as the 16 hex
+digits of imghash.FormatHash. Postgres gets a function, created by the
+statements of Functions, which requires Postgres 14 or newer:
+
+	g := sqlfunc.New(&sqlfunc.Options{Dialect: sqlfunc.Postgres})
+	for _, stmt := range g.Functions() {
+		_, err := db.ExecContext(ctx, stmt)
+		...
+	}
+
+	rows, err := db.QueryContext(ctx, g.QuerySQL("images", "path", "hash"),
+		int64(hash), distance)
+
+SQLite can not define functions in SQL. Queries inline an expression
+computing the distance with the operators SQLite has instead, which
+works with any driver. Drivers which can register functions written in
+Go, such as github.com/mattn/go-sqlite3 through its ConnectHook, may
+register Distance or DistanceHex under the name of the function, which
+is faster:
+
+	conn.RegisterFunc("imghash_distance", sqlfunc.Distance, true)
+
+Every query compares against all rows, which is fast enough for tens
+of thousands of hashes. For more, use an imghash.Index.
+*/
+package sqlfunc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// DefaultName is the name of the function, if Options does not specify one.
+const DefaultName = "imghash_distance"
+
+// A Dialect is a flavour of SQL.
+type Dialect int
+
+// Known dialects.
+const (
+	Postgres Dialect = iota
+	SQLite
+	dialects
+)
+
+var dialectNames = [...]string{"postgres", "sqlite"}
+
+func (d Dialect) String() string {
+	if d < 0 || d >= dialects {
+		return fmt.Sprintf("Dialect(%d)", int(d))
+	}
+	return dialectNames[d]
+}
+
+// ParseDialect returns the dialect with the given name.
+func ParseDialect(name string) (Dialect, error) {
+	for d, n := range dialectNames {
+		if strings.EqualFold(n, name) {
+			return Dialect(d), nil
+		}
+	}
+	return 0, fmt.Errorf("sqlfunc: unknown dialect %q", name)
+}
+
+// An Encoding is the form in which hashes are stored.
+type Encoding int
+
+// Known encodings.
+const (
+	Integer Encoding = iota // 64 bit integers, as postgres.Encode stores them.
+	Hex                     // 16 hex digits, as imghash.FormatHash writes them.
+	encodings
+)
+
+var encodingNames = [...]string{"integer", "hex"}
+
+func (e Encoding) String() string {
+	if e < 0 || e >= encodings {
+		return fmt.Sprintf("Encoding(%d)", int(e))
+	}
+	return encodingNames[e]
+}
+
+// ParseEncoding returns the encoding with the given name.
+func ParseEncoding(name string) (Encoding, error) {
+	for e, n := range encodingNames {
+		if strings.EqualFold(n, name) {
+			return Encoding(e), nil
+		}
+	}
+	return 0, fmt.Errorf("sqlfunc: unknown encoding %q", name)
+}
+
+// Options configure a Generator.
+type Options struct {
+	Dialect  Dialect
+	Encoding Encoding
+
+	// Name of the function. Defaults to DefaultName.
+	Name string
+
+	// If set, SQLite queries call the function by its name, rather than
+	// inlining the expression. Distance or DistanceHex must then be
+	// registered with the driver under that name.
+	Registered bool
+}
+
+// A Generator generates SQL for one dialect and encoding.
+type Generator struct {
+	opts Options
+}
+
+// New returns a generator for the given options. Opts may be nil,
+// to generate Postgres for integer hashes.
+func New(opts *Options) *Generator {
+	g := &Generator{}
+	if opts != nil {
+		g.opts = *opts
+	}
+
+	if len(g.opts.Name) == 0 {
+		g.opts.Name = DefaultName
+	}
+
+	return g
+}
+
+// Functions returns the statements which create the function, or
+// replace it if it exists. SQLite gets none.
+func (g *Generator) Functions() []string {
+	if g.opts.Dialect != Postgres {
+		return nil
+	}
+
+	typ := "bigint"
+	if g.opts.Encoding == Hex {
+		typ = "text"
+	}
+
+	return []string{fmt.Sprintf("CREATE OR REPLACE FUNCTION %s(a %s, b %s) RETURNS integer "+
+		"LANGUAGE sql IMMUTABLE STRICT PARALLEL SAFE AS $$ SELECT %s::integer $$",
+		quoteIdent(g.opts.Name), typ, typ, g.Expr("a", "b"))}
+}
+
+// Distance returns an expression computing the distance between the
+// hashes to which the SQL expressions a and b evaluate, through the
+// function. SQLite queries inline the expression, unless the options
+// say the function is registered.
+func (g *Generator) Distance(a, b string) string {
+	if g.opts.Dialect == SQLite && !g.opts.Registered {
+		return g.Expr(a, b)
+	}
+	return fmt.Sprintf("%s(%s, %s)", quoteIdent(g.opts.Name), a, b)
+}
+
+// Expr returns an expression computing the distance between the
+// hashes to which the SQL expressions a and b evaluate, without calling
+// a function of its own. It may refer to a and b several times.
+func (g *Generator) Expr(a, b string) string {
+	switch {
+	case g.opts.Dialect == Postgres && g.opts.Encoding == Hex:
+		return fmt.Sprintf("bit_count(('x' || %s)::bit(64) # ('x' || %s)::bit(64))", a, b)
+	case g.opts.Dialect == Postgres:
+		return fmt.Sprintf("bit_count((%s # %s)::bit(64))", a, b)
+	case g.opts.Encoding == Hex:
+		return sqliteHexExpr(a, b)
+	}
+	return sqliteIntExpr(a, b)
+}
+
+// QuerySQL returns a query for all rows of table within a distance of
+// a hash, closest first. It takes the hash, in the stored encoding, and
+// the distance as parameters, and yields the id and hash columns of each
+// match, followed by its distance.
+func (g *Generator) QuerySQL(table, id, hash string) string {
+	h, d := "$1", "$2"
+	if g.opts.Dialect == SQLite {
+		h, d = "?1", "?2"
+	}
+
+	if g.opts.Dialect == Postgres && g.opts.Encoding == Integer {
+		h = h + "::bigint"
+	}
+
+	// The distance is computed once per row, in a subquery.
+	return fmt.Sprintf("SELECT * FROM (SELECT %s, %s, %s AS distance FROM %s) AS t WHERE distance <= %s ORDER BY distance, %s",
+		quoteIdent(id), quoteIdent(hash), g.Distance(quoteIdent(hash), h), quoteIdent(table), d, quoteIdent(id))
+}
+
+// sqliteIntExpr returns the distance between integer hashes in SQLite,
+// which has neither an exclusive or nor a population count. Sums over
+// all 64 bits would overflow to floating point, so the two halves are
+// counted apart.
+func sqliteIntExpr(a, b string) string {
+	x := fmt.Sprintf("((%s | %s) & ~(%s & %s))", a, b, a, b)
+	return fmt.Sprintf("(%s + %s)", popcount32("("+x+" & 4294967295)"), popcount32("(("+x+" >> 32) & 4294967295)"))
+}
+
+// popcount32 returns the number of bits set in the SQLite expression v,
+// which must be in the range of an unsigned 32 bit integer.
+func popcount32(v string) string {
+	v = fmt.Sprintf("(%s - ((%s >> 1) & 1431655765))", v, v)
+	v = fmt.Sprintf("((%s & 858993459) + ((%s >> 2) & 858993459))", v, v)
+	v = fmt.Sprintf("((%s + (%s >> 4)) & 252645135)", v, v)
+	return fmt.Sprintf("(((%s * 16843009) & 4294967295) >> 24)", v)
+}
+
+// sqliteHexExpr returns the distance between hex hashes in SQLite,
+// counted a digit at a time.
+func sqliteHexExpr(a, b string) string {
+	digit := func(s string, i int) string {
+		return fmt.Sprintf("(instr('0123456789abcdef', lower(substr(%s, %d, 1))) - 1)", s, i)
+	}
+
+	terms := make([]string, 16)
+	for i := range terms {
+		da, db := digit(a, i+1), digit(b, i+1)
+		terms[i] = fmt.Sprintf("CAST(substr('0112122312232334', ((%s | %s) & ~(%s & %s)) + 1, 1) AS INTEGER)", da, db, da, db)
+	}
+
+	return "(" + strings.Join(terms, " + ") + ")"
+}
+
+// Distance returns the distance between two integer hashes, for
+// registration with drivers which take functions written in Go.
+func Distance(a, b int64) int64 {
+	return int64(bits.OnesCount64(uint64(a ^ b)))
+}
+
+// DistanceHex returns the distance between two hex hashes, as
+// Distance does for integer hashes.
+func DistanceHex(a, b string) (int64, error) {
+	ha, erra := strconv.ParseUint(a, 16, 64)
+	hb, errb := strconv.ParseUint(b, 16, 64)
+	if err := errors.Join(erra, errb); err != nil {
+		return 0, err
+	}
+	return int64(imghash.Distance(ha, hb)), nil
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
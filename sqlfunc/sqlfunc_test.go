@@ -0,0 +1,70 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package sqlfunc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostgres(t *testing.T) {
+	g := New(&Options{Name: `my"distance`})
+
+	want := `CREATE OR REPLACE FUNCTION "my""distance"(a bigint, b bigint) RETURNS integer ` +
+		`LANGUAGE sql IMMUTABLE STRICT PARALLEL SAFE AS $$ SELECT bit_count((a # b)::bit(64))::integer $$`
+	if f := g.Functions(); len(f) != 1 || f[0] != want {
+		t.Fatalf("functions:\n%v\nwant:\n%s", f, want)
+	}
+
+	want = `SELECT * FROM (SELECT "path", "hash", "my""distance"("hash", $1::bigint) AS distance FROM "images") AS t ` +
+		`WHERE distance <= $2 ORDER BY distance, "path"`
+	if q := g.QuerySQL("images", "path", "hash"); q != want {
+		t.Fatalf("query:\n%s\nwant:\n%s", q, want)
+	}
+
+	g = New(&Options{Encoding: Hex})
+	if e := g.Expr("a", "b"); e != `bit_count(('x' || a)::bit(64) # ('x' || b)::bit(64))` {
+		t.Fatalf("hex expression %s", e)
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	g := New(&Options{Dialect: SQLite})
+	if g.Functions() != nil {
+		t.Fatal("functions for SQLite")
+	}
+
+	// Neither an exclusive or, nor a function of its own.
+	q := g.QuerySQL("images", "path", "hash")
+	if strings.Contains(q, "^") || strings.Contains(q, DefaultName) || !strings.Contains(q, "?1") {
+		t.Fatalf("query %s", q)
+	}
+
+	g = New(&Options{Dialect: SQLite, Encoding: Hex, Registered: true})
+	if d := g.Distance(`"hash"`, "?1"); d != `"imghash_distance"("hash", ?1)` {
+		t.Fatalf("registered distance %s", d)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	if d := Distance(-1, 0); d != 64 {
+		t.Fatalf("distance %d", d)
+	}
+
+	if d, err := DistanceHex("ffffffffffffffff", "FFFFFFFFFFFFFFF0"); err != nil || d != 4 {
+		t.Fatalf("hex distance %d, %v", d, err)
+	}
+
+	if _, err := DistanceHex("nothex", "0"); err == nil {
+		t.Fatal("no error for a malformed hash")
+	}
+
+	if d, err := ParseDialect("SQLite"); err != nil || d != SQLite {
+		t.Fatalf("dialect %v, %v", d, err)
+	}
+
+	if _, err := ParseEncoding("base64"); err == nil {
+		t.Fatal("no error for an unknown encoding")
+	}
+}
@@ -0,0 +1,173 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"sort"
+)
+
+// Size of the grid SSIM compares images on, and of its windows.
+const (
+	ssimSize   = 64
+	ssimWindow = 8
+	ssimStep   = 4
+)
+
+// SSIM returns the structural similarity of two images, from -1 to 1.
+// Copies of an image score close to 1; unrelated images around 0.
+//
+// It is meant to verify the matches of a hash search. A 64 bit hash
+// throws away nearly everything, so flat or heavily textured images
+// which have little in common can still land on nearby hashes. SSIM
+// compares the images themselves, shrunk to a 64x64 grid and stretched
+// the way the hashes do. It averages the mean, variance and covariance
+// based SSIM index over 8x8 windows and the red, green and blue
+// channels, so images of different colours but equal brightness do not
+// pass for copies. This is much slower than comparing hashes, but only
+// needs to be done for the candidates a search turns up.
+//
+// Refer to VerifyGroups for checking duplicate groups with it.
+func SSIM(a, b image.Image) float64 {
+	return ssimColor(ssimGrid(a), ssimGrid(b))
+}
+
+// ssimGrid returns the red, green and blue channels of img on the SSIM
+// grid, from 0 to 1, in row-major order.
+func ssimGrid(img image.Image) [3][]float64 {
+	small := resize(bounded(img), ssimSize, ssimSize)
+
+	var out [3][]float64
+	for c := range out {
+		out[c] = make([]float64, ssimSize*ssimSize)
+	}
+
+	var x, y int
+	var r, g, b uint32
+	for y = 0; y < ssimSize; y++ {
+		for x = 0; x < ssimSize; x++ {
+			r, g, b, _ = small.At(x, y).RGBA()
+			out[0][y*ssimSize+x] = float64(r) / 0xffff
+			out[1][y*ssimSize+x] = float64(g) / 0xffff
+			out[2][y*ssimSize+x] = float64(b) / 0xffff
+		}
+	}
+
+	return out
+}
+
+// ssimColor returns the mean SSIM of the channels of two grids.
+func ssimColor(a, b [3][]float64) float64 {
+	return (ssim(a[0], b[0]) + ssim(a[1], b[1]) + ssim(a[2], b[2])) / 3
+}
+
+// ssim returns the mean SSIM index over all windows of two grids.
+func ssim(a, b []float64) float64 {
+	// Constants from the original paper, for a dynamic range of 1.
+	const c1, c2 = 0.01 * 0.01, 0.03 * 0.03
+	const n = ssimWindow * ssimWindow
+
+	var sum float64
+	var windows int
+
+	var wx, wy, x, y int
+	for wy = 0; wy+ssimWindow <= ssimSize; wy += ssimStep {
+		for wx = 0; wx+ssimWindow <= ssimSize; wx += ssimStep {
+			var sa, sb, saa, sbb, sab float64
+
+			for y = wy; y < wy+ssimWindow; y++ {
+				for x = wx; x < wx+ssimWindow; x++ {
+					va, vb := a[y*ssimSize+x], b[y*ssimSize+x]
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+
+			ma, mb := sa/n, sb/n
+			vara, varb := saa/n-ma*ma, sbb/n-mb*mb
+			cov := sab/n - ma*mb
+
+			sum += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (vara + varb + c2))
+			windows++
+		}
+	}
+
+	return sum / float64(windows)
+}
+
+// VerifyGroups checks the groups of duplicates found by DedupeFiles, or
+// by any other hash search, by comparing the images themselves. Two
+// members of a group stay together if their SSIM is at least min, or
+// if they are both linked to a third member that way; other members
+// leave the group. Groups which end up with a single member are
+// dropped, and groups may be split. Members and groups are sorted by
+// path.
+//
+// Every file is decoded again, once. Files which fail to decode leave
+// their group. A min of 0.8 keeps re-encoded, resized and slightly
+// cropped copies, while removing most unrelated images which happened
+// to hash alike. Flat images of similar colours still pass, as they do
+// look alike.
+func VerifyGroups(groups [][]*Entry, min float64) [][]*Entry {
+	var out [][]*Entry
+
+	for _, group := range groups {
+		var members []*Entry
+		var grids [][3][]float64
+
+		for _, e := range group {
+			img, err := DecodeFile(e.Path)
+			if err != nil {
+				continue
+			}
+
+			members = append(members, e)
+			grids = append(grids, ssimGrid(img))
+		}
+
+		// Link members by SSIM, and collect the linked sets.
+		parent := make([]int, len(members))
+		for i := range parent {
+			parent[i] = i
+		}
+
+		var find func(int) int
+		find = func(i int) int {
+			for parent[i] != i {
+				parent[i] = parent[parent[i]]
+				i = parent[i]
+			}
+			return i
+		}
+
+		var i, j int
+		for i = range members {
+			for j = i + 1; j < len(members); j++ {
+				if find(i) != find(j) && ssimColor(grids[i], grids[j]) >= min {
+					parent[find(j)] = find(i)
+				}
+			}
+		}
+
+		sets := make(map[int][]*Entry)
+		for i, e := range members {
+			sets[find(i)] = append(sets[find(i)], e)
+		}
+
+		for _, set := range sets {
+			if len(set) < 2 {
+				continue
+			}
+
+			sort.Slice(set, func(i, j int) bool { return set[i].Path < set[j].Path })
+			out = append(out, set)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i][0].Path < out[j][0].Path })
+	return out
+}
@@ -0,0 +1,82 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+func TestSSIM(t *testing.T) {
+	img := synth.Shapes(128, 96, 1)
+
+	if s := SSIM(img, img); s < 0.9999 {
+		t.Fatalf("identical images: %f", s)
+	}
+
+	if s := SSIM(img, attack.JPEG(75).Apply(img)); s < 0.8 {
+		t.Fatalf("JPEG copy: %f", s)
+	}
+
+	if s := SSIM(img, synth.Shapes(128, 96, 2)); s > 0.5 {
+		t.Fatalf("unrelated images: %f", s)
+	}
+
+	// Flat images hash alike, whatever their colour.
+	red, green := flatImage(color.RGBA{0xc0, 0x20, 0x20, 0xff}), flatImage(color.RGBA{0x20, 0x80, 0x20, 0xff})
+	if Average(red) != Average(green) {
+		t.Fatal("flat images hash differently")
+	}
+
+	if s := SSIM(red, green); s > 0.7 {
+		t.Fatalf("red and green: %f", s)
+	}
+}
+
+func TestVerifyGroups(t *testing.T) {
+	dir := t.TempDir()
+	img := synth.Shapes(128, 96, 1)
+	red, green := flatImage(color.RGBA{0xc0, 0x20, 0x20, 0xff}), flatImage(color.RGBA{0x20, 0x80, 0x20, 0xff})
+
+	files := map[string]image.Image{
+		"a.png":     img,
+		"b.png":     attack.JPEG(75).Apply(img),
+		"red.png":   red,
+		"green.png": green,
+		"red2.png":  attack.JPEG(90).Apply(red),
+	}
+
+	for name, m := range files {
+		if err := saveImg(m, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry := func(name string) *Entry {
+		return &Entry{Path: filepath.Join(dir, name), Hash: Average(files[name])}
+	}
+
+	groups := VerifyGroups([][]*Entry{
+		{entry("a.png"), entry("b.png"), entry("green.png")},
+		{entry("green.png"), entry("red.png"), entry("red2.png")},
+		{entry("red.png"), {Path: filepath.Join(dir, "missing.png")}},
+	}, 0.8)
+
+	if got := paths(groups); len(got) != 2 || len(got[0]) != 2 || len(got[1]) != 2 ||
+		filepath.Base(got[0][1]) != "b.png" || filepath.Base(got[1][0]) != "red.png" {
+		t.Fatalf("groups %v", got)
+	}
+}
+
+// flatImage returns an image in a single colour.
+func flatImage(c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
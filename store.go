@@ -0,0 +1,163 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"sync"
+)
+
+// A Store holds hashes by ID, and answers radius queries. Index
+// implements it in memory, through IndexStore. Other implementations,
+// like the one in the redis subpackage, keep the hashes in a database,
+// so several processes can share them.
+//
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Add adds the given ID and hash. If the ID already
+	// exists, its hash is replaced.
+	Add(ctx context.Context, id string, hash uint64) error
+
+	// Remove removes the given ID, if it exists.
+	Remove(ctx context.Context, id string) error
+
+	// Query finds all hashes within the given distance of hash,
+	// sorted by distance. The Path field of each result holds the ID.
+	Query(ctx context.Context, hash, distance uint64) (ResultSet, error)
+}
+
+// A Snapshotter is a Store which can take a snapshot of its hashes,
+// as an Index, while it goes on serving queries. Stores returned by
+// IndexStore implement it.
+type Snapshotter interface {
+	Snapshot() *Index
+}
+
+// A Counter is a Store which can count the hashes within distance of
+// another without collecting them. Stores returned by IndexStore and
+// MappedStore implement it.
+type Counter interface {
+	Count(ctx context.Context, hash, distance uint64) (int, error)
+}
+
+// CountStore returns the number of hashes in s within distance of hash.
+// It counts them in the store if s is a Counter, and queries for them
+// otherwise.
+func CountStore(ctx context.Context, s Store, hash, distance uint64) (int, error) {
+	if c, ok := s.(Counter); ok {
+		return c.Count(ctx, hash, distance)
+	}
+
+	rs, err := s.Query(ctx, hash, distance)
+	return len(rs), err
+}
+
+// A Streamer is a Store which can pass the hashes within distance of
+// another to a function as it finds them, without collecting them.
+// Stores returned by IndexStore and MappedStore implement it.
+type Streamer interface {
+	// QueryFunc calls f for every hash within distance of hash, in no
+	// particular order, until f returns false. The Path field of each
+	// result holds the ID. It returns the error of ctx if ctx is done
+	// before the results are.
+	QueryFunc(ctx context.Context, hash, distance uint64, f func(*SearchResult) bool) error
+}
+
+// StreamStore calls f for every hash in s within distance of hash,
+// until f returns false. It streams them from the store if s is a
+// Streamer, in no particular order, and queries for them otherwise, in
+// the order of Query.
+func StreamStore(ctx context.Context, s Store, hash, distance uint64, f func(*SearchResult) bool) error {
+	if st, ok := s.(Streamer); ok {
+		return st.QueryFunc(ctx, hash, distance, f)
+	}
+
+	rs, err := s.Query(ctx, hash, distance)
+	for _, r := range rs {
+		if !f(r) {
+			break
+		}
+	}
+	return err
+}
+
+// streamHits adapts f, a function passed to QueryFunc, to the hits of
+// Index.SearchFunc. The hits stop once ctx is done, and err then holds
+// its error.
+func streamHits(ctx context.Context, f func(*SearchResult) bool, err *error) func(Hit) bool {
+	var n int
+	return func(h Hit) bool {
+		if n++; n%1024 == 0 {
+			if *err = ctx.Err(); *err != nil {
+				return false
+			}
+		}
+		return f(&SearchResult{Path: h.ID, Hash: h.Hash, Distance: h.Distance})
+	}
+}
+
+// IndexStore returns a Store backed by the given index. The index must
+// not be modified directly while the store is in use.
+func IndexStore(x *Index) Store {
+	return &indexStore{x: x}
+}
+
+// indexStore guards an Index with a lock.
+type indexStore struct {
+	mu sync.RWMutex
+	x  *Index
+}
+
+func (s *indexStore) Add(ctx context.Context, id string, hash uint64) error {
+	_, end := StartSpan(ctx, SpanIndexAdd)
+	defer end(nil)
+
+	s.mu.Lock()
+	s.x.Add(id, hash)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *indexStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	s.x.Remove(id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *indexStore) Snapshot() *Index {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.x.Snapshot()
+}
+
+func (s *indexStore) Query(ctx context.Context, hash, distance uint64) (ResultSet, error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.x.Query(hash, distance), nil
+}
+
+// QueryFunc holds the read lock while the results stream, so changes
+// wait for f to finish.
+func (s *indexStore) QueryFunc(ctx context.Context, hash, distance uint64, f func(*SearchResult) bool) (err error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.x.SearchFunc(hash, distance, streamHits(ctx, f, &err))
+	return err
+}
+
+func (s *indexStore) Count(ctx context.Context, hash, distance uint64) (int, error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.x.Count(hash, distance), nil
+}
@@ -0,0 +1,236 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package synth generates synthetic images, for benchmarks, tests and
+examples which should not depend on a collection of image files.
+
+Each generator stresses a different distribution of pixel values:
+smooth gradients, white noise, flat areas with hard edges, periodic
+patterns, uniform colour and text. Images are fully determined by their
+size and seed, so the same corpus can be recreated anywhere:
+
+	for _, img := range synth.Corpus(100, nil, 1) {
+		hash := imghash.Average(img)
+		...
+	}
+*/
+package synth
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// A Generator creates an image of the given size.
+// The seed determines its content.
+type Generator func(w, h int, seed int64) *image.RGBA
+
+// Generators lists all generators by name.
+var Generators = map[string]Generator{
+	"checker":  Checker,
+	"flat":     Flat,
+	"gradient": Gradient,
+	"noise":    Noise,
+	"shapes":   Shapes,
+	"text":     Text,
+}
+
+// DefaultSizes are the image sizes Corpus uses if none are given.
+var DefaultSizes = []image.Point{{64, 64}, {320, 240}, {1024, 768}}
+
+// Corpus generates n images. It cycles through all generators, in order
+// of their names, and through the given sizes. Each image gets its own
+// seed, derived from the given one. Sizes may be nil, to use DefaultSizes.
+func Corpus(n int, sizes []image.Point, seed int64) []*image.RGBA {
+	if len(sizes) == 0 {
+		sizes = DefaultSizes
+	}
+
+	names := make([]string, 0, len(Generators))
+	for name := range Generators {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	out := make([]*image.RGBA, n)
+	for i := range out {
+		gen := Generators[names[i%len(names)]]
+		size := sizes[(i/len(names))%len(sizes)]
+		out[i] = gen(size.X, size.Y, seed+int64(i))
+	}
+
+	return out
+}
+
+// Flat returns an image in a single, random colour.
+func Flat(w, h int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, image.NewUniform(randomColor(rng)), image.Point{}, draw.Src)
+	return img
+}
+
+// Gradient returns a linear gradient between two random colours,
+// at a random angle.
+func Gradient(w, h int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	a, b := randomColor(rng), randomColor(rng)
+
+	sin, cos := math.Sincos(rng.Float64() * 2 * math.Pi)
+	length := math.Abs(float64(w)*cos) + math.Abs(float64(h)*sin)
+	if length == 0 {
+		length = 1
+	}
+
+	var x, y int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			// Position along the gradient, in the range 0-1.
+			t := ((float64(x)-float64(w)/2)*cos+(float64(y)-float64(h)/2)*sin)/length + 0.5
+			img.SetRGBA(x, y, mix(a, b, t))
+		}
+	}
+
+	return img
+}
+
+// Noise returns white noise: every channel of every pixel is random.
+func Noise(w, h int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = uint8(rng.Intn(256))
+		img.Pix[i+1] = uint8(rng.Intn(256))
+		img.Pix[i+2] = uint8(rng.Intn(256))
+		img.Pix[i+3] = 0xff
+	}
+
+	return img
+}
+
+// Checker returns a checkerboard in two random colours,
+// with a random square size.
+func Checker(w, h int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	a, b := randomColor(rng), randomColor(rng)
+
+	size := 2
+	if w/4 > size {
+		size += rng.Intn(w/4 - 1)
+	}
+
+	var x, y int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			if (x/size+y/size)%2 == 0 {
+				img.SetRGBA(x, y, a)
+			} else {
+				img.SetRGBA(x, y, b)
+			}
+		}
+	}
+
+	return img
+}
+
+// Shapes returns a number of random rectangles and ellipses,
+// on a background of a random colour.
+func Shapes(w, h int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, image.NewUniform(randomColor(rng)), image.Point{}, draw.Src)
+
+	if w < 2 || h < 2 {
+		return img
+	}
+
+	n := 3 + rng.Intn(6)
+	for i := 0; i < n; i++ {
+		x0, y0 := rng.Intn(w), rng.Intn(h)
+		r := image.Rect(x0, y0, x0+1+rng.Intn(w/2), y0+1+rng.Intn(h/2)).Intersect(img.Rect)
+		c := image.NewUniform(randomColor(rng))
+
+		if rng.Intn(2) == 0 {
+			draw.Draw(img, r, c, image.Point{}, draw.Src)
+			continue
+		}
+
+		draw.DrawMask(img, r, c, image.Point{}, &ellipse{r}, r.Min, draw.Over)
+	}
+
+	return img
+}
+
+// Text returns a few lines of random words, over a gradient.
+func Text(w, h int, seed int64) *image.RGBA {
+	img := Gradient(w, h, seed)
+	rng := rand.New(rand.NewSource(seed + 1))
+
+	scale := 1 + w/200
+	lineHeight := (glyphHeight + 2) * scale
+	c := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	if rng.Intn(2) == 0 {
+		c = color.RGBA{0, 0, 0, 0xff}
+	}
+
+	for y := lineHeight / 2; y+lineHeight <= h; y += 2 * lineHeight {
+		var line []string
+		n := 2 + rng.Intn(4)
+
+		for i := 0; i < n; i++ {
+			line = append(line, words[rng.Intn(len(words))])
+		}
+
+		DrawText(img, image.Pt(lineHeight/2, y), scale, c, strings.Join(line, " "))
+	}
+
+	return img
+}
+
+// words are used by Text.
+var words = []string{
+	"IMAGE", "PHOTO", "COPY", "SAMPLE", "DRAFT", "2024", "NO. 7",
+	"HELLO", "WORLD", "HASH", "TEST", "(C) ACME", "FINAL!", "V2.1",
+}
+
+// ellipse is a mask covering the ellipse inscribed in a rectangle.
+type ellipse struct {
+	r image.Rectangle
+}
+
+func (e *ellipse) ColorModel() color.Model { return color.AlphaModel }
+func (e *ellipse) Bounds() image.Rectangle { return e.r }
+
+func (e *ellipse) At(x, y int) color.Color {
+	rx, ry := float64(e.r.Dx())/2, float64(e.r.Dy())/2
+	dx := (float64(x-e.r.Min.X) + 0.5 - rx) / rx
+	dy := (float64(y-e.r.Min.Y) + 0.5 - ry) / ry
+
+	if dx*dx+dy*dy <= 1 {
+		return color.Opaque
+	}
+
+	return color.Transparent
+}
+
+// randomColor returns an opaque colour.
+func randomColor(rng *rand.Rand) color.RGBA {
+	return color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+}
+
+// mix interpolates between two colours, with t in the range 0-1.
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	lerp := func(a, b uint8) uint8 { return uint8(float64(a)*(1-t) + float64(b)*t + 0.5) }
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 0xff}
+}
@@ -0,0 +1,88 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package synth
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGenerators(t *testing.T) {
+	for name, gen := range Generators {
+		for _, size := range []image.Point{{1, 1}, {3, 2}, {64, 48}} {
+			a := gen(size.X, size.Y, 1)
+			if a.Rect != image.Rect(0, 0, size.X, size.Y) {
+				t.Fatalf("%s: bounds %v, want %v", name, a.Rect, size)
+			}
+
+			if b := gen(size.X, size.Y, 1); !bytes.Equal(a.Pix, b.Pix) {
+				t.Fatalf("%s %v: not deterministic", name, size)
+			}
+		}
+
+		if name == "flat" {
+			continue
+		}
+
+		a, b := gen(64, 48, 1), gen(64, 48, 2)
+		if bytes.Equal(a.Pix, b.Pix) {
+			t.Fatalf("%s: seed has no effect", name)
+		}
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	sizes := []image.Point{{16, 16}, {32, 8}}
+	imgs := Corpus(2*len(Generators)+1, sizes, 1)
+
+	if len(imgs) != 2*len(Generators)+1 {
+		t.Fatalf("got %d images", len(imgs))
+	}
+
+	for i, img := range imgs {
+		want := sizes[(i/len(Generators))%len(sizes)]
+		if img.Rect.Size() != want {
+			t.Fatalf("image %d: size %v, want %v", i, img.Rect.Size(), want)
+		}
+	}
+
+	if len(Corpus(0, nil, 1)) != 0 {
+		t.Fatal("expected no images")
+	}
+}
+
+func TestDrawText(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 10))
+	DrawText(img, image.Pt(1, 1), 1, color.White, "hi ~")
+
+	// H and I have 17 and 11 pixels set. The space and
+	// the unsupported character draw nothing.
+	var n int
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i] == 0xff {
+			n++
+		}
+	}
+
+	if n != 17+11 {
+		t.Fatalf("%d pixels set", n)
+	}
+
+	// Scaling multiplies the area of each pixel.
+	img = image.NewRGBA(image.Rect(0, 0, 40, 40))
+	DrawText(img, image.Pt(0, 0), 3, color.White, "I")
+
+	n = 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i] == 0xff {
+			n++
+		}
+	}
+
+	if n != 11*9 {
+		t.Fatalf("%d pixels set at scale 3", n)
+	}
+}
@@ -0,0 +1,87 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"io"
+)
+
+// A TeeHasher hashes an image as it is read by someone else. It passes
+// the data of the underlying reader through unchanged, and keeps a copy.
+// Once the reader is exhausted, Hash decodes the copy. This lets a proxy
+// or upload handler hash what it forwards or stores, without reading
+// the data twice:
+//
+//	th := imghash.NewTeeHasher(req.Body, imghash.Average)
+//	if _, err := io.Copy(dst, th); err != nil {
+//		...
+//	}
+//	hash, err := th.Hash()
+//
+// A TeeHasher is not safe for concurrent use.
+type TeeHasher struct {
+	// Largest image to keep a copy of, in bytes. Larger streams are
+	// still passed through in full, but Hash fails with ErrTooLarge.
+	// Defaults to 32MB. Set it before the first Read.
+	MaxSize int64
+
+	r    io.Reader
+	hf   HashFunc
+	buf  bytes.Buffer
+	eof  bool // The reader returned io.EOF.
+	over bool // The stream exceeds MaxSize.
+
+	// Result, once computed.
+	hashed bool
+	hash   uint64
+	err    error
+}
+
+// NewTeeHasher returns a TeeHasher which reads from r, and hashes the
+// data with the given HashFunc.
+func NewTeeHasher(r io.Reader, hf HashFunc) *TeeHasher {
+	return &TeeHasher{MaxSize: defaultFetchSize, r: r, hf: hf}
+}
+
+// Read reads from the underlying reader, and keeps a copy of the data.
+func (t *TeeHasher) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+
+	if n > 0 && !t.over {
+		if int64(t.buf.Len()+n) > t.MaxSize {
+			t.over = true
+			t.buf = bytes.Buffer{}
+		} else {
+			t.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF {
+		t.eof = true
+	}
+
+	return n, err
+}
+
+// Hash returns the hash of the image read so far. It fails with
+// ErrIncomplete until the underlying reader has returned io.EOF, and
+// with ErrTooLarge if the data exceeds MaxSize. Otherwise, the error is
+// that of ComputeReader. The image is only decoded once; later calls
+// return the same result.
+func (t *TeeHasher) Hash() (uint64, error) {
+	switch {
+	case t.over:
+		return 0, ErrTooLarge
+	case !t.eof:
+		return 0, ErrIncomplete
+	case t.hashed:
+		return t.hash, t.err
+	}
+
+	t.hash, t.err = ComputeReader(&t.buf, t.hf)
+	t.hashed = true
+	t.buf = bytes.Buffer{}
+	return t.hash, t.err
+}
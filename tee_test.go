@@ -0,0 +1,60 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestTeeHasher(t *testing.T) {
+	data, err := os.ReadFile("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	th := NewTeeHasher(bytes.NewReader(data), Average)
+	var out bytes.Buffer
+
+	// Stop short of the end.
+	if _, err := io.CopyN(&out, th, int64(len(data)/2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := th.Hash(); err != ErrIncomplete {
+		t.Fatalf("partial stream: %v", err)
+	}
+
+	if _, err := io.Copy(&out, th); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("data changed in passing")
+	}
+
+	hash, err := th.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := getHash(t, Average, "testdata/gopher_small.png"); hash != want {
+		t.Fatalf("hash %016x, want %016x", hash, want)
+	}
+
+	// Oversized streams still pass through.
+	th = NewTeeHasher(bytes.NewReader(data), Average)
+	th.MaxSize = 100
+	out.Reset()
+
+	if _, err := io.Copy(&out, th); err != nil || out.Len() != len(data) {
+		t.Fatalf("oversized stream: %d bytes, %v", out.Len(), err)
+	}
+
+	if _, err := th.Hash(); err != ErrTooLarge {
+		t.Fatalf("oversized stream: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package testimg provides assertions for visual regression tests, built
+on perceptual hashes. Rendering code, like a chart or thumbnail
+generator, is tested by comparing its output with a reference image,
+allowing for the small differences a change of encoder, font
+rasterizer or platform makes:
+
+	func TestChart(t *testing.T) {
+		img := render(chart)
+		testimg.AssertGolden(t, img, "testdata/chart.png", 4)
+	}
+
+Run the tests with TESTIMG_UPDATE=1 in the environment to write the
+reference images of AssertGolden from the output instead.
+
+Images are compared by hash, so their sizes need not match: a reference
+rendered at a higher resolution matches the output at a lower one.
+*/
+package testimg
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// UpdateEnv is the environment variable which, when not empty, makes
+// AssertGolden write its reference images.
+const UpdateEnv = "TESTIMG_UPDATE"
+
+// Hash computes the hashes the assertions compare. Defaults to
+// imghash.Average.
+var Hash imghash.HashFunc = imghash.Average
+
+// Tiles is the number of columns and rows of tiles failing assertions
+// compare, to point at the region the images differ most in.
+var Tiles = 4
+
+// T is the part of testing.TB the assertions use.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Distance returns the Hamming Distance between the hashes of two images.
+func Distance(got, want image.Image) uint64 {
+	return imghash.Distance(Hash(got), Hash(want))
+}
+
+// AssertSimilar reports an error if the hashes of got and want lie more
+// than maxDistance apart, along with the region they differ most in.
+// It returns true if the assertion held.
+func AssertSimilar(t T, got, want image.Image, maxDistance uint64) bool {
+	t.Helper()
+
+	if d := Distance(got, want); d > maxDistance {
+		t.Errorf("images differ: distance %d, want at most %d; %s", d, maxDistance, worstTile(got, want))
+		return false
+	}
+
+	return true
+}
+
+// AssertDifferent reports an error if the hashes of got and want lie
+// less than minDistance apart, to check that a change was rendered at
+// all. It returns true if the assertion held.
+func AssertDifferent(t T, got, want image.Image, minDistance uint64) bool {
+	t.Helper()
+
+	if d := Distance(got, want); d < minDistance {
+		t.Errorf("images match: distance %d, want at least %d", d, minDistance)
+		return false
+	}
+
+	return true
+}
+
+// AssertGolden is like AssertSimilar, with the reference image read from
+// the given file. If UpdateEnv is set, it writes got to the file as a
+// PNG instead, and creates its directory if needed.
+func AssertGolden(t T, got image.Image, file string, maxDistance uint64) bool {
+	t.Helper()
+
+	if len(os.Getenv(UpdateEnv)) > 0 {
+		if err := writePNG(file, got); err != nil {
+			t.Errorf("update %s: %v", file, err)
+			return false
+		}
+		return true
+	}
+
+	want, err := imghash.DecodeFile(file)
+	if err != nil {
+		t.Errorf("%v; run with %s=1 to create it", err, UpdateEnv)
+		return false
+	}
+
+	if d := Distance(got, want); d > maxDistance {
+		t.Errorf("image differs from %s: distance %d, want at most %d; %s", file, d, maxDistance, worstTile(got, want))
+		return false
+	}
+
+	return true
+}
+
+// worstTile describes the tile of got which differs most from the
+// same tile of want.
+func worstTile(got, want image.Image) string {
+	h := imghash.CompareTiles(got, want, Tiles, Tiles, Hash)
+
+	var worst int
+	for i, d := range h.Distances {
+		if d > h.Distances[worst] {
+			worst = i
+		}
+	}
+
+	r := h.Tile(got.Bounds(), worst%h.Cols, worst/h.Cols)
+	return fmt.Sprintf("most in %v, at distance %d", r, h.Distances[worst])
+}
+
+// writePNG writes img to the given file, in PNG format.
+func writePNG(file string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err = png.Encode(fd, img); err != nil {
+		fd.Close()
+		return err
+	}
+
+	return fd.Close()
+}
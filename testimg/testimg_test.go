@@ -0,0 +1,78 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package testimg
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recorder is a T which keeps the errors reported to it.
+type recorder struct{ errors []string }
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertSimilar(t *testing.T) {
+	want := synth.Gradient(128, 128, 1)
+
+	// A small render at half the size matches.
+	small := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			small.Set(x, y, want.At(2*x, 2*y))
+		}
+	}
+
+	var r recorder
+	if !AssertSimilar(&r, small, want, 2) || len(r.errors) > 0 {
+		t.Fatalf("scaled: %v", r.errors)
+	}
+
+	// A region which failed to render is pointed at.
+	broken := image.NewRGBA(want.Bounds())
+	draw.Draw(broken, broken.Rect, want, image.Point{}, draw.Src)
+	draw.Draw(broken, image.Rect(96, 96, 128, 128), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	if AssertSimilar(&r, broken, synth.Noise(128, 128, 1), 2) {
+		t.Fatal("noise matched")
+	}
+
+	if AssertSimilar(&r, broken, want, 0) || !strings.Contains(r.errors[1], "(96,96)-(128,128)") {
+		t.Fatalf("broken: %v", r.errors)
+	}
+
+	if AssertDifferent(&r, small, want, 10) || !AssertDifferent(&r, broken, synth.Noise(128, 128, 1), 10) {
+		t.Fatalf("different: %v", r.errors)
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "testdata", "gradient.png")
+	img := synth.Gradient(64, 64, 1)
+
+	var r recorder
+	if AssertGolden(&r, img, file, 0) || !strings.Contains(r.errors[0], UpdateEnv) {
+		t.Fatalf("missing: %v", r.errors)
+	}
+
+	t.Setenv(UpdateEnv, "1")
+	if !AssertGolden(&r, img, file, 0) {
+		t.Fatalf("update: %v", r.errors)
+	}
+
+	t.Setenv(UpdateEnv, "")
+	if !AssertGolden(&r, img, file, 0) || AssertGolden(&r, synth.Checker(64, 64, 1), file, 4) || len(r.errors) != 2 {
+		t.Fatalf("compare: %v", r.errors)
+	}
+}
@@ -0,0 +1,130 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// A throttle bounds the number of files read at the same time, and
+// the rate at which they are read. A nil throttle has no limits.
+type throttle struct {
+	slots chan struct{} // Nil for any number of readers.
+	rate  int64         // Bytes per second; 0 for no limit.
+	mu    sync.Mutex
+	next  time.Time // Time at which the bytes read so far are paid for.
+}
+
+// newThrottle returns a throttle for the given options, or
+// nil if they set no limits.
+func newThrottle(o *BatchOptions) *throttle {
+	if o.Readers < 1 && o.ReadRate <= 0 {
+		return nil
+	}
+
+	t := &throttle{rate: o.ReadRate}
+	if o.Readers > 0 {
+		t.slots = make(chan struct{}, o.Readers)
+	}
+
+	return t
+}
+
+// acquire blocks until a read may start.
+func (t *throttle) acquire() {
+	if t != nil && t.slots != nil {
+		t.slots <- struct{}{}
+	}
+}
+
+// release ends a read started with acquire.
+func (t *throttle) release() {
+	if t != nil && t.slots != nil {
+		<-t.slots
+	}
+}
+
+// wait blocks until n more bytes may be read.
+func (t *throttle) wait(n int) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+
+	t.next = t.next.Add(time.Duration(n) * time.Second / time.Duration(t.rate))
+	d := t.next.Sub(now)
+	t.mu.Unlock()
+
+	time.Sleep(d)
+}
+
+// reader returns r, limited to the rate of the throttle.
+func (t *throttle) reader(r io.Reader) io.Reader {
+	if t == nil || t.rate <= 0 {
+		return r
+	}
+
+	return &throttledReader{r, t}
+}
+
+// A throttledReader reads no faster than its throttle allows.
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// Small reads keep the rate smooth.
+	if len(p) > 32<<10 {
+		p = p[:32<<10]
+	}
+
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		tr.t.wait(n)
+	}
+
+	return n, err
+}
+
+// open returns a function which opens files with the given function,
+// and reads them into memory within the limits of the throttle. The
+// file is closed before it is decoded, so decoding does not hold up
+// other reads.
+func (t *throttle) open(open func(string) (fs.File, error)) func(string) (fs.File, error) {
+	return func(file string) (fs.File, error) {
+		t.acquire()
+		defer t.release()
+
+		fd, err := open(file)
+		if err != nil {
+			return nil, err
+		}
+
+		defer fd.Close()
+
+		data, err := io.ReadAll(t.reader(fd))
+		if err != nil {
+			return nil, err
+		}
+
+		stat, err := fd.Stat()
+		return &memFile{bytes.NewReader(data), stat, err}, nil
+	}
+}
+
+// A memFile is a file which has been read into memory.
+type memFile struct {
+	*bytes.Reader
+	stat fs.FileInfo
+	err  error // Error returned by Stat, if any.
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.stat, f.err }
+func (f *memFile) Close() error               { return nil }
@@ -0,0 +1,151 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// ThumbnailThresholds are the thresholds for comparing a thumbnail with
+// an original, with CompareThumbnail and Average. Thumbnails of 64 pixels
+// or less keep little of the detail the hash is computed from, and are
+// mostly stored at low JPEG quality, so they drift further from their
+// originals than copies of the same size do.
+var ThumbnailThresholds = Thresholds{Duplicate: 5, NearDuplicate: 12}
+
+// A Kernel is a filter for scaling images down, as used by the programs
+// which make thumbnails.
+type Kernel int
+
+// Known kernels.
+const (
+	KernelBox      Kernel = iota // Each pixel holds the mean of the area it covers.
+	KernelNearest                // Each pixel holds the pixel at the centre of the area it covers.
+	KernelBilinear               // Each pixel holds the 4 pixels around that centre, interpolated.
+	kernels
+)
+
+var kernelNames = [...]string{"box", "nearest", "bilinear"}
+
+func (k Kernel) String() string {
+	if k < 0 || k >= kernels {
+		return fmt.Sprintf("Kernel(%d)", int(k))
+	}
+	return kernelNames[k]
+}
+
+// Scale returns a copy of the image scaled to w by h pixels with the
+// given kernel, with its origin at (0, 0).
+func Scale(img image.Image, w, h int, k Kernel) image.Image {
+	switch k {
+	case KernelNearest:
+		if w <= 0 || h <= 0 {
+			return image.NewRGBA64(image.Rect(0, 0, w, h))
+		}
+		return &sampledImage{img, img.Bounds(), w, h}
+	case KernelBilinear:
+		return bilinear(img, w, h)
+	}
+
+	return resize(img, w, h)
+}
+
+// ThumbnailHashes hashes the image as a thumbnail of the given size
+// would hash, for each kernel. The hashes are indexed by Kernel.
+func ThumbnailHashes(original image.Image, size image.Point, hf HashFunc) []uint64 {
+	out := make([]uint64, kernels)
+	for k := range out {
+		out[k] = hf(Scale(original, size.X, size.Y, Kernel(k)))
+	}
+	return out
+}
+
+// CompareThumbnail returns the distance between the hash of a thumbnail
+// and that of an original it may have been made from, together with the
+// kernel which best explains the thumbnail.
+//
+// Hashing both images as they are compares the thumbnail with the
+// original as the hash scales it down, which is not how the thumbnail
+// was made. Nearest neighbour and bilinear scaling skip most pixels of
+// a large image, so thumbnails made with them show detail the original
+// averages out, and vice versa. CompareThumbnail scales the original
+// down to the size of the thumbnail with each kernel instead, and
+// keeps the closest match. Refer to ThumbnailThresholds for what the
+// distance means.
+func CompareThumbnail(thumb, original image.Image, hf HashFunc) (uint64, Kernel) {
+	hash := hf(thumb)
+	hashes := ThumbnailHashes(original, thumb.Bounds().Size(), hf)
+
+	best, kernel := Distance(hash, hashes[0]), KernelBox
+	for k, h := range hashes[1:] {
+		if d := Distance(hash, h); d < best {
+			best, kernel = d, Kernel(k+1)
+		}
+	}
+
+	return best, kernel
+}
+
+// bilinear scales the image to w by h pixels, interpolating between
+// the 4 pixels around the centre of each destination pixel.
+func bilinear(img image.Image, w, h int) image.Image {
+	if w <= 0 || h <= 0 {
+		return image.NewRGBA64(image.Rect(0, 0, w, h))
+	}
+
+	img = bounded(img)
+	rect := img.Bounds()
+	out := image.NewRGBA64(image.Rect(0, 0, w, h))
+	if rect.Empty() {
+		return out
+	}
+
+	// Position of the centre of destination pixel i out of n, on a
+	// range of size source pixels: the source pixels before and after
+	// it, and the weight of the one after it, in 1/256th.
+	coord := func(i, n, size int) (int, int, uint64) {
+		p := ((2*i+1)*size*256)/(2*n) - 128
+		if p < 0 {
+			p = 0
+		}
+
+		lo, frac := p>>8, uint64(p&0xff)
+		hi := lo + 1
+		if hi >= size {
+			hi = size - 1
+		}
+
+		return lo, hi, frac
+	}
+
+	var x, y int
+	for y = 0; y < h; y++ {
+		y0, y1, fy := coord(y, h, rect.Dy())
+
+		for x = 0; x < w; x++ {
+			x0, x1, fx := coord(x, w, rect.Dx())
+
+			// The weights add up to 1<<16.
+			var c [4]uint64
+			mix := func(px, py int, weight uint64) {
+				r, g, b, a := img.At(rect.Min.X+px, rect.Min.Y+py).RGBA()
+				c[0] += uint64(r) * weight
+				c[1] += uint64(g) * weight
+				c[2] += uint64(b) * weight
+				c[3] += uint64(a) * weight
+			}
+
+			mix(x0, y0, (256-fx)*(256-fy))
+			mix(x1, y0, fx*(256-fy))
+			mix(x0, y1, (256-fx)*fy)
+			mix(x1, y1, fx*fy)
+
+			out.SetRGBA64(x, y, color.RGBA64{uint16(c[0] >> 16), uint16(c[1] >> 16), uint16(c[2] >> 16), uint16(c[3] >> 16)})
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,70 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestScale(t *testing.T) {
+	img := synth.Shapes(300, 200, 1)
+	moved := Region(img, image.Rect(10, 10, 300, 200))
+
+	for k := KernelBox; k < kernels; k++ {
+		if b := Scale(moved, 64, 48, k).Bounds(); b != image.Rect(0, 0, 64, 48) {
+			t.Fatalf("%v: bounds %v", k, b)
+		}
+
+		if b := Scale(img, 0, 10, k).Bounds(); !b.Empty() {
+			t.Fatalf("%v: bounds %v", k, b)
+		}
+
+		// Scaling to the same size leaves the image as is.
+		if d := Distance(Average(Scale(img, 300, 200, k)), Average(img)); d != 0 {
+			t.Fatalf("%v: distance %d", k, d)
+		}
+	}
+}
+
+func TestCompareThumbnail(t *testing.T) {
+	var naive, matched uint64
+
+	for seed := int64(0); seed < 10; seed++ {
+		gen := synth.Shapes
+		if seed%2 == 1 {
+			gen = synth.Text
+		}
+
+		original := gen(1024, 768, seed)
+
+		for k := KernelBox; k < kernels; k++ {
+			var buf bytes.Buffer
+			jpeg.Encode(&buf, Scale(original, 64, 48, k), &jpeg.Options{Quality: 70})
+			thumb, err := jpeg.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d, _ := CompareThumbnail(thumb, original, Average)
+			if d > ThumbnailThresholds.Duplicate {
+				t.Fatalf("%d %v: distance %d", seed, k, d)
+			}
+
+			naive += Distance(Average(thumb), Average(original))
+			matched += d
+
+			if d, _ := CompareThumbnail(thumb, gen(1024, 768, seed+100), Average); d <= ThumbnailThresholds.Duplicate {
+				t.Fatalf("%d %v: distance %d to another image", seed, k, d)
+			}
+		}
+	}
+
+	if matched >= naive {
+		t.Fatalf("distance %d with kernels, %d without", matched, naive)
+	}
+}
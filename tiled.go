@@ -0,0 +1,277 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"sync"
+)
+
+// defaultTiledSize is the default length of the long side of the
+// thumbnail of a TiledHasher.
+const defaultTiledSize = 512
+
+// TiledOptions configure a TiledHasher.
+type TiledOptions struct {
+	// Length of the long side of the thumbnail the whole-image hash is
+	// computed from. The short side keeps the aspect ratio of the image.
+	// Neither is larger than the image. Defaults to 512.
+	Size int
+}
+
+// A TileHash is the hash of a single tile, at its place in the image.
+type TileHash struct {
+	Rect image.Rectangle
+	Hash uint64
+}
+
+// A TiledHasher hashes an image too large to hold in memory, like a
+// gigapixel slide scan or a map, from its tiles. Tiles are added one at
+// a time, in any order, and each is hashed on its own. Their pixels are
+// also averaged into a thumbnail as they come in, from which Hash
+// computes the hash of the image as a whole. Only the thumbnail is
+// kept, so memory use does not grow with the size of the image.
+//
+// The thumbnail is scaled down as Average and the other hashers scale
+// images down, so the hash of the whole image is close to, and often
+// the same as, the hash of the image held in memory.
+//
+// Add may be called concurrently from multiple goroutines.
+type TiledHasher struct {
+	hf     HashFunc
+	bounds image.Rectangle
+	w, h   int
+
+	mu     sync.Mutex
+	sum    []uint64 // Area weighted sums of every thumbnail pixel.
+	pixels int64    // Number of image pixels added.
+	tiles  []TileHash
+}
+
+// NewTiledHasher creates a hasher for an image of the given size, whose
+// tiles and thumbnail are hashed with hf. Opts may be nil, to use the
+// defaults.
+func NewTiledHasher(width, height int, hf HashFunc, opts *TiledOptions) *TiledHasher {
+	size := defaultTiledSize
+	if opts != nil && opts.Size > 0 {
+		size = opts.Size
+	}
+
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	long := width
+	if height > long {
+		long = height
+	}
+	if size > long {
+		size = long
+	}
+
+	w := int(int64(width) * int64(size) / int64(long))
+	h := int(int64(height) * int64(size) / int64(long))
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	return &TiledHasher{
+		hf:     hf,
+		bounds: image.Rect(0, 0, width, height),
+		w:      w,
+		h:      h,
+		sum:    make([]uint64, 4*w*h),
+	}
+}
+
+// Add adds a tile, whose top-left pixel is at the given point of the
+// image, and returns its hash. Parts of the tile outside the image are
+// ignored. Each pixel of the image should be added once: where tiles
+// overlap, as deep zoom tiles do, crop them with Region first, or the
+// overlap counts twice towards the thumbnail. The hash of the tile
+// itself is computed from the tile as given.
+func (t *TiledHasher) Add(at image.Point, tile image.Image) uint64 {
+	hash := t.hf(tile)
+
+	src := tile.Bounds()
+	dst := src.Add(at.Sub(src.Min))
+
+	t.mu.Lock()
+	t.tiles = append(t.tiles, TileHash{dst, hash})
+	t.mu.Unlock()
+
+	clip := dst.Intersect(t.bounds)
+	if clip.Empty() {
+		return hash
+	}
+
+	// Spread each column and row of the tile over the columns and rows
+	// of the thumbnail they overlap, weighted by the overlap, as
+	// resizeSums does for a whole image.
+	cols := make([][]tileSpread, clip.Dx())
+	for x := range cols {
+		cols[x] = spreadPixel(clip.Min.X+x, t.bounds.Dx(), t.w)
+	}
+
+	// Sum up the thumbnail pixels the tile overlaps on their own, and
+	// add them to the thumbnail in one go.
+	first := cols[0][0].index
+	last := cols[len(cols)-1]
+	lw := last[len(last)-1].index - first + 1
+
+	top := spreadPixel(clip.Min.Y, t.bounds.Dy(), t.h)[0].index
+	bottom := spreadPixel(clip.Max.Y-1, t.bounds.Dy(), t.h)
+	lh := bottom[len(bottom)-1].index - top + 1
+
+	offset := src.Min.Sub(dst.Min)
+	row := make([]uint64, 4*clip.Dx())
+	sum := make([]uint64, 4*lw*lh)
+
+	var x, y, c int
+	var cy, cx tileSpread
+	var q, idx uint64
+
+	for y = clip.Min.Y; y < clip.Max.Y; y++ {
+		tileRow(tile, clip.Min.X+offset.X, y+offset.Y, row)
+
+		for _, cy = range spreadPixel(y, t.bounds.Dy(), t.h) {
+			for x = range cols {
+				for _, cx = range cols[x] {
+					q = cy.weight * cx.weight
+					idx = uint64(4 * ((cy.index-top)*lw + cx.index - first))
+
+					for c = 0; c < 4; c++ {
+						sum[idx+uint64(c)] += row[4*x+c] * q
+					}
+				}
+			}
+		}
+	}
+
+	t.mu.Lock()
+	for y = 0; y < lh; y++ {
+		for x = 0; x < 4*lw; x++ {
+			t.sum[4*((top+y)*t.w+first)+x] += sum[4*y*lw+x]
+		}
+	}
+	t.pixels += int64(clip.Dx()) * int64(clip.Dy())
+	t.mu.Unlock()
+
+	return hash
+}
+
+// tileSpread is the share of a source pixel in a thumbnail column or row.
+type tileSpread struct {
+	index  int
+	weight uint64
+}
+
+// spreadPixel returns the thumbnail columns, or rows, the source column
+// or row at pos overlaps, out of n source and m destination ones. The
+// weights add up to m, and those of all source pixels in a destination
+// pixel to n.
+func spreadPixel(pos, n, m int) []tileSpread {
+	var s []tileSpread
+
+	nn, mm := uint64(n), uint64(m)
+	p := uint64(pos) * mm
+
+	for rem := mm; rem > 0; {
+		q := nn - p%nn
+		if q > rem {
+			q = rem
+		}
+
+		s = append(s, tileSpread{int(p / nn), q})
+		p += q
+		rem -= q
+	}
+
+	return s
+}
+
+// tileRow reads the premultiplied, 16 bit colours of len(row)/4 pixels of
+// m into row, starting at (x, y).
+func tileRow(m image.Image, x, y int, row []uint64) {
+	n := len(row) / 4
+
+	if !generic.Load() {
+		switch m := plain(m).(type) {
+		case *image.RGBA:
+			pix := m.Pix[m.PixOffset(x, y):]
+			for i := 0; i < 4*n; i++ {
+				row[i] = uint64(pix[i]) * 0x101
+			}
+			return
+
+		case *image.Gray:
+			pix := m.Pix[m.PixOffset(x, y):]
+			for i := 0; i < n; i++ {
+				v := uint64(pix[i]) * 0x101
+				row[4*i], row[4*i+1], row[4*i+2], row[4*i+3] = v, v, v, 0xffff
+			}
+			return
+
+		case *image.YCbCr:
+			for i := 0; i < n; i++ {
+				yi, ci := m.YOffset(x+i, y), m.COffset(x+i, y)
+				r, g, b := color.YCbCrToRGB(m.Y[yi], m.Cb[ci], m.Cr[ci])
+				row[4*i] = uint64(r) * 0x101
+				row[4*i+1] = uint64(g) * 0x101
+				row[4*i+2] = uint64(b) * 0x101
+				row[4*i+3] = 0xffff
+			}
+			return
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		r, g, b, a := m.At(x+i, y).RGBA()
+		row[4*i], row[4*i+1], row[4*i+2], row[4*i+3] = uint64(r), uint64(g), uint64(b), uint64(a)
+	}
+}
+
+// Image returns the thumbnail of the tiles added so far. Parts of the
+// image no tile covered yet are transparent.
+func (t *TiledHasher) Image() image.Image {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := uint64(t.bounds.Dx()) * uint64(t.bounds.Dy())
+	return average(t.sum, t.w, t.h, n)
+}
+
+// Hash returns the hash of the whole image, computed from the thumbnail
+// of the tiles added so far.
+func (t *TiledHasher) Hash() uint64 {
+	return t.hf(t.Image())
+}
+
+// Tiles returns the hashes of the tiles added so far, in order of
+// addition.
+func (t *TiledHasher) Tiles() []TileHash {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TileHash(nil), t.tiles...)
+}
+
+// Coverage returns the fraction of the image the tiles added so far
+// cover, in the range 0-1, assuming they do not overlap.
+func (t *TiledHasher) Coverage() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := float64(t.pixels) / (float64(t.bounds.Dx()) * float64(t.bounds.Dy()))
+	if c > 1 {
+		c = 1
+	}
+	return c
+}
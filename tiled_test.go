@@ -0,0 +1,88 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/draw"
+	"sync"
+	"testing"
+)
+
+func TestTiledHasher(t *testing.T) {
+	img := synth.Shapes(1000, 700, 1)
+	th := NewTiledHasher(1000, 700, Average, &TiledOptions{Size: 128})
+
+	// Tiles are added out of order, concurrently, and in a mix of
+	// colour models, with the ones on the edges overhanging the image.
+	var rects []image.Rectangle
+	for y := 0; y < 700; y += 256 {
+		for x := 0; x < 1000; x += 256 {
+			rects = append(rects, image.Rect(x, y, x+256, y+256))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := len(rects) - 1; i >= 0; i-- {
+		r := rects[i]
+
+		var tile draw.Image = image.NewRGBA(image.Rect(0, 0, 256, 256))
+		if i%2 == 1 {
+			tile = image.NewNRGBA64(tile.Bounds())
+		}
+		draw.Draw(tile, tile.Bounds(), img, r.Min, draw.Src)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, want := th.Add(r.Min, tile), Average(tile); got != want {
+				t.Errorf("tile %v: hash %016x, want %016x", r, got, want)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if c := th.Coverage(); c != 1 {
+		t.Errorf("coverage %v, want 1", c)
+	}
+
+	if n := len(th.Tiles()); n != len(rects) {
+		t.Errorf("%d tile hashes, want %d", n, len(rects))
+	}
+
+	thumb := th.Image().(*image.RGBA64)
+	if b := thumb.Bounds(); b.Dx() != 128 || b.Dy() != 89 {
+		t.Fatalf("thumbnail is %v", b)
+	}
+
+	sum, n := resizeSums(img, 128, 89)
+	if want := average(sum, 128, 89, n).(*image.RGBA64); !bytes.Equal(thumb.Pix, want.Pix) {
+		t.Errorf("thumbnail differs from the image scaled down")
+	}
+
+	if d := Distance(th.Hash(), Average(img)); d > 2 {
+		t.Errorf("whole-image hash at distance %d of that of the image", d)
+	}
+}
+
+func TestTiledHasherPartial(t *testing.T) {
+	th := NewTiledHasher(100, 50, Average, nil)
+
+	if b := th.Image().Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Fatalf("thumbnail is %v, want the image size", b)
+	}
+
+	th.Add(image.Pt(-10, 40), synth.Flat(20, 20, 1))
+	if c := th.Coverage(); c != 0.02 {
+		t.Errorf("coverage %v, want 0.02", c)
+	}
+
+	th.Add(image.Pt(200, 0), synth.Flat(20, 20, 1))
+	if c := th.Coverage(); c != 0.02 {
+		t.Errorf("coverage %v after a tile outside the image, want 0.02", c)
+	}
+}
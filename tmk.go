@@ -0,0 +1,291 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"io"
+	"math"
+	"time"
+)
+
+// TMK parameters, matching the defaults of the TMK+PDQF reference
+// implementation. Frames are resampled to tmkFPS before aggregation.
+const (
+	tmkFPS          = 15
+	tmkCoefficients = 32
+	tmkBeta         = 32
+)
+
+// tmkPeriods holds the periods, in frames, of the Fourier
+// features. They are chosen to be mutually prime.
+var tmkPeriods = [...]int{2731, 4391, 9767, 14653}
+
+// A FeatureFunc computes a real valued feature vector for a single frame.
+// All vectors it returns must have the same length.
+type FeatureFunc func(image.Image) []float64
+
+// GridFeatures returns the 64 grayscale values of the Average grid,
+// centred on their mean and normalised to unit length.
+//
+// They are cheaper to compute than PDQFeatures, the frame features of
+// the TMK+PDQF reference implementation. TMK descriptors computed with
+// GridFeatures are only comparable with each other, not with those of
+// the reference implementation.
+func GridFeatures(img image.Image) []float64 {
+	cells, mean := AverageCells(img)
+	out := make([]float64, len(cells))
+
+	var norm float64
+	for i, v := range cells {
+		out[i] = float64(v) - float64(mean)
+		norm += out[i] * out[i]
+	}
+
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range out {
+			out[i] /= norm
+		}
+	}
+
+	return out
+}
+
+// A TMKSignature is a fixed-size, video-level descriptor, computed with
+// the Temporal Match Kernel. Its size depends only on the length of the
+// frame features, not on the length of the video.
+type TMKSignature struct {
+	Frames  int         // Number of frames, after resampling.
+	Average []float64   // Mean feature vector.
+	Cos     [][]float64 // Cosine features, per period and coefficient.
+	Sin     [][]float64 // Sine features, per period and coefficient.
+}
+
+// ComputeTMK reads all frames from src, resamples them to a fixed frame
+// rate and aggregates their features into a TMK signature.
+//
+// Features are only computed for frames which survive resampling.
+func ComputeTMK(src FrameSource, ff FeatureFunc) (*TMKSignature, error) {
+	sig := new(TMKSignature)
+
+	var last *Frame
+	var feat []float64
+	var start time.Duration
+
+	// tick returns the time of the next resampled frame.
+	tick := func() time.Duration {
+		return start + time.Duration(sig.Frames)*time.Second/tmkFPS
+	}
+
+	// emit adds the current features for all ticks before end.
+	emit := func(end time.Duration) {
+		for sig.Frames == 0 || tick() < end {
+			if feat == nil {
+				feat = ff(last.Image)
+			}
+
+			sig.add(feat)
+		}
+	}
+
+	for {
+		f, err := src.NextFrame()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if last == nil {
+			start = f.Time
+		} else {
+			emit(f.Time)
+		}
+
+		last, feat = f, nil
+	}
+
+	if last != nil {
+		emit(last.Time + last.Duration)
+	}
+
+	sig.normalize()
+	return sig, nil
+}
+
+// add adds the features of the next frame.
+func (s *TMKSignature) add(feat []float64) {
+	if s.Average == nil {
+		s.Average = make([]float64, len(feat))
+		s.Cos = make([][]float64, len(tmkPeriods)*tmkCoefficients)
+		s.Sin = make([][]float64, len(tmkPeriods)*tmkCoefficients)
+
+		for i := range s.Cos {
+			s.Cos[i] = make([]float64, len(feat))
+			s.Sin[i] = make([]float64, len(feat))
+		}
+	}
+
+	t := float64(s.Frames)
+	s.Frames++
+
+	var i, p, j int
+	var cos, sin float64
+
+	for i = range feat {
+		s.Average[i] += feat[i]
+	}
+
+	for p = range tmkPeriods {
+		for j = 0; j < tmkCoefficients; j++ {
+			sin, cos = math.Sincos(2 * math.Pi * float64(j+1) * t / float64(tmkPeriods[p]))
+			c := s.Cos[p*tmkCoefficients+j]
+			n := s.Sin[p*tmkCoefficients+j]
+
+			for i = range feat {
+				c[i] += feat[i] * cos
+				n[i] += feat[i] * sin
+			}
+		}
+	}
+}
+
+// normalize divides all sums by the number of frames.
+func (s *TMKSignature) normalize() {
+	if s.Frames == 0 {
+		return
+	}
+
+	n := float64(s.Frames)
+	scale := func(v []float64) {
+		for i := range v {
+			v[i] /= n
+		}
+	}
+
+	scale(s.Average)
+	for i := range s.Cos {
+		scale(s.Cos[i])
+		scale(s.Sin[i])
+	}
+}
+
+// TMKLevel1 returns the cosine similarity of the mean feature vectors of
+// two signatures. It ignores the order of frames entirely, and is meant
+// as a cheap first pass before TMKLevel2. The reference implementation
+// considers videos with a score of 0.7 or more as candidate matches.
+func TMKLevel1(a, b *TMKSignature) float64 {
+	return cosine(a.Average, b.Average)
+}
+
+// TMKLevel2 returns the temporal match score of two signatures, between
+// -1 and 1. It compares the Fourier features at every relative time
+// offset and returns the score of the best one, so videos which start
+// at a different point in time can still match. A score of 0.7 or more
+// is considered a match by the reference implementation.
+func TMKLevel2(a, b *TMKSignature) float64 {
+	if len(a.Average) == 0 || len(a.Average) != len(b.Average) {
+		return 0
+	}
+
+	weights := tmkWeights()
+
+	var total float64
+	var p, j, i, offset int
+	var cross, anti [tmkCoefficients]float64
+
+	for p = range tmkPeriods {
+		var normA, normB float64
+
+		for j = 0; j < tmkCoefficients; j++ {
+			ac, as := a.Cos[p*tmkCoefficients+j], a.Sin[p*tmkCoefficients+j]
+			bc, bs := b.Cos[p*tmkCoefficients+j], b.Sin[p*tmkCoefficients+j]
+
+			cross[j], anti[j] = 0, 0
+			for i = range ac {
+				cross[j] += ac[i]*bc[i] + as[i]*bs[i]
+				anti[j] += as[i]*bc[i] - ac[i]*bs[i]
+				normA += weights[j] * (ac[i]*ac[i] + as[i]*as[i])
+				normB += weights[j] * (bc[i]*bc[i] + bs[i]*bs[i])
+			}
+		}
+
+		if normA == 0 || normB == 0 {
+			continue
+		}
+
+		best := math.Inf(-1)
+		for offset = 0; offset < tmkPeriods[p]; offset++ {
+			var score float64
+
+			for j = 0; j < tmkCoefficients; j++ {
+				sin, cos := math.Sincos(2 * math.Pi * float64(j+1) * float64(offset) / float64(tmkPeriods[p]))
+				score += weights[j] * (cross[j]*cos + anti[j]*sin)
+			}
+
+			if score > best {
+				best = score
+			}
+		}
+
+		total += best / math.Sqrt(normA*normB)
+	}
+
+	return total / float64(len(tmkPeriods))
+}
+
+// tmkWeights returns the Fourier coefficient weights of the
+// reference kernel: modified Bessel functions of the first kind.
+func tmkWeights() [tmkCoefficients]float64 {
+	var w [tmkCoefficients]float64
+	for j := range w {
+		w[j] = besselI(j+1, tmkBeta) / math.Sinh(tmkBeta)
+	}
+
+	return w
+}
+
+// besselI computes the modified Bessel function of the first kind
+// of order n, using its power series.
+func besselI(n int, x float64) float64 {
+	term := 1.0
+	for k := 1; k <= n; k++ {
+		term *= x / 2 / float64(k)
+	}
+
+	sum := term
+	for k := 1; k < 500; k++ {
+		term *= (x / 2) * (x / 2) / (float64(k) * float64(k+n))
+		sum += term
+
+		if term < sum*1e-16 {
+			break
+		}
+	}
+
+	return sum
+}
+
+// cosine returns the cosine similarity of two vectors.
+func cosine(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,98 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"image"
+	"sync/atomic"
+)
+
+// Names of the spans started by this package.
+const (
+	SpanCompute    = "imghash.compute"     // Decoding and hashing an image, around the next two.
+	SpanDecode     = "imghash.decode"      // Decoding an image.
+	SpanHash       = "imghash.hash"        // Running a HashFunc.
+	SpanPreprocess = "imghash.preprocess"  // Running filters, with ApplyFilters.
+	SpanIndexQuery = "imghash.index.query" // Querying a Store.
+	SpanIndexAdd   = "imghash.index.add"   // Adding to a Store.
+)
+
+// A Tracer starts spans around the stages of hashing and matching an
+// image, so a tracing system can show where the time of a request goes.
+//
+// Start starts a span with the given name, as a child of the span in
+// ctx, if any. It returns a context holding the new span, and a function
+// which ends it, with the error the stage failed with, or nil. This fits
+// OpenTelemetry with a few lines:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, func(error)) {
+//		ctx, span := o.t.Start(ctx, name)
+//		return ctx, func(err error) {
+//			if err != nil {
+//				span.RecordError(err)
+//				span.SetStatus(codes.Error, err.Error())
+//			}
+//			span.End()
+//		}
+//	}
+//
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, func(err error))
+}
+
+// tracer holds the current Tracer, wrapped in a tracerHolder, as
+// metrics does.
+var tracer atomic.Value
+
+type tracerHolder struct{ t Tracer }
+
+func init() {
+	tracer.Store(tracerHolder{})
+}
+
+// SetTracer sets the Tracer which starts all spans. Pass nil to disable
+// tracing, which is the default. This is typically called once, at
+// program startup.
+func SetTracer(t Tracer) {
+	tracer.Store(tracerHolder{t})
+}
+
+// StartSpan starts a span with the current Tracer. Without one, it
+// returns ctx and a function which does nothing. Packages building on
+// this one use it to trace stages of their own.
+func StartSpan(ctx context.Context, name string) (context.Context, func(err error)) {
+	t := tracer.Load().(tracerHolder).t
+	if t == nil {
+		return ctx, func(error) {}
+	}
+
+	return t.Start(ctx, name)
+}
+
+// ApplyFilters runs the filters over the image, in order, in a span of
+// its own. Preprocess does the same, but a HashFunc has no context, so
+// its spans have no parent; use ApplyFilters to trace a request.
+//
+// Generated images too large to copy, like image.Uniform, are sampled
+// first, so filters of your own which copy the image copy the sample.
+func ApplyFilters(ctx context.Context, img image.Image, filters ...Filter) image.Image {
+	if len(filters) == 0 {
+		return img
+	}
+
+	_, end := StartSpan(ctx, SpanPreprocess)
+	defer end(nil)
+
+	img = copyable(img)
+
+	for _, f := range filters {
+		img = f(img)
+	}
+
+	return img
+}
@@ -0,0 +1,81 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// spanKey holds the name of the current span in a context.
+type spanKey struct{}
+
+// testTracer records spans as "parent>name", and failed ones with
+// a trailing "!".
+type testTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, func(error)) {
+	parent, _ := ctx.Value(spanKey{}).(string)
+	return context.WithValue(ctx, spanKey{}, name), func(err error) {
+		span := parent + ">" + name
+		if err != nil {
+			span += "!"
+		}
+
+		t.mu.Lock()
+		t.spans = append(t.spans, span)
+		t.mu.Unlock()
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tr := new(testTracer)
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), spanKey{}, "request")
+	hf := Preprocess(Average, CenterCrop)
+
+	if _, err := ComputeContext(ctx, bytes.NewReader(buf.Bytes()), hf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ComputeContext(ctx, strings.NewReader("not an image"), hf); err == nil {
+		t.Fatal("decoded garbage")
+	}
+
+	s := IndexStore(NewIndex())
+	s.Add(ctx, "a", 1)
+	s.Query(ctx, 1, 0)
+
+	ApplyFilters(ctx, nil)
+
+	want := []string{
+		"imghash.compute>imghash.decode",
+		">imghash.preprocess", // Preprocess has no context.
+		"imghash.compute>imghash.hash",
+		"request>imghash.compute",
+		"imghash.compute>imghash.decode!",
+		"request>imghash.compute!",
+		"request>imghash.index.add",
+		"request>imghash.index.query",
+	}
+
+	if strings.Join(tr.spans, " ") != strings.Join(want, " ") {
+		t.Fatalf("spans:\n%s\nwant:\n%s", strings.Join(tr.spans, "\n"), strings.Join(want, "\n"))
+	}
+}
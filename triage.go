@@ -0,0 +1,85 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// Layout of the grid Triage samples: 8 by 4 regions, for a bit each,
+// with 3 by 3 pixels sampled in every region.
+const (
+	triageCols    = 8
+	triageRows    = 4
+	triageSamples = 3
+)
+
+// TriageDistance is the number of bits two triage codes may differ in
+// and still be taken as codes of copies of an image. Refer to
+// TriageReject.
+const TriageDistance = 14
+
+// Triage computes a rough, 32 bit code of the image from a sparse,
+// fixed set of pixels, for ruling out pairs of images before hashing
+// them. It reads 288 pixels whatever the size of the image, so it takes
+// microseconds where a hash, which reads every pixel, takes milliseconds
+// for a photo.
+//
+// The image is split into a grid of 8 by 4 regions, and 3 by 3 pixels
+// evenly spread over each are sampled. Each bit tells whether the mean
+// luminance of a region's samples is above that of all samples. This is
+// the Average hash on a coarser grid, from samples rather than means,
+// so it holds up to the same changes, less reliably: a sampled pixel
+// may land on a detail in one copy and beside it in the other.
+func Triage(img image.Image) uint32 {
+	r := img.Bounds()
+	if r.Empty() {
+		return 0
+	}
+
+	const side = triageSamples
+	var sums [triageCols * triageRows]uint64
+	var total uint64
+	var x, y, cx, cy int
+
+	for cy = 0; cy < triageRows*side; cy++ {
+		y = r.Min.Y + triageCoord(cy, triageRows*side, r.Dy())
+
+		for cx = 0; cx < triageCols*side; cx++ {
+			x = r.Min.X + triageCoord(cx, triageCols*side, r.Dx())
+
+			v := uint64(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+			sums[(cy/side)*triageCols+cx/side] += v
+			total += v
+		}
+	}
+
+	// Compare sums rather than means: every region has the same number
+	// of samples, so the mean of all samples is total / len(sums) in
+	// units of region sums.
+	var code uint32
+	for i, s := range sums {
+		if s*uint64(len(sums)) > total {
+			code |= 1 << uint(i)
+		}
+	}
+
+	return code
+}
+
+// triageCoord returns the offset of the centre of sample i, out of n
+// along a side of the given length.
+func triageCoord(i, n, size int) int {
+	return int((2*int64(i) + 1) * int64(size) / (2 * int64(n)))
+}
+
+// TriageReject returns true if the triage codes of two images are far
+// enough apart for them to be taken as distinct without hashing them.
+// Near duplicates rarely differ in more than a few bits; pairs it does
+// not reject still need their hashes compared.
+func TriageReject(a, b uint32) bool {
+	return bits.OnesCount32(a^b) > TriageDistance
+}
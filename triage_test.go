@@ -0,0 +1,66 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/attack"
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"math/bits"
+	"testing"
+)
+
+// countingImage counts the pixels read from it.
+type countingImage struct {
+	image.Image
+	reads int
+}
+
+func (c *countingImage) At(x, y int) color.Color {
+	c.reads++
+	return c.Image.At(x, y)
+}
+
+func TestTriage(t *testing.T) {
+	img := synth.Shapes(640, 480, 1)
+
+	ci := &countingImage{Image: img}
+	code := Triage(ci)
+	if ci.reads != triageCols*triageRows*triageSamples*triageSamples {
+		t.Errorf("read %d pixels", ci.reads)
+	}
+
+	if code == 0 || code == 1<<32-1 {
+		t.Errorf("code %08x of a detailed image", code)
+	}
+
+	for _, tr := range []attack.Transform{attack.JPEG(75), attack.Resize(0.5), attack.Brightness(0.1)} {
+		if c := Triage(tr.Apply(img)); TriageReject(code, c) {
+			t.Errorf("%s: copy rejected, %d bits apart", tr.Name, bits.OnesCount32(code^c))
+		}
+	}
+
+	var rejected int
+	imgs := synth.Corpus(40, nil, 2)
+	for i := range imgs {
+		for j := i + 1; j < len(imgs); j++ {
+			if TriageReject(Triage(imgs[i]), Triage(imgs[j])) {
+				rejected++
+			}
+		}
+	}
+
+	if pairs := len(imgs) * (len(imgs) - 1) / 2; rejected < pairs/2 {
+		t.Errorf("rejected %d out of %d distinct pairs", rejected, pairs)
+	}
+
+	if c := Triage(image.NewRGBA(image.Rect(0, 0, 0, 0))); c != 0 {
+		t.Errorf("empty image: %08x", c)
+	}
+
+	if c := Triage(image.NewGray(image.Rect(0, 0, 1, 1))); c != 0 {
+		t.Errorf("single pixel: %08x", c)
+	}
+}
@@ -0,0 +1,434 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrInvalidUpdateLog is returned when reading a malformed update log.
+	ErrInvalidUpdateLog = errors.New("imghash: invalid update log")
+
+	// ErrUpdateSeq is returned by UpdateLog.Stream for a sequence
+	// number past the end of the log.
+	ErrUpdateSeq = errors.New("imghash: sequence number past the end of the update log")
+)
+
+// updateLogHeader is the first line of an update log.
+const updateLogHeader = "# imghash update log v1"
+
+// An UpdateOp is the kind of change an Update makes.
+type UpdateOp byte
+
+// Known update operations.
+const (
+	UpdateAdd    UpdateOp = 'a'
+	UpdateRemove UpdateOp = 'r'
+)
+
+// An Update is a single change to a Store, as recorded in an UpdateLog.
+type Update struct {
+	Seq  uint64 // Position in the log, from 1.
+	Op   UpdateOp
+	ID   string
+	Hash uint64 // Only for UpdateAdd.
+}
+
+// Apply makes the change to the given store.
+func (u *Update) Apply(ctx context.Context, s Store) error {
+	if u.Op == UpdateRemove {
+		return s.Remove(ctx, u.ID)
+	}
+	return s.Add(ctx, u.ID, u.Hash)
+}
+
+// appendLine appends the update to b, as a line of an update log.
+// Additions are written as "a", followed by the sequence number, the hash
+// and the quoted ID. Removals are written as "r", followed by the sequence
+// number and the quoted ID.
+func (u *Update) appendLine(b []byte) []byte {
+	b = append(b, byte(u.Op), ' ')
+	b = strconv.AppendUint(b, u.Seq, 10)
+	b = append(b, ' ')
+
+	if u.Op != UpdateRemove {
+		b = AppendHash(b, u.Hash)
+		b = append(b, ' ')
+	}
+
+	b = strconv.AppendQuote(b, u.ID)
+	return append(b, '\n')
+}
+
+// parseUpdate parses a line written by format, without the newline.
+func parseUpdate(line []byte) (*Update, bool) {
+	fields := bytes.SplitN(line, []byte(" "), 3)
+	if len(fields) != 3 || len(fields[0]) != 1 {
+		return nil, false
+	}
+
+	seq, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	u := &Update{Seq: seq, Op: UpdateOp(fields[0][0])}
+
+	rest := fields[2]
+	switch u.Op {
+	case UpdateAdd:
+		if len(rest) < 18 || rest[16] != ' ' {
+			return nil, false
+		}
+
+		if u.Hash, err = strconv.ParseUint(string(rest[:16]), 16, 64); err != nil {
+			return nil, false
+		}
+
+		rest = rest[17:]
+
+	case UpdateRemove:
+	default:
+		return nil, false
+	}
+
+	if u.ID, err = strconv.Unquote(string(rest)); err != nil {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// An UpdateLog records the changes made to a Store, in an append-only
+// file, so replicas can follow them. A primary records every change,
+// through LoggedStore, and streams the log to its followers with Stream.
+// Followers read it with ReadUpdates, and apply each update to a store
+// of their own. This saves every replica from running its own ingest.
+//
+// Updates are numbered from 1. A follower which starts from a snapshot
+// taken at sequence number n, as returned by LoggedStore.SnapshotAt,
+// asks for the updates after n.
+//
+// Records are written to the operating system right away, like those of
+// a Checkpoint. A record cut short by a power failure is dropped when
+// the log is opened again.
+//
+// An UpdateLog is safe for concurrent use.
+type UpdateLog struct {
+	file string
+
+	mu      sync.Mutex
+	fd      *os.File
+	offsets []int64       // Offset of each record; that of Seq n at n-1.
+	size    int64         // Offset of the end of the last record.
+	notify  chan struct{} // Closed, and replaced, on every append.
+	line    []byte        // Buffer of append, reused.
+}
+
+// OpenUpdateLog opens the given update log, and indexes the records in
+// it. A missing file is created.
+func OpenUpdateLog(file string) (*UpdateLog, error) {
+	fd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &UpdateLog{file: file, fd: fd, notify: make(chan struct{})}
+	if err = l.load(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// load indexes all records, and positions the file after the last
+// complete one. A new file gets a header.
+func (l *UpdateLog) load() error {
+	r := bufio.NewReader(l.fd)
+
+	header, err := r.ReadString('\n')
+	if err == io.EOF && len(header) == 0 {
+		n, err := fmt.Fprintln(l.fd, updateLogHeader)
+		l.size = int64(n)
+		return err
+	}
+
+	if err != nil || header[:len(header)-1] != updateLogHeader {
+		return ErrInvalidUpdateLog
+	}
+
+	l.size = int64(len(header))
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line was cut short; drop it.
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		u, ok := parseUpdate(bytes.TrimSuffix(line, []byte("\n")))
+		if !ok || u.Seq != uint64(len(l.offsets))+1 {
+			return ErrInvalidUpdateLog
+		}
+
+		l.offsets = append(l.offsets, l.size)
+		l.size += int64(len(line))
+	}
+
+	if err := l.fd.Truncate(l.size); err != nil {
+		return err
+	}
+
+	_, err = l.fd.Seek(l.size, io.SeekStart)
+	return err
+}
+
+// Seq returns the sequence number of the last update, or 0 if the log
+// is empty.
+func (l *UpdateLog) Seq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return uint64(len(l.offsets))
+}
+
+// Add records the addition of a hash, and returns its sequence number.
+func (l *UpdateLog) Add(id string, hash uint64) (uint64, error) {
+	return l.append(&Update{Op: UpdateAdd, ID: id, Hash: hash})
+}
+
+// Remove records the removal of an ID, and returns its sequence number.
+func (l *UpdateLog) Remove(id string) (uint64, error) {
+	return l.append(&Update{Op: UpdateRemove, ID: id})
+}
+
+// append numbers and writes an update, and wakes up all followers.
+func (l *UpdateLog) append(u *Update) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u.Seq = uint64(len(l.offsets)) + 1
+	l.line = u.appendLine(l.line[:0])
+	line := l.line
+
+	if _, err := l.fd.Write(line); err != nil {
+		// Drop whatever part of the record made it to the file.
+		l.fd.Truncate(l.size)
+		l.fd.Seek(l.size, io.SeekStart)
+		return 0, err
+	}
+
+	l.offsets = append(l.offsets, l.size)
+	l.size += int64(len(line))
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return u.Seq, nil
+}
+
+// Stream writes the updates after the given sequence number to w, in
+// the format of the log, starting with its header. Pass 0 for all of
+// them. If follow is set, Stream then waits for further updates and
+// writes them as they come in, until ctx is done. If w has a Flush
+// method, like an http.ResponseWriter, it is called after every batch
+// of updates.
+//
+// Stream fails with ErrUpdateSeq if seq is past the end of the log,
+// which means the follower has seen a different log.
+func (l *UpdateLog) Stream(ctx context.Context, w io.Writer, seq uint64, follow bool) error {
+	fd, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	l.mu.Lock()
+	if seq > uint64(len(l.offsets)) {
+		l.mu.Unlock()
+		return ErrUpdateSeq
+	}
+
+	pos := l.size
+	if seq < uint64(len(l.offsets)) {
+		pos = l.offsets[seq]
+	}
+	l.mu.Unlock()
+
+	if _, err := io.WriteString(w, updateLogHeader+"\n"); err != nil {
+		return err
+	}
+
+	flusher, _ := w.(interface{ Flush() })
+
+	for {
+		l.mu.Lock()
+		end, notify := l.size, l.notify
+		l.mu.Unlock()
+
+		if end > pos {
+			if _, err := io.Copy(w, io.NewSectionReader(fd, pos, end-pos)); err != nil {
+				return err
+			}
+
+			pos = end
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if !follow {
+			return nil
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close closes the log file.
+func (l *UpdateLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fd.Close()
+}
+
+// ReadUpdates reads a stream of updates, as written by UpdateLog.Stream,
+// and calls f for each. It returns once the stream ends, with io.EOF
+// if it was cut off in the middle of an update, or with the first error
+// returned by f.
+func ReadUpdates(r io.Reader, f func(*Update) error) error {
+	br := bufio.NewReader(r)
+
+	header, err := br.ReadString('\n')
+	if err != nil || header[:len(header)-1] != updateLogHeader {
+		if err == nil || err == io.EOF {
+			err = ErrInvalidUpdateLog
+		}
+		return err
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		u, ok := parseUpdate(bytes.TrimSuffix(line, []byte("\n")))
+		if !ok {
+			return ErrInvalidUpdateLog
+		}
+
+		if err := f(u); err != nil {
+			return err
+		}
+	}
+}
+
+// A LoggedStore is a Store which records every change to it in an
+// UpdateLog, for replicas to follow.
+type LoggedStore struct {
+	mu    sync.Mutex // Keeps the order of the log that of the store.
+	store Store
+	log   *UpdateLog
+}
+
+// NewLoggedStore returns a store which makes its changes to s, and
+// records them in log.
+func NewLoggedStore(s Store, log *UpdateLog) *LoggedStore {
+	return &LoggedStore{store: s, log: log}
+}
+
+// Add records the change, then makes it.
+func (s *LoggedStore) Add(ctx context.Context, id string, hash uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.log.Add(id, hash); err != nil {
+		return err
+	}
+	return s.store.Add(ctx, id, hash)
+}
+
+// Remove records the change, then makes it.
+func (s *LoggedStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.log.Remove(id); err != nil {
+		return err
+	}
+	return s.store.Remove(ctx, id)
+}
+
+func (s *LoggedStore) Query(ctx context.Context, hash, distance uint64) (ResultSet, error) {
+	return s.store.Query(ctx, hash, distance)
+}
+
+func (s *LoggedStore) Count(ctx context.Context, hash, distance uint64) (int, error) {
+	return CountStore(ctx, s.store, hash, distance)
+}
+
+// QueryFunc streams the results from the underlying store, as
+// StreamStore does.
+func (s *LoggedStore) QueryFunc(ctx context.Context, hash, distance uint64, f func(*SearchResult) bool) error {
+	return StreamStore(ctx, s.store, hash, distance, f)
+}
+
+// Verify checks the underlying store, as VerifyStore does.
+func (s *LoggedStore) Verify(ctx context.Context) error {
+	return VerifyStore(ctx, s.store)
+}
+
+// Compact compacts the underlying store, as CompactStore does. It
+// changes no entries, so it is not logged.
+func (s *LoggedStore) Compact(ctx context.Context, opts *CompactOptions) error {
+	return CompactStore(ctx, s.store, opts)
+}
+
+// Snapshot returns a snapshot of the underlying store, if it is a
+// Snapshotter, or nil otherwise.
+func (s *LoggedStore) Snapshot() *Index {
+	x, _ := s.SnapshotAt()
+	return x
+}
+
+// SnapshotAt is like Snapshot, but also returns the sequence number of
+// the last update the snapshot includes. Followers which start from the
+// snapshot ask for the updates after it.
+func (s *LoggedStore) SnapshotAt() (*Index, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, ok := s.store.(Snapshotter)
+	if !ok {
+		return nil, 0
+	}
+
+	return snap.Snapshot(), s.log.Seq()
+}
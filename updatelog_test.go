@@ -0,0 +1,105 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "updates")
+	ctx := context.Background()
+
+	log, err := OpenUpdateLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := NewIndex()
+	s := NewLoggedStore(IndexStore(primary), log)
+	s.Add(ctx, "a", 1)
+	s.Add(ctx, "b", 2)
+	s.Add(ctx, `odd "id" with spaces`, 3)
+	s.Remove(ctx, "a")
+
+	snap, seq := s.SnapshotAt()
+	if seq != 4 || snap.Len() != 2 {
+		t.Fatalf("snapshot at %d, with %d entries", seq, snap.Len())
+	}
+
+	// A follower starts from the snapshot, and tails the log.
+	follower := snap
+	store := IndexStore(follower)
+	applied := make(chan uint64)
+
+	r, w := io.Pipe()
+	fctx, cancel := context.WithCancel(ctx)
+	go func() {
+		w.CloseWithError(log.Stream(fctx, w, seq, true))
+	}()
+
+	go ReadUpdates(r, func(u *Update) error {
+		u.Apply(ctx, store)
+		applied <- u.Seq
+		return nil
+	})
+
+	s.Add(ctx, "c", 4)
+	s.Remove(ctx, "b")
+
+	if <-applied != 5 || <-applied != 6 {
+		t.Fatal("updates out of order")
+	}
+
+	cancel()
+
+	if !reflect.DeepEqual(follower.IDs(), primary.IDs()) {
+		t.Fatalf("follower has %v, primary %v", follower.IDs(), primary.IDs())
+	}
+
+	// Streams from the start hold every update.
+	var buf bytes.Buffer
+	if err := log.Stream(ctx, &buf, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []Update
+	if err := ReadUpdates(bytes.NewReader(buf.Bytes()), func(u *Update) error {
+		updates = append(updates, *u)
+		return nil
+	}); err != nil || len(updates) != 6 || updates[2] != (Update{3, UpdateAdd, `odd "id" with spaces`, 3}) {
+		t.Fatalf("updates %v, %v", updates, err)
+	}
+
+	if err := ReadUpdates(bytes.NewReader(buf.Bytes()[:buf.Len()-3]), func(*Update) error { return nil }); err != io.ErrUnexpectedEOF {
+		t.Fatalf("cut off stream: %v", err)
+	}
+
+	if err := log.Stream(ctx, &buf, 7, false); err != ErrUpdateSeq {
+		t.Fatalf("stream past the end: %v", err)
+	}
+
+	log.Close()
+
+	// A record cut short is dropped on reopening.
+	fd, _ := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	fd.WriteString("a 7 00000000")
+	fd.Close()
+
+	if log, err = OpenUpdateLog(file); err != nil || log.Seq() != 6 {
+		t.Fatalf("reopened log: %v", err)
+	}
+
+	if seq, _ := log.Add("d", 5); seq != 7 {
+		t.Fatalf("next update %d", seq)
+	}
+
+	log.Close()
+}
@@ -0,0 +1,313 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package upload checks images uploaded to a web application against the
+images it already knows, before the application sees them.
+
+Middleware wraps a handler. It parses multipart uploads, hashes every
+file in them, and looks each hash up in a Store. Depending on the
+Action, it turns away uploads of known images, or passes them on with
+the matches attached, so the application only has to read them:
+
+	mw := upload.Middleware(&upload.Options{
+		Store:  store,
+		Action: upload.Reject,
+	})
+
+	http.Handle("/upload", mw(http.HandlerFunc(saveUpload)))
+
+	func saveUpload(w http.ResponseWriter, r *http.Request) {
+		for _, f := range upload.Files(r.Context()) {
+			...
+		}
+	}
+
+The form is parsed with Request.ParseMultipartForm, so the handler reads
+the files from Request.MultipartForm or Request.FormFile as usual.
+Requests which are not multipart uploads are passed on untouched.
+*/
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jteeuwen/imghash"
+	"mime"
+	"net/http"
+	"sort"
+)
+
+// Headers set on requests by the Tag action. Any sent by the client are
+// removed, so the handler can trust them.
+const (
+	HeaderHash  = "Imghash-Hash"  // Field name and hash of each image: "photo=0838787c7c3e3c18".
+	HeaderMatch = "Imghash-Match" // Field name and ID of the closest match of each known image: "photo=a.jpg".
+)
+
+// defaultMaxMemory is the default Options.MaxMemory, as net/http uses
+// for Request.FormFile.
+const defaultMaxMemory = 32 << 20
+
+// An Action is what Middleware does with an upload holding a known image.
+type Action int
+
+// Known actions.
+const (
+	Annotate Action = iota // Pass it on, with the matches in the request context.
+	Tag                    // Annotate it, and set HeaderHash and HeaderMatch on the request.
+	Reject                 // Answer it with 409 Conflict, without calling the handler.
+)
+
+// Options configure Middleware.
+type Options struct {
+	// Store to look the uploaded images up in. It is required.
+	Store imghash.Store
+
+	// Hash function of the images in Store. Defaults to that of
+	// imghash.DefaultHasher.
+	Hash imghash.HashFunc
+
+	// Hamming Distance within which an upload matches a known image.
+	// Defaults to the near-duplicate threshold of the default hasher if
+	// Hash is not set, and to that of the Average hash if it is.
+	Distance uint64
+
+	// What to do with uploads of known images. Defaults to Annotate.
+	Action Action
+
+	// Form fields holding images. Files in other fields are passed on
+	// without being hashed. Defaults to all file fields.
+	Fields []string
+
+	// Bytes of the form kept in memory, as for
+	// Request.ParseMultipartForm. The rest goes to temporary files.
+	// Defaults to 32 MB.
+	MaxMemory int64
+
+	// If set, uploads which do not match a known image are added to
+	// Store once the handler answers with a 2xx status, keyed by the
+	// ID this returns. Later uploads of the same image then match it.
+	Add func(r *http.Request, f *File) string
+
+	// Writes the response to rejected uploads. Files returns the
+	// uploaded files. By default, the response is a JSON object of the
+	// form imghashd uses for errors, listing the matches.
+	Rejected http.Handler
+}
+
+// A File is an image in an upload, and the known images it matches.
+type File struct {
+	Field    string            // Form field holding the file.
+	Filename string            // Name of the file, as sent by the client.
+	Hash     uint64            // Hash of the image.
+	Matches  imghash.ResultSet // Known images it matches, closest first. The Path of each is its ID.
+	Err      error             // Why the file could not be hashed, like not being an image.
+}
+
+// Known returns true if the file matches a known image.
+func (f *File) Known() bool {
+	return len(f.Matches) > 0
+}
+
+// filesKey is the context key of the files of an upload.
+type filesKey struct{}
+
+// Files returns the files of the upload in ctx, in the order of their
+// fields and of the files in each field. It returns nil for requests
+// which Middleware did not see, or which were not uploads.
+func Files(ctx context.Context) []*File {
+	files, _ := ctx.Value(filesKey{}).([]*File)
+	return files
+}
+
+// Middleware returns middleware which checks uploads with the given
+// options, and calls the handler it wraps with the request annotated.
+// Uploads which can not be parsed are answered with 400 Bad Request.
+// Files which do not decode are passed on, with their Err set; the
+// handler decides what to do with those.
+func Middleware(opts *Options) func(http.Handler) http.Handler {
+	o := *opts
+	if o.Hash == nil {
+		h := imghash.DefaultHasher()
+		o.Hash = h.Hash
+		if o.Distance == 0 {
+			o.Distance = h.Thresholds.NearDuplicate
+		}
+	}
+
+	if o.Distance == 0 {
+		o.Distance = imghash.AverageThresholds.NearDuplicate
+	}
+
+	if o.MaxMemory <= 0 {
+		o.MaxMemory = defaultMaxMemory
+	}
+
+	if o.Rejected == nil {
+		o.Rejected = http.HandlerFunc(rejected)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			o.serve(w, r, next)
+		})
+	}
+}
+
+// serve checks the upload in r, and passes it on to next.
+func (o *Options) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if o.Action == Tag {
+		r.Header.Del(HeaderHash)
+		r.Header.Del(HeaderMatch)
+	}
+
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "multipart/form-data" {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	if err := r.ParseMultipartForm(o.MaxMemory); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	files, err := o.check(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), filesKey{}, files))
+
+	known := false
+	for _, f := range files {
+		known = known || f.Known()
+	}
+
+	if known && o.Action == Reject {
+		o.Rejected.ServeHTTP(w, r)
+		return
+	}
+
+	if o.Action == Tag {
+		for _, f := range files {
+			if f.Err != nil {
+				continue
+			}
+
+			r.Header.Add(HeaderHash, f.Field+"="+imghash.FormatHash(f.Hash))
+			if f.Known() {
+				r.Header.Add(HeaderMatch, f.Field+"="+f.Matches[0].Path)
+			}
+		}
+	}
+
+	if o.Add == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(sw, r)
+
+	if sw.status < 200 || sw.status > 299 {
+		return
+	}
+
+	for _, f := range files {
+		if f.Err == nil && !f.Known() {
+			// The response is sent; a failure here only means later
+			// uploads of the image are not caught.
+			o.Store.Add(r.Context(), o.Add(r, f), f.Hash)
+		}
+	}
+}
+
+// check hashes the files of the parsed upload in r, and looks them up.
+// It fails only if the Store does.
+func (o *Options) check(r *http.Request) ([]*File, error) {
+	form := r.MultipartForm
+
+	fields := o.Fields
+	if fields == nil {
+		for field := range form.File {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+
+	var files []*File
+	for _, field := range fields {
+		for _, fh := range form.File[field] {
+			f := &File{Field: field, Filename: fh.Filename}
+			files = append(files, f)
+
+			fd, err := fh.Open()
+			if err != nil {
+				f.Err = err
+				continue
+			}
+
+			f.Hash, f.Err = imghash.ComputeContext(r.Context(), fd, o.Hash)
+			fd.Close()
+
+			if f.Err != nil {
+				continue
+			}
+
+			if f.Matches, err = o.Store.Query(r.Context(), f.Hash, o.Distance); err != nil {
+				return nil, fmt.Errorf("upload: %w", err)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// rejected is the default Options.Rejected.
+func rejected(w http.ResponseWriter, r *http.Request) {
+	type match struct {
+		Field    string `json:"field"`
+		ID       string `json:"id"`
+		Hash     string `json:"hash"`
+		Distance uint64 `json:"distance"`
+	}
+
+	resp := struct {
+		Error   string  `json:"error"`
+		Matches []match `json:"matches"`
+	}{Error: "image already known"}
+
+	for _, f := range Files(r.Context()) {
+		for _, m := range f.Matches {
+			resp.Matches = append(resp.Matches, match{f.Field, m.Path, imghash.FormatHash(m.Hash), m.Distance})
+		}
+	}
+
+	writeJSON(w, http.StatusConflict, &resp)
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
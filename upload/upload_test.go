@@ -0,0 +1,132 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"github.com/jteeuwen/imghash"
+	"github.com/jteeuwen/imghash/synth"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newUpload returns a multipart upload of the given files, by field.
+func newUpload(t *testing.T, files map[string][]byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for field, data := range files {
+		fw, err := mw.CreateFormFile(field, field+".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestMiddleware(t *testing.T) {
+	var known, other bytes.Buffer
+	png.Encode(&known, synth.Shapes(128, 96, 1))
+	png.Encode(&other, synth.Text(128, 96, 2))
+
+	hash, err := imghash.ComputeBytes(known.Bytes(), imghash.Average)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := imghash.IndexStore(imghash.NewIndex())
+	store.Add(context.Background(), "known.png", hash)
+
+	var seen []*File
+	var header http.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, header = Files(r.Context()), r.Header
+
+		// The form is still there for the handler to read.
+		if _, _, err := r.FormFile("photo"); err != nil {
+			t.Errorf("form file: %v", err)
+		}
+	})
+
+	serve := func(opts *Options, r *http.Request) *httptest.ResponseRecorder {
+		seen = nil
+		w := httptest.NewRecorder()
+		Middleware(opts)(handler).ServeHTTP(w, r)
+		return w
+	}
+
+	// Annotate passes known images on, with their matches.
+	w := serve(&Options{Store: store}, newUpload(t, map[string][]byte{
+		"photo": known.Bytes(),
+		"other": []byte("not an image"),
+	}))
+
+	if w.Code != http.StatusOK || len(seen) != 2 {
+		t.Fatalf("annotate: status %d, files %v", w.Code, seen)
+	}
+
+	if f := seen[0]; f.Field != "other" || f.Err == nil {
+		t.Fatalf("annotate: %+v", f)
+	}
+
+	if f := seen[1]; f.Field != "photo" || !f.Known() || f.Matches[0].Path != "known.png" {
+		t.Fatalf("annotate: %+v", f)
+	}
+
+	// Tag sets headers, replacing those of the client.
+	r := newUpload(t, map[string][]byte{"photo": known.Bytes()})
+	r.Header.Set(HeaderMatch, "photo=forged.png")
+
+	serve(&Options{Store: store, Action: Tag}, r)
+	if got := header.Values(HeaderMatch); len(got) != 1 || got[0] != "photo=known.png" {
+		t.Fatalf("tag: match headers %q", got)
+	}
+
+	if got := header.Get(HeaderHash); got != "photo="+imghash.FormatHash(hash) {
+		t.Fatalf("tag: hash header %q", got)
+	}
+
+	// Reject turns known images away.
+	w = serve(&Options{Store: store, Action: Reject}, newUpload(t, map[string][]byte{"photo": known.Bytes()}))
+	if w.Code != http.StatusConflict || seen != nil || !strings.Contains(w.Body.String(), `"id":"known.png"`) {
+		t.Fatalf("reject: status %d, %s", w.Code, w.Body)
+	}
+
+	// Add stores new images, once the handler accepts them.
+	opts := &Options{
+		Store:  store,
+		Action: Reject,
+		Add:    func(r *http.Request, f *File) string { return "new.png" },
+	}
+
+	if w = serve(opts, newUpload(t, map[string][]byte{"photo": other.Bytes()})); w.Code != http.StatusOK {
+		t.Fatalf("add: status %d", w.Code)
+	}
+
+	if w = serve(opts, newUpload(t, map[string][]byte{"photo": other.Bytes()})); w.Code != http.StatusConflict {
+		t.Fatalf("add: second upload status %d", w.Code)
+	}
+
+	// Other requests pass untouched.
+	w = httptest.NewRecorder()
+	Middleware(opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "plain")
+	})).ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader("x=1")))
+
+	if w.Body.String() != "plain" {
+		t.Fatalf("plain request: %q", w.Body)
+	}
+}
@@ -0,0 +1,369 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Parameters of DetectOrientation.
+const (
+	uprightSize       = 512  // Longest side of the copy the cues are measured on.
+	uprightRadius     = 6    // Radius of the neighbourhood ink is told apart from.
+	uprightInk        = 0.12 // Least difference in luminance from it, for ink.
+	uprightLines      = 3    // Least number of lines for the layout of text to count.
+	uprightGap        = 0.15 // Least fraction of the text taken up by the gaps between lines.
+	uprightUniform    = 0.8  // Least fraction of lines within half of the median height.
+	uprightAlign      = 0.05 // Difference in ragged and aligned line ends, as a fraction of their length, for full confidence.
+	uprightSkyLuma    = 0.15 // Difference in brightness between opposite sides, for full confidence in the sky cue.
+	uprightSkyTexture = 0.02 // Difference in texture between them, as the mean step between pixels, for the same.
+	uprightConfidence = 0.5  // Least confidence at which Upright turns an image.
+)
+
+// DetectOrientation estimates by how much the image was rotated, from
+// its content alone, ignoring any EXIF orientation. It returns Identity,
+// Rotate90, Rotate180 or Rotate270, and a confidence between 0 and 1.
+// Images without any of the cues below yield Identity, at confidence 0.
+//
+// This is a coarse heuristic, not a classifier; it is right for most
+// scanned pages and outdoor photos, and unsure of most other images. Two
+// cues are used:
+//
+//   - Text. Lines of text leave gaps between them, which run across the
+//     whole page; the gaps between letters do not line up. This tells
+//     upright and upside down pages from those turned by 90 degrees.
+//     Lines start at the same margin, and end ragged, which then tells
+//     the side the text starts at. Justified text has no ragged side,
+//     and yields a low confidence.
+//   - Sky. Above the horizon, photos are mostly brighter and smoother
+//     than below it. The side of the image which is most so is taken
+//     for the top.
+func DetectOrientation(img image.Image) (Orientation, float64) {
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	if w < 2*uprightRadius || h < 2*uprightRadius {
+		return Identity, 0
+	}
+
+	if w > uprightSize || h > uprightSize {
+		if w > h {
+			w, h = uprightSize, max(h*uprightSize/w, 1)
+		} else {
+			w, h = max(w*uprightSize/h, 1), uprightSize
+		}
+		img = resize(img, w, h)
+	}
+
+	gray := luminance(img)
+	ink := inkMask(gray)
+
+	if o, c, ok := textOrientation(ink, w, h); ok {
+		return o, c
+	}
+
+	return skyOrientation(gray, w, h)
+}
+
+// Upright is a Filter which turns images the right way up, if
+// DetectOrientation is confident enough about how they were rotated.
+// Scans and images stripped of their metadata are often rotated by 90
+// degrees, and then match none of their copies. Other images are left
+// as is.
+func Upright(img image.Image) image.Image {
+	o, c := DetectOrientation(img)
+	if o == Identity || c < uprightConfidence {
+		return img
+	}
+
+	// Rotations by 90 and 270 degrees undo each other.
+	switch o {
+	case Rotate90:
+		o = Rotate270
+	case Rotate270:
+		o = Rotate90
+	}
+
+	return OrientImage(img, o)
+}
+
+// OrientImage returns a copy of the image, rotated or mirrored as o
+// says, with its origin at (0, 0). For the hashes Orient applies to,
+// hashing the copy yields the hash Orient computes.
+func OrientImage(img image.Image, o Orientation) image.Image {
+	img = copyable(img)
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+
+	size := image.Rect(0, 0, w, h)
+	switch o {
+	case Rotate90, Rotate270, Transpose, Transverse:
+		size = image.Rect(0, 0, h, w)
+	}
+
+	out := image.NewRGBA64(size)
+
+	var x, y, nx, ny int
+	for y = 0; y < h; y++ {
+		for x = 0; x < w; x++ {
+			switch o {
+			case Rotate90:
+				nx, ny = h-1-y, x
+			case Rotate180:
+				nx, ny = w-1-x, h-1-y
+			case Rotate270:
+				nx, ny = y, w-1-x
+			case FlipH:
+				nx, ny = w-1-x, y
+			case FlipV:
+				nx, ny = x, h-1-y
+			case Transpose:
+				nx, ny = y, x
+			case Transverse:
+				nx, ny = h-1-y, w-1-x
+			default:
+				nx, ny = x, y
+			}
+
+			out.Set(nx, ny, img.At(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+
+	return out
+}
+
+// inkMask returns which pixels of the image stand out from their
+// neighbourhood, in row-major order. These are the strokes of text,
+// in either polarity, and none of a smooth background.
+func inkMask(gray *image.Gray16) []bool {
+	rect := gray.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+
+	// Summed area table, with a row and column of zeroes in front.
+	sum := make([]uint64, (w+1)*(h+1))
+
+	var x, y int
+	for y = 0; y < h; y++ {
+		var row uint64
+		for x = 0; x < w; x++ {
+			row += uint64(gray.Gray16At(x, y).Y)
+			sum[(y+1)*(w+1)+x+1] = sum[y*(w+1)+x+1] + row
+		}
+	}
+
+	ink := make([]bool, w*h)
+	for y = 0; y < h; y++ {
+		y0, y1 := max(y-uprightRadius, 0), min(y+uprightRadius+1, h)
+
+		for x = 0; x < w; x++ {
+			x0, x1 := max(x-uprightRadius, 0), min(x+uprightRadius+1, w)
+
+			s := sum[y1*(w+1)+x1] - sum[y0*(w+1)+x1] - sum[y1*(w+1)+x0] + sum[y0*(w+1)+x0]
+			mean := float64(s) / float64((x1-x0)*(y1-y0))
+			ink[y*w+x] = math.Abs(float64(gray.Gray16At(x, y).Y)-mean) > uprightInk*0xffff
+		}
+	}
+
+	return ink
+}
+
+// textOrientation finds the orientation of the lines of text in the
+// ink mask, if it holds any.
+func textOrientation(ink []bool, w, h int) (Orientation, float64, bool) {
+	row := func(y, x int) bool { return ink[y*w+x] }
+	col := func(x, y int) bool { return ink[y*w+x] }
+	rows, cols := lineRuns(h, w, row), lineRuns(w, h, col)
+
+	// Gaps between lines run across the page, and are wide; those
+	// between letters only line up by chance, or in monospaced text.
+	gr, gc := gapFraction(rows), gapFraction(cols)
+
+	switch {
+	case gr > uprightGap && gr > 2*gc && textLines(rows):
+		c := lineAlignment(rows, w, row)
+		if c < 0 {
+			return Rotate180, math.Min(-c/uprightAlign, 1), true
+		}
+		return Identity, math.Min(c/uprightAlign, 1), true
+
+	case gc > uprightGap && gc > 2*gr && textLines(cols):
+		// Lines which start at the top were on the left.
+		c := lineAlignment(cols, h, col)
+		if c < 0 {
+			return Rotate270, math.Min(-c/uprightAlign, 1), true
+		}
+		return Rotate90, math.Min(c/uprightAlign, 1), true
+	}
+
+	return Identity, 0, false
+}
+
+// lineRuns returns the runs of lines 0 to n of length m which hold any
+// ink, as reported by at, as pairs of their first and past the last.
+func lineRuns(n, m int, at func(line, pos int) bool) [][2]int {
+	var runs [][2]int
+	var i, j int
+
+	start := -1
+	for i = 0; i <= n; i++ {
+		found := false
+		for j = 0; i < n && j < m && !found; j++ {
+			found = at(i, j)
+		}
+
+		switch {
+		case found && start < 0:
+			start = i
+		case !found && start >= 0:
+			runs = append(runs, [2]int{start, i})
+			start = -1
+		}
+	}
+
+	return runs
+}
+
+// textLines returns true if the runs look like lines of text: there
+// are enough of them, and most are of about the same height.
+func textLines(runs [][2]int) bool {
+	if len(runs) < uprightLines {
+		return false
+	}
+
+	heights := make([]float64, len(runs))
+	for i, r := range runs {
+		heights[i] = float64(r[1] - r[0])
+	}
+
+	sort.Float64s(heights)
+	median := heights[len(heights)/2]
+
+	var n int
+	for _, v := range heights {
+		if v >= median/2 && v <= median*3/2 {
+			n++
+		}
+	}
+
+	return float64(n) >= uprightUniform*float64(len(runs))
+}
+
+// gapFraction returns the fraction of the span of the runs which lies
+// between them.
+func gapFraction(runs [][2]int) float64 {
+	if len(runs) < 2 {
+		return 0
+	}
+
+	span := runs[len(runs)-1][1] - runs[0][0]
+	for _, r := range runs {
+		span -= r[1] - r[0]
+	}
+
+	return float64(span) / float64(runs[len(runs)-1][1]-runs[0][0])
+}
+
+// lineAlignment compares how far the starts and the ends of the lines
+// of text spread, along their length m. It returns the spread of the
+// ends less that of the starts, as a fraction of m: positive when the
+// lines start aligned, and end ragged.
+func lineAlignment(runs [][2]int, m int, at func(line, pos int) bool) float64 {
+	starts := make([]float64, 0, len(runs))
+	ends := make([]float64, 0, len(runs))
+
+	var i, j int
+	for _, r := range runs {
+		first, last := m, -1
+		for i = r[0]; i < r[1]; i++ {
+			for j = 0; j < m; j++ {
+				if at(i, j) {
+					first = min(first, j)
+					last = max(last, j)
+				}
+			}
+		}
+
+		starts = append(starts, float64(first))
+		ends = append(ends, float64(last))
+	}
+
+	return (deviation(ends) - deviation(starts)) / float64(m)
+}
+
+// deviation returns the median absolute deviation of the values. It
+// ignores the odd heading or short last line.
+func deviation(v []float64) float64 {
+	median := func(v []float64) float64 {
+		s := append([]float64(nil), v...)
+		sort.Float64s(s)
+		return s[len(s)/2]
+	}
+
+	m := median(v)
+	d := make([]float64, len(v))
+	for i := range v {
+		d[i] = math.Abs(v[i] - m)
+	}
+
+	return median(d)
+}
+
+// skyOrientation finds the side of the image which is both brighter
+// and smoother than the opposite side, by the widest margin, and takes
+// it for the top. Gradients and evenly lit scenes have no such side.
+func skyOrientation(gray *image.Gray16, w, h int) (Orientation, float64) {
+	var sides [4]struct{ luma, texture, n float64 } // Top, right, bottom, left.
+	var x, y, i int
+
+	for y = 0; y < h-1; y++ {
+		for x = 0; x < w-1; x++ {
+			v := float64(gray.Gray16At(x, y).Y) / 0xffff
+			dx := math.Abs(float64(gray.Gray16At(x+1, y).Y)/0xffff - v)
+			dy := math.Abs(float64(gray.Gray16At(x, y+1).Y)/0xffff - v)
+
+			// Each side is the outer quarter of the image.
+			for i, in := range [4]bool{4*y < h, 4*x >= 3*w, 4*y >= 3*h, 4*x < w} {
+				if in {
+					sides[i].luma += v
+					sides[i].texture += dx + dy
+					sides[i].n++
+				}
+			}
+		}
+	}
+
+	// The evidence for each side being the top is the lesser of how
+	// much brighter, and how much smoother it is than the opposite one.
+	var evidence [4]float64
+	for i = range sides {
+		a, b := sides[i], sides[(i+2)%4]
+		if a.n == 0 || b.n == 0 {
+			continue
+		}
+
+		brighter := (a.luma/a.n - b.luma/b.n) / uprightSkyLuma
+		smoother := (b.texture/b.n - a.texture/a.n) / uprightSkyTexture
+		evidence[i] = math.Max(math.Min(brighter, smoother), 0)
+	}
+
+	best, second := 0, -1
+	for i = 1; i < 4; i++ {
+		if evidence[i] > evidence[best] {
+			best, second = i, best
+		} else if second < 0 || evidence[i] > evidence[second] {
+			second = i
+		}
+	}
+
+	e := evidence[best]
+	if e == 0 {
+		return Identity, 0
+	}
+
+	// Less sure as another side is nearly as likely, or as the
+	// evidence is weak. The top on the right was turned clockwise.
+	o := [4]Orientation{Identity, Rotate90, Rotate180, Rotate270}[best]
+	return o, (e - evidence[second]) / e * math.Min(e, 1)
+}
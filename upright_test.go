@@ -0,0 +1,73 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"github.com/jteeuwen/imghash/synth"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestOrientImage(t *testing.T) {
+	img := synth.Shapes(100, 60, 1)
+	hash := Average(img)
+
+	for o := Identity; o < orientations; o++ {
+		out := OrientImage(img, o)
+		if got, want := Average(out), Orient(hash, o); got != want {
+			t.Fatalf("%v: hash %016x, want %016x", o, got, want)
+		}
+	}
+
+	if r := OrientImage(img, Rotate90).Bounds(); r != image.Rect(0, 0, 60, 100) {
+		t.Fatalf("rotated bounds %v", r)
+	}
+}
+
+func TestDetectOrientation(t *testing.T) {
+	// A bright, smooth sky over dark, rough ground.
+	rng := rand.New(rand.NewSource(1))
+	scene := image.NewGray(image.Rect(0, 0, 300, 200))
+
+	var x, y int
+	for y = 0; y < 200; y++ {
+		for x = 0; x < 300; x++ {
+			v := 230 - y/4
+			if y > 90+x/20 {
+				v = 40 + rng.Intn(100)
+			}
+			scene.SetGray(x, y, color.Gray{uint8(v)})
+		}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"text", synth.Text(600, 800, 3)},
+		{"scene", scene},
+	}
+
+	for _, tt := range tests {
+		want := Average(tt.img)
+
+		for _, o := range []Orientation{Identity, Rotate90, Rotate180, Rotate270} {
+			img := OrientImage(tt.img, o)
+			if got, c := DetectOrientation(img); got != o || c < uprightConfidence {
+				t.Fatalf("%s, %v: detected %v at %.2f", tt.name, o, got, c)
+			}
+
+			if got := Average(Upright(img)); got != want {
+				t.Fatalf("%s, %v: upright hash %016x, want %016x", tt.name, o, got, want)
+			}
+		}
+	}
+
+	// Nothing to go by.
+	if o, c := DetectOrientation(image.NewGray(image.Rect(0, 0, 64, 64))); o != Identity || c != 0 {
+		t.Fatalf("flat image: %v at %.2f", o, c)
+	}
+}
@@ -0,0 +1,149 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTooLarge is returned by FetchURL for downloads over the size
+	// limit, and by Decode for images with more than MaxPixels pixels.
+	ErrTooLarge = errors.New("imghash: image exceeds the size limit")
+
+	// ErrContentType is returned by FetchURL for responses
+	// which are not images.
+	ErrContentType = errors.New("imghash: response is not an image")
+)
+
+// Defaults for FetchOptions.
+const (
+	defaultFetchSize    = 32 << 20
+	defaultFetchTimeout = 30 * time.Second
+)
+
+// FetchOptions configure FetchURL and ComputeURL.
+type FetchOptions struct {
+	// Maximum size of the image in bytes. Defaults to 32MB.
+	MaxSize int64
+
+	// Time allowed for the whole download, including the body.
+	// Defaults to 30 seconds. The context may impose a shorter limit.
+	Timeout time.Duration
+}
+
+// FetchURL downloads the image at the given URL. Client may be nil, to
+// use http.DefaultClient. Opts may be nil, to use the defaults.
+//
+// Only http and https URLs are accepted. The download is aborted with
+// ErrTooLarge as soon as it exceeds the size limit, and with ErrContentType
+// if the server says it is sending anything other than an image. Servers
+// which send no content type, or a generic binary one, are given the
+// benefit of the doubt; data which is not an image fails to decode.
+func FetchURL(ctx context.Context, client *http.Client, rawurl string, opts *FetchOptions) ([]byte, error) {
+	maxSize, timeout := int64(defaultFetchSize), defaultFetchTimeout
+	if opts != nil && opts.MaxSize > 0 {
+		maxSize = opts.MaxSize
+	}
+
+	if opts != nil && opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("imghash: unsupported URL scheme %q", u.Scheme)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("imghash: %s: %s", rawurl, resp.Status)
+	}
+
+	if resp.ContentLength > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	if !imageContentType(resp.Header.Get("Content-Type")) {
+		return nil, ErrContentType
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	return data, nil
+}
+
+// imageContentType returns true if the given Content-Type header
+// may describe an image.
+func imageContentType(header string) bool {
+	if len(header) == 0 {
+		return true
+	}
+
+	kind, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(kind, "image/"):
+		return true
+	case kind == "application/octet-stream", kind == "binary/octet-stream":
+		return true
+	}
+
+	return false
+}
+
+// ComputeURL downloads the image at the given URL and computes its hash
+// using the given HashFunc. Refer to FetchURL for details on the other
+// parameters.
+func ComputeURL(ctx context.Context, client *http.Client, rawurl string, hf HashFunc, opts *FetchOptions) (uint64, error) {
+	data, err := FetchURL(ctx, client, rawurl, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	return ComputeContext(ctx, bytes.NewReader(data), hf)
+}
@@ -0,0 +1,74 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestComputeURL(t *testing.T) {
+	gopher, err := os.ReadFile("testdata/gopher_small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gopher.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(gopher)
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/slow.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	hash, err := ComputeURL(ctx, srv.Client(), srv.URL+"/gopher.png", Average, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := getHash(t, Average, "testdata/gopher_small.png"); hash != want {
+		t.Fatalf("hash mismatch: %016x, want %016x", hash, want)
+	}
+
+	tests := []struct {
+		path string
+		opts *FetchOptions
+		err  error
+	}{
+		{"/gopher.png", &FetchOptions{MaxSize: 100}, ErrTooLarge},
+		{"/page.html", nil, ErrContentType},
+		{"/missing.png", nil, nil},
+		{"/slow.png", &FetchOptions{Timeout: 50 * time.Millisecond}, nil},
+	}
+
+	for _, tt := range tests {
+		_, err := ComputeURL(ctx, srv.Client(), srv.URL+tt.path, Average, tt.opts)
+		if err == nil || tt.err != nil && err != tt.err {
+			t.Fatalf("%s: unexpected error %v", tt.path, err)
+		}
+	}
+
+	if _, err := ComputeURL(ctx, nil, "file:///etc/passwd", Average, nil); err == nil {
+		t.Fatalf("file URL accepted")
+	}
+}
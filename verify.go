@@ -0,0 +1,203 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCorruptIndex is returned by Verify for an index whose tree does
+// not hold up, and which may miss matches or return wrong ones.
+var ErrCorruptIndex = errors.New("imghash: corrupt index")
+
+// A Verifier is a Store which can check its own consistency. Stores
+// returned by IndexStore and MappedStore implement it.
+type Verifier interface {
+	Verify(ctx context.Context) error
+}
+
+// VerifyStore checks the consistency of s, if it is a Verifier. Other
+// stores are taken on trust, and yield nil.
+func VerifyStore(ctx context.Context, s Store) error {
+	if v, ok := s.(Verifier); ok {
+		return v.Verify(ctx)
+	}
+	return nil
+}
+
+// bkAncestor is a node on the path to another, with the distance of
+// the edge the path leaves it by.
+type bkAncestor struct {
+	hash, dist uint64
+}
+
+// verify checks that every node of the tree is at the distance of the
+// edge it hangs from, from every node on its path from the root, and
+// calls f for the IDs of every node.
+func (t *bkTree[K]) verify(f func(id K, hash uint64) error) error {
+	var walk func(n *bkNode[K], path []bkAncestor) error
+	walk = func(n *bkNode[K], path []bkAncestor) error {
+		for _, a := range path {
+			if d := Distance(a.hash, n.hash); d != a.dist {
+				return fmt.Errorf("%w: %016x is at distance %d of %016x, not %d", ErrCorruptIndex, n.hash, d, a.hash, a.dist)
+			}
+		}
+
+		for _, id := range n.ids {
+			if err := f(id, n.hash); err != nil {
+				return err
+			}
+		}
+
+		if n.edges != nil && len(n.edges) != len(n.children) {
+			return fmt.Errorf("%w: sorted children of %016x are stale", ErrCorruptIndex, n.hash)
+		}
+
+		for i, e := range n.edges {
+			if n.children[e.dist] != e.node || i > 0 && n.edges[i-1].dist >= e.dist {
+				return fmt.Errorf("%w: sorted children of %016x are stale", ErrCorruptIndex, n.hash)
+			}
+		}
+
+		for d, child := range n.children {
+			if d == 0 || child == nil {
+				return fmt.Errorf("%w: bad edge %d of %016x", ErrCorruptIndex, d, n.hash)
+			}
+
+			if err := walk(child, append(path, bkAncestor{n.hash, d})); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if t.root == nil {
+		return nil
+	}
+	return walk(t.root, nil)
+}
+
+// Verify checks that the tree of the index holds up, and that it holds
+// every ID once, under its own hash. It returns an error wrapping
+// ErrCorruptIndex if not. It visits every node, so it takes a while for
+// large indexes, and reads them like a query.
+func (x *Index) Verify() error {
+	x.load()
+	return verifyIDs(&x.bkTree, x.ids)
+}
+
+// Verify checks the index as Index.Verify does.
+func (x *KeyedIndex[K]) Verify() error {
+	return verifyIDs(&x.bkTree, x.keys)
+}
+
+// verifyIDs verifies t, and that it holds every ID of ids once, under
+// its hash, and no others.
+func verifyIDs[K comparable](t *bkTree[K], ids map[K]uint64) error {
+	seen := make(map[K]bool, len(ids))
+	err := t.verify(func(id K, hash uint64) error {
+		switch want, ok := ids[id]; {
+		case !ok:
+			return fmt.Errorf("%w: unknown ID %v in the tree", ErrCorruptIndex, id)
+		case want != hash:
+			return fmt.Errorf("%w: ID %v is under %016x, not %016x", ErrCorruptIndex, id, hash, want)
+		case seen[id]:
+			return fmt.Errorf("%w: ID %v is in the tree twice", ErrCorruptIndex, id)
+		}
+
+		seen[id] = true
+		return nil
+	})
+
+	if err == nil && len(seen) != len(ids) {
+		err = fmt.Errorf("%w: %d of %d IDs are missing from the tree", ErrCorruptIndex, len(ids)-len(seen), len(ids))
+	}
+
+	return err
+}
+
+// Verify checks the tree of a mapped index as Index.Verify does, and
+// that all of its records point within their tables. Queries skip
+// records which do not, so a damaged file otherwise goes unnoticed.
+func (m *MappedIndex) Verify() error {
+	if m.nodes == 0 {
+		if m.entries != 0 {
+			return fmt.Errorf("%w: %d IDs without nodes", ErrCorruptIndex, m.entries)
+		}
+		return nil
+	}
+
+	// The parent of every node, and the distance of the edge to it.
+	parent := make([]uint32, m.nodes)
+	dist := make([]uint32, m.nodes)
+	reached := make([]bool, m.nodes)
+	reached[0] = true
+
+	var ids uint64
+	for n := uint64(0); n < m.nodes; n++ {
+		rec := mappedHeader + n*mappedNode
+		h := m.uint64(rec)
+
+		if !reached[n] {
+			return fmt.Errorf("%w: node %d is not in the tree", ErrCorruptIndex, n)
+		}
+
+		for c := n; c != 0; c = uint64(parent[c]) {
+			p := uint64(parent[c])
+			if d := Distance(m.uint64(mappedHeader+p*mappedNode), h); d != uint64(dist[c]) {
+				return fmt.Errorf("%w: %016x is at distance %d of node %d, not %d", ErrCorruptIndex, h, d, p, dist[c])
+			}
+		}
+
+		first, count := m.uint32(rec+8), m.uint32(rec+12)
+		if first+count > m.nodes-1 {
+			return fmt.Errorf("%w: edges of node %d out of range", ErrCorruptIndex, n)
+		}
+
+		var last uint64
+		for i := uint64(0); i < count; i++ {
+			e := m.edges + (first+i)*mappedEdge
+			d, child := m.uint32(e), m.uint32(e+4)
+
+			if child <= n || child >= m.nodes || reached[child] || d <= last {
+				return fmt.Errorf("%w: bad edge %d of node %d", ErrCorruptIndex, i, n)
+			}
+
+			parent[child], dist[child], reached[child] = uint32(n), uint32(d), true
+			last = d
+		}
+
+		idFirst, idCount := m.uint32(rec+16), m.uint32(rec+20)
+		if idFirst != ids || idFirst+idCount > m.entries {
+			return fmt.Errorf("%w: IDs of node %d out of range", ErrCorruptIndex, n)
+		}
+		ids += idCount
+	}
+
+	if ids != m.entries {
+		return fmt.Errorf("%w: %d of %d IDs belong to no node", ErrCorruptIndex, m.entries-ids, m.entries)
+	}
+
+	for i := uint64(0); i < m.entries; i++ {
+		off := m.ids + i*mappedID
+		if from, to := m.uint64(off), m.uint64(off+mappedID); from > to || m.str+to > uint64(len(m.data)) {
+			return fmt.Errorf("%w: ID %d out of range", ErrCorruptIndex, i)
+		}
+	}
+
+	return nil
+}
+
+func (s *indexStore) Verify(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.x.Verify()
+}
+
+func (s mappedStore) Verify(ctx context.Context) error {
+	return s.m.Verify()
+}
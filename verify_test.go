@@ -0,0 +1,62 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestIndexVerify(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x := NewIndex()
+	for i := 0; i < 500; i++ {
+		x.Add(string(rune('a'+i%26))+string(rune('a'+i/26)), rng.Uint64()&0xffff)
+	}
+	x.Remove("aa")
+	x.Warm()
+
+	if err := VerifyStore(context.Background(), IndexStore(x)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	x.WriteMapped(&buf)
+	m, err := newMappedIndex(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Verify(); err != nil {
+		t.Fatalf("mapped: %v", err)
+	}
+
+	// Move a hash somewhere the tree does not expect it.
+	data := append([]byte(nil), buf.Bytes()...)
+	rec := mappedHeader + 7*mappedNode
+	binary.LittleEndian.PutUint64(data[rec:], binary.LittleEndian.Uint64(data[rec:])^0x8000)
+	if m, err = newMappedIndex(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Verify(); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("moved mapped node: %v", err)
+	}
+
+	x.root.children[Distance(x.root.hash, 0)^1] = &bkNode[string]{hash: 0}
+	if err := x.Verify(); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("misplaced node: %v", err)
+	}
+
+	x = NewIndex()
+	x.Add("a", 1)
+	x.ids["b"] = 2
+	if err := x.Verify(); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("missing ID: %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrCompacted is returned for queries as of a sequence number before
+// the last compaction of a VersionedIndex.
+var ErrCompacted = errors.New("imghash: sequence number before the last compaction")
+
+// versionedMagic identifies the persistent versioned index format.
+const versionedMagic = "IMGHVER1"
+
+// A VersionedIndex is an index which keeps its past. Every change gets a
+// sequence number, and removed or replaced entries are kept, marked with
+// the sequence number of their removal, until Compact drops them. This
+// lets SearchAt answer a query as the index would have at any point
+// since, so moderation audits can reproduce exactly what was matched
+// at the time of a past decision.
+//
+// Changes are numbered as an UpdateLog numbers them: a VersionedIndex
+// which starts out empty and receives the updates of a log, like the
+// store of a follower does, has the sequence numbers of the log.
+//
+// A VersionedIndex is not safe for concurrent use; VersionedStore is.
+type VersionedIndex struct {
+	x        *Index                    // Every version kept, under its key.
+	versions map[string][]entryVersion // Versions of each ID, oldest first.
+	seq      uint64                    // Sequence number of the last change.
+	horizon  uint64                    // Sequence number of the last compaction.
+}
+
+// entryVersion is a hash an ID had from the change numbered added, up
+// to the one numbered removed, or 0 while it lasts.
+type entryVersion struct {
+	hash           uint64
+	added, removed uint64
+}
+
+// live returns true if the version was in the index as of seq.
+func (v *entryVersion) live(seq uint64) bool {
+	return v.added <= seq && (v.removed == 0 || seq < v.removed)
+}
+
+// versionKey returns the key a version is kept under in the index.
+func versionKey(id string, added uint64) string {
+	return id + "\x00" + strconv.FormatUint(added, 10)
+}
+
+// NewVersionedIndex creates a new, empty index.
+func NewVersionedIndex() *VersionedIndex {
+	return &VersionedIndex{x: NewIndex(), versions: make(map[string][]entryVersion)}
+}
+
+// Seq returns the sequence number of the last change, or 0 if there
+// were none.
+func (v *VersionedIndex) Seq() uint64 { return v.seq }
+
+// Horizon returns the sequence number of the last compaction. Queries
+// can go back to it, and no further.
+func (v *VersionedIndex) Horizon() uint64 { return v.horizon }
+
+// Len returns the number of IDs in the index as it is now.
+func (v *VersionedIndex) Len() int {
+	var n int
+	for _, vs := range v.versions {
+		if vs[len(vs)-1].removed == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Add adds the given ID and hash, and returns the sequence number of the
+// change. If the ID exists, its earlier hash is kept as removed by it.
+func (v *VersionedIndex) Add(id string, hash uint64) uint64 {
+	v.seq++
+	v.remove(id)
+	v.versions[id] = append(v.versions[id], entryVersion{hash: hash, added: v.seq})
+	v.x.Add(versionKey(id, v.seq), hash)
+	return v.seq
+}
+
+// Remove removes the given ID, and returns the sequence number of the
+// change. Its hash is kept, as removed by it. Removing an ID which is
+// not in the index counts as a change, as it does in an UpdateLog.
+func (v *VersionedIndex) Remove(id string) uint64 {
+	v.seq++
+	v.remove(id)
+	return v.seq
+}
+
+// remove marks the live version of id, if any, as removed by the
+// current change.
+func (v *VersionedIndex) remove(id string) {
+	vs := v.versions[id]
+	if len(vs) > 0 && vs[len(vs)-1].removed == 0 {
+		vs[len(vs)-1].removed = v.seq
+	}
+}
+
+// Search is like Index.Search, for the index as it is now.
+func (v *VersionedIndex) Search(hash, distance uint64) Hits {
+	hits, _ := v.SearchAt(hash, distance, v.seq)
+	return hits
+}
+
+// SearchAt is like Search, for the index as it was right after the
+// change with the given sequence number; 0 is before the first change.
+// It fails with ErrCompacted if the index was compacted since, and
+// with ErrUpdateSeq if there was no such change yet.
+func (v *VersionedIndex) SearchAt(hash, distance, seq uint64) (Hits, error) {
+	if seq < v.horizon {
+		return nil, ErrCompacted
+	}
+
+	if seq > v.seq {
+		return nil, ErrUpdateSeq
+	}
+
+	var hits Hits
+	for _, h := range v.x.Search(hash, distance) {
+		i := strings.LastIndexByte(h.ID, 0)
+		id := h.ID[:i]
+		added, _ := strconv.ParseUint(h.ID[i+1:], 10, 64)
+
+		for _, ev := range v.versions[id] {
+			if ev.added == added && ev.live(seq) {
+				hits = append(hits, Hit{Record{ID: id, Hash: ev.hash}, h.Distance})
+				break
+			}
+		}
+	}
+
+	hits.Sort()
+	return hits, nil
+}
+
+// Compact drops the versions removed as of the given sequence number,
+// and returns their number. Queries can then no longer go back before
+// it. Pass Seq() to drop all removed versions.
+func (v *VersionedIndex) Compact(seq uint64) int {
+	if seq > v.seq {
+		seq = v.seq
+	}
+
+	if seq <= v.horizon {
+		return 0
+	}
+
+	var dropped int
+	for id, vs := range v.versions {
+		kept := vs[:0]
+		for _, ev := range vs {
+			if ev.removed != 0 && ev.removed <= seq {
+				v.x.Remove(versionKey(id, ev.added))
+				dropped++
+				continue
+			}
+			kept = append(kept, ev)
+		}
+
+		if len(kept) == 0 {
+			delete(v.versions, id)
+		} else {
+			v.versions[id] = kept
+		}
+	}
+
+	v.horizon = seq
+	return dropped
+}
+
+// WriteTo writes the index to w, with all versions it keeps.
+//
+// The format starts with the magic string "IMGHVER1", followed by the
+// sequence numbers of the last change and of the last compaction, and
+// the number of versions. Each version holds the ID, the 8 byte, big
+// endian hash, and the sequence numbers of the changes which added and
+// removed it, the latter 0 if it was not removed. Versions are written
+// in order of ID, then of addition. Strings are prefixed by their length
+// and all lengths, counts and sequence numbers are stored as unsigned
+// varints.
+func (v *VersionedIndex) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(n uint64) {
+		cw.Write(buf[:binary.PutUvarint(buf[:], n)])
+	}
+
+	ids := make([]string, 0, len(v.versions))
+	var count int
+	for id, vs := range v.versions {
+		ids = append(ids, id)
+		count += len(vs)
+	}
+
+	sort.Strings(ids)
+
+	io.WriteString(cw, versionedMagic)
+	putUvarint(v.seq)
+	putUvarint(v.horizon)
+	putUvarint(uint64(count))
+
+	for _, id := range ids {
+		for _, ev := range v.versions[id] {
+			putUvarint(uint64(len(id)))
+			io.WriteString(cw, id)
+			binary.BigEndian.PutUint64(buf[:], ev.hash)
+			cw.Write(buf[:8])
+			putUvarint(ev.added)
+			putUvarint(ev.removed)
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// ReadFrom reads an index written by WriteTo from r, in place of the
+// contents of the index.
+func (v *VersionedIndex) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(versionedMagic))
+	if _, err := io.ReadFull(cr, magic); err != nil {
+		return cr.n, err
+	}
+
+	if string(magic) != versionedMagic {
+		return cr.n, ErrInvalidIndex
+	}
+
+	var head [3]uint64
+	for i := range head {
+		n, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return cr.n, err
+		}
+		head[i] = n
+	}
+
+	n := NewVersionedIndex()
+	n.seq, n.horizon = head[0], head[1]
+	if n.horizon > n.seq {
+		return cr.n, ErrInvalidIndex
+	}
+
+	var buf [8]byte
+	for count := head[2]; count > 0; count-- {
+		id, err := readString(cr)
+		if err != nil {
+			return cr.n, err
+		}
+
+		if _, err = io.ReadFull(cr, buf[:]); err != nil {
+			return cr.n, err
+		}
+
+		var ev entryVersion
+		ev.hash = binary.BigEndian.Uint64(buf[:])
+
+		if ev.added, err = binary.ReadUvarint(cr); err != nil {
+			return cr.n, err
+		}
+
+		if ev.removed, err = binary.ReadUvarint(cr); err != nil {
+			return cr.n, err
+		}
+
+		if ev.added == 0 || ev.added > n.seq || ev.removed > n.seq || ev.removed != 0 && ev.removed <= ev.added {
+			return cr.n, ErrInvalidIndex
+		}
+
+		n.versions[id] = append(n.versions[id], ev)
+		n.x.Add(versionKey(id, ev.added), ev.hash)
+	}
+
+	*v = *n
+	return cr.n, nil
+}
+
+// Save saves the index to the given file.
+func (v *VersionedIndex) Save(file string) (err error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return
+	}
+
+	if _, err = v.WriteTo(fd); err != nil {
+		fd.Close()
+		return
+	}
+
+	return fd.Close()
+}
+
+// Load loads an index from the given file.
+func (v *VersionedIndex) Load(file string) (err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+
+	_, err = v.ReadFrom(fd)
+	return
+}
+
+// A VersionedStore is a Store backed by a VersionedIndex, which also
+// answers queries as of past changes. It is safe for concurrent use.
+type VersionedStore struct {
+	mu sync.RWMutex
+	v  *VersionedIndex
+}
+
+// NewVersionedStore returns a store backed by the given index. The
+// index must not be used directly while the store is in use.
+func NewVersionedStore(v *VersionedIndex) *VersionedStore {
+	return &VersionedStore{v: v}
+}
+
+func (s *VersionedStore) Add(ctx context.Context, id string, hash uint64) error {
+	_, end := StartSpan(ctx, SpanIndexAdd)
+	defer end(nil)
+
+	s.mu.Lock()
+	s.v.Add(id, hash)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *VersionedStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	s.v.Remove(id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *VersionedStore) Query(ctx context.Context, hash, distance uint64) (ResultSet, error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return hitResults(s.v.Search(hash, distance)), nil
+}
+
+// QueryAt is like Query, for the store as it was right after the change
+// with the given sequence number. Refer to VersionedIndex.SearchAt.
+func (s *VersionedStore) QueryAt(ctx context.Context, hash, distance, seq uint64) (ResultSet, error) {
+	_, end := StartSpan(ctx, SpanIndexQuery)
+	defer end(nil)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hits, err := s.v.SearchAt(hash, distance, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	return hitResults(hits), nil
+}
+
+// hitResults returns the hits as search results.
+func hitResults(hits Hits) ResultSet {
+	rs := make(ResultSet, len(hits))
+	for i, h := range hits {
+		rs[i] = &SearchResult{Path: h.ID, Hash: h.Hash, Distance: h.Distance}
+	}
+	return rs
+}
+
+// Seq returns the sequence number of the last change to the store.
+func (s *VersionedStore) Seq() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.v.Seq()
+}
+
+// Compact compacts the index, as VersionedIndex.Compact does.
+func (s *VersionedStore) Compact(seq uint64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.v.Compact(seq)
+}
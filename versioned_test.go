@@ -0,0 +1,141 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVersionedIndex(t *testing.T) {
+	v := NewVersionedIndex()
+
+	v.Add("a", 0x0f)    // 1
+	v.Add("b", 0xf0)    // 2
+	v.Add("a", 0xff)    // 3: replaced.
+	v.Remove("b")       // 4
+	v.Remove("missing") // 5
+	if s := v.Add("c", 0x0e); s != 6 || v.Seq() != 6 || v.Len() != 2 {
+		t.Fatalf("seq %d, %d entries", s, v.Len())
+	}
+
+	ids := func(seq uint64) []string {
+		t.Helper()
+		hits, err := v.SearchAt(0x0f, 8, seq)
+		if err != nil {
+			t.Fatalf("as of %d: %v", seq, err)
+		}
+		return hits.IDs()
+	}
+
+	for seq, want := range [][]string{{}, {"a"}, {"a", "b"}, {"a", "b"}, {"a"}, {"a"}, {"c", "a"}} {
+		if got := ids(uint64(seq)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("as of %d: %v, want %v", seq, got, want)
+		}
+	}
+
+	// Replaced hashes are returned as they were.
+	if hits, _ := v.SearchAt(0x0f, 0, 2); len(hits) != 1 || hits[0].Hash != 0x0f {
+		t.Fatalf("replaced hash: %+v", hits)
+	}
+
+	if _, err := v.SearchAt(0, 64, 7); err != ErrUpdateSeq {
+		t.Fatalf("future: %v", err)
+	}
+
+	// Saved and loaded with the removed versions.
+	var buf bytes.Buffer
+	if _, err := v.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewVersionedIndex()
+	if _, err := w.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(w.versions, v.versions) || w.Seq() != 6 {
+		t.Fatalf("loaded %+v", w.versions)
+	}
+
+	if _, err := w.ReadFrom(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Fatal("truncated index loaded")
+	}
+
+	// Compaction drops what was removed, and the past before it.
+	if n := v.Compact(4); n != 2 || v.Horizon() != 4 {
+		t.Fatalf("compacted %d, horizon %d", n, v.Horizon())
+	}
+
+	if _, err := v.SearchAt(0x0f, 8, 3); err != ErrCompacted {
+		t.Fatalf("compacted past: %v", err)
+	}
+
+	if got := ids(4); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("after compaction: %v", got)
+	}
+
+	if v.x.Len() != 2 || v.Compact(2) != 0 {
+		t.Fatalf("%d versions kept", v.x.Len())
+	}
+
+	file := filepath.Join(t.TempDir(), "versions.idx")
+	if err := v.Save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Load(file); err != nil || w.Horizon() != 4 || w.Len() != 2 {
+		t.Fatalf("load: %v, horizon %d", err, w.Horizon())
+	}
+}
+
+func TestVersionedStore(t *testing.T) {
+	ctx := context.Background()
+	log, err := OpenUpdateLog(filepath.Join(t.TempDir(), "updates.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer log.Close()
+
+	s := NewLoggedStore(IndexStore(NewIndex()), log)
+	s.Add(ctx, "a", 1)
+	s.Add(ctx, "b", 3)
+	s.Remove(ctx, "a")
+
+	// A follower numbers its changes as the log does.
+	var stream bytes.Buffer
+	if err := log.Stream(ctx, &stream, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	vs := NewVersionedStore(NewVersionedIndex())
+	if err := ReadUpdates(&stream, func(u *Update) error { return u.Apply(ctx, vs) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if vs.Seq() != log.Seq() {
+		t.Fatalf("seq %d, log at %d", vs.Seq(), log.Seq())
+	}
+
+	rs, err := vs.QueryAt(ctx, 0, 2, 2)
+	if err != nil || len(rs) != 2 || rs[0].Path != "a" {
+		t.Fatalf("as of 2: %v, %v", rs, err)
+	}
+
+	if rs, _ := vs.Query(ctx, 0, 2); len(rs) != 1 || rs[0].Path != "b" {
+		t.Fatalf("now: %v", rs)
+	}
+
+	if vs.Compact(3) != 1 {
+		t.Fatal("nothing compacted")
+	}
+
+	if _, err := vs.QueryAt(ctx, 0, 2, 2); err != ErrCompacted {
+		t.Fatalf("compacted: %v", err)
+	}
+}
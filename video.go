@@ -0,0 +1,409 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for VideoOptions.
+const (
+	defaultKeyframeInterval = time.Second
+	defaultSceneInterval    = 10 * time.Second
+	defaultSceneThreshold   = 12
+	defaultFrameStep        = 30
+	defaultMotionThreshold  = 6
+)
+
+// A FrameSource produces the frames of a video in display order.
+//
+// This package does not decode video itself. A FrameSource is easily
+// implemented on top of ffmpeg bindings, or by reading raw frames from
+// an ffmpeg process writing to a pipe:
+//
+//	ffmpeg -i movie.mp4 -f rawvideo -pix_fmt rgba -vf fps=5 -
+//
+// Only Image and Time need to be set on the returned frames.
+type FrameSource interface {
+	// NextFrame returns the next frame. It returns io.EOF once
+	// there are no more frames.
+	NextFrame() (*Frame, error)
+}
+
+// sliceSource is a FrameSource reading from a slice.
+type sliceSource []*Frame
+
+func (s *sliceSource) NextFrame() (*Frame, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+
+	f := (*s)[0]
+	*s = (*s)[1:]
+	return f, nil
+}
+
+// SliceSource returns a FrameSource for the given frames, for example
+// those returned by DecodeAnimation.
+func SliceSource(frames []*Frame) FrameSource {
+	s := sliceSource(frames)
+	return &s
+}
+
+// A Sampling is a policy by which HashVideo picks the keyframes it
+// hashes. Footage which hardly changes, like screen recordings, talking
+// heads or surveillance, is best sampled by motion, which spends almost
+// nothing on frames which look like the last keyframe; footage which
+// cuts a lot by scenes; and the rest at intervals.
+type Sampling int
+
+// Known sampling policies.
+const (
+	// A keyframe every Interval. This is the default.
+	SampleInterval Sampling = iota
+
+	// A keyframe every Step frames, for sources whose frame times are
+	// unreliable.
+	SampleFrames
+
+	// A keyframe at every scene cut, as with Scenes.
+	SampleScenes
+
+	// A keyframe whenever the frame has moved away from the last one,
+	// by MotionThreshold. Motion is measured on the Triage codes of the
+	// frames, which take a fraction of the time of a hash.
+	SampleMotion
+
+	samplings
+)
+
+var samplingNames = [...]string{"interval", "frames", "scenes", "motion"}
+
+func (s Sampling) String() string {
+	if s < 0 || s >= samplings {
+		return fmt.Sprintf("Sampling(%d)", int(s))
+	}
+	return samplingNames[s]
+}
+
+// VideoOptions configure HashVideo.
+type VideoOptions struct {
+	// Policy by which keyframes are picked.
+	Sampling Sampling
+
+	// Time between keyframes. Defaults to a second. With scene or
+	// motion sampling, this is the maximum time between keyframes
+	// instead, and it defaults to ten seconds. It does not apply to
+	// SampleFrames.
+	Interval time.Duration
+
+	// Number of frames from one keyframe to the next, for SampleFrames.
+	// Defaults to 30.
+	Step int
+
+	// If set, a keyframe is taken at every scene cut, rather than at
+	// fixed intervals. This catches short scenes which fixed sampling
+	// skips entirely, at the cost of hashing every frame. It is the same
+	// as a Sampling of SampleScenes.
+	Scenes bool
+
+	// Minimum Hamming Distance between consecutive frames for a scene
+	// cut. Refer to SceneDetector for details. Defaults to 12.
+	SceneThreshold uint64
+
+	// Minimum distance between the Triage codes of a frame and of the
+	// last keyframe, for SampleMotion. Defaults to 6, of 32 bits.
+	MotionThreshold uint64
+}
+
+// ParseSampling returns the options for a sampling policy described by
+// name and an optional argument, as kept in the configuration of a
+// source:
+//
+//	interval 2s   a keyframe every 2 seconds
+//	frames 30     a keyframe every 30 frames
+//	scenes 12     a keyframe at scene cuts, with a threshold of 12
+//	motion 6      a keyframe on motion, with a threshold of 6
+//
+// Policies without an argument use the defaults.
+func ParseSampling(spec string) (*VideoOptions, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("imghash: invalid sampling %q", spec)
+	}
+
+	o := &VideoOptions{Sampling: samplings}
+	for s, name := range samplingNames {
+		if name == strings.ToLower(fields[0]) {
+			o.Sampling = Sampling(s)
+		}
+	}
+
+	if o.Sampling == samplings {
+		return nil, fmt.Errorf("imghash: unknown sampling %q", fields[0])
+	}
+
+	if len(fields) == 1 {
+		return o, nil
+	}
+
+	var err error
+	var n uint64
+
+	if o.Sampling == SampleInterval {
+		o.Interval, err = time.ParseDuration(fields[1])
+	} else if n, err = strconv.ParseUint(fields[1], 10, 16); err == nil {
+		switch o.Sampling {
+		case SampleFrames:
+			o.Step = int(n)
+		case SampleScenes:
+			o.SceneThreshold = n
+		case SampleMotion:
+			o.MotionThreshold = n
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("imghash: invalid sampling %q", spec)
+	}
+
+	return o, nil
+}
+
+// A SceneDetector finds scene cuts in a sequence of frame hashes.
+//
+// A cut is a spike in the distance between consecutive frames: the
+// distance must reach Threshold, and be at least twice the running mean
+// of recent distances. The latter keeps scenes with a lot of motion
+// from registering a cut on every frame.
+type SceneDetector struct {
+	Threshold uint64 // Minimum distance for a cut.
+
+	prev uint64  // Hash of the previous frame.
+	mean float64 // Running mean distance within the scene.
+	seen bool    // Whether prev is set.
+}
+
+// Cut adds the hash of the next frame, and returns true if it starts
+// a new scene. The first frame always does.
+func (s *SceneDetector) Cut(hash uint64) bool {
+	if !s.seen {
+		s.prev, s.seen = hash, true
+		return true
+	}
+
+	dist := Distance(s.prev, hash)
+	s.prev = hash
+
+	if dist >= s.Threshold && float64(dist) >= 2*s.mean {
+		s.mean = 0
+		return true
+	}
+
+	s.mean = 0.9*s.mean + 0.1*float64(dist)
+	return false
+}
+
+// A VideoSignature describes a video by the hashes of its keyframes.
+type VideoSignature struct {
+	Keyframes []FrameHash   // Keyframe hashes, in display order.
+	Duration  time.Duration // Time of the last frame read.
+	Frames    int           // Number of frames read.
+	Hashed    int           // Number of frames hashed.
+}
+
+// HashVideo reads all frames from src, and hashes keyframes using the
+// given HashFunc. Keyframes are picked by the sampling policy of the
+// options. Except with scene sampling, frames in between are skipped
+// without being hashed. Opts may be nil, to use the defaults. It fails
+// for an unknown Sampling.
+func HashVideo(src FrameSource, hf HashFunc, opts *VideoOptions) (*VideoSignature, error) {
+	var o VideoOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Scenes {
+		o.Sampling = SampleScenes
+	}
+
+	if o.Sampling < 0 || o.Sampling >= samplings {
+		return nil, fmt.Errorf("imghash: unknown sampling %v", o.Sampling)
+	}
+
+	interval := o.Interval
+	if interval <= 0 {
+		interval = defaultKeyframeInterval
+		if o.Sampling == SampleScenes || o.Sampling == SampleMotion {
+			interval = defaultSceneInterval
+		}
+	}
+
+	step := o.Step
+	if step <= 0 {
+		step = defaultFrameStep
+	}
+
+	motion := o.MotionThreshold
+	if motion == 0 {
+		motion = defaultMotionThreshold
+	}
+
+	var scenes *SceneDetector
+	if o.Sampling == SampleScenes {
+		scenes = &SceneDetector{Threshold: o.SceneThreshold}
+		if scenes.Threshold == 0 {
+			scenes.Threshold = defaultSceneThreshold
+		}
+	}
+
+	sig := new(VideoSignature)
+
+	var next time.Duration
+	var last *FrameHash
+	var hash uint64
+	var code, ref uint32 // Triage codes of the frame and the last keyframe.
+
+	for {
+		f, err := src.NextFrame()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		sig.Duration = f.Time + f.Duration
+		sig.Frames++
+
+		take := len(sig.Keyframes) == 0
+		switch o.Sampling {
+		case SampleInterval:
+			take = take || f.Time >= next
+		case SampleFrames:
+			take = take || (sig.Frames-1)%step == 0
+		case SampleScenes:
+			hash = hf(f.Image)
+			sig.Hashed++
+			take = scenes.Cut(hash) || take || f.Time >= next
+		case SampleMotion:
+			code = Triage(f.Image)
+			take = take || f.Time >= next || uint64(bits.OnesCount32(code^ref)) >= motion
+		}
+
+		if !take {
+			continue
+		}
+
+		if scenes == nil {
+			hash = hf(f.Image)
+			sig.Hashed++
+		}
+
+		ref = code
+
+		// A keyframe lasts until the next one.
+		if last != nil {
+			last.Duration = f.Time - last.Time
+		}
+
+		sig.Keyframes = append(sig.Keyframes, FrameHash{
+			Hash: hash,
+			Time: f.Time,
+		})
+
+		last = &sig.Keyframes[len(sig.Keyframes)-1]
+		next = f.Time + interval
+	}
+
+	if last != nil {
+		last.Duration = sig.Duration - last.Time
+	}
+
+	return sig, nil
+}
+
+// VideoDistance returns the mean Hamming Distance between the
+// keyframes of two videos, after aligning them.
+//
+// The shorter video is aligned against any contiguous stretch of the
+// longer one, using dynamic time warping. A clip cut from a longer
+// video therefore matches it, as does a copy which was slowed down,
+// sped up or had short bits removed. The result is 64 if either
+// video has no keyframes.
+func VideoDistance(a, b *VideoSignature) float64 {
+	query, ref := a.Keyframes, b.Keyframes
+	if len(query) > len(ref) {
+		query, ref = ref, query
+	}
+
+	if len(query) == 0 {
+		return 64
+	}
+
+	// Cost and path length of the best alignment ending at
+	// each reference keyframe, for the previous and current row.
+	m := len(ref)
+	cost := make([]float64, m)
+	steps := make([]int, m)
+	pcost := make([]float64, m)
+	psteps := make([]int, m)
+
+	var i, j int
+	var d float64
+
+	for j = 0; j < m; j++ {
+		// The alignment may start anywhere in the reference.
+		pcost[j] = float64(Distance(query[0].Hash, ref[j].Hash))
+		psteps[j] = 1
+	}
+
+	for i = 1; i < len(query); i++ {
+		for j = 0; j < m; j++ {
+			d = float64(Distance(query[i].Hash, ref[j].Hash))
+
+			// Stay on the same reference keyframe.
+			cost[j], steps[j] = pcost[j]+d, psteps[j]+1
+
+			if j == 0 {
+				continue
+			}
+
+			// Advance both.
+			if better(pcost[j-1]+d, psteps[j-1]+1, cost[j], steps[j]) {
+				cost[j], steps[j] = pcost[j-1]+d, psteps[j-1]+1
+			}
+
+			// Advance the reference only.
+			if better(cost[j-1]+d, steps[j-1]+1, cost[j], steps[j]) {
+				cost[j], steps[j] = cost[j-1]+d, steps[j-1]+1
+			}
+		}
+
+		cost, pcost = pcost, cost
+		steps, psteps = psteps, steps
+	}
+
+	// The alignment may end anywhere in the reference.
+	best := 64.0
+	for j = 0; j < m; j++ {
+		if d = pcost[j] / float64(psteps[j]); d < best {
+			best = d
+		}
+	}
+
+	return best
+}
+
+// better returns true if the alignment with cost a over na steps
+// has a lower mean cost than the one with cost b over nb steps.
+func better(a float64, na int, b float64, nb int) bool {
+	return a*float64(nb) < b*float64(na)
+}
@@ -0,0 +1,274 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestVideo(t *testing.T) {
+	scenes := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	a, err := HashVideo(newTestVideo(scenes, 30, 0, 10*time.Second), Average, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Keyframes) != 10 || a.Duration != 10*time.Second {
+		t.Fatalf("unexpected keyframes: %d, duration %v", len(a.Keyframes), a.Duration)
+	}
+
+	// A clip cut from the middle, at a lower frame rate.
+	b, err := HashVideo(newTestVideo(scenes, 15, 3500*time.Millisecond, 7*time.Second), Average, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := VideoDistance(a, b); d > 1 {
+		t.Fatalf("clip mismatch: distance %.2f", d)
+	}
+
+	if VideoDistance(a, b) != VideoDistance(b, a) {
+		t.Fatalf("distance is not symmetric")
+	}
+
+	// A different video.
+	c, err := HashVideo(newTestVideo([]int64{11, 12, 13, 14, 15}, 30, 0, 5*time.Second), Average, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := VideoDistance(a, c); d <= MaxDistance {
+		t.Fatalf("different videos match: distance %.2f", d)
+	}
+}
+
+func TestVideoScenes(t *testing.T) {
+	// Three seconds of one scene, interrupted by a
+	// fifth of a second of another one.
+	var frames []*Frame
+	step := time.Second / 30
+
+	for i := 0; i < 90; i++ {
+		seed := int64(1)
+		if i >= 72 && i < 78 {
+			seed = 2
+		}
+
+		frames = append(frames, &Frame{
+			Image:    blockPattern(seed),
+			Time:     time.Duration(i) * step,
+			Duration: step,
+		})
+	}
+
+	inserted := Average(blockPattern(2))
+
+	fixed, err := HashVideo(SliceSource(frames), Average, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range fixed.Keyframes {
+		if k.Hash == inserted {
+			t.Fatalf("fixed sampling unexpectedly caught the short scene")
+		}
+	}
+
+	scenes, err := HashVideo(SliceSource(frames), Average, &VideoOptions{Scenes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scenes.Keyframes) != 3 {
+		t.Fatalf("expected 3 keyframes, got %d", len(scenes.Keyframes))
+	}
+
+	k := scenes.Keyframes[1]
+	if k.Hash != inserted || k.Time != 72*step || k.Duration != 6*step {
+		t.Fatalf("unexpected keyframe for the short scene: %+v", k)
+	}
+}
+
+func TestVideoSampling(t *testing.T) {
+	// Three seconds of one scene, a second of another, and three more
+	// of the first.
+	src := func() FrameSource {
+		return newTestVideo([]int64{1, 1, 1, 2, 1, 1, 1}, 30, 0, 7*time.Second)
+	}
+
+	frames, err := HashVideo(src(), Average, &VideoOptions{Sampling: SampleFrames, Step: 45})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames.Keyframes) != 5 || frames.Hashed != 5 || frames.Frames != 210 || frames.Keyframes[1].Time != 1500*time.Millisecond {
+		t.Fatalf("frame sampling: %d keyframes, %d of %d frames hashed", len(frames.Keyframes), frames.Hashed, frames.Frames)
+	}
+
+	// Motion sampling hashes the first frame of each scene, and nothing
+	// else.
+	motion, err := HashVideo(src(), Average, &VideoOptions{Sampling: SampleMotion})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(motion.Keyframes) != 3 || motion.Hashed != 3 || motion.Keyframes[1].Time != 3*time.Second || motion.Keyframes[2].Duration != 3*time.Second {
+		t.Fatalf("motion sampling: %+v", motion)
+	}
+
+	if VideoDistance(motion, frames) != 0 {
+		t.Fatalf("motion and frame sampling disagree")
+	}
+
+	// The interval bounds the time between keyframes.
+	if motion, _ = HashVideo(src(), Average, &VideoOptions{Sampling: SampleMotion, Interval: 2 * time.Second}); len(motion.Keyframes) != 5 {
+		t.Fatalf("motion sampling with interval: %d keyframes", len(motion.Keyframes))
+	}
+
+	if _, err = HashVideo(src(), Average, &VideoOptions{Sampling: samplings}); err == nil {
+		t.Fatalf("unknown sampling accepted")
+	}
+}
+
+func TestParseSampling(t *testing.T) {
+	for spec, want := range map[string]VideoOptions{
+		"interval":    {},
+		"Interval 2s": {Interval: 2 * time.Second},
+		"frames 15":   {Sampling: SampleFrames, Step: 15},
+		"scenes 20":   {Sampling: SampleScenes, SceneThreshold: 20},
+		"motion":      {Sampling: SampleMotion},
+		"motion 4":    {Sampling: SampleMotion, MotionThreshold: 4},
+	} {
+		if o, err := ParseSampling(spec); err != nil || *o != want {
+			t.Errorf("%q: %+v, %v", spec, o, err)
+		}
+	}
+
+	for _, spec := range []string{"", "every 5", "frames x", "interval 5", "motion 4 5"} {
+		if _, err := ParseSampling(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+
+	if SampleMotion.String() != "motion" {
+		t.Errorf("name %q", SampleMotion)
+	}
+}
+
+func TestTMK(t *testing.T) {
+	// TMK periods span minutes, so it needs longer videos to
+	// tell the order of scenes apart.
+	rng := rand.New(rand.NewSource(1))
+	scenes := make([]int64, 180)
+	for i := range scenes {
+		scenes[i] = rng.Int63()
+	}
+
+	shuffled := make([]int64, len(scenes))
+	for i, j := range rng.Perm(len(scenes)) {
+		shuffled[i] = scenes[j]
+	}
+
+	length := time.Duration(len(scenes)) * time.Second
+
+	for _, tt := range []struct {
+		name string
+		ff   FeatureFunc
+		n    int
+	}{
+		{"grid", GridFeatures, 64},
+		{"pdqf", PDQFeatures, 256},
+	} {
+		a, err := ComputeTMK(newTestVideo(scenes, 30, 0, length), tt.ff)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Frames != len(scenes)*15 || len(a.Average) != tt.n {
+			t.Fatalf("%s: unexpected signature: %d frames, %d features", tt.name, a.Frames, len(a.Average))
+		}
+
+		// The same video at a different frame rate.
+		b, err := ComputeTMK(newTestVideo(scenes, 24, 0, length), tt.ff)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if l1, l2 := TMKLevel1(a, b), TMKLevel2(a, b); l1 < 0.99 || l2 < 0.99 {
+			t.Fatalf("%s: frame rate change: level 1 %.3f, level 2 %.3f", tt.name, l1, l2)
+		}
+
+		// The same scenes, shuffled.
+		c, err := ComputeTMK(newTestVideo(shuffled, 30, 0, length), tt.ff)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if l1, l2 := TMKLevel1(a, c), TMKLevel2(a, c); l1 < 0.99 || l2 >= 0.7 {
+			t.Fatalf("%s: shuffled scenes: level 1 %.3f, level 2 %.3f", tt.name, l1, l2)
+		}
+	}
+}
+
+// testVideo generates frames for a video made up of one second scenes.
+// Each scene shows a random block pattern, seeded by the scene number.
+type testVideo struct {
+	scenes []int64
+	fps    int
+	frame  int
+	end    int
+}
+
+func newTestVideo(scenes []int64, fps int, start, end time.Duration) FrameSource {
+	return &testVideo{
+		scenes: scenes,
+		fps:    fps,
+		frame:  int(start * time.Duration(fps) / time.Second),
+		end:    int(end * time.Duration(fps) / time.Second),
+	}
+}
+
+// at returns the display time of the given frame.
+func (v *testVideo) at(frame int) time.Duration {
+	return time.Duration(frame) * time.Second / time.Duration(v.fps)
+}
+
+func (v *testVideo) NextFrame() (*Frame, error) {
+	if v.frame >= v.end {
+		return nil, io.EOF
+	}
+
+	f := &Frame{
+		Image:    blockPattern(v.scenes[v.frame/v.fps]),
+		Time:     v.at(v.frame),
+		Duration: v.at(v.frame+1) - v.at(v.frame),
+	}
+
+	v.frame++
+	return f, nil
+}
+
+// blockPattern returns a random block pattern with the given seed.
+func blockPattern(seed int64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	rng := rand.New(rand.NewSource(seed))
+
+	for y := 0; y < 64; y += 8 {
+		for x := 0; x < 64; x += 8 {
+			c := color.Gray{Y: uint8(rng.Intn(256))}
+
+			for i := 0; i < 64; i++ {
+				img.SetGray(x+i%8, y+i/8, c)
+			}
+		}
+	}
+
+	return img
+}
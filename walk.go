@@ -0,0 +1,133 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// HashFS walks the given file system, and concurrently hashes all image
+// files it finds using the given HashFunc. This works with any fs.FS:
+// os.DirFS, embed.FS, zip archives or adapters for cloud storage.
+// Opts may be nil, to use the defaults.
+//
+// Files are selected by extension, and optionally by sniffing their
+// contents. Refer to BatchOptions for details. Result paths are the
+// slash-separated paths within fsys.
+//
+// Results are sent on the returned channel in the order in which they
+// complete. Files which can not be read or decoded, and directories
+// which can not be listed, yield a result with Err set; they do not stop
+// the walk. The channel is closed once the walk is done, or once ctx is
+// cancelled.
+//
+// With the Archives option, zip and tar archives are walked as well,
+// and their entries are streamed to the workers as they are read, a few
+// at a time, without extracting the archives to disk.
+func HashFS(ctx context.Context, fsys fs.FS, hf HashFunc, opts *BatchOptions) <-chan *BatchResult {
+	b := newBatch(ctx, opts, fsys.Open)
+	jobs := make(chan job)
+
+	go func() {
+		defer close(jobs)
+
+		fs.WalkDir(fsys, ".", func(file string, d fs.DirEntry, err error) error {
+			if b.ctx.Err() != nil {
+				return fs.SkipAll
+			}
+
+			if err == nil && b.opts.Archives && d.Type().IsRegular() {
+				if kind := archiveKind(file); kind != archiveNone {
+					if !b.walkArchive(fsys, file, kind, jobs) {
+						return fs.SkipAll
+					}
+					return nil
+				}
+			}
+
+			if err == nil && (d.IsDir() || !b.accept(fsys, file, d)) {
+				return nil
+			}
+
+			select {
+			case jobs <- job{path: file, err: err}:
+			case <-b.ctx.Done():
+				return fs.SkipAll
+			}
+
+			return nil
+		})
+	}()
+
+	return b.run(jobs, hf)
+}
+
+// accept returns true if the given file in fsys should be hashed.
+func (b *batch) accept(fsys fs.FS, file string, d fs.DirEntry) bool {
+	return b.match(file, d, func() bool { return sniff(fsys, file, b.limit) })
+}
+
+// match returns true if the given file should be hashed. If its
+// extension is not one to hash, sniff is called to read its header.
+func (b *batch) match(file string, d fs.DirEntry, sniff func() bool) bool {
+	if !d.Type().IsRegular() {
+		return false
+	}
+
+	if b.opts.MinSize > 0 {
+		stat, err := d.Info()
+		if err != nil || stat.Size() < b.opts.MinSize {
+			return false
+		}
+	}
+
+	exts := b.opts.Extensions
+	if exts == nil {
+		exts = Extensions()
+	}
+
+	ext := path.Ext(file)
+	for _, e := range exts {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+
+	return b.opts.Sniff && sniff()
+}
+
+// sniff returns true if the file holds an image in a registered format.
+// Reads count towards the limits of t, which may be nil.
+func sniff(fsys fs.FS, file string, t *throttle) bool {
+	t.acquire()
+	defer t.release()
+
+	fd, err := fsys.Open(file)
+	if err != nil {
+		return false
+	}
+
+	defer fd.Close()
+	return sniffReader(t.reader(fd))
+}
+
+// sniffReader returns true if r holds an image in a registered format.
+func sniffReader(r io.Reader) bool {
+	header := make([]byte, 64)
+	n, _ := io.ReadFull(r, header)
+	header = header[:n]
+
+	if findDecoder(header) != nil {
+		return true
+	}
+
+	_, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(header), r))
+	return err == nil
+}
@@ -0,0 +1,98 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"runtime"
+	"sort"
+)
+
+// bkEdge is a child of a BK-tree node, at the given distance.
+type bkEdge[K comparable] struct {
+	dist uint64
+	node *bkNode[K]
+}
+
+// IndexStats describe the shape of the BK-tree of an Index.
+type IndexStats struct {
+	Entries   int     // Number of IDs.
+	Nodes     int     // Number of nodes, one for every distinct hash.
+	Empty     int     // Number of nodes left without IDs by removals.
+	Depth     int     // Number of nodes on the longest path from the root.
+	MeanDepth float64 // Mean number of nodes on the path to a node.
+
+	// Buckets holds the number of nodes at every distance from their
+	// parent. A tree whose nodes are spread evenly over the buckets
+	// passes over more of them in a query.
+	Buckets [65]int
+}
+
+// Warm prepares the index for queries, and returns the shape of its tree.
+// Call it after loading an index, before serving queries from it, so
+// the first queries after a restart take no longer than later ones.
+//
+// It sorts the children of every node by distance, so queries look up
+// the range of children they need to visit, rather than going over all
+// of them. Children which change later fall back to the unsorted form,
+// until Warm runs again. It also collects the IDs of a snapshot, which
+// is otherwise done by the first call which needs them, and runs a
+// garbage collection, so one set off by loading the index does not slow
+// down the first queries. Like Add, it must not be called concurrently
+// with other calls on the index, or on snapshots taken from it.
+func (x *Index) Warm() IndexStats {
+	x.load()
+
+	s := IndexStats{Entries: len(x.ids)}
+	x.warm(&s)
+
+	runtime.GC()
+	return s
+}
+
+// warm sorts the children of every node, and records the shape of the
+// tree in s.
+func (t *bkTree[K]) warm(s *IndexStats) {
+	t.survey(s, true)
+}
+
+// survey records the shape of the tree in s. If sortEdges is set, it
+// also sorts the children of every node; otherwise the tree is only
+// read, and may be shared with an index which is in use.
+func (t *bkTree[K]) survey(s *IndexStats, sortEdges bool) {
+	var depths int
+
+	var walk func(n *bkNode[K], depth int)
+	walk = func(n *bkNode[K], depth int) {
+		s.Nodes++
+		if len(n.ids) == 0 {
+			s.Empty++
+		}
+
+		depths += depth
+		if depth > s.Depth {
+			s.Depth = depth
+		}
+
+		for d, child := range n.children {
+			s.Buckets[d]++
+			walk(child, depth+1)
+		}
+
+		if !sortEdges {
+			return
+		}
+
+		n.edges = make([]bkEdge[K], 0, len(n.children))
+		for d, child := range n.children {
+			n.edges = append(n.edges, bkEdge[K]{d, child})
+		}
+
+		sort.Slice(n.edges, func(i, j int) bool { return n.edges[i].dist < n.edges[j].dist })
+	}
+
+	if t.root != nil {
+		walk(t.root, 1)
+		s.MeanDepth = float64(depths) / float64(s.Nodes)
+	}
+}
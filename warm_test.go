@@ -0,0 +1,94 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestIndexWarm(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x, cold := NewIndex(), NewIndex()
+
+	add := func(id string, hash uint64) {
+		x.Add(id, hash)
+		cold.Add(id, hash)
+	}
+
+	base := rng.Uint64()
+	for i := 0; i < 2000; i++ {
+		// Clustered hashes, so queries find something.
+		add(fmt.Sprint(i), base^rng.Uint64()&rng.Uint64()&rng.Uint64())
+	}
+
+	add("again", x.ids["7"])
+
+	same := func(when string) {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			q := base ^ rng.Uint64()&rng.Uint64()&rng.Uint64()
+			if a, b := x.Search(q, 10), cold.Search(q, 10); !reflect.DeepEqual(a, b) {
+				t.Fatalf("%s: %d hits, want %d", when, len(a), len(b))
+			}
+		}
+	}
+
+	s := x.Warm()
+	if s.Entries != 2001 || s.Nodes != 2000 || s.Depth < 2 || s.MeanDepth <= 1 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	var children int
+	for _, n := range s.Buckets {
+		children += n
+	}
+
+	if children != s.Nodes-1 || s.Buckets[0] != 0 {
+		t.Fatalf("buckets %v", s.Buckets)
+	}
+
+	same("warm")
+
+	// Changes drop the sorted children of the nodes they touch.
+	for i := 0; i < 200; i++ {
+		add(fmt.Sprint("new", i), base^rng.Uint64()&rng.Uint64())
+		x.Remove(fmt.Sprint(i))
+		cold.Remove(fmt.Sprint(i))
+	}
+
+	same("changed")
+
+	// Snapshots are warmed by their own call.
+	snap := x.Snapshot()
+	if s := snap.Warm(); s.Entries != x.Len() {
+		t.Fatalf("snapshot stats %+v", s)
+	}
+
+	if s := NewIndex().Warm(); s.Nodes != 0 || s.MeanDepth != 0 {
+		t.Fatalf("empty index %+v", s)
+	}
+}
+
+func BenchmarkIndexSearch(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	x := NewIndex()
+	for i := 0; i < 100000; i++ {
+		x.Add(fmt.Sprint(i), rng.Uint64())
+	}
+
+	for _, warm := range []bool{false, true} {
+		if warm {
+			x.Warm()
+		}
+
+		b.Run(fmt.Sprintf("warm=%v", warm), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				x.Search(rng.Uint64(), 8)
+			}
+		})
+	}
+}
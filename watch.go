@@ -0,0 +1,254 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A WatchOp is the kind of a WatchEvent.
+type WatchOp int
+
+// Known watch operations.
+const (
+	WatchAdd    WatchOp = iota // A new or changed file was hashed and added.
+	WatchRemove                // A file was removed, and so was its entry.
+	WatchError                 // A file could not be hashed.
+	watchOps
+)
+
+var watchOpNames = [...]string{"add", "remove", "error"}
+
+func (op WatchOp) String() string {
+	if op < 0 || op >= watchOps {
+		return fmt.Sprintf("WatchOp(%d)", int(op))
+	}
+	return watchOpNames[op]
+}
+
+// A WatchEvent reports a change Watch made to the index, or a file it
+// failed to hash.
+type WatchEvent struct {
+	Op      WatchOp
+	Path    string
+	Hash    uint64 // Hash of the file, for WatchAdd.
+	Matches Hits   // Other entries within the distance, for WatchAdd.
+	Err     error  // Error hashing the file, for WatchError.
+	Retry   bool   // Whether the file will be tried again, for WatchError.
+}
+
+// WatchOptions configure Watch.
+type WatchOptions struct {
+	// Interval at which the directory is scanned. Defaults to 2
+	// seconds.
+	Interval time.Duration
+
+	// How long a file must go unchanged before it is hashed, so files
+	// still being written are not hashed early. Defaults to the
+	// interval.
+	Settle time.Duration
+
+	// Number of times a file which failed to hash is tried again, each
+	// once it has settled anew. It is then left alone until it changes.
+	// Defaults to 3; use a negative number to never retry.
+	Retries int
+
+	// Hamming Distance within which other entries are reported as
+	// matches of an added file.
+	Distance uint64
+
+	// Accept returns true for files to hash. Defaults to HasExtension.
+	Accept func(path string) bool
+
+	// OnEvent is called for every event, if set.
+	OnEvent func(WatchEvent)
+
+	// AfterScan is called after every scan which changed the index, if
+	// set, to save it, say.
+	AfterScan func()
+
+	// If set, Watch also scans root whenever it receives from Notify,
+	// and again once the files have had time to settle. This lets file
+	// system notifications pick up changes before the next interval.
+	// Notifications may be coalesced: a channel with a buffer of one,
+	// sent to without blocking, will do.
+	Notify <-chan struct{}
+}
+
+// watchFile is what Watch remembers about a file.
+type watchFile struct {
+	size     int64
+	modTime  time.Time
+	changed  time.Time // When the file was last seen to change.
+	hashed   bool      // Whether this version of the file is done with.
+	attempts int       // Failed attempts to hash this version.
+}
+
+// Watch keeps the index in line with the image files under root, until
+// ctx is cancelled: new and changed files are hashed and added, under
+// their paths, and the entries of removed files are removed. Opts may be
+// nil, to use the defaults. It returns ctx.Err(), or an error if root
+// can not be read at the start.
+//
+// Changes are found by scanning root at an interval, which works on any
+// file system, network mounts included, and on notifications received
+// from opts.Notify. Entries already in the index
+// count as up to date at the start. Watch changes the index from its own
+// goroutine. Since an Index is not safe for concurrent use, only use it
+// from the hooks of opts, or take Snapshots from them.
+func Watch(ctx context.Context, root string, x *Index, hf HashFunc, opts *WatchOptions) error {
+	o := WatchOptions{Interval: 2 * time.Second, Retries: 3, Accept: HasExtension}
+	if opts != nil {
+		o = *opts
+		if o.Interval <= 0 {
+			o.Interval = 2 * time.Second
+		}
+		if o.Retries == 0 {
+			o.Retries = 3
+		}
+		if o.Accept == nil {
+			o.Accept = HasExtension
+		}
+	}
+
+	if o.Settle <= 0 {
+		o.Settle = o.Interval
+	}
+
+	if _, err := os.Stat(root); err != nil {
+		return err
+	}
+
+	w := &watcher{x: x, hf: hf, opts: &o, files: make(map[string]*watchFile)}
+
+	tick := time.NewTicker(o.Interval)
+	defer tick.Stop()
+
+	// Fires once the files changed since a notification have settled.
+	settled := time.NewTimer(o.Settle)
+	settled.Stop()
+	defer settled.Stop()
+
+	var notified bool
+
+	for {
+		if w.scan(root, time.Now()) && o.AfterScan != nil {
+			o.AfterScan()
+		}
+
+		// The timer starts after the scan, which noted the changes.
+		if notified {
+			settled.Reset(o.Settle)
+			notified = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		case <-settled.C:
+		case <-o.Notify:
+			notified = true
+		}
+	}
+}
+
+// watcher holds the state of Watch.
+type watcher struct {
+	x     *Index
+	hf    HashFunc
+	opts  *WatchOptions
+	files map[string]*watchFile
+}
+
+// scan updates the state of all files under root, and hashes those
+// which have settled. It returns true if the index changed.
+func (w *watcher) scan(root string, now time.Time) bool {
+	var changed bool
+	seen := make(map[string]bool, len(w.files))
+
+	filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !w.opts.Accept(file) {
+			return nil
+		}
+
+		stat, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		seen[file] = true
+		f, ok := w.files[file]
+
+		switch {
+		case !ok:
+			_, indexed := w.x.Hash(file)
+			w.files[file] = &watchFile{size: stat.Size(), modTime: stat.ModTime(), changed: now, hashed: indexed}
+
+		case f.size != stat.Size() || !f.modTime.Equal(stat.ModTime()):
+			*f = watchFile{size: stat.Size(), modTime: stat.ModTime(), changed: now}
+
+		case !f.hashed && now.Sub(f.changed) >= w.opts.Settle:
+			changed = w.hash(file, f, now) || changed
+		}
+
+		return nil
+	})
+
+	for file := range w.files {
+		if seen[file] {
+			continue
+		}
+
+		delete(w.files, file)
+
+		if _, ok := w.x.Hash(file); ok {
+			w.x.Remove(file)
+			w.emit(WatchEvent{Op: WatchRemove, Path: file})
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// hash hashes a settled file and adds it to the index. It returns true
+// if it did.
+func (w *watcher) hash(file string, f *watchFile, now time.Time) bool {
+	hash, err := ComputeFile(file, w.hf)
+	if err != nil {
+		f.attempts++
+		retry := f.attempts <= w.opts.Retries
+
+		// Wait for the file to settle again before the next attempt.
+		f.hashed, f.changed = !retry, now
+		w.emit(WatchEvent{Op: WatchError, Path: file, Err: err, Retry: retry})
+		return false
+	}
+
+	f.hashed = true
+
+	var matches Hits
+	for _, h := range w.x.Search(hash, w.opts.Distance) {
+		if h.ID != file {
+			matches = append(matches, h)
+		}
+	}
+
+	w.x.Add(file, hash)
+	w.emit(WatchEvent{Op: WatchAdd, Path: file, Hash: hash, Matches: matches})
+	return true
+}
+
+// emit calls OnEvent, if set.
+func (w *watcher) emit(e WatchEvent) {
+	if w.opts.OnEvent != nil {
+		w.opts.OnEvent(e)
+	}
+}
@@ -0,0 +1,149 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package imghash
+
+import (
+	"context"
+	"github.com/jteeuwen/imghash/synth"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	a, broken := filepath.Join(dir, "a.png"), filepath.Join(dir, "broken.png")
+	writeTestPNG(t, a, synth.Gradient(64, 48, 1))
+	os.WriteFile(broken, []byte("not an image"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("skipped"), 0644)
+
+	var events []WatchEvent
+	x := NewIndex()
+	w := &watcher{x: x, hf: Average, files: make(map[string]*watchFile), opts: &WatchOptions{
+		Settle:  time.Second,
+		Retries: 1,
+		Accept:  HasExtension,
+		OnEvent: func(e WatchEvent) { events = append(events, e) },
+	}}
+
+	t0 := time.Now()
+	scan := func(after time.Duration, changed bool) {
+		t.Helper()
+		if c := w.scan(dir, t0.Add(after)); c != changed {
+			t.Fatalf("scan at %v: changed %v", after, c)
+		}
+	}
+
+	// Nothing is hashed before it settles.
+	scan(0, false)
+	scan(500*time.Millisecond, false)
+	if len(events) != 0 {
+		t.Fatalf("unsettled files hashed: %+v", events)
+	}
+
+	scan(time.Second, true)
+	if len(events) != 2 || x.Len() != 1 {
+		t.Fatalf("events %+v", events)
+	}
+
+	for _, e := range events {
+		if e.Path == broken && (e.Op != WatchError || !e.Retry) || e.Path == a && e.Op != WatchAdd {
+			t.Fatalf("event %+v", e)
+		}
+	}
+
+	// A copy is reported as a match of the original.
+	events = nil
+	b := filepath.Join(dir, "b.png")
+	writeTestPNG(t, b, synth.Gradient(64, 48, 1))
+	scan(1500*time.Millisecond, false)
+	scan(2500*time.Millisecond, true)
+
+	if len(events) != 2 {
+		t.Fatalf("events %+v", events)
+	}
+
+	for _, e := range events {
+		switch e.Path {
+		case b:
+			if e.Op != WatchAdd || len(e.Matches) != 1 || e.Matches[0].ID != a {
+				t.Fatalf("copy: %+v", e)
+			}
+		case broken:
+			if e.Op != WatchError || e.Retry {
+				t.Fatalf("retried file: %+v", e)
+			}
+		}
+	}
+
+	// Files given up on are left alone until they change.
+	events = nil
+	scan(10*time.Second, false)
+	if len(events) != 0 {
+		t.Fatalf("events %+v", events)
+	}
+
+	os.Remove(a)
+	scan(11*time.Second, true)
+	if len(events) != 1 || events[0].Op != WatchRemove || events[0].Path != a {
+		t.Fatalf("removal: %+v", events)
+	}
+
+	if _, ok := x.Hash(a); ok || x.Len() != 1 {
+		t.Fatal("removed file still indexed")
+	}
+
+	// Watch runs the scans until cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	var scans int
+	err := Watch(ctx, dir, NewIndex(), Average, &WatchOptions{
+		Interval:  time.Millisecond,
+		AfterScan: func() { scans++; cancel() },
+	})
+
+	if err != context.Canceled || scans != 1 {
+		t.Fatalf("watch: %v, %d scans", err, scans)
+	}
+
+	if err := Watch(ctx, filepath.Join(dir, "missing"), x, Average, nil); !os.IsNotExist(err) {
+		t.Fatalf("missing root: %v", err)
+	}
+
+	if s := WatchOp(9).String(); s != "WatchOp(9)" {
+		t.Fatalf("unknown op %q", s)
+	}
+}
+
+func TestWatchNotify(t *testing.T) {
+	dir := t.TempDir()
+	notify := make(chan struct{}, 1)
+
+	// The interval is too long to matter; the notification alone gets
+	// the file hashed, once it has settled.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var events []WatchEvent
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, dir, NewIndex(), Average, &WatchOptions{
+			Interval: time.Hour,
+			Settle:   50 * time.Millisecond,
+			Notify:   notify,
+			OnEvent:  func(e WatchEvent) { events = append(events, e); cancel() },
+		})
+	}()
+
+	writeTestPNG(t, filepath.Join(dir, "a.png"), synth.Gradient(64, 48, 1))
+	notify <- struct{}{}
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("watch: %v", err)
+	}
+
+	if len(events) != 1 || events[0].Op != WatchAdd {
+		t.Fatalf("events %+v", events)
+	}
+}
@@ -0,0 +1,17 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package ximage registers WebP, TIFF and BMP decoders from golang.org/x/image
+with imghash. Import it for its side effects:
+
+	import _ "github.com/jteeuwen/imghash/ximage"
+
+The decoders are only included when building with the ximage tag, so
+the main package does not depend on golang.org/x/image:
+
+	go build -tags ximage
+
+Without the tag, importing this package has no effect.
+*/
+package ximage
@@ -0,0 +1,46 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+//go:build ximage
+
+package ximage
+
+import (
+	"github.com/jteeuwen/imghash"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+func init() {
+	imghash.RegisterDecoder(&imghash.Decoder{
+		Name:         "webp",
+		Magic:        "RIFF????WEBP",
+		Extensions:   []string{".webp"},
+		Decode:       webp.Decode,
+		DecodeConfig: webp.DecodeConfig,
+	})
+
+	imghash.RegisterDecoder(&imghash.Decoder{
+		Name:         "tiff",
+		Magic:        "II*\x00",
+		Extensions:   []string{".tif", ".tiff"},
+		Decode:       tiff.Decode,
+		DecodeConfig: tiff.DecodeConfig,
+	})
+
+	imghash.RegisterDecoder(&imghash.Decoder{
+		Name:         "tiff",
+		Magic:        "MM\x00*",
+		Decode:       tiff.Decode,
+		DecodeConfig: tiff.DecodeConfig,
+	})
+
+	imghash.RegisterDecoder(&imghash.Decoder{
+		Name:         "bmp",
+		Magic:        "BM",
+		Extensions:   []string{".bmp"},
+		Decode:       bmp.Decode,
+		DecodeConfig: bmp.DecodeConfig,
+	})
+}